@@ -0,0 +1,301 @@
+package main
+
+import (
+  "bufio"
+  "encoding/csv"
+  "encoding/json"
+  "fmt"
+  "io"
+  "net/http"
+  "os"
+  "strings"
+  "sync"
+  "time"
+)
+
+// where to find out who is away, configured under `absences`. every source
+// given is read and the periods merged. auto-assign skips anyone who is out
+//
+//   absences:
+//     csv: ./absences.csv            # user,start,end with yyyy-mm-dd dates
+//     ical: https://calendar.google.com/calendar/ical/.../basic.ics
+//     bamboohr:
+//       company: acme
+//       api_key: ...
+//     users: {"Alice Smith": alice}  # names in the sources -> jira users
+//     refresh: 1h
+type AbsenceConfig struct {
+  Csv      string            `yaml:"csv"`
+  Ical     string            `yaml:"ical"`
+  BambooHR BambooHRConfig    `yaml:"bamboohr"`
+  Users    map[string]string `yaml:"users"`
+  Refresh  string            `yaml:"refresh"`
+}
+
+type BambooHRConfig struct {
+  Company string `yaml:"company"`
+  ApiKey  string `yaml:"api_key"`
+}
+
+type absencePeriod struct {
+  start, end time.Time // end is exclusive
+}
+
+// who is out when, loaded at startup in main and refreshed in the background
+var absences = &absenceList{}
+
+type absenceList struct {
+  mu      sync.RWMutex
+  config  AbsenceConfig
+  periods map[string][]absencePeriod
+}
+
+const absenceLookahead = 30 * 24 * time.Hour
+
+func loadAbsences(config AbsenceConfig) *absenceList {
+  a := &absenceList{config: config}
+  if len(config.Csv) == 0 && len(config.Ical) == 0 && len(config.BambooHR.Company) == 0 {
+    return a
+  }
+  a.refresh()
+  go func() {
+    for {
+      time.Sleep(durationOr(config.Refresh, time.Hour))
+      a.refresh()
+    }
+  }()
+  return a
+}
+
+// Out is whether a jira user is away at t
+func (a *absenceList) Out(user string, t time.Time) bool {
+  a.mu.RLock()
+  defer a.mu.RUnlock()
+
+  for _, p := range a.periods[strings.ToLower(user)] {
+    if !t.Before(p.start) && t.Before(p.end) {
+      return true
+    }
+  }
+  return false
+}
+
+// reread every source. if one fails the periods we had before are kept,
+// rather than treating everyone as in
+func (a *absenceList) refresh() {
+  periods := map[string][]absencePeriod{}
+  add := func(name string, start, end time.Time) {
+    user := name
+    if mapped, ok := a.config.Users[name]; ok {
+      user = mapped
+    }
+    user = strings.ToLower(strings.TrimSpace(user))
+    periods[user] = append(periods[user], absencePeriod{start, end})
+  }
+
+  failed := false
+  if len(a.config.Csv) > 0 {
+    if err := readAbsenceCsv(a.config.Csv, add); err != nil {
+      logger.Print("Error reading absences from ", a.config.Csv, ": ", err)
+      failed = true
+    }
+  }
+  if len(a.config.Ical) > 0 {
+    if err := readAbsenceIcal(a.config.Ical, a.config.Users, add); err != nil {
+      logger.Print("Error reading absences from the calendar: ", err)
+      failed = true
+    }
+  }
+  if len(a.config.BambooHR.Company) > 0 {
+    if err := readAbsenceBambooHR(a.config.BambooHR, add); err != nil {
+      logger.Print("Error reading absences from BambooHR: ", err)
+      failed = true
+    }
+  }
+
+  a.mu.Lock()
+  defer a.mu.Unlock()
+  if failed && a.periods != nil {
+    for user, old := range a.periods {
+      if _, ok := periods[user]; !ok {
+        periods[user] = old
+      }
+    }
+  }
+  a.periods = periods
+}
+
+// a day's date, or the end of the day for an inclusive end date
+func parseAbsenceDate(s string, end bool) (time.Time, error) {
+  t, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(s), time.Local)
+  if err != nil {
+    return t, err
+  }
+  if end {
+    t = t.AddDate(0, 0, 1)
+  }
+  return t, nil
+}
+
+func readAbsenceCsv(path string, add func(string, time.Time, time.Time)) error {
+  file, err := os.Open(path)
+  if err != nil {
+    return err
+  }
+  defer file.Close()
+
+  r := csv.NewReader(file)
+  r.FieldsPerRecord = 3
+  r.Comment = '#'
+  for {
+    row, err := r.Read()
+    if err == io.EOF {
+      return nil
+    } else if err != nil {
+      return err
+    }
+    start, err := parseAbsenceDate(row[1], false)
+    if err != nil {
+      continue // e.g. a header row
+    }
+    end, err := parseAbsenceDate(row[2], true)
+    if err != nil {
+      return fmt.Errorf("invalid end date %q for %s", row[2], row[0])
+    }
+    add(row[0], start, end)
+  }
+}
+
+func absenceGet(req *http.Request) (io.ReadCloser, error) {
+  resp, err := http.DefaultClient.Do(req)
+  if err != nil {
+    return nil, err
+  }
+  if resp.StatusCode >= 300 {
+    drainAndClose(resp.Body)
+    return nil, fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+  }
+  return resp.Body, nil
+}
+
+// an ical feed, e.g. the secret address of a shared google calendar. an
+// event is for whichever configured user's name is in its summary, or
+// the summary itself
+func readAbsenceIcal(url string, users map[string]string, add func(string, time.Time, time.Time)) error {
+  req, err := http.NewRequest("GET", url, nil)
+  if err != nil {
+    return err
+  }
+  body, err := absenceGet(req)
+  if err != nil {
+    return err
+  }
+  defer body.Close()
+
+  // unfold continuation lines first
+  lines := []string{}
+  scanner := bufio.NewScanner(body)
+  for scanner.Scan() {
+    line := strings.TrimRight(scanner.Text(), "\r")
+    if strings.HasPrefix(line, " ") && len(lines) > 0 {
+      lines[len(lines)-1] += line[1:]
+    } else {
+      lines = append(lines, line)
+    }
+  }
+  if err := scanner.Err(); err != nil {
+    return err
+  }
+
+  var summary string
+  var start, end time.Time
+  for _, line := range lines {
+    i := strings.Index(line, ":")
+    if i < 0 {
+      continue
+    }
+    name, value := line[:i], line[i+1:]
+    if j := strings.Index(name, ";"); j >= 0 {
+      name = name[:j]
+    }
+    switch name {
+    case "BEGIN":
+      summary, start, end = "", time.Time{}, time.Time{}
+    case "SUMMARY":
+      summary = value
+    case "DTSTART":
+      start = parseIcalTime(value)
+    case "DTEND":
+      end = parseIcalTime(value)
+    case "END":
+      if value != "VEVENT" || start.IsZero() || len(summary) == 0 {
+        continue
+      }
+      if end.IsZero() {
+        end = start.AddDate(0, 0, 1)
+      }
+      who := summary
+      for source := range users {
+        if strings.Contains(strings.ToLower(summary), strings.ToLower(source)) {
+          who = source
+        }
+      }
+      add(who, start, end)
+    }
+  }
+  return nil
+}
+
+func parseIcalTime(value string) time.Time {
+  if t, err := time.Parse("20060102T150405Z", value); err == nil {
+    return t
+  }
+  if t, err := time.ParseInLocation("20060102T150405", value, time.Local); err == nil {
+    return t
+  }
+  t, _ := time.ParseInLocation("20060102", value, time.Local)
+  return t
+}
+
+// bamboohr's "who's out" for the next month, matched by employee name
+func readAbsenceBambooHR(config BambooHRConfig, add func(string, time.Time, time.Time)) error {
+  now := time.Now()
+  url := fmt.Sprintf("https://api.bamboohr.com/api/gateway.php/%s/v1/time_off/whos_out/?start=%s&end=%s",
+    config.Company, now.Format("2006-01-02"), now.Add(absenceLookahead).Format("2006-01-02"))
+  req, err := http.NewRequest("GET", url, nil)
+  if err != nil {
+    return err
+  }
+  req.SetBasicAuth(config.ApiKey, "x")
+  req.Header.Set("Accept", "application/json")
+  body, err := absenceGet(req)
+  if err != nil {
+    return err
+  }
+  defer body.Close()
+
+  var out []struct {
+    Type  string `json:"type"`
+    Name  string `json:"name"`
+    Start string `json:"start"`
+    End   string `json:"end"`
+  }
+  if err := json.NewDecoder(body).Decode(&out); err != nil {
+    return err
+  }
+  for _, o := range out {
+    if o.Type != "timeOff" {
+      continue // holidays are in the calendars
+    }
+    start, err := parseAbsenceDate(o.Start, false)
+    if err != nil {
+      continue
+    }
+    end, err := parseAbsenceDate(o.End, true)
+    if err != nil {
+      continue
+    }
+    add(o.Name, start, end)
+  }
+  return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+// write actions can use other jira accounts than the one that polls, so
+// what the tracker changes is attributed to a bot with only the rights it
+// needs. `write` is for every kind of write, and each action can have its
+// own instead
+//
+//   accounts:
+//     write:                  # comments, transitions, assigning, edits and new issues
+//       login: tracker-bot
+//       password: ...
+//     transition:             # just transitions
+//       login: tracker-workflow
+//       password: ...
+//
+// the actions are comment, transition, assign, edit (fields, labels,
+// priorities and security levels) and create
+type AccountsConfig struct {
+  Write      JiraAccount `yaml:"write"`
+  Comment    JiraAccount `yaml:"comment"`
+  Transition JiraAccount `yaml:"transition"`
+  Assign     JiraAccount `yaml:"assign"`
+  Edit       JiraAccount `yaml:"edit"`
+  Create     JiraAccount `yaml:"create"`
+}
+
+type JiraAccount struct {
+  Login    string `yaml:"login"`
+  Password string `yaml:"password"`
+}
+
+var writeActions = []string{"comment", "transition", "assign", "edit", "create"}
+
+// the credentials to take an action with, the polling account's if the
+// action has no account of its own
+func (c *Config) accountFor(action string) *Config {
+  account := map[string]JiraAccount{
+    "comment":    c.Accounts.Comment,
+    "transition": c.Accounts.Transition,
+    "assign":     c.Accounts.Assign,
+    "edit":       c.Accounts.Edit,
+    "create":     c.Accounts.Create,
+  }[action]
+  if len(account.Login) == 0 {
+    account = c.Accounts.Write
+  }
+  if len(account.Login) == 0 {
+    return c
+  }
+  copied := *c
+  copied.Login, copied.Password = account.Login, account.Password
+  return &copied
+}
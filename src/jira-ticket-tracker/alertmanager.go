@@ -0,0 +1,220 @@
+package main
+
+import (
+  "crypto/hmac"
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "sort"
+  "strings"
+  "time"
+)
+
+// a receiver for prometheus alertmanager, configured under `alertmanager`.
+// alertmanager's webhook is pointed at /alertmanager on the control api. a
+// firing alert opens an issue, once per fingerprint while it stays open,
+// which the targets are subscribed to. when the alert resolves the issue is
+// commented on and, with a transition, resolved too
+//
+//   alertmanager:
+//     project: OPS
+//     issuetype: Incident
+//     transition: Done     # optional, to resolve the issue with the alert
+//     token: ...           # alertmanager's http_config bearer token
+//     targets: [ops-slack]
+type AlertmanagerConfig struct {
+  Project    string   `yaml:"project"`
+  IssueType  string   `yaml:"issuetype"`
+  Transition string   `yaml:"transition"`
+  Token      string   `yaml:"token"`
+  Targets    []string `yaml:"targets"`
+}
+
+type alertmanagerPayload struct {
+  Alerts []struct {
+    Status       string            `json:"status"` // firing or resolved
+    Labels       map[string]string `json:"labels"`
+    Annotations  map[string]string `json:"annotations"`
+    StartsAt     time.Time         `json:"startsAt"`
+    EndsAt       time.Time         `json:"endsAt"`
+    GeneratorURL string            `json:"generatorURL"`
+    Fingerprint  string            `json:"fingerprint"`
+  } `json:"alerts"`
+}
+
+var alertmanager = &alertReceiver{}
+
+type alertReceiver struct {
+  config AlertmanagerConfig
+  creds  *Config
+  events chan []*Event
+}
+
+func loadAlertmanager(creds *Config, c chan []*Event) {
+  alertmanager = &alertReceiver{config: creds.Alertmanager, creds: creds, events: c}
+  if len(alertmanager.config.IssueType) == 0 {
+    alertmanager.config.IssueType = "Task"
+  }
+}
+
+// how long a resolution already commented on is remembered for, so a retried
+// or repeated webhook doesn't comment again
+const alertResolvedTTL = 7 * 24 * time.Hour
+
+// the label an alert's issues get, so they can be found again if the state
+// is lost
+func alertLabel(fingerprint string) string {
+  return "alert-" + fingerprint
+}
+
+// the open issue for an alert, from the state or failing that by its label
+func (a *alertReceiver) openIssue(fingerprint string) (string, error) {
+  if key := state.AlertIssue(fingerprint); len(key) > 0 {
+    contents := jiraIssue(key, a.creds)
+    if contents == nil {
+      return "", fmt.Errorf("couldn't fetch %s", key)
+    }
+    _, fields, err := parseIssue(contents)
+    if err != nil {
+      return "", err
+    }
+    if fieldValue(fields, "resolution") == nil {
+      return key, nil
+    }
+    state.ForgetAlert(fingerprint) // resolved by hand, open a new one
+    return "", nil
+  }
+
+  jql := fmt.Sprintf("labels = %s AND resolution is EMPTY", jqlQuote(alertLabel(fingerprint)))
+  contents := jiraSearch(jql, 0, 1, a.creds)
+  if contents == nil {
+    return "", fmt.Errorf("search for the alert's issue failed")
+  }
+  var result struct {
+    Issues []struct {
+      Key string `json:"key"`
+    } `json:"issues"`
+  }
+  if err := json.Unmarshal(contents, &result); err != nil {
+    return "", err
+  }
+  if len(result.Issues) == 0 {
+    return "", nil
+  }
+  state.SetAlertIssue(fingerprint, result.Issues[0].Key)
+  return result.Issues[0].Key, nil
+}
+
+func alertDescription(labels, annotations map[string]string, generator string) string {
+  lines := []string{}
+  if d := annotations["description"]; len(d) > 0 {
+    lines = append(lines, d, "")
+  }
+  names := []string{}
+  for name := range labels {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  for _, name := range names {
+    lines = append(lines, fmt.Sprintf("* %s: %s", name, labels[name]))
+  }
+  if len(generator) > 0 {
+    lines = append(lines, "", generator)
+  }
+  return strings.Join(lines, "\n")
+}
+
+func (a *alertReceiver) receive(payload *alertmanagerPayload) error {
+  for _, alert := range payload.Alerts {
+    if len(alert.Fingerprint) == 0 {
+      continue
+    }
+    key, err := a.openIssue(alert.Fingerprint)
+    if err != nil {
+      return err
+    }
+
+    if alert.Status == "resolved" {
+      if len(key) == 0 {
+        continue
+      }
+      // the same resolution comes back when alertmanager retries, or when
+      // the issue stays open without a transition, and is commented on once.
+      // a resolution after the alert fires again has a new end
+      resolution := alert.Fingerprint + "@" + alert.EndsAt.UTC().Format(time.RFC3339)
+      seen, err := store.MarkSeen("alert-resolved", resolution, alertResolvedTTL)
+      if err != nil {
+        return err
+      }
+      if !seen {
+        comment := "The alert resolved at " + alert.EndsAt.Format(time.RFC1123)
+        if err := addComment(key, comment, a.creds); err != nil {
+          if err := store.ForgetSeen("alert-resolved", resolution); err != nil {
+            logger.Print("Error forgetting the resolution of ", key, ": ", err)
+          }
+          return err
+        }
+      }
+      if len(a.config.Transition) > 0 {
+        if err := transitionIssue(key, a.config.Transition, a.creds); err != nil {
+          return err
+        }
+      }
+      state.ForgetAlert(alert.Fingerprint)
+      logger.Print("Alert ", alert.Labels["alertname"], " for ", key, " resolved")
+      continue
+    }
+
+    if len(key) > 0 {
+      continue // already open, alertmanager repeats firing alerts
+    }
+    summary := alert.Annotations["summary"]
+    if len(summary) == 0 {
+      summary = alert.Labels["alertname"]
+    }
+    key, err = createIssue(map[string]interface{}{
+      "project":     map[string]string{"key": a.config.Project},
+      "issuetype":   map[string]string{"name": a.config.IssueType},
+      "summary":     summary,
+      "description": alertDescription(alert.Labels, alert.Annotations, alert.GeneratorURL),
+      "labels":      []string{alertLabel(alert.Fingerprint)},
+    }, a.creds)
+    if err != nil {
+      return err
+    }
+    state.SetAlertIssue(alert.Fingerprint, key)
+    logger.Print("Created ", key, " for alert ", alert.Labels["alertname"])
+    followNewIssue(key, a.config.Targets, "for the alert "+alert.Labels["alertname"], a.creds, a.events)
+  }
+  return nil
+}
+
+//   POST /alertmanager   an alertmanager webhook
+func handleAlertmanager(w http.ResponseWriter, r *http.Request) {
+  if r.Method != "POST" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+  if len(alertmanager.config.Project) == 0 {
+    writeError(w, http.StatusNotFound, "the alertmanager receiver isn't configured")
+    return
+  }
+  if token := alertmanager.config.Token; len(token) > 0 {
+    if !hmac.Equal([]byte("Bearer "+token), []byte(r.Header.Get("Authorization"))) {
+      writeError(w, http.StatusUnauthorized, "invalid token")
+      return
+    }
+  }
+  var payload alertmanagerPayload
+  if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+    writeError(w, http.StatusBadRequest, "invalid json: "+err.Error())
+    return
+  }
+  // a failure makes alertmanager retry, which is safe as we dedupe
+  if err := alertmanager.receive(&payload); err != nil {
+    logger.Print("Error handling alerts: ", err)
+    writeError(w, http.StatusBadGateway, err.Error())
+    return
+  }
+  writeJSON(w, http.StatusOK, map[string]int{"alerts": len(payload.Alerts)})
+}
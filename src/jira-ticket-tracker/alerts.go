@@ -0,0 +1,44 @@
+package main
+
+import (
+  "github.com/plouc/go-jira-client"
+)
+
+const eventOperatorAlert = "operator-alert"
+
+// the key of the placeholder issue on events about the tracker itself
+const trackerKey = "tracker"
+
+// alerts about the tracker itself go to the targets under `operator`. its
+// token is the bearer token the control api wants for what only the
+// operator does, applying the plan, replaying and pausing
+//
+//   operator:
+//     targets: [ops-slack]
+//     token: ...
+//
+// and, optionally, a weekly digest on how reliably the rules poll, see
+// FlapConfig
+type OperatorConfig struct {
+  Targets     []string   `yaml:"targets"`
+  Token       string     `yaml:"token"`
+  Digest      bool       `yaml:"digest"`
+  ErrorBudget string     `yaml:"error_budget"`
+  Flapping    FlapConfig `yaml:"flapping"`
+}
+
+// operator alerts aren't about an issue, so they are sent as an event with
+// a placeholder issue to let every notifier take them. they are always
+// logged, even with no targets configured
+func alertOperator(creds *Config, message string) {
+  logger.Print("ALERT: ", message)
+  event := trackerEvent(eventOperatorAlert, message)
+  event.Targets = creds.Operator.Targets
+  deliver([]*Event{event})
+}
+
+// an event about the tracker rather than an issue, e.g. an alert or report
+func trackerEvent(kind, summary string) *Event {
+  issue := &gojira.Issue{Key: trackerKey, Fields: &gojira.IssueFields{Summary: summary}}
+  return newEvent(kind, issue, map[string]interface{}{})
+}
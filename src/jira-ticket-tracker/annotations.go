@@ -0,0 +1,178 @@
+package main
+
+import (
+  "encoding/json"
+  "flag"
+  "fmt"
+  "net/http"
+  "os"
+  "sort"
+  "strings"
+  "time"
+)
+
+// local notes and tags on an issue, for triage that doesn't belong in jira.
+// they are kept in the state file, added to events (and so to templates,
+// webhooks and csv exports) and managed with the annotate commands
+type Annotation struct {
+  Notes []Note   `json:"notes,omitempty"`
+  Tags  []string `json:"tags,omitempty"`
+}
+
+type Note struct {
+  Text   string    `json:"text"`
+  Author string    `json:"author,omitempty"`
+  At     time.Time `json:"at"`
+}
+
+func (a *Annotation) copy() *Annotation {
+  return &Annotation{
+    Notes: append([]Note{}, a.Notes...),
+    Tags:  append([]string{}, a.Tags...),
+  }
+}
+
+// the tags of a possibly nil annotation
+func (a *Annotation) tags() []string {
+  if a == nil {
+    return nil
+  }
+  return a.Tags
+}
+
+func init() {
+  commands["annotate"] = command{"annotate [--tag=TAG,..] [--author=NAME] KEY [NOTE...]", annotateCommand}
+  commands["unannotate"] = command{"unannotate [--tag=TAG,..] KEY", unannotateCommand}
+  commands["annotations"] = command{"annotations [KEY]", annotationsCommand}
+}
+
+type annotationRequest struct {
+  Key    string   `json:"key"`
+  Note   string   `json:"note,omitempty"`
+  Author string   `json:"author,omitempty"`
+  Tags   []string `json:"tags,omitempty"`
+}
+
+//   GET    /annotations[?key=KEY]                    list the annotations
+//   POST   /annotations {"key":..,"note":..,"tags":[..]} add a note and/or tags
+//   DELETE /annotations {"key":..,"tags":[..]}        remove tags, or everything
+func handleAnnotations(w http.ResponseWriter, r *http.Request) {
+  if r.Method == "GET" {
+    if key := strings.ToUpper(r.URL.Query().Get("key")); len(key) > 0 {
+      a := state.Annotation(key)
+      if a == nil {
+        writeError(w, http.StatusNotFound, key+" has no annotations")
+        return
+      }
+      writeJSON(w, http.StatusOK, map[string]*Annotation{key: a})
+      return
+    }
+    writeJSON(w, http.StatusOK, state.AllAnnotations())
+    return
+  }
+  if r.Method != "POST" && r.Method != "DELETE" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+
+  var req annotationRequest
+  if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+    writeError(w, http.StatusBadRequest, "invalid json: "+err.Error())
+    return
+  }
+  req.Key = strings.ToUpper(strings.TrimSpace(req.Key))
+  if len(req.Key) == 0 {
+    writeError(w, http.StatusBadRequest, "key is required")
+    return
+  }
+
+  if r.Method == "DELETE" {
+    if !state.Unannotate(req.Key, req.Tags) {
+      writeError(w, http.StatusNotFound, req.Key+" has no annotations")
+      return
+    }
+    writeJSON(w, http.StatusOK, req)
+    return
+  }
+
+  if len(req.Note) == 0 && len(req.Tags) == 0 {
+    writeError(w, http.StatusBadRequest, "a note or tags are required")
+    return
+  }
+  var note *Note
+  if len(req.Note) > 0 {
+    note = &Note{Text: req.Note, Author: req.Author, At: time.Now()}
+  }
+  logger.Print("Annotated ", req.Key)
+  writeJSON(w, http.StatusOK, state.Annotate(req.Key, note, req.Tags))
+}
+
+func splitTags(s string) []string {
+  tags := []string{}
+  for _, tag := range strings.Split(s, ",") {
+    if tag = strings.TrimSpace(tag); len(tag) > 0 {
+      tags = append(tags, tag)
+    }
+  }
+  return tags
+}
+
+func annotateCommand(args []string) {
+  flags := flag.NewFlagSet("annotate", flag.ExitOnError)
+  tags := flags.String("tag", "", "Comma separated tags to add")
+  author := flags.String("author", os.Getenv("USER"), "Who the note is from")
+  flags.Parse(args)
+  if flags.NArg() < 1 {
+    usageExit(commands["annotate"].usage)
+  }
+
+  req := annotationRequest{
+    Key:    flags.Arg(0),
+    Note:   strings.Join(flags.Args()[1:], " "),
+    Author: *author,
+    Tags:   splitTags(*tags),
+  }
+  if err := callAPI("POST", "/annotations", req, nil); err != nil {
+    logger.Print("Error annotating: ", err)
+    os.Exit(1)
+  }
+}
+
+func unannotateCommand(args []string) {
+  flags := flag.NewFlagSet("unannotate", flag.ExitOnError)
+  tags := flags.String("tag", "", "Comma separated tags to remove, everything if not given")
+  flags.Parse(args)
+  if flags.NArg() != 1 {
+    usageExit(commands["unannotate"].usage)
+  }
+
+  req := annotationRequest{Key: flags.Arg(0), Tags: splitTags(*tags)}
+  if err := callAPI("DELETE", "/annotations", req, nil); err != nil {
+    logger.Print("Error removing annotations: ", err)
+    os.Exit(1)
+  }
+}
+
+func annotationsCommand(args []string) {
+  path := "/annotations"
+  if len(args) > 0 {
+    path += "?key=" + args[0]
+  }
+  var annotations map[string]*Annotation
+  if err := callAPI("GET", path, nil, &annotations); err != nil {
+    logger.Print("Error listing annotations: ", err)
+    os.Exit(1)
+  }
+  keys := []string{}
+  for key := range annotations {
+    keys = append(keys, key)
+  }
+  sort.Strings(keys)
+  for _, key := range keys {
+    a := annotations[key]
+    fmt.Printf("%s\t%s\n", key, strings.Join(a.Tags, ", "))
+    for _, note := range a.Notes {
+      fmt.Printf("  %s %s: %s\n", note.At.Format("2006-01-02 15:04"), note.Author, note.Text)
+    }
+  }
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+  "fmt"
+  "strings"
+  "sync"
+  "time"
+)
+
+const (
+  eventAnomaly        = "anomaly"
+  eventAnomalyCleared = "anomaly-cleared"
+)
+
+// alerts when a project suddenly gets far more issues than usual, often the
+// first sign of an outage, configured under `anomalies`. every interval the
+// issues created in the last window are counted and compared with the
+// average window over the baseline before it
+//
+//   anomalies:
+//     projects: [OPS, PAY]
+//     window: 10m
+//     baseline: 7d
+//     factor: 5         # times the usual rate to alert at
+//     min_issues: 5     # so 0 -> 2 issues isn't an anomaly
+//     interval: 2m
+//     targets: [ops-pager] # the operator's by default
+type AnomalyConfig struct {
+  Projects  []string `yaml:"projects"`
+  Window    string   `yaml:"window"`
+  Baseline  string   `yaml:"baseline"`
+  Factor    float64  `yaml:"factor"`
+  MinIssues int      `yaml:"min_issues"`
+  Interval  string   `yaml:"interval"`
+  Targets   []string `yaml:"targets"`
+}
+
+// a project's latest creation rate against its baseline
+type creationRate struct {
+  recent  int     // issues in the last window
+  usual   float64 // issues in the average window of the baseline
+  anomaly bool
+}
+
+var anomalies = struct {
+  sync.Mutex
+  config   AnomalyConfig
+  window   time.Duration
+  baseline time.Duration
+  interval time.Duration
+  rates    map[string]*creationRate
+}{rates: map[string]*creationRate{}}
+
+func loadAnomalies(creds *Config) {
+  config := creds.Anomalies
+  if config.Factor <= 0 {
+    config.Factor = 5
+  }
+  if config.MinIssues <= 0 {
+    config.MinIssues = 5
+  }
+  anomalies.config = config
+  anomalies.window = durationOr(config.Window, 10*time.Minute)
+  anomalies.baseline = durationOr(config.Baseline, 7*24*time.Hour)
+  anomalies.interval = durationOr(config.Interval, anomalies.window/5)
+  for _, project := range config.Projects {
+    anomalies.rates[strings.ToUpper(project)] = &creationRate{}
+  }
+}
+
+func (c *AnomalyConfig) enabled() bool {
+  return len(c.Projects) > 0
+}
+
+func (r *creationRate) ratio() float64 {
+  if r.usual == 0 {
+    return float64(r.recent)
+  }
+  return float64(r.recent) / r.usual
+}
+
+// count the project's recent and usual issues, alerting as it starts or
+// stops being anomalous
+func checkCreationRate(project string, creds *Config) {
+  window, baseline := anomalies.window, anomalies.baseline
+  recent, err := countIssues(fmt.Sprintf("project = %s AND created >= -%dm", project, int(window.Minutes())), creds)
+  if err != nil {
+    logger.Print("Error counting the new issues in ", project, ": ", err)
+    return
+  }
+  before, err := countIssues(fmt.Sprintf("project = %s AND created >= -%dm AND created < -%dm",
+    project, int((baseline + window).Minutes()), int(window.Minutes())), creds)
+  if err != nil {
+    logger.Print("Error counting the baseline issues in ", project, ": ", err)
+    return
+  }
+
+  config := anomalies.config
+  anomalies.Lock()
+  rate := anomalies.rates[project]
+  rate.recent, rate.usual = recent, float64(before)/(float64(baseline)/float64(window))
+  anomalous := recent >= config.MinIssues && rate.ratio() >= config.Factor
+  started, stopped := anomalous && !rate.anomaly, !anomalous && rate.anomaly
+  rate.anomaly = anomalous
+  ratio, usual := rate.ratio(), rate.usual
+  anomalies.Unlock()
+
+  kind, summary := "", ""
+  switch {
+  case started:
+    kind = eventAnomaly
+    summary = fmt.Sprintf("%s: %d issues created in the last %s, %.0fx the usual %.1f",
+      project, recent, window, ratio, usual)
+  case stopped:
+    kind = eventAnomalyCleared
+    summary = fmt.Sprintf("%s: back to %d issues in the last %s", project, recent, window)
+  default:
+    return
+  }
+  logger.Print("ANOMALY: ", summary)
+  event := trackerEvent(kind, summary)
+  event.Fields["project"] = map[string]interface{}{"key": project}
+  event.Targets = config.Targets
+  if len(event.Targets) == 0 {
+    event.Targets = creds.Operator.Targets
+  }
+  deliver([]*Event{event})
+  if started && creds.Incidents.OnAnomaly {
+    startIncident(project, summary)
+  }
+}
+
+func watchCreationRates(creds *Config) {
+  for {
+    for _, project := range anomalies.config.Projects {
+      if shards.leading() {
+        checkCreationRate(strings.ToUpper(project), creds)
+      }
+    }
+    time.Sleep(anomalies.interval)
+  }
+}
+
+func writeAnomalyMetrics(out *strings.Builder) {
+  if !anomalies.config.enabled() {
+    return
+  }
+  anomalies.Lock()
+  defer anomalies.Unlock()
+  out.WriteString("# HELP jira_tracker_creation_rate_ratio A project's issues in the last window against its usual.\n")
+  out.WriteString("# TYPE jira_tracker_creation_rate_ratio gauge\n")
+  for _, project := range anomalies.config.Projects {
+    project = strings.ToUpper(project)
+    fmt.Fprintf(out, "jira_tracker_creation_rate_ratio{project=%q} %.2f\n", project, anomalies.rates[project].ratio())
+  }
+}
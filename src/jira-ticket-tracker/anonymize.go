@@ -0,0 +1,106 @@
+package main
+
+import (
+  "crypto/sha256"
+  "encoding/hex"
+  "strings"
+
+  "github.com/plouc/go-jira-client"
+)
+
+// what an anonymizing FieldPolicy drops: the fields people write freely in,
+// where names, emails and customer details end up
+var freeTextFields = []string{"summary", "description", "comment", "environment", "annotation", "correlations"}
+
+// a user's stand-in, the same for the same user so they can still be
+// counted and grouped. the salt keeps it from being looked up by hashing
+// every username
+func anonymousUser(salt, user string) string {
+  if len(user) == 0 {
+    return ""
+  }
+  sum := sha256.Sum256([]byte(salt + "\x00" + user))
+  return "user-" + hex.EncodeToString(sum[:8])
+}
+
+func anonymizeUser(u *gojira.User, salt string) *gojira.User {
+  if u == nil {
+    return nil
+  }
+  name := anonymousUser(salt, u.Name)
+  return &gojira.User{Name: name, DisplayName: name, Active: u.Active}
+}
+
+// the account id or username of a user object, empty if it isn't one
+func jiraUserId(v map[string]interface{}) string {
+  if id := fieldString(v, "accountId"); len(id) > 0 {
+    return id
+  }
+  if _, ok := v["displayName"]; !ok {
+    return "" // e.g. a status or a component, which have names too
+  }
+  return fieldString(v, "name")
+}
+
+// the value with every user object in it, like the reporter or a
+// comment's author, replaced by its stand-in
+func anonymizeValue(value interface{}, salt string) interface{} {
+  switch v := value.(type) {
+  case map[string]interface{}:
+    if user := jiraUserId(v); len(user) > 0 {
+      name := anonymousUser(salt, user)
+      return map[string]interface{}{"name": name, "accountId": name, "displayName": name, "active": v["active"]}
+    }
+    copied := map[string]interface{}{}
+    for key, item := range v {
+      copied[key] = anonymizeValue(item, salt)
+    }
+    return copied
+  case []interface{}:
+    copied := make([]interface{}, len(v))
+    for i, item := range v {
+      copied[i] = anonymizeValue(item, salt)
+    }
+    return copied
+  }
+  return value
+}
+
+// whether a field's value is text someone may have written anything in, or
+// a list of such texts
+func freeText(value interface{}) bool {
+  switch v := value.(type) {
+  case string:
+    return true
+  case []interface{}:
+    for _, item := range v {
+      if _, ok := item.(string); ok {
+        return true
+      }
+    }
+  }
+  return false
+}
+
+// hash the people in an already redacted copy of an event. it fails
+// closed: text custom fields and computed values, which can hold anything,
+// are dropped unless the policy keeps them
+func (p *FieldPolicy) anonymize(event *Event) {
+  for name, value := range event.Fields {
+    if strings.HasPrefix(name, "customfield_") && freeText(value) && !contains(p.Keep, name) {
+      delete(event.Fields, name)
+      continue
+    }
+    event.Fields[name] = anonymizeValue(value, p.Salt)
+  }
+  if !contains(p.Keep, "computed") {
+    event.Computed = nil
+  }
+  if event.Issue != nil && event.Issue.Fields != nil {
+    event.Issue.Fields.Reporter = anonymizeUser(event.Issue.Fields.Reporter, p.Salt)
+    event.Issue.Fields.Assignee = anonymizeUser(event.Issue.Fields.Assignee, p.Salt)
+  }
+  if event.Kind != eventTransitioned {
+    event.Detail = "" // e.g. who it's orphaned from, but a transition's is only statuses
+  }
+}
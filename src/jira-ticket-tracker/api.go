@@ -0,0 +1,139 @@
+package main
+
+import (
+  "crypto/hmac"
+  "encoding/json"
+  "net"
+  "net/http"
+  "os"
+  "strings"
+)
+
+// the control API's server, so an upgrade can stop it. nil until it's served
+var apiServer *http.Server
+
+// the operator's token, see OperatorConfig
+var operatorToken string
+
+// whether the request carries the operator's token. without one configured
+// nobody is the operator, so what only they may do is refused
+func operatorAuthorized(r *http.Request) bool {
+  return len(operatorToken) > 0 && hmac.Equal([]byte("Bearer "+operatorToken), []byte(r.Header.Get("Authorization")))
+}
+
+// the control API's socket, handed over by the process being upgraded if
+// there is one, see upgrade.go
+func listenAPI(addr string) net.Listener {
+  if listener := inheritedListener(); listener != nil {
+    logger.Print("Took over the control API's socket on ", listener.Addr())
+    return listener
+  }
+  listener, err := net.Listen("tcp", addr)
+  if err != nil {
+    logger.Print("Error listening on ", addr, ": ", err)
+    os.Exit(1)
+  }
+  return listener
+}
+
+// the control API, served when --listen is given
+func serveAPI(listener net.Listener) {
+  mux := http.NewServeMux()
+  mux.HandleFunc("/subscriptions", handleSubscriptions)
+  mux.HandleFunc("/devices", handleDevices)
+  mux.HandleFunc("/dnd", handleDND)
+  mux.HandleFunc("/grep", handleGrep)
+  mux.HandleFunc("/poll", handlePoll)
+  mux.HandleFunc("/annotations", handleAnnotations)
+  mux.HandleFunc("/receipts", handleReceipts)
+  mux.HandleFunc("/receipts/", handleReceipts)
+  mux.HandleFunc("/slo", handleSLO)
+  mux.HandleFunc("/metrics", handleMetrics)
+  mux.HandleFunc("/status", handleStatus)
+  mux.HandleFunc("/sinks", handleSinks)
+  mux.HandleFunc("/sinks/", handleSinks)
+  mux.HandleFunc("/traces/", handleTraces)
+  mux.HandleFunc("/gitops", handleGitOps)
+  mux.HandleFunc("/fleet", handleFleet)
+  mux.HandleFunc("/fleet/", handleFleet)
+  mux.HandleFunc("/shards", handleShards)
+  mux.HandleFunc("/incidents", handleIncidents)
+  mux.HandleFunc("/pause", handlePause)
+  mux.HandleFunc("/events", handleEvents)
+  mux.HandleFunc("/replay", handleReplay)
+  mux.HandleFunc("/openapi.json", handleOpenAPI)
+  mux.HandleFunc("/schemas/", handleSchemas)
+  mux.HandleFunc("/slack/actions", handleSlackActions)
+  mux.HandleFunc("/wallboard", handleWallboard)
+  mux.HandleFunc("/wallboard/data", handleWallboard)
+  mux.HandleFunc("/feeds/", handleFeeds)
+  mux.HandleFunc("/ical/", handleICal)
+  mux.HandleFunc("/sentry", handleSentry)
+  mux.HandleFunc("/rollbar", handleRollbar)
+  mux.HandleFunc("/alertmanager", handleAlertmanager)
+  mux.HandleFunc("/webhooks/jira/", handleJiraWebhook)
+  mux.HandleFunc("/plan", handlePlan)
+  mux.HandleFunc("/approvals", handleApprovals)
+
+  logger.Print("Serving the control API on ", listener.Addr())
+  apiServer = &http.Server{Handler: mux}
+  if err := apiServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+    logger.Print("Error serving the control API: ", err)
+  }
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(status)
+  json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+  writeJSON(w, status, map[string]string{"error": message})
+}
+
+type subscription struct {
+  Key    string `json:"key"`
+  Target string `json:"target"`
+}
+
+//   GET    /subscriptions                       list every subscription
+//   POST   /subscriptions {"key":..,"target":..} subscribe a target to an issue
+//   DELETE /subscriptions {"key":..,"target":..} unsubscribe it again
+func handleSubscriptions(w http.ResponseWriter, r *http.Request) {
+  if r.Method == "GET" {
+    writeJSON(w, http.StatusOK, state.AllSubscriptions())
+    return
+  }
+  if r.Method != "POST" && r.Method != "DELETE" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+
+  var sub subscription
+  if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+    writeError(w, http.StatusBadRequest, "invalid json: "+err.Error())
+    return
+  }
+  sub.Key = strings.ToUpper(strings.TrimSpace(sub.Key))
+  if len(sub.Key) == 0 || len(sub.Target) == 0 {
+    writeError(w, http.StatusBadRequest, "key and target are required")
+    return
+  }
+
+  if r.Method == "POST" {
+    if _, ok := sinks[sub.Target]; !ok {
+      writeError(w, http.StatusBadRequest, "unknown target "+sub.Target)
+      return
+    }
+    if state.Subscribe(sub.Key, sub.Target) {
+      logger.Print("Subscribed ", sub.Target, " to ", sub.Key)
+    }
+  } else if !state.Unsubscribe(sub.Key, sub.Target) {
+    writeError(w, http.StatusNotFound, sub.Target+" is not subscribed to "+sub.Key)
+    return
+  } else {
+    logger.Print("Unsubscribed ", sub.Target, " from ", sub.Key)
+  }
+  writeJSON(w, http.StatusOK, sub)
+}
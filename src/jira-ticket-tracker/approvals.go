@@ -0,0 +1,280 @@
+package main
+
+import (
+  "fmt"
+  "net/http"
+  "os"
+  "strings"
+  "text/tabwriter"
+  "time"
+)
+
+func init() {
+  commands["approvals"] = command{"approvals", approvalsCommand}
+}
+
+const (
+  eventApproval = "approval"
+
+  slackActionApprove = "jtt-approve"
+  slackActionReject  = "jtt-reject"
+)
+
+// a gate in chat for the targets that change jira, configured under
+// `approval`. a target with `requires_approval: true` posts each change it
+// would make to the channel, with approve and reject buttons, and only makes
+// it once an approver approves it. the buttons go through the slack app, see
+// SlackAppConfig
+//
+//   approval:
+//     channel: ops-slack                    # a slack target with a token
+//     approvers: [alice, "group:ops-leads"] # jira users, see recipients.go
+//     timeout: 4h                           # then the change is dropped
+//     audit: [audit-log]                    # sent every request and decision
+//
+//   targets:
+//     close-stale:
+//       type: security-level
+//       requires_approval: true
+//       level: Internal
+//
+// every request and decision is kept in the history as an approval event
+// for the issue, like any other, so `replay` and the storage show who
+// approved what
+type ApprovalConfig struct {
+  Channel   string   `yaml:"channel"`
+  Approvers []string `yaml:"approvers"`
+  Timeout   string   `yaml:"timeout"`
+  Audit     []string `yaml:"audit"`
+}
+
+// ApprovalRequest is a change waiting in the channel to be approved
+type ApprovalRequest struct {
+  Id        string        `json:"id"`
+  Target    string        `json:"target"`
+  Key       string        `json:"key"`
+  Change    string        `json:"change"` // what it does, see describeChange
+  Requested time.Time     `json:"requested"`
+  Expires   time.Time     `json:"expires"`
+  Event     HistoryRecord `json:"event"`
+  Message   slackMessage  `json:"message"` // to replace with the decision
+}
+
+// the change to apply once it's approved
+func (r *ApprovalRequest) planned() PlannedChange {
+  return PlannedChange{Id: r.Id, Target: r.Target, Key: r.Key, Change: r.Change, Planned: r.Requested, Event: r.Event}
+}
+
+var approvals = &approvalGate{}
+
+type approvalGate struct {
+  config  ApprovalConfig
+  timeout time.Duration
+  token   string
+  channel string
+  creds   *Config
+}
+
+// whether any target requires approval
+func (c *Config) gated() bool {
+  for _, target := range c.Targets {
+    if target.RequiresApproval {
+      return true
+    }
+  }
+  return false
+}
+
+func loadApprovals(creds *Config) {
+  c := creds.Approval
+  approvals = &approvalGate{config: c, timeout: durationOr(c.Timeout, 4*time.Hour), creds: creds}
+  for name, target := range creds.Targets {
+    if !target.RequiresApproval {
+      continue
+    }
+    if !contains(jiraWriteTargets, target.Type) {
+      logger.Print("Target ", name, " requires approval but doesn't change jira, only ", strings.Join(jiraWriteTargets, ", "), " can")
+      os.Exit(1)
+    }
+    if target.Planned {
+      logger.Print("Target ", name, " can't both be planned and require approval")
+      os.Exit(1)
+    }
+  }
+  if !creds.gated() {
+    return
+  }
+  channel, ok := creds.Targets[c.Channel]
+  if !ok || channel.Type != "slack" || len(channel.Token) == 0 || len(channel.Channel) == 0 {
+    logger.Print("approval.channel has to be a slack target with a token and a channel")
+    os.Exit(1)
+  }
+  if len(creds.Slack.SigningSecret) == 0 {
+    logger.Print("Approvals need slack.signing_secret, for the app behind their buttons")
+    os.Exit(1)
+  }
+  if len(c.Approvers) == 0 {
+    logger.Print("approval.approvers is required")
+    os.Exit(1)
+  }
+  approvals.token, approvals.channel = channel.Token, channel.Channel
+}
+
+func (g *approvalGate) blocks(r *ApprovalRequest, rule string) []interface{} {
+  approve := slackButton("Approve", slackActionApprove, r.Id)
+  approve["style"] = "primary"
+  reject := slackButton("Reject", slackActionReject, r.Id)
+  reject["style"] = "danger"
+  context := fmt.Sprintf("%s · %s · expires %s", r.Target, r.Id, r.Expires.Format("15:04 Mon"))
+  if len(rule) > 0 {
+    context = "rule " + rule + " · " + context
+  }
+  return []interface{}{
+    map[string]interface{}{"type": "section", "text": slackText("mrkdwn", "*Approval needed* to "+r.Change)},
+    map[string]interface{}{"type": "context", "elements": []interface{}{slackText("mrkdwn", context)}},
+    map[string]interface{}{"type": "actions", "elements": []interface{}{approve, reject}},
+  }
+}
+
+// post a request for each change the sink would make, returning the
+// events none could be posted for
+func requestApprovals(s *sink, events []*Event) []*Event {
+  g := approvals
+  failed := []*Event{}
+  now := time.Now()
+  for _, event := range events {
+    record, err := historyRecord(event, now)
+    if err != nil {
+      logger.Print("Error requesting approval of ", s.name, " for ", event.Issue.Key, ": ", err)
+      failed = append(failed, event)
+      continue
+    }
+    r := &ApprovalRequest{
+      Id:        newEventId()[:8],
+      Target:    s.name,
+      Key:       event.Issue.Key,
+      Change:    describeChange(s.target, event.Issue.Key),
+      Requested: now,
+      Expires:   now.Add(g.timeout),
+      Event:     record,
+    }
+    body := map[string]interface{}{"channel": g.channel, "text": "Approval needed to " + r.Change, "blocks": g.blocks(r, event.Rule)}
+    if r.Message, err = slackPost(g.token, body); err != nil {
+      logger.Print("Error asking for approval to ", r.Change, ": ", err)
+      failed = append(failed, event)
+      continue
+    }
+    state.AddApproval(r)
+    pipeline.step(event, PipelineStep{Stage: "approval", Target: s.name, Detail: r.Change + ", waiting for approval as " + r.Id})
+    g.audit(*r, "approval requested in "+g.config.Channel)
+  }
+  return failed
+}
+
+// keep a decision in the history, and send it to the audit targets
+func (g *approvalGate) audit(r ApprovalRequest, decision string) {
+  logger.Print("APPROVAL ", r.Id, ": ", r.Change, ": ", decision)
+  event, err := r.Event.event()
+  if err != nil {
+    logger.Print("Error recording the approval ", r.Id, ": ", err)
+    return
+  }
+  event.Kind, event.Detail = eventApproval, r.Change+": "+decision
+  recordHistory([]*Event{event})
+  if len(g.config.Audit) > 0 {
+    event.Targets = g.config.Audit
+    deliver([]*Event{event})
+  }
+}
+
+// replace the request in the channel with what became of it
+func (g *approvalGate) close(r ApprovalRequest, outcome string) {
+  if err := slackUpdate(g.token, r.Message, outcome+": "+r.Change); err != nil {
+    logger.Print("Error updating the approval request ", r.Id, ": ", err)
+  }
+}
+
+// approve or reject a request for the slack user who clicked, returning
+// what to tell them
+func (g *approvalGate) decide(a *slackAppHandler, slackUser, id string, approve bool) (string, error) {
+  user, err := a.jiraUser(slackUser)
+  if err != nil {
+    return "", err
+  }
+  // the project, for approvers that are project roles
+  project := ""
+  for _, r := range state.PendingApprovals() {
+    if r.Id == id {
+      project = strings.SplitN(r.Key, "-", 2)[0]
+    }
+  }
+  if !contains(expandPrincipals(g.config.Approvers, project, g.creds), user) {
+    logger.Print("APPROVAL ", id, ": refused ", user, ", who isn't an approver")
+    return "", fmt.Errorf("%s isn't allowed to approve changes", user)
+  }
+  r, ok := state.TakeApproval(id)
+  if !ok {
+    return "", fmt.Errorf("%s was already decided, or expired", id)
+  }
+  if !approve {
+    g.audit(r, "rejected by "+user)
+    g.close(r, "Rejected by "+user)
+    return "Rejected: " + r.Change, nil
+  }
+  if err := applyChange(r.planned()); err != nil {
+    g.audit(r, "approved by "+user+", but failed: "+err.Error())
+    g.close(r, "Approved by "+user+" but failed")
+    return "", err
+  }
+  g.audit(r, "approved by "+user)
+  g.close(r, "Approved by "+user)
+  return "Approved: " + r.Change, nil
+}
+
+// drop the requests nobody decided on in time
+func watchApprovals(creds *Config) {
+  g := approvals
+  for range time.Tick(time.Minute) {
+    producers.RLock()
+    for _, pending := range state.PendingApprovals() {
+      if time.Now().Before(pending.Expires) {
+        continue
+      }
+      if r, ok := state.TakeApproval(pending.Id); ok {
+        g.audit(r, "expired after "+g.timeout.String())
+        g.close(r, "Expired")
+      }
+    }
+    producers.RUnlock()
+  }
+}
+
+//   GET /approvals    the changes waiting to be approved
+func handleApprovals(w http.ResponseWriter, r *http.Request) {
+  if r.Method != "GET" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+  writeJSON(w, http.StatusOK, state.PendingApprovals())
+}
+
+func approvalsCommand(args []string) {
+  if len(args) > 0 {
+    usageExit(commands["approvals"].usage)
+  }
+  var requests []ApprovalRequest
+  if err := callAPI("GET", "/approvals", nil, &requests); err != nil {
+    logger.Print("Error getting the approvals: ", err)
+    os.Exit(1)
+  }
+  if len(requests) == 0 {
+    fmt.Println("nothing is waiting for approval")
+    return
+  }
+  w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+  fmt.Fprintln(w, "ID\tREQUESTED\tEXPIRES\tTARGET\tCHANGE")
+  for _, r := range requests {
+    fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Id, r.Requested.Format("2006-01-02 15:04"), r.Expires.Format("15:04"), r.Target, r.Change)
+  }
+  w.Flush()
+}
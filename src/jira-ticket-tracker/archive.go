@@ -0,0 +1,133 @@
+package main
+
+import (
+  "bytes"
+  "compress/gzip"
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "net/url"
+  "os"
+  "strings"
+  "time"
+)
+
+// history past its retention can be archived to s3 or gcs before it's
+// deleted, as gzipped ndjson, one object per compaction
+//
+//   history:
+//     retention: 30d
+//     archive:
+//       url: s3://acme-tracker/history/   # or gs://bucket/prefix
+//       region: us-east-1
+//       schedule: 24h                      # how often to archive and compact
+//
+// s3 uses the aws credentials from the environment. gcs uses its s3
+// compatible api, with an hmac key in access_key_id and secret_access_key,
+// or from the environment. endpoint is for anything else s3 compatible, e.g.
+// minio
+type ArchiveConfig struct {
+  Url             string `yaml:"url"`
+  Region          string `yaml:"region"`
+  Endpoint        string `yaml:"endpoint"`
+  Schedule        string `yaml:"schedule"`
+  AccessKeyId     string `yaml:"access_key_id"`
+  SecretAccessKey string `yaml:"secret_access_key"`
+}
+
+type historyArchive struct {
+  base     string // the bucket's url, with a trailing slash
+  prefix   string
+  region   string
+  schedule time.Duration
+  creds    *awsCredentials // nil to use the environment's
+  client   *http.Client
+}
+
+// the archive, opened at startup in setup. nil if it isn't configured
+var archive *historyArchive
+
+func openArchive(creds *Config) *historyArchive {
+  c := creds.History.Archive
+  if len(c.Url) == 0 {
+    return nil
+  }
+  u, err := url.Parse(c.Url)
+  if err != nil || len(u.Host) == 0 || (u.Scheme != "s3" && u.Scheme != "gs") {
+    logger.Print("history.archive.url must be s3://BUCKET/PREFIX or gs://BUCKET/PREFIX")
+    os.Exit(1)
+  }
+  a := &historyArchive{
+    prefix:   strings.TrimPrefix(u.Path, "/"),
+    region:   c.Region,
+    schedule: durationOr(c.Schedule, historyCompactInterval),
+    client:   &http.Client{Timeout: 5 * time.Minute},
+  }
+  if len(a.prefix) > 0 && !strings.HasSuffix(a.prefix, "/") {
+    a.prefix += "/"
+  }
+  switch {
+  case len(c.Endpoint) > 0:
+    a.base = strings.TrimSuffix(c.Endpoint, "/") + "/" + u.Host + "/"
+  case u.Scheme == "gs":
+    a.base = "https://storage.googleapis.com/" + u.Host + "/"
+  default:
+    a.base = "https://" + u.Host + ".s3." + c.Region + ".amazonaws.com/"
+  }
+  if len(a.region) == 0 {
+    if u.Scheme == "s3" && len(c.Endpoint) == 0 {
+      logger.Print("history.archive.region is required for s3")
+      os.Exit(1)
+    }
+    a.region = "auto"
+  }
+  if len(c.AccessKeyId) > 0 {
+    a.creds = &awsCredentials{AccessKeyId: c.AccessKeyId, SecretAccessKey: c.SecretAccessKey}
+  }
+  return a
+}
+
+// upload records as one object, named for the times of the first and last
+func (a *historyArchive) Upload(records []HistoryRecord) error {
+  if len(records) == 0 {
+    return nil
+  }
+  var body bytes.Buffer
+  w := gzip.NewWriter(&body)
+  first, last := records[0].Time, records[0].Time
+  for _, r := range records {
+    line, err := json.Marshal(r)
+    if err != nil {
+      logger.Print("Error encoding history for ", r.Key, ": ", err)
+      continue
+    }
+    w.Write(line)
+    w.Write([]byte{'\n'})
+    if r.Time.Before(first) {
+      first = r.Time
+    }
+    if r.Time.After(last) {
+      last = r.Time
+    }
+  }
+  if err := w.Close(); err != nil {
+    return err
+  }
+
+  key := fmt.Sprintf("%s%s/%s-%s.ndjson.gz", a.prefix, first.UTC().Format("2006/01/02"),
+    first.UTC().Format("20060102T150405Z"), last.UTC().Format("20060102T150405Z"))
+  headers := map[string]string{"Content-Type": "application/x-ndjson", "Content-Encoding": "gzip"}
+  creds := a.creds
+  if creds == nil {
+    env, err := currentAWSCredentials()
+    if err != nil {
+      return err
+    }
+    creds = &env
+  }
+  if _, err := signedRequest(a.client, "PUT", a.base+awsEscape(key, false), headers, body.Bytes(), "s3", a.region, *creds); err != nil {
+    return fmt.Errorf("uploading %s: %v", key, err)
+  }
+  logger.Print("Archived ", len(records), " history records to ", a.base+key)
+  return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+  "fmt"
+  "strings"
+  "time"
+)
+
+// an assign target gives unassigned issues to the least loaded candidate,
+// by how many open issues each has assigned right now
+//
+//   targets:
+//     ops-assign:
+//       type: assign
+//       assign:
+//         candidates: [alice, bob, "group:ops-l2"]  # see recipients.go
+//         max_open: 10         # nobody gets more than this
+//         caps: {carol: 3}     # or per person
+//         exclude: [bob]       # e.g. while someone is on another project
+//         table: ./assignees.yaml  # optional, candidates by area
+type AssignConfig struct {
+  Candidates []string       `yaml:"candidates"`
+  // an assignment table file, see assignmentEntry. the candidates of the
+  // entries matching an issue are used, or candidates if none match
+  Table      string         `yaml:"table"`
+  MaxOpen    int            `yaml:"max_open"`
+  Caps       map[string]int `yaml:"caps"`
+  Exclude    []string       `yaml:"exclude"`
+  // what counts as open, and-ed with the assignee
+  Open string `yaml:"open"`
+}
+
+const defaultOpenJql = "resolution = EMPTY"
+
+func (c *AssignConfig) cap(user string) int {
+  if max, ok := c.Caps[user]; ok {
+    return max
+  }
+  return c.MaxOpen
+}
+
+type assignNotifier struct {
+  name   string
+  config AssignConfig
+  table  *assignmentTable
+  creds  *Config
+}
+
+func newAssignNotifier(name string, config AssignConfig, creds *Config) *assignNotifier {
+  n := &assignNotifier{name: name, config: config, creds: creds}
+  if len(config.Table) > 0 {
+    n.table = loadAssignmentTable(config.Table)
+  }
+  return n
+}
+
+// the people an event's issue could go to
+func (n *assignNotifier) candidates(event *Event) []string {
+  pool := n.config.Candidates
+  if n.table != nil {
+    if matched := n.table.candidates(event); len(matched) > 0 {
+      pool = matched
+    }
+  }
+  pool = expandPrincipals(pool, issueProject(event), n.creds)
+  candidates := []string{}
+  now := time.Now()
+  for _, user := range pool {
+    if !contains(n.config.Exclude, user) && !absences.Out(user, now) {
+      candidates = append(candidates, user)
+    }
+  }
+  return candidates
+}
+
+// how many open issues a user has assigned
+func (n *assignNotifier) load(user string) (int, error) {
+  open := n.config.Open
+  if len(open) == 0 {
+    open = defaultOpenJql
+  }
+  return countIssues("assignee = "+jqlQuote(user)+" AND ("+open+")", n.creds)
+}
+
+// the least loaded candidate under their cap, earlier candidates win ties
+func (n *assignNotifier) pick(candidates []string) (string, error) {
+  best, bestLoad := "", 0
+  full := []string{}
+  for _, user := range candidates {
+    load, err := n.load(user)
+    if err != nil {
+      return "", fmt.Errorf("counting issues for %s: %v", user, err)
+    }
+    if max := n.config.cap(user); max > 0 && load >= max {
+      full = append(full, user)
+      continue
+    }
+    if len(best) == 0 || load < bestLoad {
+      best, bestLoad = user, load
+    }
+  }
+  if len(best) == 0 {
+    if len(full) > 0 {
+      return "", fmt.Errorf("every candidate is at their cap (%s)", strings.Join(full, ", "))
+    }
+    return "", fmt.Errorf("no candidates")
+  }
+  return best, nil
+}
+
+func (n *assignNotifier) Notify(event *Event, message string) error {
+  if event.Issue.Fields.Assignee != nil && len(event.Issue.Fields.Assignee.Name) > 0 {
+    return nil // someone already has it
+  }
+  user, err := n.pick(n.candidates(event))
+  if err != nil {
+    return fmt.Errorf("can't assign %s: %v", event.Issue.Key, err)
+  }
+
+  if err := assignIssue(event.Issue.Key, user, n.creds); err != nil {
+    return err
+  }
+  logger.Print(n.name, ": assigned ", event.Issue.Key, " to ", user)
+  return nil
+}
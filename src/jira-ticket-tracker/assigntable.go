@@ -0,0 +1,154 @@
+package main
+
+import (
+  "github.com/plouc/go-jira-client"
+  "io/ioutil"
+  "launchpad.net/goyaml"
+  "os"
+  "strings"
+  "sync"
+  "time"
+)
+
+// an entry in an assignment table file, who can take issues in an area.
+// an entry matches if any of its components, labels or keywords do
+//
+//   - component: [Payments]
+//     label: [billing]
+//     keyword: [refund, invoice]  # in the summary or description
+//     assignees: [alice, dave]
+type assignmentEntry struct {
+  Components []string `yaml:"component"`
+  Labels     []string `yaml:"label"`
+  Keywords   []string `yaml:"keyword"`
+  Assignees  []string `yaml:"assignees"`
+}
+
+// the table is reread whenever the file changes so team leads can edit it
+// without a redeploy. a broken edit keeps the previous table
+type assignmentTable struct {
+  mu      sync.RWMutex
+  path    string
+  modTime time.Time
+  entries []assignmentEntry
+}
+
+func loadAssignmentTable(path string) *assignmentTable {
+  t := &assignmentTable{path: path}
+  if err := t.load(); err != nil {
+    logger.Print("Error loading assignment table: ", err)
+    os.Exit(1)
+  }
+  go t.watch()
+  return t
+}
+
+func (t *assignmentTable) load() error {
+  info, err := os.Stat(t.path)
+  if err != nil {
+    return err
+  }
+  contents, err := ioutil.ReadFile(t.path)
+  if err != nil {
+    return err
+  }
+  var entries []assignmentEntry
+  if err := goyaml.Unmarshal(contents, &entries); err != nil {
+    return err
+  }
+
+  t.mu.Lock()
+  t.entries, t.modTime = entries, info.ModTime()
+  t.mu.Unlock()
+  return nil
+}
+
+func (t *assignmentTable) watch() {
+  for {
+    time.Sleep(time.Duration(waitIntervalSecs * time.Second))
+    info, err := os.Stat(t.path)
+    if err != nil {
+      logger.Print("Error reading assignment table: ", err)
+      continue
+    }
+    t.mu.RLock()
+    changed := info.ModTime().After(t.modTime)
+    t.mu.RUnlock()
+    if !changed {
+      continue
+    }
+    if err := t.load(); err != nil {
+      logger.Print("Error reloading assignment table, keeping the old one: ", err)
+      t.mu.Lock()
+      t.modTime = info.ModTime()
+      t.mu.Unlock()
+    } else {
+      logger.Print("Reloaded assignment table from ", t.path)
+    }
+  }
+}
+
+func (e *assignmentEntry) matches(event *Event) bool {
+  return e.matchesIssue(event.Issue, event.Fields)
+}
+
+func (e *assignmentEntry) matchesIssue(issue *gojira.Issue, fields map[string]interface{}) bool {
+  for _, c := range listNames(fields, "components") {
+    if containsFold(e.Components, c) {
+      return true
+    }
+  }
+  for _, l := range listNames(fields, "labels") {
+    if containsFold(e.Labels, l) {
+      return true
+    }
+  }
+  text := strings.ToLower(issue.Fields.Summary + " " + issue.Fields.Description)
+  for _, k := range e.Keywords {
+    if strings.Contains(text, strings.ToLower(k)) {
+      return true
+    }
+  }
+  return false
+}
+
+// the assignees of every matching entry, in table order
+func (t *assignmentTable) candidates(event *Event) []string {
+  t.mu.RLock()
+  defer t.mu.RUnlock()
+
+  candidates := []string{}
+  for i := range t.entries {
+    if t.entries[i].matches(event) {
+      candidates = addTargets(candidates, t.entries[i].Assignees)
+    }
+  }
+  return candidates
+}
+
+// the names in a list field, e.g. components ([{"name":..}]) or labels
+// (plain strings)
+func listNames(fields map[string]interface{}, path string) []string {
+  list, _ := fieldValue(fields, path).([]interface{})
+  names := []string{}
+  for _, item := range list {
+    switch v := item.(type) {
+    case string:
+      names = append(names, v)
+    case map[string]interface{}:
+      if name, ok := v["name"].(string); ok {
+        names = append(names, name)
+      }
+    }
+  }
+  return names
+}
+
+func containsFold(list []string, s string) bool {
+  for _, item := range list {
+    if strings.EqualFold(item, s) {
+      return true
+    }
+  }
+  return false
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+  "crypto/hmac"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "net/url"
+  "os"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+// how requests to jira are authenticated, configured under `auth` as a
+// chain of steps that each request goes through in order. without it,
+// requests use basic auth with login and password
+//
+//   auth:
+//     - type: headers                      # for a gateway in front of jira
+//       headers: {X-Gateway-Tenant: ops}
+//       headers_env: {X-Gateway-Key: GATEWAY_KEY}
+//     - type: oauth                        # or basic, or bearer with a secret
+//       token_url: https://auth.acme.com/oauth/token
+//       client_id: tracker
+//       secret_env: OAUTH_CLIENT_SECRET
+//       refresh_token_env: OAUTH_REFRESH   # client credentials without it
+//       scopes: [read:jira-work, write:jira-work]
+//     - type: hmac                         # sign the request for the gateway
+//       secret_env: GATEWAY_SECRET
+//       header: X-Gateway-Signature        # X-Tracker-Signature by default
+//
+// the basic, bearer and oauth steps send a user's token instead while the
+// tracker acts as them, see actingAs, and the accounts of write actions
+// keep the chain with their own login, see accountFor. correlated instances
+// can have a chain of their own
+type AuthStep struct {
+  Type       string            `yaml:"type"` // basic, bearer, oauth, headers, hmac or one of authTypes
+  Headers    map[string]string `yaml:"headers"`
+  HeadersEnv map[string]string `yaml:"headers_env"` // header -> the environment variable with its value
+  // bearer's token, oauth's client secret or hmac's key
+  Secret        string `yaml:"secret"`
+  SecretEnv     string `yaml:"secret_env"`
+  SecretFile    string `yaml:"secret_file"`
+  SecretKeyring string `yaml:"secret_keyring"`
+  Header        string `yaml:"header"` // for hmac
+  // for oauth
+  TokenUrl        string   `yaml:"token_url"`
+  ClientId        string   `yaml:"client_id"`
+  RefreshTokenEnv string   `yaml:"refresh_token_env"`
+  Scopes          []string `yaml:"scopes"`
+  // the settings of a step type added to authTypes
+  Options map[string]string `yaml:"options"`
+}
+
+func (s AuthStep) secret() (string, error) {
+  return SigningConfig{Secret: s.Secret, SecretEnv: s.SecretEnv, SecretFile: s.SecretFile, SecretKeyring: s.SecretKeyring}.secret()
+}
+
+// what's left of the chain after a step, ending with sending the request
+type jiraSender func(req *http.Request) (*http.Response, error)
+
+// AuthMiddleware is a step of the chain. it changes the request as it needs
+// to, usually its headers, and passes it on to next, so it can also look at
+// the response, e.g. to retry with a fresh token. creds are the account
+// the request is made for
+type AuthMiddleware interface {
+  Authenticate(req *http.Request, creds *Config, next jiraSender) (*http.Response, error)
+}
+
+// the step types by name. a file of its own can add one from init:
+//
+//   func init() {
+//     authTypes["vault"] = func(step AuthStep) (AuthMiddleware, error) {
+//       return &vaultAuth{path: step.Options["path"]}, nil
+//     }
+//   }
+var authTypes = map[string]func(step AuthStep) (AuthMiddleware, error){
+  "basic":   func(step AuthStep) (AuthMiddleware, error) { return basicAuth{}, nil },
+  "bearer":  newBearerAuth,
+  "oauth":   newOAuthAuth,
+  "headers": newHeadersAuth,
+  "hmac":    newHMACAuth,
+}
+
+type authChain []AuthMiddleware
+
+// basic auth with the login, how the tracker has always authenticated
+var defaultAuth = authChain{basicAuth{}}
+
+func newAuthChain(steps []AuthStep) (authChain, error) {
+  if len(steps) == 0 {
+    return defaultAuth, nil
+  }
+  chain := authChain{}
+  for i, step := range steps {
+    build, ok := authTypes[step.Type]
+    if !ok {
+      return nil, fmt.Errorf("step %d has an unknown type %q", i+1, step.Type)
+    }
+    middleware, err := build(step)
+    if err != nil {
+      return nil, fmt.Errorf("step %d (%s): %v", i+1, step.Type, err)
+    }
+    chain = append(chain, middleware)
+  }
+  return chain, nil
+}
+
+// the chain for an instance's config, exiting if it's invalid
+func loadAuthChain(steps []AuthStep, what string) authChain {
+  chain, err := newAuthChain(steps)
+  if err != nil {
+    logger.Print("Invalid auth for ", what, ": ", err)
+    os.Exit(1)
+  }
+  return chain
+}
+
+func loadAuth(creds *Config) {
+  creds.auth = loadAuthChain(creds.Auth, creds.Url)
+}
+
+func (c authChain) send(req *http.Request, creds *Config, i int) (*http.Response, error) {
+  if i == len(c) {
+    return jiraClient.Do(req)
+  }
+  return c[i].Authenticate(req, creds, func(req *http.Request) (*http.Response, error) {
+    return c.send(req, creds, i+1)
+  })
+}
+
+// send a request to jira through the account's auth chain
+func sendJiraRequest(req *http.Request, creds *Config) (*http.Response, error) {
+  chain := creds.auth
+  if chain == nil {
+    chain = defaultAuth
+  }
+  return chain.send(req, creds, 0)
+}
+
+// send the token of the user the tracker acts as, if it does, instead of
+// its own credentials
+func actingAsUser(req *http.Request, creds *Config) bool {
+  if len(creds.bearer) == 0 {
+    return false
+  }
+  req.Header.Set("Authorization", "Bearer "+creds.bearer)
+  return true
+}
+
+type basicAuth struct{}
+
+func (basicAuth) Authenticate(req *http.Request, creds *Config, next jiraSender) (*http.Response, error) {
+  if !actingAsUser(req, creds) {
+    req.SetBasicAuth(creds.Login, creds.Password)
+  }
+  return next(req)
+}
+
+// a personal access token, or any other fixed bearer token
+type bearerAuth struct {
+  token string
+}
+
+func newBearerAuth(step AuthStep) (AuthMiddleware, error) {
+  token, err := step.secret()
+  if err != nil {
+    return nil, err
+  }
+  if len(token) == 0 {
+    return nil, fmt.Errorf("the token is required, as secret, secret_env, secret_file or secret_keyring")
+  }
+  return &bearerAuth{token: token}, nil
+}
+
+func (a *bearerAuth) Authenticate(req *http.Request, creds *Config, next jiraSender) (*http.Response, error) {
+  if !actingAsUser(req, creds) {
+    req.Header.Set("Authorization", "Bearer "+a.token)
+  }
+  return next(req)
+}
+
+// an oauth 2 access token, from a refresh token or the client's own
+// credentials. it's refreshed shortly before it expires, and once more
+// when jira turns it down
+type oauthAuth struct {
+  step    AuthStep
+  secret  string
+  refresh string
+
+  mu      sync.Mutex
+  token   string
+  expires time.Time
+}
+
+func newOAuthAuth(step AuthStep) (AuthMiddleware, error) {
+  if len(step.TokenUrl) == 0 || len(step.ClientId) == 0 {
+    return nil, fmt.Errorf("token_url and client_id are required")
+  }
+  secret, err := step.secret()
+  if err != nil {
+    return nil, err
+  }
+  a := &oauthAuth{step: step, secret: secret}
+  if len(step.RefreshTokenEnv) > 0 {
+    if a.refresh = os.Getenv(step.RefreshTokenEnv); len(a.refresh) == 0 {
+      return nil, fmt.Errorf("%s is not set", step.RefreshTokenEnv)
+    }
+  }
+  return a, nil
+}
+
+func (a *oauthAuth) accessToken(stale bool) (string, error) {
+  a.mu.Lock()
+  defer a.mu.Unlock()
+  if !stale && len(a.token) > 0 && time.Until(a.expires) > time.Minute {
+    return a.token, nil
+  }
+  form := url.Values{"client_id": {a.step.ClientId}, "grant_type": {"client_credentials"}}
+  if len(a.secret) > 0 {
+    form.Set("client_secret", a.secret)
+  }
+  if len(a.refresh) > 0 {
+    form.Set("grant_type", "refresh_token")
+    form.Set("refresh_token", a.refresh)
+  }
+  if len(a.step.Scopes) > 0 {
+    form.Set("scope", strings.Join(a.step.Scopes, " "))
+  }
+  resp, err := jiraClient.PostForm(a.step.TokenUrl, form)
+  if err != nil {
+    return "", err
+  }
+  defer resp.Body.Close()
+  contents, _ := ioutil.ReadAll(resp.Body)
+  if resp.StatusCode != http.StatusOK {
+    return "", fmt.Errorf("the token endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(contents)))
+  }
+  var result struct {
+    AccessToken  string `json:"access_token"`
+    ExpiresIn    int    `json:"expires_in"`
+    RefreshToken string `json:"refresh_token"`
+  }
+  if err := json.Unmarshal(contents, &result); err != nil {
+    return "", err
+  }
+  if len(result.RefreshToken) > 0 {
+    a.refresh = result.RefreshToken // rotated, the old one may not work again
+  }
+  a.token, a.expires = result.AccessToken, time.Now().Add(time.Duration(result.ExpiresIn)*time.Second)
+  return a.token, nil
+}
+
+func (a *oauthAuth) Authenticate(req *http.Request, creds *Config, next jiraSender) (*http.Response, error) {
+  if actingAsUser(req, creds) {
+    return next(req)
+  }
+  token, err := a.accessToken(false)
+  if err != nil {
+    return nil, fmt.Errorf("can't get an oauth token from %s: %v", a.step.TokenUrl, err)
+  }
+  req.Header.Set("Authorization", "Bearer "+token)
+  resp, err := next(req)
+  if err != nil || resp.StatusCode != http.StatusUnauthorized || (req.Body != nil && req.GetBody == nil) {
+    return resp, err
+  }
+  // revoked or expired early, try once more with a new one
+  drainAndClose(resp.Body)
+  if token, err = a.accessToken(true); err != nil {
+    return nil, fmt.Errorf("can't refresh the oauth token from %s: %v", a.step.TokenUrl, err)
+  }
+  retry := req.Clone(req.Context())
+  if req.GetBody != nil {
+    if retry.Body, err = req.GetBody(); err != nil {
+      return nil, err
+    }
+  }
+  retry.Header.Set("Authorization", "Bearer "+token)
+  return next(retry)
+}
+
+// fixed headers, e.g. the key and tenant a gateway in front of jira wants
+type headersAuth struct {
+  headers map[string]string
+}
+
+func newHeadersAuth(step AuthStep) (AuthMiddleware, error) {
+  headers := map[string]string{}
+  for name, value := range step.Headers {
+    headers[name] = value
+  }
+  for name, env := range step.HeadersEnv {
+    value := os.Getenv(env)
+    if len(value) == 0 {
+      return nil, fmt.Errorf("%s, for the header %s, is not set", env, name)
+    }
+    headers[name] = value
+  }
+  if len(headers) == 0 {
+    return nil, fmt.Errorf("headers or headers_env is required")
+  }
+  return &headersAuth{headers: headers}, nil
+}
+
+func (a *headersAuth) Authenticate(req *http.Request, creds *Config, next jiraSender) (*http.Response, error) {
+  for name, value := range a.headers {
+    req.Header.Set(name, value)
+  }
+  return next(req)
+}
+
+// signs each request like a signed webhook's posts, see SigningConfig, but
+// covering the method and the url too: X-Tracker-Timestamp has the unix
+// time, and the header sha256= and the hex hmac-sha256 of the timestamp,
+// the method, the request uri and the body, joined by dots
+type hmacAuth struct {
+  secret []byte
+  header string
+}
+
+func newHMACAuth(step AuthStep) (AuthMiddleware, error) {
+  secret, err := step.secret()
+  if err != nil {
+    return nil, err
+  }
+  if len(secret) == 0 {
+    return nil, fmt.Errorf("the key is required, as secret, secret_env, secret_file or secret_keyring")
+  }
+  if err := checkFIPSSecret("The hmac auth key", secret); err != nil {
+    return nil, err
+  }
+  header := step.Header
+  if len(header) == 0 {
+    header = "X-Tracker-Signature"
+  }
+  return &hmacAuth{secret: []byte(secret), header: header}, nil
+}
+
+func (a *hmacAuth) Authenticate(req *http.Request, creds *Config, next jiraSender) (*http.Response, error) {
+  var body []byte
+  if req.GetBody != nil {
+    reader, err := req.GetBody()
+    if err != nil {
+      return nil, err
+    }
+    body, err = ioutil.ReadAll(reader)
+    if err != nil {
+      return nil, err
+    }
+  }
+  timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+  mac := hmac.New(sha256.New, a.secret)
+  mac.Write([]byte(timestamp + "." + req.Method + "." + req.URL.RequestURI() + "."))
+  mac.Write(body)
+  req.Header.Set("X-Tracker-Timestamp", timestamp)
+  req.Header.Set(a.header, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+  return next(req)
+}
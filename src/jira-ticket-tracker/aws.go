@@ -0,0 +1,223 @@
+package main
+
+import (
+  "bytes"
+  "crypto/hmac"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "encoding/xml"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "net/url"
+  "os"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+)
+
+// just enough of aws to sign requests with, so the aws backed features
+// don't need the sdk. credentials come from the environment like the sdk's:
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, as lambda
+// sets them, or the container credentials ecs and fargate provide
+type awsCredentials struct {
+  AccessKeyId     string
+  SecretAccessKey string
+  Token           string
+  Expiration      time.Time
+}
+
+var awsCredentialCache = struct {
+  sync.Mutex
+  creds awsCredentials
+}{}
+
+func currentAWSCredentials() (awsCredentials, error) {
+  if id := os.Getenv("AWS_ACCESS_KEY_ID"); len(id) > 0 {
+    return awsCredentials{AccessKeyId: id, SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"), Token: os.Getenv("AWS_SESSION_TOKEN")}, nil
+  }
+  uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+  if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); len(relative) > 0 {
+    uri = "http://169.254.170.2" + relative
+  }
+  if len(uri) == 0 {
+    return awsCredentials{}, fmt.Errorf("no aws credentials, set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+  }
+
+  awsCredentialCache.Lock()
+  defer awsCredentialCache.Unlock()
+  if time.Until(awsCredentialCache.creds.Expiration) > 5*time.Minute {
+    return awsCredentialCache.creds, nil
+  }
+  req, err := http.NewRequest("GET", uri, nil)
+  if err != nil {
+    return awsCredentials{}, err
+  }
+  if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); len(token) > 0 {
+    req.Header.Set("Authorization", token)
+  }
+  resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+  if err != nil {
+    return awsCredentials{}, err
+  }
+  defer drainAndClose(resp.Body)
+  if resp.StatusCode >= 300 {
+    return awsCredentials{}, fmt.Errorf("container credentials returned %s", resp.Status)
+  }
+  var creds awsCredentials
+  if err := json.NewDecoder(resp.Body).Decode(&creds); err != nil {
+    return awsCredentials{}, err
+  }
+  awsCredentialCache.creds = creds
+  return creds, nil
+}
+
+// escape as sigv4 wants, everything but the unreserved characters
+func awsEscape(s string, slashes bool) string {
+  var out strings.Builder
+  for _, b := range []byte(s) {
+    switch {
+    case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~':
+      out.WriteByte(b)
+    case b == '/' && !slashes:
+      out.WriteByte(b)
+    default:
+      fmt.Fprintf(&out, "%%%02X", b)
+    }
+  }
+  return out.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+  mac := hmac.New(sha256.New, key)
+  mac.Write([]byte(data))
+  return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+  sum := sha256.Sum256(data)
+  return hex.EncodeToString(sum[:])
+}
+
+// sign a request with aws signature version 4. the path has to be escaped
+// already, see awsEscape
+func signAWS(req *http.Request, body []byte, service, region string, creds awsCredentials) {
+  now := time.Now().UTC()
+  amzDate, day := now.Format("20060102T150405Z"), now.Format("20060102")
+  payload := sha256Hex(body)
+  req.Header.Set("Host", req.URL.Host)
+  req.Header.Set("X-Amz-Date", amzDate)
+  req.Header.Set("X-Amz-Content-Sha256", payload)
+  if len(creds.Token) > 0 {
+    req.Header.Set("X-Amz-Security-Token", creds.Token)
+  }
+
+  names := []string{}
+  for name := range req.Header {
+    names = append(names, strings.ToLower(name))
+  }
+  sort.Strings(names)
+  headers := ""
+  for _, name := range names {
+    headers += name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n"
+  }
+  signed := strings.Join(names, ";")
+
+  query := req.URL.Query()
+  keys := []string{}
+  for key := range query {
+    keys = append(keys, key)
+  }
+  sort.Strings(keys)
+  params := []string{}
+  for _, key := range keys {
+    for _, value := range query[key] {
+      params = append(params, awsEscape(key, true)+"="+awsEscape(value, true))
+    }
+  }
+  path := req.URL.EscapedPath()
+  if len(path) == 0 {
+    path = "/"
+  }
+  canonical := strings.Join([]string{req.Method, path, strings.Join(params, "&"), headers, signed, payload}, "\n")
+
+  scope := day + "/" + region + "/" + service + "/aws4_request"
+  toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+  key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), day)
+  key = hmacSHA256(key, region)
+  key = hmacSHA256(key, service)
+  key = hmacSHA256(key, "aws4_request")
+  signature := hex.EncodeToString(hmacSHA256(key, toSign))
+  req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+    creds.AccessKeyId, scope, signed, signature))
+}
+
+// an aws error response, e.g. a dynamodb ConditionalCheckFailedException
+type awsError struct {
+  Status  int
+  Type    string
+  Message string
+}
+
+func (e *awsError) Error() string {
+  return fmt.Sprintf("%d %s: %s", e.Status, e.Type, e.Message)
+}
+
+// make a signed request, returning the body of a successful response
+func awsRequest(client *http.Client, method, rawUrl string, headers map[string]string, body []byte, service, region string) ([]byte, error) {
+  creds, err := currentAWSCredentials()
+  if err != nil {
+    return nil, err
+  }
+  return signedRequest(client, method, rawUrl, headers, body, service, region, creds)
+}
+
+// the same with the given credentials, e.g. gcs hmac keys
+func signedRequest(client *http.Client, method, rawUrl string, headers map[string]string, body []byte, service, region string, creds awsCredentials) ([]byte, error) {
+  u, err := url.Parse(rawUrl)
+  if err != nil {
+    return nil, err
+  }
+  req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+  if err != nil {
+    return nil, err
+  }
+  for name, value := range headers {
+    req.Header.Set(name, value)
+  }
+  signAWS(req, body, service, region, creds)
+  resp, err := client.Do(req)
+  if err != nil {
+    return nil, err
+  }
+  defer drainAndClose(resp.Body)
+  contents, err := ioutil.ReadAll(resp.Body)
+  if err != nil {
+    return nil, err
+  }
+  if resp.StatusCode >= 300 {
+    var e struct {
+      Type    string `json:"__type"`
+      Message string `json:"message"`
+    }
+    if err := json.Unmarshal(contents, &e); err != nil {
+      // s3 errors are xml
+      var x struct {
+        Code    string
+        Message string
+      }
+      xml.Unmarshal(contents, &x)
+      e.Type, e.Message = x.Code, x.Message
+    }
+    if i := strings.LastIndex(e.Type, "#"); i >= 0 {
+      e.Type = e.Type[i+1:]
+    }
+    if len(e.Message) == 0 {
+      e.Message = strings.TrimSpace(string(contents))
+    }
+    return nil, &awsError{resp.StatusCode, e.Type, e.Message}
+  }
+  return contents, nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+  "encoding/json"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "math/rand"
+  "os"
+  "path/filepath"
+  "runtime"
+  "sort"
+  "strings"
+  "sync"
+  "sync/atomic"
+  "time"
+)
+
+func init() {
+  commands["bench"] = command{"bench [--rate=100] [--duration=30s] [--poll=20] [--latency=20ms] [--errors=0] [--rule=NAME]", benchCommand}
+}
+
+// a target that only waits and counts, standing in for every configured
+// target while benchmarking
+type benchNotifier struct {
+  latency time.Duration
+  errors  float64 // the fraction of sends that fail
+
+  sent, failed int64
+  queued       *sync.Map // event id -> when it was put on the queue
+  latencies    *benchLatencies
+}
+
+func (n *benchNotifier) Notify(event *Event, message string) error {
+  time.Sleep(n.latency)
+  if rand.Float64() < n.errors {
+    atomic.AddInt64(&n.failed, 1)
+    return fmt.Errorf("a synthetic failure")
+  }
+  atomic.AddInt64(&n.sent, 1)
+  if queued, ok := n.queued.Load(event.Id); ok {
+    n.latencies.add(time.Since(queued.(time.Time)))
+  }
+  return nil
+}
+
+func (n *benchNotifier) NotifyBatch(events []*Event, messages []string) error {
+  time.Sleep(n.latency)
+  if rand.Float64() < n.errors {
+    atomic.AddInt64(&n.failed, int64(len(events)))
+    return fmt.Errorf("a synthetic failure")
+  }
+  atomic.AddInt64(&n.sent, int64(len(events)))
+  for _, event := range events {
+    if queued, ok := n.queued.Load(event.Id); ok {
+      n.latencies.add(time.Since(queued.(time.Time)))
+    }
+  }
+  return nil
+}
+
+type benchLatencies struct {
+  sync.Mutex
+  all []time.Duration
+}
+
+func (l *benchLatencies) add(d time.Duration) {
+  l.Lock()
+  defer l.Unlock()
+  l.all = append(l.all, d)
+}
+
+// the latency below which a fraction of the sends were
+func (l *benchLatencies) percentile(p float64) time.Duration {
+  l.Lock()
+  defer l.Unlock()
+  if len(l.all) == 0 {
+    return 0
+  }
+  sort.Slice(l.all, func(i, j int) bool { return l.all[i] < l.all[j] })
+  return l.all[int(p*float64(len(l.all)-1))]
+}
+
+// a made up issue a rule would have found
+func syntheticIssue(rule *Rule, n int) []byte {
+  project := rule.Project
+  if len(project) == 0 {
+    project = "BENCH"
+  }
+  user := rule.User
+  if len(user) == 0 && len(rule.Users) > 0 {
+    user = rule.Users[0]
+  }
+  priorities := []string{"Highest", "High", "Medium", "Low"}
+  contents, _ := json.Marshal(map[string]interface{}{
+    "key": fmt.Sprintf("%s-%d", project, n),
+    "fields": map[string]interface{}{
+      "summary":     fmt.Sprintf("Synthetic issue %d for %s", n, rule.Name),
+      "description": strings.Repeat("Something is broken. ", 20),
+      "project":     map[string]interface{}{"key": project},
+      "issuetype":   map[string]interface{}{"name": "Bug"},
+      "priority":    map[string]interface{}{"name": priorities[n%len(priorities)]},
+      "status":      map[string]interface{}{"name": "Open"},
+      "labels":      []string{"bench"},
+      "created":     time.Now().Format("2006-01-02T15:04:05.000-0700"),
+      rule.Field:    map[string]interface{}{"name": user, "displayName": user},
+    },
+  })
+  return contents
+}
+
+// drive the pipeline with synthetic issues at a steady rate. polls of
+// issues go on a queue like the pollers' and are delivered from it by the
+// same code the tracker uses, to targets that only wait for --latency. at
+// the end it reports the throughput, how deep the queue got and what was
+// allocated. the state and history go to a temporary directory and jira
+// isn't called, so it's safe to run next to a real tracker
+func benchCommand(args []string) {
+  flags := flag.NewFlagSet("bench", flag.ExitOnError)
+  rate := flags.Int("rate", 100, "Synthetic issues per second")
+  duration := flags.String("duration", "30s", "How long to generate issues for")
+  poll := flags.Int("poll", 20, "Issues per poll, delivered together")
+  latency := flags.String("latency", "20ms", "How long each send to a target takes")
+  errors := flags.Float64("errors", 0, "The fraction of sends that fail, e.g. 0.01")
+  ruleName := flags.String("rule", "", "Only generate issues for this rule")
+  flags.Parse(args)
+  run, err := parseDuration(*duration)
+  if err != nil || *rate <= 0 || *poll <= 0 || flags.NArg() > 0 {
+    usageExit(commands["bench"].usage)
+  }
+  wait, err := parseDuration(*latency)
+  if err != nil {
+    usageExit(commands["bench"].usage)
+  }
+
+  creds, rules := setup()
+  if len(*ruleName) > 0 {
+    rule := findRule(rules, *ruleName)
+    if rule == nil {
+      logger.Print("No rule named ", *ruleName)
+      os.Exit(1)
+    }
+    rules = []*Rule{rule}
+  }
+  if len(rules) == 0 {
+    logger.Print("bench generates issues for the rules, configure some")
+    os.Exit(1)
+  }
+  dir, err := ioutil.TempDir("", "tracker-bench")
+  if err != nil {
+    logger.Print("Error creating a directory for the bench's state: ", err)
+    os.Exit(1)
+  }
+  defer os.RemoveAll(dir)
+  state = loadState(filepath.Join(dir, "state.json"))
+  store = newMemoryStorage()
+  history, outbox, archive, correlations = nil, nil, nil, nil
+
+  queued := &sync.Map{}
+  latencies := &benchLatencies{}
+  notifiers := map[string]*benchNotifier{}
+  for name, s := range sinks {
+    n := &benchNotifier{latency: wait, errors: *errors, queued: queued, latencies: latencies}
+    s.notifier, notifiers[name] = n, n
+    s.target.VisibleTo = Visibility{} // it would ask jira
+    // or record the changes and post approval requests to chat
+    s.target.Planned, s.target.RequiresApproval = false, false
+  }
+
+  var before runtime.MemStats
+  runtime.GC()
+  runtime.ReadMemStats(&before)
+  goroutines := runtime.NumGoroutine()
+
+  queue := make(chan []*Event, 10000)
+  done := make(chan struct{})
+  var delivered int64
+  go func() {
+    for events := range queue {
+      recordHistory(events)
+      deliver(events)
+      atomic.AddInt64(&delivered, int64(len(events)))
+    }
+    close(done)
+  }()
+
+  // the queue's depth and the heap, sampled while it runs
+  var depths []int
+  var peakHeap uint64
+  sampling, sampled := make(chan struct{}), make(chan struct{})
+  go func() {
+    tick := time.NewTicker(100 * time.Millisecond)
+    defer tick.Stop()
+    defer close(sampled)
+    for {
+      select {
+      case <-sampling:
+        return
+      case <-tick.C:
+        depths = append(depths, len(queue))
+        var m runtime.MemStats
+        runtime.ReadMemStats(&m)
+        if m.HeapInuse > peakHeap {
+          peakHeap = m.HeapInuse
+        }
+      }
+    }
+  }()
+
+  fmt.Printf("generating %d issues a second for %s across %d rules and %d targets\n", *rate, run, len(rules), len(sinks))
+  started := time.Now()
+  interval := time.Second * time.Duration(*poll) / time.Duration(*rate)
+  generated := 0
+  for time.Since(started) < run {
+    events := []*Event{}
+    for i := 0; i < *poll; i++ {
+      rule := rules[generated%len(rules)]
+      issue, fields, err := parseIssue(syntheticIssue(rule, generated+1))
+      if err != nil {
+        logger.Print("Error generating an issue: ", err)
+        os.Exit(1)
+      }
+      event := ruleEvent(rule, eventCreated, issue, fields, creds)
+      queued.Store(event.Id, time.Now())
+      events = append(events, event)
+      generated++
+    }
+    queue <- events
+    time.Sleep(time.Until(started.Add(interval * time.Duration(generated / *poll))))
+  }
+  generating := time.Since(started)
+  close(queue)
+  <-done
+  elapsed := time.Since(started)
+  close(sampling)
+  <-sampled
+
+  var after runtime.MemStats
+  runtime.ReadMemStats(&after)
+
+  maxDepth, totalDepth := 0, 0
+  for _, depth := range depths {
+    totalDepth += depth
+    if depth > maxDepth {
+      maxDepth = depth
+    }
+  }
+  avgDepth := 0.0
+  if len(depths) > 0 {
+    avgDepth = float64(totalDepth) / float64(len(depths))
+  }
+  var sent, failed int64
+  names := []string{}
+  for name := range notifiers {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+
+  fmt.Printf("generated %d issues in %s (%.1f/s), delivered them all after %s (%.1f/s)\n",
+    generated, generating.Round(time.Millisecond), float64(generated)/generating.Seconds(),
+    elapsed.Round(time.Millisecond), float64(delivered)/elapsed.Seconds())
+  fmt.Printf("queue depth: %.1f polls on average, %d at most\n", avgDepth, maxDepth)
+  fmt.Printf("latency from queued to sent: p50 %s, p99 %s, max %s\n",
+    latencies.percentile(0.5).Round(time.Microsecond), latencies.percentile(0.99).Round(time.Microsecond),
+    latencies.percentile(1).Round(time.Microsecond))
+  for _, name := range names {
+    n := notifiers[name]
+    sent, failed = sent+n.sent, failed+n.failed
+    if n.sent+n.failed > 0 {
+      fmt.Printf("  %-20s %d sent, %d failed\n", name, n.sent, n.failed)
+    }
+  }
+  fmt.Printf("notifications: %d sent (%.1f/s), %d failed\n", sent, float64(sent)/elapsed.Seconds(), failed)
+  fmt.Printf("allocated %.1f MB in %d allocations (%.1f KB and %d allocations per issue), %d GCs\n",
+    float64(after.TotalAlloc-before.TotalAlloc)/(1<<20), after.Mallocs-before.Mallocs,
+    float64(after.TotalAlloc-before.TotalAlloc)/1024/float64(generated), (after.Mallocs-before.Mallocs)/uint64(generated),
+    after.NumGC-before.NumGC)
+  fmt.Printf("heap: %.1f MB at most, %.1f MB at the end, %d goroutines more than at the start\n",
+    float64(peakHeap)/(1<<20), float64(after.HeapInuse)/(1<<20), runtime.NumGoroutine()-goroutines)
+}
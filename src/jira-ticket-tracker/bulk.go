@@ -0,0 +1,150 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "os"
+  "strings"
+  "sync"
+)
+
+func init() {
+  commands["bulk"] = command{"bulk --jql=JQL --action=KIND:VALUE.. [--concurrency=4] [--limit=N] [--dry-run]", bulkCommand}
+}
+
+// one change for every issue a bulk command finds, e.g. label:triaged
+type bulkAction struct {
+  kind  string
+  value string
+}
+
+var bulkActionKinds = []string{"label", "unlabel", "comment", "transition", "assign"}
+
+func parseBulkAction(s string) (bulkAction, error) {
+  parts := strings.SplitN(s, ":", 2)
+  if len(parts) != 2 || len(parts[1]) == 0 {
+    return bulkAction{}, fmt.Errorf("expected KIND:VALUE, got %q", s)
+  }
+  if !containsFold(bulkActionKinds, parts[0]) {
+    return bulkAction{}, fmt.Errorf("unknown action %s, expected one of %s", parts[0], strings.Join(bulkActionKinds, ", "))
+  }
+  return bulkAction{strings.ToLower(parts[0]), parts[1]}, nil
+}
+
+func (a bulkAction) String() string {
+  return a.kind + ":" + a.value
+}
+
+func labelIssue(key, op, label string, creds *Config) error {
+  body := map[string]interface{}{"update": map[string]interface{}{
+    "labels": []map[string]string{{op: label}},
+  }}
+  _, err := jiraRequest("PUT", "/issue/"+key, body, creds.accountFor("edit"))
+  return err
+}
+
+func (a bulkAction) apply(key string, creds *Config) error {
+  switch a.kind {
+  case "label":
+    return labelIssue(key, "add", a.value, creds)
+  case "unlabel":
+    return labelIssue(key, "remove", a.value, creds)
+  case "comment":
+    return addComment(key, a.value, creds)
+  case "transition":
+    return transitionIssue(key, a.value, creds)
+  case "assign":
+    return assignIssue(key, a.value, creds)
+  }
+  return fmt.Errorf("unknown action %s", a.kind)
+}
+
+// repeated --action flags
+type bulkActions []bulkAction
+
+func (b *bulkActions) String() string {
+  names := []string{}
+  for _, a := range *b {
+    names = append(names, a.String())
+  }
+  return strings.Join(names, ",")
+}
+
+func (b *bulkActions) Set(s string) error {
+  a, err := parseBulkAction(s)
+  if err != nil {
+    return err
+  }
+  *b = append(*b, a)
+  return nil
+}
+
+// apply the actions to every issue matching the jql, a few issues at a time
+// so jira isn't flooded. an issue's actions run in order and stop at the
+// first that fails
+func bulkCommand(args []string) {
+  flags := flag.NewFlagSet("bulk", flag.ExitOnError)
+  jql := flags.String("jql", "", "The issues to change")
+  concurrency := flags.Int("concurrency", 4, "How many issues to change at once")
+  limit := flags.Int("limit", 1000, "The most issues to change")
+  dryRun := flags.Bool("dry-run", false, "List what would be changed without changing it")
+  actions := bulkActions{}
+  flags.Var(&actions, "action", "label:NAME, unlabel:NAME, comment:TEXT, transition:NAME or assign:USER")
+  flags.Parse(args)
+  if len(*jql) == 0 || len(actions) == 0 || flags.NArg() > 0 || *concurrency < 1 {
+    usageExit(commands["bulk"].usage)
+  }
+
+  creds, _ := setup()
+  issues, _, err := searchIssues(configJql(creds, "--jql", *jql), *limit, creds)
+  if err != nil {
+    logger.Print("Error searching: ", err)
+    os.Exit(1)
+  }
+  if *dryRun {
+    for _, issue := range issues {
+      fmt.Printf("%s: would %s\n", issue.Key, actions.String())
+    }
+    fmt.Printf("%d issues\n", len(issues))
+    return
+  }
+
+  var mu sync.Mutex
+  done, failed := 0, 0
+  keys := make(chan string)
+  var wg sync.WaitGroup
+  for i := 0; i < *concurrency; i++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      for key := range keys {
+        var err error
+        for _, a := range actions {
+          if err = a.apply(key, creds); err != nil {
+            err = fmt.Errorf("%s: %s", a, err)
+            break
+          }
+        }
+        mu.Lock()
+        done++
+        if err != nil {
+          failed++
+          fmt.Printf("[%d/%d] %s FAILED %s\n", done, len(issues), key, err)
+        } else {
+          fmt.Printf("[%d/%d] %s ok\n", done, len(issues), key)
+        }
+        mu.Unlock()
+      }
+    }()
+  }
+  for _, issue := range issues {
+    keys <- issue.Key
+  }
+  close(keys)
+  wg.Wait()
+
+  fmt.Printf("changed %d of %d issues\n", done-failed, len(issues))
+  if failed > 0 {
+    os.Exit(1)
+  }
+}
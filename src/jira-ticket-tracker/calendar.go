@@ -0,0 +1,176 @@
+package main
+
+import (
+  "fmt"
+  "os"
+  "strings"
+  "time"
+)
+
+// a working calendar for a project, configured under `calendars` by project
+// key ("default" applies to projects without their own). it is used to
+// measure time in business hours for first response targets and needs info
+// timeouts, and its timezone is the one due dates are read in
+//
+//   calendars:
+//     SUPPORT:
+//       timezone: Europe/Berlin
+//       hours: "09:00-17:00"
+//       days: [mon, tue, wed, thu, fri]
+//       holidays: [2026-12-25, 2026-12-26]
+//
+// JSM's own calendars aren't exposed through the public REST API, so they
+// have to be copied here
+type Calendar struct {
+  Timezone string   `yaml:"timezone"`
+  Hours    string   `yaml:"hours"`
+  Days     []string `yaml:"days"`
+  Holidays []string `yaml:"holidays"`
+
+  location *time.Location
+  open     time.Duration // since midnight
+  close    time.Duration
+  days     map[time.Weekday]bool
+  holidays map[string]bool
+}
+
+var weekdays = map[string]time.Weekday{
+  "sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+  "thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func parseClock(s string) (time.Duration, error) {
+  t, err := time.Parse("15:04", strings.TrimSpace(s))
+  if err != nil {
+    return 0, err
+  }
+  return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (c *Calendar) parse() error {
+  var err error
+  c.location = time.UTC
+  if len(c.Timezone) > 0 {
+    if c.location, err = time.LoadLocation(c.Timezone); err != nil {
+      return err
+    }
+  }
+
+  c.open, c.close = 0, 24*time.Hour
+  if len(c.Hours) > 0 {
+    parts := strings.Split(c.Hours, "-")
+    if len(parts) != 2 {
+      return fmt.Errorf("hours should look like 09:00-17:00, not %q", c.Hours)
+    }
+    if c.open, err = parseClock(parts[0]); err != nil {
+      return err
+    }
+    if c.close, err = parseClock(parts[1]); err != nil {
+      return err
+    }
+    if c.close <= c.open {
+      return fmt.Errorf("hours %q close before they open", c.Hours)
+    }
+  }
+
+  c.days = map[time.Weekday]bool{}
+  if len(c.Days) == 0 {
+    c.Days = []string{"mon", "tue", "wed", "thu", "fri"}
+  }
+  for _, d := range c.Days {
+    name := strings.ToLower(d)
+    if len(name) > 3 {
+      name = name[:3]
+    }
+    day, ok := weekdays[name]
+    if !ok {
+      return fmt.Errorf("unknown day %q", d)
+    }
+    c.days[day] = true
+  }
+
+  c.holidays = map[string]bool{}
+  for _, h := range c.Holidays {
+    if _, err := time.Parse("2006-01-02", h); err != nil {
+      return fmt.Errorf("invalid holiday %q", h)
+    }
+    c.holidays[h] = true
+  }
+  return nil
+}
+
+func (c *Calendar) workingDay(day time.Time) bool {
+  return c.days[day.Weekday()] && !c.holidays[day.Format("2006-01-02")]
+}
+
+// Working reports whether t falls within business hours
+func (c *Calendar) Working(t time.Time) bool {
+  if c == nil {
+    return true
+  }
+  t = t.In(c.location)
+  midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, c.location)
+  since := t.Sub(midnight)
+  return c.workingDay(t) && since >= c.open && since < c.close
+}
+
+// Elapsed is the business time between start and end. a nil calendar
+// counts every hour
+func (c *Calendar) Elapsed(start, end time.Time) time.Duration {
+  if c == nil {
+    return end.Sub(start)
+  }
+  if !end.After(start) {
+    return -c.Elapsed(end, start)
+  }
+
+  total := time.Duration(0)
+  start, end = start.In(c.location), end.In(c.location)
+  day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, c.location)
+  for !day.After(end) {
+    if c.workingDay(day) {
+      open, close := day.Add(c.open), day.Add(c.close)
+      if open.Before(start) {
+        open = start
+      }
+      if close.After(end) {
+        close = end
+      }
+      if close.After(open) {
+        total += close.Sub(open)
+      }
+    }
+    day = day.AddDate(0, 0, 1)
+  }
+  return total
+}
+
+// BusinessDays is Elapsed in working days of the calendar's hours
+func (c *Calendar) BusinessDays(start, end time.Time) float64 {
+  day := 24 * time.Hour
+  if c != nil {
+    day = c.close - c.open
+  }
+  return float64(c.Elapsed(start, end)) / float64(day)
+}
+
+func parseCalendars(creds *Config) {
+  for project, c := range creds.Calendars {
+    if err := c.parse(); err != nil {
+      logger.Print("Invalid calendar for ", project, ": ", err)
+      os.Exit(1)
+    }
+  }
+}
+
+// the calendar for the project an issue key belongs to, nil if there is none
+func calendarFor(creds *Config, key string) *Calendar {
+  project := key
+  if i := strings.LastIndex(key, "-"); i > 0 {
+    project = key[:i]
+  }
+  if c, ok := creds.Calendars[project]; ok {
+    return c
+  }
+  return creds.Calendars["default"]
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+  "encoding/json"
+  "flag"
+  "fmt"
+  "os"
+  "time"
+)
+
+const defaultCatchupMax = 500
+
+// how much catching up after downtime is allowed, configured under `catchup`
+//
+//   catchup:
+//     max_issues: 200
+type CatchupConfig struct {
+  MaxIssues int `yaml:"max_issues"`
+}
+
+func init() {
+  commands["backfill"] = command{"backfill [--notify=false] RULE", backfillCommand}
+}
+
+// count the issues a search would return without fetching them
+func countIssues(jql string, creds *Config) (int, error) {
+  contents := jiraSearch(jql, 0, 0, creds)
+  if contents == nil {
+    return 0, fmt.Errorf("count query failed")
+  }
+  var issues rawIssueList
+  if err := json.Unmarshal(contents, &issues); err != nil {
+    return 0, err
+  }
+  return issues.Total, nil
+}
+
+// check the size of the gap before catching up on it. with more issues than
+// allowed (e.g. after a week of downtime) the rule is stopped and the
+// operator alerted rather than blasting out thousands of notifications
+func catchUpAllowed(rule *Rule, since time.Time, creds *Config) bool {
+  if *forceCatchup {
+    return true
+  }
+  max := creds.Catchup.MaxIssues
+  if max == 0 {
+    max = defaultCatchupMax
+  }
+
+  total, err := countIssues(windowQuery(rule, since, windowEnd()), creds)
+  if err != nil {
+    // better to catch up one window at a time than not at all
+    logger.Print("Error counting the issues to catch up on for ", rule.Name, ": ", err)
+    return true
+  }
+  if total <= max {
+    return true
+  }
+
+  alertOperator(creds, fmt.Sprintf(
+    "Rule %s has %d issues to catch up on since %s (max %d), it will not poll until "+
+      "restarted with --force-catchup or backfilled with `backfill %s`",
+    rule.Name, total, since.Format(time.RFC3339), max, rule.Name))
+  return false
+}
+
+// record the issues a rule missed without notifying anyone, then move its
+// checkpoint to now so the tracker starts from the present. the tracker has
+// to be stopped while this runs since it writes the state file directly
+func backfillCommand(args []string) {
+  flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+  notify := flags.Bool("notify", false, "Send the usual notifications for the backfilled issues")
+  flags.Parse(args)
+  if flags.NArg() != 1 {
+    usageExit(commands["backfill"].usage)
+  }
+
+  creds, rules := setup()
+  rule := findRule(rules, flags.Arg(0))
+  if rule == nil {
+    logger.Print("No rule named ", flags.Arg(0))
+    os.Exit(1)
+  }
+
+  since, ok := checkpoint(rule.Name)
+  if !ok {
+    logger.Print("Rule ", rule.Name, " has never been polled, there is nothing to backfill")
+    return
+  }
+  until := windowEnd()
+  events, err := searchWindow(rule, since, until, creds)
+  if err != nil {
+    logger.Print("Error backfilling ", rule.Name, ": ", err)
+    os.Exit(1)
+  }
+  if *notify {
+    deliver(events)
+  }
+  setCheckpoint(rule.Name, until)
+  logger.Print("Backfilled ", len(events), " issues for ", rule.Name, " since ", since.Format(time.RFC3339))
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "strings"
+  "sync"
+  "time"
+)
+
+// a rule's targets are all sent to at once. a chain runs targets one after
+// the other instead, each step seeing what the ones before it returned, so
+// e.g. the jira comment only goes on once the pagerduty incident exists and
+// can say which it is. a step's `if` is a path into the results so far,
+// true when it's set and not false, empty or zero, and negated with !
+//
+//   rules:
+//     - name: sev1
+//       jql: priority = Highest
+//       chain:
+//         on: [created]          # the event kinds it runs for, created by default
+//         steps:
+//           - target: pagerduty  # a webhook, its json response is the result
+//             as: page           # .Results.page in later templates, the target's name by default
+//           - target: jira-note  # a jira-comment target
+//             if: page.ok
+//           - target: ops-slack
+//             if: "!page.ok"
+//
+// each result has ok, error when it failed, skipped when its condition
+// wasn't met, and response, what webhooks and jira comments answered, so
+// jira-note's template can use {{.Results.page.response.incident.id}}. the
+// chain's targets are sent to only by the chain, without fallbacks
+//
+// a step can wait before it runs and be retried when it fails:
+//
+//           - target: manager-pager
+//             delay: 15m     # after the step before it
+//             if: "!ack.ok"
+//             retry: 3       # more tries, 1m apart and doubling
+//             backoff: 1m
+//
+// a chain that is waiting is kept in the state, with what it has done and
+// when its next step is due, so it picks up where it was after a restart
+type RuleChain struct {
+  On    []string    `yaml:"on"`
+  Steps []ChainStep `yaml:"steps"`
+}
+
+type ChainStep struct {
+  Target  string `yaml:"target"`
+  As      string `yaml:"as"`
+  If      string `yaml:"if"`
+  Delay   string `yaml:"delay"`
+  Retry   int    `yaml:"retry"`
+  Backoff string `yaml:"backoff"`
+
+  delay, backoff time.Duration
+}
+
+// ChainRun is a chain part way through for an event, persisted in the
+// state until its last step has run
+type ChainRun struct {
+  Id      string                 `json:"id"`
+  Rule    string                 `json:"rule"`
+  EventId string                 `json:"event_id"`
+  Event   HistoryRecord          `json:"event"` // to rebuild the event from
+  Step    int                    `json:"step"`  // the next to run
+  Tries   int                    `json:"tries,omitempty"` // of that step so far
+  Due     time.Time              `json:"due,omitempty"`   // when it may run, zero until it's been delayed
+  Results map[string]interface{} `json:"results"`
+  Started time.Time              `json:"started"`
+}
+
+// with its own results, which a step adds to. each result is left alone
+// once it's in
+func (r *ChainRun) copied() ChainRun {
+  copied := *r
+  copied.Results = map[string]interface{}{}
+  for name, result := range r.Results {
+    copied.Results[name] = result
+  }
+  return copied
+}
+
+// how often waiting chains are checked for steps that are due
+const chainInterval = 10 * time.Second
+
+// the runs being advanced right now, so a run that's being delivered isn't
+// also picked up as due
+var chainsRunning = struct {
+  sync.Mutex
+  ids map[string]bool
+}{ids: map[string]bool{}}
+
+// a notifier that can say what the receiver answered, for chains
+type resultNotifier interface {
+  NotifyResult(event *Event, message string) (interface{}, error)
+}
+
+func (c *RuleChain) validate() error {
+  if len(c.Steps) == 0 {
+    if len(c.On) > 0 {
+      return fmt.Errorf("the chain has no steps")
+    }
+    return nil
+  }
+  if len(c.On) == 0 {
+    c.On = []string{eventCreated}
+  }
+  names := map[string]bool{}
+  for i := range c.Steps {
+    step := &c.Steps[i]
+    if len(step.Target) == 0 {
+      return fmt.Errorf("step %d of the chain has no target", i+1)
+    }
+    name := step.name()
+    var err error
+    if step.delay, err = parseDuration(step.Delay); len(step.Delay) > 0 && err != nil {
+      return fmt.Errorf("step %s has an invalid delay %q", name, step.Delay)
+    }
+    if step.backoff, err = parseDuration(step.Backoff); len(step.Backoff) > 0 && err != nil {
+      return fmt.Errorf("step %s has an invalid backoff %q", name, step.Backoff)
+    }
+    if step.backoff <= 0 {
+      step.backoff = time.Minute
+    }
+    if step.Retry < 0 {
+      return fmt.Errorf("step %s's retry can't be negative", name)
+    }
+    if names[name] {
+      return fmt.Errorf("two steps of the chain are called %s, give one an `as`", name)
+    }
+    if condition := strings.TrimPrefix(step.If, "!"); len(step.If) > 0 && !names[strings.SplitN(condition, ".", 2)[0]] {
+      return fmt.Errorf("step %s's if %q isn't about an earlier step", name, step.If)
+    }
+    names[name] = true
+  }
+  return nil
+}
+
+// that every step's target is configured
+func (c *RuleChain) checkTargets(creds *Config) error {
+  for _, step := range c.Steps {
+    if _, ok := creds.Targets[step.Target]; !ok {
+      return fmt.Errorf("step %s of the chain has an unknown target %s", step.name(), step.Target)
+    }
+  }
+  return nil
+}
+
+// take the chain's targets out of the event's, when the chain runs for
+// it, so they're only sent to by the chain
+func (event *Event) leaveTargetsToChain() {
+  if event.chain == nil || !contains(event.chain.On, event.Kind) {
+    return
+  }
+  kept := []string{}
+  for _, target := range event.Targets {
+    inChain := false
+    for _, step := range event.chain.Steps {
+      inChain = inChain || step.Target == target
+    }
+    if !inChain {
+      kept = append(kept, target)
+    }
+  }
+  event.Targets = kept
+}
+
+// the name of the step's result
+func (s ChainStep) name() string {
+  if len(s.As) > 0 {
+    return s.As
+  }
+  return s.Target
+}
+
+// the rule's chain, nil if it doesn't have one
+func (r *Rule) chain() *RuleChain {
+  if len(r.Chain.Steps) == 0 {
+    return nil
+  }
+  return &r.Chain
+}
+
+// start the event's chain if it has one for the event's kind
+func runChain(event *Event) {
+  if event.chain == nil || !contains(event.chain.On, event.Kind) {
+    return
+  }
+  record, err := historyRecord(event, time.Now())
+  if err != nil {
+    logger.Print("Error starting the chain of ", event.Rule, " for ", event.Issue.Key, ": ", err)
+    return
+  }
+  run := &ChainRun{
+    Id: newEventId(), Rule: event.Rule, EventId: event.Id, Event: record,
+    Results: map[string]interface{}{}, Started: time.Now(),
+  }
+  state.SetChainRun(run)
+  advanceChain(run.Id, event.chain, event)
+}
+
+// run the chain's steps from where the run is, until one has to wait or
+// they're all done. the run is saved after every step, so a restart never
+// repeats one that finished
+func advanceChain(id string, chain *RuleChain, event *Event) {
+  chainsRunning.Lock()
+  if chainsRunning.ids[id] {
+    chainsRunning.Unlock()
+    return
+  }
+  chainsRunning.ids[id] = true
+  chainsRunning.Unlock()
+  defer func() {
+    chainsRunning.Lock()
+    delete(chainsRunning.ids, id)
+    chainsRunning.Unlock()
+  }()
+  // what's saved, it may have moved on since the caller looked
+  saved, ok := state.ChainRun(id)
+  if !ok {
+    return
+  }
+  run := &saved
+
+  for run.Step < len(chain.Steps) {
+    step := chain.Steps[run.Step]
+    name := step.name()
+    if run.Tries == 0 && run.Due.IsZero() && step.delay > 0 {
+      run.Due = time.Now().Add(step.delay)
+      state.SetChainRun(run)
+      return
+    }
+    if time.Now().Before(run.Due) {
+      return
+    }
+
+    result := map[string]interface{}{}
+    if !chainCondition(step.If, run.Results) {
+      logger.Print("Skipping ", name, " for ", event.Issue.Key, ", ", step.If, " isn't true")
+      result = map[string]interface{}{"ok": false, "skipped": true}
+    } else if s, ok := sinks[step.Target]; !ok {
+      logger.Print("Unknown target ", step.Target, " in the chain of ", run.Rule)
+      result = map[string]interface{}{"ok": false, "error": "unknown target " + step.Target}
+    } else {
+      stepEvent := *event
+      stepEvent.Results = map[string]interface{}{}
+      for k, v := range run.Results {
+        stepEvent.Results[k] = v
+      }
+      response, err := s.result(&stepEvent)
+      result = map[string]interface{}{"ok": err == nil, "response": response}
+      if err != nil {
+        logger.Print("Error notifying ", s.name, " about ", event.Issue.Key, ": ", err)
+        result["error"] = err.Error()
+        if run.Tries < step.Retry {
+          run.Due = time.Now().Add(step.backoff << uint(run.Tries))
+          run.Tries++
+          state.SetChainRun(run)
+          return
+        }
+      }
+    }
+    run.Results[name] = result
+    run.Step, run.Tries, run.Due = run.Step+1, 0, time.Time{}
+    state.SetChainRun(run)
+  }
+  state.ForgetChainRun(run.Id)
+}
+
+// carry on with the waiting chains whose next step is due, including the
+// ones from before a restart. a run whose rule or chain is gone is dropped
+func resumeChains() {
+  for {
+    time.Sleep(chainInterval)
+    producers.RLock()
+
+    rules := map[string]*Rule{}
+    for _, rule := range configuredPollerRules() {
+      rules[rule.Name] = rule
+    }
+    for _, run := range state.ChainRuns() {
+      if time.Now().Before(run.Due) || !shards.follows(rules, run.Rule) {
+        continue
+      }
+      rule, ok := rules[run.Rule]
+      if !ok || rule.chain() == nil || run.Step >= len(rule.Chain.Steps) {
+        logger.Print("Dropping the chain of ", run.Rule, " for ", run.Event.Key, ", the rule's chain changed")
+        state.ForgetChainRun(run.Id)
+        continue
+      }
+      event, err := run.Event.event()
+      if err != nil {
+        logger.Print("Dropping the chain of ", run.Rule, " for ", run.Event.Key, ": ", err)
+        state.ForgetChainRun(run.Id)
+        continue
+      }
+      event.Id, event.chain = run.EventId, rule.chain()
+      advanceChain(run.Id, rule.chain(), event)
+    }
+    producers.RUnlock()
+  }
+}
+
+// whether a step's condition holds for the results so far
+func chainCondition(condition string, results map[string]interface{}) bool {
+  if len(condition) == 0 {
+    return true
+  }
+  negated := strings.HasPrefix(condition, "!")
+  value := fieldValue(results, strings.TrimPrefix(condition, "!"))
+  truthy := true
+  switch v := value.(type) {
+  case nil:
+    truthy = false
+  case bool:
+    truthy = v
+  case string:
+    truthy = len(v) > 0
+  case float64:
+    truthy = v != 0
+  case []interface{}:
+    truthy = len(v) > 0
+  case map[string]interface{}:
+    truthy = len(v) > 0
+  }
+  return truthy != negated
+}
+
+// send one event to this sink only, with what the receiver answered if it
+// says
+func (s *sink) result(event *Event) (interface{}, error) {
+  if failed, held := s.hold([]*Event{event}); held {
+    if len(failed) > 0 {
+      return nil, fmt.Errorf("%s couldn't hold the change to %s for review", s.name, event.Issue.Key)
+    }
+    return nil, nil
+  }
+  if !s.health.available() {
+    err := fmt.Errorf("%s is failing and skipped for now", s.name)
+    recordDelivery(s.name, event, err)
+    return nil, err
+  }
+  if len(s.visible([]*Event{event})) == 0 {
+    return nil, fmt.Errorf("%s's team can't see %s", s.name, event.Issue.Key)
+  }
+  if len(s.undisturbed([]*Event{event})) == 0 {
+    return nil, fmt.Errorf("%s's owner doesn't want %s now", s.name, event.Issue.Key)
+  }
+  sent := s.target.Fields.redact(event)
+  started := time.Now()
+  message := s.message(sent)
+  var response interface{}
+  var err error
+  if r, ok := s.notifier.(resultNotifier); ok {
+    response, err = r.NotifyResult(sent, message)
+  } else {
+    err = s.notifier.Notify(sent, message)
+  }
+  s.recordHealth(err)
+  recordDelivery(s.name, event, err)
+  pipeline.delivery(s, event, sent, message, err)
+  if err == nil {
+    checkLatencyBudget(s.name, event, started)
+  }
+  return response, err
+}
+
+// a response as json if it is, or as text
+func parseResponse(contents []byte) interface{} {
+  if len(contents) == 0 {
+    return nil
+  }
+  var value interface{}
+  if err := json.Unmarshal(contents, &value); err != nil {
+    return string(contents)
+  }
+  return value
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+  "bytes"
+  "fmt"
+  "io/ioutil"
+  "math/rand"
+  "net/http"
+  "strings"
+  "sync"
+  "time"
+)
+
+// fault injection for trying out how the tracker copes with a misbehaving
+// jira before production. it only takes effect with --chaos, using the
+// rates under `chaos` (or these defaults):
+//
+//   chaos:
+//     error_rate: 0.1      # requests failing outright
+//     status_rate: 0.1     # requests answered with a 503
+//     slow_rate: 0.1       # requests delayed by `slow`
+//     slow: 5s
+//     malformed_rate: 0.05 # successful responses with their json cut short
+type ChaosConfig struct {
+  ErrorRate     float64 `yaml:"error_rate"`
+  StatusRate    float64 `yaml:"status_rate"`
+  SlowRate      float64 `yaml:"slow_rate"`
+  Slow          string  `yaml:"slow"`
+  MalformedRate float64 `yaml:"malformed_rate"`
+}
+
+var defaultChaos = ChaosConfig{
+  ErrorRate:     0.1,
+  StatusRate:    0.1,
+  SlowRate:      0.1,
+  Slow:          "5s",
+  MalformedRate: 0.05,
+}
+
+type chaosTransport struct {
+  next   http.RoundTripper
+  config ChaosConfig
+  slow   time.Duration
+
+  // the transport is used from several goroutines but math/rand's sources
+  // aren't safe for that
+  mu   sync.Mutex
+  rand *rand.Rand
+}
+
+func withChaos(client *http.Client, c ChaosConfig) *http.Client {
+  if c == (ChaosConfig{}) {
+    c = defaultChaos
+  }
+  logger.Print(fmt.Sprintf("CHAOS MODE: failing %.0f%%, 503ing %.0f%%, slowing %.0f%% and corrupting %.0f%% of jira requests",
+    c.ErrorRate*100, c.StatusRate*100, c.SlowRate*100, c.MalformedRate*100))
+
+  next := client.Transport
+  if next == nil {
+    next = http.DefaultTransport
+  }
+  return &http.Client{
+    Transport: &chaosTransport{
+      next:   next,
+      config: c,
+      slow:   durationOr(c.Slow, 5*time.Second),
+      rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+    },
+    Timeout: client.Timeout,
+  }
+}
+
+func (t *chaosTransport) roll(rate float64) bool {
+  t.mu.Lock()
+  defer t.mu.Unlock()
+  return t.rand.Float64() < rate
+}
+
+func (t *chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+  if t.roll(t.config.SlowRate) {
+    logger.Print("CHAOS: delaying ", req.Method, " ", req.URL.Path, " by ", t.slow)
+    select {
+    case <-time.After(t.slow):
+    case <-req.Context().Done():
+      return nil, req.Context().Err()
+    }
+  }
+  if t.roll(t.config.ErrorRate) {
+    logger.Print("CHAOS: failing ", req.Method, " ", req.URL.Path)
+    return nil, fmt.Errorf("chaos: injected connection failure")
+  }
+  if t.roll(t.config.StatusRate) {
+    logger.Print("CHAOS: answering ", req.Method, " ", req.URL.Path, " with a 503")
+    return &http.Response{
+      Status:     "503 Service Unavailable",
+      StatusCode: http.StatusServiceUnavailable,
+      Proto:      "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+      Header:     http.Header{"Content-Type": {"text/plain"}},
+      Body:       ioutil.NopCloser(strings.NewReader("chaos: injected outage")),
+      Request:    req,
+    }, nil
+  }
+
+  resp, err := t.next.RoundTrip(req)
+  if err != nil || resp.StatusCode >= 300 || !t.roll(t.config.MalformedRate) {
+    return resp, err
+  }
+
+  logger.Print("CHAOS: corrupting the response to ", req.Method, " ", req.URL.Path)
+  contents, err := ioutil.ReadAll(resp.Body)
+  resp.Body.Close()
+  if err != nil {
+    return nil, err
+  }
+  contents = contents[:len(contents)/2]
+  resp.Body = ioutil.NopCloser(bytes.NewReader(contents))
+  resp.ContentLength = int64(len(contents))
+  resp.Header.Del("Content-Length")
+  resp.Header.Del("ETag") // don't let a corrupt body be served on 304s
+  resp.Header.Del("Last-Modified")
+  return resp, nil
+}
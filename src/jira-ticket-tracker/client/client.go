@@ -0,0 +1,304 @@
+// Package client talks to the control API of a running jira-ticket-tracker,
+// the one it serves with --listen, so tools can script against trackers:
+//
+//   c := client.New("http://tracker:8080")
+//   rules, err := c.Rules(ctx)
+//   err = c.Pause(ctx, "ops-from-jsmith")
+//   err = c.Events(ctx, client.EventFilter{Kind: "created"}, func(e client.Event) error {
+//     fmt.Println(e.Key, e.Summary)
+//     return nil
+//   })
+//
+// it only needs the standard library, so it can be vendored on its own
+package client
+
+import (
+  "bufio"
+  "bytes"
+  "context"
+  "encoding/json"
+  "fmt"
+  "io"
+  "net/http"
+  "net/url"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// Client calls one tracker. the zero HTTP uses http.DefaultClient. Token
+// is sent as a bearer token if set, e.g. the operator's token for Pause,
+// Resume and Replay, a mobile target's registration secret or a do not
+// disturb token
+type Client struct {
+  BaseURL string
+  HTTP    *http.Client
+  Token   string
+}
+
+func New(baseURL string) *Client {
+  return &Client{BaseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// Error is a response from the tracker that wasn't a success
+type Error struct {
+  Status  int
+  Message string
+}
+
+func (e *Error) Error() string {
+  return fmt.Sprintf("%d %s: %s", e.Status, http.StatusText(e.Status), e.Message)
+}
+
+// Rule is how a rule's polling has gone since the tracker started
+type Rule struct {
+  Rule        string     `json:"rule"`
+  Healthy     bool       `json:"healthy"`
+  LastPoll    *time.Time `json:"last_poll,omitempty"`
+  LastMatch   *time.Time `json:"last_match,omitempty"`
+  NextPoll    *time.Time `json:"next_poll,omitempty"`
+  ErrorStreak int        `json:"error_streak"`
+  LastError   string     `json:"last_error,omitempty"`
+  Paused      bool       `json:"paused,omitempty"`
+}
+
+// Paused is what's paused, every rule or the ones listed
+type Paused struct {
+  All   bool     `json:"all"`
+  Rules []string `json:"rules"`
+}
+
+// Event is an event the tracker delivered
+type Event struct {
+  Time     time.Time         `json:"time"`
+  Kind     string            `json:"kind"`
+  Rule     string            `json:"rule,omitempty"`
+  Key      string            `json:"key"`
+  Summary  string            `json:"summary"`
+  Detail   string            `json:"detail,omitempty"`
+  Targets  []string          `json:"targets"`
+  Computed map[string]string `json:"computed,omitempty"`
+}
+
+// EventFilter narrows a stream of events, empty fields match everything
+type EventFilter struct {
+  Rule string
+  Kind string
+}
+
+// Replay picks the recorded events to send again. To is now if zero, and
+// Targets are the original ones if empty
+type Replay struct {
+  From    time.Time
+  To      time.Time
+  Rule    string
+  Kind    string
+  Targets []string
+  DryRun  bool
+}
+
+// Replayed is an event a replay sent, or would have
+type Replayed struct {
+  Kind    string   `json:"kind"`
+  Key     string   `json:"key"`
+  Targets []string `json:"targets"`
+}
+
+func (c *Client) httpClient() *http.Client {
+  if c.HTTP != nil {
+    return c.HTTP
+  }
+  return http.DefaultClient
+}
+
+func (c *Client) request(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+  var reader io.Reader
+  if body != nil {
+    contents, err := json.Marshal(body)
+    if err != nil {
+      return nil, err
+    }
+    reader = bytes.NewReader(contents)
+  }
+  req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+  if err != nil {
+    return nil, err
+  }
+  if body != nil {
+    req.Header.Set("Content-Type", "application/json")
+  }
+  if len(c.Token) > 0 {
+    req.Header.Set("Authorization", "Bearer "+c.Token)
+  }
+  resp, err := c.httpClient().Do(req)
+  if err != nil {
+    return nil, err
+  }
+  if resp.StatusCode >= 300 {
+    defer resp.Body.Close()
+    var apiErr struct {
+      Error string `json:"error"`
+    }
+    json.NewDecoder(resp.Body).Decode(&apiErr)
+    return nil, &Error{resp.StatusCode, apiErr.Error}
+  }
+  return resp, nil
+}
+
+func (c *Client) call(ctx context.Context, method, path string, body, out interface{}) error {
+  resp, err := c.request(ctx, method, path, body)
+  if err != nil {
+    return err
+  }
+  defer resp.Body.Close()
+  if out == nil {
+    io.Copy(io.Discard, resp.Body)
+    return nil
+  }
+  return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Rules lists the rules being polled and how their polling is going
+func (c *Client) Rules(ctx context.Context) ([]Rule, error) {
+  var rules []Rule
+  err := c.call(ctx, "GET", "/status", nil, &rules)
+  return rules, err
+}
+
+// Poll polls every rule now
+func (c *Client) Poll(ctx context.Context) error {
+  return c.call(ctx, "POST", "/poll", nil, nil)
+}
+
+// Pause stops a rule polling until it's resumed, every rule if it's empty.
+// what was created meanwhile is picked up once it is
+func (c *Client) Pause(ctx context.Context, rule string) error {
+  return c.call(ctx, "POST", "/pause", map[string]string{"rule": rule}, nil)
+}
+
+// Resume resumes a paused rule, or everything if it's empty
+func (c *Client) Resume(ctx context.Context, rule string) error {
+  return c.call(ctx, "DELETE", "/pause", map[string]string{"rule": rule}, nil)
+}
+
+// Paused says what's paused
+func (c *Client) Paused(ctx context.Context) (Paused, error) {
+  var paused Paused
+  err := c.call(ctx, "GET", "/pause", nil, &paused)
+  return paused, err
+}
+
+// Replay sends recorded events again, returning what was sent
+func (c *Client) Replay(ctx context.Context, r Replay) ([]Replayed, error) {
+  body := map[string]interface{}{"from": r.From, "rule": r.Rule, "kind": r.Kind, "targets": r.Targets, "dry_run": r.DryRun}
+  if !r.To.IsZero() {
+    body["to"] = r.To
+  }
+  var replayed []Replayed
+  err := c.call(ctx, "POST", "/replay", body, &replayed)
+  return replayed, err
+}
+
+// Subscriptions lists the targets subscribed to each issue
+func (c *Client) Subscriptions(ctx context.Context) (map[string][]string, error) {
+  var subscriptions map[string][]string
+  err := c.call(ctx, "GET", "/subscriptions", nil, &subscriptions)
+  return subscriptions, err
+}
+
+// Subscribe sends a target an issue's changes
+func (c *Client) Subscribe(ctx context.Context, key, target string) error {
+  return c.call(ctx, "POST", "/subscriptions", map[string]string{"key": key, "target": target}, nil)
+}
+
+// Unsubscribe stops sending a target an issue's changes
+func (c *Client) Unsubscribe(ctx context.Context, key, target string) error {
+  return c.call(ctx, "DELETE", "/subscriptions", map[string]string{"key": key, "target": target}, nil)
+}
+
+// Device is a phone registered for a mobile target's pushes. the tracker
+// only lists the end of its Token
+type Device struct {
+  Target     string    `json:"target"`
+  Id         string    `json:"id"`
+  Platform   string    `json:"platform"` // ios or android
+  Token      string    `json:"token"`
+  Name       string    `json:"name,omitempty"`
+  Registered time.Time `json:"registered"`
+}
+
+// Devices lists the phones registered for a mobile target, every one with
+// an empty target
+func (c *Client) Devices(ctx context.Context, target string) ([]Device, error) {
+  var devices []Device
+  err := c.call(ctx, "GET", "/devices?target="+url.QueryEscape(target), nil, &devices)
+  return devices, err
+}
+
+// RegisterDevice registers a phone, or updates its token. it takes the
+// target's registration secret as the Token
+func (c *Client) RegisterDevice(ctx context.Context, d Device) error {
+  return c.call(ctx, "POST", "/devices", d, nil)
+}
+
+// UnregisterDevice stops pushing to a phone
+func (c *Client) UnregisterDevice(ctx context.Context, target, id string) error {
+  return c.call(ctx, "DELETE", "/devices", map[string]string{"target": target, "id": id}, nil)
+}
+
+// GrepHit is an issue found in the tracker's full-text index
+type GrepHit struct {
+  Key       string    `json:"key"`
+  Project   string    `json:"project"`
+  Summary   string    `json:"summary"`
+  Status    string    `json:"status"`
+  Seen      time.Time `json:"seen"`
+  Score     float64   `json:"score"`
+  Fragments []string  `json:"fragments"`
+}
+
+// Grep searches the text of every issue the tracker has seen for a phrase,
+// in one project if it isn't empty
+func (c *Client) Grep(ctx context.Context, text, project string, limit int) ([]GrepHit, error) {
+  query := url.Values{"q": {text}, "project": {project}, "limit": {strconv.Itoa(limit)}}
+  var hits []GrepHit
+  err := c.call(ctx, "GET", "/grep?"+query.Encode(), nil, &hits)
+  return hits, err
+}
+
+// Events calls fn with each event the tracker delivers until ctx is done,
+// fn returns an error, or the tracker ends the stream. the tracker ends it
+// for clients that fall too far behind, so fn shouldn't block for long
+func (c *Client) Events(ctx context.Context, filter EventFilter, fn func(Event) error) error {
+  query := url.Values{}
+  if len(filter.Rule) > 0 {
+    query.Set("rule", filter.Rule)
+  }
+  if len(filter.Kind) > 0 {
+    query.Set("kind", filter.Kind)
+  }
+  path := "/events"
+  if len(query) > 0 {
+    path += "?" + query.Encode()
+  }
+  resp, err := c.request(ctx, "GET", path, nil)
+  if err != nil {
+    return err
+  }
+  defer resp.Body.Close()
+  scanner := bufio.NewScanner(resp.Body)
+  scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+  for scanner.Scan() {
+    var event Event
+    if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+      return err
+    }
+    if err := fn(event); err != nil {
+      return err
+    }
+  }
+  if ctx.Err() != nil {
+    return ctx.Err()
+  }
+  return scanner.Err()
+}
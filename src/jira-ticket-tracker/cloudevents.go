@@ -0,0 +1,132 @@
+package main
+
+import (
+  "fmt"
+  "os"
+  "strings"
+  "time"
+)
+
+const envelopeCloudEvents = "cloudevents"
+
+// wrapping outbound events in a cloudevents 1.0 envelope, for consumers
+// like knative or eventbridge that route on it. a target opts in with
+// `envelope: cloudevents`, or every webhook does with all. structured
+// mode posts the envelope with the payload as its data, binary mode posts
+// the payload with the envelope in ce- headers
+//
+//   cloudevents:
+//     all: true
+//     source: https://jira.acme.com    # the jira url by default
+//     type_prefix: com.acme.jira.      # the types are PREFIX+kind
+//     mode: structured                 # or binary
+type CloudEventsConfig struct {
+  All        bool   `yaml:"all"`
+  Source     string `yaml:"source"`
+  TypePrefix string `yaml:"type_prefix"`
+  Mode       string `yaml:"mode"`
+}
+
+var cloudEvents CloudEventsConfig
+
+func loadCloudEvents(creds *Config) {
+  cloudEvents = creds.CloudEvents
+  if len(cloudEvents.Source) == 0 {
+    cloudEvents.Source = strings.TrimSuffix(creds.Url, "/rest/api/2")
+  }
+  if len(cloudEvents.TypePrefix) == 0 {
+    cloudEvents.TypePrefix = "com.github.sk8erwitskil.jira-ticket-tracker."
+  }
+  switch cloudEvents.Mode {
+  case "":
+    cloudEvents.Mode = "structured"
+  case "structured", "binary":
+  default:
+    logger.Print("Unknown cloudevents.mode ", cloudEvents.Mode, ", it can be structured or binary")
+    os.Exit(1)
+  }
+}
+
+func targetEnvelope(name string, target Target) string {
+  switch target.Envelope {
+  case "":
+    if cloudEvents.All {
+      return envelopeCloudEvents
+    }
+    return ""
+  case envelopeCloudEvents:
+    return envelopeCloudEvents
+  case "none":
+    return ""
+  }
+  logger.Print("Target ", name, " has envelope ", target.Envelope, ", it can be cloudevents or none")
+  os.Exit(1)
+  return ""
+}
+
+// CloudEvent is the structured mode envelope
+type CloudEvent struct {
+  SpecVersion     string      `json:"specversion"`
+  Id              string      `json:"id"`
+  Source          string      `json:"source"`
+  Type            string      `json:"type"`
+  Subject         string      `json:"subject,omitempty"`
+  Time            time.Time   `json:"time"`
+  DataContentType string      `json:"datacontenttype"`
+  DataSchema      string      `json:"dataschema"`
+  Data            interface{} `json:"data"`
+}
+
+func newCloudEvent(event *Event, payload interface{}, version int) CloudEvent {
+  id := event.Id
+  if len(id) == 0 {
+    id = newEventId()
+  }
+  return CloudEvent{
+    SpecVersion:     "1.0",
+    Id:              id,
+    Source:          cloudEvents.Source,
+    Type:            cloudEvents.TypePrefix + event.Kind,
+    Subject:         event.Issue.Key,
+    Time:            time.Now().UTC(),
+    DataContentType: "application/json",
+    DataSchema:      fmt.Sprintf("%sv%d/event.json", schemaBase, version),
+    Data:            payload,
+  }
+}
+
+func (n *webhookNotifier) postCloudEvent(event *Event, payload interface{}) error {
+  ce := newCloudEvent(event, payload, n.version)
+  if cloudEvents.Mode == "structured" {
+    return n.post("application/cloudevents+json", nil, ce)
+  }
+  headers := map[string]string{
+    "ce-specversion": ce.SpecVersion,
+    "ce-id":          ce.Id,
+    "ce-source":      ce.Source,
+    "ce-type":        ce.Type,
+    "ce-subject":     ce.Subject,
+    "ce-time":        ce.Time.Format(time.RFC3339Nano),
+    "ce-dataschema":  ce.DataSchema,
+  }
+  return n.post(ce.DataContentType, headers, payload)
+}
+
+// structured mode posts a batch in one call, binary mode has no batches so
+// each event is posted on its own. if one fails the batch is retried, and
+// receivers can drop the ones they already have by their ids
+func (n *webhookNotifier) postCloudEvents(events []*Event, payloads []interface{}) error {
+  if cloudEvents.Mode == "structured" {
+    batch := make([]CloudEvent, len(events))
+    for i, event := range events {
+      batch[i] = newCloudEvent(event, payloads[i], n.version)
+    }
+    return n.post("application/cloudevents-batch+json", nil, batch)
+  }
+  for i, event := range events {
+    if err := n.postCloudEvent(event, payloads[i]); err != nil {
+      return err
+    }
+  }
+  return nil
+}
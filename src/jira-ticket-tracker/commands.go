@@ -0,0 +1,123 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "os"
+  "sort"
+  "strings"
+)
+
+// a command is run instead of the tracker when its name is given after the
+// flags, e.g. ./jira-ticket-tracker --api=http://tracker:8080 subscriptions
+type command struct {
+  usage string
+  run   func(args []string)
+}
+
+// commands are registered by name from the init functions of the files
+// implementing them
+var commands = map[string]command{}
+
+func init() {
+  commands["subscribe"] = command{"subscribe KEY TARGET", subscribeCommand}
+  commands["unsubscribe"] = command{"unsubscribe KEY TARGET", unsubscribeCommand}
+  commands["subscriptions"] = command{"subscriptions", subscriptionsCommand}
+}
+
+func runCommand(args []string) {
+  cmd, ok := commands[args[0]]
+  if !ok {
+    names := []string{}
+    for _, c := range commands {
+      names = append(names, c.usage)
+    }
+    sort.Strings(names)
+    logger.Print("Unknown command ", args[0], ", expected one of:\n  ", strings.Join(names, "\n  "))
+    os.Exit(1)
+  }
+  cmd.run(args[1:])
+}
+
+func usageExit(usage string) {
+  logger.Print("Usage: ", os.Args[0], " [flags] ", usage)
+  os.Exit(1)
+}
+
+// call the control API of a running tracker and decode the response into out
+func callAPI(method, path string, body, out interface{}) error {
+  var reader *bytes.Reader
+  if body != nil {
+    contents, err := json.Marshal(body)
+    if err != nil {
+      return err
+    }
+    reader = bytes.NewReader(contents)
+  } else {
+    reader = bytes.NewReader(nil)
+  }
+
+  req, err := http.NewRequest(method, strings.TrimRight(*apiUrl, "/")+path, reader)
+  if err != nil {
+    return err
+  }
+  req.Header.Set("Content-Type", "application/json")
+  if len(*apiToken) > 0 {
+    req.Header.Set("Authorization", "Bearer "+*apiToken)
+  }
+  resp, err := http.DefaultClient.Do(req)
+  if err != nil {
+    return err
+  }
+  defer resp.Body.Close()
+
+  if resp.StatusCode >= 300 {
+    var apiErr struct {
+      Error string `json:"error"`
+    }
+    json.NewDecoder(resp.Body).Decode(&apiErr)
+    return fmt.Errorf("%s: %s", resp.Status, apiErr.Error)
+  }
+  if out != nil {
+    return json.NewDecoder(resp.Body).Decode(out)
+  }
+  return nil
+}
+
+func subscribeCommand(args []string) {
+  if len(args) != 2 {
+    usageExit(commands["subscribe"].usage)
+  }
+  if err := callAPI("POST", "/subscriptions", subscription{args[0], args[1]}, nil); err != nil {
+    logger.Print("Error subscribing: ", err)
+    os.Exit(1)
+  }
+}
+
+func unsubscribeCommand(args []string) {
+  if len(args) != 2 {
+    usageExit(commands["unsubscribe"].usage)
+  }
+  if err := callAPI("DELETE", "/subscriptions", subscription{args[0], args[1]}, nil); err != nil {
+    logger.Print("Error unsubscribing: ", err)
+    os.Exit(1)
+  }
+}
+
+func subscriptionsCommand(args []string) {
+  var subs map[string][]string
+  if err := callAPI("GET", "/subscriptions", nil, &subs); err != nil {
+    logger.Print("Error listing subscriptions: ", err)
+    os.Exit(1)
+  }
+  keys := []string{}
+  for key := range subs {
+    keys = append(keys, key)
+  }
+  sort.Strings(keys)
+  for _, key := range keys {
+    fmt.Printf("%s\t%s\n", key, strings.Join(subs[key], ", "))
+  }
+}
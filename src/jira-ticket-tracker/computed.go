@@ -0,0 +1,80 @@
+package main
+
+import (
+  "bytes"
+  "os"
+  "sort"
+  "strings"
+  "text/template"
+)
+
+// computed fields are config-defined values derived from the raw issue
+// fields, attached to every event as Event.Computed and usable in routing,
+// templates and the webhook/csv payloads. each one is a template given the
+// raw fields, with the same functions as the message templates:
+//
+//   computed:
+//     team: '{{ .customfield_10100.value | default "unassigned" }}'
+//     area: '{{ .components | len }}'
+var computedFields = map[string]*template.Template{}
+
+func loadComputedFields(creds *Config) {
+  funcs := templateFuncs(creds)
+  for name, expr := range creds.Computed {
+    t, err := template.New(name).Funcs(funcs).Parse(expr)
+    if err != nil {
+      logger.Print("Invalid computed field ", name, ": ", err)
+      os.Exit(1)
+    }
+    computedFields[name] = t
+  }
+}
+
+func computeFields(fields map[string]interface{}) map[string]string {
+  if len(computedFields) == 0 {
+    return nil
+  }
+  data := withoutNulls(fields)
+  computed := map[string]string{}
+  for name, t := range computedFields {
+    var out bytes.Buffer
+    if err := t.Execute(&out, data); err != nil {
+      logger.Print("Error computing field ", name, ": ", err)
+      continue
+    }
+    value := strings.TrimSpace(out.String())
+    if value == "<no value>" {
+      value = ""
+    }
+    computed[name] = value
+  }
+  return computed
+}
+
+// a copy of the fields with the json nulls removed. unset custom fields are
+// null and templates can't walk into {{.customfield_10100.value}} through
+// a nil, while a missing key just gives no value
+func withoutNulls(fields map[string]interface{}) map[string]interface{} {
+  copied := map[string]interface{}{}
+  for k, v := range fields {
+    switch value := v.(type) {
+    case nil:
+      continue
+    case map[string]interface{}:
+      copied[k] = withoutNulls(value)
+    default:
+      copied[k] = v
+    }
+  }
+  return copied
+}
+
+// the computed field names in a stable order, for columns in exports
+func computedFieldNames() []string {
+  names := []string{}
+  for name := range computedFields {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  return names
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "sync"
+)
+
+// how many responses are remembered for conditional requests
+const conditionalCacheSize = 2000
+
+type conditionalEntry struct {
+  etag         string
+  lastModified string
+  contents     []byte
+}
+
+// the validators and bodies of previous GETs by consumer and uri, see
+// conditionalKey
+var conditionalCache = struct {
+  sync.Mutex
+  entries map[string]*conditionalEntry
+}{entries: map[string]*conditionalEntry{}}
+
+// the validators are kept apart for each consumer and instance, so one
+// consumer fetching a change doesn't make it look unchanged to the others
+func conditionalKey(consumer, uri string, creds *Config) string {
+  return consumer + " " + creds.Url + uri
+}
+
+// GET a resource sending If-None-Match/If-Modified-Since from the consumer's
+// last response, where jira gave us an ETag or Last-Modified. on a 304 the
+// remembered body is returned with changed false, so callers can skip
+// processing it and we don't download it again
+func jiraConditional(consumer, uri string, creds *Config) (contents []byte, changed bool, err error) {
+  req, err := newJiraRequest("GET", uri, nil, creds)
+  if err != nil {
+    return nil, false, err
+  }
+
+  key := conditionalKey(consumer, uri, creds)
+  conditionalCache.Lock()
+  cached := conditionalCache.entries[key]
+  conditionalCache.Unlock()
+  if cached != nil {
+    if len(cached.etag) > 0 {
+      req.Header.Set("If-None-Match", cached.etag)
+    }
+    if len(cached.lastModified) > 0 {
+      req.Header.Set("If-Modified-Since", cached.lastModified)
+    }
+  }
+
+  resp, err := sendJiraRequest(req, creds)
+  if err != nil {
+    return nil, false, fmt.Errorf("Error calling %s: %v", req.URL, err)
+  }
+  defer drainAndClose(resp.Body)
+
+  if resp.StatusCode == http.StatusNotModified && cached != nil {
+    return cached.contents, false, nil
+  }
+  if resp.StatusCode >= 300 {
+    return nil, false, fmt.Errorf("GET %s returned %s", req.URL, resp.Status)
+  }
+  contents, err = ioutil.ReadAll(resp.Body)
+  if err != nil {
+    return nil, false, fmt.Errorf("Unable to read body contents: %v", err)
+  }
+
+  etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+  conditionalCache.Lock()
+  if len(etag) > 0 || len(lastModified) > 0 {
+    if len(conditionalCache.entries) >= conditionalCacheSize {
+      // no need for anything clever, just make room
+      for k := range conditionalCache.entries {
+        delete(conditionalCache.entries, k)
+        if len(conditionalCache.entries) < conditionalCacheSize/2 {
+          break
+        }
+      }
+    }
+    conditionalCache.entries[key] = &conditionalEntry{etag, lastModified, contents}
+  } else {
+    delete(conditionalCache.entries, key) // the resource doesn't support it
+  }
+  conditionalCache.Unlock()
+  return contents, true, nil
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "github.com/plouc/go-jira-client"
+  "net/url"
+  "os"
+  "regexp"
+  "strings"
+  "sync"
+  "time"
+)
+
+// issues on other jira instances that an issue refers to, while a team
+// works across two of them, e.g. during a server to cloud migration. keys
+// of the other instance's projects mentioned in the summary or description,
+// or in a remote link to it, are looked up there, and with `search` the
+// other instance is searched for issues that mention this one's key. what's
+// found is attached to the issue's events as correlations
+//
+//   correlation:
+//     instances:
+//       - name: cloud
+//         url: https://acme.atlassian.net/rest/api/2
+//         login: tracker@acme.com
+//         password: ...
+//         projects: [OPS, PAY]  # the keys to look for, all of its projects by default
+//         search: true          # also find its issues that mention ours
+type CorrelationConfig struct {
+  Instances []CorrelatedInstance `yaml:"instances"`
+}
+
+type CorrelatedInstance struct {
+  Name     string   `yaml:"name"`
+  Url      string   `yaml:"url"`
+  Login    string   `yaml:"login"`
+  Password string   `yaml:"password"`
+  Auth     []AuthStep `yaml:"auth"` // see AuthStep, basic with the login by default
+  Projects []string `yaml:"projects"`
+  Search   bool     `yaml:"search"`
+}
+
+// Correlation is an issue on another instance that an event's issue is
+// related to
+type Correlation struct {
+  Instance string `json:"instance"`
+  Key      string `json:"key"`
+  Summary  string `json:"summary"`
+  Status   string `json:"status,omitempty"`
+  Url      string `json:"url"`
+  Via      string `json:"via"` // summary, description, link or mention
+}
+
+const (
+  correlationCacheTTL = 10 * time.Minute
+  // how long an event waits for its issue's correlations. a slower lookup
+  // goes on without it, and its result is cached for the issue's next events
+  correlationWait = 2 * time.Second
+  // how long an instance whose projects couldn't be listed is left alone
+  correlationFailureTTL = time.Minute
+)
+
+var issueKeyPattern = regexp.MustCompile(`\b[A-Z][A-Z0-9_]+-[0-9]+\b`)
+
+type correlator struct {
+  instances []*correlatedInstance
+  creds     *Config // this instance's, for the remote links
+
+  mu      sync.Mutex
+  issues  map[string]cachedCorrelations   // issue key -> what it's correlated with
+  pending map[string]*correlationLookup // issue key -> its lookup under way
+}
+
+// a lookup of an issue's correlations, found is set once done is closed
+type correlationLookup struct {
+  done  chan struct{}
+  found []Correlation
+}
+
+type correlatedInstance struct {
+  CorrelatedInstance
+  creds  *Config
+  browse string // the base of its issues' urls
+
+  mu         sync.Mutex
+  projects   map[string]bool // nil until listed
+  listFailed time.Time       // when listing them last failed
+}
+
+type cachedCorrelations struct {
+  correlations []Correlation
+  fetched      time.Time
+}
+
+// nil when no other instances are configured
+var correlations *correlator
+
+func loadCorrelation(creds *Config) {
+  if len(creds.Correlation.Instances) == 0 {
+    return
+  }
+  c := &correlator{creds: creds, issues: map[string]cachedCorrelations{}, pending: map[string]*correlationLookup{}}
+  for _, instance := range creds.Correlation.Instances {
+    if len(instance.Name) == 0 || len(instance.Url) == 0 {
+      logger.Print("Invalid correlation instance, it needs a name and a url")
+      os.Exit(1)
+    }
+    i := &correlatedInstance{
+      CorrelatedInstance: instance,
+      creds:              &Config{Login: instance.Login, Password: instance.Password, Url: strings.TrimSuffix(instance.Url, "/")},
+      browse:             strings.TrimSuffix(strings.TrimSuffix(instance.Url, "/"), "/rest/api/2") + "/browse/",
+    }
+    i.creds.auth = loadAuthChain(instance.Auth, "correlation instance "+instance.Name)
+    if len(instance.Projects) > 0 {
+      i.projects = map[string]bool{}
+      for _, project := range instance.Projects {
+        i.projects[strings.ToUpper(project)] = true
+      }
+    }
+    c.instances = append(c.instances, i)
+  }
+  correlations = c
+}
+
+// the issue's correlations, cached for a while. they're looked up in the
+// background, one lookup for an issue at a time, and waited on for up to
+// correlationWait, going on with the stale ones if any
+func (c *correlator) find(issue *gojira.Issue, fields map[string]interface{}) []Correlation {
+  if c == nil || issue == nil || issue.Fields == nil {
+    return nil
+  }
+  c.mu.Lock()
+  cached, ok := c.issues[issue.Key]
+  if ok && time.Since(cached.fetched) < correlationCacheTTL {
+    c.mu.Unlock()
+    return cached.correlations
+  }
+  lookup, running := c.pending[issue.Key]
+  if !running {
+    lookup = &correlationLookup{done: make(chan struct{})}
+    c.pending[issue.Key] = lookup
+    go func() {
+      found, complete := c.lookup(issue, fields)
+      c.mu.Lock()
+      if complete {
+        c.issues[issue.Key] = cachedCorrelations{found, time.Now()}
+      }
+      delete(c.pending, issue.Key)
+      c.mu.Unlock()
+      lookup.found = found
+      close(lookup.done)
+    }()
+  }
+  c.mu.Unlock()
+
+  select {
+  case <-lookup.done:
+    return lookup.found
+  case <-time.After(correlationWait):
+    logger.Print("Looking up the correlations of ", issue.Key, " is slow, going on without them")
+    return cached.correlations
+  }
+}
+
+// the urls of an issue's remote links
+func remoteLinks(key string, creds *Config) []string {
+  contents, err := jiraRequest("GET", "/issue/"+key+"/remotelink", nil, creds)
+  if err != nil {
+    logger.Print("Error fetching the remote links of ", key, ": ", err)
+    return nil
+  }
+  var links []struct {
+    Object struct {
+      Url string `json:"url"`
+    } `json:"object"`
+  }
+  if err := json.Unmarshal(contents, &links); err != nil {
+    logger.Print("Error parsing the remote links of ", key, ": ", err)
+    return nil
+  }
+  urls := []string{}
+  for _, link := range links {
+    urls = append(urls, link.Object.Url)
+  }
+  return urls
+}
+
+// look up the issue's correlations on every instance. complete is false
+// if an instance was skipped, so they aren't cached
+func (c *correlator) lookup(issue *gojira.Issue, fields map[string]interface{}) ([]Correlation, bool) {
+  found := []Correlation{}
+  seen := map[string]bool{}
+  skipped := false // instances over their budget's degrade_at, see quota.go
+  add := func(i *correlatedInstance, key, via string) {
+    if seen[i.Name+":"+key] {
+      return
+    }
+    seen[i.Name+":"+key] = true
+    correlation, err := i.lookup(key)
+    if err != nil {
+      return // most often a key that isn't the other instance's
+    }
+    correlation.Via = via
+    found = append(found, correlation)
+  }
+  links := []string{}
+  if quota.degraded(c.creds.Url) {
+    skipped = true
+  } else if len(c.instances) > 0 {
+    links = remoteLinks(issue.Key, c.creds)
+  }
+  for _, i := range c.instances {
+    if quota.degraded(i.creds.Url) {
+      skipped = true
+      continue
+    }
+    for _, key := range issueKeyPattern.FindAllString(issue.Fields.Summary, -1) {
+      if key != issue.Key && i.hasProject(key) {
+        add(i, key, "summary")
+      }
+    }
+    for _, key := range issueKeyPattern.FindAllString(fieldString(fields, "description"), -1) {
+      if key != issue.Key && i.hasProject(key) {
+        add(i, key, "description")
+      }
+    }
+    for _, link := range links {
+      if strings.HasPrefix(link, i.browse) {
+        add(i, strings.TrimPrefix(link, i.browse), "link")
+      }
+    }
+    if i.Search {
+      for _, key := range i.mentions(issue.Key) {
+        add(i, key, "mention")
+      }
+    }
+  }
+
+  return found, !skipped
+}
+
+// whether a key is in one of the instance's projects, listing them the
+// first time if they weren't configured. the listing is outside the lock,
+// so a slow instance doesn't hold up the lookups waiting on it, and a
+// failed one isn't tried again for correlationFailureTTL
+func (i *correlatedInstance) hasProject(key string) bool {
+  project := key[:strings.LastIndex(key, "-")]
+  i.mu.Lock()
+  projects, failed := i.projects, i.listFailed
+  i.mu.Unlock()
+  if projects != nil {
+    return projects[project]
+  }
+  if time.Since(failed) < correlationFailureTTL {
+    return false
+  }
+
+  projects, err := i.listProjects()
+  i.mu.Lock()
+  defer i.mu.Unlock()
+  if err != nil {
+    logger.Print("Error listing the projects of ", i.Name, ": ", err)
+    i.listFailed = time.Now()
+    return false
+  }
+  i.projects = projects
+  return projects[project]
+}
+
+func (i *correlatedInstance) listProjects() (map[string]bool, error) {
+  contents, err := jiraRequest("GET", "/project", nil, i.creds)
+  if err != nil {
+    return nil, err
+  }
+  var projects []struct {
+    Key string `json:"key"`
+  }
+  if err := json.Unmarshal(contents, &projects); err != nil {
+    return nil, err
+  }
+  keys := map[string]bool{}
+  for _, project := range projects {
+    keys[project.Key] = true
+  }
+  return keys, nil
+}
+
+func (i *correlatedInstance) lookup(key string) (Correlation, error) {
+  contents, err := jiraRequest("GET", "/issue/"+url.PathEscape(key)+"?fields=summary,status", nil, i.creds)
+  if err != nil {
+    return Correlation{}, err
+  }
+  var issue struct {
+    Key    string `json:"key"`
+    Fields struct {
+      Summary string `json:"summary"`
+      Status  struct {
+        Name string `json:"name"`
+      } `json:"status"`
+    } `json:"fields"`
+  }
+  if err := json.Unmarshal(contents, &issue); err != nil {
+    return Correlation{}, err
+  }
+  return Correlation{
+    Instance: i.Name,
+    Key:      issue.Key,
+    Summary:  issue.Fields.Summary,
+    Status:   issue.Fields.Status.Name,
+    Url:      i.browse + issue.Key,
+  }, nil
+}
+
+// the keys of the instance's issues whose text mentions key
+func (i *correlatedInstance) mentions(key string) []string {
+  jql := fmt.Sprintf("text ~ %s", jqlQuote(key))
+  contents := jiraSearch(jql, 0, 20, i.creds)
+  if contents == nil {
+    return nil
+  }
+  var result struct {
+    Issues []struct {
+      Key string `json:"key"`
+    } `json:"issues"`
+  }
+  if err := json.Unmarshal(contents, &result); err != nil {
+    logger.Print("Error parsing the issues of ", i.Name, " mentioning ", key, ": ", err)
+    return nil
+  }
+  keys := []string{}
+  for _, issue := range result.Issues {
+    keys = append(keys, issue.Key)
+  }
+  return keys
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+  "encoding/json"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "launchpad.net/goyaml"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+)
+
+func init() {
+  commands["create"] = command{"create [--template=NAME] [--project=KEY] [--type=TYPE] [--summary=TEXT] [--description=TEXT|-] [--set=FIELD=VALUE].. [--dry-run]", createCommand}
+}
+
+// the starting point for issues filed with `create --template`, inline
+// under `issue_templates` or one NAME.yaml file each in issue_templates_dir.
+// the flags override what the template sets, and create refuses to file
+// the issue while any of the required fields are empty
+//
+//   issue_templates:
+//     bug:
+//       project: OPS
+//       type: Bug
+//       priority: Major
+//       labels: [from-cli]
+//       description: |
+//         Steps to reproduce:
+//       fields: {customfield_10010: web}
+//       required: [summary, components]
+type IssueTemplate struct {
+  Project     string                 `yaml:"project"`
+  Type        string                 `yaml:"type"`
+  Priority    string                 `yaml:"priority"`
+  Summary     string                 `yaml:"summary"`
+  Description string                 `yaml:"description"`
+  Labels      []string               `yaml:"labels"`
+  Components  []string               `yaml:"components"`
+  Fields      map[string]interface{} `yaml:"fields"`
+  Required    []string               `yaml:"required"`
+}
+
+func loadIssueTemplate(name string, creds *Config) (*IssueTemplate, error) {
+  if t, ok := creds.IssueTemplates[name]; ok {
+    return &t, nil
+  }
+  if len(creds.IssueTemplatesDir) == 0 {
+    return nil, fmt.Errorf("no issue template named %s", name)
+  }
+  contents, err := ioutil.ReadFile(filepath.Join(creds.IssueTemplatesDir, name+".yaml"))
+  if os.IsNotExist(err) {
+    return nil, fmt.Errorf("no issue template named %s", name)
+  } else if err != nil {
+    return nil, err
+  }
+  var t IssueTemplate
+  if err := goyaml.Unmarshal(contents, &t); err != nil {
+    return nil, fmt.Errorf("%s: %s", name, err)
+  }
+  return &t, nil
+}
+
+// the jira form of a field's value, for the fields that take an object
+// or a list rather than plain text
+func issueFieldValue(field string, value string) interface{} {
+  switch field {
+  case "project":
+    return map[string]string{"key": value}
+  case "issuetype", "priority":
+    return map[string]string{"name": value}
+  case "assignee", "reporter":
+    return map[string]string{"name": value}
+  case "labels":
+    return splitList(value)
+  case "components", "versions", "fixVersions":
+    names := []map[string]string{}
+    for _, name := range splitList(value) {
+      names = append(names, map[string]string{"name": name})
+    }
+    return names
+  }
+  return value
+}
+
+func splitList(s string) []string {
+  list := []string{}
+  for _, item := range strings.Split(s, ",") {
+    if item = strings.TrimSpace(item); len(item) > 0 {
+      list = append(list, item)
+    }
+  }
+  return list
+}
+
+// the field names people use for the ones jira names differently
+var issueFieldNames = map[string]string{"type": "issuetype"}
+
+func issueField(name string) string {
+  if field, ok := issueFieldNames[name]; ok {
+    return field
+  }
+  return name
+}
+
+// a decoded yaml value that can be sent as json, whose maps have to have
+// string keys
+func jsonValue(value interface{}) interface{} {
+  switch v := value.(type) {
+  case map[interface{}]interface{}:
+    m := map[string]interface{}{}
+    for k, child := range v {
+      m[fmt.Sprint(k)] = jsonValue(child)
+    }
+    return m
+  case []interface{}:
+    list := []interface{}{}
+    for _, child := range v {
+      list = append(list, jsonValue(child))
+    }
+    return list
+  }
+  return value
+}
+
+// the fields to create the issue with, the template's overridden by the
+// flags
+func (t *IssueTemplate) issueFields(set map[string]string) map[string]interface{} {
+  fields := map[string]interface{}{}
+  for name, value := range t.Fields {
+    fields[name] = jsonValue(value)
+  }
+  for name, value := range map[string]string{
+    "project": t.Project, "issuetype": t.Type, "priority": t.Priority,
+    "summary": t.Summary, "description": t.Description,
+  } {
+    if len(value) > 0 {
+      fields[name] = issueFieldValue(name, value)
+    }
+  }
+  if len(t.Labels) > 0 {
+    fields["labels"] = t.Labels
+  }
+  if len(t.Components) > 0 {
+    fields["components"] = issueFieldValue("components", strings.Join(t.Components, ","))
+  }
+  for name, value := range set {
+    fields[issueField(name)] = issueFieldValue(issueField(name), value)
+  }
+  return fields
+}
+
+// the required fields with no value, always including what jira can't do
+// without
+func (t *IssueTemplate) missing(fields map[string]interface{}) []string {
+  missing := []string{}
+  for _, name := range append([]string{"project", "issuetype", "summary"}, t.Required...) {
+    if emptyField(fields[issueField(name)]) && !containsFold(missing, name) {
+      missing = append(missing, name)
+    }
+  }
+  return missing
+}
+
+// repeated --set flags
+type setFlags map[string]string
+
+func (s setFlags) String() string {
+  pairs := []string{}
+  for name, value := range s {
+    pairs = append(pairs, name+"="+value)
+  }
+  sort.Strings(pairs)
+  return strings.Join(pairs, ",")
+}
+
+func (s setFlags) Set(pair string) error {
+  parts := strings.SplitN(pair, "=", 2)
+  if len(parts) != 2 || len(parts[0]) == 0 {
+    return fmt.Errorf("expected FIELD=VALUE, got %q", pair)
+  }
+  s[parts[0]] = parts[1]
+  return nil
+}
+
+func createCommand(args []string) {
+  flags := flag.NewFlagSet("create", flag.ExitOnError)
+  template := flags.String("template", "", "The issue template to start from")
+  project := flags.String("project", "", "The project to file the issue in")
+  issueType := flags.String("type", "", "The issue type")
+  summary := flags.String("summary", "", "The summary")
+  description := flags.String("description", "", "The description, or - to read it from stdin")
+  dryRun := flags.Bool("dry-run", false, "Print the fields instead of creating the issue")
+  set := setFlags{}
+  flags.Var(set, "set", "Set another field, e.g. --set priority=Critical or --set labels=a,b")
+  flags.Parse(args)
+  if flags.NArg() > 0 {
+    usageExit(commands["create"].usage)
+  }
+
+  creds, _ := setup()
+  t := &IssueTemplate{}
+  if len(*template) > 0 {
+    var err error
+    if t, err = loadIssueTemplate(*template, creds); err != nil {
+      logger.Print("Error loading the issue template: ", err)
+      os.Exit(1)
+    }
+  }
+  if *description == "-" {
+    *description = argumentText([]string{"-"})
+  }
+  for name, value := range map[string]string{"project": *project, "type": *issueType, "summary": *summary, "description": *description} {
+    if _, ok := set[name]; !ok && len(value) > 0 {
+      set[name] = value
+    }
+  }
+
+  fields := t.issueFields(set)
+  if missing := t.missing(fields); len(missing) > 0 {
+    logger.Print("Missing required fields: ", strings.Join(missing, ", "))
+    os.Exit(1)
+  }
+  if *dryRun {
+    contents, _ := json.MarshalIndent(map[string]interface{}{"fields": fields}, "", "  ")
+    fmt.Println(string(contents))
+    return
+  }
+  key, err := createIssue(fields, creds)
+  if err != nil {
+    logger.Print("Error creating the issue: ", err)
+    os.Exit(1)
+  }
+  fmt.Println(key)
+  logger.Print("Created ", creds.browseUrl(key))
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// a cronSchedule is a standard five field cron expression (minute hour
+// day-of-month month day-of-week) supporting *, lists, ranges and steps,
+// e.g. "*/15 9-17 * * mon-fri", or one of @hourly, @daily, @midnight,
+// @weekly and @monthly. times are in the tracker's local timezone
+type cronSchedule struct {
+  minutes, hours, days, months, weekdays map[int]bool
+  anyDay, anyWeekday                     bool
+}
+
+var cronDescriptors = map[string]string{
+  "@hourly":   "0 * * * *",
+  "@daily":    "0 0 * * *",
+  "@midnight": "0 0 * * *",
+  "@weekly":   "0 0 * * 0",
+  "@monthly":  "0 0 1 * *",
+}
+
+var cronNames = map[string]int{
+  "sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+  "jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+  "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+func parseCronValue(s string) (int, error) {
+  if n, ok := cronNames[strings.ToLower(s)]; ok {
+    return n, nil
+  }
+  return strconv.Atoi(s)
+}
+
+// parse one field into the set of values it matches
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+  values := map[int]bool{}
+  for _, part := range strings.Split(field, ",") {
+    step := 1
+    if i := strings.Index(part, "/"); i >= 0 {
+      var err error
+      if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+        return nil, fmt.Errorf("invalid step in %q", part)
+      }
+      part = part[:i]
+    }
+
+    lo, hi := min, max
+    if part != "*" {
+      bounds := strings.SplitN(part, "-", 2)
+      var err error
+      if lo, err = parseCronValue(bounds[0]); err != nil {
+        return nil, fmt.Errorf("invalid value %q", bounds[0])
+      }
+      hi = lo
+      if len(bounds) == 2 {
+        if hi, err = parseCronValue(bounds[1]); err != nil {
+          return nil, fmt.Errorf("invalid value %q", bounds[1])
+        }
+      } else if step > 1 {
+        hi = max // "5/15" means from 5 every 15
+      }
+    }
+    if lo < min || hi > max || lo > hi {
+      return nil, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+    }
+    for v := lo; v <= hi; v += step {
+      values[v] = true
+    }
+  }
+  return values, nil
+}
+
+func parseCron(expr string) (*cronSchedule, error) {
+  if e, ok := cronDescriptors[strings.TrimSpace(expr)]; ok {
+    expr = e
+  }
+  fields := strings.Fields(expr)
+  if len(fields) != 5 {
+    return nil, fmt.Errorf("expected 5 fields in %q", expr)
+  }
+
+  c := &cronSchedule{anyDay: fields[2] == "*", anyWeekday: fields[4] == "*"}
+  var err error
+  if c.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+    return nil, err
+  }
+  if c.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+    return nil, err
+  }
+  if c.days, err = parseCronField(fields[2], 1, 31); err != nil {
+    return nil, err
+  }
+  if c.months, err = parseCronField(fields[3], 1, 12); err != nil {
+    return nil, err
+  }
+  if c.weekdays, err = parseCronField(fields[4], 0, 7); err != nil {
+    return nil, err
+  }
+  if c.weekdays[7] {
+    c.weekdays[0] = true // both 0 and 7 are sunday
+  }
+  return c, nil
+}
+
+// like cron, if both the day of month and day of week are restricted
+// either one matching is enough
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+  day, weekday := c.days[t.Day()], c.weekdays[int(t.Weekday())]
+  switch {
+  case c.anyDay && c.anyWeekday:
+    return true
+  case c.anyDay:
+    return weekday
+  case c.anyWeekday:
+    return day
+  }
+  return day || weekday
+}
+
+// the first time after t the schedule fires
+func (c *cronSchedule) next(t time.Time) time.Time {
+  t = t.Truncate(time.Minute).Add(time.Minute)
+  limit := t.AddDate(5, 0, 0) // e.g. "0 0 30 2 *" never fires
+  for t.Before(limit) {
+    switch {
+    case !c.months[int(t.Month())]:
+      t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+    case !c.dayMatches(t):
+      t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+    case !c.hours[t.Hour()]:
+      // the local hour, truncating would go by utc's and step to :30 in
+      // zones with a half hour offset
+      t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+    case !c.minutes[t.Minute()]:
+      t = t.Add(time.Minute)
+    default:
+      return t
+    }
+  }
+  return limit
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+  "testing"
+  "time"
+)
+
+// the hours are stepped through in the schedule's zone, including zones a
+// half hour off utc
+func TestCronNext(t *testing.T) {
+  kolkata := time.FixedZone("IST", 5*3600+1800)
+  newfoundland := time.FixedZone("NST", -(3*3600 + 1800))
+  cases := []struct {
+    expr string
+    from time.Time
+    want time.Time
+  }{
+    {"0 9 * * *", time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC), time.Date(2024, 3, 5, 9, 0, 0, 0, time.UTC)},
+    {"0 9 * * *", time.Date(2024, 3, 4, 7, 15, 0, 0, kolkata), time.Date(2024, 3, 4, 9, 0, 0, 0, kolkata)},
+    {"30 14 * * 1-5", time.Date(2024, 3, 8, 15, 0, 0, 0, kolkata), time.Date(2024, 3, 11, 14, 30, 0, 0, kolkata)},
+    {"0 9 * * *", time.Date(2024, 3, 4, 23, 59, 0, 0, newfoundland), time.Date(2024, 3, 5, 9, 0, 0, 0, newfoundland)},
+    {"*/15 * * * *", time.Date(2024, 3, 4, 9, 50, 0, 0, kolkata), time.Date(2024, 3, 4, 10, 0, 0, 0, kolkata)},
+  }
+  for _, c := range cases {
+    schedule, err := parseCron(c.expr)
+    if err != nil {
+      t.Fatalf("parsing %q: %v", c.expr, err)
+    }
+    if got := schedule.next(c.from); !got.Equal(c.want) {
+      t.Errorf("%q after %s fired at %s, want %s", c.expr, c.from, got, c.want)
+    }
+  }
+}
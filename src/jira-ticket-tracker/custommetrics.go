@@ -0,0 +1,238 @@
+package main
+
+import (
+  "fmt"
+  "os"
+  "regexp"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+)
+
+// metrics of the team's own, worked out from issue fields and served with
+// the tracker's at /metrics, configured under `metrics`. a gauge searches
+// every interval and a counter adds up the events the tracker sends
+//
+//   metrics:
+//     - name: open_issues
+//       type: gauge
+//       jql: project = OPS AND resolution = EMPTY
+//       by: components          # a series per value of the field
+//       interval: 5m            # 1m by default
+//     - name: story_points_created
+//       type: counter
+//       kinds: [created]        # the events counted, every kind by default
+//       rules: [ops-queue]      # optional, only these rules' events
+//       sum: Story Points       # add up a number field instead of counting
+//       by: priority
+//
+// names get a jira_tracker_ prefix, and counters a _total suffix. a gauge
+// looks at up to max issues, 1000 by default, unless it only counts them
+type CustomMetric struct {
+  Name     string   `yaml:"name"`
+  Help     string   `yaml:"help"`
+  Type     string   `yaml:"type"` // gauge or counter
+  Jql      string   `yaml:"jql"`
+  Kinds    []string `yaml:"kinds"`
+  Rules    []string `yaml:"rules"`
+  Sum      string   `yaml:"sum"`
+  By       string   `yaml:"by"`
+  Interval string   `yaml:"interval"`
+  Max      int      `yaml:"max"`
+
+  interval time.Duration
+}
+
+var (
+  metricNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+  labelNamePattern  = regexp.MustCompile(`[^a-z0-9_]+`) // what a field name can't keep
+)
+
+var customMetrics = struct {
+  sync.Mutex
+  list   []*CustomMetric
+  values map[string]map[string]float64 // metric -> label value -> value
+}{values: map[string]map[string]float64{}}
+
+func loadCustomMetrics(creds *Config) {
+  seen := map[string]bool{}
+  for i := range creds.Metrics {
+    m := &creds.Metrics[i]
+    switch {
+    case !metricNamePattern.MatchString(m.Name):
+      logger.Print("Metric names have to be lowercase letters, digits and underscores, not ", m.Name)
+      os.Exit(1)
+    case seen[m.Name]:
+      logger.Print("There is more than one metric named ", m.Name)
+      os.Exit(1)
+    case m.Type == "gauge" && len(m.Jql) == 0:
+      logger.Print("Gauge ", m.Name, " needs jql")
+      os.Exit(1)
+    case m.Type != "gauge" && m.Type != "counter":
+      logger.Print("Metric ", m.Name, " has to be a gauge or a counter, not ", m.Type)
+      os.Exit(1)
+    }
+    seen[m.Name] = true
+    m.Jql = configJql(creds, "metric "+m.Name, m.Jql)
+    m.interval = durationOr(m.Interval, time.Minute)
+    if m.Max <= 0 {
+      m.Max = 1000
+    }
+    customMetrics.list = append(customMetrics.list, m)
+    customMetrics.values[m.Name] = map[string]float64{}
+  }
+}
+
+// the metric's labels for a field's value: a select's, a user's, a number
+// or each of a list's. "none" when it's empty
+func metricLabels(value interface{}) []string {
+  labels := []string{}
+  var add func(v interface{})
+  add = func(v interface{}) {
+    switch v := v.(type) {
+    case string:
+      labels = append(labels, v)
+    case float64:
+      labels = append(labels, fmt.Sprint(v))
+    case bool:
+      labels = append(labels, fmt.Sprint(v))
+    case []interface{}:
+      for _, item := range v {
+        add(item)
+      }
+    case map[string]interface{}:
+      for _, key := range []string{"name", "value", "displayName", "key"} {
+        if s, ok := v[key].(string); ok {
+          labels = append(labels, s)
+          return
+        }
+      }
+    }
+  }
+  add(value)
+  if len(labels) == 0 {
+    return []string{"none"}
+  }
+  return labels
+}
+
+// what an issue adds to the metric, by label. a list field adds to each of
+// its values
+func (m *CustomMetric) measure(fields map[string]interface{}, creds *Config) map[string]float64 {
+  amount := 1.0
+  if len(m.Sum) > 0 {
+    amount = fieldNumber(fields, customFields.id(m.Sum, creds))
+  }
+  if len(m.By) == 0 {
+    return map[string]float64{"": amount}
+  }
+  added := map[string]float64{}
+  for _, label := range metricLabels(fieldValue(fields, customFields.id(m.By, creds))) {
+    added[label] += amount
+  }
+  return added
+}
+
+// search once and set the gauge, keeping the last values if it fails
+func (m *CustomMetric) gauge(creds *Config) {
+  values := map[string]float64{}
+  if len(m.Sum) == 0 && len(m.By) == 0 {
+    count, err := countIssues(m.Jql, creds)
+    if err != nil {
+      logger.Print("Error counting the issues for metric ", m.Name, ": ", err)
+      return
+    }
+    values[""] = float64(count)
+  } else {
+    _, fields, err := searchIssues(m.Jql, m.Max, creds)
+    if err != nil {
+      logger.Print("Error searching the issues for metric ", m.Name, ": ", err)
+      return
+    }
+    for _, f := range fields {
+      for label, amount := range m.measure(f, creds) {
+        values[label] += amount
+      }
+    }
+  }
+  customMetrics.Lock()
+  customMetrics.values[m.Name] = values
+  customMetrics.Unlock()
+}
+
+func watchCustomMetrics(creds *Config) {
+  for _, m := range customMetrics.list {
+    if m.Type != "gauge" {
+      continue
+    }
+    go func(m *CustomMetric) {
+      for {
+        // the last values are kept while the instance is near its budget
+        if !skipForQuota(creds.Url) && !quota.degraded(creds.Url) {
+          m.gauge(creds)
+        }
+        time.Sleep(m.interval)
+      }
+    }(m)
+  }
+}
+
+// add the events to the counters they match
+func countEventMetrics(events []*Event, creds *Config) {
+  if len(customMetrics.list) == 0 {
+    return
+  }
+  for _, m := range customMetrics.list {
+    if m.Type != "counter" {
+      continue
+    }
+    for _, event := range events {
+      if event.Issue.Key == trackerKey || (len(m.Kinds) > 0 && !contains(m.Kinds, event.Kind)) || (len(m.Rules) > 0 && !contains(m.Rules, event.Rule)) {
+        continue
+      }
+      added := m.measure(event.Fields, creds) // may look the field names up
+      customMetrics.Lock()
+      for label, amount := range added {
+        customMetrics.values[m.Name][label] += amount
+      }
+      customMetrics.Unlock()
+    }
+  }
+}
+
+func writeCustomMetrics(out *strings.Builder) {
+  if len(customMetrics.list) == 0 {
+    return
+  }
+  customMetrics.Lock()
+  defer customMetrics.Unlock()
+  for _, m := range customMetrics.list {
+    name := "jira_tracker_" + m.Name
+    if m.Type == "counter" {
+      name += "_total"
+    }
+    help := m.Help
+    if len(help) == 0 {
+      help = "Configured under metrics."
+    }
+    fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, m.Type)
+    values := customMetrics.values[m.Name]
+    labels := []string{}
+    for label := range values {
+      labels = append(labels, label)
+    }
+    sort.Strings(labels)
+    if len(labels) == 0 && len(m.By) == 0 {
+      labels = []string{""} // a counter nothing has added to yet
+    }
+    label := labelNamePattern.ReplaceAllString(strings.ToLower(m.By), "_")
+    for _, value := range labels {
+      if len(m.By) == 0 {
+        fmt.Fprintf(out, "%s %g\n", name, values[value])
+      } else {
+        fmt.Fprintf(out, "%s{%s=%q} %g\n", name, label, value, values[value])
+      }
+    }
+  }
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "os"
+  "sort"
+  "strings"
+  "text/tabwriter"
+  "time"
+)
+
+func init() {
+  commands["cycle-time"] = command{"cycle-time [--window=FROM..TO|DURATION] [--project=KEY] [--jql=JQL] [--limit=200]", cycleTimeCommand}
+}
+
+// how long the issues resolved in a window spent in one status
+type statusTime struct {
+  Status string
+  Issues int // that were ever in it
+  Total  time.Duration
+  times  []time.Duration // per issue, for the median
+}
+
+// the time an issue spent in each status from its creation to its
+// resolution, from its changelog
+func issueStatusTimes(key, created, resolved, status string, creds *Config) (map[string]time.Duration, error) {
+  start, err := time.Parse(dateLayout, created)
+  if err != nil {
+    return nil, err
+  }
+  end, err := time.Parse(dateLayout, resolved)
+  if err != nil {
+    end = time.Now()
+  }
+  transitions, err := issueTransitions(key, start, end, creds)
+  if err != nil {
+    return nil, err
+  }
+  sort.SliceStable(transitions, func(i, j int) bool { return transitions[i].Time.Before(transitions[j].Time) })
+  times := map[string]time.Duration{}
+  if len(transitions) > 0 {
+    status = transitions[0].From // what it was created in
+  }
+  at := start
+  for _, t := range transitions {
+    times[t.From] += t.Time.Sub(at)
+    at = t.Time
+    status = t.To
+  }
+  if end.After(at) {
+    times[status] += end.Sub(at)
+  }
+  return times, nil
+}
+
+// the status times of the issues matching the jql that were resolved in the
+// window, the slowest status first, and how many issues there were
+func cycleTimes(jql string, from, to time.Time, limit int, creds *Config) ([]*statusTime, int, error) {
+  query := fmt.Sprintf("resolved >= %s AND resolved <= %s", jqlTime(from), jqlTime(to))
+  if len(jql) > 0 {
+    query = "(" + jql + ") AND " + query
+  }
+  issues, fields, err := searchIssues(query+" ORDER BY resolved ASC", limit, creds)
+  if err != nil {
+    return nil, 0, err
+  }
+  byStatus := map[string]*statusTime{}
+  counted := 0
+  for i, issue := range issues {
+    times, err := issueStatusTimes(issue.Key, fieldString(fields[i], "created"), fieldString(fields[i], "resolutiondate"),
+      fieldString(fields[i], "status.name"), creds)
+    if err != nil {
+      logger.Print("Error fetching the changelog of ", issue.Key, ": ", err)
+      continue
+    }
+    counted++
+    for status, d := range times {
+      if _, ok := byStatus[status]; !ok {
+        byStatus[status] = &statusTime{Status: status}
+      }
+      s := byStatus[status]
+      s.Issues++
+      s.Total += d
+      s.times = append(s.times, d)
+    }
+  }
+  statuses := []*statusTime{}
+  for _, s := range byStatus {
+    statuses = append(statuses, s)
+  }
+  sort.Slice(statuses, func(i, j int) bool { return statuses[i].Total > statuses[j].Total })
+  return statuses, counted, nil
+}
+
+// the breakdown as lines of text, the share of the time each status took
+func formatCycleTimes(title string, statuses []*statusTime, issues int) string {
+  var out strings.Builder
+  fmt.Fprintln(&out, title)
+  if issues == 0 {
+    out.WriteString("no issues were resolved\n")
+    return out.String()
+  }
+  var total time.Duration
+  for _, s := range statuses {
+    total += s.Total
+  }
+  w := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+  fmt.Fprintln(w, "STATUS\tISSUES\tMEDIAN\tSHARE")
+  for _, s := range statuses {
+    share := 0.0
+    if total > 0 {
+      share = float64(s.Total) / float64(total) * 100
+    }
+    fmt.Fprintf(w, "%s\t%d\t%s\t%.0f%%\n", s.Status, s.Issues, humanize(medianDuration(s.times)), share)
+  }
+  w.Flush()
+  fmt.Fprintf(&out, "%d issues, %s from creation to resolution on average\n", issues, humanize(total/time.Duration(issues)))
+  return out.String()
+}
+
+// print how long the issues resolved in the window spent in each status,
+// the last 30 days by default
+func cycleTimeCommand(args []string) {
+  flags := flag.NewFlagSet("cycle-time", flag.ExitOnError)
+  window := flags.String("window", "30d", "FROM..TO as timestamps or dates, or a duration back from now")
+  project := flags.String("project", "", "Only issues in this project")
+  jql := flags.String("jql", "", "Only issues matching this too")
+  limit := flags.Int("limit", 200, "The most issues to look at, each needs its changelog fetched")
+  flags.Parse(args)
+  if flags.NArg() > 0 {
+    usageExit(commands["cycle-time"].usage)
+  }
+  from, to, err := parseReportWindow(*window)
+  if err != nil {
+    logger.Print("Invalid --window: ", err)
+    os.Exit(1)
+  }
+
+  creds, _ := setup()
+  query := configJql(creds, "--jql", *jql)
+  if len(*project) > 0 {
+    clause := "project = " + strings.ToUpper(*project)
+    if len(query) > 0 {
+      clause += " AND (" + query + ")"
+    }
+    query = clause
+  }
+  statuses, issues, err := cycleTimes(query, from, to, *limit, creds)
+  if err != nil {
+    logger.Print("Error searching jira: ", err)
+    os.Exit(1)
+  }
+  fmt.Print(formatCycleTimes(fmt.Sprintf("Time in each status of the issues resolved from %s to %s:",
+    from.Format("2006-01-02 15:04"), to.Format("2006-01-02 15:04")), statuses, issues))
+}
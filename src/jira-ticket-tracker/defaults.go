@@ -0,0 +1,84 @@
+package main
+
+import (
+  "fmt"
+  "strings"
+  "time"
+)
+
+// settings every rule gets unless it sets its own, configured under
+// `defaults`. a rule turns off the default quiet hours with `quiet_hours: off`
+//
+//   defaults:
+//     interval: 1m
+//     max_results: 100          # issues per search page
+//     targets: [ops-slack]
+//     quiet_hours: 22:00-07:00  # local time, polls wait until they're over
+type RuleDefaults struct {
+  Interval   string   `yaml:"interval"`
+  MaxResults int      `yaml:"max_results"`
+  Targets    []string `yaml:"targets"`
+  QuietHours string   `yaml:"quiet_hours"`
+}
+
+// fill in what the rule leaves unset from the defaults
+func (d *RuleDefaults) apply(r *Rule) {
+  if len(r.Interval) == 0 && len(r.Schedule) == 0 {
+    r.Interval = d.Interval
+  }
+  if r.MaxResults == 0 {
+    r.MaxResults = d.MaxResults
+  }
+  if r.Targets == nil {
+    r.Targets = d.Targets
+  }
+  if len(r.QuietHours) == 0 {
+    r.QuietHours = d.QuietHours
+  }
+}
+
+// a daily window in the timezone of the times it's given, which may run
+// past midnight
+type quietHours struct {
+  start, end time.Duration // since midnight
+}
+
+func parseQuietHours(s string) (*quietHours, error) {
+  if len(s) == 0 || s == "off" {
+    return nil, nil
+  }
+  parts := strings.Split(s, "-")
+  if len(parts) != 2 {
+    return nil, fmt.Errorf("expected START-END, e.g. 22:00-07:00")
+  }
+  start, err := parseClock(parts[0])
+  if err != nil {
+    return nil, err
+  }
+  end, err := parseClock(parts[1])
+  if err != nil {
+    return nil, err
+  }
+  if start == end {
+    return nil, fmt.Errorf("quiet hours can't start and end at the same time")
+  }
+  return &quietHours{start, end}, nil
+}
+
+// when the quiet hours t falls in end, or the zero time if t isn't in them
+func (q *quietHours) over(t time.Time) time.Time {
+  if q == nil {
+    return time.Time{}
+  }
+  midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+  clock := t.Sub(midnight)
+  switch {
+  case q.start < q.end && clock >= q.start && clock < q.end:
+    return midnight.Add(q.end)
+  case q.start > q.end && clock >= q.start:
+    return midnight.AddDate(0, 0, 1).Add(q.end)
+  case q.start > q.end && clock < q.end:
+    return midnight.Add(q.end)
+  }
+  return time.Time{}
+}
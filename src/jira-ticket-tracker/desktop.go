@@ -0,0 +1,26 @@
+package main
+
+import (
+  "fmt"
+)
+
+// pops up a desktop notification, for a tracker running on someone's own
+// machine. where the platform has no way to show one the target logs the
+// message instead, so a config shared between a mac and a server still
+// works on both
+type desktopNotifier struct {
+  name string
+}
+
+func newDesktopNotifier(name string) Notifier {
+  if !desktopSupported {
+    logger.Print("Desktop notifications aren't supported on this platform, target ", name, " will log instead")
+    return &logNotifier{name: name}
+  }
+  return &desktopNotifier{name: name}
+}
+
+func (n *desktopNotifier) Notify(event *Event, message string) error {
+  title := fmt.Sprintf("%s %s", event.Issue.Key, event.Kind)
+  return showDesktopNotification(title, message)
+}
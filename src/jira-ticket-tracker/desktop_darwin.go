@@ -0,0 +1,20 @@
+//go:build darwin
+// +build darwin
+
+package main
+
+import (
+  "os/exec"
+  "strconv"
+)
+
+const desktopSupported = true
+
+func showDesktopNotification(title, message string) error {
+  script := "display notification " + strconv.Quote(message) + " with title " + strconv.Quote(title)
+  return exec.Command("osascript", "-e", script).Run()
+}
+
+func openUrl(url string) error {
+  return exec.Command("open", url).Start()
+}
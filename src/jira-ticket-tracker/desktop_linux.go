@@ -0,0 +1,18 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+  "os/exec"
+)
+
+const desktopSupported = true
+
+func showDesktopNotification(title, message string) error {
+  return exec.Command("notify-send", "--app-name=jira-ticket-tracker", title, message).Run()
+}
+
+func openUrl(url string) error {
+  return exec.Command("xdg-open", url).Start()
+}
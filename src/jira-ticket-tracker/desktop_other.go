@@ -0,0 +1,25 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package main
+
+import (
+  "fmt"
+  "os/exec"
+  "runtime"
+)
+
+// windows, the bsds and the rest have no notifier we can count on being
+// installed
+const desktopSupported = false
+
+func showDesktopNotification(title, message string) error {
+  return fmt.Errorf("desktop notifications aren't supported on %s", runtime.GOOS)
+}
+
+func openUrl(url string) error {
+  if runtime.GOOS == "windows" {
+    return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+  }
+  return exec.Command("xdg-open", url).Start()
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "github.com/plouc/go-jira-client"
+  "launchpad.net/goyaml"
+  "os"
+  "sort"
+  "strings"
+  "time"
+)
+
+func init() {
+  commands["diff-config"] = command{"diff-config [--since=7d] [--local] NEW.yaml", diffConfigCommand}
+}
+
+// an issue from the history, as it was when it was last seen
+type pastIssue struct {
+  issue  *gojira.Issue
+  fields map[string]interface{}
+}
+
+// the issues in the history since a time, newest version of each, by key
+func pastIssues(since time.Time) (map[string]pastIssue, error) {
+  issues := map[string]pastIssue{}
+  err := history.Each(func(record HistoryRecord) bool {
+    if record.Time.Before(since) {
+      return true
+    }
+    issue, fields, err := parseIssue(record.Issue)
+    if err != nil {
+      return true
+    }
+    issues[record.Key] = pastIssue{issue, fields}
+    return true
+  })
+  return issues, err
+}
+
+// the keys of the issues a rule matches, with the targets their event would
+// go to. the filters are checked locally, and the rule's search by asking
+// jira which of the issues it returns now, unless local is set
+func ruleMatches(rule *Rule, issues map[string]pastIssue, creds *Config, local bool) (map[string][]string, error) {
+  candidates := []string{}
+  for key, past := range issues {
+    if conditionsPassed(rule.evaluate(past.issue, past.fields, creds)) {
+      candidates = append(candidates, key)
+    }
+  }
+  sort.Strings(candidates)
+  if jql := rule.jql(); len(jql) > 0 && !local {
+    found := map[string]bool{}
+    for i := 0; i < len(candidates); i += 100 {
+      batch := candidates[i:]
+      if len(batch) > 100 {
+        batch = batch[:100]
+      }
+      quoted := []string{}
+      for _, key := range batch {
+        quoted = append(quoted, jqlQuote(key))
+      }
+      matched, _, err := searchIssues("key in ("+strings.Join(quoted, ", ")+") AND ("+jql+")", len(batch), creds)
+      if err != nil {
+        return nil, err
+      }
+      for _, issue := range matched {
+        found[issue.Key] = true
+      }
+    }
+    kept := []string{}
+    for _, key := range candidates {
+      if found[key] {
+        kept = append(kept, key)
+      }
+    }
+    candidates = kept
+  }
+  matches := map[string][]string{}
+  for _, key := range candidates {
+    past := issues[key]
+    matches[key] = ruleEvent(rule, eventCreated, past.issue, past.fields, creds).Targets
+  }
+  return matches, nil
+}
+
+// compare the rules of a new config with the current ones against the
+// issues in the history, so a change that would suddenly match hundreds of
+// issues is seen before it's deployed. only the rules and routing are taken
+// from the new config, everything else is the current config's
+func diffConfigCommand(args []string) {
+  flags := flag.NewFlagSet("diff-config", flag.ExitOnError)
+  since := flags.String("since", "7d", "How much of the history to compare against")
+  local := flags.Bool("local", false, "Don't ask jira about the rules' jql, only check their filters")
+  flags.Parse(args)
+  if flags.NArg() != 1 {
+    usageExit(commands["diff-config"].usage)
+  }
+  window, err := parseDuration(*since)
+  if err != nil {
+    usageExit(commands["diff-config"].usage)
+  }
+
+  creds, current := setup()
+  if history == nil {
+    logger.Print("diff-config compares against the history, configure history.path")
+    os.Exit(1)
+  }
+  file := getCreds(flags.Arg(0))
+  proposed, err := buildRules(file.Rules, &file)
+  if err != nil {
+    logger.Print("Error in the new config's rules: ", err)
+    os.Exit(1)
+  }
+  proposed = append(flagRules(&file), proposed...)
+  newCreds := *creds
+  newCreds.Routing = file.Routing
+  x, _ := goyaml.Marshal(creds.Routing)
+  y, _ := goyaml.Marshal(newCreds.Routing)
+  routingChanged := string(x) != string(y)
+
+  issues, err := pastIssues(time.Now().Add(-window))
+  if err != nil {
+    logger.Print("Error reading the history: ", err)
+    os.Exit(1)
+  }
+  fmt.Printf("comparing against %d issues from the last %s of history\n", len(issues), *since)
+
+  before := map[string]*Rule{}
+  for _, rule := range current {
+    before[rule.Name] = rule
+  }
+  after := map[string]*Rule{}
+  for _, rule := range proposed {
+    after[rule.Name] = rule
+  }
+  names := []string{}
+  for name := range before {
+    names = append(names, name)
+  }
+  for name := range after {
+    if _, ok := before[name]; !ok {
+      names = append(names, name)
+    }
+  }
+  sort.Strings(names)
+
+  changed, more, fewer := 0, 0, 0
+  for _, name := range names {
+    oldRule, newRule := before[name], after[name]
+    if oldRule != nil && newRule != nil && sameRule(oldRule, newRule) && !routingChanged {
+      continue
+    }
+    was, now := map[string][]string{}, map[string][]string{}
+    var err error
+    if oldRule != nil {
+      if was, err = ruleMatches(oldRule, issues, creds, *local); err != nil {
+        logger.Print("Error checking the current ", name, ": ", err)
+        os.Exit(1)
+      }
+    }
+    if newRule != nil {
+      if now, err = ruleMatches(newRule, issues, &newCreds, *local); err != nil {
+        logger.Print("Error checking the new ", name, ": ", err)
+        os.Exit(1)
+      }
+    }
+
+    lines := []string{}
+    keys := []string{}
+    for key := range was {
+      keys = append(keys, key)
+    }
+    for key := range now {
+      if _, ok := was[key]; !ok {
+        keys = append(keys, key)
+      }
+    }
+    sort.Strings(keys)
+    for _, key := range keys {
+      oldTargets, matched := was[key]
+      newTargets, matches := now[key]
+      summary := issues[key].issue.Fields.Summary
+      switch {
+      case matches && !matched:
+        more += len(newTargets)
+        lines = append(lines, fmt.Sprintf("  + %s %s -> %s", key, summary, strings.Join(newTargets, ", ")))
+      case matched && !matches:
+        fewer += len(oldTargets)
+        lines = append(lines, fmt.Sprintf("  - %s %s -> %s", key, summary, strings.Join(oldTargets, ", ")))
+      case strings.Join(oldTargets, ",") != strings.Join(newTargets, ","):
+        more, fewer = more+len(newTargets), fewer+len(oldTargets)
+        lines = append(lines, fmt.Sprintf("  ~ %s %s -> %s instead of %s", key, summary, strings.Join(newTargets, ", "), strings.Join(oldTargets, ", ")))
+      }
+    }
+    if len(lines) == 0 {
+      continue
+    }
+    changed++
+    switch {
+    case oldRule == nil:
+      fmt.Printf("rule %s is new:\n", name)
+    case newRule == nil:
+      fmt.Printf("rule %s is removed:\n", name)
+    default:
+      fmt.Printf("rule %s:\n", name)
+    }
+    fmt.Println(strings.Join(lines, "\n"))
+  }
+
+  if changed == 0 {
+    fmt.Println("no issue would have matched differently")
+    return
+  }
+  fmt.Printf("%d rules would have matched differently: %d notifications more, %d fewer\n", changed, more, fewer)
+  os.Exit(1)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "os"
+  "sort"
+  "strings"
+  "text/template"
+  "time"
+)
+
+// a rule for every project in a category or with a key prefix, from a rule
+// template, so a new team's project is tracked as soon as it's created. the
+// projects are listed every interval, rules are added for new ones and
+// removed for ones that stopped matching. the template gets the vars plus
+// project, project_name, category and lead. a configured rule with the
+// same name wins over a discovered one
+//
+//   discovery:
+//     category: Engineering   # and/or
+//     prefix: ENG
+//     exclude: [ENGOLD]
+//     template: team-bugs
+//     name: "{{.project}}-bugs"   # discovered-{{.project}} by default
+//     vars: {channel: eng-slack}
+//     interval: 1h                # the default
+type DiscoveryConfig struct {
+  Category string            `yaml:"category"`
+  Prefix   string            `yaml:"prefix"`
+  Exclude  []string          `yaml:"exclude"`
+  Template string            `yaml:"template"`
+  Name     string            `yaml:"name"`
+  Vars     map[string]string `yaml:"vars"`
+  Interval string            `yaml:"interval"`
+}
+
+type discoveredProject struct {
+  Key      string `json:"key"`
+  Name     string `json:"name"`
+  Category struct {
+    Name string `json:"name"`
+  } `json:"projectCategory"`
+  Lead struct {
+    Name        string `json:"name"`
+    AccountId   string `json:"accountId"`
+    DisplayName string `json:"displayName"`
+  } `json:"lead"`
+}
+
+type projectDiscovery struct {
+  config   DiscoveryConfig
+  name     *template.Template
+  interval time.Duration
+  creds    *Config
+  projects map[string]bool // the keys with rules
+}
+
+// nil when there's no discovery configured
+var discovery *projectDiscovery
+
+func loadDiscovery(creds *Config) {
+  c := creds.Discovery
+  if len(c.Category) == 0 && len(c.Prefix) == 0 && len(c.Template) == 0 {
+    return
+  }
+  if len(c.Template) == 0 || (len(c.Category) == 0 && len(c.Prefix) == 0) {
+    logger.Print("discovery needs a template, and a category or a prefix")
+    os.Exit(1)
+  }
+  if _, ok := creds.RuleTemplates[c.Template]; !ok {
+    logger.Print("discovery uses rule template ", c.Template, ", which isn't in rule_templates")
+    os.Exit(1)
+  }
+  if len(c.Name) == 0 {
+    c.Name = "discovered-{{.project}}"
+  }
+  name, err := template.New("name").Option("missingkey=error").Parse(c.Name)
+  if err != nil {
+    logger.Print("Invalid discovery.name: ", err)
+    os.Exit(1)
+  }
+  discovery = &projectDiscovery{
+    config: c, name: name, interval: durationOr(c.Interval, time.Hour), creds: creds, projects: map[string]bool{},
+  }
+}
+
+func (d *projectDiscovery) matches(p discoveredProject) bool {
+  for _, key := range d.config.Exclude {
+    if strings.EqualFold(key, p.Key) {
+      return false
+    }
+  }
+  if len(d.config.Category) > 0 && !strings.EqualFold(d.config.Category, p.Category.Name) {
+    return false
+  }
+  return strings.HasPrefix(strings.ToUpper(p.Key), strings.ToUpper(d.config.Prefix))
+}
+
+// the matching projects, sorted by key
+func (d *projectDiscovery) list() ([]discoveredProject, error) {
+  contents, err := jiraRequest("GET", "/project?expand=lead", nil, d.creds)
+  if err != nil {
+    return nil, err
+  }
+  var all []discoveredProject
+  if err := json.Unmarshal(contents, &all); err != nil {
+    return nil, fmt.Errorf("Error parsing the projects: %v", err)
+  }
+  matching := []discoveredProject{}
+  for _, p := range all {
+    if d.matches(p) {
+      matching = append(matching, p)
+    }
+  }
+  sort.Slice(matching, func(i, j int) bool { return matching[i].Key < matching[j].Key })
+  return matching, nil
+}
+
+// the rules for the projects, built like the config's
+func (d *projectDiscovery) rules(projects []discoveredProject) ([]*Rule, error) {
+  specs := []Rule{}
+  for _, p := range projects {
+    lead := p.Lead.Name
+    if len(lead) == 0 {
+      lead = p.Lead.AccountId
+    }
+    vars := map[string]string{"project": p.Key, "project_name": p.Name, "category": p.Category.Name, "lead": lead}
+    for name, value := range d.config.Vars {
+      vars[name] = value
+    }
+    var name bytes.Buffer
+    if err := d.name.Execute(&name, vars); err != nil {
+      return nil, fmt.Errorf("discovery.name for %s: %v", p.Key, err)
+    }
+    specs = append(specs, Rule{Name: name.String(), Template: d.config.Template, Vars: vars})
+  }
+  return buildRules(specs, d.creds)
+}
+
+// list the projects and poll their rules, logging what changed
+func (d *projectDiscovery) discover() error {
+  projects, err := d.list()
+  if err != nil {
+    return err
+  }
+  rules, err := d.rules(projects)
+  if err != nil {
+    return err
+  }
+  keys := map[string]bool{}
+  for _, p := range projects {
+    keys[p.Key] = true
+    if !d.projects[p.Key] {
+      logger.Print("Discovered project ", p.Key, " (", p.Name, ")")
+    }
+  }
+  for key := range d.projects {
+    if !keys[key] {
+      logger.Print("Project ", key, " no longer matches discovery, stopping its rule")
+    }
+  }
+  d.projects = keys
+  added, changed, removed := applyDiscoveredRules(rules)
+  if added > 0 || changed > 0 || removed > 0 {
+    logger.Print(fmt.Sprintf("Discovery: %d projects, %d rules added, %d changed, %d removed", len(projects), added, changed, removed))
+  }
+  return nil
+}
+
+func (d *projectDiscovery) discoverForever() {
+  for {
+    if err := d.discover(); err != nil {
+      logger.Print("Error discovering projects, keeping the current rules: ", err)
+    }
+    time.Sleep(d.interval)
+  }
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+  "crypto/sha1"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+  "time"
+)
+
+// rarely changing metadata can be cached on disk so a restart doesn't fetch
+// it all again, configured under `cache`. ttls are by uri prefix, the
+// longest matching prefix wins, and uris without one aren't cached:
+//
+//   cache:
+//     dir: ./cache
+//     ttls:
+//       /field: 24h
+//       /project: 6h
+//       /user: 1h
+type CacheConfig struct {
+  Dir  string            `yaml:"dir"`
+  TTLs map[string]string `yaml:"ttls"`
+}
+
+var defaultCacheTTLs = map[string]string{
+  "/field":   "24h",
+  "/project": "6h",
+  "/user":    "1h",
+  "/group":   "1h",
+}
+
+// the cache used by jiraCached, set up in setup(). a nil cache fetches
+// everything
+var responseCache *diskCache
+
+type diskCache struct {
+  dir      string
+  prefixes []string // longest first
+  ttls     map[string]time.Duration
+}
+
+type cachedResponse struct {
+  Uri      string          `json:"uri"`
+  Fetched  time.Time       `json:"fetched"`
+  Contents json.RawMessage `json:"contents"`
+}
+
+func newDiskCache(c CacheConfig) *diskCache {
+  if len(c.Dir) == 0 {
+    return nil
+  }
+  if err := os.MkdirAll(c.Dir, 0700); err != nil {
+    logger.Print("Error creating cache directory: ", err)
+    os.Exit(1)
+  }
+
+  d := &diskCache{dir: c.Dir, ttls: map[string]time.Duration{}}
+  ttls := c.TTLs
+  if len(ttls) == 0 {
+    ttls = defaultCacheTTLs
+  }
+  for prefix, ttl := range ttls {
+    d.ttls[prefix] = durationOr(ttl, 0)
+    d.prefixes = append(d.prefixes, prefix)
+  }
+  sort.Slice(d.prefixes, func(i, j int) bool { return len(d.prefixes[i]) > len(d.prefixes[j]) })
+  return d
+}
+
+func (d *diskCache) ttl(uri string) time.Duration {
+  for _, prefix := range d.prefixes {
+    if strings.HasPrefix(uri, prefix) {
+      return d.ttls[prefix]
+    }
+  }
+  return 0
+}
+
+func (d *diskCache) path(uri string) string {
+  if fipsMode {
+    sum := sha256.Sum256([]byte(uri))
+    return filepath.Join(d.dir, hex.EncodeToString(sum[:])+".json")
+  }
+  sum := sha1.Sum([]byte(uri))
+  return filepath.Join(d.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (d *diskCache) get(uri string, ttl time.Duration) []byte {
+  contents, err := ioutil.ReadFile(d.path(uri))
+  if err != nil {
+    return nil
+  }
+  var cached cachedResponse
+  if err := json.Unmarshal(contents, &cached); err != nil || cached.Uri != uri {
+    return nil
+  }
+  if time.Since(cached.Fetched) > ttl {
+    return nil
+  }
+  return cached.Contents
+}
+
+func (d *diskCache) put(uri string, contents []byte) {
+  if !json.Valid(contents) {
+    return
+  }
+  encoded, err := json.Marshal(cachedResponse{uri, time.Now(), contents})
+  if err != nil {
+    return
+  }
+  path := d.path(uri)
+  if err := ioutil.WriteFile(path+".tmp", encoded, 0600); err == nil {
+    os.Rename(path+".tmp", path)
+  }
+}
+
+// GET a metadata uri through the disk cache when one is configured and the
+// uri has a ttl
+func jiraCached(uri string, creds *Config) ([]byte, error) {
+  if responseCache == nil {
+    return jiraRequest("GET", uri, nil, creds)
+  }
+  ttl := responseCache.ttl(uri)
+  if ttl <= 0 {
+    return jiraRequest("GET", uri, nil, creds)
+  }
+  if contents := responseCache.get(uri, ttl); contents != nil {
+    return contents, nil
+  }
+  contents, err := jiraRequest("GET", uri, nil, creds)
+  if err == nil {
+    responseCache.put(uri, contents)
+  }
+  return contents, err
+}
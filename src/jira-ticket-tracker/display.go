@@ -0,0 +1,88 @@
+package main
+
+import (
+  "strings"
+)
+
+// the emojis and colors for priorities, statuses and issue types, for
+// templates (priorityEmoji, statusEmoji, typeEmoji and color), configured
+// under `display`. the config's entries are added to (or replace) the
+// default priority emojis, names match case insensitively
+//
+//   display:
+//     emoji:
+//       priority: {blocker: "🔥", p1: "🚨"}
+//       status: {"in progress": "🔧", done: "✅"}
+//       issuetype: {bug: "🐛", story: "📖"}
+//     colors:
+//       priority: {blocker: "#d04437", high: "#f15c75"}
+type DisplayConfig struct {
+  Emoji  DisplayMap `yaml:"emoji"`
+  Colors DisplayMap `yaml:"colors"`
+}
+
+type DisplayMap struct {
+  Priority  map[string]string `yaml:"priority"`
+  Status    map[string]string `yaml:"status"`
+  IssueType map[string]string `yaml:"issuetype"`
+}
+
+var defaultPriorityEmojis = map[string]string{
+  "blocker":  "🔥",
+  "highest":  "🔥",
+  "critical": "🚨",
+  "high":     "🔴",
+  "major":    "🔴",
+  "medium":   "🟠",
+  "minor":    "🟡",
+  "low":      "🟢",
+  "lowest":   "⚪",
+  "trivial":  "⚪",
+}
+
+// what's displayed for each kind of value, loaded in setup
+var display = map[string]map[string]string{
+  "emoji priority": defaultPriorityEmojis,
+}
+
+func loadDisplay(creds *Config) {
+  display = map[string]map[string]string{}
+  add := func(name string, defaults, configured map[string]string) {
+    m := map[string]string{}
+    for k, v := range defaults {
+      m[k] = v
+    }
+    for k, v := range configured {
+      m[strings.ToLower(k)] = v
+    }
+    display[name] = m
+  }
+  c := creds.Display
+  add("emoji priority", defaultPriorityEmojis, c.Emoji.Priority)
+  add("emoji status", nil, c.Emoji.Status)
+  add("emoji issuetype", nil, c.Emoji.IssueType)
+  add("color priority", nil, c.Colors.Priority)
+  add("color status", nil, c.Colors.Status)
+  add("color issuetype", nil, c.Colors.IssueType)
+}
+
+func displayed(kind, value string) string {
+  return display[kind][strings.ToLower(value)]
+}
+
+func priorityEmoji(priority string) string {
+  return displayed("emoji priority", priority)
+}
+
+func statusEmoji(status string) string {
+  return displayed("emoji status", status)
+}
+
+func typeEmoji(issueType string) string {
+  return displayed("emoji issuetype", issueType)
+}
+
+// the color for a priority, status or issuetype, e.g. {{color "status" ..}}
+func displayColor(kind, value string) string {
+  return displayed("color "+strings.ToLower(kind), value)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+  "crypto/hmac"
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "strings"
+  "time"
+)
+
+// the priorities that get through everyone's do not disturb, blocker by
+// default, and who may change whose
+//
+//   dnd:
+//     override: [Blocker, Critical]
+//     token: ...                 # anyone's, for the operator
+//     tokens: {alice: ...}       # each user's own
+//
+// each user sets their own quiet hours and muted projects on the control
+// api with their token, see handleDND. a target with an owner respects the
+// owner's, and a personalized email target each recipient's
+type DNDConfig struct {
+  Override []string          `yaml:"override"`
+  Token    string            `yaml:"token"`
+  Tokens   map[string]string `yaml:"tokens"`
+}
+
+// whether the request's bearer token is the user's, or the operator's.
+// no user means everyone's, which only the operator's token is for
+func dndAuthorized(r *http.Request, user string) bool {
+  given := []byte(r.Header.Get("Authorization"))
+  if len(dndConfig.Token) > 0 && hmac.Equal([]byte("Bearer "+dndConfig.Token), given) {
+    return true
+  }
+  token := dndConfig.Tokens[user]
+  return len(user) > 0 && len(token) > 0 && hmac.Equal([]byte("Bearer "+token), given)
+}
+
+// a user's do not disturb, kept in the state
+type DNDPreferences struct {
+  User          string   `json:"user"`
+  QuietHours    string   `json:"quiet_hours,omitempty"` // like 22:00-07:00, in the timezone
+  Timezone      string   `json:"timezone,omitempty"`    // utc by default
+  MutedProjects []string `json:"muted_projects,omitempty"`
+
+  location *time.Location
+  quiet    *quietHours
+}
+
+// the tokens handleDND checks, see loadDND
+var dndConfig DNDConfig
+
+func loadDND(creds *Config) {
+  if creds.DND.Override == nil {
+    creds.DND.Override = []string{"Blocker"}
+  }
+  dndConfig = creds.DND
+}
+
+func (p *DNDPreferences) parse() error {
+  var err error
+  p.location = time.UTC
+  if len(p.Timezone) > 0 {
+    if p.location, err = time.LoadLocation(p.Timezone); err != nil {
+      return err
+    }
+  }
+  if p.quiet, err = parseQuietHours(p.QuietHours); err != nil {
+    return fmt.Errorf("quiet_hours: %v", err)
+  }
+  return nil
+}
+
+// why the user shouldn't be sent the event now, empty if they should
+func dndReason(user string, event *Event, creds *Config) string {
+  if len(user) == 0 || event.Issue.Key == trackerKey {
+    return ""
+  }
+  p := state.DNDFor(user)
+  if p == nil || contains(creds.DND.Override, fieldString(event.Fields, "priority.name")) {
+    return ""
+  }
+  if project := issueProject(event); len(project) > 0 && contains(p.MutedProjects, project) {
+    return user + " muted " + project
+  }
+  if !p.quiet.over(time.Now().In(p.location)).IsZero() {
+    return "it's " + user + "'s quiet hours"
+  }
+  return ""
+}
+
+// the events the target's owner wants now, if it has one
+func (s *sink) undisturbed(events []*Event) []*Event {
+  if len(s.target.Owner) == 0 {
+    return events
+  }
+  wanted := []*Event{}
+  for _, event := range events {
+    if reason := dndReason(s.target.Owner, event, s.creds); len(reason) > 0 {
+      logger.Print("Not sending ", event.Issue.Key, " to ", s.name, ", ", reason)
+      pipeline.step(event, PipelineStep{Stage: "dnd", Target: s.name, Detail: reason})
+    } else {
+      wanted = append(wanted, event)
+    }
+  }
+  return wanted
+}
+
+// GET /dnd lists everyone's do not disturb, one user's with ?user=. POST
+// sets a user's and DELETE clears it:
+//
+//   {"user": "alice", "quiet_hours": "22:00-07:00", "timezone": "Europe/Berlin", "muted_projects": ["LEGACY"]}
+//
+// each takes the user's token, or the operator's, as a bearer token
+func handleDND(w http.ResponseWriter, r *http.Request) {
+  if r.Method == "GET" {
+    user := r.URL.Query().Get("user")
+    if !dndAuthorized(r, user) {
+      writeError(w, http.StatusUnauthorized, "the user's dnd token, or the operator's, is required")
+      return
+    }
+    all := []DNDPreferences{}
+    for _, p := range state.AllDND() {
+      if len(user) == 0 || p.User == user {
+        all = append(all, p)
+      }
+    }
+    writeJSON(w, http.StatusOK, all)
+    return
+  }
+  if r.Method != "POST" && r.Method != "DELETE" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+
+  var p DNDPreferences
+  if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+    writeError(w, http.StatusBadRequest, "invalid json: "+err.Error())
+    return
+  }
+  if len(p.User) == 0 {
+    writeError(w, http.StatusBadRequest, "user is required")
+    return
+  }
+  if !dndAuthorized(r, p.User) {
+    writeError(w, http.StatusUnauthorized, "the user's dnd token, or the operator's, is required")
+    return
+  }
+  if r.Method == "DELETE" {
+    if !state.ClearDND(p.User) {
+      writeError(w, http.StatusNotFound, p.User+" has no do not disturb")
+      return
+    }
+    logger.Print("Cleared the do not disturb of ", p.User)
+    writeJSON(w, http.StatusOK, p)
+    return
+  }
+  if err := p.parse(); err != nil {
+    writeError(w, http.StatusBadRequest, err.Error())
+    return
+  }
+  for i, project := range p.MutedProjects {
+    p.MutedProjects[i] = strings.ToUpper(strings.TrimSpace(project))
+  }
+  state.SetDND(&p)
+  logger.Print("Set the do not disturb of ", p.User)
+  writeJSON(w, http.StatusOK, p)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+  "fmt"
+  "sort"
+  "strings"
+  "time"
+)
+
+// a duplicate-link target looks for a probable duplicate of each new issue
+// in the other projects and links the two, so whoever picks either one up
+// sees the other
+//
+//   targets:
+//     link-duplicates:
+//       type: duplicate-link
+//       duplicates:
+//         projects: [OPS, SUPPORT]  # where to look, besides the issue's own
+//         link: Duplicate           # the link type, e.g. Relates
+//         similarity: 0.5           # how alike the summaries' words must be
+//         within: 30d               # only issues created this recently
+//
+// the open issue whose summary shares the most words with the new one's
+// wins, if its share is at least the similarity. the new issue is linked
+// as the duplicate of the older one
+type DuplicatesConfig struct {
+  Projects   []string `yaml:"projects"`
+  Link       string   `yaml:"link"`
+  Similarity float64  `yaml:"similarity"`
+  Within     string   `yaml:"within"`
+}
+
+type duplicateLinkNotifier struct {
+  name   string
+  config DuplicatesConfig
+  within time.Duration
+  creds  *Config
+}
+
+func newDuplicateLinkNotifier(name string, config DuplicatesConfig, creds *Config) (*duplicateLinkNotifier, error) {
+  if len(config.Projects) == 0 {
+    return nil, fmt.Errorf("duplicates.projects is required")
+  }
+  if len(config.Link) == 0 {
+    config.Link = "Duplicate"
+  }
+  if config.Similarity <= 0 || config.Similarity > 1 {
+    config.Similarity = 0.5
+  }
+  return &duplicateLinkNotifier{name: name, config: config, within: durationOr(config.Within, 30*24*time.Hour), creds: creds}, nil
+}
+
+// the summary's words that say something about it
+func summaryWords(summary string) map[string]bool {
+  words := map[string]bool{}
+  for _, word := range strings.FieldsFunc(strings.ToLower(summary), func(r rune) bool {
+    return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+  }) {
+    if len(word) >= 3 {
+      words[word] = true
+    }
+  }
+  return words
+}
+
+// how many of the two summaries' words they share, from 0 to 1
+func summarySimilarity(a, b map[string]bool) float64 {
+  if len(a) == 0 || len(b) == 0 {
+    return 0
+  }
+  shared := 0
+  for word := range a {
+    if b[word] {
+      shared++
+    }
+  }
+  return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// the other projects' open issue most like this one, empty if none is
+// alike enough
+func (n *duplicateLinkNotifier) probableDuplicate(event *Event) (string, float64, error) {
+  words := summaryWords(event.Issue.Fields.Summary)
+  if len(words) == 0 {
+    return "", 0, nil
+  }
+  own := issueProject(event)
+  projects := []string{}
+  for _, project := range n.config.Projects {
+    if !strings.EqualFold(project, own) {
+      projects = append(projects, jqlQuote(project))
+    }
+  }
+  if len(projects) == 0 {
+    return "", 0, nil
+  }
+  terms := []string{}
+  for word := range words {
+    terms = append(terms, word)
+  }
+  sort.Strings(terms)
+  // any of the words, the similarity sorts out the rest
+  jql := fmt.Sprintf("project in (%s) AND resolution = EMPTY AND created >= -%dm AND summary ~ %s",
+    strings.Join(projects, ", "), int(n.within.Minutes()), jqlQuote(strings.Join(terms, " OR ")))
+  issues, _, err := searchIssues(jql, 50, n.creds)
+  if err != nil {
+    return "", 0, err
+  }
+  best, bestScore := "", 0.0
+  for _, issue := range issues {
+    if score := summarySimilarity(words, summaryWords(issue.Fields.Summary)); score > bestScore {
+      best, bestScore = issue.Key, score
+    }
+  }
+  if bestScore < n.config.Similarity {
+    return "", bestScore, nil
+  }
+  return best, bestScore, nil
+}
+
+func (n *duplicateLinkNotifier) Notify(event *Event, message string) error {
+  if event.Kind != eventCreated {
+    return nil
+  }
+  key := event.Issue.Key
+  dup, score, err := n.probableDuplicate(event)
+  if err != nil {
+    return fmt.Errorf("can't look for duplicates of %s: %v", key, err)
+  }
+  if len(dup) == 0 {
+    return nil
+  }
+  for _, link := range issueLinks(event.Fields) {
+    if link.key == dup {
+      return nil // already linked
+    }
+  }
+  body := map[string]interface{}{
+    "type":         map[string]string{"name": n.config.Link},
+    "inwardIssue":  map[string]string{"key": key},
+    "outwardIssue": map[string]string{"key": dup},
+  }
+  if _, err := jiraRequest("POST", "/issueLink", body, n.creds.accountFor("edit")); err != nil {
+    return fmt.Errorf("can't link %s to %s: %v", key, dup, err)
+  }
+  logger.Print(fmt.Sprintf("%s: linked %s to %s (%s), their summaries are %.0f%% alike", n.name, key, dup, n.config.Link, score*100))
+  return nil
+}
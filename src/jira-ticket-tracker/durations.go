@@ -0,0 +1,28 @@
+package main
+
+import (
+  "strconv"
+  "strings"
+  "time"
+)
+
+// parseDuration is time.ParseDuration plus a "d" suffix for days, which
+// most of the config wants (e.g. "3d", "1d12h")
+func parseDuration(s string) (time.Duration, error) {
+  s = strings.TrimSpace(s)
+  if i := strings.Index(s, "d"); i > 0 {
+    days, err := strconv.Atoi(s[:i])
+    if err != nil {
+      return 0, err
+    }
+    rest := time.Duration(0)
+    if len(s[i+1:]) > 0 {
+      rest, err = time.ParseDuration(s[i+1:])
+      if err != nil {
+        return 0, err
+      }
+    }
+    return time.Duration(days)*24*time.Hour + rest, nil
+  }
+  return time.ParseDuration(s)
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+// dynamodb storage, for deployments on aws where running a database is
+// overkill. everything is kept in one table, with a string partition key
+// pk and sort key sk, and dynamodb's ttl on `expires` does the retention
+//
+//   storage:
+//     type: dynamodb
+//     dynamodb:
+//       table: jira-tracker
+//       region: us-east-1
+//       create: true     # create the table if it doesn't exist
+//       endpoint: http://localhost:8000 # e.g. dynamodb local, aws by default
+//
+// history is dropped after history.retention, if set, and encrypted with
+// encryption on. seen keys and timers expire as they would anywhere else
+type DynamoDBConfig struct {
+  Table    string `yaml:"table"`
+  Region   string `yaml:"region"`
+  Create   bool   `yaml:"create"`
+  Endpoint string `yaml:"endpoint"`
+}
+
+func init() {
+  storageBackends["dynamodb"] = openDynamoDBStorage
+}
+
+type dynamoStorage struct {
+  config    DynamoDBConfig
+  client    *http.Client
+  retention time.Duration
+
+  mu     sync.Mutex
+  oldest string // the day of the first history partition, once known
+}
+
+// an attribute value in dynamodb's json, one of S or N
+type dynamoValue struct {
+  S string `json:"S,omitempty"`
+  N string `json:"N,omitempty"`
+}
+
+type dynamoItem map[string]dynamoValue
+
+func dynamoString(s string) dynamoValue { return dynamoValue{S: s} }
+
+func dynamoTime(t time.Time) dynamoValue {
+  return dynamoValue{N: strconv.FormatInt(t.Unix(), 10)}
+}
+
+func openDynamoDBStorage(creds *Config) (Storage, error) {
+  config := creds.Storage.DynamoDB
+  if len(config.Table) == 0 || len(config.Region) == 0 {
+    return nil, fmt.Errorf("storage.dynamodb.table and region are required")
+  }
+  if len(config.Endpoint) == 0 {
+    config.Endpoint = "https://dynamodb." + config.Region + ".amazonaws.com"
+  }
+  d := &dynamoStorage{
+    config:    config,
+    client:    &http.Client{Timeout: 30 * time.Second},
+    retention: durationOr(creds.History.Retention, 0),
+  }
+  if err := d.ensureTable(); err != nil {
+    return nil, err
+  }
+  if err := d.findOldestHistory(); err != nil {
+    return nil, err
+  }
+  return d, nil
+}
+
+func (d *dynamoStorage) call(action string, body, out interface{}) error {
+  contents, err := json.Marshal(body)
+  if err != nil {
+    return err
+  }
+  headers := map[string]string{
+    "Content-Type": "application/x-amz-json-1.0",
+    "X-Amz-Target": "DynamoDB_20120810." + action,
+  }
+  response, err := awsRequest(d.client, "POST", d.config.Endpoint+"/", headers, contents, "dynamodb", d.config.Region)
+  if err != nil {
+    return fmt.Errorf("dynamodb %s: %v", action, err)
+  }
+  if out != nil {
+    return json.Unmarshal(response, out)
+  }
+  return nil
+}
+
+func dynamoErrorIs(err error, kind string) bool {
+  for err != nil {
+    if e, ok := err.(*awsError); ok {
+      return e.Type == kind
+    }
+    unwrapped, ok := err.(interface{ Unwrap() error })
+    if !ok {
+      return false
+    }
+    err = unwrapped.Unwrap()
+  }
+  return false
+}
+
+// create the table, with its ttl, if asked to and it isn't there
+func (d *dynamoStorage) ensureTable() error {
+  table := map[string]interface{}{"TableName": d.config.Table}
+  var described struct {
+    Table struct {
+      TableStatus string `json:"TableStatus"`
+    } `json:"Table"`
+  }
+  err := d.call("DescribeTable", table, &described)
+  if err == nil || !dynamoErrorIs(err, "ResourceNotFoundException") || !d.config.Create {
+    return err
+  }
+
+  logger.Print("Creating the dynamodb table ", d.config.Table)
+  err = d.call("CreateTable", map[string]interface{}{
+    "TableName":   d.config.Table,
+    "BillingMode": "PAY_PER_REQUEST",
+    "AttributeDefinitions": []map[string]string{
+      {"AttributeName": "pk", "AttributeType": "S"},
+      {"AttributeName": "sk", "AttributeType": "S"},
+    },
+    "KeySchema": []map[string]string{
+      {"AttributeName": "pk", "KeyType": "HASH"},
+      {"AttributeName": "sk", "KeyType": "RANGE"},
+    },
+  }, nil)
+  if err != nil {
+    return err
+  }
+  for described.Table.TableStatus != "ACTIVE" {
+    time.Sleep(2 * time.Second)
+    if err := d.call("DescribeTable", table, &described); err != nil {
+      return err
+    }
+  }
+  return d.call("UpdateTimeToLive", map[string]interface{}{
+    "TableName":               d.config.Table,
+    "TimeToLiveSpecification": map[string]interface{}{"AttributeName": "expires", "Enabled": true},
+  }, nil)
+}
+
+func (d *dynamoStorage) get(pk, sk string) (dynamoItem, error) {
+  var out struct {
+    Item dynamoItem `json:"Item"`
+  }
+  err := d.call("GetItem", map[string]interface{}{
+    "TableName":      d.config.Table,
+    "Key":            dynamoItem{"pk": dynamoString(pk), "sk": dynamoString(sk)},
+    "ConsistentRead": true,
+  }, &out)
+  return out.Item, err
+}
+
+func (d *dynamoStorage) put(item dynamoItem) error {
+  return d.call("PutItem", map[string]interface{}{"TableName": d.config.Table, "Item": item}, nil)
+}
+
+func (d *dynamoStorage) delete(pk, sk string) error {
+  return d.call("DeleteItem", map[string]interface{}{
+    "TableName": d.config.Table,
+    "Key":       dynamoItem{"pk": dynamoString(pk), "sk": dynamoString(sk)},
+  }, nil)
+}
+
+// the time an item holds, false if there is no item
+func timeAttribute(item dynamoItem, err error) (time.Time, bool, error) {
+  if err != nil || item == nil {
+    return time.Time{}, false, err
+  }
+  t, err := time.Parse(time.RFC3339Nano, item["at"].S)
+  return t, err == nil, err
+}
+
+func (d *dynamoStorage) Checkpoint(rule string) (time.Time, bool, error) {
+  return timeAttribute(d.get("checkpoint", rule))
+}
+
+func (d *dynamoStorage) SetCheckpoint(rule string, t time.Time) error {
+  return d.put(dynamoItem{"pk": dynamoString("checkpoint"), "sk": dynamoString(rule), "at": dynamoString(t.Format(time.RFC3339Nano))})
+}
+
+// a conditional put, which fails if the key is there and hasn't expired.
+// the ttl only deletes items eventually, so the condition checks it too,
+// comparing the unix times as numbers
+func (d *dynamoStorage) MarkSeen(set, key string, ttl time.Duration) (bool, error) {
+  now := time.Now()
+  err := d.call("PutItem", map[string]interface{}{
+    "TableName": d.config.Table,
+    "Item": dynamoItem{
+      "pk": dynamoString("seen#" + set), "sk": dynamoString(key), "expires": dynamoTime(now.Add(ttl)),
+    },
+    "ConditionExpression":       "attribute_not_exists(pk) OR expires <= :now",
+    "ExpressionAttributeValues": dynamoItem{":now": dynamoTime(now)},
+  }, nil)
+  if dynamoErrorIs(err, "ConditionalCheckFailedException") {
+    return true, nil
+  }
+  return false, err
+}
+
+func (d *dynamoStorage) ForgetSeen(set, key string) error {
+  return d.delete("seen#"+set, key)
+}
+
+// history goes in a partition a day, sorted by time. the sort keys start
+// with a fixed width time so they sort as the times do
+const historySortLayout = "2006-01-02T15:04:05.000000000Z"
+
+func historyPartition(t time.Time) string {
+  return "history#" + t.UTC().Format("2006-01-02")
+}
+
+// the first day with history is kept in an item of its own, since the
+// partitions can't be listed without a scan. look it up, scanning for it
+// once in tables from before it was kept
+func (d *dynamoStorage) findOldestHistory() error {
+  item, err := d.get("history-oldest", "day")
+  if err != nil {
+    return err
+  }
+  if item != nil {
+    d.oldest = item["day"].S
+    return nil
+  }
+  oldest := ""
+  var start dynamoItem
+  for {
+    scan := map[string]interface{}{
+      "TableName":                 d.config.Table,
+      "FilterExpression":          "begins_with(pk, :history)",
+      "ProjectionExpression":      "pk",
+      "ExpressionAttributeValues": dynamoItem{":history": dynamoString("history#")},
+    }
+    if start != nil {
+      scan["ExclusiveStartKey"] = start
+    }
+    var page struct {
+      Items            []dynamoItem `json:"Items"`
+      LastEvaluatedKey dynamoItem   `json:"LastEvaluatedKey"`
+    }
+    if err := d.call("Scan", scan, &page); err != nil {
+      return err
+    }
+    for _, item := range page.Items {
+      if day := strings.TrimPrefix(item["pk"].S, "history#"); len(oldest) == 0 || day < oldest {
+        oldest = day
+      }
+    }
+    if page.LastEvaluatedKey == nil {
+      break
+    }
+    start = page.LastEvaluatedKey
+  }
+  if len(oldest) == 0 {
+    return nil // no history yet, the first append records its day
+  }
+  return d.noteHistoryDay(oldest)
+}
+
+// record a day with history, if it's before the first one known. the put
+// only replaces a later day, so replicas can't move it forward
+func (d *dynamoStorage) noteHistoryDay(day string) error {
+  d.mu.Lock()
+  known := d.oldest
+  d.mu.Unlock()
+  if len(known) > 0 && known <= day {
+    return nil
+  }
+  err := d.call("PutItem", map[string]interface{}{
+    "TableName":                 d.config.Table,
+    "Item":                      dynamoItem{"pk": dynamoString("history-oldest"), "sk": dynamoString("day"), "day": dynamoString(day)},
+    "ConditionExpression":       "attribute_not_exists(pk) OR #day > :day",
+    "ExpressionAttributeNames":  map[string]string{"#day": "day"},
+    "ExpressionAttributeValues": dynamoItem{":day": dynamoString(day)},
+  }, nil)
+  if err != nil && !dynamoErrorIs(err, "ConditionalCheckFailedException") {
+    return err
+  }
+  // an earlier day is already there if the condition failed, so the days
+  // from this one on need no put either way
+  d.mu.Lock()
+  if len(d.oldest) == 0 || day < d.oldest {
+    d.oldest = day
+  }
+  d.mu.Unlock()
+  return nil
+}
+
+func (d *dynamoStorage) AppendHistory(records []HistoryRecord) error {
+  for i, r := range records {
+    contents, err := json.Marshal(r)
+    if err != nil {
+      return err
+    }
+    if err := d.noteHistoryDay(r.Time.UTC().Format("2006-01-02")); err != nil {
+      return err
+    }
+    item := dynamoItem{
+      "pk":     dynamoString(historyPartition(r.Time)),
+      "sk":     dynamoString(fmt.Sprintf("%s#%s#%d", r.Time.UTC().Format(historySortLayout), r.Key, i)),
+      "record": dynamoString(string(sealLine(contents))),
+    }
+    if d.retention > 0 {
+      item["expires"] = dynamoTime(r.Time.Add(d.retention))
+    }
+    if err := d.put(item); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// the record an item holds, decrypted if it was encrypted
+func dynamoRecord(item dynamoItem) (HistoryRecord, error) {
+  var r HistoryRecord
+  contents, err := unsealLine([]byte(item["record"].S))
+  if err != nil {
+    return r, err
+  }
+  err = json.Unmarshal(contents, &r)
+  return r, err
+}
+
+// each day's partition from since's, or from the first day with history if
+// that's later, as with a zero since
+func (d *dynamoStorage) EachHistory(since time.Time, fn func(HistoryRecord) bool) error {
+  from := since.UTC().Format(historySortLayout)
+  first := since.UTC().Truncate(24 * time.Hour)
+  d.mu.Lock()
+  oldest := d.oldest
+  d.mu.Unlock()
+  if len(oldest) == 0 {
+    // another replica may have appended since the table was opened
+    item, err := d.get("history-oldest", "day")
+    if err != nil || item == nil {
+      return err
+    }
+    oldest = item["day"].S
+    d.mu.Lock()
+    d.oldest = oldest
+    d.mu.Unlock()
+  }
+  if day, err := time.Parse("2006-01-02", oldest); err == nil && day.After(first) {
+    first = day
+  }
+  if d.retention > 0 {
+    if expired := time.Now().UTC().Add(-d.retention).Truncate(24 * time.Hour); expired.After(first) {
+      first = expired
+    }
+  }
+  for day := first; !day.After(time.Now().UTC()); day = day.Add(24 * time.Hour) {
+    var start dynamoItem
+    for {
+      query := map[string]interface{}{
+        "TableName":                 d.config.Table,
+        "KeyConditionExpression":    "pk = :pk AND sk >= :from",
+        "ExpressionAttributeValues": dynamoItem{":pk": dynamoString(historyPartition(day)), ":from": dynamoString(from)},
+        "ConsistentRead":            true,
+      }
+      if start != nil {
+        query["ExclusiveStartKey"] = start
+      }
+      var page struct {
+        Items            []dynamoItem `json:"Items"`
+        LastEvaluatedKey dynamoItem   `json:"LastEvaluatedKey"`
+      }
+      if err := d.call("Query", query, &page); err != nil {
+        return err
+      }
+      for _, item := range page.Items {
+        r, err := dynamoRecord(item)
+        if err != nil {
+          logger.Print("Skipping history record ", item["sk"].S, ": ", err)
+          continue
+        }
+        if !fn(r) {
+          return nil
+        }
+      }
+      if page.LastEvaluatedKey == nil {
+        break
+      }
+      start = page.LastEvaluatedKey
+    }
+  }
+  return nil
+}
+
+// scans the whole table for the history, as its partitions go back to
+// whenever it started
+func (d *dynamoStorage) RewriteHistory(fn func(HistoryRecord) (*HistoryRecord, bool)) (int, error) {
+  type change struct {
+    item   dynamoItem
+    record *HistoryRecord
+  }
+  changes := []change{}
+  var start dynamoItem
+  for {
+    scan := map[string]interface{}{
+      "TableName":                 d.config.Table,
+      "FilterExpression":          "begins_with(pk, :history)",
+      "ExpressionAttributeValues": dynamoItem{":history": dynamoString("history#")},
+      "ConsistentRead":            true,
+    }
+    if start != nil {
+      scan["ExclusiveStartKey"] = start
+    }
+    var page struct {
+      Items            []dynamoItem `json:"Items"`
+      LastEvaluatedKey dynamoItem   `json:"LastEvaluatedKey"`
+    }
+    if err := d.call("Scan", scan, &page); err != nil {
+      return 0, err
+    }
+    for _, item := range page.Items {
+      r, err := dynamoRecord(item)
+      if err != nil {
+        logger.Print("Skipping history record ", item["sk"].S, ": ", err)
+        continue
+      }
+      if record, ok := fn(r); ok {
+        changes = append(changes, change{item, record})
+      }
+    }
+    if page.LastEvaluatedKey == nil {
+      break
+    }
+    start = page.LastEvaluatedKey
+  }
+  for i, c := range changes {
+    if c.record == nil {
+      if err := d.delete(c.item["pk"].S, c.item["sk"].S); err != nil {
+        return i, err
+      }
+      continue
+    }
+    contents, err := json.Marshal(c.record)
+    if err != nil {
+      return i, err
+    }
+    c.item["record"] = dynamoString(string(sealLine(contents)))
+    if err := d.put(c.item); err != nil {
+      return i, err
+    }
+  }
+  return len(changes), nil
+}
+
+func (d *dynamoStorage) SetTimer(kind, key string, at time.Time) error {
+  // kept a day past going off so Timer still finds it, then dropped
+  return d.put(dynamoItem{
+    "pk": dynamoString("timer#" + kind), "sk": dynamoString(key),
+    "at": dynamoString(at.Format(time.RFC3339Nano)), "expires": dynamoTime(at.Add(24 * time.Hour)),
+  })
+}
+
+func (d *dynamoStorage) Timer(kind, key string) (time.Time, bool, error) {
+  return timeAttribute(d.get("timer#"+kind, key))
+}
+
+func (d *dynamoStorage) DeleteTimer(kind, key string) error {
+  return d.delete("timer#"+kind, key)
+}
+
+func (d *dynamoStorage) Close() error { return nil }
@@ -0,0 +1,166 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "launchpad.net/goyaml"
+  "net/url"
+  "os"
+  "sort"
+  "strings"
+)
+
+func init() {
+  commands["config"] = command{"config effective", configCommand}
+}
+
+const maskedSecret = "********"
+
+// whether a config key holds a secret, by its name
+func secretKey(parent, key string) bool {
+  key = strings.ToLower(key)
+  for _, word := range []string{"password", "token", "secret", "api_key", "apikey", "webhook"} {
+    if strings.Contains(key, word) {
+      return true
+    }
+  }
+  return parent == "encryption" && key == "key"
+}
+
+// mask a url's credentials and query, which can hold a token. under a key
+// for a url, like a webhook's, the path is masked too, since services like
+// slack put the secret in it. only the scheme and host are left then
+func maskUrl(key, s string) string {
+  urlKey := strings.Contains(strings.ToLower(key), "url")
+  u, err := url.Parse(s)
+  if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+    if urlKey && len(s) > 0 {
+      return maskedSecret
+    }
+    return s
+  }
+  if u.User == nil && len(u.RawQuery) == 0 && (!urlKey || strings.Trim(u.Path, "/") == "") {
+    return s
+  }
+  masked := u.Scheme + "://"
+  if u.User != nil {
+    masked += maskedSecret + "@"
+  }
+  masked += u.Host
+  if urlKey && strings.Trim(u.Path, "/") != "" {
+    masked += "/" + maskedSecret
+  } else {
+    masked += u.EscapedPath()
+  }
+  if len(u.RawQuery) > 0 {
+    masked += "?" + maskedSecret
+  }
+  return masked
+}
+
+// mask the secrets in a decoded yaml document, and the secrets in any url
+func maskSecrets(parent string, value interface{}) interface{} {
+  switch v := value.(type) {
+  case map[interface{}]interface{}:
+    for k, child := range v {
+      name := fmt.Sprint(k)
+      if s, ok := child.(string); ok && len(s) > 0 && secretKey(parent, name) {
+        v[k] = maskedSecret
+      } else {
+        v[k] = maskSecrets(name, child)
+      }
+    }
+  case map[string]interface{}:
+    for name, child := range v {
+      if s, ok := child.(string); ok && len(s) > 0 && secretKey(parent, name) {
+        v[name] = maskedSecret
+      } else {
+        v[name] = maskSecrets(name, child)
+      }
+    }
+  case []interface{}:
+    for i, child := range v {
+      v[i] = maskSecrets(parent, child)
+    }
+  case string:
+    return maskUrl(parent, v)
+  }
+  return value
+}
+
+// the configuration the tracker is running with: the flags, the rules as
+// they're searched and the config file with every default filled in that
+// the config itself holds, secrets masked
+func effectiveConfig(creds *Config, rules []*Rule) (string, error) {
+  var out strings.Builder
+  out.WriteString("# flags\n")
+  names := []string{}
+  flag.VisitAll(func(f *flag.Flag) {
+    names = append(names, f.Name)
+  })
+  sort.Strings(names)
+  for _, name := range names {
+    value := flag.Lookup(name).Value.String()
+    if len(value) > 0 && secretKey("", name) {
+      value = maskedSecret
+    }
+    fmt.Fprintf(&out, "%s: %s\n", name, maskSecrets(name, value))
+  }
+
+  out.WriteString("\n# rules, as searched\n")
+  for _, rule := range rules {
+    polls := "every " + rule.interval.String()
+    if rule.cron != nil {
+      polls = "on " + rule.Schedule
+    }
+    fmt.Fprintf(&out, "%s: %s, polling %s, to %s\n", rule.Name, rule.query(), polls, strings.Join(rule.Targets, ", "))
+    kinds := []string{}
+    for kind := range rule.On {
+      kinds = append(kinds, kind)
+    }
+    sort.Strings(kinds)
+    for _, kind := range kinds {
+      fmt.Fprintf(&out, "  %s to %s\n", kind, strings.Join(rule.On[kind], ", "))
+    }
+  }
+
+  contents, err := goyaml.Marshal(creds)
+  if err != nil {
+    return "", err
+  }
+  var document interface{}
+  if err := goyaml.Unmarshal(contents, &document); err != nil {
+    return "", err
+  }
+  if contents, err = goyaml.Marshal(maskSecrets("", document)); err != nil {
+    return "", err
+  }
+  out.WriteString("\n# config\n")
+  out.Write(contents)
+  return out.String(), nil
+}
+
+// log what we're starting with, so "why isn't my rule firing" can be
+// answered from the logs
+func logStartupBanner(creds *Config, rules []*Rule) {
+  effective, err := effectiveConfig(creds, rules)
+  if err != nil {
+    logger.Print("Error resolving the configuration: ", err)
+    return
+  }
+  logger.Printf("Starting with %d rules and %d targets from %s, the effective configuration:\n%s",
+      len(rules), len(sinks), *config, effective)
+}
+
+func configCommand(args []string) {
+  if len(args) != 1 || args[0] != "effective" {
+    usageExit(commands["config"].usage)
+  }
+  creds, rules := setup()
+  effective, err := effectiveConfig(creds, rules)
+  if err != nil {
+    logger.Print("Error resolving the configuration: ", err)
+    os.Exit(1)
+  }
+  fmt.Print(effective)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+  "context"
+  "fmt"
+  "net"
+  "net/http"
+  "net/url"
+  "os"
+  "strings"
+  "syscall"
+  "time"
+)
+
+// the only places the tracker may connect to, so a mistyped or malicious
+// target url can't send issue data somewhere unexpected. entries are
+// hostnames, *.domain for a domain's subdomains, ips and cidrs. a hostname
+// that isn't listed is allowed if every address it's dialled at is in a
+// listed cidr. jira's host is always allowed. it's checked when dialling,
+// after dns, so a name can't be pointed elsewhere to get around it. with a
+// proxy, the proxy has to be allowed, and so does where it's asked to go
+//
+//   egress:
+//     allow:
+//       - hooks.slack.com
+//       - "*.acme.com"
+//       - 10.20.0.0/16
+type EgressConfig struct {
+  Allow []string `yaml:"allow"`
+}
+
+type egressPolicy struct {
+  hosts    []string // lowercase, with *. prefixes for subdomains
+  networks []*net.IPNet
+}
+
+// nil allows everything
+var egress *egressPolicy
+
+func loadEgress(creds *Config) {
+  if len(creds.Egress.Allow) == 0 {
+    return
+  }
+  p := &egressPolicy{}
+  if u, err := url.Parse(creds.Url); err == nil && len(u.Hostname()) > 0 {
+    p.hosts = append(p.hosts, strings.ToLower(u.Hostname()))
+  }
+  for _, entry := range creds.Egress.Allow {
+    if _, network, err := net.ParseCIDR(entry); err == nil {
+      p.networks = append(p.networks, network)
+    } else if ip := net.ParseIP(entry); ip != nil {
+      p.networks = append(p.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+    } else if len(entry) > 0 && !strings.ContainsAny(entry, "/:") {
+      p.hosts = append(p.hosts, strings.ToLower(strings.TrimSuffix(entry, ".")))
+    } else {
+      logger.Print("Invalid egress.allow entry ", entry, ", it can be a hostname, *.domain, ip or cidr")
+      os.Exit(1)
+    }
+  }
+  egress = p
+
+  // most of the tracker's http clients use the default transport
+  transport := http.DefaultTransport.(*http.Transport)
+  transport.DialContext = egressDialContext(&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second})
+  transport.Proxy = egressProxy(transport.Proxy)
+}
+
+func (p *egressPolicy) allowsHost(host string) bool {
+  host = strings.ToLower(strings.TrimSuffix(host, "."))
+  for _, allowed := range p.hosts {
+    if host == allowed || (strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:])) {
+      return true
+    }
+  }
+  return false
+}
+
+func (p *egressPolicy) allowsIP(ip net.IP) bool {
+  for _, network := range p.networks {
+    if network.Contains(ip) {
+      return true
+    }
+  }
+  return false
+}
+
+// whether a proxy can be asked to go to host, which it will resolve itself,
+// so an unlisted name has to resolve to allowed addresses here too
+func (p *egressPolicy) allowsDestination(host string) bool {
+  if p == nil || p.allowsHost(host) {
+    return true
+  }
+  if ip := net.ParseIP(host); ip != nil {
+    return p.allowsIP(ip)
+  }
+  ips, err := net.LookupIP(host)
+  if err != nil || len(ips) == 0 {
+    return false
+  }
+  for _, ip := range ips {
+    if !p.allowsIP(ip) {
+      return false
+    }
+  }
+  return true
+}
+
+func egressDenied(addr string) error {
+  return fmt.Errorf("egress to %s isn't allowed by egress.allow", addr)
+}
+
+// dials with dialer. a listed name goes through as is, others only connect
+// to addresses in the listed cidrs
+func egressDialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+  return func(ctx context.Context, network, addr string) (net.Conn, error) {
+    host, _, err := net.SplitHostPort(addr)
+    if err != nil {
+      return nil, err
+    }
+    if egress == nil || egress.allowsHost(host) {
+      return dialer.DialContext(ctx, network, addr)
+    }
+    checked := *dialer
+    checked.Control = func(network, address string, c syscall.RawConn) error {
+      ip, _, _ := net.SplitHostPort(address)
+      if !egress.allowsIP(net.ParseIP(ip)) {
+        return egressDenied(addr)
+      }
+      return nil
+    }
+    return checked.DialContext(ctx, network, addr)
+  }
+}
+
+// a transport's proxy func that refuses requests the proxy would take
+// somewhere that isn't allowed
+func egressProxy(next func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+  if next == nil {
+    return nil
+  }
+  return func(req *http.Request) (*url.URL, error) {
+    proxy, err := next(req)
+    if err != nil || proxy == nil {
+      return proxy, err
+    }
+    if !egress.allowsDestination(req.URL.Hostname()) {
+      return nil, egressDenied(req.URL.Host)
+    }
+    return proxy, nil
+  }
+}
+
+// a tcp connection for what isn't http, checked against the allowlist
+func dialEgress(addr string, timeout time.Duration) (net.Conn, error) {
+  return egressDialContext(&net.Dialer{Timeout: timeout})(context.Background(), "tcp", addr)
+}
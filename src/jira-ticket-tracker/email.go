@@ -0,0 +1,269 @@
+package main
+
+import (
+  "fmt"
+  "net/http"
+  "net/smtp"
+  "net/url"
+  "strings"
+  "time"
+)
+
+// an email target sends each event as a message through an smtp server.
+// with an .html template the message is sent as html. with `batch` the
+// events of a poll go in one digest, and with personalize each recipient
+// only gets the issues assigned to or reported by them, so one rule can
+// send everyone their own view, respecting each one's do not disturb.
+// issues none of the recipients are on go to nobody
+//
+//   targets:
+//     ops-email:
+//       type: email
+//       email:
+//         server: smtp.example.com:587
+//         username: tracker   # optional, plain auth
+//         password: ...
+//         from: tracker@example.com
+//         to: [ops@example.com, "group:ops-team"]  # see recipients.go
+//         personalize: true   # optional
+//         plaintext: false    # send without starttls, e.g. to a relay on localhost
+//       batch: 5              # a digest for 5 or more issues
+type EmailConfig struct {
+  Server      string   `yaml:"server"`
+  Username    string   `yaml:"username"`
+  Password    string   `yaml:"password"`
+  From        string   `yaml:"from"`
+  To          []string `yaml:"to"`
+  Personalize bool     `yaml:"personalize"`
+  Plaintext   bool     `yaml:"plaintext"`
+}
+
+type emailNotifier struct {
+  config    EmailConfig
+  html      bool
+  pixel     string // where open pixels are served, for read receipts
+  creds     *Config
+  transport *targetTransport
+}
+
+func (n *emailNotifier) Notify(event *Event, message string) error {
+  subject := fmt.Sprintf("[%s] %s (%s)", event.Issue.Key, event.Issue.Fields.Summary, event.Kind)
+  recipients := expandRecipients(n.config.To, issueProject(event), n.creds)
+  if len(recipients) == 0 {
+    return fmt.Errorf("no recipients")
+  }
+  to := []string{}
+  for _, r := range recipients {
+    if !n.config.Personalize || (r.involved(event) && len(dndReason(r.user, event, n.creds)) == 0) {
+      to = addTargets(to, []string{r.address})
+    }
+  }
+  if len(to) == 0 {
+    return nil // it's on nobody's list
+  }
+  return n.send(to, subject, message)
+}
+
+// one digest of the events, or with personalize one per recipient of the
+// events they're on
+func (n *emailNotifier) NotifyBatch(events []*Event, messages []string) error {
+  separator := "\n\n---\n\n"
+  if n.html {
+    separator = "<hr>"
+  }
+  byAddress := map[string][]int{}
+  addresses := []string{}
+  for i, event := range events {
+    seen := map[string]bool{}
+    for _, r := range expandRecipients(n.config.To, issueProject(event), n.creds) {
+      if seen[r.address] || (n.config.Personalize && (!r.involved(event) || len(dndReason(r.user, event, n.creds)) > 0)) {
+        continue
+      }
+      seen[r.address] = true
+      if _, ok := byAddress[r.address]; !ok {
+        addresses = append(addresses, r.address)
+      }
+      byAddress[r.address] = append(byAddress[r.address], i)
+    }
+  }
+  if !n.config.Personalize {
+    if len(addresses) == 0 {
+      return fmt.Errorf("no recipients")
+    }
+    return n.send(addresses, fmt.Sprintf("%d issues", len(events)), strings.Join(messages, separator))
+  }
+  // only the events of the digests that failed are failed, the others'
+  // recipients have them
+  failed, undelivered := 0, map[int]bool{}
+  var last error
+  for _, address := range addresses {
+    parts := []string{}
+    for _, i := range byAddress[address] {
+      parts = append(parts, messages[i])
+    }
+    if err := n.send([]string{address}, fmt.Sprintf("%d of your issues", len(parts)), strings.Join(parts, separator)); err != nil {
+      logger.Print("Error sending the digest to ", address, ": ", err)
+      failed, last = failed+1, err
+      for _, i := range byAddress[address] {
+        undelivered[i] = true
+      }
+    }
+  }
+  if failed == 0 {
+    return nil
+  }
+  err := fmt.Errorf("%d of %d digests failed, the last with: %v", failed, len(addresses), last)
+  if failed == len(addresses) {
+    return err
+  }
+  partial := &partialBatchError{err: err}
+  for i := range events {
+    if undelivered[i] {
+      partial.failed = append(partial.failed, i)
+    }
+  }
+  return partial
+}
+
+// whether the event's issue is assigned to or reported by the recipient
+func (r emailRecipient) involved(event *Event) bool {
+  for _, field := range []string{"assignee", "reporter"} {
+    if email := fieldString(event.Fields, field+".emailAddress"); len(email) > 0 && strings.EqualFold(email, r.address) {
+      return true
+    }
+    if len(r.user) == 0 {
+      continue
+    }
+    for _, id := range []string{"name", "key", "accountId"} {
+      if fieldString(event.Fields, field+"."+id) == r.user {
+        return true
+      }
+    }
+  }
+  return false
+}
+
+func (n *emailNotifier) send(to []string, subject, message string) error {
+  contentType := "text/plain"
+  if n.html {
+    contentType = "text/html"
+  }
+  headers := []string{
+    "From: " + n.config.From,
+    "To: " + strings.Join(to, ", "),
+    "Subject: " + strings.Replace(subject, "\n", " ", -1),
+    "Date: " + time.Now().Format(time.RFC1123Z),
+    "MIME-Version: 1.0",
+    "Content-Type: " + contentType + "; charset=utf-8",
+  }
+  body := strings.Join(headers, "\r\n") + "\r\n\r\n" + message
+
+  var auth smtp.Auth
+  if len(n.config.Username) > 0 {
+    host := strings.Split(n.config.Server, ":")[0]
+    auth = smtp.PlainAuth("", n.config.Username, n.config.Password, host)
+  }
+  return n.sendMail(auth, to, []byte(body))
+}
+
+// smtp.SendMail, connecting with the target's tls and proxy, and checked
+// against the egress allowlist. a server that doesn't offer starttls is
+// refused unless the target allows plaintext, so the messages and the
+// password aren't sent in the clear
+func (n *emailNotifier) sendMail(auth smtp.Auth, to []string, body []byte) error {
+  conn, err := n.transport.dial(n.config.Server)
+  if err != nil {
+    return err
+  }
+  host := strings.Split(n.config.Server, ":")[0]
+  c, err := smtp.NewClient(conn, host)
+  if err != nil {
+    conn.Close()
+    return err
+  }
+  defer c.Close()
+  if ok, _ := c.Extension("STARTTLS"); ok {
+    if err := c.StartTLS(n.transport.tlsConfig(host)); err != nil {
+      return err
+    }
+  } else if !n.config.Plaintext {
+    return fmt.Errorf("%s doesn't offer starttls, set plaintext to send to it anyway", n.config.Server)
+  }
+  if auth != nil {
+    if ok, _ := c.Extension("AUTH"); ok {
+      if err := c.Auth(auth); err != nil {
+        return err
+      }
+    }
+  }
+  if err := c.Mail(n.config.From); err != nil {
+    return err
+  }
+  for _, address := range to {
+    if err := c.Rcpt(address); err != nil {
+      return err
+    }
+  }
+  w, err := c.Data()
+  if err != nil {
+    return err
+  }
+  if _, err := w.Write(body); err != nil {
+    return err
+  }
+  if err := w.Close(); err != nil {
+    return err
+  }
+  return c.Quit()
+}
+
+// an sms target texts each message through twilio
+//
+//   targets:
+//     ops-sms:
+//       type: sms
+//       sms:
+//         account: AC...
+//         token: ...
+//         from: "+15550000000"
+//         to: ["+15551234567"]
+type SMSConfig struct {
+  Account string   `yaml:"account"`
+  Token   string   `yaml:"token"`
+  From    string   `yaml:"from"`
+  To      []string `yaml:"to"`
+}
+
+// texts are cut to what fits in a few segments, in characters
+const maxSMSLength = 480
+
+type smsNotifier struct {
+  config    SMSConfig
+  transport *targetTransport
+}
+
+func (n *smsNotifier) Notify(event *Event, message string) error {
+  // cut between characters, a byte cut can split one into invalid utf-8
+  if runes := []rune(message); len(runes) > maxSMSLength {
+    message = string(runes[:maxSMSLength-3]) + "..."
+  }
+  uri := "https://api.twilio.com/2010-04-01/Accounts/" + n.config.Account + "/Messages.json"
+  for _, to := range n.config.To {
+    form := url.Values{"From": {n.config.From}, "To": {to}, "Body": {message}}
+    req, err := http.NewRequest("POST", uri, strings.NewReader(form.Encode()))
+    if err != nil {
+      return err
+    }
+    req.SetBasicAuth(n.config.Account, n.config.Token)
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    resp, err := n.transport.httpClient().Do(req)
+    if err != nil {
+      return err
+    }
+    drainAndClose(resp.Body)
+    if resp.StatusCode >= 300 {
+      return fmt.Errorf("texting %s returned %s", to, resp.Status)
+    }
+  }
+  return nil
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+  "bytes"
+  "encoding/binary"
+  "encoding/json"
+  "fmt"
+  "math"
+  "net/http"
+  "reflect"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+)
+
+// binary encodings of the event payloads for nats and kafka, avro or
+// protobuf. like the json schemas, the avro schema and the .proto are
+// generated from the go types. with a schema registry the schema is
+// registered under the sink's subject, TOPIC-value, and each payload is
+// framed the confluent way: a zero byte, the schema id, then the encoded
+// payload, with protobuf's message indexes before it
+//
+//   schema_registry:
+//     url: https://registry.acme.com
+//     username: tracker   # optional, basic auth
+//     password: ...
+type SchemaRegistryConfig struct {
+  Url      string `yaml:"url"`
+  Username string `yaml:"username"`
+  Password string `yaml:"password"`
+}
+
+const (
+  encodingJSON     = "json"
+  encodingAvro     = "avro"
+  encodingProtobuf = "protobuf"
+)
+
+var schemaRegistry SchemaRegistryConfig
+
+// the registered schema ids, by subject and schema
+var registeredSchemas = struct {
+  sync.Mutex
+  ids map[string]int
+}{ids: map[string]int{}}
+
+// a payload encoded with encoding, registering its schema under subject
+func encodePayload(encoding, subject string, payload interface{}) ([]byte, error) {
+  v := reflect.ValueOf(payload)
+  var schema, schemaType string
+  var body bytes.Buffer
+  switch encoding {
+  case encodingJSON, "":
+    return json.Marshal(payload)
+  case encodingAvro:
+    schema, schemaType = avroSchema(v.Type()), "AVRO"
+    avroEncode(&body, v)
+  case encodingProtobuf:
+    schema, schemaType = protoSchema(v.Type()), "PROTOBUF"
+    protoEncode(&body, v)
+  default:
+    return nil, fmt.Errorf("unknown encoding %s", encoding)
+  }
+  if len(schemaRegistry.Url) == 0 {
+    return body.Bytes(), nil
+  }
+  id, err := registerSchema(subject, schema, schemaType)
+  if err != nil {
+    return nil, err
+  }
+  framed := []byte{0, 0, 0, 0, 0}
+  binary.BigEndian.PutUint32(framed[1:], uint32(id))
+  if encoding == encodingProtobuf {
+    framed = append(framed, 0) // the first message in the .proto
+  }
+  return append(framed, body.Bytes()...), nil
+}
+
+func registerSchema(subject, schema, schemaType string) (int, error) {
+  registeredSchemas.Lock()
+  defer registeredSchemas.Unlock()
+  if id, ok := registeredSchemas.ids[subject+"\x00"+schema]; ok {
+    return id, nil
+  }
+  request := map[string]string{"schema": schema}
+  if schemaType != "AVRO" {
+    request["schemaType"] = schemaType
+  }
+  contents, _ := json.Marshal(request)
+  url := strings.TrimRight(schemaRegistry.Url, "/") + "/subjects/" + subject + "/versions"
+  req, err := http.NewRequest("POST", url, bytes.NewReader(contents))
+  if err != nil {
+    return 0, err
+  }
+  req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+  if len(schemaRegistry.Username) > 0 {
+    req.SetBasicAuth(schemaRegistry.Username, schemaRegistry.Password)
+  }
+  resp, err := http.DefaultClient.Do(req)
+  if err != nil {
+    return 0, err
+  }
+  defer resp.Body.Close()
+  var registered struct {
+    Id      int    `json:"id"`
+    Message string `json:"message"`
+  }
+  json.NewDecoder(resp.Body).Decode(&registered)
+  if resp.StatusCode >= 300 {
+    return 0, fmt.Errorf("the schema registry returned %s registering %s: %s", resp.Status, subject, registered.Message)
+  }
+  registeredSchemas.ids[subject+"\x00"+schema] = registered.Id
+  return registered.Id, nil
+}
+
+// the exported fields of a struct with their json names
+func encodedFields(t reflect.Type) ([]reflect.StructField, []string) {
+  fields, names := []reflect.StructField{}, []string{}
+  for i := 0; i < t.NumField(); i++ {
+    field := t.Field(i)
+    if len(field.PkgPath) > 0 {
+      continue
+    }
+    name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+    if name == "-" {
+      continue
+    }
+    if len(name) == 0 {
+      name = field.Name
+    }
+    fields, names = append(fields, field), append(names, name)
+  }
+  return fields, names
+}
+
+// map keys are sorted so the same payload always encodes the same
+func sortedMapKeys(v reflect.Value) []reflect.Value {
+  keys := v.MapKeys()
+  sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+  return keys
+}
+
+// the avro record or protobuf message a struct is, anonymous ones numbered
+func encodedName(t reflect.Type, defined map[string]bool) string {
+  if len(t.Name()) == 0 {
+    return fmt.Sprintf("Anonymous%d", len(defined))
+  }
+  return schemaName(t)
+}
+
+const avroNamespace = "com.github.sk8erwitskil.jiratickettracker"
+
+// pointers are unions with null, times are timestamp-millis and nil maps
+// and slices are empty
+func avroSchema(t reflect.Type) string {
+  contents, _ := json.Marshal(avroType(t, map[string]bool{}))
+  return string(contents)
+}
+
+func avroType(t reflect.Type, defined map[string]bool) interface{} {
+  if t == timeType {
+    return map[string]string{"type": "long", "logicalType": "timestamp-millis"}
+  }
+  switch t.Kind() {
+  case reflect.Ptr:
+    return []interface{}{"null", avroType(t.Elem(), defined)}
+  case reflect.Bool:
+    return "boolean"
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+    reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    return "long"
+  case reflect.Float32, reflect.Float64:
+    return "double"
+  case reflect.Slice, reflect.Array:
+    return map[string]interface{}{"type": "array", "items": avroType(t.Elem(), defined)}
+  case reflect.Map:
+    return map[string]interface{}{"type": "map", "values": avroType(t.Elem(), defined)}
+  case reflect.Struct:
+    name := encodedName(t, defined)
+    if defined[name] {
+      return avroNamespace + "." + name
+    }
+    defined[name] = true
+    fields, names := encodedFields(t)
+    avroFields := []interface{}{}
+    for i, field := range fields {
+      avroField := map[string]interface{}{"name": names[i], "type": avroType(field.Type, defined)}
+      if field.Type.Kind() == reflect.Ptr {
+        avroField["default"] = nil
+      }
+      avroFields = append(avroFields, avroField)
+    }
+    return map[string]interface{}{"type": "record", "name": name, "namespace": avroNamespace, "fields": avroFields}
+  }
+  return "string"
+}
+
+func avroLong(w *bytes.Buffer, n int64) {
+  var buf [binary.MaxVarintLen64]byte
+  w.Write(buf[:binary.PutVarint(buf[:], n)]) // zigzag, as avro's longs are
+}
+
+func avroEncode(w *bytes.Buffer, v reflect.Value) {
+  if v.Type() == timeType {
+    avroLong(w, v.Interface().(time.Time).UnixNano()/int64(time.Millisecond))
+    return
+  }
+  switch v.Kind() {
+  case reflect.Ptr:
+    if v.IsNil() {
+      avroLong(w, 0)
+      return
+    }
+    avroLong(w, 1)
+    avroEncode(w, v.Elem())
+  case reflect.Bool:
+    if v.Bool() {
+      w.WriteByte(1)
+    } else {
+      w.WriteByte(0)
+    }
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    avroLong(w, v.Int())
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    avroLong(w, int64(v.Uint()))
+  case reflect.Float32, reflect.Float64:
+    binary.Write(w, binary.LittleEndian, v.Float())
+  case reflect.String:
+    avroLong(w, int64(v.Len()))
+    w.WriteString(v.String())
+  case reflect.Slice, reflect.Array:
+    if v.Len() > 0 {
+      avroLong(w, int64(v.Len()))
+      for i := 0; i < v.Len(); i++ {
+        avroEncode(w, v.Index(i))
+      }
+    }
+    avroLong(w, 0)
+  case reflect.Map:
+    if v.Len() > 0 {
+      avroLong(w, int64(v.Len()))
+      for _, key := range sortedMapKeys(v) {
+        avroEncode(w, key)
+        avroEncode(w, v.MapIndex(key))
+      }
+    }
+    avroLong(w, 0)
+  case reflect.Struct:
+    fields, _ := encodedFields(v.Type())
+    for _, field := range fields {
+      avroEncode(w, v.FieldByIndex(field.Index))
+    }
+  default:
+    avroEncode(w, reflect.ValueOf(fmt.Sprint(v.Interface())))
+  }
+}
+
+// the .proto for a struct, proto3 with the fields numbered in order. times
+// are int64 unix milliseconds, so nothing is imported
+func protoSchema(t reflect.Type) string {
+  var w strings.Builder
+  w.WriteString("syntax = \"proto3\";\npackage " + avroNamespace + ";\n")
+  messages, names, defined := []reflect.Type{t}, []string{encodedName(t, nil)}, map[string]bool{}
+  defined[names[0]] = true
+  for i := 0; i < len(messages); i++ {
+    fmt.Fprintf(&w, "\nmessage %s {\n", names[i])
+    fields, fieldNames := encodedFields(messages[i])
+    for j, field := range fields {
+      fmt.Fprintf(&w, "  %s %s = %d;\n", protoType(field.Type, &messages, &names, defined), fieldNames[j], j+1)
+    }
+    w.WriteString("}\n")
+  }
+  return w.String()
+}
+
+func protoType(t reflect.Type, messages *[]reflect.Type, names *[]string, defined map[string]bool) string {
+  if t == timeType {
+    return "int64"
+  }
+  switch t.Kind() {
+  case reflect.Ptr:
+    return protoType(t.Elem(), messages, names, defined)
+  case reflect.Bool:
+    return "bool"
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    return "int64"
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    return "uint64"
+  case reflect.Float32, reflect.Float64:
+    return "double"
+  case reflect.Slice, reflect.Array:
+    return "repeated " + protoType(t.Elem(), messages, names, defined)
+  case reflect.Map:
+    return "map<string, " + protoType(t.Elem(), messages, names, defined) + ">"
+  case reflect.Struct:
+    for i, message := range *messages {
+      if message == t {
+        return (*names)[i]
+      }
+    }
+    name := encodedName(t, defined)
+    defined[name] = true
+    *messages, *names = append(*messages, t), append(*names, name)
+    return name
+  }
+  return "string"
+}
+
+func protoVarint(w *bytes.Buffer, n uint64) {
+  var buf [binary.MaxVarintLen64]byte
+  w.Write(buf[:binary.PutUvarint(buf[:], n)])
+}
+
+func protoEncode(w *bytes.Buffer, v reflect.Value) {
+  fields, _ := encodedFields(v.Type())
+  for i, field := range fields {
+    protoField(w, uint64(i+1), v.FieldByIndex(field.Index), true)
+  }
+}
+
+// a field, or nothing for a zero value as proto3 does. repeated fields and
+// map entries are each written as a field of their own
+func protoField(w *bytes.Buffer, number uint64, v reflect.Value, skipZero bool) {
+  if v.Type() == timeType {
+    if t := v.Interface().(time.Time); !t.IsZero() || !skipZero {
+      protoVarint(w, number<<3)
+      protoVarint(w, uint64(t.UnixNano()/int64(time.Millisecond)))
+    }
+    return
+  }
+  switch v.Kind() {
+  case reflect.Ptr:
+    if !v.IsNil() {
+      protoField(w, number, v.Elem(), false)
+    }
+  case reflect.Bool:
+    if v.Bool() || !skipZero {
+      protoVarint(w, number<<3)
+      if v.Bool() {
+        protoVarint(w, 1)
+      } else {
+        protoVarint(w, 0)
+      }
+    }
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    if v.Int() != 0 || !skipZero {
+      protoVarint(w, number<<3)
+      protoVarint(w, uint64(v.Int()))
+    }
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    if v.Uint() != 0 || !skipZero {
+      protoVarint(w, number<<3)
+      protoVarint(w, v.Uint())
+    }
+  case reflect.Float32, reflect.Float64:
+    if v.Float() != 0 || !skipZero {
+      protoVarint(w, number<<3|1)
+      binary.Write(w, binary.LittleEndian, math.Float64bits(v.Float()))
+    }
+  case reflect.String:
+    if v.Len() > 0 || !skipZero {
+      protoVarint(w, number<<3|2)
+      protoVarint(w, uint64(v.Len()))
+      w.WriteString(v.String())
+    }
+  case reflect.Slice, reflect.Array:
+    for i := 0; i < v.Len(); i++ {
+      protoField(w, number, v.Index(i), false)
+    }
+  case reflect.Map:
+    for _, key := range sortedMapKeys(v) {
+      var entry bytes.Buffer
+      protoField(&entry, 1, key, false)
+      protoField(&entry, 2, v.MapIndex(key), false)
+      protoBytes(w, number, entry.Bytes())
+    }
+  case reflect.Struct:
+    var message bytes.Buffer
+    protoEncode(&message, v)
+    protoBytes(w, number, message.Bytes())
+  default:
+    protoField(w, number, reflect.ValueOf(fmt.Sprint(v.Interface())), skipZero)
+  }
+}
+
+func protoBytes(w *bytes.Buffer, number uint64, contents []byte) {
+  protoVarint(w, number<<3|2)
+  protoVarint(w, uint64(len(contents)))
+  w.Write(contents)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+  "bytes"
+  "crypto/aes"
+  "crypto/cipher"
+  "crypto/rand"
+  "encoding/base64"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "os/exec"
+  "strings"
+)
+
+// encrypt the state and history files at rest, configured under
+// `encryption`. the key is 32 random bytes, base64 encoded, given by one of:
+//
+//   encryption:
+//     key: ...            # inline, least safe
+//     key_env: TRACKER_KEY
+//     key_file: /run/secrets/tracker-key
+//     key_command: aws kms decrypt --ciphertext-blob fileb://key.enc --query Plaintext --output text
+//
+// key_command is how a kms (or vault, or a password manager) is used: its
+// output is the key. files written before encryption was turned on are
+// still read and are encrypted the next time they are written
+type EncryptionConfig struct {
+  Key        string `yaml:"key"`
+  KeyEnv     string `yaml:"key_env"`
+  KeyFile    string `yaml:"key_file"`
+  KeyCommand string `yaml:"key_command"`
+}
+
+// what sealed data starts with, so plain files can still be told apart
+var (
+  sealedMagic = []byte("JTTENC1\n")
+  sealedLine  = []byte("enc1:")
+)
+
+// the cipher for the state and history, nil when they are kept in the clear
+var stateCipher cipher.AEAD
+
+func (c *EncryptionConfig) key() (string, error) {
+  switch {
+  case len(c.Key) > 0:
+    return c.Key, nil
+  case len(c.KeyEnv) > 0:
+    key := os.Getenv(c.KeyEnv)
+    if len(key) == 0 {
+      return "", fmt.Errorf("%s is not set", c.KeyEnv)
+    }
+    return key, nil
+  case len(c.KeyFile) > 0:
+    contents, err := ioutil.ReadFile(c.KeyFile)
+    return string(contents), err
+  case len(c.KeyCommand) > 0:
+    out, err := exec.Command("sh", "-c", c.KeyCommand).Output()
+    if err != nil {
+      return "", fmt.Errorf("key_command failed: %v", err)
+    }
+    return string(out), nil
+  }
+  return "", nil
+}
+
+func loadEncryption(c EncryptionConfig) cipher.AEAD {
+  encoded, err := c.key()
+  if err != nil {
+    logger.Print("Error getting the encryption key: ", err)
+    os.Exit(1)
+  }
+  if len(encoded) == 0 {
+    return nil
+  }
+  key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+  if err != nil || len(key) != 32 {
+    logger.Print("The encryption key must be 32 bytes, base64 encoded")
+    os.Exit(1)
+  }
+  block, err := aes.NewCipher(key)
+  if err != nil {
+    logger.Print("Error creating the cipher: ", err)
+    os.Exit(1)
+  }
+  aead, err := cipher.NewGCM(block)
+  if err != nil {
+    logger.Print("Error creating the cipher: ", err)
+    os.Exit(1)
+  }
+  return aead
+}
+
+// set up the state cipher for commands working on the state files without
+// the rest of setup, if there is a config to read it from
+func loadStateCipher() {
+  if _, err := os.Stat(*config); err == nil {
+    stateCipher = loadEncryption(getCreds(*config).Encryption)
+  }
+}
+
+func seal(plain []byte) []byte {
+  nonce := make([]byte, stateCipher.NonceSize())
+  rand.Read(nonce)
+  return stateCipher.Seal(nonce, nonce, plain, nil)
+}
+
+func unseal(data []byte) ([]byte, error) {
+  size := stateCipher.NonceSize()
+  if len(data) < size {
+    return nil, fmt.Errorf("encrypted data is truncated")
+  }
+  plain, err := stateCipher.Open(nil, data[:size], data[size:], nil)
+  if err != nil {
+    return nil, fmt.Errorf("can't decrypt, is it the right key? %v", err)
+  }
+  return plain, nil
+}
+
+// sealFile encrypts a whole file's contents, if encryption is on
+func sealFile(plain []byte) []byte {
+  if stateCipher == nil {
+    return plain
+  }
+  return append(append([]byte{}, sealedMagic...), seal(plain)...)
+}
+
+func unsealFile(data []byte) ([]byte, error) {
+  if !bytes.HasPrefix(data, sealedMagic) {
+    return data, nil
+  }
+  if stateCipher == nil {
+    return nil, fmt.Errorf("the file is encrypted but no key is configured")
+  }
+  return unseal(data[len(sealedMagic):])
+}
+
+// sealLine encrypts one line of a line based file like the history
+func sealLine(plain []byte) []byte {
+  if stateCipher == nil {
+    return plain
+  }
+  sealed := seal(plain)
+  line := make([]byte, len(sealedLine)+base64.StdEncoding.EncodedLen(len(sealed)))
+  copy(line, sealedLine)
+  base64.StdEncoding.Encode(line[len(sealedLine):], sealed)
+  return line
+}
+
+// a history record for a database, its issue and detail encrypted if
+// encryption is on. the rest is left to query by. the issue stays json, as
+// a string holding the sealed line
+func sealRecord(r HistoryRecord) HistoryRecord {
+  if stateCipher == nil {
+    return r
+  }
+  issue, _ := json.Marshal(string(sealLine(r.Issue)))
+  r.Issue, r.Detail = issue, string(sealLine([]byte(r.Detail)))
+  return r
+}
+
+func unsealRecord(r *HistoryRecord) error {
+  var sealed string
+  if err := json.Unmarshal(r.Issue, &sealed); err == nil && strings.HasPrefix(sealed, string(sealedLine)) {
+    issue, err := unsealLine([]byte(sealed))
+    if err != nil {
+      return err
+    }
+    r.Issue = issue
+  }
+  detail, err := unsealLine([]byte(r.Detail))
+  if err != nil {
+    return err
+  }
+  r.Detail = string(detail)
+  return nil
+}
+
+func unsealLine(line []byte) ([]byte, error) {
+  if !bytes.HasPrefix(line, sealedLine) {
+    return line, nil
+  }
+  if stateCipher == nil {
+    return nil, fmt.Errorf("the line is encrypted but no key is configured")
+  }
+  sealed, err := base64.StdEncoding.DecodeString(string(line[len(sealedLine):]))
+  if err != nil {
+    return nil, err
+  }
+  return unseal(sealed)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+  "crypto/hmac"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// alerts from sentry and rollbar, configured under `error_tracking`. their
+// webhooks are posted to /sentry and /rollbar on the control api, matched
+// to issues by the fingerprint field or a remote link to the error, and the
+// error's frequency is attached to the issue's events as the computed
+// fields errors, errors_users, errors_last_seen and errors_url
+//
+//   error_tracking:
+//     field: Sentry Issue   # holds the sentry issue or rollbar item id
+//     sentry_secret: ...    # the integration's client secret
+//     rollbar_token: ...    # the rollbar webhook url has ?token=..
+type ErrorTrackingConfig struct {
+  Field        string `yaml:"field"`
+  SentrySecret string `yaml:"sentry_secret"`
+  RollbarToken string `yaml:"rollbar_token"`
+}
+
+// ErrorStats is the latest an error tracker told us about an issue's error
+type ErrorStats struct {
+  Source   string    `json:"source"` // sentry or rollbar
+  Id       string    `json:"id"`
+  Title    string    `json:"title,omitempty"`
+  Url      string    `json:"url,omitempty"`
+  Count    int       `json:"count"`
+  Users    int       `json:"users,omitempty"`
+  LastSeen time.Time `json:"last_seen"`
+}
+
+var errorTracking = &errorTracker{}
+
+type errorTracker struct {
+  config ErrorTrackingConfig
+  creds  *Config
+}
+
+func loadErrorTracking(creds *Config) {
+  errorTracking = &errorTracker{config: creds.ErrorTracking, creds: creds}
+}
+
+// the computed fields for an issue's errors, if it has any
+func errorFields(key string) map[string]string {
+  if state == nil {
+    return nil
+  }
+  stats := state.ErrorStats(key)
+  if stats == nil {
+    return nil
+  }
+  fields := map[string]string{
+    "errors":           strconv.Itoa(stats.Count),
+    "errors_last_seen": stats.LastSeen.Format(time.RFC3339),
+    "errors_url":       stats.Url,
+  }
+  if stats.Users > 0 {
+    fields["errors_users"] = strconv.Itoa(stats.Users)
+  }
+  return fields
+}
+
+// the issues the tracker knows about (following by a rule or subscribed
+// to) that are about the error, found with one search: by the fingerprint
+// field, or by a remote link whose global id is the error's url, which is
+// what jql can match remote links on
+func (t *errorTracker) correlate(stats *ErrorStats) []string {
+  clauses := []string{}
+  if len(t.config.Field) > 0 {
+    clauses = append(clauses, fmt.Sprintf("%s ~ %s", jqlQuote(t.config.Field), jqlQuote(stats.Id)))
+  }
+  if u := strings.TrimSuffix(stats.Url, "/"); len(u) > 0 {
+    clauses = append(clauses, fmt.Sprintf("issue in issuesWithRemoteLinksByGlobalId(%s, %s)", jqlQuote(u), jqlQuote(u+"/")))
+  }
+  keys := []string{}
+  if len(clauses) == 0 {
+    return keys
+  }
+  contents := jiraSearch(strings.Join(clauses, " OR "), 0, 50, t.creds)
+  if contents == nil {
+    return keys
+  }
+  var result struct {
+    Issues []struct {
+      Key string `json:"key"`
+    } `json:"issues"`
+  }
+  if err := json.Unmarshal(contents, &result); err != nil {
+    logger.Print("Error parsing the issues of ", stats.Source, " error ", stats.Id, ": ", err)
+    return keys
+  }
+  known := state.TrackedRules()
+  for _, key := range state.SubscribedKeys() {
+    known[key] = ""
+  }
+  for _, issue := range result.Issues {
+    if _, ok := known[issue.Key]; ok {
+      keys = append(keys, issue.Key)
+    }
+  }
+  return keys
+}
+
+func (t *errorTracker) record(stats *ErrorStats) []string {
+  keys := t.correlate(stats)
+  for _, key := range keys {
+    state.SetErrorStats(key, stats)
+  }
+  if len(keys) > 0 {
+    logger.Print("Correlated ", stats.Source, " error ", stats.Id, " with ", strings.Join(keys, ", "))
+  }
+  return keys
+}
+
+// sentry's integration webhooks, for issue and event alerts
+type sentryPayload struct {
+  Data struct {
+    Issue *struct {
+      Id        string `json:"id"`
+      Title     string `json:"title"`
+      Permalink string `json:"permalink"`
+      Count     string `json:"count"`
+      UserCount int    `json:"userCount"`
+      LastSeen  string `json:"lastSeen"`
+    } `json:"issue"`
+    Event *struct {
+      IssueId string `json:"issue_id"`
+      Title   string `json:"title"`
+      WebUrl  string `json:"web_url"`
+    } `json:"event"`
+  } `json:"data"`
+}
+
+func (p *sentryPayload) stats() (*ErrorStats, error) {
+  stats := &ErrorStats{Source: "sentry", LastSeen: time.Now()}
+  if issue := p.Data.Issue; issue != nil {
+    stats.Id, stats.Title, stats.Url, stats.Users = issue.Id, issue.Title, issue.Permalink, issue.UserCount
+    stats.Count, _ = strconv.Atoi(issue.Count)
+    if t, err := time.Parse(time.RFC3339, issue.LastSeen); err == nil {
+      stats.LastSeen = t
+    }
+  } else if event := p.Data.Event; event != nil {
+    stats.Id, stats.Title = event.IssueId, event.Title
+    // the web url is the event's, the issue is its parent
+    if i := strings.Index(event.WebUrl, "/events/"); i > 0 {
+      stats.Url = event.WebUrl[:i] + "/"
+    }
+  }
+  if len(stats.Id) == 0 {
+    return nil, fmt.Errorf("no issue in the payload")
+  }
+  return stats, nil
+}
+
+// rollbar's webhooks, new_item, occurrence, exp_repeat_item and the like
+type rollbarPayload struct {
+  Data struct {
+    Item struct {
+      Id                      json.Number `json:"id"`
+      Title                   string      `json:"title"`
+      TotalOccurrences        int         `json:"total_occurrences"`
+      LastOccurrenceTimestamp int64       `json:"last_occurrence_timestamp"`
+    } `json:"item"`
+    Url string `json:"url"`
+  } `json:"data"`
+}
+
+func (p *rollbarPayload) stats() (*ErrorStats, error) {
+  item := p.Data.Item
+  if len(item.Id) == 0 {
+    return nil, fmt.Errorf("no item in the payload")
+  }
+  stats := &ErrorStats{
+    Source: "rollbar", Id: item.Id.String(), Title: item.Title, Url: p.Data.Url,
+    Count: item.TotalOccurrences, LastSeen: time.Now(),
+  }
+  if item.LastOccurrenceTimestamp > 0 {
+    stats.LastSeen = time.Unix(item.LastOccurrenceTimestamp, 0)
+  }
+  return stats, nil
+}
+
+//   POST /sentry   a sentry integration webhook
+func handleSentry(w http.ResponseWriter, r *http.Request) {
+  if r.Method != "POST" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+  body, err := ioutil.ReadAll(r.Body)
+  if err != nil {
+    writeError(w, http.StatusBadRequest, err.Error())
+    return
+  }
+  if secret := errorTracking.config.SentrySecret; len(secret) > 0 {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write(body)
+    expected := hex.EncodeToString(mac.Sum(nil))
+    if !hmac.Equal([]byte(expected), []byte(r.Header.Get("Sentry-Hook-Signature"))) {
+      writeError(w, http.StatusUnauthorized, "invalid signature")
+      return
+    }
+  }
+  var payload sentryPayload
+  if err := json.Unmarshal(body, &payload); err != nil {
+    writeError(w, http.StatusBadRequest, "invalid json: "+err.Error())
+    return
+  }
+  stats, err := payload.stats()
+  if err != nil {
+    writeError(w, http.StatusBadRequest, err.Error())
+    return
+  }
+  writeJSON(w, http.StatusOK, map[string][]string{"keys": errorTracking.record(stats)})
+}
+
+//   POST /rollbar?token=..   a rollbar webhook
+func handleRollbar(w http.ResponseWriter, r *http.Request) {
+  if r.Method != "POST" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+  token := errorTracking.config.RollbarToken
+  if len(token) > 0 && !hmac.Equal([]byte(token), []byte(r.URL.Query().Get("token"))) {
+    writeError(w, http.StatusUnauthorized, "invalid token")
+    return
+  }
+  var payload rollbarPayload
+  if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+    writeError(w, http.StatusBadRequest, "invalid json: "+err.Error())
+    return
+  }
+  stats, err := payload.stats()
+  if err != nil {
+    writeError(w, http.StatusBadRequest, err.Error())
+    return
+  }
+  writeJSON(w, http.StatusOK, map[string][]string{"keys": errorTracking.record(stats)})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "os"
+  "text/tabwriter"
+  "time"
+)
+
+// how many issues a rule can be expected to match, checked before it starts
+// polling so a jql that matches half of jira doesn't flood every target.
+// configured under `estimate`:
+//
+//   estimate:
+//     sample: 7d          # how far back to count the rule's issues
+//     max_per_hour: 200
+//     max_per_poll: 500
+//     block: true         # don't poll rules over the limits, only warn by default
+type EstimateConfig struct {
+  Sample     string `yaml:"sample"`
+  MaxPerHour int    `yaml:"max_per_hour"`
+  MaxPerPoll int    `yaml:"max_per_poll"`
+  Block      bool   `yaml:"block"`
+}
+
+func init() {
+  commands["estimate"] = command{"estimate [RULE...]", estimateCommand}
+}
+
+// what a rule would have matched over the sample
+type ruleEstimate struct {
+  total   int
+  perHour float64
+  perPoll float64
+  pages   int // searched each poll
+}
+
+func estimateRule(rule *Rule, creds *Config) (ruleEstimate, error) {
+  sample := durationOr(creds.Estimate.Sample, 7*24*time.Hour)
+  now := time.Now()
+  total, err := countIssues(windowQuery(rule, now.Add(-sample), now), creds)
+  if err != nil {
+    return ruleEstimate{}, err
+  }
+  e := ruleEstimate{total: total, perHour: float64(total) / sample.Hours()}
+  e.perPoll = e.perHour * rule.nextPoll(now).Sub(now).Hours()
+  e.pages = int(e.perPoll)/rule.pageSize(creds) + 1
+  return e, nil
+}
+
+// the limits the estimate goes over, if any
+func (e ruleEstimate) over(creds *Config) string {
+  maxHour, maxPoll := creds.Estimate.MaxPerHour, creds.Estimate.MaxPerPoll
+  if maxHour == 0 {
+    maxHour = 200
+  }
+  if maxPoll == 0 {
+    maxPoll = 500
+  }
+  switch {
+  case e.perHour > float64(maxHour):
+    return fmt.Sprintf("about %.0f issues an hour (max %d)", e.perHour, maxHour)
+  case e.perPoll > float64(maxPoll):
+    return fmt.Sprintf("about %.0f issues a poll (max %d)", e.perPoll, maxPoll)
+  }
+  return ""
+}
+
+// check a rule's volume before it polls, alerting the operator if it looks
+// like too much. false means it shouldn't poll
+func estimateAllowed(rule *Rule, creds *Config) bool {
+  e, err := estimateRule(rule, creds)
+  if err != nil {
+    // let it poll, the searches will show whether the jql works
+    logger.Print("Error estimating the issues for rule ", rule.Name, ": ", err)
+    return true
+  }
+  over := e.over(creds)
+  if len(over) == 0 {
+    return true
+  }
+  if !creds.Estimate.Block {
+    alertOperator(creds, fmt.Sprintf("Rule %s would match %s, check its jql: %s", rule.Name, over, rule.query()))
+    return true
+  }
+  alertOperator(creds, fmt.Sprintf(
+    "Rule %s would match %s, it will not poll until its jql is fixed or estimate.block is turned off: %s",
+    rule.Name, over, rule.query()))
+  return false
+}
+
+// print the estimates for the rules, all of them by default
+func estimateCommand(args []string) {
+  flags := flag.NewFlagSet("estimate", flag.ExitOnError)
+  flags.Parse(args)
+
+  creds, rules := setup()
+  if flags.NArg() > 0 {
+    chosen := []*Rule{}
+    for _, name := range flags.Args() {
+      rule := findRule(rules, name)
+      if rule == nil {
+        logger.Print("No rule named ", name)
+        os.Exit(1)
+      }
+      chosen = append(chosen, rule)
+    }
+    rules = chosen
+  }
+
+  w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+  fmt.Fprintln(w, "RULE\tSAMPLED\tPER HOUR\tPER POLL\tPAGES\t")
+  failed := false
+  for _, rule := range rules {
+    e, err := estimateRule(rule, creds)
+    if err != nil {
+      fmt.Fprintf(w, "%s\terror: %v\t\t\t\t\n", rule.Name, err)
+      failed = true
+      continue
+    }
+    fmt.Fprintf(w, "%s\t%d\t%.1f\t%.1f\t%d\t", rule.Name, e.total, e.perHour, e.perPoll, e.pages)
+    if over := e.over(creds); len(over) > 0 {
+      fmt.Fprintf(w, "over the limits: %s", over)
+      failed = true
+    }
+    fmt.Fprintln(w)
+  }
+  w.Flush()
+  if failed {
+    os.Exit(1)
+  }
+}
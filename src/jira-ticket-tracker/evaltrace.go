@@ -0,0 +1,158 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "net/http"
+  "os"
+  "strings"
+  "sync"
+  "time"
+)
+
+func init() {
+  commands["why"] = command{"why [--rule=NAME] KEY", whyCommand}
+}
+
+// EvaluationTrace is how a rule's poll judged an issue its search returned
+type EvaluationTrace struct {
+  Time       time.Time         `json:"time"`
+  Rule       string            `json:"rule"`
+  Key        string            `json:"key"`
+  Matched    bool              `json:"matched"`
+  Conditions []conditionResult `json:"conditions"`
+  Targets    []string          `json:"targets,omitempty"`
+}
+
+const (
+  // traces kept for each issue, and issues kept, the oldest go first
+  tracesPerIssue = 20
+  tracedIssues   = 1000
+)
+
+var evaluationTraces = struct {
+  sync.Mutex
+  order  []string
+  traces map[string][]EvaluationTrace
+}{traces: map[string][]EvaluationTrace{}}
+
+func recordTrace(trace EvaluationTrace) {
+  evaluationTraces.Lock()
+  defer evaluationTraces.Unlock()
+  traces, ok := evaluationTraces.traces[trace.Key]
+  if !ok {
+    evaluationTraces.order = append(evaluationTraces.order, trace.Key)
+    if len(evaluationTraces.order) > tracedIssues {
+      delete(evaluationTraces.traces, evaluationTraces.order[0])
+      evaluationTraces.order = evaluationTraces.order[1:]
+    }
+  }
+  traces = append(traces, trace)
+  if len(traces) > tracesPerIssue {
+    traces = traces[len(traces)-tracesPerIssue:]
+  }
+  evaluationTraces.traces[trace.Key] = traces
+}
+
+func tracesFor(key string) []EvaluationTrace {
+  evaluationTraces.Lock()
+  defer evaluationTraces.Unlock()
+  return append([]EvaluationTrace{}, evaluationTraces.traces[key]...)
+}
+
+//   GET /traces/KEY   how the rules' polls judged the issue, oldest first
+func handleTraces(w http.ResponseWriter, r *http.Request) {
+  if r.Method != "GET" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+  key := strings.ToUpper(strings.TrimPrefix(r.URL.Path, "/traces/"))
+  if len(key) == 0 {
+    writeError(w, http.StatusBadRequest, "missing issue key")
+    return
+  }
+  writeJSON(w, http.StatusOK, tracesFor(key))
+}
+
+// explain why rules did or didn't fire for an issue: what the running
+// tracker's polls made of it, then whether each rule would match it now
+func whyCommand(args []string) {
+  flags := flag.NewFlagSet("why", flag.ExitOnError)
+  ruleName := flags.String("rule", "", "Only explain this rule")
+  flags.Parse(args)
+  if flags.NArg() != 1 {
+    usageExit(commands["why"].usage)
+  }
+  key := strings.ToUpper(flags.Arg(0))
+
+  var traces []EvaluationTrace
+  if err := callAPI("GET", "/traces/"+key, nil, &traces); err != nil {
+    fmt.Println("no traces from the running tracker:", err)
+  } else {
+    polled := false
+    for _, t := range traces {
+      if len(*ruleName) > 0 && t.Rule != *ruleName {
+        continue
+      }
+      polled = true
+      outcome := "did not match"
+      if t.Matched {
+        outcome = "matched, sent to " + strings.Join(t.Targets, ", ")
+      }
+      fmt.Printf("%s rule %s %s\n%s\n", t.Time.Format(time.RFC3339), t.Rule, outcome, formatConditions(t.Conditions))
+    }
+    if !polled {
+      fmt.Println("no poll has seen", key, "since the tracker started")
+    }
+  }
+
+  creds, rules := setup()
+  if len(*ruleName) > 0 {
+    rule := findRule(rules, *ruleName)
+    if rule == nil {
+      logger.Print("No rule named ", *ruleName)
+      os.Exit(1)
+    }
+    rules = []*Rule{rule}
+  }
+  contents := jiraIssue(key, creds)
+  if contents == nil {
+    logger.Print("Error fetching ", key)
+    os.Exit(1)
+  }
+  issue, fields, err := parseIssue(contents)
+  if err != nil {
+    logger.Print("Error parsing ", key, ": ", err)
+    os.Exit(1)
+  }
+
+  fmt.Println("now:")
+  for _, rule := range rules {
+    results := rule.evaluate(issue, fields, creds)
+    // the search is what decides the jql, so ask jira about this issue
+    if len(rule.jql()) > 0 {
+      total, err := countIssues("key = "+jqlQuote(key)+" AND ("+rule.jql()+")", creds)
+      result := conditionResult{Condition: "search: " + rule.jql(), Passed: total > 0, Checked: err == nil}
+      if err != nil {
+        result.Detail = err.Error()
+      } else {
+        // the search checked what couldn't be checked here
+        checked := []conditionResult{}
+        for _, r := range results {
+          if r.Checked {
+            checked = append(checked, r)
+          }
+        }
+        results = checked
+      }
+      results = append(results, result)
+    }
+    outcome := "would match"
+    if !conditionsPassed(results) {
+      outcome = "would not match"
+    } else if unchecked := uncheckedConditions(results); len(unchecked) > 0 {
+      outcome = "may match, jira couldn't check " + strings.Join(unchecked, ", ")
+    }
+    fmt.Printf("rule %s %s\n%s\n", rule.Name, outcome, formatConditions(results))
+  }
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+  "fmt"
+  "github.com/plouc/go-jira-client"
+  "strings"
+)
+
+// the outcome of one of a rule's conditions for an issue
+type conditionResult struct {
+  Condition string `json:"condition"`
+  Passed    bool   `json:"passed"`
+  Checked   bool   `json:"checked"` // false for what can only be checked by jira
+  Detail    string `json:"detail,omitempty"`
+}
+
+// evaluate a rule's conditions against an issue locally, without a search.
+// the rule's extra jql can't be evaluated here, it is reported as unchecked
+func (r *Rule) evaluate(issue *gojira.Issue, fields map[string]interface{}, creds *Config) []conditionResult {
+  results := []conditionResult{}
+  if len(r.Project) > 0 {
+    key := ""
+    if issue.Fields.Project != nil {
+      key = issue.Fields.Project.Key
+    }
+    results = append(results, conditionResult{
+      Condition: "project = " + r.Project,
+      Passed:    strings.EqualFold(key, r.Project),
+      Checked:   true,
+      Detail:    "project is " + key,
+    })
+  }
+  if len(r.User) > 0 {
+    name := fieldString(fields, r.Field+".name")
+    if len(name) == 0 {
+      name = fieldString(fields, r.Field+".accountId")
+    }
+    results = append(results, conditionResult{
+      Condition: r.Field + " = " + r.User,
+      Passed:    strings.EqualFold(name, r.User),
+      Checked:   true,
+      Detail:    r.Field + " is " + name,
+    })
+  }
+  if len(r.Users) > 0 {
+    name := fieldString(fields, r.Field+".name")
+    if len(name) == 0 {
+      name = fieldString(fields, r.Field+".accountId")
+    }
+    passed := false
+    for _, user := range r.users() {
+      passed = passed || strings.EqualFold(name, user)
+    }
+    results = append(results, conditionResult{
+      Condition: r.Field + " in " + strings.Join(r.Users, ", "),
+      Passed:    passed,
+      Checked:   true,
+      Detail:    r.Field + " is " + name,
+    })
+  }
+  if len(r.Jql) > 0 {
+    results = append(results, conditionResult{
+      Condition: "jql: " + r.Jql,
+      Detail:    "only jira can evaluate jql",
+    })
+  }
+  return append(results, r.linkResults(fields, creds)...)
+}
+
+// how the issue fares against each of the rule's link filters
+func (r *Rule) linkResults(fields map[string]interface{}, creds *Config) []conditionResult {
+  results := []conditionResult{}
+  for i := range r.Links {
+    f := &r.Links[i]
+    passed := linksMatch([]LinkFilter{*f}, fields, creds)
+    results = append(results, conditionResult{
+      Condition: "links: " + f.describe(),
+      Passed:    passed,
+      Checked:   true,
+    })
+  }
+  return results
+}
+
+// whether none of the conditions that were checked failed. the ones that
+// weren't, like the jql, are up to jira's search, see uncheckedConditions
+func conditionsPassed(results []conditionResult) bool {
+  for _, r := range results {
+    if r.Checked && !r.Passed {
+      return false
+    }
+  }
+  return true
+}
+
+// the conditions that weren't checked, so an issue that passed the rest is
+// only known to match once jira has checked them too
+func uncheckedConditions(results []conditionResult) []string {
+  unchecked := []string{}
+  for _, r := range results {
+    if !r.Checked {
+      unchecked = append(unchecked, r.Condition)
+    }
+  }
+  return unchecked
+}
+
+// the outcome for people: matched, did not match, or unknown when the
+// conditions only jira can check decide it
+func matchOutcome(results []conditionResult) string {
+  switch {
+  case !conditionsPassed(results):
+    return "did not match"
+  case len(uncheckedConditions(results)) > 0:
+    return "unknown, only jira can check " + strings.Join(uncheckedConditions(results), ", ")
+  }
+  return "matched"
+}
+
+func (f *LinkFilter) describe() string {
+  parts := []string{}
+  if len(f.Relation) > 0 {
+    parts = append(parts, f.Relation)
+  }
+  if len(f.Project) > 0 {
+    parts = append(parts, "project "+f.Project)
+  }
+  if len(f.IssueTypes) > 0 {
+    parts = append(parts, "type "+strings.Join(f.IssueTypes, "/"))
+  }
+  if len(f.Statuses) > 0 {
+    parts = append(parts, "status "+strings.Join(f.Statuses, "/"))
+  }
+  if f.Open {
+    parts = append(parts, "open")
+  }
+  if len(parts) == 0 {
+    return "any link"
+  }
+  return strings.Join(parts, ", ")
+}
+
+// build the event a rule would emit for an issue, with routing applied
+func ruleEvent(rule *Rule, kind string, issue *gojira.Issue, fields map[string]interface{}, creds *Config) *Event {
+  event := newEvent(kind, issue, fields)
+  event.Rule = rule.Name
+  event.Targets = addTargets(rule.targetsFor(kind), routeTargets(creds.Routing, event))
+  event.chain = rule.chain()
+  event.leaveTargetsToChain()
+  event.response = rule.response()
+  return event
+}
+
+func formatConditions(results []conditionResult) string {
+  lines := []string{}
+  for _, r := range results {
+    status := "ok"
+    if !r.Checked {
+      status = "not checked"
+    } else if !r.Passed {
+      status = "FAILED"
+    }
+    line := fmt.Sprintf("  %-40s %s", r.Condition, status)
+    if len(r.Detail) > 0 {
+      line += " (" + r.Detail + ")"
+    }
+    lines = append(lines, line)
+  }
+  return strings.Join(lines, "\n")
+}
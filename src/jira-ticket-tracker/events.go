@@ -0,0 +1,154 @@
+package main
+
+import (
+  "crypto/rand"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "github.com/plouc/go-jira-client"
+  "strings"
+  "time"
+)
+
+const (
+  eventCreated      = "created"
+  eventUpdated      = "updated"
+  eventTransitioned = "transitioned"
+  eventCommented    = "commented"
+  eventResolved     = "resolved"
+  eventReopened     = "reopened"
+)
+
+// an Event is what the producers hand to the consumer. the gojira issue
+// only knows about a handful of fields so the raw jira fields are kept
+// alongside it for everything else (status, comments, custom fields...)
+type Event struct {
+  Kind    string
+  Rule    string // the name of the rule that matched, if any
+  Issue   *gojira.Issue
+  Fields  map[string]interface{}
+  // the config-defined computed fields, see computed.go
+  Computed map[string]string
+  // the operators' notes and tags on the issue, nil if there are none
+  Annotation *Annotation
+  // issues on other jira instances it's related to, see correlate.go
+  Correlations []Correlation
+  Detail  string   // e.g. "Open -> In Progress" for transitions
+  Targets []string // the notifier targets the event should be sent to
+  // the locale of the target the message is being rendered for, if any
+  Locale string
+
+  // set on resolved events, from creation to the resolution date
+  TimeToResolution time.Duration
+  // when a rule's poll found the issue, for the latency budget
+  Fetched time.Time
+  // unique to the event and kept across retries and fallbacks, so
+  // receivers can drop the duplicates, e.g. the cloudevents id
+  Id string
+  // what the earlier steps of its rule's chain returned, see chain.go
+  Results map[string]interface{}
+  chain   *RuleChain
+  // the rule's canned response, for jira-comment targets
+  response *RuleResponse
+  // the jira webhook that brought it, empty for the tracker's own jira
+  Instance string
+}
+
+func newEvent(kind string, issue *gojira.Issue, fields map[string]interface{}) *Event {
+  fields = normalizePriority(issue, fields)
+  event := &Event{Kind: kind, Issue: issue, Fields: fields, Computed: computeFields(fields), Id: newEventId()}
+  if tier := customerTiers.tier(fields); len(tier) > 0 {
+    if event.Computed == nil {
+      event.Computed = map[string]string{}
+    }
+    event.Computed["tier"] = tier
+  }
+  if errors := errorFields(issue.Key); errors != nil {
+    if event.Computed == nil {
+      event.Computed = map[string]string{}
+    }
+    for name, value := range errors {
+      event.Computed[name] = value
+    }
+  }
+  if state != nil {
+    event.Annotation = state.Annotation(issue.Key)
+  }
+  event.Correlations = correlations.find(issue, fields)
+  return event
+}
+
+func newEventId() string {
+  id := make([]byte, 16)
+  rand.Read(id)
+  return hex.EncodeToString(id)
+}
+
+// a search result with the issues left undecoded so each one can be parsed
+// into both a gojira.Issue and its raw fields
+type rawIssueList struct {
+  Total  int               `json:"total"`
+  Issues []json.RawMessage `json:"issues"`
+}
+
+func parseIssue(data []byte) (*gojira.Issue, map[string]interface{}, error) {
+  var issue gojira.Issue
+  if err := json.Unmarshal(data, &issue); err != nil {
+    return nil, nil, err
+  }
+  if issue.Fields == nil {
+    return nil, nil, fmt.Errorf("issue %q has no fields", issue.Key)
+  }
+
+  var raw struct {
+    Fields map[string]interface{} `json:"fields"`
+  }
+  if err := json.Unmarshal(data, &raw); err != nil {
+    return nil, nil, err
+  }
+
+  return &issue, raw.Fields, nil
+}
+
+// fieldValue walks a dotted path like "status.name" through the raw fields
+func fieldValue(fields map[string]interface{}, path string) interface{} {
+  var value interface{} = fields
+  for _, part := range strings.Split(path, ".") {
+    m, ok := value.(map[string]interface{})
+    if !ok {
+      return nil
+    }
+    value = m[part]
+  }
+  return value
+}
+
+func fieldString(fields map[string]interface{}, path string) string {
+  switch v := fieldValue(fields, path).(type) {
+  case nil:
+    return ""
+  case string:
+    return v
+  default:
+    return fmt.Sprint(v)
+  }
+}
+
+func fieldNumber(fields map[string]interface{}, path string) float64 {
+  if v, ok := fieldValue(fields, path).(float64); ok {
+    return v
+  }
+  return 0
+}
+
+// the plain text form of an event used by the notifiers
+func eventMessage(event *Event) string {
+  issue := event.Issue
+  message := fmt.Sprintf("[%s] %s", issue.Key, issue.Fields.Summary)
+  if event.Kind == eventResolved && event.TimeToResolution > 0 {
+    message = fmt.Sprintf("%s (resolved %s after %s)", message, event.Detail, event.TimeToResolution)
+  } else if event.Kind != eventCreated {
+    message = fmt.Sprintf("%s (%s)", message, strings.TrimSpace(event.Kind+" "+event.Detail))
+  }
+  return message
+}
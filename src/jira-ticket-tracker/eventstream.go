@@ -0,0 +1,104 @@
+package main
+
+import (
+  "encoding/json"
+  "net/http"
+  "sync"
+  "time"
+)
+
+// StreamedEvent is an event as the control API streams it
+type StreamedEvent struct {
+  Time     time.Time         `json:"time"`
+  Kind     string            `json:"kind"`
+  Rule     string            `json:"rule,omitempty"`
+  Key      string            `json:"key"`
+  Summary  string            `json:"summary"`
+  Detail   string            `json:"detail,omitempty"`
+  Targets  []string          `json:"targets"`
+  Computed map[string]string `json:"computed,omitempty"`
+}
+
+// how many events a slow client can fall behind by before it's dropped
+const eventStreamBuffer = 100
+
+// the clients of GET /events
+var eventStream = struct {
+  sync.Mutex
+  clients map[chan StreamedEvent]bool
+}{clients: map[chan StreamedEvent]bool{}}
+
+// hand the events to every client streaming them
+func publishEvents(events []*Event) {
+  eventStream.Lock()
+  defer eventStream.Unlock()
+  if len(eventStream.clients) == 0 {
+    return
+  }
+  for _, event := range events {
+    streamed := StreamedEvent{
+      Time: time.Now(), Kind: event.Kind, Rule: event.Rule, Key: event.Issue.Key,
+      Detail: event.Detail, Targets: event.Targets, Computed: event.Computed,
+    }
+    if event.Issue.Fields != nil {
+      streamed.Summary = event.Issue.Fields.Summary
+    }
+    for client := range eventStream.clients {
+      select {
+      case client <- streamed:
+      default:
+        delete(eventStream.clients, client)
+        close(client)
+      }
+    }
+  }
+}
+
+//   GET /events[?rule=NAME&kind=KIND]   stream events as they're delivered, one
+//                                       json object per line
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+  if r.Method != "GET" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+  flusher, ok := w.(http.Flusher)
+  if !ok {
+    writeError(w, http.StatusInternalServerError, "streaming isn't supported")
+    return
+  }
+  rule, kind := r.URL.Query().Get("rule"), r.URL.Query().Get("kind")
+  client := make(chan StreamedEvent, eventStreamBuffer)
+  eventStream.Lock()
+  eventStream.clients[client] = true
+  eventStream.Unlock()
+  defer func() {
+    eventStream.Lock()
+    if eventStream.clients[client] {
+      delete(eventStream.clients, client)
+      close(client)
+    }
+    eventStream.Unlock()
+  }()
+
+  w.Header().Set("Content-Type", "application/x-ndjson")
+  w.WriteHeader(http.StatusOK)
+  flusher.Flush()
+  encoder := json.NewEncoder(w)
+  for {
+    select {
+    case <-r.Context().Done():
+      return
+    case event, ok := <-client:
+      if !ok {
+        return // fell too far behind
+      }
+      if (len(rule) > 0 && event.Rule != rule) || (len(kind) > 0 && event.Kind != kind) {
+        continue
+      }
+      if err := encoder.Encode(event); err != nil {
+        return
+      }
+      flusher.Flush()
+    }
+  }
+}
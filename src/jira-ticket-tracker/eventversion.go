@@ -0,0 +1,51 @@
+package main
+
+import "os"
+
+// the version of the event payloads posted to webhooks. it goes up when a
+// field is renamed or removed, or its meaning changes. a target can pin an
+// older version with `event_version`, so its consumer keeps getting what
+// it was written against until it's updated
+//
+//   targets:
+//     billing-hook:
+//       type: webhook
+//       url: https://billing.acme.com/jira-events
+//       event_version: 1
+//
+//   1  version, kind, key, summary, detail, message, computed and annotation
+//   2  adds time, rule and correlations
+const eventVersion = 2
+
+// WebhookPayloadV1 is WebhookPayload as it was in version 1, without the
+// time, rule and correlations. its version is always 1
+type WebhookPayloadV1 struct {
+  Version    int               `json:"version"`
+  Kind       string            `json:"kind"`
+  Key        string            `json:"key"`
+  Summary    string            `json:"summary"`
+  Detail     string            `json:"detail"`
+  Message    string            `json:"message"`
+  Computed   map[string]string `json:"computed"`
+  Annotation *Annotation       `json:"annotation,omitempty"`
+}
+
+// the payload for an event in a version
+func eventPayload(event *Event, message string, version int) interface{} {
+  payload := webhookPayload(event, message)
+  if version == 1 {
+    return WebhookPayloadV1{1, payload.Kind, payload.Key, payload.Summary, payload.Detail, payload.Message, payload.Computed, payload.Annotation}
+  }
+  return payload
+}
+
+func targetEventVersion(name string, target Target) int {
+  switch {
+  case target.EventVersion == 0:
+    return eventVersion
+  case target.EventVersion < 1 || target.EventVersion > eventVersion:
+    logger.Print("Target ", name, " has event_version ", target.EventVersion, ", it can be 1 to ", eventVersion)
+    os.Exit(1)
+  }
+  return target.EventVersion
+}
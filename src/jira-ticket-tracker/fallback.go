@@ -0,0 +1,107 @@
+package main
+
+import (
+  "fmt"
+  "sync"
+  "time"
+)
+
+const (
+  // after this many failures in a row a sink is skipped, going straight to
+  // its fallbacks, until the cooldown has passed and it gets another try
+  sinkFailureLimit = 3
+  sinkCooldown     = time.Minute
+  // the error rate is over the results this recent, up to sinkMaxResults
+  sinkHealthWindow = time.Hour
+  sinkMaxResults   = 10000
+)
+
+// the recent delivery record of a sink
+type sinkHealth struct {
+  mu           sync.Mutex
+  failures     int // in a row
+  failingSince time.Time
+  lastFailure  time.Time
+  lastSuccess  time.Time
+  lastError    string
+  results      []sinkResult // within sinkHealthWindow
+
+  // disabled after failing for sink_health.disable_after, see health.go
+  disabled   bool
+  disabledAt time.Time
+  lastProbe  time.Time
+  probes     int // successful in a row since it was disabled
+}
+
+type sinkResult struct {
+  at time.Time
+  ok bool
+}
+
+// whether to try the sink. a disabled one is let through once every
+// sink_health.probe, as a probe
+func (h *sinkHealth) available() bool {
+  h.mu.Lock()
+  defer h.mu.Unlock()
+  if h.disabled {
+    if time.Since(h.lastProbe) < sinkHealthConfig.probe {
+      return false
+    }
+    h.lastProbe = time.Now()
+    return true
+  }
+  return h.failures < sinkFailureLimit || time.Since(h.lastFailure) >= sinkCooldown
+}
+
+// record a delivery, returning an operator alert if it disabled or enabled
+// the sink
+func (h *sinkHealth) record(name string, err error) string {
+  h.mu.Lock()
+  defer h.mu.Unlock()
+
+  now := time.Now()
+  h.results = append(h.results, sinkResult{now, err == nil})
+  for len(h.results) > 0 && (len(h.results) > sinkMaxResults || now.Sub(h.results[0].at) > sinkHealthWindow) {
+    h.results = h.results[1:]
+  }
+  if err == nil {
+    if h.failures >= sinkFailureLimit && !h.disabled {
+      logger.Print(name, " is delivering again")
+    }
+    h.failures = 0
+    h.lastSuccess = now
+    if h.disabled {
+      if h.probes++; h.probes >= sinkHealthConfig.probes {
+        h.disabled = false
+        return fmt.Sprintf("Re-enabled target %s after %d successful deliveries, it was disabled for %s", name, h.probes, humanize(now.Sub(h.disabledAt)))
+      }
+    }
+    return ""
+  }
+  if h.failures == 0 {
+    h.failingSince = now
+  }
+  h.failures++
+  h.lastFailure = now
+  h.lastError = err.Error()
+  if h.disabled {
+    h.probes = 0
+    return ""
+  }
+  if h.failures == sinkFailureLimit {
+    logger.Print(name, " failed ", h.failures, " times in a row, skipping it for ", sinkCooldown)
+  }
+  if sinkHealthConfig.disableAfter > 0 && now.Sub(h.failingSince) >= sinkHealthConfig.disableAfter {
+    h.disabled, h.disabledAt, h.lastProbe, h.probes = true, now, now, 0
+    return fmt.Sprintf("Disabled target %s, it has been failing for %s: %s", name, humanize(now.Sub(h.failingSince)), h.lastError)
+  }
+  return ""
+}
+
+// record a delivery to the sink, alerting the operator when that disables
+// or re-enables it
+func (s *sink) recordHealth(err error) {
+  if alert := s.health.record(s.name, err); len(alert) > 0 {
+    alertOperator(s.creds, alert)
+  }
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+  "encoding/xml"
+  "fmt"
+  "net/http"
+  "net/url"
+  "strings"
+  "sync"
+  "time"
+)
+
+// atom feeds of the issues rules match, served on the control api at
+// /feeds/RULE.atom, for stakeholders to follow in a feed reader. only the
+// rules listed get a feed, "*" for all of them
+//
+//   feeds:
+//     rules: [sev1, payments]
+//     limit: 30   # the newest issues in a feed, 30 by default
+//
+// each entry is an issue with its status, priority and assignee, and is
+// updated when the issue is. feeds are cached for a minute
+type FeedsConfig struct {
+  Rules []string `yaml:"rules"`
+  Limit int      `yaml:"limit"`
+}
+
+const feedCacheTTL = time.Minute
+
+type atomFeed struct {
+  XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+  Title   string      `xml:"title"`
+  Id      string      `xml:"id"`
+  Updated string      `xml:"updated"`
+  Link    []atomLink  `xml:"link"`
+  Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+  Href string `xml:"href,attr"`
+  Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+  Title   string      `xml:"title"`
+  Id      string      `xml:"id"`
+  Updated string      `xml:"updated"`
+  Link    atomLink    `xml:"link"`
+  Author  *atomPerson `xml:"author,omitempty"`
+  Summary string      `xml:"summary"`
+}
+
+type atomPerson struct {
+  Name string `xml:"name"`
+}
+
+var feeds = struct {
+  sync.Mutex
+  creds  *Config
+  cached map[string]cachedFeed // rule -> its feed
+}{cached: map[string]cachedFeed{}}
+
+type cachedFeed struct {
+  body    []byte
+  fetched time.Time
+}
+
+func loadFeeds(creds *Config) {
+  feeds.Lock()
+  defer feeds.Unlock()
+  feeds.creds = creds
+  if creds.Feeds.Limit <= 0 {
+    creds.Feeds.Limit = 30
+  }
+}
+
+func (c *FeedsConfig) serves(rule string) bool {
+  return contains(c.Rules, "*") || contains(c.Rules, rule)
+}
+
+// a jira date as atom wants it
+func atomTime(date string) string {
+  t, err := time.Parse(dateLayout, date)
+  if err != nil {
+    return time.Now().UTC().Format(time.RFC3339)
+  }
+  return t.UTC().Format(time.RFC3339)
+}
+
+// the rule's newest matching issues as a feed
+func ruleFeed(rule *Rule, self string, creds *Config) ([]byte, error) {
+  issues, fields, err := searchIssues(rule.jql()+" ORDER BY created DESC", creds.Feeds.Limit, creds)
+  if err != nil {
+    return nil, err
+  }
+  feed := atomFeed{
+    Title:   "Issues matching " + rule.Name,
+    Id:      "urn:jira-ticket-tracker:rule:" + url.PathEscape(rule.Name),
+    Updated: time.Now().UTC().Format(time.RFC3339),
+    Link:    []atomLink{{Href: self, Rel: "self"}},
+  }
+  for i, issue := range issues {
+    if !conditionsPassed(rule.evaluate(issue, fields[i], creds)) {
+      continue
+    }
+    browse := creds.browseUrl(issue.Key)
+    details := []string{"Status: " + fieldString(fields[i], "status.name")}
+    if priority := fieldString(fields[i], "priority.name"); len(priority) > 0 {
+      details = append(details, "Priority: "+priority)
+    }
+    assignee := fieldString(fields[i], "assignee.displayName")
+    if len(assignee) == 0 {
+      assignee = "unassigned"
+    }
+    details = append(details, "Assignee: "+assignee)
+    entry := atomEntry{
+      Title:   issue.Key + " " + fieldString(fields[i], "summary"),
+      Id:      browse,
+      Updated: atomTime(fieldString(fields[i], "updated")),
+      Link:    atomLink{Href: browse},
+      Summary: strings.Join(details, ", "),
+    }
+    if reporter := fieldString(fields[i], "reporter.displayName"); len(reporter) > 0 {
+      entry.Author = &atomPerson{reporter}
+    }
+    feed.Entries = append(feed.Entries, entry)
+  }
+  body, err := xml.MarshalIndent(feed, "", "  ")
+  if err != nil {
+    return nil, err
+  }
+  return append([]byte(xml.Header), body...), nil
+}
+
+func handleFeeds(w http.ResponseWriter, r *http.Request) {
+  if r.Method != "GET" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+  name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/feeds/"), ".atom")
+  feeds.Lock()
+  creds := feeds.creds
+  feeds.Unlock()
+  if creds == nil || !creds.Feeds.serves(name) {
+    writeError(w, http.StatusNotFound, "no feed for "+name)
+    return
+  }
+  rule := findRule(configuredPollerRules(), name)
+  if rule == nil {
+    writeError(w, http.StatusNotFound, "unknown rule "+name)
+    return
+  }
+
+  feeds.Lock()
+  cached, ok := feeds.cached[name]
+  feeds.Unlock()
+  if !ok || time.Since(cached.fetched) > feedCacheTTL {
+    self := fmt.Sprintf("http://%s%s", r.Host, r.URL.Path)
+    body, err := ruleFeed(rule, self, creds)
+    if err != nil {
+      writeError(w, http.StatusBadGateway, "searching jira: "+err.Error())
+      return
+    }
+    cached = cachedFeed{body, time.Now()}
+    feeds.Lock()
+    feeds.cached[name] = cached
+    feeds.Unlock()
+  }
+  w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+  w.Write(cached.body)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+  "crypto/tls"
+  "fmt"
+  "net/http"
+  "os"
+)
+
+// fips mode, for deployments that may only use fips 140 approved crypto.
+// tls is limited to 1.2 and up with approved cipher suites and curves, the
+// hmac secrets for signing have to be at least 112 bits, and what isn't
+// approved is refused, like nsca's xor encryption. it's on with `fips:
+// true`, and always in a build made with `make fips`, which also uses go's
+// validated fips 140 module and won't start if it's been turned off
+//
+//   fips: true
+var fipsMode bool
+
+// approved and in go's tls, tls 1.3's are fixed to approved ones in fips mode
+var fipsCipherSuites = []uint16{
+  tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+  tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+  tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+  tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+var fipsCurves = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+
+// the smallest hmac secret allowed, in bytes
+const fipsMinSecret = 14
+
+func loadFIPS(creds *Config) {
+  fipsMode = creds.FIPS || fipsBuild
+  if !fipsMode {
+    return
+  }
+  if fipsBuild && !fipsModuleEnabled() {
+    logger.Print("This is a fips build but go's fips 140 module is off, check GODEBUG")
+    os.Exit(1)
+  }
+  transport := http.DefaultTransport.(*http.Transport)
+  transport.TLSClientConfig = fipsTLS(transport.TLSClientConfig)
+  logger.Print("FIPS mode is on")
+}
+
+// c limited to approved settings in fips mode, as it is otherwise. nil is an
+// empty config
+func fipsTLS(c *tls.Config) *tls.Config {
+  if !fipsMode {
+    return c
+  }
+  if c == nil {
+    c = &tls.Config{}
+  } else {
+    c = c.Clone()
+  }
+  if c.MinVersion < tls.VersionTLS12 {
+    c.MinVersion = tls.VersionTLS12
+  }
+  c.CipherSuites = fipsCipherSuites
+  c.CurvePreferences = fipsCurves
+  return c
+}
+
+// an error if a secret is too short to sign with in fips mode
+func checkFIPSSecret(what, secret string) error {
+  if fipsMode && len(secret) < fipsMinSecret {
+    return fmt.Errorf("%s has to be at least %d bytes in fips mode", what, fipsMinSecret)
+  }
+  return nil
+}
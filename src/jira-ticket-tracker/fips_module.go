@@ -0,0 +1,13 @@
+//go:build fips
+// +build fips
+
+package main
+
+import "crypto/fips140"
+
+// built with `make fips`, GOFIPS140 set and the fips tag
+const fipsBuild = true
+
+func fipsModuleEnabled() bool {
+  return fips140.Enabled()
+}
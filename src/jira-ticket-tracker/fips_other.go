@@ -0,0 +1,10 @@
+//go:build !fips
+// +build !fips
+
+package main
+
+const fipsBuild = false
+
+func fipsModuleEnabled() bool {
+  return false
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "os"
+  "strings"
+  "time"
+)
+
+// how often unanswered issues are checked against their first response target
+const firstResponseInterval = time.Minute
+
+const (
+  eventFirstResponseAtRisk   = "first-response-at-risk"
+  eventFirstResponseBreached = "first-response-breached"
+)
+
+// a first response target for a support project, configured under
+// `first_response`. the clock runs (in the project's business hours, see
+// Calendar) from creation until the first public comment by one of the
+// team, and a warning fires once `warn_after` has passed without one (and
+// again if the target itself is missed)
+//
+//   first_response:
+//     - project: SUPPORT
+//       target: 4h
+//       warn_after: 3h
+//       team: [alice, bob, "role:Service Desk Team"]  # see recipients.go
+//       targets: [support-leads]
+type FirstResponsePolicy struct {
+  Project   string   `yaml:"project"`
+  Target    string   `yaml:"target"`
+  WarnAfter string   `yaml:"warn_after"`
+  Team      []string `yaml:"team"`
+  Targets   []string `yaml:"targets"`
+
+  target    time.Duration
+  warnAfter time.Duration
+}
+
+func (p *FirstResponsePolicy) parse() error {
+  var err error
+  if p.target, err = parseDuration(p.Target); err != nil {
+    return fmt.Errorf("invalid target %q: %v", p.Target, err)
+  }
+  p.warnAfter = p.target
+  if len(p.WarnAfter) > 0 {
+    if p.warnAfter, err = parseDuration(p.WarnAfter); err != nil {
+      return fmt.Errorf("invalid warn_after %q: %v", p.WarnAfter, err)
+    }
+  }
+  return nil
+}
+
+type jiraComment struct {
+  Id     string `json:"id"`
+  Body   string `json:"body"`
+  Author struct {
+    Name string `json:"name"`
+  } `json:"author"`
+  Created    string                 `json:"created"`
+  Visibility map[string]interface{} `json:"visibility"`
+  JsdPublic  *bool                  `json:"jsdPublic"` // service desk internal notes
+}
+
+// comments restricted to a group/role or marked internal by the service
+// desk aren't a response to the customer
+func (c *jiraComment) public() bool {
+  return c.Visibility == nil && (c.JsdPublic == nil || *c.JsdPublic)
+}
+
+func issueComments(key string, creds *Config) ([]jiraComment, error) {
+  contents, err := jiraRequest("GET", "/issue/"+key+"/comment", nil, creds)
+  if err != nil {
+    return nil, err
+  }
+  var page struct {
+    Comments []jiraComment `json:"comments"`
+  }
+  err = json.Unmarshal(contents, &page)
+  return page.Comments, err
+}
+
+// the time of the first public comment by a team member, if any
+func firstTeamResponse(comments []jiraComment, team []string) string {
+  for _, c := range comments {
+    if c.public() && matchesAny(team, c.Author.Name) {
+      return c.Created
+    }
+  }
+  return ""
+}
+
+func policyFor(policies []FirstResponsePolicy, key string) *FirstResponsePolicy {
+  for i := range policies {
+    if strings.HasPrefix(key, strings.ToUpper(policies[i].Project)+"-") {
+      return &policies[i]
+    }
+  }
+  return nil
+}
+
+func timeFirstResponses(rules []*Rule, creds *Config, c chan []*Event) {
+  policies := creds.FirstResponse
+  for i := range policies {
+    if err := policies[i].parse(); err != nil {
+      logger.Print("Invalid first_response for ", policies[i].Project, ": ", err)
+      os.Exit(1)
+    }
+  }
+  rulesByName := map[string]*Rule{}
+  for _, rule := range rules {
+    rulesByName[rule.Name] = rule
+  }
+
+  for {
+    time.Sleep(firstResponseInterval)
+    producers.RLock()
+
+    for key, tracked := range state.TrackedIssues() {
+      if !shards.follows(rulesByName, tracked.Rule) {
+        continue
+      }
+      policy := policyFor(policies, key)
+      snapshot := tracked.Snapshot
+      if policy == nil || snapshot == nil || len(tracked.FirstResponse) > 0 || len(snapshot.Resolution) > 0 {
+        continue
+      }
+      if contains(tracked.FirstResponseWarned, eventFirstResponseBreached) {
+        continue // nothing is left to warn about, so the comments aren't fetched again
+      }
+      created, err := time.Parse(dateLayout, snapshot.Created)
+      if err != nil {
+        continue
+      }
+      waited := calendarFor(creds, key).Elapsed(created, time.Now())
+      if waited < policy.warnAfter {
+        continue // no need to look at the comments yet
+      }
+
+      comments, err := issueComments(key, creds)
+      if err != nil {
+        logger.Print("Error fetching comments for ", key, ": ", err)
+        continue
+      }
+      team := expandPrincipals(policy.Team, policy.Project, creds)
+      if at := firstTeamResponse(comments, team); len(at) > 0 {
+        state.SetFirstResponse(key, at)
+        continue
+      }
+
+      kind := eventFirstResponseAtRisk
+      if waited >= policy.target {
+        kind = eventFirstResponseBreached
+      }
+      if contains(tracked.FirstResponseWarned, kind) {
+        continue // already warned
+      }
+
+      contents := jiraIssue(key, creds)
+      if contents == nil {
+        continue
+      }
+      issue, fields, err := parseIssue(contents)
+      if err != nil {
+        logger.Print("Error parsing issue ", key, ": ", err)
+        continue
+      }
+      event := newEvent(kind, issue, fields)
+      event.Detail = fmt.Sprintf("no response after %s (target %s)", waited.Truncate(time.Minute), policy.Target)
+      event.Targets = policy.Targets
+      if rule, ok := rulesByName[tracked.Rule]; ok {
+        event.Rule = rule.Name
+        if targets, ok := rule.On[kind]; ok {
+          event.Targets = targets
+        }
+      }
+      state.MarkFirstResponseWarning(key, kind)
+      c <- []*Event{event}
+    }
+    producers.RUnlock()
+  }
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+  "bytes"
+  "crypto/hmac"
+  "encoding/json"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "os"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+func init() {
+  commands["agent"] = command{"agent --coordinator=URL --token=TOKEN [--name=NAME] [--workers=2]", agentCommand}
+}
+
+// splits the tracker in two for more search throughput than one jira user
+// gets: a coordinator, configured with `fleet: {role: coordinator}`, owns
+// the rules, the state and the notifying, and hands each rule's searches to
+// agents. agents are stateless `jira-ticket-tracker agent` processes, each
+// with its own jira credentials, that run the searches and send back the
+// issues. they talk over the coordinator's control api, json over http
+// rather than grpc, which would need a dependency the tracker doesn't
+// vendor. only the rules' searches are handed out: the actions, and the
+// watchers, reminders and chains, still call jira as the coordinator
+//
+//   fleet:
+//     role: coordinator
+//     token: ...    # that agents have to present
+//     lease: 2m     # how long an agent gets to finish a search
+type FleetConfig struct {
+  Role  string `yaml:"role"`
+  Token string `yaml:"token"`
+  Lease string `yaml:"lease"`
+}
+
+// one search for an agent to run
+type fleetJob struct {
+  Id       string `json:"id"`
+  Rule     string `json:"rule"`
+  Jql      string `json:"jql"`
+  PageSize int    `json:"page_size"`
+
+  result    chan fleetResult
+  abandoned bool // given up on, whether or not an agent has it
+}
+
+// what an agent found
+type fleetResult struct {
+  Id     string            `json:"id"`
+  Agent  string            `json:"agent"`
+  Issues []json.RawMessage `json:"issues"`
+  Error  string            `json:"error,omitempty"`
+}
+
+// FleetAgent is an agent the coordinator has heard from
+type FleetAgent struct {
+  Name     string    `json:"name"`
+  LastSeen time.Time `json:"last_seen"`
+  Searches int       `json:"searches"`
+  Failures int       `json:"failures"`
+}
+
+var fleet = &fleetCoordinator{}
+
+type fleetCoordinator struct {
+  config FleetConfig
+  lease  time.Duration
+  queue  chan *fleetJob
+
+  mu     sync.Mutex
+  nextId int
+  leased map[string]*fleetJob
+  agents map[string]*FleetAgent
+}
+
+func loadFleet(creds *Config) {
+  fleet = &fleetCoordinator{
+    config: creds.Fleet,
+    lease:  durationOr(creds.Fleet.Lease, 2*time.Minute),
+    queue:  make(chan *fleetJob, 1000),
+    leased: map[string]*fleetJob{},
+    agents: map[string]*FleetAgent{},
+  }
+  if fleet.coordinating() && len(creds.Fleet.Token) == 0 {
+    logger.Print("fleet.token is required for a coordinator")
+    os.Exit(1)
+  }
+}
+
+func (f *fleetCoordinator) coordinating() bool {
+  return f.config.Role == "coordinator"
+}
+
+// have an agent run a window's search. if no agent finishes it in time it
+// fails like any search would, and the window is tried again next poll
+func (f *fleetCoordinator) searchWindow(rule *Rule, since, until time.Time, creds *Config) ([]*Event, error) {
+  f.mu.Lock()
+  f.nextId++
+  job := &fleetJob{
+    Id: strconv.Itoa(f.nextId), Rule: rule.Name, Jql: windowQuery(rule, since, until),
+    PageSize: rule.pageSize(creds), result: make(chan fleetResult, 1),
+  }
+  f.mu.Unlock()
+
+  f.queue <- job
+  select {
+  case result := <-job.result:
+    if len(result.Error) > 0 {
+      return nil, fmt.Errorf("search for rule %s failed on agent %s: %s", rule.Name, result.Agent, result.Error)
+    }
+    events := []*Event{}
+    issueIsMatch := issueFilter(rule.Project, since, until)
+    for _, raw := range result.Issues {
+      if event := windowEvent(rule, raw, issueIsMatch, creds); event != nil {
+        events = append(events, event)
+      }
+    }
+    return events, nil
+  case <-time.After(f.lease):
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    job.abandoned = true
+    delete(f.leased, job.Id)
+    return nil, fmt.Errorf("search for rule %s failed: no agent finished it within %s", rule.Name, f.lease)
+  }
+}
+
+func (f *fleetCoordinator) seen(name string) *FleetAgent {
+  agent, ok := f.agents[name]
+  if !ok {
+    agent = &FleetAgent{Name: name}
+    f.agents[name] = agent
+  }
+  agent.LastSeen = time.Now()
+  return agent
+}
+
+func (f *fleetCoordinator) authorized(r *http.Request) bool {
+  return hmac.Equal([]byte("Bearer "+f.config.Token), []byte(r.Header.Get("Authorization")))
+}
+
+// the next search, waiting a while for one so agents can long poll
+func (f *fleetCoordinator) take(agent string) *fleetJob {
+  f.mu.Lock()
+  f.seen(agent)
+  f.mu.Unlock()
+  timeout := time.After(30 * time.Second)
+  for {
+    select {
+    case job := <-f.queue:
+      f.mu.Lock()
+      if job.abandoned {
+        f.mu.Unlock()
+        continue
+      }
+      f.leased[job.Id] = job
+      f.mu.Unlock()
+      return job
+    case <-timeout:
+      return nil
+    }
+  }
+}
+
+func (f *fleetCoordinator) report(result fleetResult) bool {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  agent := f.seen(result.Agent)
+  job, ok := f.leased[result.Id]
+  if !ok {
+    return false // given up on already
+  }
+  delete(f.leased, result.Id)
+  agent.Searches++
+  if len(result.Error) > 0 {
+    agent.Failures++
+  }
+  select {
+  case job.result <- result:
+  default:
+  }
+  return true
+}
+
+//   POST /fleet/lease    an agent asking for a search, {"agent": NAME}
+//   POST /fleet/result   an agent sending back what a search found
+//   GET  /fleet          the agents and the searches waiting for one
+func handleFleet(w http.ResponseWriter, r *http.Request) {
+  if !fleet.coordinating() {
+    writeError(w, http.StatusNotFound, "this tracker isn't a fleet coordinator")
+    return
+  }
+  if !fleet.authorized(r) {
+    writeError(w, http.StatusUnauthorized, "invalid token")
+    return
+  }
+  switch {
+  case r.Method == "GET" && r.URL.Path == "/fleet":
+    fleet.mu.Lock()
+    agents := []FleetAgent{}
+    for _, agent := range fleet.agents {
+      agents = append(agents, *agent)
+    }
+    status := map[string]interface{}{"agents": agents, "queued": len(fleet.queue), "leased": len(fleet.leased)}
+    fleet.mu.Unlock()
+    writeJSON(w, http.StatusOK, status)
+  case r.Method == "POST" && r.URL.Path == "/fleet/lease":
+    var body struct {
+      Agent string `json:"agent"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Agent) == 0 {
+      writeError(w, http.StatusBadRequest, "expected {\"agent\": NAME}")
+      return
+    }
+    job := fleet.take(body.Agent)
+    if job == nil {
+      w.WriteHeader(http.StatusNoContent)
+      return
+    }
+    writeJSON(w, http.StatusOK, job)
+  case r.Method == "POST" && r.URL.Path == "/fleet/result":
+    var result fleetResult
+    if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+      writeError(w, http.StatusBadRequest, err.Error())
+      return
+    }
+    if !fleet.report(result) {
+      writeError(w, http.StatusConflict, "the search took too long and was given up on")
+      return
+    }
+    w.WriteHeader(http.StatusNoContent)
+  default:
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+  }
+}
+
+// the agent's side of the control api
+type fleetClient struct {
+  url   string
+  token string
+  name  string
+  http  *http.Client
+}
+
+func (c *fleetClient) post(path string, body, out interface{}) (int, error) {
+  contents, err := json.Marshal(body)
+  if err != nil {
+    return 0, err
+  }
+  req, err := http.NewRequest("POST", c.url+path, bytes.NewReader(contents))
+  if err != nil {
+    return 0, err
+  }
+  req.Header.Set("Content-Type", "application/json")
+  req.Header.Set("Authorization", "Bearer "+c.token)
+  resp, err := c.http.Do(req)
+  if err != nil {
+    return 0, err
+  }
+  defer drainAndClose(resp.Body)
+  if resp.StatusCode >= 300 {
+    message, _ := ioutil.ReadAll(resp.Body)
+    return resp.StatusCode, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+  }
+  if out != nil && resp.StatusCode != http.StatusNoContent {
+    return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+  }
+  return resp.StatusCode, nil
+}
+
+// run every page of a search, keeping the issues as they came
+func (c *fleetClient) run(job *fleetJob, creds *Config) fleetResult {
+  result := fleetResult{Id: job.Id, Agent: c.name, Issues: []json.RawMessage{}}
+  for startAt := 0; ; {
+    total, count, err := streamSearch(job.Jql, startAt, job.PageSize, creds, func(data []byte) {
+      result.Issues = append(result.Issues, json.RawMessage(append([]byte{}, data...)))
+    })
+    if err != nil {
+      result.Error = err.Error()
+      return result
+    }
+    startAt += count
+    if count == 0 || startAt >= total {
+      return result
+    }
+  }
+}
+
+func (c *fleetClient) work(creds *Config) {
+  for {
+    var job fleetJob
+    status, err := c.post("/fleet/lease", map[string]string{"agent": c.name}, &job)
+    if err != nil {
+      logger.Print("Error asking the coordinator for a search: ", err)
+      time.Sleep(10 * time.Second)
+      continue
+    }
+    if status == http.StatusNoContent {
+      continue
+    }
+    result := c.run(&job, creds)
+    if _, err := c.post("/fleet/result", result, nil); err != nil {
+      logger.Print("Error reporting the search for rule ", job.Rule, ": ", err)
+    }
+  }
+}
+
+// run searches for a coordinator, with the jira credentials in this
+// agent's own config
+func agentCommand(args []string) {
+  flags := flag.NewFlagSet("agent", flag.ExitOnError)
+  coordinator := flags.String("coordinator", "", "The control api of the coordinator")
+  token := flags.String("token", os.Getenv("TRACKER_FLEET_TOKEN"), "The coordinator's fleet.token, or $TRACKER_FLEET_TOKEN")
+  hostname, _ := os.Hostname()
+  name := flags.String("name", hostname, "What the coordinator knows this agent as")
+  workers := flags.Int("workers", 2, "How many searches to run at once")
+  flags.Parse(args)
+  if len(*coordinator) == 0 || len(*token) == 0 || *workers < 1 {
+    usageExit(commands["agent"].usage)
+  }
+
+  creds, _ := setup()
+  client := &fleetClient{
+    url: strings.TrimRight(*coordinator, "/"), token: *token, name: *name,
+    http: &http.Client{Timeout: time.Minute},
+  }
+  logger.Print("Running searches for ", client.url, " as ", *name)
+  for i := 1; i < *workers; i++ {
+    go client.work(creds)
+  }
+  client.work(creds)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "math/rand"
+  "os"
+  "sort"
+  "strconv"
+  "strings"
+  "text/tabwriter"
+  "time"
+)
+
+func init() {
+  commands["forecast"] = command{"forecast [--jql=JQL] [--project=KEY] [--issues=N] [--sample=90d] [--runs=10000] [--percentiles=50,85,95]", forecastCommand}
+}
+
+// the issues resolved each day of the sample, oldest first. each day is
+// counted with its own search, so the issues themselves aren't fetched
+func dailyThroughput(jql string, from, to time.Time, creds *Config) ([]int, error) {
+  days := []int{}
+  start := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.Local)
+  for day := start; !day.After(to); day = day.AddDate(0, 0, 1) {
+    since, until := day, day.AddDate(0, 0, 1)
+    if since.Before(from) {
+      since = from
+    }
+    query := fmt.Sprintf("resolved >= %s AND resolved < %s", jqlTime(since), jqlTime(until))
+    if until.After(to) {
+      query = fmt.Sprintf("resolved >= %s AND resolved <= %s", jqlTime(since), jqlTime(to))
+    }
+    if len(jql) > 0 {
+      query = "(" + jql + ") AND " + query
+    }
+    n, err := countIssues(query, creds)
+    if err != nil {
+      return nil, err
+    }
+    days = append(days, n)
+  }
+  return days, nil
+}
+
+// how many days each run took to finish the issues, drawing each day's
+// throughput at random from the sample, sorted. nil if nothing was resolved
+// in the sample, so it would never finish
+func simulateCompletion(issues int, throughput []int, runs int, random *rand.Rand) []int {
+  resolved := 0
+  for _, n := range throughput {
+    resolved += n
+  }
+  if resolved == 0 {
+    return nil
+  }
+  results := make([]int, runs)
+  for run := range results {
+    left, days := issues, 0
+    for left > 0 {
+      left -= throughput[random.Intn(len(throughput))]
+      days++
+    }
+    results[run] = days
+  }
+  sort.Ints(results)
+  return results
+}
+
+// the days by which p percent of the runs had finished
+func completionPercentile(results []int, p int) int {
+  i := (len(results)*p+99)/100 - 1
+  if i < 0 {
+    i = 0
+  }
+  return results[i]
+}
+
+// when the open issues are likely to be done, by how many issues a day got
+// resolved over the sample
+func forecastCommand(args []string) {
+  flags := flag.NewFlagSet("forecast", flag.ExitOnError)
+  jql := flags.String("jql", "", "The issues to forecast, the open ones are the work left and the resolved ones the throughput")
+  project := flags.String("project", "", "Only issues in this project")
+  issues := flags.Int("issues", 0, "How many issues are left, instead of counting the open ones")
+  sampleFlag := flags.String("sample", "90d", "How far back to measure the throughput")
+  runs := flags.Int("runs", 10000, "How many times to simulate")
+  percentiles := flags.String("percentiles", "50,85,95", "The likelihoods to give dates for")
+  flags.Parse(args)
+  if flags.NArg() > 0 || *runs <= 0 {
+    usageExit(commands["forecast"].usage)
+  }
+  sample, err := parseDuration(*sampleFlag)
+  if err != nil || sample < 24*time.Hour {
+    logger.Print("Invalid --sample, it has to be a day or more: ", *sampleFlag)
+    os.Exit(1)
+  }
+  likelihoods := []int{}
+  for _, s := range strings.Split(*percentiles, ",") {
+    p, err := strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(s, "%")))
+    if err != nil || p <= 0 || p > 100 {
+      logger.Print("Invalid percentile ", s)
+      os.Exit(1)
+    }
+    likelihoods = append(likelihoods, p)
+  }
+
+  creds, _ := setup()
+  query := configJql(creds, "--jql", *jql)
+  if len(*project) > 0 {
+    clause := "project = " + strings.ToUpper(*project)
+    if len(query) > 0 {
+      clause += " AND (" + query + ")"
+    }
+    query = clause
+  }
+  left := *issues
+  if left == 0 {
+    open := "resolution = EMPTY"
+    if len(query) > 0 {
+      open = "(" + query + ") AND " + open
+    }
+    if left, err = countIssues(open, creds); err != nil {
+      logger.Print("Error counting the open issues: ", err)
+      os.Exit(1)
+    }
+  }
+  if left == 0 {
+    fmt.Println("No issues are left")
+    return
+  }
+  now := time.Now()
+  throughput, err := dailyThroughput(query, now.Add(-sample), now, creds)
+  if err != nil {
+    logger.Print("Error measuring the throughput: ", err)
+    os.Exit(1)
+  }
+  results := simulateCompletion(left, throughput, *runs, rand.New(rand.NewSource(now.UnixNano())))
+  if results == nil {
+    logger.Print("Nothing was resolved in the last ", *sampleFlag, ", so there's nothing to forecast from")
+    os.Exit(1)
+  }
+
+  resolved := 0
+  for _, n := range throughput {
+    resolved += n
+  }
+  fmt.Printf("%d issues left, %.1f resolved a day over the last %d days, %d runs:\n", left,
+    float64(resolved)/float64(len(throughput)), len(throughput), *runs)
+  w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+  fmt.Fprintln(w, "LIKELIHOOD\tDONE BY\tDAYS")
+  for _, p := range likelihoods {
+    days := completionPercentile(results, p)
+    fmt.Fprintf(w, "%d%%\t%s\t%d\n", p, now.AddDate(0, 0, days).Format("2006-01-02"), days)
+  }
+  w.Flush()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+  "fmt"
+  "regexp"
+  "strings"
+)
+
+// a form-check target asks the reporter of a new issue for the details the
+// project requires and moves it to a status like Needs Info
+//
+//   targets:
+//     needs-info:
+//       type: form-check
+//       template: needs-info  # the comment, given the event and .Missing
+//       form:
+//         transition: Needs Info
+//         projects:
+//           OPS:
+//             - name: environment
+//               field: environment
+//             - name: steps to reproduce
+//               pattern: "(?i)steps to reproduce"
+type FormConfig struct {
+  Transition string                   `yaml:"transition"`
+  Projects   map[string][]Requirement `yaml:"projects"`
+}
+
+// a Requirement is met if the field (a dotted path into the raw fields,
+// default description) is set, and matches the pattern if one is given
+type Requirement struct {
+  Name    string `yaml:"name"`
+  Field   string `yaml:"field"`
+  Pattern string `yaml:"pattern"`
+
+  pattern *regexp.Regexp
+}
+
+func (r *Requirement) met(fields map[string]interface{}) bool {
+  field := r.Field
+  if len(field) == 0 {
+    field = "description"
+  }
+  value := fieldValue(fields, field)
+  if r.pattern == nil {
+    return !emptyField(value)
+  }
+  return r.pattern.MatchString(fieldString(fields, field))
+}
+
+func emptyField(value interface{}) bool {
+  switch v := value.(type) {
+  case nil:
+    return true
+  case string:
+    return len(strings.TrimSpace(v)) == 0
+  case []interface{}:
+    return len(v) == 0
+  case []string:
+    return len(v) == 0
+  case []map[string]string:
+    return len(v) == 0
+  case map[string]interface{}:
+    return len(v) == 0
+  }
+  return false
+}
+
+// what the comment template is given, the event plus what's missing
+type formData struct {
+  *Event
+  Missing []string
+}
+
+type formNotifier struct {
+  name     string
+  template string
+  config   FormConfig
+  creds    *Config
+}
+
+func newFormNotifier(name string, target Target, creds *Config) (*formNotifier, error) {
+  for project, requirements := range target.Form.Projects {
+    for i := range requirements {
+      r := &requirements[i]
+      if len(r.Name) == 0 {
+        r.Name = r.Field
+      }
+      if len(r.Pattern) == 0 {
+        continue
+      }
+      var err error
+      if r.pattern, err = regexp.Compile(r.Pattern); err != nil {
+        return nil, fmt.Errorf("invalid pattern for %s in %s: %v", r.Name, project, err)
+      }
+    }
+  }
+  return &formNotifier{name: name, template: target.Template, config: target.Form, creds: creds}, nil
+}
+
+func (n *formNotifier) missing(event *Event) []string {
+  project := ""
+  if event.Issue.Fields.Project != nil {
+    project = event.Issue.Fields.Project.Key
+  }
+  missing := []string{}
+  for i := range n.config.Projects[project] {
+    r := &n.config.Projects[project][i]
+    if !r.met(event.Fields) {
+      missing = append(missing, r.Name)
+    }
+  }
+  return missing
+}
+
+func (n *formNotifier) comment(event *Event, missing []string) string {
+  if len(n.template) > 0 {
+    comment, err := templates.Render(n.template, formData{event, missing})
+    if err == nil {
+      return comment
+    }
+    logger.Print("Error rendering template ", n.template, " for ", n.name, ": ", err)
+  }
+  return "Thanks for the report! To look into it we need a few more details:\n* " +
+    strings.Join(missing, "\n* ")
+}
+
+// only new issues are checked, the message rendered for the sink is unused
+// since the comment needs the missing details
+func (n *formNotifier) Notify(event *Event, message string) error {
+  if event.Kind != eventCreated {
+    return nil
+  }
+  missing := n.missing(event)
+  if len(missing) == 0 {
+    return nil
+  }
+
+  if err := addComment(event.Issue.Key, n.comment(event, missing), n.creds); err != nil {
+    return err
+  }
+  logger.Print(n.name, ": asked for ", strings.Join(missing, ", "), " on ", event.Issue.Key)
+  if len(n.config.Transition) > 0 {
+    return transitionIssue(event.Issue.Key, n.config.Transition, n.creds)
+  }
+  return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+  "fmt"
+  "os"
+  "regexp"
+)
+
+// named pieces of jql that rules, watermarks, wallboard panels and the
+// --jql of commands can use as ${name}, so a clause shared by many queries
+// is written once. fragments can use each other, and each is put in
+// parentheses where it's used, so one with OR in it still means the same.
+// they're conditions, so ORDER BY belongs in the query, not a fragment
+//
+//   jql_fragments:
+//     openBugs: type = Bug AND resolution = EMPTY
+//     customerFacing: component in (Web, Mobile) OR labels = customer
+//     hot: ${openBugs} AND ${customerFacing}
+//   rules:
+//     - name: hot-bugs
+//       jql: ${hot} AND priority >= High
+var jqlFragmentPattern = regexp.MustCompile(`\$\{([A-Za-z0-9_.-]+)\}`)
+
+// jql with its ${name}s replaced by the fragments
+func expandJql(jql string, fragments map[string]string) (string, error) {
+  return expandJqlFragments(jql, fragments, nil)
+}
+
+func expandJqlFragments(jql string, fragments map[string]string, using []string) (string, error) {
+  var err error
+  expanded := jqlFragmentPattern.ReplaceAllStringFunc(jql, func(ref string) string {
+    name := jqlFragmentPattern.FindStringSubmatch(ref)[1]
+    fragment, ok := fragments[name]
+    if err != nil {
+      return ref
+    }
+    if !ok {
+      err = fmt.Errorf("there's no jql fragment %s", name)
+      return ref
+    }
+    for _, outer := range using {
+      if outer == name {
+        err = fmt.Errorf("jql fragment %s uses itself", name)
+        return ref
+      }
+    }
+    var inner string
+    inner, err = expandJqlFragments(fragment, fragments, append(using, name))
+    return "(" + inner + ")"
+  })
+  return expanded, err
+}
+
+// check every fragment expands, and the queries outside rules that use them
+func loadJqlFragments(creds *Config) {
+  for name, fragment := range creds.JqlFragments {
+    if _, err := expandJqlFragments(fragment, creds.JqlFragments, []string{name}); err != nil {
+      logger.Print("Invalid jql_fragments: ", err)
+      os.Exit(1)
+    }
+  }
+  for i := range creds.Watermarks {
+    creds.Watermarks[i].Jql = configJql(creds, "watermark "+creds.Watermarks[i].Name, creds.Watermarks[i].Jql)
+  }
+  for i := range creds.Wallboard.Panels {
+    creds.Wallboard.Panels[i].Jql = configJql(creds, "wallboard panel "+creds.Wallboard.Panels[i].Title, creds.Wallboard.Panels[i].Jql)
+  }
+}
+
+// jql from the config or a flag, expanded, exiting if it uses a fragment
+// that isn't there
+func configJql(creds *Config, what, jql string) string {
+  expanded, err := expandJql(jql, creds.JqlFragments)
+  if err != nil {
+    logger.Print("Invalid jql for ", what, ": ", err)
+    os.Exit(1)
+  }
+  return expanded
+}
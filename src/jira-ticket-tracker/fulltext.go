@@ -0,0 +1,292 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "net/http"
+  "net/url"
+  "os"
+  "strconv"
+  "strings"
+  "text/tabwriter"
+  "time"
+
+  "github.com/blevesearch/bleve/v2"
+)
+
+func init() {
+  commands["grep"] = command{"grep [--project=KEY] [--limit=20] [--query] [--local] TEXT..", grepCommand}
+}
+
+// a local full-text index of every issue the tracker has seen, configured
+// under `full_text`. each issue is indexed as it was last seen, so it can
+// still be found once jira has archived it
+//
+//   full_text:
+//     path: /var/lib/jira-tracker/index
+//
+// a new index is filled from the history first, if there is one
+type FullTextConfig struct {
+  Path string `yaml:"path"`
+}
+
+// what's indexed of an issue
+type indexedIssue struct {
+  Key         string    `json:"key"`
+  Project     string    `json:"project"`
+  Summary     string    `json:"summary"`
+  Description string    `json:"description"`
+  Comments    string    `json:"comments"`
+  Status      string    `json:"status"`
+  Seen        time.Time `json:"seen"`
+}
+
+// a found issue, as the api returns it
+type GrepHit struct {
+  Key       string    `json:"key"`
+  Project   string    `json:"project"`
+  Summary   string    `json:"summary"`
+  Status    string    `json:"status"`
+  Seen      time.Time `json:"seen"`
+  Score     float64   `json:"score"`
+  Fragments []string  `json:"fragments,omitempty"` // the matching text, highlighted
+}
+
+type fullTextIndex struct {
+  index bleve.Index
+}
+
+// the index, opened when the tracker starts. nil if it isn't configured
+var fullText *fullTextIndex
+
+func openFullTextIndex(creds *Config) *fullTextIndex {
+  path := creds.FullText.Path
+  if len(path) == 0 {
+    return nil
+  }
+  index, err := bleve.Open(path)
+  created := false
+  if err == bleve.ErrorIndexPathDoesNotExist {
+    index, err = bleve.New(path, bleve.NewIndexMapping())
+    created = true
+  }
+  if err != nil {
+    logger.Print("Error opening the full-text index ", path, ": ", err)
+    os.Exit(1)
+  }
+  f := &fullTextIndex{index: index}
+  if created && store != nil {
+    go f.backfill()
+  }
+  return f
+}
+
+func issueDocument(fields map[string]interface{}, key string, seen time.Time) indexedIssue {
+  comments := []string{}
+  list, _ := fieldValue(fields, "comment.comments").([]interface{})
+  for _, c := range list {
+    c, _ := c.(map[string]interface{})
+    if body := fieldString(c, "body"); len(body) > 0 {
+      comments = append(comments, body)
+    }
+  }
+  project := fieldString(fields, "project.key")
+  if len(project) == 0 {
+    project = strings.SplitN(key, "-", 2)[0]
+  }
+  return indexedIssue{
+    Key:         key,
+    Project:     project,
+    Summary:     fieldString(fields, "summary"),
+    Description: fieldString(fields, "description"),
+    Comments:    strings.Join(comments, "\n\n"),
+    Status:      fieldString(fields, "status.name"),
+    Seen:        seen,
+  }
+}
+
+// index the events' issues as they are now
+func (f *fullTextIndex) add(events []*Event) {
+  if f == nil {
+    return
+  }
+  batch := f.index.NewBatch()
+  now := time.Now()
+  for _, event := range events {
+    if event.Issue.Key == trackerKey {
+      continue
+    }
+    batch.Index(event.Issue.Key, issueDocument(event.Fields, event.Issue.Key, now))
+  }
+  if batch.Size() == 0 {
+    return
+  }
+  if err := f.index.Batch(batch); err != nil {
+    logger.Print("Error indexing ", batch.Size(), " issues: ", err)
+  }
+}
+
+// index what the history has, the latest of each issue winning
+func (f *fullTextIndex) backfill() {
+  latest := map[string]indexedIssue{}
+  err := store.EachHistory(time.Time{}, func(r HistoryRecord) bool {
+    if r.Key == trackerKey {
+      return true
+    }
+    if _, fields, err := parseIssue(r.Issue); err == nil {
+      latest[r.Key] = issueDocument(fields, r.Key, r.Time)
+    }
+    return true
+  })
+  if err != nil {
+    logger.Print("Error reading the history into the full-text index: ", err)
+  }
+  batch := f.index.NewBatch()
+  for key, doc := range latest {
+    batch.Index(key, doc)
+    if batch.Size() >= 500 {
+      if err := f.index.Batch(batch); err != nil {
+        logger.Print("Error indexing the history: ", err)
+        return
+      }
+      batch = f.index.NewBatch()
+    }
+  }
+  if err := f.index.Batch(batch); err != nil {
+    logger.Print("Error indexing the history: ", err)
+    return
+  }
+  logger.Print("Indexed ", len(latest), " issues from the history")
+}
+
+// fill the index again from the history alone, e.g. once someone has been
+// purged from the history
+func rebuildFullTextIndex(path string) error {
+  if err := os.RemoveAll(path); err != nil {
+    return err
+  }
+  index, err := bleve.New(path, bleve.NewIndexMapping())
+  if err != nil {
+    return err
+  }
+  defer index.Close()
+  (&fullTextIndex{index: index}).backfill()
+  return nil
+}
+
+// the best matches for the text, an exact phrase unless it's in bleve's
+// query syntax
+func (f *fullTextIndex) search(text, project string, syntax bool, limit int) ([]GrepHit, error) {
+  var q bleve.Query = bleve.NewMatchPhraseQuery(text)
+  if syntax {
+    q = bleve.NewQueryStringQuery(text)
+  }
+  if len(project) > 0 {
+    inProject := bleve.NewMatchQuery(project)
+    inProject.SetField("project")
+    q = bleve.NewConjunctionQuery(q, inProject)
+  }
+  req := bleve.NewSearchRequestOptions(q, limit, 0, false)
+  req.Fields = []string{"key", "project", "summary", "status", "seen"}
+  req.Highlight = bleve.NewHighlight()
+
+  result, err := f.index.Search(req)
+  if err != nil {
+    return nil, err
+  }
+  hits := []GrepHit{}
+  for _, h := range result.Hits {
+    hit := GrepHit{Key: h.ID, Score: h.Score}
+    hit.Project, _ = h.Fields["project"].(string)
+    hit.Summary, _ = h.Fields["summary"].(string)
+    hit.Status, _ = h.Fields["status"].(string)
+    if seen, ok := h.Fields["seen"].(string); ok {
+      hit.Seen, _ = time.Parse(time.RFC3339, seen)
+    }
+    for _, fragments := range h.Fragments {
+      hit.Fragments = append(hit.Fragments, fragments...)
+    }
+    hits = append(hits, hit)
+  }
+  return hits, nil
+}
+
+//   GET /grep?q=TEXT&project=KEY&limit=20&syntax=true
+func handleGrep(w http.ResponseWriter, r *http.Request) {
+  if fullText == nil {
+    writeError(w, http.StatusNotFound, "full_text isn't configured")
+    return
+  }
+  query := r.URL.Query()
+  if len(query.Get("q")) == 0 {
+    writeError(w, http.StatusBadRequest, "q is required")
+    return
+  }
+  limit, err := strconv.Atoi(query.Get("limit"))
+  if err != nil || limit <= 0 {
+    limit = 20
+  }
+  hits, err := fullText.search(query.Get("q"), query.Get("project"), query.Get("syntax") == "true", limit)
+  if err != nil {
+    writeError(w, http.StatusBadRequest, err.Error())
+    return
+  }
+  writeJSON(w, http.StatusOK, hits)
+}
+
+// search everything the tracker has seen, through the running tracker or,
+// with --local, the index itself while the tracker is stopped
+func grepCommand(args []string) {
+  flags := flag.NewFlagSet("grep", flag.ExitOnError)
+  project := flags.String("project", "", "Only issues in this project")
+  limit := flags.Int("limit", 20, "The most issues to show")
+  syntax := flags.Bool("query", false, "TEXT is in bleve's query syntax, e.g. +summary:timeout -status:closed")
+  local := flags.Bool("local", false, "Open the index instead of asking the running tracker")
+  flags.Parse(args)
+  if flags.NArg() == 0 {
+    usageExit(commands["grep"].usage)
+  }
+  text := strings.Join(flags.Args(), " ")
+
+  var hits []GrepHit
+  if *local {
+    creds, _ := setup()
+    if len(creds.FullText.Path) == 0 {
+      logger.Print("full_text.path isn't configured")
+      os.Exit(1)
+    }
+    index, err := bleve.Open(creds.FullText.Path)
+    if err != nil {
+      logger.Print("Error opening the full-text index ", creds.FullText.Path, ": ", err)
+      os.Exit(1)
+    }
+    f := &fullTextIndex{index: index}
+    hits, err = f.search(text, strings.ToUpper(*project), *syntax, *limit)
+    index.Close()
+    if err != nil {
+      logger.Print("Error searching: ", err)
+      os.Exit(1)
+    }
+  } else {
+    query := url.Values{"q": {text}, "project": {strings.ToUpper(*project)}, "limit": {strconv.Itoa(*limit)}}
+    if *syntax {
+      query.Set("syntax", "true")
+    }
+    if err := callAPI("GET", "/grep?"+query.Encode(), nil, &hits); err != nil {
+      logger.Print("Error searching: ", err)
+      os.Exit(1)
+    }
+  }
+  if len(hits) == 0 {
+    fmt.Println("no issues found")
+    os.Exit(1)
+  }
+  w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+  for _, hit := range hits {
+    fmt.Fprintf(w, "%s\t%s\t%s\tseen %s\n", hit.Key, hit.Status, hit.Summary, hit.Seen.Format("2006-01-02"))
+    for _, fragment := range hit.Fragments {
+      fmt.Fprintf(w, "\t%s\n", strings.Join(strings.Fields(fragment), " "))
+    }
+  }
+  w.Flush()
+}
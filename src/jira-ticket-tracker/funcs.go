@@ -0,0 +1,210 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "strings"
+  "sync"
+  "time"
+)
+
+// the functions available to every template
+//
+//   truncate 80 .Issue.Fields.Summary   at most 80 characters, with "…"
+//   humanize .TimeToResolution           "2d 3h" style durations
+//   since .Issue.Fields.Created          how long ago a jira date was
+//   priorityEmoji "Blocker"              🔥, see DisplayConfig
+//   statusEmoji "Done"                   ✅ if configured
+//   typeEmoji "Bug"                      🐛 if configured
+//   color "priority" "Blocker"           #d04437 if configured
+//   jqlEscape "it's \"quoted\""          safe to put in a jql string
+//   mdEscape .Issue.Fields.Summary       escapes slack/markdown formatting
+//   get .Fields "status.name"            a raw field by dotted path
+//   field "Story Points" .Fields         a custom field by its display name
+//   default "none" .Detail               the fallback if the value is empty
+//   first .Fields "Customer" "reporter.displayName" "reporter.name"
+//                                        the first of the fields that's set
+func templateFuncs(creds *Config) map[string]interface{} {
+  return map[string]interface{}{
+    "truncate":      truncate,
+    "humanize":      humanize,
+    "since":         since,
+    "priorityEmoji": priorityEmoji,
+    "statusEmoji":   statusEmoji,
+    "typeEmoji":     typeEmoji,
+    "color":         displayColor,
+    "jqlEscape":     jqlEscape,
+    "mdEscape":      mdEscape,
+    "get":           fieldValue,
+    "field": func(name string, fields map[string]interface{}) interface{} {
+      return fields[customFields.id(name, creds)]
+    },
+    "default": defaultValue,
+    "first": func(fields map[string]interface{}, paths ...string) interface{} {
+      return firstField(fields, paths, creds)
+    },
+  }
+}
+
+func truncate(n int, s string) string {
+  runes := []rune(s)
+  if len(runes) <= n {
+    return s
+  }
+  if n < 1 {
+    return ""
+  }
+  return string(runes[:n-1]) + "…"
+}
+
+func humanize(d time.Duration) string {
+  if d < time.Minute {
+    return d.Truncate(time.Second).String()
+  }
+  days := d / (24 * time.Hour)
+  hours := (d % (24 * time.Hour)) / time.Hour
+  minutes := (d % time.Hour) / time.Minute
+  switch {
+  case days > 0 && hours > 0:
+    return fmt.Sprintf("%dd %dh", days, hours)
+  case days > 0:
+    return fmt.Sprintf("%dd", days)
+  case hours > 0 && minutes > 0:
+    return fmt.Sprintf("%dh %dm", hours, minutes)
+  case hours > 0:
+    return fmt.Sprintf("%dh", hours)
+  }
+  return fmt.Sprintf("%dm", minutes)
+}
+
+func since(date string) string {
+  t, err := time.Parse(dateLayout, date)
+  if err != nil {
+    return date
+  }
+  return humanize(time.Since(t))
+}
+
+func jqlEscape(s string) string {
+  return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `'`, `\'`).Replace(s)
+}
+
+var markdownEscaper = strings.NewReplacer(
+  `\`, `\\`, "*", `\*`, "_", `\_`, "~", `\~`, "`", "\\`",
+  "[", `\[`, "]", `\]`, "<", "&lt;", ">", "&gt;", "&", "&amp;",
+)
+
+func mdEscape(s string) string {
+  return markdownEscaper.Replace(s)
+}
+
+func defaultValue(fallback, value interface{}) interface{} {
+  switch v := value.(type) {
+  case nil:
+    return fallback
+  case string:
+    if len(v) == 0 {
+      return fallback
+    }
+  }
+  return value
+}
+
+// the first of the fields that isn't empty, nil if none is, so projects that
+// keep e.g. the customer in different places can share a template. each is
+// a dotted path into the raw fields, starting with the field's id or, for
+// a custom field, its display name
+func firstField(fields map[string]interface{}, paths []string, creds *Config) interface{} {
+  for _, path := range paths {
+    parts := strings.SplitN(path, ".", 2)
+    if _, ok := fields[parts[0]]; !ok {
+      parts[0] = customFields.id(parts[0], creds)
+    }
+    value := fieldValue(fields, strings.Join(parts, "."))
+    switch v := value.(type) {
+    case nil:
+      continue
+    case string:
+      if len(strings.TrimSpace(v)) == 0 {
+        continue
+      }
+    case []interface{}:
+      if len(v) == 0 {
+        continue
+      }
+    case map[string]interface{}:
+      if len(v) == 0 {
+        continue
+      }
+    }
+    return value
+  }
+  return nil
+}
+
+// the ids of jira's custom fields by display name, fetched from /field the
+// first time a template asks for one
+var customFields = &fieldNames{}
+
+type fieldNames struct {
+  mu  sync.Mutex
+  ids map[string]string
+}
+
+func (f *fieldNames) id(name string, creds *Config) string {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+
+  if !f.load(creds) {
+    return name
+  }
+  if id, ok := f.ids[strings.ToLower(name)]; ok {
+    return id
+  }
+  return name // let people use the id directly too
+}
+
+// whether jira has a field with this name or id, and whether that could be
+// checked at all
+func (f *fieldNames) exists(name string, creds *Config) (exists, checked bool) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+
+  if !f.load(creds) {
+    return false, false
+  }
+  if _, ok := f.ids[strings.ToLower(name)]; ok {
+    return true, true
+  }
+  for _, id := range f.ids {
+    if id == name {
+      return true, true
+    }
+  }
+  return false, true
+}
+
+// fetch the fields if they haven't been, with f.mu held
+func (f *fieldNames) load(creds *Config) bool {
+  if f.ids != nil {
+    return true
+  }
+  contents, err := jiraCached("/field", creds)
+  if err != nil {
+    logger.Print("Error fetching fields: ", err)
+    return false
+  }
+  var fields []struct {
+    Id   string `json:"id"`
+    Name string `json:"name"`
+  }
+  if err := json.Unmarshal(contents, &fields); err != nil {
+    logger.Print("Error parsing fields: ", err)
+    return false // try again next time
+  }
+  f.ids = map[string]string{}
+  for _, field := range fields {
+    f.ids[strings.ToLower(field.Name)] = field.Id
+  }
+  return true
+}
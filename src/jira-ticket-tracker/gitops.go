@@ -0,0 +1,267 @@
+package main
+
+import (
+  "fmt"
+  "io/ioutil"
+  "launchpad.net/goyaml"
+  "net/http"
+  "os"
+  "os/exec"
+  "path/filepath"
+  "strings"
+  "sync"
+  "time"
+)
+
+// rules kept in a git repository instead of the config, configured under
+// `gitops`. the tracker pulls the branch every interval and, when it has
+// moved, checks the rules file: every rule has to be valid, send to known
+// targets and pass its tests. only then are the new rules swapped in, all
+// at once. a bad commit changes nothing, the last good rules keep polling
+//
+//   gitops:
+//     repo: git@github.com:acme/tracker-rules.git
+//     branch: main        # the default
+//     path: rules.yaml    # in the repo, with `rules` and `rule_templates`
+//     dir: ./gitops       # where to check it out
+//     interval: 5m
+type GitOpsConfig struct {
+  Repo     string `yaml:"repo"`
+  Branch   string `yaml:"branch"`
+  Path     string `yaml:"path"`
+  Dir      string `yaml:"dir"`
+  Interval string `yaml:"interval"`
+}
+
+// GitOpsStatus is how syncing the rules has gone
+type GitOpsStatus struct {
+  Repo        string     `json:"repo"`
+  Branch      string     `json:"branch"`
+  Commit      string     `json:"commit,omitempty"` // of the rules being polled
+  Rules       int        `json:"rules"`
+  LastSync    *time.Time `json:"last_sync,omitempty"` // the last that changed the rules
+  LastAttempt *time.Time `json:"last_attempt,omitempty"`
+  LastError   string     `json:"last_error,omitempty"`
+  Failures    int        `json:"failures"`
+}
+
+// what the rules file in the repo can contain
+type gitopsRules struct {
+  Rules         []Rule                 `yaml:"rules"`
+  RuleTemplates map[string]interface{} `yaml:"rule_templates"`
+}
+
+var gitops = &gitopsSyncer{}
+
+type gitopsSyncer struct {
+  config   GitOpsConfig
+  interval time.Duration
+  creds    *Config
+
+  mu     sync.Mutex
+  status GitOpsStatus
+}
+
+func loadGitOps(creds *Config) {
+  config := creds.GitOps
+  if len(config.Branch) == 0 {
+    config.Branch = "main"
+  }
+  if len(config.Path) == 0 {
+    config.Path = "rules.yaml"
+  }
+  if len(config.Dir) == 0 {
+    config.Dir = "gitops"
+  }
+  gitops = &gitopsSyncer{
+    config:   config,
+    interval: durationOr(config.Interval, 5*time.Minute),
+    creds:    creds,
+    status:   GitOpsStatus{Repo: config.Repo, Branch: config.Branch},
+  }
+}
+
+func (g *gitopsSyncer) enabled() bool {
+  return len(g.config.Repo) > 0
+}
+
+func (g *gitopsSyncer) git(args ...string) (string, error) {
+  out, err := exec.Command("git", args...).CombinedOutput()
+  if err != nil {
+    return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+  }
+  return strings.TrimSpace(string(out)), nil
+}
+
+// bring the checkout up to date with the branch, returning its commit
+func (g *gitopsSyncer) pull() (string, error) {
+  dir := g.config.Dir
+  if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
+    if _, err := g.git("clone", "--depth=1", "--branch="+g.config.Branch, g.config.Repo, dir); err != nil {
+      return "", err
+    }
+  } else {
+    if _, err := g.git("-C", dir, "fetch", "--depth=1", "origin", g.config.Branch); err != nil {
+      return "", err
+    }
+    if _, err := g.git("-C", dir, "reset", "--hard", "FETCH_HEAD"); err != nil {
+      return "", err
+    }
+  }
+  return g.git("-C", dir, "rev-parse", "HEAD")
+}
+
+// the rules in the checkout, if they are all good
+func (g *gitopsSyncer) load() ([]*Rule, error) {
+  path := filepath.Join(g.config.Dir, g.config.Path)
+  contents, err := ioutil.ReadFile(path)
+  if err != nil {
+    return nil, err
+  }
+  var file gitopsRules
+  if err := goyaml.Unmarshal(contents, &file); err != nil {
+    return nil, fmt.Errorf("%s: %v", g.config.Path, err)
+  }
+
+  // the repo's templates go with the config's
+  creds := *g.creds
+  creds.RuleTemplates = map[string]interface{}{}
+  for name, t := range g.creds.RuleTemplates {
+    creds.RuleTemplates[name] = t
+  }
+  for name, t := range file.RuleTemplates {
+    creds.RuleTemplates[name] = t
+  }
+  rules, err := buildRules(file.Rules, &creds)
+  if err != nil {
+    return nil, err
+  }
+  for _, rule := range rules {
+    rule.dir = filepath.Dir(path)
+    for _, target := range allRuleTargets(rule) {
+      if _, ok := g.creds.Targets[target]; !ok {
+        return nil, fmt.Errorf("rule %s sends to unknown target %s", rule.Name, target)
+      }
+    }
+    for i := range rule.Tests {
+      if reason, _ := rule.Tests[i].run(rule, g.creds); len(reason) > 0 {
+        return nil, fmt.Errorf("rule %s test %s failed: %s", rule.Name, rule.Tests[i].Issue, reason)
+      }
+    }
+  }
+  return append(flagRules(g.creds), rules...), nil
+}
+
+func allRuleTargets(rule *Rule) []string {
+  targets := append([]string{}, rule.Targets...)
+  for _, kind := range rule.On {
+    targets = append(targets, kind...)
+  }
+  return targets
+}
+
+// pull and load the rules, returning nil if nothing changed
+func (g *gitopsSyncer) fetch() (string, []*Rule, error) {
+  now := time.Now()
+  g.mu.Lock()
+  g.status.LastAttempt = &now
+  current, failing := g.status.Commit, len(g.status.LastError) > 0
+  g.mu.Unlock()
+
+  commit, err := g.pull()
+  if err == nil && commit == current && !failing {
+    return commit, nil, nil
+  }
+  var rules []*Rule
+  if err == nil {
+    rules, err = g.load()
+  }
+  g.mu.Lock()
+  defer g.mu.Unlock()
+  if err != nil {
+    g.status.Failures++
+    g.status.LastError = err.Error()
+    return commit, nil, err
+  }
+  g.status.LastError = ""
+  return commit, rules, nil
+}
+
+func (g *gitopsSyncer) applied(commit string, rules int) {
+  now := time.Now()
+  g.mu.Lock()
+  defer g.mu.Unlock()
+  g.status.Commit, g.status.Rules, g.status.LastSync = commit, rules, &now
+}
+
+// the rules to start with, the repo's if they can be had, otherwise the
+// config's until a sync works
+func (g *gitopsSyncer) initial(rules []*Rule) []*Rule {
+  commit, synced, err := g.fetch()
+  if err != nil || synced == nil {
+    logger.Print("Error syncing rules from ", g.config.Repo, ", using the config's for now: ", err)
+    return rules
+  }
+  logger.Print("Using ", len(synced), " rules from ", g.config.Repo, " at ", commit)
+  g.applied(commit, len(synced))
+  return synced
+}
+
+func (g *gitopsSyncer) syncForever() {
+  for {
+    time.Sleep(g.interval)
+    commit, rules, err := g.fetch()
+    if err != nil {
+      logger.Print("Error syncing rules from ", g.config.Repo, ", keeping the current ones: ", err)
+      continue
+    }
+    if rules == nil {
+      continue
+    }
+    added, changed, removed := applyRules(rules)
+    g.applied(commit, len(rules))
+    logger.Print(fmt.Sprintf("Synced rules from %s at %s: %d added, %d changed, %d removed", g.config.Repo, commit, added, changed, removed))
+  }
+}
+
+func (g *gitopsSyncer) currentStatus() GitOpsStatus {
+  g.mu.Lock()
+  defer g.mu.Unlock()
+  return g.status
+}
+
+func (g *gitopsSyncer) writeMetrics(out *strings.Builder) {
+  if !g.enabled() {
+    return
+  }
+  status := g.currentStatus()
+  ok, last := 1, 0.0
+  if len(status.LastError) > 0 {
+    ok = 0
+  }
+  if status.LastSync != nil {
+    last = float64(status.LastSync.Unix())
+  }
+  out.WriteString("# HELP jira_tracker_gitops_ok Whether the last sync of the rules from git worked.\n")
+  out.WriteString("# TYPE jira_tracker_gitops_ok gauge\n")
+  fmt.Fprintf(out, "jira_tracker_gitops_ok %d\n", ok)
+  out.WriteString("# HELP jira_tracker_gitops_last_sync_timestamp_seconds When the rules last changed from git.\n")
+  out.WriteString("# TYPE jira_tracker_gitops_last_sync_timestamp_seconds gauge\n")
+  fmt.Fprintf(out, "jira_tracker_gitops_last_sync_timestamp_seconds %.0f\n", last)
+  out.WriteString("# HELP jira_tracker_gitops_failures_total Syncs of the rules from git that failed.\n")
+  out.WriteString("# TYPE jira_tracker_gitops_failures_total counter\n")
+  fmt.Fprintf(out, "jira_tracker_gitops_failures_total %d\n", status.Failures)
+}
+
+//   GET /gitops   how syncing the rules from git has gone
+func handleGitOps(w http.ResponseWriter, r *http.Request) {
+  if r.Method != "GET" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+  if !gitops.enabled() {
+    writeError(w, http.StatusNotFound, "rules aren't synced from git")
+    return
+  }
+  writeJSON(w, http.StatusOK, gitops.currentStatus())
+}
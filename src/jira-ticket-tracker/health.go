@@ -0,0 +1,203 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "net/http"
+  "os"
+  "sort"
+  "strings"
+  "time"
+)
+
+func init() {
+  commands["sinks"] = command{"sinks [--enable=TARGET]", sinksCommand}
+}
+
+// disable a target that has failed continuously for disable_after, alerting
+// the operator. its events go to its fallbacks while it's disabled, but one
+// delivery every probe is still tried, and after probes of them in a row
+// succeed it's enabled again. targets are never disabled without
+// disable_after. `sinks` and /sinks on the control api show every target's
+// health
+//
+//   sink_health:
+//     disable_after: 30m
+//     probe: 5m    # the default
+//     probes: 3    # the default
+type SinkHealthConfig struct {
+  DisableAfter string `yaml:"disable_after"`
+  Probe        string `yaml:"probe"`
+  Probes       int    `yaml:"probes"`
+
+  disableAfter time.Duration
+  probe        time.Duration
+  probes       int
+}
+
+var sinkHealthConfig = SinkHealthConfig{probe: 5 * time.Minute, probes: 3}
+
+func loadSinkHealth(creds *Config) {
+  sinkHealthConfig = creds.SinkHealth
+  sinkHealthConfig.disableAfter = durationOr(creds.SinkHealth.DisableAfter, 0)
+  sinkHealthConfig.probe = durationOr(creds.SinkHealth.Probe, 5*time.Minute)
+  sinkHealthConfig.probes = creds.SinkHealth.Probes
+  if sinkHealthConfig.probes <= 0 {
+    sinkHealthConfig.probes = 3
+  }
+}
+
+// SinkStatus is a target's health, for the sinks command
+type SinkStatus struct {
+  Target       string     `json:"target"`
+  Type         string     `json:"type"`
+  State        string     `json:"state"` // ok, failing, skipped or disabled
+  Sent         int        `json:"sent"`   // in the last hour
+  Failed       int        `json:"failed"` // in the last hour
+  ErrorRate    float64    `json:"error_rate"`
+  Failures     int        `json:"failures"` // in a row
+  FailingSince *time.Time `json:"failing_since,omitempty"`
+  LastSuccess  *time.Time `json:"last_success,omitempty"`
+  LastError    string     `json:"last_error,omitempty"`
+  DisabledAt   *time.Time `json:"disabled_at,omitempty"`
+}
+
+func (s *sink) status() SinkStatus {
+  h := &s.health
+  h.mu.Lock()
+  defer h.mu.Unlock()
+  status := SinkStatus{Target: s.name, Type: s.target.Type, State: "ok", Failures: h.failures}
+  for _, result := range h.results {
+    if time.Since(result.at) > sinkHealthWindow {
+      continue
+    }
+    if result.ok {
+      status.Sent++
+    } else {
+      status.Failed++
+    }
+  }
+  if total := status.Sent + status.Failed; total > 0 {
+    status.ErrorRate = float64(status.Failed) / float64(total)
+  }
+  if !h.lastSuccess.IsZero() {
+    at := h.lastSuccess
+    status.LastSuccess = &at
+  }
+  if h.failures > 0 {
+    since := h.failingSince
+    status.FailingSince, status.LastError, status.State = &since, h.lastError, "failing"
+    if h.failures >= sinkFailureLimit {
+      status.State = "skipped"
+    }
+  }
+  if h.disabled {
+    at := h.disabledAt
+    status.DisabledAt, status.State = &at, "disabled"
+  }
+  return status
+}
+
+func sinkStatuses() []SinkStatus {
+  statuses := []SinkStatus{}
+  for _, s := range sinks {
+    statuses = append(statuses, s.status())
+  }
+  sort.Slice(statuses, func(i, j int) bool { return statuses[i].Target < statuses[j].Target })
+  return statuses
+}
+
+// enable a disabled sink by hand
+func (s *sink) enable() bool {
+  s.health.mu.Lock()
+  defer s.health.mu.Unlock()
+  if !s.health.disabled {
+    return false
+  }
+  s.health.disabled, s.health.failures, s.health.probes = false, 0, 0
+  return true
+}
+
+// GET /sinks for every target's health, POST /sinks/TARGET/enable to enable
+// a disabled one
+func handleSinks(w http.ResponseWriter, r *http.Request) {
+  if r.URL.Path == "/sinks" {
+    if r.Method != "GET" {
+      writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+      return
+    }
+    writeJSON(w, http.StatusOK, sinkStatuses())
+    return
+  }
+  name := strings.TrimPrefix(r.URL.Path, "/sinks/")
+  if !strings.HasSuffix(name, "/enable") {
+    writeError(w, http.StatusNotFound, "not found")
+    return
+  }
+  if r.Method != "POST" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+  name = strings.TrimSuffix(name, "/enable")
+  s, ok := sinks[name]
+  if !ok {
+    writeError(w, http.StatusNotFound, "unknown target "+name)
+    return
+  }
+  if !s.enable() {
+    writeError(w, http.StatusConflict, name+" isn't disabled")
+    return
+  }
+  logger.Print("Enabled target ", name, " by hand")
+  writeJSON(w, http.StatusOK, s.status())
+}
+
+func sinksCommand(args []string) {
+  flags := flag.NewFlagSet("sinks", flag.ExitOnError)
+  enable := flags.String("enable", "", "Enable this disabled target instead of listing them")
+  flags.Parse(args)
+  if flags.NArg() > 0 {
+    usageExit(commands["sinks"].usage)
+  }
+  if len(*enable) > 0 {
+    if err := callAPI("POST", "/sinks/"+*enable+"/enable", nil, nil); err != nil {
+      logger.Print("Error enabling ", *enable, ": ", err)
+      os.Exit(1)
+    }
+    fmt.Println("Enabled", *enable)
+    return
+  }
+  var statuses []SinkStatus
+  if err := callAPI("GET", "/sinks", nil, &statuses); err != nil {
+    logger.Print("Error fetching the targets' health: ", err)
+    os.Exit(1)
+  }
+  for _, s := range statuses {
+    health := strings.ToUpper(s.State)
+    if s.State == "ok" {
+      health = "ok"
+    }
+    if s.FailingSince != nil {
+      health += fmt.Sprintf(" for %s x%d: %s", humanize(time.Since(*s.FailingSince)), s.Failures, s.LastError)
+    }
+    fmt.Printf("%s\t%s\t%d sent, %d failed in the last hour (%.1f%%)\t%s\n", s.Target, s.Type, s.Sent, s.Failed, s.ErrorRate*100, health)
+  }
+}
+
+func writeSinkHealthMetrics(out *strings.Builder) {
+  statuses := sinkStatuses()
+  out.WriteString("# HELP jira_tracker_sink_disabled Whether a target has been disabled for failing too long.\n")
+  out.WriteString("# TYPE jira_tracker_sink_disabled gauge\n")
+  for _, s := range statuses {
+    disabled := 0
+    if s.State == "disabled" {
+      disabled = 1
+    }
+    fmt.Fprintf(out, "jira_tracker_sink_disabled{target=%q} %d\n", s.Target, disabled)
+  }
+  out.WriteString("# HELP jira_tracker_sink_error_rate The share of a target's deliveries that failed in the last hour.\n")
+  out.WriteString("# TYPE jira_tracker_sink_error_rate gauge\n")
+  for _, s := range statuses {
+    fmt.Fprintf(out, "jira_tracker_sink_error_rate{target=%q} %g\n", s.Target, s.ErrorRate)
+  }
+}
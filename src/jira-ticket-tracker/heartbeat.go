@@ -0,0 +1,82 @@
+package main
+
+import (
+  "fmt"
+  "net/http"
+  "sync"
+  "time"
+)
+
+// a dead man's switch, configured under `heartbeat`. the url (e.g. a
+// healthchecks.io check or a dead man's snitch) is pinged after successful
+// polls, so if the tracker silently stops polling the pings stop and the
+// service raises the alarm. pings are at most one per `every`, however many
+// rules there are
+//
+//   heartbeat:
+//     url: https://hc-ping.com/<uuid>
+//     fail_url: https://hc-ping.com/<uuid>/fail   # optional, on failed polls
+//     every: 1m
+type HeartbeatConfig struct {
+  Url     string `yaml:"url"`
+  FailUrl string `yaml:"fail_url"`
+  Every   string `yaml:"every"`
+}
+
+var heartbeat = &heartbeatPinger{}
+
+type heartbeatPinger struct {
+  config HeartbeatConfig
+  every  time.Duration
+  client *http.Client
+
+  mu   sync.Mutex
+  last time.Time
+}
+
+func loadHeartbeat(creds *Config) {
+  heartbeat = &heartbeatPinger{
+    config: creds.Heartbeat,
+    every:  durationOr(creds.Heartbeat.Every, time.Minute),
+    client: &http.Client{Timeout: 10 * time.Second},
+  }
+}
+
+// ping for a finished poll, in the background so a slow heartbeat service
+// can't hold up polling
+func (h *heartbeatPinger) beat(pollErr error) {
+  url := h.config.Url
+  if pollErr != nil {
+    url = h.config.FailUrl
+  } else {
+    h.mu.Lock()
+    due := time.Since(h.last) >= h.every
+    if due {
+      h.last = time.Now()
+    }
+    h.mu.Unlock()
+    if !due {
+      return
+    }
+  }
+  if len(url) == 0 {
+    return
+  }
+  go func() {
+    if err := h.ping(url); err != nil {
+      logger.Print("Error pinging the heartbeat: ", err)
+    }
+  }()
+}
+
+func (h *heartbeatPinger) ping(url string) error {
+  resp, err := h.client.Get(url)
+  if err != nil {
+    return err
+  }
+  drainAndClose(resp.Body)
+  if resp.StatusCode >= 300 {
+    return fmt.Errorf("%s returned %s", url, resp.Status)
+  }
+  return nil
+}
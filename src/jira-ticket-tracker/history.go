@@ -0,0 +1,434 @@
+package main
+
+import (
+  "bufio"
+  "encoding/json"
+  "flag"
+  "fmt"
+  "net/http"
+  "os"
+  "strings"
+  "sync"
+  "time"
+)
+
+// every event the tracker emits can be appended to a history file, one json
+// record per line, so it can be replayed later. configured under `history`
+//
+//   history:
+//     path: ./history.jsonl
+//     retention: 90d  # optional, older records are compacted away daily
+//
+// with an archive, see ArchiveConfig, they're uploaded first
+type HistoryConfig struct {
+  Path      string        `yaml:"path"`
+  Retention string        `yaml:"retention"`
+  Archive   ArchiveConfig `yaml:"archive"`
+}
+
+const historyCompactInterval = 24 * time.Hour
+
+// a HistoryRecord is an event as written to the history file. the issue is
+// kept as its key and raw fields so it parses back like a fetched one
+type HistoryRecord struct {
+  Time    time.Time       `json:"time"`
+  Kind    string          `json:"kind"`
+  Rule    string          `json:"rule,omitempty"`
+  Key     string          `json:"key"`
+  Detail  string          `json:"detail,omitempty"`
+  Targets []string        `json:"targets"`
+  Issue   json.RawMessage `json:"issue"`
+}
+
+type historyFile struct {
+  mu        sync.Mutex
+  path      string
+  retention time.Duration
+}
+
+// the history, opened at startup in main. nil if it isn't configured
+var history *historyFile
+
+func openHistory(creds *Config) *historyFile {
+  if len(creds.History.Path) == 0 {
+    return nil
+  }
+  return &historyFile{path: creds.History.Path, retention: durationOr(creds.History.Retention, 0)}
+}
+
+func historyRecord(event *Event, at time.Time) (HistoryRecord, error) {
+  issue, err := json.Marshal(map[string]interface{}{"key": event.Issue.Key, "fields": event.Fields})
+  return HistoryRecord{
+    Time:    at,
+    Kind:    event.Kind,
+    Rule:    event.Rule,
+    Key:     event.Issue.Key,
+    Detail:  event.Detail,
+    Targets: event.Targets,
+    Issue:   issue,
+  }, err
+}
+
+// append records to the file
+func (h *historyFile) Append(records []HistoryRecord) error {
+  h.mu.Lock()
+  defer h.mu.Unlock()
+
+  file, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+  if err != nil {
+    return err
+  }
+  defer file.Close()
+
+  w := bufio.NewWriter(file)
+  for _, record := range records {
+    line, err := json.Marshal(record)
+    if err != nil {
+      logger.Print("Error encoding history for ", record.Key, ": ", err)
+      continue
+    }
+    w.Write(sealLine(line))
+    w.WriteByte('\n')
+  }
+  return w.Flush()
+}
+
+// call fn with every record in the file, oldest first, stopping early if it
+// returns false
+func (h *historyFile) Each(fn func(record HistoryRecord) bool) error {
+  h.mu.Lock()
+  defer h.mu.Unlock()
+
+  file, err := os.Open(h.path)
+  if os.IsNotExist(err) {
+    return nil
+  } else if err != nil {
+    return err
+  }
+  defer file.Close()
+
+  scanner := bufio.NewScanner(file)
+  scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+  for line := 1; scanner.Scan(); line++ {
+    var record HistoryRecord
+    plain, err := unsealLine(scanner.Bytes())
+    if err != nil {
+      logger.Print("Skipping line ", line, " of the history: ", err)
+      continue
+    }
+    if err := json.Unmarshal(plain, &record); err != nil {
+      logger.Print("Skipping line ", line, " of the history: ", err)
+      continue
+    }
+    if !fn(record) {
+      break
+    }
+  }
+  return scanner.Err()
+}
+
+// rewrite the file without the records from before a time, returning how
+// many were dropped. lines that don't parse are kept. with an archive the
+// dropped records are uploaded first, and kept if that fails
+func (h *historyFile) Compact(before time.Time) (int, error) {
+  h.mu.Lock()
+  defer h.mu.Unlock()
+
+  file, err := os.Open(h.path)
+  if os.IsNotExist(err) {
+    return 0, nil
+  } else if err != nil {
+    return 0, err
+  }
+  defer file.Close()
+
+  tmp := h.path + ".tmp"
+  out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+  if err != nil {
+    return 0, err
+  }
+  w := bufio.NewWriter(out)
+  dropped := []HistoryRecord{}
+  scanner := bufio.NewScanner(file)
+  scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+  for scanner.Scan() {
+    var record HistoryRecord
+    plain, err := unsealLine(scanner.Bytes())
+    if err == nil && json.Unmarshal(plain, &record) == nil && record.Time.Before(before) {
+      if archive != nil {
+        dropped = append(dropped, record)
+      } else {
+        dropped = append(dropped, HistoryRecord{})
+      }
+      continue
+    }
+    w.Write(scanner.Bytes())
+    w.WriteByte('\n')
+  }
+  if err := scanner.Err(); err != nil {
+    out.Close()
+    os.Remove(tmp)
+    return 0, err
+  }
+  if err := w.Flush(); err != nil {
+    out.Close()
+    os.Remove(tmp)
+    return 0, err
+  }
+  if err := out.Close(); err != nil {
+    os.Remove(tmp)
+    return 0, err
+  }
+  if len(dropped) == 0 {
+    os.Remove(tmp)
+    return 0, nil
+  }
+  if archive != nil {
+    if err := archive.Upload(dropped); err != nil {
+      os.Remove(tmp)
+      return 0, err
+    }
+  }
+  return len(dropped), os.Rename(tmp, h.path)
+}
+
+// rewrite the file with fn's replacements for the records it returns true
+// for, dropping them if they're nil. lines that don't parse are kept
+func (h *historyFile) Rewrite(fn func(HistoryRecord) (*HistoryRecord, bool)) (int, error) {
+  h.mu.Lock()
+  defer h.mu.Unlock()
+
+  file, err := os.Open(h.path)
+  if os.IsNotExist(err) {
+    return 0, nil
+  } else if err != nil {
+    return 0, err
+  }
+  defer file.Close()
+
+  tmp := h.path + ".tmp"
+  out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+  if err != nil {
+    return 0, err
+  }
+  w := bufio.NewWriter(out)
+  changed := 0
+  scanner := bufio.NewScanner(file)
+  scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+  for scanner.Scan() {
+    var record HistoryRecord
+    plain, err := unsealLine(scanner.Bytes())
+    if err == nil && json.Unmarshal(plain, &record) == nil {
+      if replaced, ok := fn(record); ok {
+        changed++
+        if replaced == nil {
+          continue
+        }
+        line, err := json.Marshal(replaced)
+        if err != nil {
+          out.Close()
+          os.Remove(tmp)
+          return 0, err
+        }
+        w.Write(sealLine(line))
+        w.WriteByte('\n')
+        continue
+      }
+    }
+    w.Write(scanner.Bytes())
+    w.WriteByte('\n')
+  }
+  if err := scanner.Err(); err != nil {
+    out.Close()
+    os.Remove(tmp)
+    return 0, err
+  }
+  if err := w.Flush(); err != nil {
+    out.Close()
+    os.Remove(tmp)
+    return 0, err
+  }
+  if err := out.Close(); err != nil {
+    os.Remove(tmp)
+    return 0, err
+  }
+  if changed == 0 {
+    os.Remove(tmp)
+    return 0, nil
+  }
+  return changed, os.Rename(tmp, h.path)
+}
+
+// compact the history now and then every day, or on the archive's schedule,
+// if it has a retention
+func (h *historyFile) compactForever() {
+  for {
+    dropped, err := h.Compact(time.Now().Add(-h.retention))
+    if err != nil {
+      logger.Print("Error compacting history: ", err)
+    } else if dropped > 0 {
+      logger.Print("Dropped ", dropped, " history records older than ", h.retention)
+    }
+    if archive != nil {
+      time.Sleep(archive.schedule)
+    } else {
+      time.Sleep(historyCompactInterval)
+    }
+  }
+}
+
+// the event a record was written from
+func (r *HistoryRecord) event() (*Event, error) {
+  issue, fields, err := parseIssue(r.Issue)
+  if err != nil {
+    return nil, err
+  }
+  event := newEvent(r.Kind, issue, fields)
+  event.Rule = r.Rule
+  event.Detail = r.Detail
+  event.Targets = r.Targets
+  return event, nil
+}
+
+func init() {
+  commands["replay"] = command{"replay --from=TIME [--to=TIME] [--rule=NAME] [--kind=KIND] [--target=NAME,..] [--dry-run]", replayCommand}
+}
+
+// a time as an RFC3339 timestamp or a yyyy-mm-dd date
+func parseReplayTime(s string) (time.Time, error) {
+  if t, err := time.Parse(time.RFC3339, s); err == nil {
+    return t, nil
+  }
+  return time.ParseInLocation("2006-01-02", s, time.Local)
+}
+
+// re-send past events, e.g. to recover from a target's outage with the
+// original targets, or to backfill a new target with --target
+func replayCommand(args []string) {
+  flags := flag.NewFlagSet("replay", flag.ExitOnError)
+  from := flags.String("from", "", "Replay events recorded from this time")
+  to := flags.String("to", "", "Until this time (default now)")
+  ruleName := flags.String("rule", "", "Only events from this rule")
+  kind := flags.String("kind", "", "Only events of this kind")
+  targetNames := flags.String("target", "", "Send to these targets instead of the original ones")
+  dryRun := flags.Bool("dry-run", false, "List the events instead of sending them")
+  flags.Parse(args)
+  if len(*from) == 0 {
+    usageExit(commands["replay"].usage)
+  }
+  since, err := parseReplayTime(*from)
+  if err != nil {
+    logger.Print("Invalid --from: ", err)
+    os.Exit(1)
+  }
+  until := time.Now()
+  if len(*to) > 0 {
+    if until, err = parseReplayTime(*to); err != nil {
+      logger.Print("Invalid --to: ", err)
+      os.Exit(1)
+    }
+  }
+  var targets []string
+  if len(*targetNames) > 0 {
+    targets = splitTags(*targetNames)
+  }
+
+  setup()
+  events, err := replayEvents(since, until, *ruleName, *kind, targets)
+  if err != nil {
+    logger.Print("Error reading history: ", err)
+    os.Exit(1)
+  }
+
+  for _, event := range events {
+    fmt.Printf("%s\t%s\t%s\n", event.Kind, event.Issue.Key, strings.Join(event.Targets, ", "))
+  }
+  if *dryRun {
+    return
+  }
+  deliver(events)
+  logger.Print("Replayed ", len(events), " events")
+}
+
+// the recorded events between two times, optionally only a rule's or a
+// kind's, and sent to other targets if there are any
+func replayEvents(since, until time.Time, ruleName, kind string, targets []string) ([]*Event, error) {
+  events := []*Event{}
+  err := store.EachHistory(since, func(r HistoryRecord) bool {
+    if r.Time.After(until) {
+      return false
+    }
+    if (len(ruleName) > 0 && r.Rule != ruleName) || (len(kind) > 0 && r.Kind != kind) {
+      return true
+    }
+    event, err := r.event()
+    if err != nil {
+      logger.Print("Skipping ", r.Key, " from ", r.Time, ": ", err)
+      return true
+    }
+    if targets != nil {
+      event.Targets = targets
+    }
+    events = append(events, event)
+    return true
+  })
+  return events, err
+}
+
+type replayRequest struct {
+  From    time.Time  `json:"from"`
+  To      *time.Time `json:"to,omitempty"`
+  Rule    string     `json:"rule,omitempty"`
+  Kind    string     `json:"kind,omitempty"`
+  Targets []string   `json:"targets,omitempty"` // instead of the original ones
+  DryRun  bool       `json:"dry_run,omitempty"`
+}
+
+// ReplayedEvent is an event a replay sent, or would have
+type ReplayedEvent struct {
+  Kind    string   `json:"kind"`
+  Key     string   `json:"key"`
+  Targets []string `json:"targets"`
+}
+
+//   POST /replay {"from":..,"to":..,"rule":..,"kind":..,"targets":[..],"dry_run":..}
+//               re-send past events, returning what was sent
+func handleReplay(w http.ResponseWriter, r *http.Request) {
+  if r.Method != "POST" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+  if !operatorAuthorized(r) {
+    writeError(w, http.StatusUnauthorized, "replaying needs the operator's token")
+    return
+  }
+  var req replayRequest
+  if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.From.IsZero() {
+    writeError(w, http.StatusBadRequest, "from is required")
+    return
+  }
+  until := time.Now()
+  if req.To != nil {
+    until = *req.To
+  }
+  for _, target := range req.Targets {
+    if _, ok := sinks[target]; !ok {
+      writeError(w, http.StatusBadRequest, "unknown target "+target)
+      return
+    }
+  }
+  events, err := replayEvents(req.From, until, req.Rule, req.Kind, req.Targets)
+  if err != nil {
+    writeError(w, http.StatusInternalServerError, err.Error())
+    return
+  }
+  replayed := []ReplayedEvent{}
+  for _, event := range events {
+    replayed = append(replayed, ReplayedEvent{event.Kind, event.Issue.Key, event.Targets})
+  }
+  if !req.DryRun {
+    deliver(events)
+    logger.Print("Replayed ", len(events), " events")
+  }
+  writeJSON(w, http.StatusOK, replayed)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+  "crypto/tls"
+  "io"
+  "io/ioutil"
+  "net"
+  "net/http"
+  "os"
+  "time"
+)
+
+// tuning for the connections to jira, configured under `http`. everything
+// is optional:
+//
+//   http:
+//     timeout: 30s             # a whole request, including reading the body
+//     keep_alive: 30s          # tcp keep-alive probes
+//     idle_conn_timeout: 90s   # how long unused connections stay pooled
+//     max_idle_conns: 4        # pooled connections kept open to jira
+//     max_conns: 0             # limit on connections to jira, 0 is none
+//     disable_http2: false
+//     disable_compression: false  # gzip is requested by default
+type HTTPConfig struct {
+  Timeout            string `yaml:"timeout"`
+  KeepAlive          string `yaml:"keep_alive"`
+  IdleConnTimeout    string `yaml:"idle_conn_timeout"`
+  MaxIdleConns       int    `yaml:"max_idle_conns"`
+  MaxConns           int    `yaml:"max_conns"`
+  DisableHTTP2       bool   `yaml:"disable_http2"`
+  DisableCompression bool   `yaml:"disable_compression"`
+}
+
+func durationOr(s string, fallback time.Duration) time.Duration {
+  if len(s) == 0 {
+    return fallback
+  }
+  d, err := parseDuration(s)
+  if err != nil {
+    logger.Print("Invalid duration ", s, ": ", err)
+    os.Exit(1)
+  }
+  return d
+}
+
+// one client for every call to jira, so polls reuse pooled keep-alive
+// connections instead of doing a tls handshake each time
+func newJiraClient(c HTTPConfig) *http.Client {
+  idle := c.MaxIdleConns
+  if idle == 0 {
+    idle = 4
+  }
+  dialer := &net.Dialer{
+    Timeout:   10 * time.Second,
+    KeepAlive: durationOr(c.KeepAlive, 30*time.Second),
+  }
+  transport := &http.Transport{
+    Proxy:                 http.ProxyFromEnvironment,
+    DialContext:           dialer.DialContext,
+    ForceAttemptHTTP2:     !c.DisableHTTP2,
+    MaxIdleConns:          idle,
+    MaxIdleConnsPerHost:   idle,
+    MaxConnsPerHost:       c.MaxConns,
+    IdleConnTimeout:       durationOr(c.IdleConnTimeout, 90*time.Second),
+    TLSHandshakeTimeout:   10 * time.Second,
+    ExpectContinueTimeout: time.Second,
+    DisableCompression:    c.DisableCompression,
+    TLSClientConfig:       fipsTLS(nil),
+  }
+  if c.DisableHTTP2 {
+    // a non-nil empty map is how net/http is told not to upgrade
+    transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+  }
+  return &http.Client{
+    Transport: transport,
+    Timeout:   durationOr(c.Timeout, 60*time.Second),
+  }
+}
+
+// read what's left of a body before closing it, a connection only goes back
+// into the pool once its response has been read to the end
+func drainAndClose(body io.ReadCloser) {
+  io.Copy(ioutil.Discard, io.LimitReader(body, 1<<20))
+  body.Close()
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+  "fmt"
+  "net/http"
+  "strings"
+  "sync"
+  "time"
+)
+
+// ics calendars of the due dates and sla deadlines of the open issues rules
+// match, served on the control api at /ical/RULE.ics for any calendar
+// client to subscribe to. only the rules listed get one, "*" for all
+//
+//   ical:
+//     rules: [payments]
+//     sla_fields: ["Time to resolution", "Time to first response"]
+//     limit: 200   # open issues looked at, 200 by default
+//
+// a due date is an all day event, an sla deadline one at the time it
+// breaches, from the service desk sla fields' ongoing cycles. paused slas
+// and ones already breached are left out. calendars are cached for five
+// minutes
+type ICalConfig struct {
+  Rules     []string `yaml:"rules"`
+  SLAFields []string `yaml:"sla_fields"`
+  Limit     int      `yaml:"limit"`
+}
+
+const icalCacheTTL = 5 * time.Minute
+
+var icalFeeds = struct {
+  sync.Mutex
+  creds  *Config
+  cached map[string]cachedFeed // rule -> its calendar
+}{cached: map[string]cachedFeed{}}
+
+func loadICal(creds *Config) {
+  icalFeeds.Lock()
+  defer icalFeeds.Unlock()
+  icalFeeds.creds = creds
+  if creds.ICal.Limit <= 0 {
+    creds.ICal.Limit = 200
+  }
+}
+
+func (c *ICalConfig) serves(rule string) bool {
+  return contains(c.Rules, "*") || contains(c.Rules, rule)
+}
+
+// escape text for a property value, see rfc 5545 3.3.11
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`, "\r", "")
+
+// a content line folded to 75 octets, without splitting a utf-8 sequence
+func icalLine(out *strings.Builder, line string) {
+  for len(line) > 75 {
+    cut := 75
+    for cut > 0 && line[cut]&0xc0 == 0x80 {
+      cut--
+    }
+    out.WriteString(line[:cut] + "\r\n ")
+    line = line[cut:]
+  }
+  out.WriteString(line + "\r\n")
+}
+
+// when the sla's ongoing cycle breaches, false if it's paused, breached
+// already or not running
+func slaBreachTime(value interface{}) (time.Time, bool) {
+  sla, ok := value.(map[string]interface{})
+  if !ok {
+    return time.Time{}, false
+  }
+  cycle, ok := sla["ongoingCycle"].(map[string]interface{})
+  if !ok || cycle["paused"] == true || cycle["breached"] == true {
+    return time.Time{}, false
+  }
+  breach, ok := cycle["breachTime"].(map[string]interface{})
+  if !ok {
+    return time.Time{}, false
+  }
+  millis, ok := breach["epochMillis"].(float64)
+  if !ok {
+    return time.Time{}, false
+  }
+  return time.Unix(0, int64(millis)*int64(time.Millisecond)), true
+}
+
+// the rule's open issues' deadlines as a calendar
+func ruleCalendar(rule *Rule, creds *Config) ([]byte, error) {
+  jql := "resolution = EMPTY"
+  if q := rule.jql(); len(q) > 0 {
+    jql = "(" + q + ") AND " + jql
+  }
+  issues, fields, err := searchIssues(jql+" ORDER BY key", creds.ICal.Limit, creds)
+  if err != nil {
+    return nil, err
+  }
+  now := time.Now().UTC().Format("20060102T150405Z")
+  var out strings.Builder
+  for _, line := range []string{
+    "BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:-//jira-ticket-tracker//deadlines//EN",
+    "CALSCALE:GREGORIAN", "X-WR-CALNAME:" + icalEscaper.Replace("Deadlines of "+rule.Name),
+  } {
+    icalLine(&out, line)
+  }
+  event := func(uid, summary, url string, start []string) {
+    icalLine(&out, "BEGIN:VEVENT")
+    icalLine(&out, "UID:"+uid)
+    icalLine(&out, "DTSTAMP:"+now)
+    for _, line := range start {
+      icalLine(&out, line)
+    }
+    icalLine(&out, "SUMMARY:"+icalEscaper.Replace(summary))
+    icalLine(&out, "URL:"+url)
+    icalLine(&out, "END:VEVENT")
+  }
+  for i, issue := range issues {
+    if !conditionsPassed(rule.evaluate(issue, fields[i], creds)) {
+      continue
+    }
+    title := issue.Key + " " + fieldString(fields[i], "summary")
+    browse := creds.browseUrl(issue.Key)
+    if due, err := time.Parse("2006-01-02", fieldString(fields[i], "duedate")); err == nil {
+      event(issue.Key+"-due@jira-ticket-tracker", "Due: "+title, browse, []string{
+        "DTSTART;VALUE=DATE:" + due.Format("20060102"),
+        "DTEND;VALUE=DATE:" + due.AddDate(0, 0, 1).Format("20060102"),
+      })
+    }
+    for _, name := range creds.ICal.SLAFields {
+      breach, ok := slaBreachTime(fields[i][customFields.id(name, creds)])
+      if !ok {
+        continue
+      }
+      at := breach.UTC().Format("20060102T150405Z")
+      uid := fmt.Sprintf("%s-%s@jira-ticket-tracker", issue.Key, strings.ToLower(strings.Replace(name, " ", "-", -1)))
+      event(uid, name+": "+title, browse, []string{"DTSTART:" + at, "DTEND:" + at})
+    }
+  }
+  icalLine(&out, "END:VCALENDAR")
+  return []byte(out.String()), nil
+}
+
+func handleICal(w http.ResponseWriter, r *http.Request) {
+  if r.Method != "GET" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+  name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/ical/"), ".ics")
+  icalFeeds.Lock()
+  creds := icalFeeds.creds
+  icalFeeds.Unlock()
+  if creds == nil || !creds.ICal.serves(name) {
+    writeError(w, http.StatusNotFound, "no calendar for "+name)
+    return
+  }
+  rule := findRule(configuredPollerRules(), name)
+  if rule == nil {
+    writeError(w, http.StatusNotFound, "unknown rule "+name)
+    return
+  }
+
+  icalFeeds.Lock()
+  cached, ok := icalFeeds.cached[name]
+  icalFeeds.Unlock()
+  if !ok || time.Since(cached.fetched) > icalCacheTTL {
+    body, err := ruleCalendar(rule, creds)
+    if err != nil {
+      writeError(w, http.StatusBadGateway, "searching jira: "+err.Error())
+      return
+    }
+    cached = cachedFeed{body, time.Now()}
+    icalFeeds.Lock()
+    icalFeeds.cached[name] = cached
+    icalFeeds.Unlock()
+  }
+  w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+  w.Write(cached.body)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+  "bufio"
+  "crypto/tls"
+  "fmt"
+  "io"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// just enough of an imap client to read new mail from one mailbox: login,
+// select, search unseen, fetch whole messages and mark them seen
+type imapClient struct {
+  conn   *tls.Conn
+  reader *bufio.Reader
+  tag    int
+}
+
+func dialIMAP(server string) (*imapClient, error) {
+  raw, err := dialEgress(server, 30*time.Second)
+  if err != nil {
+    return nil, err
+  }
+  conn := tls.Client(raw, fipsTLS(&tls.Config{ServerName: strings.Split(server, ":")[0]}))
+  conn.SetDeadline(time.Now().Add(30 * time.Second))
+  if err := conn.Handshake(); err != nil {
+    raw.Close()
+    return nil, err
+  }
+  c := &imapClient{conn: conn, reader: bufio.NewReader(conn)}
+  conn.SetDeadline(time.Now().Add(time.Minute))
+  if _, err := c.reader.ReadString('\n'); err != nil { // the greeting
+    conn.Close()
+    return nil, err
+  }
+  return c, nil
+}
+
+func imapQuote(s string) string {
+  return `"` + strings.Replace(strings.Replace(s, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+}
+
+// an untagged response line along with any literals sent in it
+type imapLine struct {
+  text     string
+  literals [][]byte
+}
+
+// send a command and read responses up to its tagged completion
+func (c *imapClient) command(format string, args ...interface{}) ([]imapLine, error) {
+  c.tag++
+  tag := fmt.Sprintf("a%d", c.tag)
+  c.conn.SetDeadline(time.Now().Add(time.Minute))
+  if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, fmt.Sprintf(format, args...)); err != nil {
+    return nil, err
+  }
+
+  lines := []imapLine{}
+  for {
+    line, err := c.readLine()
+    if err != nil {
+      return nil, err
+    }
+    if strings.HasPrefix(line.text, tag+" ") {
+      status := strings.TrimPrefix(line.text, tag+" ")
+      if !strings.HasPrefix(status, "OK") {
+        return nil, fmt.Errorf("imap: %s", status)
+      }
+      return lines, nil
+    }
+    lines = append(lines, line)
+  }
+}
+
+// read a response line, following {n} literals into the rest of the line
+func (c *imapClient) readLine() (imapLine, error) {
+  var line imapLine
+  for {
+    part, err := c.reader.ReadString('\n')
+    if err != nil {
+      return line, err
+    }
+    part = strings.TrimRight(part, "\r\n")
+    line.text += part
+    if !strings.HasSuffix(part, "}") {
+      return line, nil
+    }
+    i := strings.LastIndex(part, "{")
+    if i < 0 {
+      return line, nil
+    }
+    size, err := strconv.Atoi(part[i+1 : len(part)-1])
+    if err != nil {
+      return line, nil
+    }
+    literal := make([]byte, size)
+    if _, err := io.ReadFull(c.reader, literal); err != nil {
+      return line, err
+    }
+    line.literals = append(line.literals, literal)
+  }
+}
+
+func (c *imapClient) login(user, password string) error {
+  _, err := c.command("LOGIN %s %s", imapQuote(user), imapQuote(password))
+  return err
+}
+
+func (c *imapClient) selectMailbox(mailbox string) error {
+  _, err := c.command("SELECT %s", imapQuote(mailbox))
+  return err
+}
+
+// the uids of the unseen messages
+func (c *imapClient) unseen() ([]string, error) {
+  lines, err := c.command("UID SEARCH UNSEEN")
+  if err != nil {
+    return nil, err
+  }
+  uids := []string{}
+  for _, line := range lines {
+    if strings.HasPrefix(line.text, "* SEARCH") {
+      uids = append(uids, strings.Fields(strings.TrimPrefix(line.text, "* SEARCH"))...)
+    }
+  }
+  return uids, nil
+}
+
+// the raw message, without marking it seen
+func (c *imapClient) fetch(uid string) ([]byte, error) {
+  lines, err := c.command("UID FETCH %s (BODY.PEEK[])", uid)
+  if err != nil {
+    return nil, err
+  }
+  for _, line := range lines {
+    if strings.Contains(line.text, "FETCH") && len(line.literals) > 0 {
+      return line.literals[0], nil
+    }
+  }
+  return nil, fmt.Errorf("imap: no message %s", uid)
+}
+
+func (c *imapClient) markSeen(uid string) error {
+  _, err := c.command(`UID STORE %s +FLAGS (\Seen)`, uid)
+  return err
+}
+
+func (c *imapClient) close() {
+  c.command("LOGOUT")
+  c.conn.Close()
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+  "encoding/base64"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "net/url"
+  "strings"
+  "sync"
+  "time"
+)
+
+// on jira cloud, comments and new issues can be made as another user, e.g.
+// a service desk request raised as the customer who emailed it in. that
+// takes a connect app installed with the act_as_user scope, whose
+// installation gives the client key, shared secret and oauth client id.
+// the tracker signs a jwt as the app to get a short-lived token for the
+// user from atlassian's authorization server
+//
+//   impersonation:
+//     client_key: ...       # the app installation's clientKey
+//     shared_secret: ...    # and sharedSecret
+//     oauth_client_id: ...  # and oauthClientId
+//     base_url: https://acme.atlassian.net
+//
+// then a jira-comment target's on_behalf_of is who to comment as: reporter,
+// assignee, or an account id, and an intake rule's on_behalf_of raises its
+// issues as the sender. when there's no such user, or no token for them,
+// the tracker's own account is used
+type ImpersonationConfig struct {
+  ClientKey     string `yaml:"client_key"`
+  SharedSecret  string `yaml:"shared_secret"`
+  OAuthClientId string `yaml:"oauth_client_id"`
+  BaseUrl       string `yaml:"base_url"`
+}
+
+const impersonationTokenUrl = "https://oauth-2-authorization-server.services.atlassian.com/oauth2/token"
+
+var impersonationTokens = struct {
+  sync.Mutex
+  tokens map[string]impersonationToken // account id -> its token
+}{tokens: map[string]impersonationToken{}}
+
+type impersonationToken struct {
+  token   string
+  expires time.Time
+}
+
+func (c *ImpersonationConfig) enabled() bool {
+  return len(c.OAuthClientId) > 0 && len(c.SharedSecret) > 0
+}
+
+// the credentials to act as the user with, the given ones if impersonation
+// isn't configured or the user can't be acted as
+func (c *Config) actingAs(accountId string) *Config {
+  if !c.Impersonation.enabled() || len(accountId) == 0 {
+    return c
+  }
+  token, err := impersonationTokenFor(&c.Impersonation, accountId)
+  if err != nil {
+    logger.Print("Error acting as ", accountId, ", using the tracker's account: ", err)
+    return c
+  }
+  copied := *c
+  copied.bearer = token
+  return &copied
+}
+
+func impersonationTokenFor(config *ImpersonationConfig, accountId string) (string, error) {
+  impersonationTokens.Lock()
+  cached, ok := impersonationTokens.tokens[accountId]
+  impersonationTokens.Unlock()
+  if ok && time.Until(cached.expires) > time.Minute {
+    return cached.token, nil
+  }
+
+  now := time.Now()
+  assertion := signJWT(config.SharedSecret, map[string]interface{}{
+    "iss": "urn:atlassian:connect:clientid:" + config.OAuthClientId,
+    "sub": "urn:atlassian:connect:useraccountid:" + accountId,
+    "tnt": strings.TrimSuffix(config.BaseUrl, "/"),
+    "aud": "https://oauth-2-authorization-server.services.atlassian.com",
+    "iat": now.Unix(),
+    "exp": now.Add(time.Minute).Unix(),
+  })
+  form := url.Values{
+    "grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+    "assertion":  {assertion},
+    "scope":      {"READ WRITE"},
+  }
+  resp, err := jiraClient.PostForm(impersonationTokenUrl, form)
+  if err != nil {
+    return "", err
+  }
+  defer resp.Body.Close()
+  contents, _ := ioutil.ReadAll(resp.Body)
+  if resp.StatusCode != http.StatusOK {
+    return "", fmt.Errorf("the authorization server returned %s: %s", resp.Status, strings.TrimSpace(string(contents)))
+  }
+  var result struct {
+    AccessToken string `json:"access_token"`
+    ExpiresIn   int    `json:"expires_in"`
+  }
+  if err := json.Unmarshal(contents, &result); err != nil {
+    return "", err
+  }
+  impersonationTokens.Lock()
+  impersonationTokens.tokens[accountId] = impersonationToken{result.AccessToken, now.Add(time.Duration(result.ExpiresIn) * time.Second)}
+  impersonationTokens.Unlock()
+  return result.AccessToken, nil
+}
+
+// a jwt signed with hs256
+func signJWT(secret string, claims map[string]interface{}) string {
+  header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+  payload, _ := json.Marshal(claims)
+  unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+  return unsigned + "." + base64.RawURLEncoding.EncodeToString(hmacSHA256([]byte(secret), unsigned))
+}
+
+// the account id to act as for an event: reporter, assignee or an account
+// id. empty if the field has no user
+func onBehalfOf(who string, event *Event) string {
+  switch who {
+  case "reporter", "assignee":
+    return fieldString(event.Fields, who+".accountId")
+  }
+  return who
+}
+
+// the account id of the user with an email address, empty if there's none
+// or it isn't visible
+func accountByEmail(email string, creds *Config) string {
+  contents, err := jiraRequest("GET", "/user/search?query="+url.QueryEscape(email), nil, creds)
+  if err != nil {
+    logger.Print("Error looking up ", email, ": ", err)
+    return ""
+  }
+  var users []struct {
+    AccountId    string `json:"accountId"`
+    EmailAddress string `json:"emailAddress"`
+  }
+  if err := json.Unmarshal(contents, &users); err != nil {
+    return ""
+  }
+  for _, user := range users {
+    if strings.EqualFold(user.EmailAddress, email) {
+      return user.AccountId
+    }
+  }
+  return ""
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+  "encoding/json"
+  "flag"
+  "fmt"
+  "os"
+  "sort"
+  "strings"
+  "time"
+)
+
+func init() {
+  commands["incident-report"] = command{"incident-report --window=FROM..TO|DURATION [--project=KEY] [--jql=JQL] [--format=markdown|json] [--limit=500]", incidentReportCommand}
+}
+
+// IncidentReport is everything about the issues of an incident window, for
+// a postmortem: the issues, their status changes and what the tracker sent
+// about them, with all of it merged into one timeline
+type IncidentReport struct {
+  From     time.Time     `json:"from"`
+  To       time.Time     `json:"to"`
+  Project  string        `json:"project,omitempty"`
+  Issues   []ReportIssue `json:"issues"`
+  Timeline []ReportEntry `json:"timeline"`
+}
+
+type ReportIssue struct {
+  Key           string               `json:"key"`
+  Summary       string               `json:"summary"`
+  Priority      string               `json:"priority"`
+  Status        string               `json:"status"`
+  Assignee      string               `json:"assignee,omitempty"`
+  Created       string               `json:"created"`
+  Resolved      string               `json:"resolved,omitempty"`
+  Transitions   []ReportTransition   `json:"transitions"`
+  Notifications []ReportNotification `json:"notifications"`
+}
+
+type ReportTransition struct {
+  Time   time.Time `json:"time"`
+  From   string    `json:"from"`
+  To     string    `json:"to"`
+  Author string    `json:"author"`
+}
+
+type ReportNotification struct {
+  Time    time.Time  `json:"time"`
+  Kind    string     `json:"kind"`
+  Rule    string     `json:"rule,omitempty"`
+  Targets []string   `json:"targets"`
+  Seen    *time.Time `json:"seen,omitempty"` // by the first target with read receipts to see it
+}
+
+type ReportEntry struct {
+  Time time.Time `json:"time"`
+  Key  string    `json:"key"`
+  What string    `json:"what"`
+}
+
+// FROM..TO with timestamps or dates, or a duration back from now
+func parseReportWindow(s string) (time.Time, time.Time, error) {
+  if parts := strings.SplitN(s, "..", 2); len(parts) == 2 {
+    from, err := parseReplayTime(parts[0])
+    if err != nil {
+      return time.Time{}, time.Time{}, err
+    }
+    to := time.Now()
+    if len(parts[1]) > 0 {
+      if to, err = parseReplayTime(parts[1]); err != nil {
+        return time.Time{}, time.Time{}, err
+      }
+    }
+    return from, to, nil
+  }
+  d, err := parseDuration(s)
+  if err != nil {
+    return time.Time{}, time.Time{}, fmt.Errorf("expected FROM..TO or a duration: %v", err)
+  }
+  return time.Now().Add(-d), time.Now(), nil
+}
+
+// jql's datetime format, in the local time jira takes it in
+func jqlTime(t time.Time) string {
+  return t.Local().Format(`"2006/01/02 15:04"`)
+}
+
+// the status changes of an issue within the window, from its changelog
+func issueTransitions(key string, from, to time.Time, creds *Config) ([]ReportTransition, error) {
+  contents, err := jiraRequest("GET", "/issue/"+key+"?fields=status&expand=changelog", nil, creds)
+  if err != nil {
+    return nil, err
+  }
+  var issue struct {
+    Changelog struct {
+      Histories []struct {
+        Created string `json:"created"`
+        Author  struct {
+          DisplayName string `json:"displayName"`
+        } `json:"author"`
+        Items []struct {
+          Field      string `json:"field"`
+          FromString string `json:"fromString"`
+          ToString   string `json:"toString"`
+        } `json:"items"`
+      } `json:"histories"`
+    } `json:"changelog"`
+  }
+  if err := json.Unmarshal(contents, &issue); err != nil {
+    return nil, err
+  }
+  transitions := []ReportTransition{}
+  for _, h := range issue.Changelog.Histories {
+    at, err := time.Parse(dateLayout, h.Created)
+    if err != nil || at.Before(from) || at.After(to) {
+      continue
+    }
+    for _, item := range h.Items {
+      if item.Field == "status" {
+        transitions = append(transitions, ReportTransition{at, item.FromString, item.ToString, h.Author.DisplayName})
+      }
+    }
+  }
+  return transitions, nil
+}
+
+func buildIncidentReport(from, to time.Time, project, jql string, limit int, creds *Config) (*IncidentReport, error) {
+  report := &IncidentReport{From: from, To: to, Project: project, Issues: []ReportIssue{}, Timeline: []ReportEntry{}}
+
+  // every issue created or changed in the window
+  query := fmt.Sprintf("updated >= %s AND created <= %s", jqlTime(from), jqlTime(to))
+  if len(project) > 0 {
+    query += " AND project = " + project
+  }
+  if len(jql) > 0 {
+    query += " AND (" + jql + ")"
+  }
+  issues, fields, err := searchIssues(query+" ORDER BY created ASC", limit, creds)
+  if err != nil {
+    return nil, err
+  }
+  byKey := map[string]*ReportIssue{}
+  for i, issue := range issues {
+    report.Issues = append(report.Issues, ReportIssue{
+      Key: issue.Key, Summary: issue.Fields.Summary,
+      Priority: fieldString(fields[i], "priority.name"), Status: fieldString(fields[i], "status.name"),
+      Assignee: fieldString(fields[i], "assignee.displayName"),
+      Created:  fieldString(fields[i], "created"), Resolved: fieldString(fields[i], "resolutiondate"),
+      Transitions: []ReportTransition{}, Notifications: []ReportNotification{},
+    })
+  }
+  for i := range report.Issues {
+    issue := &report.Issues[i]
+    byKey[issue.Key] = issue
+    if created, err := time.Parse(dateLayout, issue.Created); err == nil && !created.Before(from) {
+      report.Timeline = append(report.Timeline, ReportEntry{created, issue.Key, "created: " + issue.Summary})
+    }
+    if issue.Transitions, err = issueTransitions(issue.Key, from, to, creds); err != nil {
+      logger.Print("Error fetching the changelog of ", issue.Key, ": ", err)
+      issue.Transitions = []ReportTransition{}
+    }
+    for _, t := range issue.Transitions {
+      report.Timeline = append(report.Timeline, ReportEntry{t.Time, issue.Key, fmt.Sprintf("%s -> %s by %s", t.From, t.To, t.Author)})
+    }
+  }
+
+  // what the tracker sent about them, from the history and read receipts
+  seen := map[string]*time.Time{}
+  if state != nil {
+    for _, r := range state.AllReceipts() {
+      if r.Seen != nil && !r.Sent.Before(from) && !r.Sent.After(to) {
+        id := r.Key + " " + r.Kind
+        if first, ok := seen[id]; !ok || r.Seen.Before(*first) {
+          seen[id] = r.Seen
+        }
+      }
+    }
+  }
+  err = store.EachHistory(from, func(r HistoryRecord) bool {
+    if r.Time.After(to) {
+      return false
+    }
+    issue, ok := byKey[r.Key]
+    if !ok {
+      return true
+    }
+    issue.Notifications = append(issue.Notifications, ReportNotification{r.Time, r.Kind, r.Rule, r.Targets, seen[r.Key+" "+r.Kind]})
+    report.Timeline = append(report.Timeline, ReportEntry{r.Time, r.Key, fmt.Sprintf("%s sent to %s", r.Kind, strings.Join(r.Targets, ", "))})
+    return true
+  })
+  if err != nil && err != errNoHistory {
+    logger.Print("Error reading history: ", err)
+  }
+  sort.SliceStable(report.Timeline, func(i, j int) bool { return report.Timeline[i].Time.Before(report.Timeline[j].Time) })
+  return report, nil
+}
+
+func (r *IncidentReport) markdown() string {
+  var out strings.Builder
+  title := "Incident report"
+  if len(r.Project) > 0 {
+    title += " for " + r.Project
+  }
+  fmt.Fprintf(&out, "# %s\n\n%s to %s, %d issues\n\n## Issues\n\n", title,
+    r.From.Format("2006-01-02 15:04"), r.To.Format("2006-01-02 15:04"), len(r.Issues))
+  out.WriteString("| Key | Priority | Status | Assignee | Summary | Notified |\n|---|---|---|---|---|---|\n")
+  for _, issue := range r.Issues {
+    notified := "-"
+    if len(issue.Notifications) > 0 {
+      notified = issue.Notifications[0].Time.Format("15:04:05")
+    }
+    fmt.Fprintf(&out, "| %s | %s | %s | %s | %s | %s |\n", issue.Key, issue.Priority, issue.Status, issue.Assignee,
+      strings.ReplaceAll(issue.Summary, "|", "\\|"), notified)
+  }
+  out.WriteString("\n## Timeline\n\n")
+  for _, entry := range r.Timeline {
+    fmt.Fprintf(&out, "- %s **%s** %s\n", entry.Time.Local().Format("2006-01-02 15:04:05"), entry.Key, entry.What)
+  }
+  return out.String()
+}
+
+func incidentReportCommand(args []string) {
+  flags := flag.NewFlagSet("incident-report", flag.ExitOnError)
+  window := flags.String("window", "", "FROM..TO as timestamps or dates, or a duration back from now, e.g. 6h")
+  project := flags.String("project", "", "Only issues in this project")
+  jql := flags.String("jql", "", "Only issues matching this too")
+  format := flags.String("format", "markdown", "markdown or json")
+  limit := flags.Int("limit", 500, "The most issues to include")
+  flags.Parse(args)
+  if len(*window) == 0 || flags.NArg() > 0 || (*format != "markdown" && *format != "json") {
+    usageExit(commands["incident-report"].usage)
+  }
+  from, to, err := parseReportWindow(*window)
+  if err != nil {
+    logger.Print("Invalid --window: ", err)
+    os.Exit(1)
+  }
+
+  creds, _ := setup()
+  report, err := buildIncidentReport(from, to, strings.ToUpper(*project), configJql(creds, "--jql", *jql), *limit, creds)
+  if err != nil {
+    logger.Print("Error building the report: ", err)
+    os.Exit(1)
+  }
+  if *format == "json" {
+    contents, _ := json.MarshalIndent(report, "", "  ")
+    fmt.Println(string(contents))
+    return
+  }
+  fmt.Print(report.markdown())
+}
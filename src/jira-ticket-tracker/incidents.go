@@ -0,0 +1,341 @@
+package main
+
+import (
+  "encoding/json"
+  "flag"
+  "fmt"
+  "net/http"
+  "os"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+)
+
+const (
+  eventIncidentSummary = "incident-summary"
+  eventIncidentEnded   = "incident-ended"
+)
+
+func init() {
+  commands["incident"] = command{"incident start|stop PROJECT [--reason=TEXT] | incident list", incidentCommand}
+}
+
+// while a project is in incident mode the targets get one summary of its
+// issues every few minutes instead of a message per issue. on slack with a
+// token it's the same message, edited as the summary changes. incident mode
+// starts with the `incident` command, and with an anomaly if on_anomaly is
+// set, and ends with the command or after quiet with no events
+//
+//   incidents:
+//     update: 3m
+//     on_anomaly: true
+//     quiet: 30m         # 0 means only the command ends it
+type IncidentConfig struct {
+  Update    string `yaml:"update"`
+  OnAnomaly bool   `yaml:"on_anomaly"`
+  Quiet     string `yaml:"quiet"`
+}
+
+// Incident is a project in incident mode
+type Incident struct {
+  Project string    `json:"project"`
+  Reason  string    `json:"reason"`
+  Started time.Time `json:"started"`
+  Events  int       `json:"events"` // held back from the targets so far
+  Issues  int       `json:"issues"`
+
+  kinds   map[string]int
+  issues  map[string]string // key -> summary
+  order   []string          // the keys, most recent first
+  targets map[string]bool
+  pending int // events since the last summary
+  last    time.Time
+  posted  map[string]slackMessage // slack target -> the summary message
+}
+
+var incidents = struct {
+  sync.Mutex
+  update time.Duration
+  quiet  time.Duration
+  active map[string]*Incident
+}{active: map[string]*Incident{}}
+
+func loadIncidents(creds *Config) {
+  incidents.update = durationOr(creds.Incidents.Update, 3*time.Minute)
+  incidents.quiet = durationOr(creds.Incidents.Quiet, 30*time.Minute)
+}
+
+func startIncident(project, reason string) bool {
+  project = strings.ToUpper(project)
+  incidents.Lock()
+  defer incidents.Unlock()
+  if _, ok := incidents.active[project]; ok {
+    return false
+  }
+  incidents.active[project] = &Incident{
+    Project: project, Reason: reason, Started: time.Now(),
+    kinds: map[string]int{}, issues: map[string]string{}, targets: map[string]bool{},
+    last: time.Now(), posted: map[string]slackMessage{},
+  }
+  logger.Print("Incident mode started for ", project, ": ", reason)
+  return true
+}
+
+// whether a target changes jira rather than notifying anyone, which the
+// summaries don't stand in for
+func changesJira(target string) bool {
+  s, ok := sinks[target]
+  return ok && contains(jiraWriteTargets, s.target.Type)
+}
+
+// hold back the events for projects in incident mode from the notification
+// targets, returning the rest, and the events for targets that change jira
+func holdForIncidents(events []*Event) []*Event {
+  incidents.Lock()
+  defer incidents.Unlock()
+  if len(incidents.active) == 0 {
+    return events
+  }
+  rest := []*Event{}
+  for _, event := range events {
+    project := issueProject(event)
+    if len(project) == 0 {
+      project = strings.SplitN(event.Issue.Key, "-", 2)[0]
+    }
+    incident, ok := incidents.active[project]
+    if !ok || event.Issue.Key == trackerKey {
+      rest = append(rest, event)
+      continue
+    }
+    held, writes := []string{}, []string{}
+    for _, target := range event.Targets {
+      if changesJira(target) {
+        writes = append(writes, target)
+      } else {
+        held = append(held, target)
+      }
+    }
+    if len(writes) > 0 {
+      changes := *event
+      changes.Targets = writes
+      rest = append(rest, &changes)
+    }
+    if len(held) == 0 {
+      continue
+    }
+    key := event.Issue.Key
+    if _, seen := incident.issues[key]; !seen {
+      incident.Issues++
+    } else {
+      for i, k := range incident.order {
+        if k == key {
+          incident.order = append(incident.order[:i], incident.order[i+1:]...)
+          break
+        }
+      }
+    }
+    incident.issues[key] = event.Issue.Fields.Summary
+    incident.order = append([]string{key}, incident.order...)
+    incident.kinds[event.Kind]++
+    for _, target := range held {
+      incident.targets[target] = true
+    }
+    incident.Events++
+    incident.pending++
+    incident.last = time.Now()
+    pipeline.step(event, PipelineStep{Stage: "held", Detail: "for the summary of the incident in " + project})
+  }
+  return rest
+}
+
+func (i *Incident) summary() string {
+  kinds := []string{}
+  for kind, n := range i.kinds {
+    kinds = append(kinds, fmt.Sprintf("%d %s", n, kind))
+  }
+  sort.Strings(kinds)
+  lines := []string{fmt.Sprintf("🚨 Incident in %s since %s (%s): %d issues, %s",
+    i.Project, i.Started.Format("15:04"), i.Reason, i.Issues, strings.Join(kinds, ", "))}
+  for n, key := range i.order {
+    if n == 10 {
+      lines = append(lines, fmt.Sprintf("and %d more", len(i.order)-n))
+      break
+    }
+    lines = append(lines, fmt.Sprintf("• [%s] %s", key, i.issues[key]))
+  }
+  return strings.Join(lines, "\n")
+}
+
+// a summary to send, taken under incidents' lock and sent outside it
+type incidentSummary struct {
+  incident *Incident
+  kind     string
+  text     string
+  targets  []string
+  posted   map[string]slackMessage
+}
+
+// the summary for every target the held back events were for. call with
+// incidents locked
+func (i *Incident) summarize(kind, text string) incidentSummary {
+  u := incidentSummary{incident: i, kind: kind, text: text, posted: map[string]slackMessage{}}
+  for target := range i.targets {
+    u.targets = append(u.targets, target)
+  }
+  sort.Strings(u.targets)
+  for name, message := range i.posted {
+    u.posted[name] = message
+  }
+  return u
+}
+
+// send the summary. slack targets with a token get the first summary
+// edited instead of a new one. the ones that change jira never get it
+func (u incidentSummary) send() {
+  for _, name := range u.targets {
+    s, ok := sinks[name]
+    if !ok || changesJira(name) {
+      continue
+    }
+    if slack, ok := s.notifier.(*slackNotifier); ok && len(slack.token) > 0 {
+      if message, ok := u.posted[name]; ok {
+        if err := slackUpdate(slack.token, message, u.text); err != nil {
+          logger.Print("Error updating the incident summary in ", name, ": ", err)
+        }
+        continue
+      }
+      message, err := slackPost(slack.token, map[string]interface{}{"channel": slack.channel, "text": u.text})
+      if err != nil {
+        logger.Print("Error posting the incident summary to ", name, ": ", err)
+        continue
+      }
+      incidents.Lock()
+      u.incident.posted[name] = message
+      incidents.Unlock()
+      continue
+    }
+    event := trackerEvent(u.kind, u.text)
+    event.Targets = []string{name}
+    s.send([]*Event{event})
+  }
+}
+
+func stopIncident(project string) bool {
+  incidents.Lock()
+  incident, ok := incidents.active[strings.ToUpper(project)]
+  if !ok {
+    incidents.Unlock()
+    return false
+  }
+  delete(incidents.active, incident.Project)
+  summary := incident.summarize(eventIncidentEnded, incident.summary()+fmt.Sprintf("\n✅ Incident mode ended after %s", time.Since(incident.Started).Truncate(time.Minute)))
+  incidents.Unlock()
+  summary.send()
+  logger.Print("Incident mode ended for ", incident.Project)
+  return true
+}
+
+// send the summaries that changed, and end the incidents gone quiet
+func updateIncidents() {
+  incidents.Lock()
+  quiet, summaries := []string{}, []incidentSummary{}
+  for project, incident := range incidents.active {
+    if incident.pending > 0 {
+      summaries = append(summaries, incident.summarize(eventIncidentSummary, incident.summary()))
+      incident.pending = 0
+    } else if incidents.quiet > 0 && time.Since(incident.last) > incidents.quiet {
+      quiet = append(quiet, project)
+    }
+  }
+  incidents.Unlock()
+  for _, summary := range summaries {
+    summary.send()
+  }
+  for _, project := range quiet {
+    stopIncident(project)
+  }
+}
+
+func updateIncidentsForever() {
+  for {
+    time.Sleep(incidents.update)
+    updateIncidents()
+  }
+}
+
+type incidentRequest struct {
+  Project string `json:"project"`
+  Reason  string `json:"reason"`
+}
+
+//   GET    /incidents                            the projects in incident mode
+//   POST   /incidents {"project":..,"reason":..} start incident mode
+//   DELETE /incidents {"project":..}             end it
+func handleIncidents(w http.ResponseWriter, r *http.Request) {
+  if r.Method == "GET" {
+    incidents.Lock()
+    list := []Incident{}
+    for _, incident := range incidents.active {
+      list = append(list, *incident)
+    }
+    incidents.Unlock()
+    sort.Slice(list, func(i, j int) bool { return list[i].Project < list[j].Project })
+    writeJSON(w, http.StatusOK, list)
+    return
+  }
+  if r.Method != "POST" && r.Method != "DELETE" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+  var req incidentRequest
+  if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Project) == 0 {
+    writeError(w, http.StatusBadRequest, "project is required")
+    return
+  }
+  if r.Method == "POST" {
+    if len(req.Reason) == 0 {
+      req.Reason = "started by hand"
+    }
+    if !startIncident(req.Project, req.Reason) {
+      writeError(w, http.StatusConflict, req.Project+" is already in incident mode")
+      return
+    }
+  } else if !stopIncident(req.Project) {
+    writeError(w, http.StatusNotFound, req.Project+" isn't in incident mode")
+    return
+  }
+  w.WriteHeader(http.StatusNoContent)
+}
+
+func incidentCommand(args []string) {
+  flags := flag.NewFlagSet("incident", flag.ExitOnError)
+  reason := flags.String("reason", "", "Why, for the summaries")
+  if len(args) == 0 {
+    usageExit(commands["incident"].usage)
+  }
+  flags.Parse(args[1:])
+  switch {
+  case args[0] == "list" && flags.NArg() == 0:
+    var list []Incident
+    if err := callAPI("GET", "/incidents", nil, &list); err != nil {
+      logger.Print("Error listing incidents: ", err)
+      os.Exit(1)
+    }
+    for _, incident := range list {
+      fmt.Printf("%s\tsince %s\t%d issues\t%s\n", incident.Project, incident.Started.Format("2006-01-02 15:04"), incident.Issues, incident.Reason)
+    }
+  case args[0] == "start" && flags.NArg() == 1:
+    if err := callAPI("POST", "/incidents", incidentRequest{flags.Arg(0), *reason}, nil); err != nil {
+      logger.Print("Error starting incident mode: ", err)
+      os.Exit(1)
+    }
+  case args[0] == "stop" && flags.NArg() == 1:
+    if err := callAPI("DELETE", "/incidents", incidentRequest{Project: flags.Arg(0)}, nil); err != nil {
+      logger.Print("Error ending incident mode: ", err)
+      os.Exit(1)
+    }
+  default:
+    usageExit(commands["incident"].usage)
+  }
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+  "bytes"
+  "encoding/base64"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "mime"
+  "mime/multipart"
+  "mime/quotedprintable"
+  "net/mail"
+  "os"
+  "regexp"
+  "strings"
+  "time"
+)
+
+// an email intake bridge, configured under `intake`, for teams whose intake
+// is still a shared mailbox. unseen mail matching a rule becomes a jira
+// issue, which the rule's targets are then subscribed to so it's tracked
+// like any other. mail matching no rule is left unseen for people to handle
+//
+//   intake:
+//     server: imap.example.com:993
+//     username: support@example.com
+//     password: ...
+//     mailbox: INBOX    # the default
+//     interval: 1m
+//     rules:
+//       - from: '@customer\.com$'
+//         subject: '(?i)urgent|outage'
+//         project: SUPPORT
+//         issuetype: Task
+//         targets: [support-slack]
+//         on_behalf_of: true   # raised as the sender, see ImpersonationConfig
+type IntakeConfig struct {
+  Server   string       `yaml:"server"`
+  Username string       `yaml:"username"`
+  Password string       `yaml:"password"`
+  Mailbox  string       `yaml:"mailbox"`
+  Interval string       `yaml:"interval"`
+  Rules    []IntakeRule `yaml:"rules"`
+}
+
+type IntakeRule struct {
+  From      string   `yaml:"from"`    // patterns, empty matches anything
+  Subject   string   `yaml:"subject"`
+  Project   string   `yaml:"project"`
+  IssueType string   `yaml:"issuetype"`
+  Targets   []string `yaml:"targets"`
+  // create the issue as the jira user with the sender's address, when
+  // there is one, so they're its reporter
+  OnBehalfOf bool `yaml:"on_behalf_of"`
+
+  from, subject *regexp.Regexp
+}
+
+const maxIntakeDescription = 30000
+
+func (c *IntakeConfig) parse() error {
+  if len(c.Mailbox) == 0 {
+    c.Mailbox = "INBOX"
+  }
+  for i := range c.Rules {
+    r := &c.Rules[i]
+    if len(r.Project) == 0 {
+      return fmt.Errorf("rule %d has no project", i+1)
+    }
+    if len(r.IssueType) == 0 {
+      r.IssueType = "Task"
+    }
+    var err error
+    if r.from, err = regexp.Compile(r.From); err != nil {
+      return fmt.Errorf("invalid from pattern: %v", err)
+    }
+    if r.subject, err = regexp.Compile(r.Subject); err != nil {
+      return fmt.Errorf("invalid subject pattern: %v", err)
+    }
+  }
+  return nil
+}
+
+func (c *IntakeConfig) ruleFor(from, subject string) *IntakeRule {
+  for i := range c.Rules {
+    if c.Rules[i].from.MatchString(from) && c.Rules[i].subject.MatchString(subject) {
+      return &c.Rules[i]
+    }
+  }
+  return nil
+}
+
+// decode a part's body by its transfer encoding
+func decodePart(encoding string, body io.Reader) ([]byte, error) {
+  switch strings.ToLower(encoding) {
+  case "base64":
+    return ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, body))
+  case "quoted-printable":
+    return ioutil.ReadAll(quotedprintable.NewReader(body))
+  }
+  return ioutil.ReadAll(body)
+}
+
+// the plain text of a message, the first text/plain part if it's multipart
+func messageText(header map[string][]string, body io.Reader) (string, error) {
+  h := mail.Header(header)
+  mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+  if err != nil {
+    mediaType = "text/plain"
+  }
+  if strings.HasPrefix(mediaType, "multipart/") {
+    r := multipart.NewReader(body, params["boundary"])
+    for {
+      part, err := r.NextPart()
+      if err == io.EOF {
+        return "", nil
+      } else if err != nil {
+        return "", err
+      }
+      if text, err := messageText(part.Header, part); err != nil || len(text) > 0 {
+        return text, err
+      }
+    }
+  }
+  if mediaType != "text/plain" {
+    return "", nil
+  }
+  text, err := decodePart(h.Get("Content-Transfer-Encoding"), body)
+  return string(text), err
+}
+
+// create the issue for a message and subscribe the rule's targets to it
+func intakeMessage(raw []byte, config *IntakeConfig, creds *Config, c chan []*Event) (bool, error) {
+  msg, err := mail.ReadMessage(bytes.NewReader(raw))
+  if err != nil {
+    return false, err
+  }
+  decoder := new(mime.WordDecoder)
+  subject, err := decoder.DecodeHeader(msg.Header.Get("Subject"))
+  if err != nil {
+    subject = msg.Header.Get("Subject")
+  }
+  from := msg.Header.Get("From")
+  if addr, err := mail.ParseAddress(from); err == nil {
+    from = addr.Address
+  }
+  rule := config.ruleFor(from, subject)
+  if rule == nil {
+    return false, nil
+  }
+
+  text, err := messageText(msg.Header, msg.Body)
+  if err != nil {
+    return false, err
+  }
+  if len(text) > maxIntakeDescription {
+    text = text[:maxIntakeDescription] + "\n[truncated]"
+  }
+  if len(strings.TrimSpace(subject)) == 0 {
+    subject = "Email from " + from
+  }
+  author := creds
+  if rule.OnBehalfOf && creds.Impersonation.enabled() {
+    author = creds.actingAs(accountByEmail(from, creds))
+  }
+  key, err := createIssue(map[string]interface{}{
+    "project":     map[string]string{"key": rule.Project},
+    "issuetype":   map[string]string{"name": rule.IssueType},
+    "summary":     strings.Replace(subject, "\n", " ", -1),
+    "description": "From: " + from + "\n\n" + text,
+  }, author)
+  if err != nil {
+    return false, err
+  }
+  logger.Print("Created ", key, " from an email from ", from)
+  followNewIssue(key, rule.Targets, "from an email from "+from, creds, c)
+  return true, nil
+}
+
+func pollMailbox(config *IntakeConfig, creds *Config, c chan []*Event) error {
+  client, err := dialIMAP(config.Server)
+  if err != nil {
+    return err
+  }
+  defer client.close()
+  if err := client.login(config.Username, config.Password); err != nil {
+    return err
+  }
+  if err := client.selectMailbox(config.Mailbox); err != nil {
+    return err
+  }
+  uids, err := client.unseen()
+  if err != nil {
+    return err
+  }
+  for _, uid := range uids {
+    raw, err := client.fetch(uid)
+    if err != nil {
+      return err
+    }
+    created, err := intakeMessage(raw, config, creds, c)
+    if err != nil {
+      logger.Print("Error creating an issue from message ", uid, ": ", err)
+      continue // left unseen to try again
+    }
+    if created {
+      if err := client.markSeen(uid); err != nil {
+        return err
+      }
+    }
+  }
+  return nil
+}
+
+func intakeEmail(creds *Config, c chan []*Event) {
+  config := creds.Intake
+  if err := config.parse(); err != nil {
+    logger.Print("Invalid intake: ", err)
+    os.Exit(1)
+  }
+  interval := durationOr(config.Interval, time.Minute)
+  trigger := newPollTrigger()
+  for {
+    producers.RLock()
+    if err := pollMailbox(&config, creds, c); err != nil {
+      logger.Print("Error reading mail from ", config.Server, ": ", err)
+    }
+    producers.RUnlock()
+    waitForPoll(trigger, interval)
+  }
+}
@@ -20,15 +20,20 @@ package main
 */
 
 import (
+  "bytes"
   "encoding/json"
   "flag"
   "fmt"
   "github.com/plouc/go-jira-client"
+  "io"
   "io/ioutil"
   "launchpad.net/goyaml"
   "log"
+  "net"
   "net/http"
+  "net/url"
   "os"
+  "strings"
   "time"
 )
 
@@ -37,8 +42,19 @@ var (
   config  = flag.String("config", "./config.yaml", "The path to the jira config to connect to")
   project = flag.String("project", "", "The jira project to search for tickets in")
   user    = flag.String("user", "", "The user to search for tickets for")
+  watchlist = flag.String("watchlist", "", "A file of issue keys (one per line) to track regardless of project/user")
+  statePath = flag.String("state", "./state.json", "The path to the file the tracker keeps its state in")
+  listen    = flag.String("listen", "", "The address to serve the control API on, e.g. :8080")
+  apiUrl    = flag.String("api", "http://localhost:8080", "The control API of a running tracker, used by the commands")
+  apiToken  = flag.String("api-token", os.Getenv("TRACKER_API_TOKEN"), "The tracker's operator.token, or $TRACKER_API_TOKEN, for the commands that need it")
+  chaos        = flag.Bool("chaos", false, "Inject failures, slow responses and malformed json into jira calls, see ChaosConfig")
+  traceHTTP    = flag.Bool("trace-http", false, "Log every jira request and response, with their bodies cut short")
+  forceCatchup = flag.Bool("force-catchup", false, "Catch up after downtime even if it is more than catchup.max_issues issues")
+  printRule    = flag.Bool("print-rule", false, "Print the yaml rule --project/--user stand for and exit")
   // create the logger
   logger  = log.New(os.Stderr, "", log.LstdFlags)
+  // shared by every call to jira so connections are reused, see newJiraClient
+  jiraClient = &http.Client{}
 )
 
 const (
@@ -46,18 +62,124 @@ const (
   maxSearchResults = 20          // max number of issues allowed in one search
   trackingMethod   = "reporter"  // either "reporter" or "assignee"
   waitIntervalSecs = 4           // how long to wait between searches
+  indexDelaySecs   = 2           // how long new issues take to be searchable
 )
 
 // store the credentials in a file outside the code
 type Config struct {
   Login    string `yaml:"login"`
   Password string `yaml:"password"`
+  PasswordKeyring string `yaml:"password_keyring"` // SERVICE/ACCOUNT instead of password, see keyringSecret
   Url      string `yaml:"url"`  // e.g. https://jira.whatever.com/rest/api/2
+  Auth     []AuthStep `yaml:"auth"` // how requests authenticate, basic with the login by default
+  Rules    []Rule            `yaml:"rules"`   // what to search for
+  Defaults RuleDefaults      `yaml:"defaults"` // inherited by every rule
+  RuleTemplates map[string]interface{} `yaml:"rule_templates"` // rules with variables
+  GitOps   GitOpsConfig      `yaml:"gitops"`   // rules synced from a git repository
+  Fleet    FleetConfig       `yaml:"fleet"`    // searches handed out to agents
+  Sharding ShardingConfig    `yaml:"sharding"` // rules split between replicas
+  Targets  map[string]Target `yaml:"targets"` // where notifications can be sent
+  Routing  []Route           `yaml:"routing"` // extra targets by priority and project
+  Reminders ReminderConfig   `yaml:"reminders"` // due date reminders
+  FirstResponse []FirstResponsePolicy `yaml:"first_response"` // response targets per project
+  Calendars map[string]*Calendar `yaml:"calendars"` // business hours by project
+  Computed  map[string]string    `yaml:"computed"`  // fields derived from the issue
+  Operator  OperatorConfig       `yaml:"operator"`  // alerts about the tracker itself
+  Catchup   CatchupConfig        `yaml:"catchup"`   // limits on catching up after downtime
+  Storage   StorageConfig        `yaml:"storage"`   // where checkpoints, history and timers are kept
+  Estimate  EstimateConfig       `yaml:"estimate"`  // limits on how much a rule matches
+  Watermarks []Watermark         `yaml:"watermarks"` // alerts on issue counts
+  Anomalies AnomalyConfig         `yaml:"anomalies"`  // alerts on creation rates
+  Incidents IncidentConfig        `yaml:"incidents"`  // summaries instead of a message per issue
+  // named message templates, inline and/or loaded from a directory
+  Templates    map[string]string `yaml:"templates"`
+  TemplatesDir string            `yaml:"templates_dir"`
+  LocalesDir   string            `yaml:"locales_dir"` // translated messages, see templateSet
+  // canned responses for comments, inline and/or loaded from a directory
+  Responses    map[string]string `yaml:"responses"`
+  ResponsesDir string            `yaml:"responses_dir"`
+  Display      DisplayConfig     `yaml:"display"`     // emojis and colors for templates
+  Slack        SlackAppConfig    `yaml:"slack"`       // the app behind message buttons
+  Wallboard    WallboardConfig   `yaml:"wallboard"`   // the team tv page
+  Feeds        FeedsConfig       `yaml:"feeds"`       // atom feeds of rules' issues
+  ICal         ICalConfig        `yaml:"ical"`        // calendars of rules' deadlines
+  // starting points for the create command, inline and/or from a directory
+  IssueTemplates    map[string]IssueTemplate `yaml:"issue_templates"`
+  IssueTemplatesDir string                   `yaml:"issue_templates_dir"`
+  PageSize     int               `yaml:"page_size"` // issues per search page
+  HTTP         HTTPConfig        `yaml:"http"`      // connection tuning for jira
+  Cache        CacheConfig       `yaml:"cache"`     // on-disk cache for metadata
+  Chaos        ChaosConfig       `yaml:"chaos"`     // fault injection rates for --chaos
+  Absences     AbsenceConfig     `yaml:"absences"`  // who is out of office
+  NeedsInfo    NeedsInfoConfig   `yaml:"needs_info"` // chasing reporters for details
+  Priority     PriorityConfig    `yaml:"priority"`   // mapping onto the team's scale
+  Security     SecurityConfig    `yaml:"security"`   // the fast path for security issues
+  CustomerTiers TierConfig       `yaml:"customer_tiers"` // tiers of service desk customers
+  SLO          SLOConfig         `yaml:"slo"`        // the delivery latency objective
+  LatencyBudget LatencyBudgetConfig `yaml:"latency_budget"` // alerts on slow deliveries, by stage
+  WarmStart    WarmStartConfig   `yaml:"warm_start"` // caches snapshotted across restarts
+  ValidatePayloads bool          `yaml:"validate_payloads"` // against the event schema before sending
+  CloudEvents  CloudEventsConfig `yaml:"cloudevents"` // the envelope around outbound events
+  SchemaRegistry SchemaRegistryConfig `yaml:"schema_registry"` // for avro and protobuf payloads
+  History      HistoryConfig     `yaml:"history"`    // a record of every event, for replays
+  Encryption   EncryptionConfig  `yaml:"encryption"` // of the state and history at rest
+  Intake       IntakeConfig      `yaml:"intake"`     // issues from a shared mailbox
+  ErrorTracking ErrorTrackingConfig `yaml:"error_tracking"` // sentry and rollbar alerts
+  Alertmanager AlertmanagerConfig `yaml:"alertmanager"` // issues for prometheus alerts
+  PassiveChecks PassiveCheckConfig `yaml:"passive_checks"` // status for zabbix and nagios
+  Heartbeat    HeartbeatConfig   `yaml:"heartbeat"`  // a dead man's switch pinged on polls
+  Egress       EgressConfig      `yaml:"egress"`     // where the tracker may connect to
+  FIPS         bool              `yaml:"fips"`       // only fips 140 approved crypto, see fips.go
+  Outbox       OutboxConfig      `yaml:"outbox"`     // events stored for `forward` to send on
+  SinkHealth   SinkHealthConfig  `yaml:"sink_health"` // disabling targets that keep failing
+  JqlFragments map[string]string `yaml:"jql_fragments"` // named jql used as ${name}
+  Discovery    DiscoveryConfig   `yaml:"discovery"` // rules for new projects from a template
+  Correlation  CorrelationConfig `yaml:"correlation"` // issues on other jira instances
+  Watchdog     WatchdogConfig    `yaml:"watchdog"`    // alerts on goroutine and heap leaks
+  Accounts     AccountsConfig    `yaml:"accounts"`    // other jira accounts for writes
+  MyTickets    MyTicketsConfig   `yaml:"my_tickets"`  // weekly emails of people's open issues
+  Impersonation ImpersonationConfig `yaml:"impersonation"` // acting as users on jira cloud
+  DND          DNDConfig         `yaml:"dnd"`         // what gets through people's do not disturb
+  TeamReport   TeamReportConfig  `yaml:"team_report"` // weekly response times per assignee
+  Metrics      []CustomMetric    `yaml:"metrics"`     // gauges and counters of issue fields
+  LabelHygiene LabelHygieneConfig `yaml:"label_hygiene"` // tidying the projects' labels
+  Orphans      OrphansConfig     `yaml:"orphans"`     // issues of deactivated users and gone components
+  FullText     FullTextConfig    `yaml:"full_text"`   // a local index of the issues' text for grep
+  JiraWebhooks []JiraWebhook     `yaml:"jira_webhooks"` // other jira instances pushing their issues
+  StatusPage   StatusPageConfig  `yaml:"status_page"` // pause polling while jira has an outage
+  Plan         PlanConfig        `yaml:"plan"`        // review the planned targets' changes to jira
+  Approval     ApprovalConfig    `yaml:"approval"`    // approve the gated targets' changes in chat
+  Quota        QuotaConfig       `yaml:"quota"`       // a budget of calls an hour to each instance
+
+  bearer string // a token to act as a user with instead of the login, see actingAs
+  auth   authChain // built from Auth, see auth.go
+}
+
+func (c *Config) pageSize() int {
+  if c.PageSize > 0 {
+    return c.PageSize
+  }
+  return maxSearchResults
+}
+
+// the page for an issue in jira's web ui
+func (c *Config) browseUrl(key string) string {
+  base := c.Url
+  if i := strings.Index(base, "/rest/api/"); i >= 0 {
+    base = base[:i]
+  }
+  return strings.TrimSuffix(base, "/") + "/browse/" + key
 }
 
 func getCreds(configPath string) Config {
-  // read the yaml file
-  file, err := ioutil.ReadFile(configPath)
+  // read the yaml file, or fetch it
+  var file []byte
+  var err error
+  if remoteConfigUrl(configPath) {
+    file, err = remoteConfig.load(configPath)
+  } else {
+    file, err = ioutil.ReadFile(configPath)
+  }
   if err != nil {
     logger.Print("Error reading config file: ", err)
     os.Exit(1)  // exit if we cannot read the creds
@@ -70,57 +192,119 @@ func getCreds(configPath string) Config {
     logger.Print("Error parsing yaml: ", err)
     os.Exit(1) // exit if we cannot read the creds
   }
+  if len(config.PasswordKeyring) > 0 {
+    if config.Password, err = keyringSecret(config.PasswordKeyring); err != nil {
+      logger.Print("Error getting the password: ", err)
+      os.Exit(1)
+    }
+  }
 
   return config
 }
 
-func jiraQuery(uri string, creds *Config) (contents []byte) {
+// build a request to jira, sending body as json if given. it's
+// authenticated when it's sent, see sendJiraRequest
+func newJiraRequest(method, uri string, body interface{}, creds *Config) (*http.Request, error) {
   url := creds.Url + uri
 
-  req, err := http.NewRequest("GET", url, nil)
+  var reader io.Reader
+  if body != nil {
+    encoded, err := json.Marshal(body)
+    if err != nil {
+      return nil, fmt.Errorf("Error encoding request to jira: %v", err)
+    }
+    reader = bytes.NewReader(encoded)
+  }
+
+  req, err := http.NewRequest(method, url, reader)
   if err != nil {
-    logger.Print("Error making a request to jira: ", err)
-    return
+    return nil, fmt.Errorf("Error making a request to jira: %v", err)
+  }
+  if body != nil {
+    req.Header.Set("Content-Type", "application/json")
   }
-  req.SetBasicAuth(creds.Login, creds.Password)
+  return req, nil
+}
 
-  client := &http.Client{}
-  resp, err := client.Do(req)
-  defer resp.Body.Close()
+// make an authenticated request to jira. the caller has to close the
+// response body. responses that aren't a success are returned as an error
+func jiraDo(method, uri string, body interface{}, creds *Config) (*http.Response, error) {
+  req, err := newJiraRequest(method, uri, body, creds)
   if err != nil {
-    logger.Print("Error calling ", url, ": ", err)
-    return
+    return nil, err
   }
 
-  contents, err = ioutil.ReadAll(resp.Body)
+  resp, err := sendJiraRequest(req, creds)
   if err != nil {
-    logger.Print("Unable to read body contents: ", err)
-    return
+    return nil, fmt.Errorf("Error calling %s: %v", req.URL, err)
   }
+  if resp.StatusCode >= 300 {
+    drainAndClose(resp.Body)
+    return nil, fmt.Errorf("%s %s returned %s", method, req.URL, resp.Status)
+  }
+  return resp, nil
+}
 
-  return
+// make a request and read the whole response
+func jiraRequest(method, uri string, body interface{}, creds *Config) ([]byte, error) {
+  resp, err := jiraDo(method, uri, body, creds)
+  if err != nil {
+    return nil, err
+  }
+  defer resp.Body.Close()
+
+  contents, err := ioutil.ReadAll(resp.Body)
+  if err != nil {
+    return nil, fmt.Errorf("Unable to read body contents: %v", err)
+  }
+  return contents, nil
+}
+
+func jiraQuery(uri string, creds *Config) []byte {
+  contents, err := jiraRequest("GET", uri, nil, creds)
+  if err != nil {
+    logger.Print(err)
+    return nil
+  }
+  return contents
 }
 
-func jiraSearch(field, value string, maxResults int, creds *Config) []byte {
+func jiraSearch(jql string, startAt, maxResults int, creds *Config) []byte {
   uri := fmt.Sprintf(
-      "/search?jql=%s=%s+order+by+created&startAt=0&maxResults=%d",
-      field,
-      value,
+      "/search?jql=%s&startAt=%d&maxResults=%d",
+      url.QueryEscape(jql),
+      startAt,
       maxResults,
   )
 
   return jiraQuery(uri, creds)
 }
 
-func issueFilter(project string, age int) func(i *gojira.Issue) bool {
+func jiraIssue(key string, creds *Config) []byte {
+  contents, _ := jiraIssueIfChanged("issue", key, creds)
+  return contents
+}
+
+// fetch an issue with a conditional request, changed is false if jira said
+// it is the same as when the consumer last fetched it
+func jiraIssueIfChanged(consumer, key string, creds *Config) (contents []byte, changed bool) {
+  contents, changed, err := jiraConditional(consumer, "/issue/"+key, creds)
+  if err != nil {
+    logger.Print(err)
+    return nil, false
+  }
+  return contents, changed
+}
+
+// match issues created after since and up to until in the project (if any)
+func issueFilter(project string, since, until time.Time) func(i *gojira.Issue) bool {
   return func(i *gojira.Issue) bool {
     t, err := time.Parse(dateLayout, i.Fields.Created)
     if err != nil {
       logger.Print("Error parsing time ", i.Fields.Created, ": ", err)
       return false  // skip this issue if we cannot parse the time
     }
-    since := time.Now().UTC().Unix() - t.Unix()
-    if since < int64(age) && i.Fields.Project.Key == project {
+    if t.After(since) && !t.After(until) && (len(project) == 0 || i.Fields.Project.Key == project) {
       return true
     } else {
       return false
@@ -128,47 +312,195 @@ func issueFilter(project string, age int) func(i *gojira.Issue) bool {
   }
 }
 
-func recentIssuesFromUser(user, project string, creds *Config) []*gojira.Issue {
-  filteredIssues := []*gojira.Issue{}
-  issueIsMatch := issueFilter(project, waitIntervalSecs)
+// the jql for the issues a rule matches, created in the window. jql dates
+// are in the jira user's timezone so relative minutes are used and the
+// window is narrowed down exactly by issueFilter
+func windowQuery(rule *Rule, since, until time.Time) string {
+  jql := fmt.Sprintf("(%s) AND created >= -%dm", rule.jql(), int(time.Since(since).Minutes())+1)
+  if before := int(time.Since(until).Minutes()); before > 0 {
+    jql += fmt.Sprintf(" AND created <= -%dm", before)
+  }
+  return jql + " ORDER BY created ASC"
+}
 
-  // get the contents of the search
-  contents := jiraSearch(trackingMethod, user, maxSearchResults, creds)
-  // change "reporter" to "assignee" if you want to track tickets
-  // that were assigned TO the user
+// search for the issues a rule matches that were created in the window,
+// paging through the results. an error means the window has to be retried
+func searchWindow(rule *Rule, since, until time.Time, creds *Config) ([]*Event, error) {
+  if fleet.coordinating() {
+    return fleet.searchWindow(rule, since, until, creds)
+  }
+  events := []*Event{}
+  issueIsMatch := issueFilter(rule.Project, since, until)
+  jql := windowQuery(rule, since, until)
+
+  for startAt := 0; ; {
+    // scan the issues as they are decoded for ones that match our filter
+    // of project/window/links
+    total, count, err := streamSearch(jql, startAt, rule.pageSize(creds), creds, func(data []byte) {
+      if event := windowEvent(rule, data, issueIsMatch, creds); event != nil {
+        events = append(events, event)
+      }
+    })
+    if err != nil {
+      // the window is searched again, and has to find these again
+      forgetCreated(events)
+      return nil, fmt.Errorf("search for rule %s failed: %v", rule.Name, err)
+    }
+
+    startAt += count
+    if count == 0 || startAt >= total {
+      return events, nil
+    }
+  }
+}
 
-  // parse the contents into a list of issues
-  var issues gojira.IssueList
-  err := json.Unmarshal(contents, &issues)
+// the event for an issue a window's search returned, or nil if it doesn't
+// pass the rule's filters, tracing the decision either way
+func windowEvent(rule *Rule, data []byte, issueIsMatch func(*gojira.Issue) bool, creds *Config) *Event {
+  issue, fields, err := parseIssue(data)
   if err != nil {
-    logger.Print("Error parsing json: ", err)
-    return filteredIssues
+    logger.Print("Error parsing issue: ", err)
+    return nil
+  }
+  trace := EvaluationTrace{Time: time.Now(), Rule: rule.Name, Key: issue.Key}
+  inWindow := issueIsMatch(issue)
+  trace.Conditions = []conditionResult{{
+    Condition: "created in the poll window", Passed: inWindow, Checked: true, Detail: "created " + issue.Fields.Created,
+  }}
+  if inWindow {
+    trace.Conditions = append(trace.Conditions, rule.linkResults(fields, creds)...)
+  }
+  if conditionsPassed(trace.Conditions) {
+    // another replica, or an earlier try at the window, may have sent it
+    seen, err := store.MarkSeen("created:"+rule.Name, issue.Key, seenCreatedTTL)
+    if err != nil {
+      logger.Print("Error checking whether ", issue.Key, " was sent already: ", err)
+    }
+    trace.Conditions = append(trace.Conditions, conditionResult{Condition: "not sent already", Passed: !seen, Checked: true})
+  }
+  trace.Matched = conditionsPassed(trace.Conditions)
+  defer func() { recordTrace(trace) }()
+  if !trace.Matched {
+    return nil
   }
+  event := ruleEvent(rule, eventCreated, issue, fields, creds)
+  event.Fetched = time.Now()
+  trace.Targets = event.Targets
+  // keep following the issue so resolves and reopens are noticed
+  state.Track(issue.Key, rule.Name, snapshotOf(fields))
+  return event
+}
 
-  // scan the issues for ones that match our filter of user/project/age
-  for _, issue := range issues.Issues {
-    if issueIsMatch(issue) {
-      filteredIssues = append(filteredIssues, issue)
+// take created events out of their rules' seen sets, so searching their
+// window again sends them
+func forgetCreated(events []*Event) {
+  for _, event := range events {
+    if event.Kind != eventCreated || len(event.Rule) == 0 {
+      continue
+    }
+    if err := store.ForgetSeen("created:"+event.Rule, event.Issue.Key); err != nil {
+      logger.Print("Error forgetting that ", event.Issue.Key, " was sent: ", err)
     }
   }
+}
 
-  return filteredIssues
+// the end of the next search window. issues take a moment to show up in
+// jira's search index so the most recent seconds are left for the next poll
+func windowEnd() time.Time {
+  return time.Now().Add(-indexDelaySecs * time.Second)
 }
 
-func waitForIssues(user, project string, creds *Config, c chan *gojira.Issue) {
+// catch up on what was created while the tracker was down, starting with
+// a window of one interval and doubling it each time so a short restart is
+// one small search and a long outage doesn't become one enormous one
+func catchUp(rule *Rule, since time.Time, creds *Config, c chan []*Event, stop <-chan struct{}) time.Time {
+  logger.Print("Rule ", rule.Name, " was last polled ", time.Since(since).Truncate(time.Second), " ago, catching up")
+  window := rule.interval
+  for !stopped(stop) {
+    until := since.Add(window)
+    end := windowEnd()
+    if !until.Before(end) {
+      return since // close enough for the normal polling to take over
+    }
+    events, err := searchWindow(rule, since, until, creds)
+    recordPoll(rule.Name, len(events), err)
+    if err != nil {
+      logger.Print("Error catching up rule ", rule.Name, ": ", err)
+      waitForPollOrStop(nil, rule.interval, stop)
+      continue
+    }
+    if len(events) > 0 {
+      c <- events
+    }
+    since = until
+    setCheckpoint(rule.Name, since)
+    window *= 2
+  }
+  return since
+}
+
+// poll for a rule's issues until stop is closed
+func waitForIssues(rule *Rule, creds *Config, c chan []*Event, stop <-chan struct{}) {
+  since, ok := checkpoint(rule.Name)
+  if !ok {
+    since = windowEnd() // never polled before, only look at new issues
+  } else if time.Now().After(rule.nextPoll(rule.nextPoll(since))) {
+    // missed more than one poll. scheduled rules search everything since
+    // their last run anyway, so they only need the cap checked
+    if !catchUpAllowed(rule, since, creds) {
+      return
+    }
+    if rule.cron == nil {
+      since = catchUp(rule, since, creds, c, stop)
+    }
+  }
+
+  trigger := newPollTrigger()
+  defer removePollTrigger(trigger)
   for {
-    time.Sleep(time.Duration(waitIntervalSecs * time.Second))
-    issues := recentIssuesFromUser(user, project, creds)
-    for _, issue := range issues {
-      c <- issue
+    next := quota.stretch(creds.Url, rule.nextPoll(time.Now()))
+    setNextPoll(rule.Name, next)
+    if !waitForPollOrStop(trigger, time.Until(next), stop) {
+      return
+    }
+    if rulePaused(rule.Name) || skipForOutage() || skipForQuota(creds.Url) {
+      continue
+    }
+    until := windowEnd()
+    events, err := searchWindow(rule, since, until, creds)
+    recordPoll(rule.Name, len(events), err)
+    if err != nil {
+      logger.Print(err)
+      continue // keep the window open until a search succeeds
     }
+    if len(events) > 0 {
+      // a whole poll at a time so sinks can batch them
+      c <- events
+    }
+    since = until
+    setCheckpoint(rule.Name, since)
   }
 }
 
-func readIssues(c chan *gojira.Issue) {
+func readIssues(c chan []*Event, creds *Config) {
   for {
-    issue := <-c
-    logger.Print(fmt.Sprintf("Found: [%s] %s", issue.Key, issue.Fields.Summary))
+    events := <-c
+    deliveries.RLock()
+    for _, event := range events {
+      writeBackPriority(event, creds)
+      restrictSecurityIssue(event, creds)
+      issue := event.Issue
+      if event.Kind == eventCreated {
+        logger.Print(fmt.Sprintf("Found: [%s] %s", issue.Key, issue.Fields.Summary))
+      } else {
+        logger.Print(fmt.Sprintf("%s: [%s] %s %s", event.Kind, issue.Key, issue.Fields.Summary, event.Detail))
+      }
+    }
+    recordHistory(events)
+    fullText.add(events)
+    countEventMetrics(events, creds)
+    deliver(events)
+    deliveries.RUnlock()
     /*
        implement your own functions here
        to do whatever you want with the issues
@@ -179,27 +511,222 @@ func readIssues(c chan *gojira.Issue) {
   }
 }
 
+// load the config, state and everything built from them. shared by the
+// tracker and the commands that work on the config or state directly
+func setup() (*Config, []*Rule) {
+  creds := getCreds(*config)
+  loadFIPS(&creds)
+  jiraClient = newJiraClient(creds.HTTP)
+  loadEgress(&creds)
+  if *chaos {
+    jiraClient = withChaos(jiraClient, creds.Chaos)
+  }
+  if *traceHTTP {
+    jiraClient = withTrace(jiraClient)
+  }
+  jiraClient = withQuota(jiraClient)
+  loadQuota(&creds)
+  loadAuth(&creds)
+  responseCache = newDiskCache(creds.Cache)
+  parseCalendars(&creds)
+  absences = loadAbsences(creds.Absences)
+  loadJqlFragments(&creds)
+  loadComputedFields(&creds)
+  loadPriorities(&creds)
+  loadSecurity(&creds)
+  loadCustomerTiers(&creds)
+  loadSLO(&creds)
+  loadSinkHealth(&creds)
+  loadLatencyBudget(&creds)
+  validatePayloads = creds.ValidatePayloads
+  loadCloudEvents(&creds)
+  schemaRegistry = creds.SchemaRegistry
+  loadErrorTracking(&creds)
+  loadCorrelation(&creds)
+  loadHeartbeat(&creds)
+  loadReliability(&creds)
+  loadSlackApp(&creds)
+  loadWallboard(&creds)
+  loadFeeds(&creds)
+  loadICal(&creds)
+  loadGitOps(&creds)
+  loadDiscovery(&creds)
+  loadFleet(&creds)
+  loadSharding(&creds)
+  loadWatermarks(&creds)
+  loadAnomalies(&creds)
+  loadIncidents(&creds)
+  loadMyTickets(&creds)
+  loadDND(&creds)
+  operatorToken = creds.Operator.Token
+  loadTeamReport(&creds)
+  loadCustomMetrics(&creds)
+  loadLabelHygiene(&creds)
+  loadOrphans(&creds)
+  loadStatusPage(&creds)
+  loadPlan(&creds)
+  loadApprovals(&creds)
+  rules := configuredRules(&creds)
+  stateCipher = loadEncryption(creds.Encryption)
+  state = loadState(*statePath)
+  archive = openArchive(&creds)
+  store = openStorage(&creds)
+  loadDisplay(&creds)
+  templates = loadTemplates(&creds)
+  responses = loadResponses(&creds, rules)
+  sinks = newSinks(&creds)
+  outbox = openOutbox(&creds)
+  history = openHistory(&creds)
+  return &creds, rules
+}
+
 func main() {
   flag.Parse()
 
-  if len(*project) == 0 {
-    // project is required
-    logger.Print("Please specify a project")
-    os.Exit(1)
-  } else if len(*user) == 0 {
+  if flag.NArg() > 0 {
+    // e.g. ./jira-ticket-tracker subscribe KEY-123 ops-slack
+    runCommand(flag.Args())
+    return
+  }
+  if *asService {
+    startService()
+  }
+
+  if len(*project) > 0 && len(*user) == 0 {
     // user is required
     logger.Print("Please specify a user")
     os.Exit(1)
   }
-  logger.Print("Searching in [", *project, "] for ", *user)
+  if *printRule {
+    printFlagRule()
+    return
+  }
+  if len(*project) > 0 {
+    logger.Print("Tracking --project/--user as rule ", *project, "-", *user, ", --print-rule prints it to move into the config")
+  }
 
-  creds := getCreds(*config)
+  creds, rules := setup()
+  if len(rules) == 0 && discovery == nil && len(*watchlist) == 0 && len(*listen) == 0 && len(creds.Watermarks) == 0 && !creds.Anomalies.enabled() {
+    // a project or rules are required unless we are only tracking
+    // individual issues
+    logger.Print("Please specify a project or configure rules")
+    os.Exit(1)
+  }
+  if fleet.coordinating() && len(*listen) == 0 {
+    logger.Print("A fleet coordinator needs --listen, agents reach it through the control API")
+    os.Exit(1)
+  }
+  if shards.enabled() && len(*listen) == 0 {
+    logger.Print("Sharded replicas need --listen, they check on each other through the control API")
+    os.Exit(1)
+  }
+  logStartupBanner(creds, rules)
+  pipeline = openPipelineRecorder()
+  fullText = openFullTextIndex(creds)
+
+  loadSnapshot(creds)
+  c := make(chan []*Event)
+  if gitops.enabled() {
+    rules = gitops.initial(rules)
+  }
+  // create the producers
+  startPollers(rules, creds, c)
+  if remoteConfigUrl(*config) {
+    go remoteConfig.watch(*config, creds)
+  }
+  if gitops.enabled() {
+    go gitops.syncForever()
+  }
+  if discovery != nil {
+    go discovery.discoverForever()
+  }
+  if shards.enabled() {
+    go shards.checkForever()
+  }
+  if len(*watchlist) > 0 {
+    logger.Print("Watching issues listed in ", *watchlist)
+  }
+  go watchIssues(*watchlist, rules, creds, c)
+  if len(creds.Reminders.Offsets) > 0 {
+    go remindDueIssues(rules, creds, c)
+  }
+  if len(creds.FirstResponse) > 0 {
+    go timeFirstResponses(rules, creds, c)
+  }
+  if len(creds.NeedsInfo.Status) > 0 {
+    go chaseNeedsInfo(creds, c)
+  }
+  if receiptsEnabled(creds) {
+    go chaseReceipts(c, creds)
+  }
+  go resumeChains()
+  if len(creds.SLO.Report) > 0 {
+    go reportSLOWeekly()
+  }
+  if len(creds.Intake.Server) > 0 {
+    go intakeEmail(creds, c)
+  }
+  if creds.Operator.Digest {
+    go sendOperatorDigestWeekly(creds)
+  }
+  if creds.MyTickets.enabled() {
+    go sendMyTicketsWeekly(creds)
+  }
+  if creds.TeamReport.enabled() {
+    go sendTeamReportWeekly(creds)
+  }
+  if len(creds.Metrics) > 0 {
+    go watchCustomMetrics(creds)
+  }
+  if creds.LabelHygiene.enabled() {
+    go watchLabels(creds)
+  }
+  if creds.Orphans.enabled() {
+    go watchOrphans(creds)
+  }
+  if creds.StatusPage.enabled() {
+    go watchStatusPage(creds)
+  }
+  if creds.planned() {
+    go watchPlan(creds)
+  }
+  if creds.gated() {
+    go watchApprovals(creds)
+  }
+  if len(creds.Watermarks) > 0 {
+    go watchWatermarks(creds)
+  }
+  if creds.Anomalies.enabled() {
+    go watchCreationRates(creds)
+  }
+  go updateIncidentsForever()
+  if creds.Watchdog.enabled() {
+    go watchForLeaks(creds)
+  }
+  if creds.PassiveChecks.enabled() {
+    go sendPassiveChecks(creds)
+  }
+  if history != nil && history.retention > 0 {
+    go history.compactForever()
+  }
 
-  c := make(chan *gojira.Issue)
-  // create the producer
-  go waitForIssues(*user, *project, &creds, c)
+  var listener net.Listener
+  if len(*listen) > 0 {
+    loadAlertmanager(creds, c)
+    loadJiraWebhooks(creds, c)
+    listener = listenAPI(*listen)
+    go serveAPI(listener)
+  }
+  go handlePollSignal()
+  go handleUpgradeSignal(listener, creds)
+  go handleShutdownSignal(creds)
   // create the consumer
-  go readIssues(c)
+  go readIssues(c, creds)
+  tookOver()
+  if *asService {
+    serviceRunning(creds) // until the service manager stops it
+    return
+  }
 
   // so the program wont end
   var input string
@@ -0,0 +1,56 @@
+package main
+
+import (
+  "io/ioutil"
+  "os"
+  "strings"
+)
+
+// commands that change issues directly, as the tracker's user, so the
+// tracker doubles as a small jira cli
+func init() {
+  commands["comment"] = command{"comment KEY TEXT|-", commentCommand}
+  commands["transition"] = command{"transition KEY NAME", transitionCommand}
+}
+
+// the text from the arguments, or stdin if it's -
+func argumentText(args []string) string {
+  if len(args) == 1 && args[0] == "-" {
+    contents, err := ioutil.ReadAll(os.Stdin)
+    if err != nil {
+      logger.Print("Error reading stdin: ", err)
+      os.Exit(1)
+    }
+    return strings.TrimSpace(string(contents))
+  }
+  return strings.Join(args, " ")
+}
+
+func commentCommand(args []string) {
+  if len(args) < 2 {
+    usageExit(commands["comment"].usage)
+  }
+  key, text := strings.ToUpper(args[0]), argumentText(args[1:])
+  if len(text) == 0 {
+    usageExit(commands["comment"].usage)
+  }
+  creds, _ := setup()
+  if err := addComment(key, text, creds); err != nil {
+    logger.Print("Error commenting on ", key, ": ", err)
+    os.Exit(1)
+  }
+  logger.Print("Commented on ", key)
+}
+
+func transitionCommand(args []string) {
+  if len(args) < 2 {
+    usageExit(commands["transition"].usage)
+  }
+  key, name := strings.ToUpper(args[0]), strings.Join(args[1:], " ")
+  creds, _ := setup()
+  if err := transitionIssue(key, name, creds); err != nil {
+    logger.Print("Error moving ", key, ": ", err)
+    os.Exit(1)
+  }
+  logger.Print("Moved ", key, " to ", name)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+  "crypto/hmac"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "os"
+  "strings"
+
+  "github.com/plouc/go-jira-client"
+)
+
+// jira instances that push their issues to the tracker instead of being
+// polled, configured under `jira_webhooks`. each one's webhook is pointed at
+// /webhooks/jira/NAME on the control api, so --listen is needed
+//
+//   jira_webhooks:
+//     - name: server
+//       secret: ...   # the webhook's secret, checked against X-Hub-Signature
+//       url: https://jira.acme.com/rest/api/2   # to check rules' links in, the tracker's by default
+//       login: tracker
+//       password: ...
+//     - name: cloud
+//       secret: ...
+//
+// an issue from an instance only goes through the rules with its name as
+// their `instance`, and those rules aren't polled. its events carry the
+// name as Instance and as the computed field `instance`, so routes and
+// templates can tell the instances apart. the targets that change jira
+// would change the tracker's own, so instance rules can't use them and
+// routes' are dropped from their events
+type JiraWebhook struct {
+  Name     string     `yaml:"name"`
+  Secret   string     `yaml:"secret"`
+  Url      string     `yaml:"url"`
+  Login    string     `yaml:"login"`
+  Password string     `yaml:"password"`
+  Auth     []AuthStep `yaml:"auth"` // see AuthStep
+
+  creds *Config // the instance's for links, the tracker's own without a url
+}
+
+type jiraWebhookPayload struct {
+  WebhookEvent string          `json:"webhookEvent"`
+  EventType    string          `json:"issue_event_type_name"`
+  Issue        json.RawMessage `json:"issue"`
+  Changelog    struct {
+    Items []struct {
+      Field      string `json:"field"`
+      FromString string `json:"fromString"`
+      ToString   string `json:"toString"`
+    } `json:"items"`
+  } `json:"changelog"`
+}
+
+var jiraWebhooks = struct {
+  creds     *Config
+  events    chan []*Event
+  instances map[string]JiraWebhook
+}{instances: map[string]JiraWebhook{}}
+
+func loadJiraWebhooks(creds *Config, c chan []*Event) {
+  jiraWebhooks.creds, jiraWebhooks.events = creds, c
+  for _, w := range creds.JiraWebhooks {
+    if len(w.Name) == 0 || strings.Contains(w.Name, "/") {
+      logger.Print("Every jira_webhooks entry needs a name without slashes")
+      os.Exit(1)
+    }
+    if _, ok := jiraWebhooks.instances[w.Name]; ok {
+      logger.Print("There is more than one jira webhook named ", w.Name)
+      os.Exit(1)
+    }
+    if len(w.Secret) == 0 {
+      logger.Print("The jira webhook ", w.Name, " needs a secret, or anyone could post issues to it")
+      os.Exit(1)
+    }
+    w.creds = creds
+    if len(w.Url) > 0 {
+      w.creds = &Config{Login: w.Login, Password: w.Password, Url: strings.TrimSuffix(w.Url, "/")}
+      w.creds.auth = loadAuthChain(w.Auth, "jira webhook "+w.Name)
+    }
+    jiraWebhooks.instances[w.Name] = w
+  }
+}
+
+// the kind of event a webhook is and its detail, empty for the ones that
+// aren't tracked, like deletions
+func (p *jiraWebhookPayload) kind() (string, string) {
+  switch p.WebhookEvent {
+  case "jira:issue_created":
+    return eventCreated, ""
+  case "comment_created":
+    return eventCommented, ""
+  case "jira:issue_updated":
+    break
+  default:
+    return "", ""
+  }
+  kind, detail := eventUpdated, ""
+  for _, item := range p.Changelog.Items {
+    switch {
+    case item.Field == "resolution" && len(item.ToString) > 0:
+      return eventResolved, ""
+    case item.Field == "resolution":
+      return eventReopened, ""
+    case item.Field == "status":
+      kind, detail = eventTransitioned, item.FromString+" -> "+item.ToString
+    }
+  }
+  if kind == eventUpdated && p.EventType == "issue_commented" {
+    kind = eventCommented
+  }
+  return kind, detail
+}
+
+// the rules for an instance's issues, as configured right now
+func instanceRules(name string) []*Rule {
+  pollers.Lock()
+  defer pollers.Unlock()
+  rules := []*Rule{}
+  for _, rule := range pollers.configured {
+    if rule.Instance == name {
+      rules = append(rules, rule)
+    }
+  }
+  return rules
+}
+
+// an event for each of the instance's rules the issue passes
+func instanceEvents(name, kind, detail string, issue *gojira.Issue, fields map[string]interface{}, creds *Config) []*Event {
+  events := []*Event{}
+  instance := jiraWebhooks.instances[name]
+  for _, rule := range instanceRules(name) {
+    if !conditionsPassed(rule.evaluate(issue, fields, instance.creds)) {
+      continue
+    }
+    event := ruleEvent(rule, kind, issue, fields, creds)
+    event.Targets = withoutJiraWrites(event.Targets, name)
+    event.Detail, event.Instance = detail, name
+    if event.Computed == nil {
+      event.Computed = map[string]string{}
+    }
+    event.Computed["instance"] = name
+    events = append(events, event)
+  }
+  return events
+}
+
+// the targets without the ones that change jira, which would change the
+// tracker's own instead of the one the issue is from
+func withoutJiraWrites(targets []string, instance string) []string {
+  kept := []string{}
+  for _, name := range targets {
+    if s, ok := sinks[name]; ok && contains(jiraWriteTargets, s.target.Type) {
+      logger.Print("Not sending ", instance, "'s issue to ", name, ", it would change the tracker's jira")
+      continue
+    }
+    kept = append(kept, name)
+  }
+  return kept
+}
+
+// an error naming the first target of an instance rule that changes jira
+func checkInstanceTargets(rule *Rule, creds *Config) error {
+  names := append([]string{}, rule.Targets...)
+  for _, targets := range rule.On {
+    names = append(names, targets...)
+  }
+  for _, step := range rule.Chain.Steps {
+    names = append(names, step.Target)
+  }
+  for _, name := range names {
+    if target, ok := creds.Targets[name]; ok && contains(jiraWriteTargets, target.Type) {
+      return fmt.Errorf("target %s changes jira, which would be the tracker's and not instance %s's", name, rule.Instance)
+    }
+  }
+  return nil
+}
+
+//   POST /webhooks/jira/NAME   a jira instance's webhook
+func handleJiraWebhook(w http.ResponseWriter, r *http.Request) {
+  if r.Method != "POST" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+  name := strings.TrimPrefix(r.URL.Path, "/webhooks/jira/")
+  instance, ok := jiraWebhooks.instances[name]
+  if !ok {
+    writeError(w, http.StatusNotFound, "no jira webhook named "+name)
+    return
+  }
+  body, err := ioutil.ReadAll(r.Body)
+  if err != nil {
+    writeError(w, http.StatusBadRequest, err.Error())
+    return
+  }
+  mac := hmac.New(sha256.New, []byte(instance.Secret))
+  mac.Write(body)
+  expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+  if !hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Hub-Signature"))) {
+    writeError(w, http.StatusUnauthorized, "invalid signature")
+    return
+  }
+  var payload jiraWebhookPayload
+  if err := json.Unmarshal(body, &payload); err != nil {
+    writeError(w, http.StatusBadRequest, "invalid json: "+err.Error())
+    return
+  }
+  kind, detail := payload.kind()
+  if len(kind) == 0 || len(payload.Issue) == 0 {
+    writeJSON(w, http.StatusOK, map[string]int{"events": 0})
+    return
+  }
+  issue, fields, err := parseIssue(payload.Issue)
+  if err != nil {
+    writeError(w, http.StatusBadRequest, "invalid issue: "+err.Error())
+    return
+  }
+  events := instanceEvents(name, kind, detail, issue, fields, jiraWebhooks.creds)
+  if len(events) > 0 {
+    jiraWebhooks.events <- events
+  }
+  writeJSON(w, http.StatusOK, map[string]int{"events": len(events)})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+  "bytes"
+  "encoding/binary"
+  "fmt"
+  "net"
+  "sort"
+  "strings"
+  "unicode"
+)
+
+// writes each event to the local journal with the issue as fields of its
+// own, JIRA_KEY, JIRA_KIND, JIRA_RULE and so on, and the computed fields as
+// JIRA_COMPUTED_NAME, so `journalctl JIRA_KIND=created` or a forwarder to a
+// siem can pick them out
+//
+//   targets:
+//     journal:
+//       type: journald
+//       severity: {Blocker: crit}   # by priority, notice by default
+type journaldNotifier struct {
+  severity map[string]int
+  socket   string
+}
+
+const journaldSocket = "/run/systemd/journal/socket"
+
+func newJournaldNotifier(name string, target Target) *journaldNotifier {
+  return &journaldNotifier{severity: parseSeverities(name, target.Severity), socket: journaldSocket}
+}
+
+// a journal field name, uppercase letters, digits and underscores, not
+// starting with one
+func journalFieldName(s string) string {
+  name := strings.Map(func(r rune) rune {
+    if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+      return '_'
+    }
+    return unicode.ToUpper(r)
+  }, s)
+  return strings.TrimLeft(name, "_")
+}
+
+// a field in the journal's native protocol. values with newlines are sent
+// with their length instead of ending at one
+func writeJournalField(w *bytes.Buffer, name, value string) {
+  if !strings.Contains(value, "\n") {
+    fmt.Fprintf(w, "%s=%s\n", name, value)
+    return
+  }
+  w.WriteString(name + "\n")
+  binary.Write(w, binary.LittleEndian, uint64(len(value)))
+  w.WriteString(value + "\n")
+}
+
+// the entry's fields, in order
+func (n *journaldNotifier) fields(event *Event, message string) [][2]string {
+  fields := [][2]string{
+    {"MESSAGE", message},
+    {"PRIORITY", fmt.Sprint(eventSeverity(event, n.severity))},
+    {"SYSLOG_IDENTIFIER", "jira-ticket-tracker"},
+    {"JIRA_KEY", event.Issue.Key},
+    {"JIRA_KIND", event.Kind},
+  }
+  if len(event.Rule) > 0 {
+    fields = append(fields, [2]string{"JIRA_RULE", event.Rule})
+  }
+  if event.Issue.Fields != nil {
+    fields = append(fields, [2]string{"JIRA_SUMMARY", event.Issue.Fields.Summary})
+  }
+  if priority := fieldString(event.Fields, "priority.name"); len(priority) > 0 {
+    fields = append(fields, [2]string{"JIRA_PRIORITY", priority})
+  }
+  if len(event.Detail) > 0 {
+    fields = append(fields, [2]string{"JIRA_DETAIL", event.Detail})
+  }
+  if len(event.Id) > 0 {
+    fields = append(fields, [2]string{"JIRA_EVENT_ID", event.Id})
+  }
+  names := []string{}
+  for name := range event.Computed {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  for _, name := range names {
+    if field := journalFieldName(name); len(field) > 0 {
+      fields = append(fields, [2]string{"JIRA_COMPUTED_" + field, event.Computed[name]})
+    }
+  }
+  return fields
+}
+
+func (n *journaldNotifier) Notify(event *Event, message string) error {
+  var w bytes.Buffer
+  for _, field := range n.fields(event, message) {
+    writeJournalField(&w, field[0], field[1])
+  }
+
+  conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: n.socket, Net: "unixgram"})
+  if err != nil {
+    return err
+  }
+  defer conn.Close()
+  // big entries need a memfd passed over the socket, which isn't done, so
+  // they fail here and go down the fallbacks
+  _, err = conn.Write(w.Bytes())
+  return err
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+  "fmt"
+  "strings"
+)
+
+// secrets kept in the os keyring instead of the config, as SERVICE/ACCOUNT,
+// for a tracker on someone's own machine
+//
+//   password_keyring: jira-ticket-tracker/tracker@acme.com
+//   targets:
+//     audit:
+//       signing:
+//         secret_keyring: jira-ticket-tracker/audit-webhook
+//
+// it's the login keychain on macos, the secret service on linux, through
+// secret-tool from libsecret, and the credential manager on windows, where
+// SERVICE is the generic credential's name and ACCOUNT its user. platforms
+// without one fail to read it, and the config has to use another source
+func keyringSecret(entry string) (string, error) {
+  parts := strings.SplitN(entry, "/", 2)
+  if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+    return "", fmt.Errorf("invalid keyring entry %q, it's SERVICE/ACCOUNT", entry)
+  }
+  secret, err := readKeyring(parts[0], parts[1])
+  if err != nil {
+    return "", fmt.Errorf("reading %s from the keyring: %v", entry, err)
+  }
+  if len(secret) == 0 {
+    return "", fmt.Errorf("%s is empty in the keyring", entry)
+  }
+  return secret, nil
+}
@@ -0,0 +1,14 @@
+//go:build darwin
+// +build darwin
+
+package main
+
+import (
+  "os/exec"
+  "strings"
+)
+
+func readKeyring(service, account string) (string, error) {
+  out, err := exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w").Output()
+  return strings.TrimRight(string(out), "\n"), err
+}
@@ -0,0 +1,15 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+  "os/exec"
+  "strings"
+)
+
+// stored with `secret-tool store --label=... service SERVICE account ACCOUNT`
+func readKeyring(service, account string) (string, error) {
+  out, err := exec.Command("secret-tool", "lookup", "service", service, "account", account).Output()
+  return strings.TrimRight(string(out), "\n"), err
+}
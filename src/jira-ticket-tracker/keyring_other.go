@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package main
+
+import (
+  "fmt"
+  "runtime"
+)
+
+func readKeyring(service, account string) (string, error) {
+  return "", fmt.Errorf("there's no keyring on %s", runtime.GOOS)
+}
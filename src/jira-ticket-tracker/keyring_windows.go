@@ -0,0 +1,70 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+  "fmt"
+  "syscall"
+  "unicode/utf16"
+  "unsafe"
+)
+
+var (
+  advapi32     = syscall.NewLazyDLL("advapi32.dll")
+  procCredRead = advapi32.NewProc("CredReadW")
+  procCredFree = advapi32.NewProc("CredFree")
+)
+
+const credTypeGeneric = 1
+
+// CREDENTIALW
+type windowsCredential struct {
+  Flags              uint32
+  Type               uint32
+  TargetName         *uint16
+  Comment            *uint16
+  LastWritten        syscall.Filetime
+  CredentialBlobSize uint32
+  CredentialBlob     *byte
+  Persist            uint32
+  AttributeCount     uint32
+  Attributes         uintptr
+  TargetAlias        *uint16
+  UserName           *uint16
+}
+
+// stored with `cmdkey /generic:SERVICE /user:ACCOUNT /pass`, which keeps
+// the password as utf-16
+func readKeyring(service, account string) (string, error) {
+  target, err := syscall.UTF16PtrFromString(service)
+  if err != nil {
+    return "", err
+  }
+  var cred *windowsCredential
+  ok, _, err := procCredRead.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+  if ok == 0 {
+    return "", err
+  }
+  defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+  if user := utf16PtrString(cred.UserName); user != account {
+    return "", fmt.Errorf("the credential %s is for %s, not %s", service, user, account)
+  }
+  blob := unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)
+  chars := make([]uint16, len(blob)/2)
+  for i := range chars {
+    chars[i] = uint16(blob[2*i]) | uint16(blob[2*i+1])<<8
+  }
+  return string(utf16.Decode(chars)), nil
+}
+
+func utf16PtrString(p *uint16) string {
+  if p == nil {
+    return ""
+  }
+  chars := []uint16{}
+  for ptr := unsafe.Pointer(p); *(*uint16)(ptr) != 0; ptr = unsafe.Add(ptr, 2) {
+    chars = append(chars, *(*uint16)(ptr))
+  }
+  return string(utf16.Decode(chars))
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "os"
+  "sort"
+  "strings"
+  "time"
+)
+
+const eventLabelReport = "label-report"
+
+func init() {
+  commands["labels"] = command{"labels [--project=KEY,..] [--apply]", labelsCommand}
+}
+
+// keeps the tracked projects' labels tidy, configured under
+// `label_hygiene`. every interval the labelled issues are searched, their
+// labels normalized and the report sent to the targets
+//
+//   label_hygiene:
+//     projects: [OPS, PAY]
+//     lowercase: true          # Prod-Bug becomes prod-bug
+//     replace:                 # deprecated labels and their replacements
+//       prodbug: production
+//       legacy: ""             # dropped
+//     known: [production, customer, security]  # the rest are reported
+//     interval: 24h
+//     apply: true              # change the labels, only reported until then
+//     max: 500                 # the most issues looked at each time
+//     targets: [ops-email]     # the operator's by default
+type LabelHygieneConfig struct {
+  Projects  []string          `yaml:"projects"`
+  Lowercase bool              `yaml:"lowercase"`
+  Replace   map[string]string `yaml:"replace"`
+  Known     []string          `yaml:"known"`
+  Interval  string            `yaml:"interval"`
+  Apply     bool              `yaml:"apply"`
+  Max       int               `yaml:"max"`
+  Targets   []string          `yaml:"targets"`
+
+  interval time.Duration
+}
+
+func (c *LabelHygieneConfig) enabled() bool {
+  return len(c.Projects) > 0
+}
+
+func loadLabelHygiene(creds *Config) {
+  c := &creds.LabelHygiene
+  c.interval = durationOr(c.Interval, 24*time.Hour)
+  if c.Max <= 0 {
+    c.Max = 500
+  }
+  for i, project := range c.Projects {
+    c.Projects[i] = strings.ToUpper(project)
+  }
+  if c.Lowercase {
+    replace := map[string]string{}
+    for label, replacement := range c.Replace {
+      replace[strings.ToLower(label)] = strings.ToLower(replacement)
+    }
+    c.Replace = replace
+    for i, label := range c.Known {
+      c.Known[i] = strings.ToLower(label)
+    }
+  }
+  for label, replacement := range c.Replace {
+    if strings.ContainsAny(label+replacement, " \t") {
+      logger.Print("Invalid label_hygiene.replace ", label, ": labels can't have spaces")
+      os.Exit(1)
+    }
+  }
+}
+
+// the issue's labels once they're tidied up, in the same order, and the ones
+// that aren't known
+func (c *LabelHygieneConfig) fix(labels []string) (fixed, unknown []string) {
+  for _, label := range labels {
+    if c.Lowercase {
+      label = strings.ToLower(label)
+    }
+    if replacement, ok := c.Replace[label]; ok {
+      label = replacement
+    }
+    if len(label) == 0 || contains(fixed, label) {
+      continue
+    }
+    fixed = append(fixed, label)
+    if len(c.Known) > 0 && !contains(c.Known, label) {
+      unknown = append(unknown, label)
+    }
+  }
+  return fixed, unknown
+}
+
+// what a pass over the projects found
+type labelReport struct {
+  changes []string       // KEY: old -> new
+  failed  int            // changes jira refused
+  unknown map[string]int // label -> issues with it
+}
+
+// tidy the labels of the issues in the projects, only working out the
+// changes unless apply is set
+func tidyLabels(c *LabelHygieneConfig, projects []string, apply bool, creds *Config) (*labelReport, error) {
+  jql := fmt.Sprintf("project in (%s) AND labels IS NOT EMPTY ORDER BY key ASC", strings.Join(projects, ", "))
+  issues, fields, err := searchIssues(jql, c.Max, creds)
+  if err != nil {
+    return nil, err
+  }
+  report := &labelReport{unknown: map[string]int{}}
+  for i, issue := range issues {
+    labels := []string{}
+    list, _ := fieldValue(fields[i], "labels").([]interface{})
+    for _, label := range list {
+      if s, ok := label.(string); ok {
+        labels = append(labels, s)
+      }
+    }
+    fixed, unknown := c.fix(labels)
+    for _, label := range unknown {
+      report.unknown[label]++
+    }
+    if strings.Join(fixed, " ") == strings.Join(labels, " ") {
+      continue
+    }
+    change := fmt.Sprintf("%s: %s -> %s", issue.Key, strings.Join(labels, ", "), strings.Join(fixed, ", "))
+    if apply {
+      body := map[string]interface{}{"fields": map[string]interface{}{"labels": fixed}}
+      if _, err := jiraRequest("PUT", "/issue/"+issue.Key, body, creds.accountFor("edit")); err != nil {
+        logger.Print("Error fixing the labels of ", issue.Key, ": ", err)
+        report.failed++
+        change += " FAILED"
+      }
+    }
+    report.changes = append(report.changes, change)
+  }
+  return report, nil
+}
+
+func (r *labelReport) empty() bool {
+  return len(r.changes) == 0 && len(r.unknown) == 0
+}
+
+// the report as lines of text, the first 50 changes and the unknown labels
+// most used first
+func (r *labelReport) format(apply bool) string {
+  lines := []string{}
+  switch {
+  case len(r.changes) == 0:
+    lines = append(lines, "No labels needed fixing")
+  case apply:
+    lines = append(lines, fmt.Sprintf("Fixed the labels of %d issues:", len(r.changes)-r.failed))
+  default:
+    lines = append(lines, fmt.Sprintf("Would fix the labels of %d issues (dry run):", len(r.changes)))
+  }
+  for i, change := range r.changes {
+    if i == 50 {
+      lines = append(lines, fmt.Sprintf("and %d more", len(r.changes)-50))
+      break
+    }
+    lines = append(lines, "  "+change)
+  }
+  if len(r.unknown) > 0 {
+    labels := []string{}
+    for label := range r.unknown {
+      labels = append(labels, label)
+    }
+    sort.Slice(labels, func(i, j int) bool {
+      if r.unknown[labels[i]] != r.unknown[labels[j]] {
+        return r.unknown[labels[i]] > r.unknown[labels[j]]
+      }
+      return labels[i] < labels[j]
+    })
+    lines = append(lines, "Unknown labels:")
+    for _, label := range labels {
+      lines = append(lines, fmt.Sprintf("  %s (%d issues)", label, r.unknown[label]))
+    }
+  }
+  return strings.Join(lines, "\n")
+}
+
+func watchLabels(creds *Config) {
+  c := &creds.LabelHygiene
+  for ; ; time.Sleep(c.interval) {
+    // a sweep can wait while the instance is near its budget
+    if !shards.leading() || skipForQuota(creds.Url) || quota.degraded(creds.Url) {
+      continue
+    }
+    report, err := tidyLabels(c, c.Projects, c.Apply, creds)
+    if err != nil {
+      logger.Print("Error searching for labelled issues: ", err)
+    } else if !report.empty() {
+      event := trackerEvent(eventLabelReport, report.format(c.Apply))
+      event.Targets = c.Targets
+      if len(event.Targets) == 0 {
+        event.Targets = creds.Operator.Targets
+      }
+      deliver([]*Event{event})
+    }
+  }
+}
+
+// tidy the labels once and print the report, a dry run without --apply
+func labelsCommand(args []string) {
+  flags := flag.NewFlagSet("labels", flag.ExitOnError)
+  projectNames := flags.String("project", "", "The projects to tidy (default label_hygiene.projects)")
+  apply := flags.Bool("apply", false, "Change the labels instead of only listing the changes")
+  flags.Parse(args)
+  if flags.NArg() > 0 {
+    usageExit(commands["labels"].usage)
+  }
+
+  creds, _ := setup()
+  projects := creds.LabelHygiene.Projects
+  if len(*projectNames) > 0 {
+    projects = splitTags(strings.ToUpper(*projectNames))
+  }
+  if len(projects) == 0 {
+    logger.Print("No projects to tidy, set label_hygiene.projects or --project")
+    os.Exit(1)
+  }
+  report, err := tidyLabels(&creds.LabelHygiene, projects, *apply, creds)
+  if err != nil {
+    logger.Print("Error searching for labelled issues: ", err)
+    os.Exit(1)
+  }
+  fmt.Println(report.format(*apply))
+  if report.failed > 0 {
+    os.Exit(1)
+  }
+}
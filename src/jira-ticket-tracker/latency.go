@@ -0,0 +1,124 @@
+package main
+
+import (
+  "fmt"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+)
+
+const eventLatencyBudget = "latency-budget"
+
+// the end to end latency budget, from an issue being created in jira to a
+// target being notified about it. it's split into the poll delay, until the
+// tracker found the issue, the queue wait, until it started notifying the
+// target, and the action time, spent notifying it. deliveries over budget
+// are logged, and alerted on now and then if there are targets
+//
+//   latency_budget:
+//     budget: 2m
+//     targets: [ops-slack]  # optional, just logged otherwise
+//     cooldown: 15m         # between alerts for the same target
+type LatencyBudgetConfig struct {
+  Budget   string   `yaml:"budget"`
+  Targets  []string `yaml:"targets"`
+  Cooldown string   `yaml:"cooldown"`
+}
+
+var latencyStages = []string{"poll", "queue", "action"}
+
+var latencyBudget = struct {
+  sync.Mutex
+  budget   time.Duration
+  targets  []string
+  cooldown time.Duration
+  alerted  map[string]time.Time // target -> the last alert
+  over     map[string]int       // target -> deliveries over budget
+  sums     map[string]float64   // stage -> seconds, over every timed delivery
+  timed    int
+}{alerted: map[string]time.Time{}, over: map[string]int{}, sums: map[string]float64{}}
+
+func loadLatencyBudget(creds *Config) {
+  latencyBudget.budget = durationOr(creds.LatencyBudget.Budget, 0)
+  latencyBudget.targets = creds.LatencyBudget.Targets
+  latencyBudget.cooldown = durationOr(creds.LatencyBudget.Cooldown, 15*time.Minute)
+}
+
+// the stages of a created event's delivery that started at started and
+// took until now, false for the events that aren't timed
+func latencyBreakdown(event *Event, started time.Time) (map[string]time.Duration, bool) {
+  if event.Kind != eventCreated || event.Issue.Fields == nil || event.Fetched.IsZero() {
+    return nil, false
+  }
+  created, err := time.Parse(dateLayout, event.Issue.Fields.Created)
+  if err != nil {
+    return nil, false
+  }
+  return map[string]time.Duration{
+    "poll":   event.Fetched.Sub(created),
+    "queue":  started.Sub(event.Fetched),
+    "action": time.Since(started),
+  }, true
+}
+
+// check a delivery to a target against the budget
+func checkLatencyBudget(target string, event *Event, started time.Time) {
+  stages, timed := latencyBreakdown(event, started)
+  if !timed {
+    return
+  }
+  total := stages["poll"] + stages["queue"] + stages["action"]
+  latencyBudget.Lock()
+  latencyBudget.timed++
+  for stage, d := range stages {
+    latencyBudget.sums[stage] += d.Seconds()
+  }
+  if latencyBudget.budget == 0 || total <= latencyBudget.budget {
+    latencyBudget.Unlock()
+    return
+  }
+  latencyBudget.over[target]++
+  alert := len(latencyBudget.targets) > 0 && time.Since(latencyBudget.alerted[target]) >= latencyBudget.cooldown
+  if alert {
+    latencyBudget.alerted[target] = time.Now()
+  }
+  latencyBudget.Unlock()
+
+  summary := fmt.Sprintf("%s reached %s after %s, over the %s budget (poll %s, queue %s, action %s)",
+    event.Issue.Key, target, total.Truncate(time.Second), latencyBudget.budget,
+    stages["poll"].Truncate(time.Second), stages["queue"].Truncate(time.Millisecond), stages["action"].Truncate(time.Millisecond))
+  logger.Print("LATENCY: ", summary)
+  if alert {
+    event := trackerEvent(eventLatencyBudget, summary)
+    event.Targets = latencyBudget.targets
+    deliver([]*Event{event})
+  }
+}
+
+func writeLatencyMetrics(out *strings.Builder) {
+  latencyBudget.Lock()
+  defer latencyBudget.Unlock()
+  if latencyBudget.timed == 0 {
+    return
+  }
+  out.WriteString("# HELP jira_tracker_latency_stage_seconds Time spent in each stage of timed deliveries.\n")
+  out.WriteString("# TYPE jira_tracker_latency_stage_seconds summary\n")
+  for _, stage := range latencyStages {
+    fmt.Fprintf(out, "jira_tracker_latency_stage_seconds_sum{stage=%q} %g\n", stage, latencyBudget.sums[stage])
+    fmt.Fprintf(out, "jira_tracker_latency_stage_seconds_count{stage=%q} %d\n", stage, latencyBudget.timed)
+  }
+  if latencyBudget.budget == 0 {
+    return
+  }
+  out.WriteString("# HELP jira_tracker_latency_budget_exceeded_total Deliveries over the latency budget per target.\n")
+  out.WriteString("# TYPE jira_tracker_latency_budget_exceeded_total counter\n")
+  targets := []string{}
+  for target := range latencyBudget.over {
+    targets = append(targets, target)
+  }
+  sort.Strings(targets)
+  for _, target := range targets {
+    fmt.Fprintf(out, "jira_tracker_latency_budget_exceeded_total{target=%q} %d\n", target, latencyBudget.over[target])
+  }
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+  "strings"
+  "sync"
+  "time"
+)
+
+// how long a fetched linked issue is trusted before it is fetched again
+const linkCacheTTL = 5 * time.Minute
+
+// a LinkFilter requires the issue to have at least one link matching it,
+// configured per rule under `links`. e.g. "is caused by an open incident":
+//
+//   links:
+//     - relation: is caused by
+//       issuetype: [Incident]
+//       open: true
+//
+// or "blocks an issue in project OPS":
+//
+//   links:
+//     - relation: blocks
+//       project: OPS
+type LinkFilter struct {
+  Relation   string   `yaml:"relation"` // the link description as seen from this issue
+  Project    string   `yaml:"project"`
+  IssueTypes []string `yaml:"issuetype"`
+  Statuses   []string `yaml:"status"`
+  Open       bool     `yaml:"open"` // only unresolved linked issues count
+}
+
+// a link from the issue being checked to another one
+type issueLink struct {
+  relation string
+  key      string
+}
+
+// the links in an issue's raw issuelinks field, each described from the
+// point of view of the issue itself (outward "blocks", inward "is blocked by")
+func issueLinks(fields map[string]interface{}) []issueLink {
+  links := []issueLink{}
+  raw, _ := fields["issuelinks"].([]interface{})
+  for _, l := range raw {
+    link, ok := l.(map[string]interface{})
+    if !ok {
+      continue
+    }
+    if key := fieldString(link, "outwardIssue.key"); len(key) > 0 {
+      links = append(links, issueLink{fieldString(link, "type.outward"), key})
+    } else if key := fieldString(link, "inwardIssue.key"); len(key) > 0 {
+      links = append(links, issueLink{fieldString(link, "type.inward"), key})
+    }
+  }
+  return links
+}
+
+type cachedIssue struct {
+  fields  map[string]interface{}
+  fetched time.Time
+}
+
+// linked issues fetched on demand, shared by every rule
+var linkCache = struct {
+  sync.Mutex
+  issues map[string]cachedIssue
+}{issues: map[string]cachedIssue{}}
+
+func linkedIssueFields(key string, creds *Config) map[string]interface{} {
+  linkCache.Lock()
+  cached, ok := linkCache.issues[key]
+  linkCache.Unlock()
+  if ok && time.Since(cached.fetched) < linkCacheTTL {
+    return cached.fields
+  }
+
+  contents := jiraIssue(key, creds)
+  if contents == nil {
+    return nil
+  }
+  _, fields, err := parseIssue(contents)
+  if err != nil {
+    logger.Print("Error parsing linked issue ", key, ": ", err)
+    return nil
+  }
+
+  linkCache.Lock()
+  linkCache.issues[key] = cachedIssue{fields, time.Now()}
+  // drop anything expired while we hold the lock so the cache can't grow
+  // without bound on a long running tracker
+  for k, c := range linkCache.issues {
+    if time.Since(c.fetched) >= linkCacheTTL {
+      delete(linkCache.issues, k)
+    }
+  }
+  linkCache.Unlock()
+  return fields
+}
+
+func (f *LinkFilter) matches(link issueLink, creds *Config) bool {
+  if len(f.Relation) > 0 && !strings.EqualFold(f.Relation, link.relation) {
+    return false
+  }
+  if len(f.Project) > 0 && !strings.HasPrefix(link.key, strings.ToUpper(f.Project)+"-") {
+    return false
+  }
+  if len(f.IssueTypes) == 0 && len(f.Statuses) == 0 && !f.Open {
+    return true // no need to fetch the linked issue
+  }
+
+  fields := linkedIssueFields(link.key, creds)
+  if fields == nil {
+    return false
+  }
+  if !matchesAny(f.IssueTypes, fieldString(fields, "issuetype.name")) {
+    return false
+  }
+  if !matchesAny(f.Statuses, fieldString(fields, "status.name")) {
+    return false
+  }
+  if f.Open && fieldValue(fields, "resolution") != nil {
+    return false
+  }
+  return true
+}
+
+// every filter has to be satisfied by at least one of the issue's links
+func linksMatch(filters []LinkFilter, fields map[string]interface{}, creds *Config) bool {
+  if len(filters) == 0 {
+    return true
+  }
+  links := issueLinks(fields)
+  for i := range filters {
+    found := false
+    for _, link := range links {
+      if filters[i].matches(link, creds) {
+        found = true
+        break
+      }
+    }
+    if !found {
+      return false
+    }
+  }
+  return true
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+  "fmt"
+  "reflect"
+  "sort"
+  "strings"
+  "text/template/parse"
+)
+
+// check every template's references against what it's rendered with, the
+// event, or the event and .Missing for form-check comments, so a typo like
+// .Issue.Fields.Sumary fails `validate` instead of rendering as nothing or
+// an error once an issue matches. .Computed is checked against the computed
+// fields, and .Fields, `field`, `get` and `first` against jira's fields
+// when they can be fetched. a dot that can't be known, e.g. inside
+// {{with (get ..)}}, isn't checked
+type templateLinter struct {
+  creds    *Config
+  template string
+  root     reflect.Type
+  computed map[string]bool
+  problems []string
+}
+
+var (
+  eventType  = reflect.TypeOf(&Event{})
+  fieldsType = reflect.TypeOf(map[string]interface{}{})
+)
+
+// the problems in every template, sorted
+func lintTemplates(t *templateSet, creds *Config) []string {
+  t.mu.RLock()
+  text, html := t.text, t.html
+  t.mu.RUnlock()
+
+  roots := map[string]reflect.Type{}
+  for _, target := range creds.Targets {
+    if target.Type == "form-check" && len(target.Template) > 0 {
+      roots[target.Template] = reflect.TypeOf(formData{})
+    }
+  }
+  computed := map[string]bool{"instance": true, "tier": true, "errors": true, "errors_last_seen": true, "errors_url": true, "errors_users": true}
+  for name := range creds.Computed {
+    computed[name] = true
+  }
+
+  trees := map[string]*parse.Tree{}
+  if text != nil {
+    for _, tmpl := range text.Templates() {
+      trees[tmpl.Name()] = tmpl.Tree
+    }
+  }
+  if html != nil {
+    for _, tmpl := range html.Templates() {
+      trees[tmpl.Name()] = tmpl.Tree
+    }
+  }
+  problems := []string{}
+  for name, tree := range trees {
+    if tree == nil || tree.Root == nil || len(name) == 0 {
+      continue
+    }
+    root, ok := roots[strings.SplitN(name, ".", 2)[0]]
+    if !ok {
+      root = eventType
+    }
+    l := &templateLinter{creds: creds, template: name, root: root, computed: computed}
+    l.walk(tree.Root, root)
+    problems = append(problems, l.problems...)
+  }
+  sort.Strings(problems)
+  return problems
+}
+
+func (l *templateLinter) problem(format string, args ...interface{}) {
+  l.problems = append(l.problems, "template "+l.template+": "+fmt.Sprintf(format, args...))
+}
+
+func (l *templateLinter) walk(node parse.Node, dot reflect.Type) {
+  switch n := node.(type) {
+  case *parse.ListNode:
+    if n == nil {
+      return
+    }
+    for _, child := range n.Nodes {
+      l.walk(child, dot)
+    }
+  case *parse.ActionNode:
+    l.pipe(n.Pipe, dot)
+  case *parse.IfNode:
+    l.pipe(n.Pipe, dot)
+    l.walk(n.List, dot)
+    l.walk(n.ElseList, dot)
+  case *parse.RangeNode:
+    l.walk(n.List, elemType(l.pipe(n.Pipe, dot)))
+    l.walk(n.ElseList, dot)
+  case *parse.WithNode:
+    l.walk(n.List, l.pipe(n.Pipe, dot))
+    l.walk(n.ElseList, dot)
+  case *parse.TemplateNode:
+    // what's passed is checked here, the template itself on its own
+    l.pipe(n.Pipe, dot)
+  }
+}
+
+// check a pipeline, returning the type it evaluates to, nil if unknown
+func (l *templateLinter) pipe(p *parse.PipeNode, dot reflect.Type) reflect.Type {
+  if p == nil {
+    return nil
+  }
+  var last reflect.Type
+  for i, cmd := range p.Cmds {
+    last = l.command(cmd, dot)
+    if i > 0 {
+      last = nil // the result of a function
+    }
+  }
+  return last
+}
+
+func (l *templateLinter) command(cmd *parse.CommandNode, dot reflect.Type) reflect.Type {
+  if len(cmd.Args) == 0 {
+    return nil
+  }
+  if ident, ok := cmd.Args[0].(*parse.IdentifierNode); ok {
+    for _, arg := range cmd.Args[1:] {
+      l.arg(arg, dot)
+    }
+    l.function(ident.Ident, cmd.Args[1:])
+    return nil
+  }
+  t := l.arg(cmd.Args[0], dot)
+  for _, arg := range cmd.Args[1:] {
+    l.arg(arg, dot)
+  }
+  if len(cmd.Args) > 1 {
+    return nil // a method called with arguments
+  }
+  return t
+}
+
+func (l *templateLinter) arg(node parse.Node, dot reflect.Type) reflect.Type {
+  switch n := node.(type) {
+  case *parse.DotNode:
+    return dot
+  case *parse.FieldNode:
+    return l.resolve(dot, n.Ident, n.String())
+  case *parse.VariableNode:
+    if n.Ident[0] == "$" {
+      return l.resolve(l.root, n.Ident[1:], n.String())
+    }
+  case *parse.ChainNode:
+    return l.resolve(l.arg(n.Node, dot), n.Field, n.String())
+  case *parse.PipeNode:
+    return l.pipe(n, dot)
+  }
+  return nil
+}
+
+// the literal field names given to field, get and first
+func (l *templateLinter) function(name string, args []parse.Node) {
+  literal := func(i int) string {
+    if i < len(args) {
+      if s, ok := args[i].(*parse.StringNode); ok {
+        return s.Text
+      }
+    }
+    return ""
+  }
+  switch name {
+  case "field":
+    l.jiraField(literal(0), `field "`+literal(0)+`"`)
+  case "get":
+    if path := literal(1); len(path) > 0 {
+      l.jiraField(strings.SplitN(path, ".", 2)[0], `get "`+path+`"`)
+    }
+  case "first":
+    for i := 1; i < len(args); i++ {
+      if path := literal(i); len(path) > 0 {
+        l.jiraField(strings.SplitN(path, ".", 2)[0], `first "`+path+`"`)
+      }
+    }
+  }
+}
+
+func (l *templateLinter) jiraField(name, ref string) {
+  if len(name) == 0 {
+    return
+  }
+  if exists, checked := customFields.exists(name, l.creds); checked && !exists {
+    l.problem("%s: jira has no field %s", ref, name)
+  }
+}
+
+// follow a chain of field names from t, returning what it ends at
+func (l *templateLinter) resolve(t reflect.Type, idents []string, ref string) reflect.Type {
+  for _, ident := range idents {
+    if t == nil {
+      return nil
+    }
+    if method, ok := t.MethodByName(ident); ok {
+      if method.Type.NumOut() == 0 {
+        return nil
+      }
+      t = method.Type.Out(0)
+      continue
+    }
+    if t.Kind() == reflect.Ptr {
+      t = t.Elem()
+      if method, ok := reflect.PtrTo(t).MethodByName(ident); ok && method.Type.NumOut() > 0 {
+        t = method.Type.Out(0)
+        continue
+      }
+    }
+    switch t.Kind() {
+    case reflect.Struct:
+      field, ok := t.FieldByName(ident)
+      if !ok || len(field.PkgPath) > 0 {
+        l.problem("%s: %s has no field %s%s", ref, t.Name(), ident, suggest(ident, structNames(t)))
+        return nil
+      }
+      if t == eventType.Elem() && ident == "Computed" {
+        if len(idents) > 1 {
+          l.computedField(idents[1], ref)
+        }
+        return nil
+      }
+      t = field.Type
+    case reflect.Map:
+      if t == fieldsType {
+        l.jiraField(ident, ref)
+        return nil // what's in a field isn't known
+      }
+      t = t.Elem()
+    default:
+      return nil
+    }
+  }
+  return t
+}
+
+func (l *templateLinter) computedField(name, ref string) {
+  if !l.computed[name] {
+    names := []string{}
+    for known := range l.computed {
+      names = append(names, known)
+    }
+    l.problem("%s: there's no computed field %s%s", ref, name, suggest(name, names))
+  }
+}
+
+// the exported fields and methods of a struct, for suggestions
+func structNames(t reflect.Type) []string {
+  names := []string{}
+  for i := 0; i < t.NumField(); i++ {
+    if len(t.Field(i).PkgPath) == 0 {
+      names = append(names, t.Field(i).Name)
+    }
+  }
+  pointer := reflect.PtrTo(t)
+  for i := 0; i < pointer.NumMethod(); i++ {
+    names = append(names, pointer.Method(i).Name)
+  }
+  return names
+}
+
+// the element a range over t iterates over
+func elemType(t reflect.Type) reflect.Type {
+  if t == nil {
+    return nil
+  }
+  if t.Kind() == reflect.Ptr {
+    t = t.Elem()
+  }
+  switch t.Kind() {
+  case reflect.Slice, reflect.Array, reflect.Map:
+    if elem := t.Elem(); elem.Kind() != reflect.Interface {
+      return elem
+    }
+  }
+  return nil
+}
+
+// ", did you mean X?" for the closest of names, if one is close
+func suggest(name string, names []string) string {
+  best, distance := "", 3
+  for _, candidate := range names {
+    if strings.EqualFold(candidate, name) {
+      return ", did you mean " + candidate + "?"
+    }
+    if d := editDistance(strings.ToLower(name), strings.ToLower(candidate)); d < distance {
+      best, distance = candidate, d
+    }
+  }
+  if len(best) == 0 {
+    return ""
+  }
+  return ", did you mean " + best + "?"
+}
+
+func editDistance(a, b string) int {
+  previous := make([]int, len(b)+1)
+  for j := range previous {
+    previous[j] = j
+  }
+  for i := 1; i <= len(a); i++ {
+    current := make([]int, len(b)+1)
+    current[0] = i
+    for j := 1; j <= len(b); j++ {
+      cost := 1
+      if a[i-1] == b[j-1] {
+        cost = 0
+      }
+      current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+    }
+    previous = current
+  }
+  return previous[len(b)]
+}
+
+func min3(a, b, c int) int {
+  if b < a {
+    a = b
+  }
+  if c < a {
+    a = c
+  }
+  return a
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "net/url"
+  "strings"
+)
+
+// posts each message to a matrix room through the client-server api, as the
+// user whose access token it has. the room can be its id or an alias
+//
+//   targets:
+//     ops-matrix:
+//       type: matrix
+//       url: https://matrix.acme.com   # the homeserver
+//       token: syt_...                 # the bot user's access token
+//       channel: "!AbCdEf:acme.com"    # or "#ops:acme.com"
+//
+// the event id is the transaction id, so a retried send isn't posted twice
+type matrixNotifier struct {
+  homeserver string
+  token      string
+  room       string
+  transport  *targetTransport
+}
+
+func newMatrixNotifier(name string, target Target) (*matrixNotifier, error) {
+  if len(target.Url) == 0 || len(target.Token) == 0 || len(target.Channel) == 0 {
+    return nil, fmt.Errorf("a matrix target needs the homeserver's url, a token and a channel")
+  }
+  return &matrixNotifier{
+    homeserver: strings.TrimSuffix(target.Url, "/"), token: target.Token, room: target.Channel,
+    transport: newTargetTransport(name, target),
+  }, nil
+}
+
+func (n *matrixNotifier) Notify(event *Event, message string) error {
+  return n.send(event.Id, message)
+}
+
+func (n *matrixNotifier) NotifyBatch(events []*Event, messages []string) error {
+  text := fmt.Sprintf("%d issues:\n• %s", len(events), strings.Join(messages, "\n• "))
+  return n.send(fmt.Sprintf("%s-%d", events[0].Id, len(events)), text)
+}
+
+func (n *matrixNotifier) send(txn, text string) error {
+  room := n.room
+  if strings.HasPrefix(room, "#") {
+    resolved, err := n.resolveAlias(room)
+    if err != nil {
+      return err
+    }
+    room = resolved
+  }
+  body, _ := json.Marshal(map[string]string{"msgtype": "m.text", "body": text})
+  uri := n.homeserver + "/_matrix/client/v3/rooms/" + url.PathEscape(room) + "/send/m.room.message/" + url.PathEscape(txn)
+  _, err := n.request("PUT", uri, body)
+  return err
+}
+
+// the room id of an alias like #ops:acme.com
+func (n *matrixNotifier) resolveAlias(alias string) (string, error) {
+  contents, err := n.request("GET", n.homeserver+"/_matrix/client/v3/directory/room/"+url.PathEscape(alias), nil)
+  if err != nil {
+    return "", err
+  }
+  var result struct {
+    RoomId string `json:"room_id"`
+  }
+  if err := json.Unmarshal(contents, &result); err != nil || len(result.RoomId) == 0 {
+    return "", fmt.Errorf("no room for %s", alias)
+  }
+  return result.RoomId, nil
+}
+
+func (n *matrixNotifier) request(method, uri string, body []byte) ([]byte, error) {
+  req, err := http.NewRequest(method, uri, bytes.NewReader(body))
+  if err != nil {
+    return nil, err
+  }
+  req.Header.Set("Authorization", "Bearer "+n.token)
+  if body != nil {
+    req.Header.Set("Content-Type", "application/json")
+  }
+  resp, err := n.transport.httpClient().Do(req)
+  if err != nil {
+    return nil, err
+  }
+  defer resp.Body.Close()
+  contents, _ := ioutil.ReadAll(resp.Body)
+  if resp.StatusCode >= 300 {
+    var matrixError struct {
+      Error string `json:"error"`
+    }
+    json.Unmarshal(contents, &matrixError)
+    return nil, fmt.Errorf("matrix returned %s: %s", resp.Status, matrixError.Error)
+  }
+  return contents, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+  "fmt"
+  "net/http"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+)
+
+// delivery latency buckets, in seconds
+var latencyBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800}
+
+// in-memory delivery counters per target since the tracker started, served
+// in the prometheus text format at /metrics
+var deliveryMetrics = &deliveryStats{targets: map[string]*targetStats{}}
+
+type deliveryStats struct {
+  mu      sync.Mutex
+  targets map[string]*targetStats
+}
+
+type targetStats struct {
+  delivered  int
+  failed     int
+  buckets    []int // cumulative, one per latencyBuckets
+  latencySum float64
+  timed      int
+  withinSLO  int
+}
+
+func (d *deliveryStats) record(target string, latency time.Duration, timed bool, err error) {
+  d.mu.Lock()
+  defer d.mu.Unlock()
+
+  t, ok := d.targets[target]
+  if !ok {
+    t = &targetStats{buckets: make([]int, len(latencyBuckets))}
+    d.targets[target] = t
+  }
+  if err != nil {
+    t.failed++
+    return
+  }
+  t.delivered++
+  if !timed {
+    return
+  }
+  seconds := latency.Seconds()
+  t.timed++
+  t.latencySum += seconds
+  if latency <= slo.latency {
+    t.withinSLO++
+  }
+  for i, le := range latencyBuckets {
+    if seconds <= le {
+      t.buckets[i]++
+    }
+  }
+}
+
+func (d *deliveryStats) write(out *strings.Builder) {
+  d.mu.Lock()
+  defer d.mu.Unlock()
+
+  names := []string{}
+  for name := range d.targets {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+
+  out.WriteString("# HELP jira_tracker_deliveries_total Notifications sent per target.\n")
+  out.WriteString("# TYPE jira_tracker_deliveries_total counter\n")
+  for _, name := range names {
+    t := d.targets[name]
+    fmt.Fprintf(out, "jira_tracker_deliveries_total{target=%q,result=\"ok\"} %d\n", name, t.delivered)
+    fmt.Fprintf(out, "jira_tracker_deliveries_total{target=%q,result=\"failed\"} %d\n", name, t.failed)
+  }
+
+  out.WriteString("# HELP jira_tracker_delivery_latency_seconds From issue creation to delivery.\n")
+  out.WriteString("# TYPE jira_tracker_delivery_latency_seconds histogram\n")
+  for _, name := range names {
+    t := d.targets[name]
+    for i, le := range latencyBuckets {
+      fmt.Fprintf(out, "jira_tracker_delivery_latency_seconds_bucket{target=%q,le=\"%g\"} %d\n", name, le, t.buckets[i])
+    }
+    fmt.Fprintf(out, "jira_tracker_delivery_latency_seconds_bucket{target=%q,le=\"+Inf\"} %d\n", name, t.timed)
+    fmt.Fprintf(out, "jira_tracker_delivery_latency_seconds_sum{target=%q} %g\n", name, t.latencySum)
+    fmt.Fprintf(out, "jira_tracker_delivery_latency_seconds_count{target=%q} %d\n", name, t.timed)
+  }
+
+  out.WriteString("# HELP jira_tracker_delivery_slo_ratio Share of timed deliveries within the slo latency.\n")
+  out.WriteString("# TYPE jira_tracker_delivery_slo_ratio gauge\n")
+  for _, name := range names {
+    t := d.targets[name]
+    ratio := 1.0
+    if t.timed > 0 {
+      ratio = float64(t.withinSLO) / float64(t.timed)
+    }
+    fmt.Fprintf(out, "jira_tracker_delivery_slo_ratio{target=%q} %g\n", name, ratio)
+  }
+}
+
+//   GET /metrics   counters in the prometheus text format
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+  var out strings.Builder
+  deliveryMetrics.write(&out)
+  gitops.writeMetrics(&out)
+  writeWatermarkMetrics(&out)
+  writeCustomMetrics(&out)
+  writeAnomalyMetrics(&out)
+  writeLatencyMetrics(&out)
+  writeOutboxMetrics(&out)
+  writeSinkHealthMetrics(&out)
+  writeRuntimeMetrics(&out)
+  writeOutageMetrics(&out)
+  writeQuotaMetrics(&out)
+  w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+  w.Write([]byte(out.String()))
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "launchpad.net/goyaml"
+  "os"
+  "strings"
+)
+
+func init() {
+  commands["migrate-config"] = command{"migrate-config [--out=PATH]", migrateConfigCommand}
+}
+
+// spellings of the top level keys seen in old configs
+var configAliases = map[string]string{
+  "user":     "login",
+  "username": "login",
+  "pass":     "password",
+  "passwd":   "password",
+  "host":     "url",
+  "server":   "url",
+  "jira_url": "url",
+  "jiraurl":  "url",
+}
+
+var configKeys = []string{
+  "login", "password", "url", "rules", "targets", "routing", "reminders",
+  "first_response", "calendars", "computed", "operator", "catchup",
+  "templates", "templates_dir", "page_size", "http", "cache", "chaos",
+}
+
+// upgrade an old config: normalize the key spellings and the jira url, and
+// turn --project/--user into a rule so the flags are no longer needed
+func migrateConfig(old map[string]interface{}) (map[string]interface{}, []string) {
+  notes := []string{}
+  migrated := map[string]interface{}{}
+  for key, value := range old {
+    name := strings.ToLower(strings.TrimSpace(key))
+    name = strings.Replace(name, "-", "_", -1)
+    if alias, ok := configAliases[name]; ok {
+      name = alias
+    }
+    if name != key {
+      notes = append(notes, fmt.Sprintf("renamed %q to %q", key, name))
+    }
+    if !contains(configKeys, name) {
+      notes = append(notes, fmt.Sprintf("unknown key %q kept as is", key))
+    }
+    if _, ok := migrated[name]; ok {
+      notes = append(notes, fmt.Sprintf("%q is given more than once, using %q", name, key))
+    }
+    migrated[name] = value
+  }
+
+  if url, ok := migrated["url"].(string); ok {
+    fixed := strings.TrimRight(strings.TrimSpace(url), "/")
+    if len(fixed) > 0 && !strings.Contains(fixed, "/rest/api/") {
+      fixed += "/rest/api/2"
+    }
+    if fixed != url {
+      notes = append(notes, fmt.Sprintf("url %q is now %q", url, fixed))
+      migrated["url"] = fixed
+    }
+  }
+
+  if len(*project) > 0 {
+    rules, _ := migrated["rules"].([]interface{})
+    rule := flagRule()
+    migrated["rules"] = append([]interface{}{rule}, rules...)
+    notes = append(notes, fmt.Sprintf("added rule %q for --project/--user, drop the flags when running with the new config", rule.Name))
+  }
+  return migrated, notes
+}
+
+// the rule --project/--user stand for, as it's written in a config
+type flagRuleSpec struct {
+  Name    string `yaml:"name"`
+  Project string `yaml:"project"`
+  User    string `yaml:"user"`
+  Field   string `yaml:"field"`
+}
+
+func flagRule() flagRuleSpec {
+  return flagRuleSpec{Name: *project + "-" + *user, Project: *project, User: *user, Field: trackingMethod}
+}
+
+// for --print-rule: the rules list to put in the config in place of the
+// flags
+func printFlagRule() {
+  if len(*project) == 0 {
+    logger.Print("--print-rule needs the --project and --user to print a rule for")
+    os.Exit(1)
+  }
+  encoded, err := goyaml.Marshal(map[string]interface{}{"rules": []interface{}{flagRule()}})
+  if err != nil {
+    logger.Print("Error encoding the rule: ", err)
+    os.Exit(1)
+  }
+  os.Stdout.Write(encoded)
+}
+
+func migrateConfigCommand(args []string) {
+  flags := flag.NewFlagSet("migrate-config", flag.ExitOnError)
+  out := flags.String("out", "", "Where to write the new config (default stdout)")
+  flags.Parse(args)
+
+  contents, err := ioutil.ReadFile(*config)
+  if err != nil {
+    logger.Print("Error reading config file: ", err)
+    os.Exit(1)
+  }
+  var old map[string]interface{}
+  if err := goyaml.Unmarshal(contents, &old); err != nil {
+    logger.Print("Error parsing yaml: ", err)
+    os.Exit(1)
+  }
+
+  migrated, notes := migrateConfig(old)
+  encoded, err := goyaml.Marshal(migrated)
+  if err != nil {
+    logger.Print("Error encoding config: ", err)
+    os.Exit(1)
+  }
+  // make sure the result still loads before writing it anywhere
+  var check Config
+  if err := goyaml.Unmarshal(encoded, &check); err != nil {
+    logger.Print("Migrated config doesn't parse: ", err)
+    os.Exit(1)
+  }
+
+  for _, note := range notes {
+    logger.Print(note)
+  }
+  if len(*out) == 0 {
+    os.Stdout.Write(encoded)
+    return
+  }
+  if *out == *config {
+    if err := ioutil.WriteFile(*config+".bak", contents, 0600); err != nil {
+      logger.Print("Error backing up config: ", err)
+      os.Exit(1)
+    }
+  }
+  if err := ioutil.WriteFile(*out, encoded, 0600); err != nil {
+    logger.Print("Error writing config: ", err)
+    os.Exit(1)
+  }
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+  "bytes"
+  "crypto"
+  "crypto/hmac"
+  "crypto/rand"
+  "crypto/rsa"
+  "crypto/sha256"
+  "crypto/x509"
+  "encoding/base64"
+  "encoding/json"
+  "encoding/pem"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "net/url"
+  "strings"
+  "sync"
+  "time"
+)
+
+// pushes each message to the phones registered with the target, for a
+// companion app. the app registers its devices on the control api, see
+// handleDevices, and the pushes go either through a relay that holds the
+// apple and google credentials, or straight to firebase cloud messaging
+// with a service account
+//
+//   targets:
+//     oncall-phones:
+//       type: mobile
+//       mobile:
+//         relay: https://push.acme.com   # see pushToRelay
+//         token: ...                     # the relay's
+//         registration_secret: ...       # the app's, to register devices
+//     team-phones:
+//       type: mobile
+//       mobile:
+//         firebase: ./firebase-service-account.json
+//         registration_secret: ...
+//
+// devices the relay or firebase say are gone are unregistered
+type MobileConfig struct {
+  Relay              string `yaml:"relay"`
+  Token              string `yaml:"token"`
+  Firebase           string `yaml:"firebase"` // the service account's json key file
+  RegistrationSecret string `yaml:"registration_secret"`
+}
+
+// a phone a companion app registered for a target's pushes
+type MobileDevice struct {
+  Id         string    `json:"id"`
+  Target     string    `json:"target"`
+  Platform   string    `json:"platform"` // ios or android
+  Token      string    `json:"token"`    // the apns or fcm token
+  Name       string    `json:"name,omitempty"`
+  Registered time.Time `json:"registered"`
+}
+
+// what's pushed to each device
+type mobilePush struct {
+  Title    string `json:"title"`
+  Body     string `json:"body"`
+  Url      string `json:"url,omitempty"`
+  Key      string `json:"key,omitempty"` // the issue's, none for a batch
+  Priority string `json:"priority"`      // high for blockers and criticals, normal otherwise
+}
+
+type mobileNotifier struct {
+  target    string
+  config    MobileConfig
+  firebase  *firebaseAccount
+  creds     *Config
+  transport *targetTransport
+}
+
+func newMobileNotifier(name string, target Target, creds *Config) (*mobileNotifier, error) {
+  config := target.Mobile
+  if (len(config.Relay) == 0) == (len(config.Firebase) == 0) {
+    return nil, fmt.Errorf("a mobile target needs either a relay or a firebase service account")
+  }
+  if len(config.RegistrationSecret) == 0 {
+    return nil, fmt.Errorf("a mobile target needs a registration_secret, or anyone could register for its pushes")
+  }
+  n := &mobileNotifier{target: name, config: config, creds: creds, transport: newTargetTransport(name, target)}
+  if len(config.Firebase) > 0 {
+    account, err := loadFirebaseAccount(config.Firebase)
+    if err != nil {
+      return nil, err
+    }
+    n.firebase = account
+  }
+  return n, nil
+}
+
+func (n *mobileNotifier) Notify(event *Event, message string) error {
+  priority := "normal"
+  switch fieldString(event.Fields, "priority.name") {
+  case "Blocker", "Critical":
+    priority = "high"
+  }
+  return n.push(mobilePush{
+    Title: fmt.Sprintf("%s %s", event.Issue.Key, event.Kind), Body: message,
+    Url: n.creds.browseUrl(event.Issue.Key), Key: event.Issue.Key, Priority: priority,
+  })
+}
+
+func (n *mobileNotifier) NotifyBatch(events []*Event, messages []string) error {
+  push := mobilePush{Title: fmt.Sprintf("%d jira issues", len(events)), Body: "• " + strings.Join(messages, "\n• "), Priority: "normal"}
+  for _, event := range events {
+    switch fieldString(event.Fields, "priority.name") {
+    case "Blocker", "Critical":
+      push.Priority = "high"
+    }
+  }
+  return n.push(push)
+}
+
+func (n *mobileNotifier) push(push mobilePush) error {
+  devices := state.TargetDevices(n.target)
+  if len(devices) == 0 {
+    return nil // nobody's registered yet
+  }
+  var gone []string
+  var err error
+  if n.firebase != nil {
+    gone, err = n.pushToFirebase(devices, push)
+  } else {
+    gone, err = n.pushToRelay(devices, push)
+  }
+  for _, id := range gone {
+    if state.UnregisterDevice(n.target, id) {
+      logger.Print("Unregistered device ", id, " from ", n.target, ", it's no longer valid")
+    }
+  }
+  return err
+}
+
+// posts the push and its devices to the relay, which answers with the ids
+// of the devices it found are gone:
+//
+//   POST RELAY/v1/push
+//   Authorization: Bearer TOKEN
+//   {"devices": [{"id": ..., "platform": "ios", "token": ...}], "push": {"title": ..., "body": ..., "url": ..., "key": ..., "priority": "high"}}
+//
+//   {"invalid": ["DEVICE-ID"]}
+func (n *mobileNotifier) pushToRelay(devices []*MobileDevice, push mobilePush) ([]string, error) {
+  type relayDevice struct {
+    Id       string `json:"id"`
+    Platform string `json:"platform"`
+    Token    string `json:"token"`
+  }
+  request := struct {
+    Devices []relayDevice `json:"devices"`
+    Push    mobilePush    `json:"push"`
+  }{Push: push}
+  for _, d := range devices {
+    request.Devices = append(request.Devices, relayDevice{d.Id, d.Platform, d.Token})
+  }
+  body, _ := json.Marshal(request)
+  headers := map[string]string{}
+  if len(n.config.Token) > 0 {
+    headers["Authorization"] = "Bearer " + n.config.Token
+  }
+  contents, err := postForResponse(n.transport.httpClient(), strings.TrimSuffix(n.config.Relay, "/")+"/v1/push", "application/json", headers, body)
+  if err != nil {
+    return nil, err
+  }
+  var result struct {
+    Invalid []string `json:"invalid"`
+  }
+  json.Unmarshal(contents, &result)
+  return result.Invalid, nil
+}
+
+// sends the push to each device through fcm's v1 api, returning the ones
+// fcm no longer knows. an error is returned if no device could be sent to
+func (n *mobileNotifier) pushToFirebase(devices []*MobileDevice, push mobilePush) ([]string, error) {
+  client := n.transport.httpClient()
+  token, err := n.firebase.accessToken(client)
+  if err != nil {
+    return nil, err
+  }
+  uri := "https://fcm.googleapis.com/v1/projects/" + url.PathEscape(n.firebase.ProjectId) + "/messages:send"
+  data := map[string]string{"url": push.Url, "key": push.Key}
+  var gone []string
+  var lastErr error
+  sent := 0
+  for _, d := range devices {
+    body, _ := json.Marshal(map[string]interface{}{"message": map[string]interface{}{
+      "token":        d.Token,
+      "notification": map[string]string{"title": push.Title, "body": push.Body},
+      "data":         data,
+      "android":      map[string]string{"priority": push.Priority},
+      "apns":         map[string]interface{}{"headers": map[string]string{"apns-priority": map[string]string{"high": "10", "normal": "5"}[push.Priority]}},
+    }})
+    req, _ := http.NewRequest("POST", uri, bytes.NewReader(body))
+    req.Header.Set("Authorization", "Bearer "+token)
+    req.Header.Set("Content-Type", "application/json")
+    resp, err := client.Do(req)
+    if err != nil {
+      lastErr = err
+      continue
+    }
+    contents, _ := ioutil.ReadAll(resp.Body)
+    resp.Body.Close()
+    switch {
+    case resp.StatusCode < 300:
+      sent++
+    case resp.StatusCode == http.StatusNotFound || strings.Contains(string(contents), "UNREGISTERED"):
+      gone = append(gone, d.Id)
+    default:
+      lastErr = fmt.Errorf("firebase returned %s for device %s: %s", resp.Status, d.Id, strings.TrimSpace(string(contents)))
+    }
+  }
+  if sent == 0 && lastErr != nil {
+    return gone, lastErr
+  }
+  if lastErr != nil {
+    logger.Print("Error pushing to some of ", n.target, "'s devices: ", lastErr)
+  }
+  return gone, nil
+}
+
+// a google service account, and the access token it was last given
+type firebaseAccount struct {
+  ProjectId   string `json:"project_id"`
+  ClientEmail string `json:"client_email"`
+  PrivateKey  string `json:"private_key"`
+  TokenUri    string `json:"token_uri"`
+
+  key     *rsa.PrivateKey
+  mu      sync.Mutex
+  token   string
+  expires time.Time
+}
+
+func loadFirebaseAccount(path string) (*firebaseAccount, error) {
+  contents, err := ioutil.ReadFile(path)
+  if err != nil {
+    return nil, err
+  }
+  account := &firebaseAccount{}
+  if err := json.Unmarshal(contents, account); err != nil {
+    return nil, fmt.Errorf("parsing %s: %v", path, err)
+  }
+  block, _ := pem.Decode([]byte(account.PrivateKey))
+  if block == nil || len(account.ProjectId) == 0 || len(account.ClientEmail) == 0 {
+    return nil, fmt.Errorf("%s isn't a service account key", path)
+  }
+  key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+  if err != nil {
+    return nil, fmt.Errorf("the private key in %s: %v", path, err)
+  }
+  var ok bool
+  if account.key, ok = key.(*rsa.PrivateKey); !ok {
+    return nil, fmt.Errorf("the private key in %s isn't rsa", path)
+  }
+  if len(account.TokenUri) == 0 {
+    account.TokenUri = "https://oauth2.googleapis.com/token"
+  }
+  return account, nil
+}
+
+// an access token for fcm, from a jwt signed with the account's key
+func (a *firebaseAccount) accessToken(client *http.Client) (string, error) {
+  a.mu.Lock()
+  defer a.mu.Unlock()
+  if time.Until(a.expires) > time.Minute {
+    return a.token, nil
+  }
+
+  now := time.Now()
+  header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+  claims, _ := json.Marshal(map[string]interface{}{
+    "iss":   a.ClientEmail,
+    "scope": "https://www.googleapis.com/auth/firebase.messaging",
+    "aud":   a.TokenUri,
+    "iat":   now.Unix(),
+    "exp":   now.Add(time.Hour).Unix(),
+  })
+  unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+  digest := sha256.Sum256([]byte(unsigned))
+  signature, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, digest[:])
+  if err != nil {
+    return "", err
+  }
+  form := url.Values{
+    "grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+    "assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)},
+  }
+  resp, err := client.PostForm(a.TokenUri, form)
+  if err != nil {
+    return "", err
+  }
+  defer resp.Body.Close()
+  contents, _ := ioutil.ReadAll(resp.Body)
+  if resp.StatusCode != http.StatusOK {
+    return "", fmt.Errorf("google returned %s: %s", resp.Status, strings.TrimSpace(string(contents)))
+  }
+  var result struct {
+    AccessToken string `json:"access_token"`
+    ExpiresIn   int    `json:"expires_in"`
+  }
+  if err := json.Unmarshal(contents, &result); err != nil {
+    return "", err
+  }
+  a.token, a.expires = result.AccessToken, now.Add(time.Duration(result.ExpiresIn)*time.Second)
+  return a.token, nil
+}
+
+// a device as the api lists it, without all of its push token
+func (d MobileDevice) redacted() MobileDevice {
+  if len(d.Token) > 8 {
+    d.Token = "…" + d.Token[len(d.Token)-6:]
+  }
+  return d
+}
+
+// GET /devices lists the registered devices, of one target with ?target=.
+// POST registers a device, again to update its token, and DELETE
+// unregisters it:
+//
+//   {"target": "oncall-phones", "id": "alice-iphone", "platform": "ios", "token": "..."}
+func handleDevices(w http.ResponseWriter, r *http.Request) {
+  if r.Method == "GET" {
+    devices := []MobileDevice{}
+    for _, d := range state.AllDevices() {
+      if target := r.URL.Query().Get("target"); len(target) == 0 || d.Target == target {
+        devices = append(devices, d.redacted())
+      }
+    }
+    writeJSON(w, http.StatusOK, devices)
+    return
+  }
+  if r.Method != "POST" && r.Method != "DELETE" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+
+  var device MobileDevice
+  if err := json.NewDecoder(r.Body).Decode(&device); err != nil {
+    writeError(w, http.StatusBadRequest, "invalid json: "+err.Error())
+    return
+  }
+  if len(device.Target) == 0 || len(device.Id) == 0 {
+    writeError(w, http.StatusBadRequest, "target and id are required")
+    return
+  }
+  s, ok := sinks[device.Target]
+  if !ok || s.target.Type != "mobile" {
+    writeError(w, http.StatusBadRequest, "no mobile target "+device.Target)
+    return
+  }
+  // the app proves it's the target's with its registration secret
+  if !hmac.Equal([]byte("Bearer "+s.target.Mobile.RegistrationSecret), []byte(r.Header.Get("Authorization"))) {
+    writeError(w, http.StatusUnauthorized, "the target's registration secret is required")
+    return
+  }
+  if r.Method == "DELETE" {
+    if !state.UnregisterDevice(device.Target, device.Id) {
+      writeError(w, http.StatusNotFound, "no device "+device.Id+" for "+device.Target)
+      return
+    }
+    logger.Print("Unregistered device ", device.Id, " from ", device.Target)
+    writeJSON(w, http.StatusOK, device)
+    return
+  }
+
+  if device.Platform != "ios" && device.Platform != "android" {
+    writeError(w, http.StatusBadRequest, "platform has to be ios or android")
+    return
+  }
+  if len(device.Token) == 0 {
+    writeError(w, http.StatusBadRequest, "token is required")
+    return
+  }
+  device.Registered = time.Now()
+  state.RegisterDevice(&device)
+  logger.Print("Registered device ", device.Id, " for ", device.Target)
+  writeJSON(w, http.StatusOK, device.redacted())
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "os"
+  "sort"
+  "strings"
+  "time"
+)
+
+func init() {
+  commands["my-tickets"] = command{"my-tickets [--send] USER", myTicketsCommand}
+}
+
+// a weekly email to each of the recipients, users, groups or roles, of the
+// open issues they reported or are assigned, the highest priority first and
+// then the oldest. it's sent through an email target's server and from
+// address, to each user's own address
+//
+//   my_tickets:
+//     recipients: [alice, "group:payments-team"]
+//     target: ops-email
+//     schedule: "0 9 * * mon"   # the default
+//     jql: project in (PAY, OPS) # optional, to narrow the issues down
+//     max: 50                    # issues in a report, 50 by default
+//
+// nobody with no open issues gets an email
+type MyTicketsConfig struct {
+  Recipients []string `yaml:"recipients"`
+  Target     string   `yaml:"target"`
+  Schedule   string   `yaml:"schedule"`
+  Jql        string   `yaml:"jql"`
+  Max        int      `yaml:"max"`
+
+  cron *cronSchedule
+}
+
+func (c *MyTicketsConfig) enabled() bool {
+  return len(c.Recipients) > 0
+}
+
+func loadMyTickets(creds *Config) {
+  c := &creds.MyTickets
+  if len(c.Schedule) == 0 {
+    c.Schedule = "0 9 * * mon"
+  }
+  if c.Max <= 0 {
+    c.Max = 50 // my-tickets uses it without recipients too
+  }
+  if !c.enabled() {
+    return
+  }
+  var err error
+  if c.cron, err = parseCron(c.Schedule); err != nil {
+    logger.Print("Invalid my_tickets.schedule: ", err)
+    os.Exit(1)
+  }
+  if target, ok := creds.Targets[c.Target]; !ok || target.Type != "email" {
+    logger.Print("my_tickets.target has to be an email target, not ", c.Target)
+    os.Exit(1)
+  }
+}
+
+// the report of a user's open issues, empty if they have none
+func myTicketsReport(user string, creds *Config) (string, error) {
+  config := creds.MyTickets
+  who := jqlQuote(user)
+  jql := fmt.Sprintf("(assignee = %s OR reporter = %s) AND resolution = EMPTY", who, who)
+  if len(config.Jql) > 0 {
+    jql += " AND (" + config.Jql + ")"
+  }
+  issues, fields, err := searchIssues(jql+" ORDER BY priority DESC, created ASC", config.Max, creds)
+  if err != nil || len(issues) == 0 {
+    return "", err
+  }
+  lines := []string{fmt.Sprintf("Your %d open issues, the highest priority and oldest first:", len(issues)), ""}
+  for i, issue := range issues {
+    roles := []string{}
+    for field, role := range map[string]string{"assignee": "assigned", "reporter": "reported"} {
+      name := fieldString(fields[i], field+".name")
+      if len(name) == 0 {
+        name = fieldString(fields[i], field+".accountId")
+      }
+      if strings.EqualFold(name, user) {
+        roles = append(roles, role)
+      }
+    }
+    sort.Strings(roles) // assigned and reported
+    lines = append(lines, fmt.Sprintf("%s [%s] %s\n  %s, %s, open for %s", issue.Key, fieldString(fields[i], "priority.name"),
+      issue.Fields.Summary, fieldString(fields[i], "status.name"), strings.Join(roles, " and "), since(issue.Fields.Created)))
+  }
+  if len(issues) == config.Max {
+    lines = append(lines, "", fmt.Sprintf("Only the first %d are listed.", config.Max))
+  }
+  return strings.Join(lines, "\n"), nil
+}
+
+// email a user their report, returning where it went
+func emailMyTickets(user, report string, creds *Config) (string, error) {
+  s, ok := sinks[creds.MyTickets.Target]
+  if !ok {
+    return "", fmt.Errorf("no email target %s", creds.MyTickets.Target)
+  }
+  n, ok := s.notifier.(*emailNotifier)
+  if !ok {
+    return "", fmt.Errorf("%s isn't an email target", creds.MyTickets.Target)
+  }
+  email, err := userEmail(user, creds)
+  if err != nil {
+    return "", err
+  }
+  plain := *n
+  plain.html = false // the report is text, whatever the target's template is
+  return email, plain.send([]string{email}, "Your open issues", report)
+}
+
+// email each recipient their report
+func sendMyTickets(creds *Config) {
+  sent := 0
+  for _, user := range expandPrincipals(creds.MyTickets.Recipients, "", creds) {
+    report, err := myTicketsReport(user, creds)
+    if err != nil {
+      logger.Print("Error finding the open issues of ", user, ": ", err)
+      continue
+    }
+    if len(report) == 0 {
+      continue
+    }
+    if _, err := emailMyTickets(user, report, creds); err != nil {
+      logger.Print("Error sending the open issues of ", user, ": ", err)
+      continue
+    }
+    sent++
+  }
+  logger.Print("Sent ", sent, " open issue reports")
+}
+
+// send the reports on the schedule, carrying on from the last one after a
+// restart so none is sent twice
+func sendMyTicketsWeekly(creds *Config) {
+  last := state.MyTicketsSent()
+  if last.IsZero() {
+    last = time.Now()
+    state.SetMyTicketsSent(last)
+  }
+  for {
+    next := creds.MyTickets.cron.next(last)
+    time.Sleep(time.Until(next))
+    if shards.leading() {
+      sendMyTickets(creds)
+    }
+    last = time.Now()
+    state.SetMyTicketsSent(last)
+  }
+}
+
+// print a user's report, or email it to them with --send
+func myTicketsCommand(args []string) {
+  flags := flag.NewFlagSet("my-tickets", flag.ExitOnError)
+  send := flags.Bool("send", false, "Email the report to the user through my_tickets.target")
+  flags.Parse(args)
+  if flags.NArg() != 1 {
+    usageExit(commands["my-tickets"].usage)
+  }
+
+  creds, _ := setup()
+  user := flags.Arg(0)
+  report, err := myTicketsReport(user, creds)
+  if err != nil {
+    logger.Print("Error finding the open issues of ", user, ": ", err)
+    os.Exit(1)
+  }
+  if len(report) == 0 {
+    fmt.Println(user, "has no open issues")
+    return
+  }
+  if !*send {
+    fmt.Println(report)
+    return
+  }
+  if !creds.MyTickets.enabled() {
+    logger.Print("--send needs my_tickets configured")
+    os.Exit(1)
+  }
+  email, err := emailMyTickets(user, report, creds)
+  if err != nil {
+    logger.Print("Error sending the report to ", user, ": ", err)
+    os.Exit(1)
+  }
+  fmt.Println("sent to", email)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+  "fmt"
+  "os"
+  "strings"
+  "time"
+)
+
+const eventNeedsInfoExpired = "needs-info-expired"
+
+// how long issues can wait on their reporter, configured under
+// `needs_info`. days are business days in the project's calendar, counted
+// from when the tracker first saw the issue in the status. a comment from
+// the reporter or a status change stops the clock
+//
+//   needs_info:
+//     status: Needs Info
+//     warn_after: 3          # comment a warning after this many days
+//     close_after: 5
+//     action: close          # or escalate
+//     transition: Closed     # for close
+//     targets: [support-leads]  # sent a needs-info-expired event
+//     warning: needs-info-warning  # optional templates for the comments
+//     closing: needs-info-closing
+type NeedsInfoConfig struct {
+  Status     string   `yaml:"status"`
+  WarnAfter  float64  `yaml:"warn_after"`
+  CloseAfter float64  `yaml:"close_after"`
+  Action     string   `yaml:"action"`
+  Transition string   `yaml:"transition"`
+  Targets    []string `yaml:"targets"`
+  Warning    string   `yaml:"warning"`
+  Closing    string   `yaml:"closing"`
+}
+
+const needsInfoInterval = time.Minute
+
+const (
+  needsInfoWarned  = "warned"
+  needsInfoExpired = "expired"
+)
+
+func (c *NeedsInfoConfig) validate() error {
+  if c.CloseAfter <= 0 {
+    return fmt.Errorf("close_after is required")
+  }
+  if c.WarnAfter >= c.CloseAfter {
+    return fmt.Errorf("warn_after must be before close_after")
+  }
+  switch c.Action {
+  case "close":
+    if len(c.Transition) == 0 {
+      return fmt.Errorf("a transition is required to close issues")
+    }
+  case "escalate":
+    if len(c.Targets) == 0 {
+      return fmt.Errorf("targets are required to escalate issues")
+    }
+  default:
+    return fmt.Errorf("action must be close or escalate, not %q", c.Action)
+  }
+  return nil
+}
+
+// whether the reporter has commented since t
+func reporterResponded(key string, since time.Time, creds *Config) (bool, error) {
+  contents := jiraIssue(key, creds)
+  if contents == nil {
+    return false, fmt.Errorf("fetching %s failed", key)
+  }
+  issue, _, err := parseIssue(contents)
+  if err != nil {
+    return false, err
+  }
+  if issue.Fields.Reporter == nil {
+    return false, nil
+  }
+  comments, err := issueComments(key, creds)
+  if err != nil {
+    return false, err
+  }
+  for _, c := range comments {
+    created, err := time.Parse(dateLayout, c.Created)
+    if err == nil && created.After(since) && c.Author.Name == issue.Fields.Reporter.Name {
+      return true, nil
+    }
+  }
+  return false, nil
+}
+
+func (c *NeedsInfoConfig) comment(name, fallback string, event *Event) string {
+  if len(name) > 0 {
+    comment, err := templates.Render(name, event)
+    if err == nil {
+      return comment
+    }
+    logger.Print("Error rendering template ", name, ": ", err)
+  }
+  return fallback
+}
+
+func chaseNeedsInfo(creds *Config, c chan []*Event) {
+  config := creds.NeedsInfo
+  if err := config.validate(); err != nil {
+    logger.Print("Invalid needs_info: ", err)
+    os.Exit(1)
+  }
+
+  for {
+    time.Sleep(needsInfoInterval)
+    producers.RLock()
+
+    now := time.Now()
+    rules := map[string]*Rule{}
+    for _, rule := range configuredPollerRules() {
+      rules[rule.Name] = rule
+    }
+    for key, tracked := range state.TrackedIssues() {
+      if !shards.follows(rules, tracked.Rule) {
+        continue
+      }
+      snapshot := tracked.Snapshot
+      if snapshot == nil {
+        continue
+      }
+      waiting := strings.EqualFold(snapshot.Status, config.Status) && len(snapshot.Resolution) == 0
+      if !waiting {
+        if len(tracked.NeedsInfoSince) > 0 {
+          state.SetNeedsInfo(key, "")
+        }
+        continue
+      }
+      if len(tracked.NeedsInfoSince) == 0 {
+        state.SetNeedsInfo(key, now.Format(time.RFC3339))
+        continue
+      }
+      since, err := time.Parse(time.RFC3339, tracked.NeedsInfoSince)
+      if err != nil {
+        continue
+      }
+      days := calendarFor(creds, key).BusinessDays(since, now)
+      stage := tracked.NeedsInfoStage
+      if stage == needsInfoExpired || days < config.WarnAfter || (days < config.CloseAfter && stage == needsInfoWarned) {
+        continue
+      }
+
+      responded, err := reporterResponded(key, since, creds)
+      if err != nil {
+        logger.Print("Error checking ", key, " for a response: ", err)
+        continue
+      }
+      if responded {
+        // they answered but it's still waiting, start the clock again
+        state.SetNeedsInfo(key, now.Format(time.RFC3339))
+        continue
+      }
+
+      contents := jiraIssue(key, creds)
+      if contents == nil {
+        continue
+      }
+      issue, fields, err := parseIssue(contents)
+      if err != nil {
+        logger.Print("Error parsing issue ", key, ": ", err)
+        continue
+      }
+      event := newEvent(eventNeedsInfoExpired, issue, fields)
+      event.Rule = tracked.Rule
+      event.Detail = fmt.Sprintf("no response from the reporter in %.0f business days", days)
+      event.Targets = config.Targets
+
+      if days < config.CloseAfter {
+        text := fmt.Sprintf("We still need more information to look into this. "+
+          "If we don't hear back it will be %s in %.0f business days.",
+          map[string]string{"close": "closed", "escalate": "escalated"}[config.Action], config.CloseAfter-days)
+        if err := addComment(key, config.comment(config.Warning, text, event), creds); err != nil {
+          logger.Print("Error warning ", key, ": ", err)
+          continue
+        }
+        state.SetNeedsInfoStage(key, needsInfoWarned)
+        continue
+      }
+
+      if config.Action == "close" {
+        text := "Closing this since we didn't get the information we needed. Feel free to reopen it with the details."
+        if err := addComment(key, config.comment(config.Closing, text, event), creds); err != nil {
+          logger.Print("Error commenting on ", key, ": ", err)
+          continue
+        }
+        if err := transitionIssue(key, config.Transition, creds); err != nil {
+          logger.Print("Error closing ", key, ": ", err)
+          continue
+        }
+        logger.Print("Closed ", key, " after waiting on the reporter")
+      }
+      state.SetNeedsInfoStage(key, needsInfoExpired)
+      if len(event.Targets) > 0 {
+        c <- []*Event{event}
+      }
+    }
+    producers.RUnlock()
+  }
+}
@@ -0,0 +1,880 @@
+package main
+
+import (
+  "bytes"
+  "encoding/csv"
+  "encoding/json"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "os"
+  "strings"
+  "sync"
+  "time"
+)
+
+// the configured notifier targets by name, built at startup in main
+var sinks = map[string]*sink{}
+
+// a Target is a place notifications can be sent, configured under `targets`
+//
+//   targets:
+//     ops-slack:
+//       type: slack
+//       url: https://hooks.slack.com/services/...
+type Target struct {
+  Type     string `yaml:"type"`     // slack, webhook, email, sms, matrix, xmpp, ntfy, gotify, mobile, csv, jira-comment, assign, form-check, security-level, watchers, duplicate-link, desktop, nats, kafka, syslog, journald, splunk, elasticsearch, clickhouse or log
+  Url      string `yaml:"url"`
+  Path     string `yaml:"path"`     // for csv
+  // for slack, post with a bot token through the web api instead of a
+  // webhook url, which read receipts need. for splunk, the hec token, for
+  // elasticsearch, an api key, for matrix, the access token, and for ntfy
+  // and gotify, the topic's or the application's token
+  Token   string `yaml:"token"`
+  Channel string `yaml:"channel"`
+  // for slack, send block kit messages with buttons (assign, transition
+  // and snooze, all by default) handled by the slack app, see SlackAppConfig
+  Blocks      bool     `yaml:"blocks"`
+  Buttons     []string `yaml:"buttons"`
+  Transitions []string `yaml:"transitions"` // a transition button for each
+  // for slack with a token, reply to the first message about an issue in
+  // its thread instead of posting each update to the channel
+  Thread bool `yaml:"thread"`
+  // for slack with a token, strike through the first message about an
+  // issue when it's resolved
+  StrikeResolved bool `yaml:"strike_resolved"`
+  Template string `yaml:"template"` // the named template to render messages with
+  Locale   string `yaml:"locale"`   // e.g. ja, for the template's translation
+  // send this many or more events from one poll in a single call, for
+  // notifiers that support it. 0 never batches
+  Batch int `yaml:"batch"`
+  // for assign, who to pick from, see AssignConfig
+  Assign AssignConfig `yaml:"assign"`
+  // for watchers, who to add as watchers, see WatchersConfig
+  Watchers WatchersConfig `yaml:"watchers"`
+  // for duplicate-link, where to look for duplicates, see DuplicatesConfig
+  Duplicates DuplicatesConfig `yaml:"duplicates"`
+  // for form-check, the details each project requires, see FormConfig
+  Form FormConfig `yaml:"form"`
+  // for security-level, the level to set and, optionally, what the summary
+  // or description has to match for it to be set
+  Level   string `yaml:"level"`
+  Pattern string `yaml:"pattern"`
+  // for jira-comment, who to comment as instead of the tracker's account:
+  // reporter, assignee or an account id. see ImpersonationConfig
+  OnBehalfOf string `yaml:"on_behalf_of"`
+  // for jira-comment, who can see the comments, see CommentVisibility
+  CommentVisibility CommentVisibility `yaml:"comment_visibility"`
+  // for ntfy and gotify, the push's priority by the issue's, see
+  // pushNotifier
+  Priorities map[string]int `yaml:"priorities"`
+  // for email, sms, xmpp and mobile, see EmailConfig, SMSConfig, XMPPConfig
+  // and MobileConfig
+  Email  EmailConfig  `yaml:"email"`
+  SMS    SMSConfig    `yaml:"sms"`
+  XMPP   XMPPConfig   `yaml:"xmpp"`
+  Mobile MobileConfig `yaml:"mobile"`
+  // the targets to try, in order, with the events this one fails to send
+  Fallback []string `yaml:"fallback"`
+  // escalate messages nobody has seen, see ReceiptConfig
+  Receipt ReceiptConfig `yaml:"receipt"`
+  // what the target is allowed to see of an issue, see FieldPolicy
+  Fields FieldPolicy `yaml:"fields"`
+  // for the targets that change jira, only plan the changes, to be made
+  // with apply. see PlanConfig
+  Planned bool `yaml:"planned"`
+  // for the targets that change jira, make each change only once it's
+  // approved in chat. see ApprovalConfig
+  RequiresApproval bool `yaml:"requires_approval"`
+  // only send issues the team behind the target can see in jira
+  VisibleTo Visibility `yaml:"visible_to"`
+  // the user a personal target, like their phone, belongs to, whose do not
+  // disturb it respects. see DNDConfig
+  Owner string `yaml:"owner"`
+  // for webhooks, the version of the payloads, the latest by default
+  EventVersion int `yaml:"event_version"`
+  // for webhooks, cloudevents to wrap the payloads in a cloudevent, none
+  // not to. cloudevents.all by default
+  Envelope string `yaml:"envelope"`
+  // for webhooks, sign the payloads so the receiver can tell they're from
+  // the tracker, see SigningConfig
+  Signing SigningConfig `yaml:"signing"`
+  // for webhooks, sms, email, nats and kafka, the cas, client certificate
+  // and proxy to connect with, see TLSConfig
+  TLS   TLSConfig `yaml:"tls"`
+  Proxy string    `yaml:"proxy"`
+  // for nats, the subject to publish to, for kafka, the topic, and for
+  // both the payloads' encoding, json, avro or protobuf
+  Subject  string `yaml:"subject"`
+  Topic    string `yaml:"topic"`
+  Encoding string `yaml:"encoding"`
+  // for syslog, the facility, and for syslog and journald, the severity
+  // by priority, see syslogNotifier
+  Facility string            `yaml:"facility"`
+  Severity map[string]string `yaml:"severity"`
+  // for splunk and elasticsearch, the index to write to, see indexName
+  Index string `yaml:"index"`
+  // for clickhouse, the table to insert into and how often, see
+  // clickhouseNotifier
+  Table string `yaml:"table"`
+  Flush string `yaml:"flush"`
+}
+
+type Notifier interface {
+  Notify(event *Event, message string) error
+}
+
+// a BatchNotifier can also send several events in one call, e.g. one slack
+// message listing them all instead of one message each
+type BatchNotifier interface {
+  Notifier
+  NotifyBatch(events []*Event, messages []string) error
+}
+
+// returned by NotifyBatch when only some of the events failed, e.g. the
+// digests some recipients were sent. failed are the events' indexes
+type partialBatchError struct {
+  failed []int
+  err    error
+}
+
+func (e *partialBatchError) Error() string { return e.err.Error() }
+
+// a sink is a configured target along with the notifier that sends to it
+type sink struct {
+  name     string
+  target   Target
+  notifier Notifier
+  health   sinkHealth
+  creds    *Config
+}
+
+func newSinks(creds *Config) map[string]*sink {
+  s := map[string]*sink{}
+  for name, target := range creds.Targets {
+    if target.Fields.Anonymize && len(target.Fields.Salt) == 0 {
+      // unsalted, the hashes of every username could be looked up
+      logger.Print("Invalid target ", name, ": fields.anonymize needs a salt")
+      os.Exit(1)
+    }
+    var notifier Notifier
+    switch target.Type {
+    case "slack":
+      notifier = &slackNotifier{
+        name: name, url: target.Url, token: target.Token, channel: target.Channel,
+        blocks: target.Blocks, buttons: target.Buttons, transitions: target.Transitions,
+        thread: target.Thread, strike: target.StrikeResolved,
+      }
+    case "webhook":
+      notifier = &webhookNotifier{
+        url: target.Url, version: targetEventVersion(name, target), envelope: targetEnvelope(name, target), signer: newWebhookSigner(name, target.Signing),
+        transport: newTargetTransport(name, target),
+      }
+    case "email":
+      notifier = &emailNotifier{
+        config: target.Email, html: templates.IsHTML(target.Template), pixel: target.Receipt.Pixel, creds: creds,
+        transport: newTargetTransport(name, target),
+      }
+    case "sms":
+      notifier = &smsNotifier{config: target.SMS, transport: newTargetTransport(name, target)}
+    case "ntfy", "gotify":
+      n, err := newPushNotifier(name, target, creds)
+      if err != nil {
+        logger.Print("Invalid target ", name, ": ", err)
+        os.Exit(1)
+      }
+      notifier = n
+    case "mobile":
+      n, err := newMobileNotifier(name, target, creds)
+      if err != nil {
+        logger.Print("Invalid target ", name, ": ", err)
+        os.Exit(1)
+      }
+      notifier = n
+    case "matrix":
+      n, err := newMatrixNotifier(name, target)
+      if err != nil {
+        logger.Print("Invalid target ", name, ": ", err)
+        os.Exit(1)
+      }
+      notifier = n
+    case "xmpp":
+      n, err := newXMPPNotifier(name, target)
+      if err != nil {
+        logger.Print("Invalid target ", name, ": ", err)
+        os.Exit(1)
+      }
+      notifier = n
+    case "jira-comment":
+      if err := target.CommentVisibility.validate(); err != nil {
+        logger.Print("Invalid target ", name, ": ", err)
+        os.Exit(1)
+      }
+      notifier = &jiraCommentNotifier{creds: creds, onBehalfOf: target.OnBehalfOf, visibility: target.CommentVisibility}
+    case "csv":
+      notifier = &csvNotifier{path: target.Path}
+    case "assign":
+      notifier = newAssignNotifier(name, target.Assign, creds)
+    case "form-check":
+      n, err := newFormNotifier(name, target, creds)
+      if err != nil {
+        logger.Print("Invalid target ", name, ": ", err)
+        os.Exit(1)
+      }
+      notifier = n
+    case "security-level":
+      n, err := newSecurityLevelNotifier(target, creds)
+      if err != nil {
+        logger.Print("Invalid target ", name, ": ", err)
+        os.Exit(1)
+      }
+      notifier = n
+    case "watchers":
+      n, err := newWatchersNotifier(name, target.Watchers, creds)
+      if err != nil {
+        logger.Print("Invalid target ", name, ": ", err)
+        os.Exit(1)
+      }
+      notifier = n
+    case "duplicate-link":
+      n, err := newDuplicateLinkNotifier(name, target.Duplicates, creds)
+      if err != nil {
+        logger.Print("Invalid target ", name, ": ", err)
+        os.Exit(1)
+      }
+      notifier = n
+    case "nats":
+      n, err := newNatsNotifier(name, target)
+      if err != nil {
+        logger.Print("Invalid target ", name, ": ", err)
+        os.Exit(1)
+      }
+      notifier = n
+    case "kafka":
+      n, err := newKafkaNotifier(name, target)
+      if err != nil {
+        logger.Print("Invalid target ", name, ": ", err)
+        os.Exit(1)
+      }
+      notifier = n
+    case "syslog":
+      n, err := newSyslogNotifier(name, target)
+      if err != nil {
+        logger.Print("Invalid target ", name, ": ", err)
+        os.Exit(1)
+      }
+      notifier = n
+    case "splunk":
+      n, err := newSplunkNotifier(name, target)
+      if err != nil {
+        logger.Print("Invalid target ", name, ": ", err)
+        os.Exit(1)
+      }
+      notifier = n
+    case "elasticsearch", "opensearch":
+      n, err := newElasticNotifier(name, target)
+      if err != nil {
+        logger.Print("Invalid target ", name, ": ", err)
+        os.Exit(1)
+      }
+      notifier = n
+    case "clickhouse":
+      n, err := newClickhouseNotifier(name, target)
+      if err != nil {
+        logger.Print("Invalid target ", name, ": ", err)
+        os.Exit(1)
+      }
+      notifier = n
+    case "journald":
+      notifier = newJournaldNotifier(name, target)
+    case "desktop":
+      notifier = newDesktopNotifier(name)
+    case "log", "":
+      notifier = &logNotifier{name: name}
+    default:
+      logger.Print("Unknown type ", target.Type, " for target ", name)
+      continue
+    }
+    s[name] = &sink{name: name, target: target, notifier: notifier, creds: creds}
+    if target.Receipt.enabled() && !s[name].tracksReceipts() {
+      // they'd all be escalated as unseen
+      logger.Print("Target ", name, " can't tell when its messages are seen, only slack with a token and html email with a pixel can, so it doesn't track receipts")
+    }
+  }
+  return s
+}
+
+// render the message for a sink, falling back to the plain text form if
+// the sink has no template or it fails to render. jira-comment targets post
+// the rule's canned response if it has one
+func (s *sink) message(event *Event) string {
+  if s.target.Type == "jira-comment" && event.response != nil {
+    message, err := responses.Render(event)
+    if err == nil {
+      return message
+    }
+    logger.Print("Error rendering canned response ", event.response.Name, " for ", s.name, ": ", err)
+  }
+  if len(s.target.Template) == 0 {
+    return eventMessage(event)
+  }
+  localized := *event
+  localized.Locale = s.target.Locale
+  name := templates.Localized(s.target.Template, s.target.Locale)
+  message, err := templates.Render(name, &localized)
+  if err != nil {
+    logger.Print("Error rendering template ", name, " for ", s.name, ": ", err)
+    return eventMessage(event)
+  }
+  return message
+}
+
+// send each event to its targets, batching the events going to the same
+// target if it opted into it and there are enough of them. security issues
+// go first, see security.go, issues in projects in incident mode go into
+// its summary, see incidents.go, and snoozed issues aren't sent at all.
+// rules' chains run after, see chain.go
+func deliver(events []*Event) {
+  pipeline.begin(events)
+  defer pipeline.finish(events)
+  publishEvents(events)
+  events = holdForIncidents(deliverSecurity(events))
+  names := []string{}
+  byTarget := map[string][]*Event{}
+  chained := []*Event{}
+  for _, event := range events {
+    if store != nil && issueSnoozed(event.Issue.Key) {
+      logger.Print("Not sending ", event.Kind, " for ", event.Issue.Key, ", it's snoozed")
+      pipeline.step(event, PipelineStep{Stage: "snoozed"})
+      continue
+    }
+    if event.chain != nil {
+      chained = append(chained, event)
+    }
+    for _, name := range event.Targets {
+      if _, ok := byTarget[name]; !ok {
+        names = append(names, name)
+      }
+      byTarget[name] = append(byTarget[name], event)
+    }
+  }
+
+  failures := map[*Event]int{}
+  for _, name := range names {
+    s, ok := sinks[name]
+    if !ok {
+      logger.Print("Unknown target ", name)
+      continue
+    }
+    for _, event := range s.send(byTarget[name]) {
+      failures[event]++
+    }
+  }
+  // a new issue no target got isn't taken as sent, so the next search of
+  // its window, such as a backfill or another replica's, sends it again
+  undelivered := []*Event{}
+  for event, failed := range failures {
+    if failed == len(event.Targets) {
+      undelivered = append(undelivered, event)
+    }
+  }
+  if store != nil {
+    forgetCreated(undelivered)
+  }
+  for _, event := range chained {
+    runChain(event)
+  }
+}
+
+// send the events, then whatever failed down the fallback chain, returning
+// what failed everywhere. the events for a target in the outbox are queued
+// there instead, see outbox.go. planned and gated targets' are held for
+// review, see hold
+func (s *sink) send(events []*Event) []*Event {
+  if failed, held := s.hold(events); held {
+    return failed
+  }
+  if outbox.holds(s.name) {
+    err := outbox.Queue(s.name, events)
+    if err == nil {
+      return nil
+    }
+    logger.Print("Error queueing ", len(events), " events for ", s.name, " in the outbox, sending them: ", err)
+  }
+  failed := s.attempt(events)
+  for _, name := range s.target.Fallback {
+    if len(failed) == 0 {
+      return nil
+    }
+    next, ok := sinks[name]
+    if !ok {
+      logger.Print("Unknown fallback target ", name, " for ", s.name)
+      continue
+    }
+    logger.Print("Falling back from ", s.name, " to ", name, " for ", len(failed), " events")
+    for _, event := range failed {
+      pipeline.step(event, PipelineStep{Stage: "fallback", Target: name, Detail: "from " + s.name})
+    }
+    failed = next.attempt(failed)
+  }
+  if len(failed) > 0 && len(s.target.Fallback) > 0 {
+    logger.Print("Giving up on ", len(failed), " events for ", s.name, " after every fallback failed")
+  } else if len(failed) > 0 {
+    logger.Print("Giving up on ", len(failed), " events for ", s.name, ", it has no fallback")
+  }
+  return failed
+}
+
+// a planned target records its changes instead of making them, see
+// plan.go, and a gated one asks for approval, see approvals.go. returns
+// whether the sink holds events, and the ones it couldn't
+func (s *sink) hold(events []*Event) ([]*Event, bool) {
+  if s.target.Planned {
+    planChanges(s, events)
+    return nil, true
+  }
+  if s.target.RequiresApproval {
+    return requestApprovals(s, events), true
+  }
+  return nil, false
+}
+
+// send the events to this sink only, returning the ones that failed. a sink
+// that keeps failing is skipped for a while, or disabled, see sinkHealth
+func (s *sink) attempt(events []*Event) []*Event {
+  if !s.health.available() {
+    // failed like any other delivery, for the fallbacks and the counts,
+    // without counting against the sink's health again
+    err := fmt.Errorf("%s is failing and skipped for now", s.name)
+    logger.Print("Not sending ", len(events), " events to ", s.name, ": ", err)
+    for _, event := range events {
+      recordDelivery(s.name, event, err)
+      pipeline.step(event, PipelineStep{Stage: "skipped", Target: s.name, Detail: err.Error()})
+    }
+    return events
+  }
+  events = s.undisturbed(s.visible(events))
+  batcher, ok := s.notifier.(BatchNotifier)
+  if ok && s.target.Batch > 0 && len(events) >= s.target.Batch {
+    redacted := make([]*Event, len(events))
+    messages := make([]string, len(events))
+    for i, event := range events {
+      redacted[i] = s.target.Fields.redact(event)
+      messages[i] = s.message(redacted[i])
+    }
+    started := time.Now()
+    err := batcher.NotifyBatch(redacted, messages)
+    s.recordHealth(err)
+    errs := make([]error, len(events))
+    if partial, ok := err.(*partialBatchError); ok {
+      for _, i := range partial.failed {
+        errs[i] = partial.err
+      }
+    } else {
+      for i := range errs {
+        errs[i] = err
+      }
+    }
+    failed := []*Event{}
+    for i, event := range events {
+      recordDelivery(s.name, event, errs[i])
+      pipeline.delivery(s, event, redacted[i], messages[i], errs[i])
+      if errs[i] == nil {
+        checkLatencyBudget(s.name, event, started)
+      } else {
+        failed = append(failed, event)
+      }
+    }
+    if err != nil {
+      logger.Print("Error notifying ", s.name, " about ", len(failed), " of ", len(events), " issues: ", err)
+      return failed
+    }
+    return nil
+  }
+
+  failed := []*Event{}
+  tracker, _ := s.notifier.(receiptNotifier)
+  tracked := s.tracksReceipts()
+  for _, event := range events {
+    // fallbacks get the original event, to redact by their own policy
+    sent := s.target.Fields.redact(event)
+    started := time.Now()
+    message := s.message(sent)
+    var err error
+    if tracked {
+      receipt := newReceipt(s.name, sent)
+      if err = tracker.NotifyTracked(sent, message, receipt); err == nil {
+        state.AddReceipt(receipt)
+      }
+    } else {
+      err = s.notifier.Notify(sent, message)
+    }
+    s.recordHealth(err)
+    recordDelivery(s.name, event, err)
+    pipeline.delivery(s, event, sent, message, err)
+    if err != nil {
+      logger.Print("Error notifying ", s.name, " about ", event.Issue.Key, ": ", err)
+      failed = append(failed, event)
+    } else {
+      checkLatencyBudget(s.name, event, started)
+    }
+  }
+  return failed
+}
+
+// the events for issues the target's team can see, if it has a check
+func (s *sink) visible(events []*Event) []*Event {
+  if !s.target.VisibleTo.enabled() {
+    return events
+  }
+  visible := []*Event{}
+  for _, event := range events {
+    if event.Issue.Key == trackerKey || s.target.VisibleTo.allows(event.Issue.Key, s.creds) {
+      visible = append(visible, event)
+    } else {
+      logger.Print("Not sending ", event.Issue.Key, " to ", s.name, ", its team can't see it")
+      pipeline.step(event, PipelineStep{Stage: "invisible", Target: s.name, Detail: "its team can't see the issue"})
+    }
+  }
+  return visible
+}
+
+func postJSON(url string, body interface{}) error {
+  return postBody(url, "application/json", nil, body)
+}
+
+// posts body as json with a content type and extra headers
+func postBody(url, contentType string, headers map[string]string, body interface{}) error {
+  contents, err := json.Marshal(body)
+  if err != nil {
+    return err
+  }
+  return postContents(http.DefaultClient, url, contentType, headers, contents)
+}
+
+func postContents(client *http.Client, url, contentType string, headers map[string]string, contents []byte) error {
+  _, err := postForResponse(client, url, contentType, headers, contents)
+  return err
+}
+
+// posts and returns the response's body, up to a megabyte of it
+func postForResponse(client *http.Client, url, contentType string, headers map[string]string, contents []byte) ([]byte, error) {
+  req, err := http.NewRequest("POST", url, bytes.NewReader(contents))
+  if err != nil {
+    return nil, err
+  }
+  req.Header.Set("Content-Type", contentType)
+  for name, value := range headers {
+    req.Header.Set(name, value)
+  }
+  resp, err := client.Do(req)
+  if err != nil {
+    return nil, err
+  }
+  defer resp.Body.Close()
+  if resp.StatusCode >= 300 {
+    return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+  }
+  return ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+type logNotifier struct {
+  name string
+}
+
+func (n *logNotifier) Notify(event *Event, message string) error {
+  logger.Print(n.name, ": ", message)
+  return nil
+}
+
+// posts to a slack incoming webhook, or through the web api when a bot
+// token and channel are given. with blocks the message is sent as block
+// kit, with buttons, see slackBlocks
+type slackNotifier struct {
+  name        string
+  url         string
+  token       string
+  channel     string
+  blocks      bool
+  buttons     []string
+  transitions []string
+  // post updates to an issue as replies to the first message about it,
+  // and strike that message through when the issue is resolved. both need
+  // the web api
+  thread bool
+  strike bool
+}
+
+func (n *slackNotifier) Notify(event *Event, message string) error {
+  _, err := n.post(event, message)
+  return err
+}
+
+// post a message, about the event if there is one
+func (n *slackNotifier) post(event *Event, text string) (slackMessage, error) {
+  body := n.body(event, text)
+  if len(n.token) == 0 {
+    return slackMessage{}, postJSON(n.url, body)
+  }
+  remembered := (n.thread || n.strike) && event != nil && event.Issue.Key != trackerKey
+  if remembered {
+    if first, ok := state.SlackThread(event.Issue.Key, n.name); ok {
+      n.restyle(event, first)
+      if n.thread {
+        body["channel"], body["thread_ts"] = first.Message.Channel, first.Message.Ts
+      }
+      return slackPost(n.token, body)
+    }
+  }
+  posted, err := slackPost(n.token, body)
+  if err == nil && remembered {
+    state.SetSlackThread(event.Issue.Key, n.name, posted, text)
+  }
+  return posted, err
+}
+
+// the message posted, to the webhook or the channel
+func (n *slackNotifier) body(event *Event, text string) map[string]interface{} {
+  body := map[string]interface{}{"text": text}
+  if n.blocks && event != nil {
+    body["blocks"] = slackBlocks(event, text, n.buttons, n.transitions)
+  }
+  if len(n.token) > 0 {
+    body["channel"] = n.channel
+  }
+  return body
+}
+
+// strike through the first message about an issue once it's resolved, so
+// the channel doesn't show a stale open alert, and put it back if the
+// issue is reopened
+func (n *slackNotifier) restyle(event *Event, first SlackThread) {
+  if !n.strike {
+    return
+  }
+  var text string
+  switch event.Kind {
+  case eventResolved:
+    text = slackStrike(first.Text) + "\n✅ Resolved " + event.Detail
+  case eventReopened:
+    text = first.Text
+  default:
+    return
+  }
+  if err := slackUpdate(n.token, first.Message, text); err != nil {
+    logger.Print("Error updating the first message about ", event.Issue.Key, " in ", n.name, ": ", err)
+  }
+}
+
+func (n *slackNotifier) NotifyBatch(events []*Event, messages []string) error {
+  text := fmt.Sprintf("%d issues:\n• %s", len(events), strings.Join(messages, "\n• "))
+  _, err := n.post(nil, text)
+  return err
+}
+
+// posts the event as json to an arbitrary url
+type webhookNotifier struct {
+  url       string
+  version   int            // of the payloads
+  envelope  string         // cloudevents to wrap them, see cloudevents.go
+  signer    *webhookSigner // nil when the payloads aren't signed
+  transport *targetTransport
+  // called with what would be posted instead of posting it, see preview.go
+  dryRun func(contentType string, headers map[string]string, contents []byte)
+}
+
+// posts body as json, signed if the target has a secret
+func (n *webhookNotifier) post(contentType string, headers map[string]string, body interface{}) error {
+  _, err := n.postForResponse(contentType, headers, body)
+  return err
+}
+
+func (n *webhookNotifier) postForResponse(contentType string, headers map[string]string, body interface{}) ([]byte, error) {
+  contents, err := json.Marshal(body)
+  if err != nil {
+    return nil, err
+  }
+  if n.signer != nil {
+    if headers == nil {
+      headers = map[string]string{}
+    }
+    n.signer.sign(headers, contents)
+  }
+  if n.dryRun != nil {
+    n.dryRun(contentType, headers, contents)
+    return nil, nil
+  }
+  return postForResponse(n.transport.httpClient(), n.url, contentType, headers, contents)
+}
+
+// WebhookPayload is what webhook targets are posted for each event, in
+// the latest version, see eventversion.go
+type WebhookPayload struct {
+  Version    int               `json:"version"`
+  Time       time.Time         `json:"time"` // when it was sent
+  Kind       string            `json:"kind"`
+  Rule       string            `json:"rule,omitempty"`
+  Key        string            `json:"key"`
+  Summary    string            `json:"summary"`
+  Detail     string            `json:"detail"`
+  Message    string            `json:"message"`
+  Computed   map[string]string `json:"computed"`
+  Annotation *Annotation       `json:"annotation,omitempty"`
+  Correlations []Correlation   `json:"correlations,omitempty"`
+}
+
+func webhookPayload(event *Event, message string) WebhookPayload {
+  return WebhookPayload{
+    Version:    eventVersion,
+    Time:       time.Now(),
+    Kind:       event.Kind,
+    Rule:       event.Rule,
+    Key:        event.Issue.Key,
+    Summary:    event.Issue.Fields.Summary,
+    Detail:     event.Detail,
+    Message:    message,
+    Computed:   event.Computed,
+    Annotation: event.Annotation,
+    Correlations: event.Correlations,
+  }
+}
+
+func (n *webhookNotifier) Notify(event *Event, message string) error {
+  payload := eventPayload(event, message, n.version)
+  if err := checkPayload(payload, n.version); err != nil {
+    return err
+  }
+  if n.envelope == envelopeCloudEvents {
+    return n.postCloudEvent(event, payload)
+  }
+  return n.post("application/json", nil, payload)
+}
+
+// as Notify, with the receiver's response for chains. cloudevents have none
+func (n *webhookNotifier) NotifyResult(event *Event, message string) (interface{}, error) {
+  payload := eventPayload(event, message, n.version)
+  if err := checkPayload(payload, n.version); err != nil {
+    return nil, err
+  }
+  if n.envelope == envelopeCloudEvents {
+    return nil, n.postCloudEvent(event, payload)
+  }
+  contents, err := n.postForResponse("application/json", nil, payload)
+  return parseResponse(contents), err
+}
+
+// a batch is posted as a json array of the single event payloads
+func (n *webhookNotifier) NotifyBatch(events []*Event, messages []string) error {
+  payloads := make([]interface{}, len(events))
+  for i, event := range events {
+    payloads[i] = eventPayload(event, messages[i], n.version)
+    if err := checkPayload(payloads[i], n.version); err != nil {
+      return err
+    }
+  }
+  if n.envelope == envelopeCloudEvents {
+    return n.postCloudEvents(events, payloads)
+  }
+  return n.post("application/json", nil, payloads)
+}
+
+// appends a row per event to a csv file
+type csvNotifier struct {
+  path string
+  mu   sync.Mutex
+}
+
+func (n *csvNotifier) Notify(event *Event, message string) error {
+  return n.NotifyBatch([]*Event{event}, []string{message})
+}
+
+func (n *csvNotifier) NotifyBatch(events []*Event, messages []string) error {
+  n.mu.Lock()
+  defer n.mu.Unlock()
+
+  file, err := os.OpenFile(n.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+  if err != nil {
+    return err
+  }
+  defer file.Close()
+
+  w := csv.NewWriter(file)
+  now := time.Now().Format(time.RFC3339)
+  for i, event := range events {
+    row := []string{now, event.Kind, event.Issue.Key, event.Issue.Fields.Summary, event.Detail, messages[i]}
+    for _, name := range computedFieldNames() {
+      row = append(row, event.Computed[name])
+    }
+    row = append(row, strings.Join(event.Annotation.tags(), " "))
+    w.Write(row)
+  }
+  w.Flush()
+  return w.Error()
+}
+
+// comments the message on the issue itself, e.g. to thank the reporter
+// when it is resolved
+type jiraCommentNotifier struct {
+  creds      *Config
+  onBehalfOf string
+  visibility CommentVisibility
+}
+
+// who can see a comment, all users by default. restricting automation notes
+// to a project role or a group keeps them from service desk customers
+//
+//   comment_visibility:
+//     type: role        # or group
+//     value: Service Desk Team
+type CommentVisibility struct {
+  Type  string `yaml:"type"`
+  Value string `yaml:"value"`
+}
+
+func (v CommentVisibility) validate() error {
+  switch v.Type {
+  case "":
+    return nil
+  case "role", "group":
+    if len(v.Value) == 0 {
+      return fmt.Errorf("comment_visibility needs the %s's name as its value", v.Type)
+    }
+    return nil
+  }
+  return fmt.Errorf("comment_visibility's type is role or group, not %s", v.Type)
+}
+
+// a comment's body for the api, restricted if the visibility is set
+func (v CommentVisibility) comment(body string) map[string]interface{} {
+  comment := map[string]interface{}{"body": body}
+  if len(v.Type) > 0 {
+    comment["visibility"] = map[string]string{"type": v.Type, "value": v.Value}
+  }
+  return comment
+}
+
+func (n *jiraCommentNotifier) Notify(event *Event, message string) error {
+  _, err := n.NotifyResult(event, message)
+  return err
+}
+
+// the comment jira created, with its id, for chains
+func (n *jiraCommentNotifier) NotifyResult(event *Event, message string) (interface{}, error) {
+  contents, err := jiraRequest("POST", "/issue/"+event.Issue.Key+"/comment", n.visibility.comment(message), n.account(event))
+  return parseResponse(contents), err
+}
+
+// the account to comment on the event's issue with
+func (n *jiraCommentNotifier) account(event *Event) *Config {
+  creds := n.creds.accountFor("comment")
+  if len(n.onBehalfOf) > 0 {
+    creds = creds.actingAs(onBehalfOf(n.onBehalfOf, event))
+  }
+  return creds
+}
+
+func addComment(key, body string, creds *Config) error {
+  _, err := jiraRequest("POST", "/issue/"+key+"/comment", map[string]string{"body": body}, creds.accountFor("comment"))
+  return err
+}
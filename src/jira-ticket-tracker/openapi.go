@@ -0,0 +1,197 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "reflect"
+  "strings"
+)
+
+func init() {
+  commands["openapi"] = command{"openapi", openapiCommand}
+}
+
+// an operation of the control API, for the openapi document. request and
+// response are values of the types encoded, nil for no body
+type apiOperation struct {
+  method   string
+  path     string
+  summary  string
+  query    []string
+  request  interface{}
+  response interface{}
+  status   int    // of a success, 200 by default
+  content  string // of the response, json by default
+  operator bool   // needs the operator's token
+}
+
+var apiOperations = []apiOperation{
+  {method: "GET", path: "/status", summary: "Every rule's status", response: []RuleStatus{}},
+  {method: "GET", path: "/sinks", summary: "Every target's health", response: []SinkStatus{}},
+  {method: "POST", path: "/sinks/{target}/enable", summary: "Enable a target that was disabled for failing", response: SinkStatus{}},
+  {method: "POST", path: "/poll", summary: "Poll every rule and watched issue now", response: map[string]string{}, status: http.StatusAccepted},
+  {method: "GET", path: "/pause", summary: "What's paused", response: PauseStatus{}},
+  {method: "POST", path: "/pause", summary: "Pause a rule, or every rule without one", request: pauseRequest{}, response: PauseStatus{}, operator: true},
+  {method: "DELETE", path: "/pause", summary: "Resume a rule, or everything without one", request: pauseRequest{}, response: PauseStatus{}, operator: true},
+  {method: "GET", path: "/plan", summary: "The planned targets' changes waiting to be applied", response: []PlannedChange{}},
+  {method: "POST", path: "/plan", summary: "Apply planned changes, or every one without ids", request: planRequest{}, response: PlanResult{}, operator: true},
+  {method: "DELETE", path: "/plan", summary: "Discard planned changes, or every one without ids", request: planRequest{}, response: PlanResult{}, operator: true},
+  {method: "GET", path: "/approvals", summary: "The gated targets' changes waiting to be approved in chat", response: []ApprovalRequest{}},
+  {method: "GET", path: "/events", summary: "Stream events as they're delivered, one per line", query: []string{"rule", "kind"}, response: StreamedEvent{}, content: "application/x-ndjson"},
+  {method: "POST", path: "/replay", summary: "Re-send past events from the history", request: replayRequest{}, response: []ReplayedEvent{}, operator: true},
+  {method: "GET", path: "/subscriptions", summary: "Every subscription, the targets by issue", response: map[string][]string{}},
+  {method: "POST", path: "/subscriptions", summary: "Subscribe a target to an issue", request: subscription{}, response: subscription{}},
+  {method: "DELETE", path: "/subscriptions", summary: "Unsubscribe a target from an issue", request: subscription{}, response: subscription{}},
+  {method: "GET", path: "/dnd", summary: "Everyone's do not disturb, one user's with user", query: []string{"user"}, response: []DNDPreferences{}},
+  {method: "POST", path: "/dnd", summary: "Set a user's quiet hours and muted projects", request: DNDPreferences{}, response: DNDPreferences{}},
+  {method: "DELETE", path: "/dnd", summary: "Clear a user's do not disturb", request: DNDPreferences{}, response: DNDPreferences{}},
+  {method: "GET", path: "/devices", summary: "The phones registered for mobile targets, of one with target", query: []string{"target"}, response: []MobileDevice{}},
+  {method: "POST", path: "/devices", summary: "Register a phone for a mobile target's pushes", request: MobileDevice{}, response: MobileDevice{}},
+  {method: "DELETE", path: "/devices", summary: "Unregister a phone", request: MobileDevice{}, response: MobileDevice{}},
+  {method: "GET", path: "/grep", summary: "Search the text of every issue the tracker has seen", query: []string{"q", "project", "limit", "syntax"}, response: []GrepHit{}},
+  {method: "GET", path: "/annotations", summary: "The annotations, of one issue with key", query: []string{"key"}, response: map[string]*Annotation{}},
+  {method: "POST", path: "/annotations", summary: "Add a note and/or tags to an issue", request: annotationRequest{}, response: Annotation{}},
+  {method: "DELETE", path: "/annotations", summary: "Remove tags from an issue, or everything", request: annotationRequest{}, response: annotationRequest{}},
+  {method: "GET", path: "/receipts", summary: "The messages waiting to be seen", response: []*Receipt{}},
+  {method: "GET", path: "/receipts/{id}.gif", summary: "An email open pixel, marks the message seen", content: "image/gif"},
+  {method: "GET", path: "/slo", summary: "The delivery report for a week, this one by default", query: []string{"week"}, response: sloResponse{}},
+  {method: "GET", path: "/metrics", summary: "Counters in the prometheus text format", content: "text/plain"},
+  {method: "GET", path: "/traces/{key}", summary: "How the rules' polls judged an issue, oldest first", response: []EvaluationTrace{}},
+  {method: "GET", path: "/gitops", summary: "How syncing the rules from git has gone", response: GitOpsStatus{}},
+  {method: "GET", path: "/fleet", summary: "The fleet's agents and the searches waiting for one", response: map[string]interface{}{}},
+  {method: "POST", path: "/fleet/lease", summary: "An agent asking for a search, 204 if there is none", request: struct {
+    Agent string `json:"agent"`
+  }{}, response: fleetJob{}},
+  {method: "POST", path: "/fleet/result", summary: "An agent sending back what a search found", request: fleetResult{}, status: http.StatusNoContent},
+  {method: "GET", path: "/shards", summary: "The replicas that are up and which of them polls each rule", response: map[string]interface{}{}},
+  {method: "GET", path: "/incidents", summary: "The projects in incident mode", response: []Incident{}},
+  {method: "POST", path: "/incidents", summary: "Start incident mode for a project", request: incidentRequest{}, status: http.StatusNoContent},
+  {method: "DELETE", path: "/incidents", summary: "End incident mode for a project", request: incidentRequest{}, status: http.StatusNoContent},
+  {method: "GET", path: "/wallboard", summary: "The wallboard page", content: "text/html"},
+  {method: "GET", path: "/wallboard/data", summary: "What the wallboard's panels show", response: map[string]interface{}{}},
+  {method: "GET", path: "/feeds/{rule}.atom", summary: "An atom feed of the rule's newest issues", content: "application/atom+xml"},
+  {method: "GET", path: "/ical/{rule}.ics", summary: "A calendar of the due dates and sla deadlines of the rule's open issues", content: "text/calendar"},
+  {method: "POST", path: "/slack/actions", summary: "Slack's interactivity requests, for the buttons", status: http.StatusNoContent},
+  {method: "POST", path: "/sentry", summary: "A sentry integration webhook", request: map[string]interface{}{}, response: map[string][]string{}},
+  {method: "POST", path: "/rollbar", summary: "A rollbar webhook", query: []string{"token"}, request: map[string]interface{}{}, response: map[string][]string{}},
+  {method: "POST", path: "/alertmanager", summary: "An alertmanager webhook", request: map[string]interface{}{}, response: map[string]int{}},
+  {method: "POST", path: "/webhooks/jira/{name}", summary: "A jira instance's webhook", request: map[string]interface{}{}, response: map[string]int{}},
+  {method: "GET", path: "/openapi.json", summary: "This document", response: map[string]interface{}{}},
+  {method: "GET", path: "/schemas/{name}.json", summary: "The json schema for the config or events", response: map[string]interface{}{}},
+  {method: "GET", path: "/schemas/{version}/{name}.json", summary: "A version of the json schema, e.g. v1", response: map[string]interface{}{}},
+}
+
+// the openapi 3 document describing apiOperations
+func openapiDocument() map[string]interface{} {
+  b := newSchemaBuilder("json", "#/components/schemas/")
+  b.defs["Error"] = map[string]interface{}{
+    "type": "object", "required": []string{"error"},
+    "properties": map[string]interface{}{"error": map[string]interface{}{"type": "string"}},
+  }
+  errorResponse := map[string]interface{}{
+    "description": "An error",
+    "content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"}}},
+  }
+
+  paths := map[string]interface{}{}
+  for _, op := range apiOperations {
+    operation := map[string]interface{}{
+      "summary":     op.summary,
+      "operationId": operationId(op),
+    }
+    parameters := []interface{}{}
+    for _, segment := range strings.Split(op.path, "/") {
+      if strings.HasPrefix(segment, "{") {
+        name := strings.TrimPrefix(segment[:strings.Index(segment, "}")], "{")
+        parameters = append(parameters, map[string]interface{}{"name": name, "in": "path", "required": true, "schema": map[string]string{"type": "string"}})
+      }
+    }
+    for _, name := range op.query {
+      parameters = append(parameters, map[string]interface{}{"name": name, "in": "query", "schema": map[string]string{"type": "string"}})
+    }
+    if len(parameters) > 0 {
+      operation["parameters"] = parameters
+    }
+    if op.request != nil {
+      operation["requestBody"] = map[string]interface{}{
+        "required": true,
+        "content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": b.schema(reflect.TypeOf(op.request))}},
+      }
+    }
+
+    status, content := op.status, op.content
+    if status == 0 {
+      status = http.StatusOK
+    }
+    if len(content) == 0 {
+      content = "application/json"
+    }
+    success := map[string]interface{}{"description": http.StatusText(status)}
+    if op.response != nil {
+      success["content"] = map[string]interface{}{content: map[string]interface{}{"schema": b.schema(reflect.TypeOf(op.response))}}
+    } else if status != http.StatusNoContent {
+      success["content"] = map[string]interface{}{content: map[string]interface{}{"schema": map[string]string{"type": "string"}}}
+    }
+    operation["responses"] = map[string]interface{}{fmt.Sprint(status): success, "default": errorResponse}
+    if op.operator {
+      operation["security"] = []interface{}{map[string]interface{}{"operator": []string{}}}
+    }
+
+    item, ok := paths[op.path].(map[string]interface{})
+    if !ok {
+      item = map[string]interface{}{}
+      paths[op.path] = item
+    }
+    item[strings.ToLower(op.method)] = operation
+  }
+
+  return map[string]interface{}{
+    "openapi": "3.0.3",
+    "info": map[string]interface{}{
+      "title":       "jira-ticket-tracker control API",
+      "version":     "1",
+      "description": "Served by a tracker started with --listen.",
+    },
+    "paths":      paths,
+    "components": map[string]interface{}{
+      "schemas":         b.defs,
+      "securitySchemes": map[string]interface{}{"operator": map[string]string{"type": "http", "scheme": "bearer", "description": "operator.token"}},
+    },
+  }
+}
+
+// e.g. getStatus, postFleetLease, getTracesByKey
+func operationId(op apiOperation) string {
+  id := strings.ToLower(op.method)
+  for _, segment := range strings.Split(op.path, "/") {
+    segment = strings.TrimSuffix(segment, ".gif")
+    if strings.HasPrefix(segment, "{") {
+      segment = "by-" + strings.Trim(segment, "{}")
+    }
+    for _, part := range strings.Split(segment, "-") {
+      if len(part) > 0 {
+        id += strings.ToUpper(part[:1]) + part[1:]
+      }
+    }
+  }
+  return id
+}
+
+//   GET /openapi.json   this document
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+  if r.Method != "GET" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+  writeJSON(w, http.StatusOK, openapiDocument())
+}
+
+// print the document, e.g. to generate a client from without a tracker
+func openapiCommand(args []string) {
+  if len(args) > 0 {
+    usageExit(commands["openapi"].usage)
+  }
+  contents, _ := json.MarshalIndent(openapiDocument(), "", "  ")
+  fmt.Println(string(contents))
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "net/url"
+  "strings"
+  "sync"
+  "time"
+)
+
+const eventOrphaned = "orphaned"
+
+// finds the open issues nobody can pick up, configured under `orphans`:
+// those assigned to a deactivated or deleted user, or in a component the
+// project no longer has
+//
+//   orphans:
+//     projects: [OPS, PAY]
+//     interval: 1h
+//     reassign: ops-triage   # optional, who gets the deactivated users' issues
+//     component: Triage      # optional, what replaces the missing components
+//     targets: [ops-leads]   # sent an orphaned event, the operator's by default
+//
+// an issue is only sent once while it stays orphaned, until a restart
+type OrphansConfig struct {
+  Projects  []string `yaml:"projects"`
+  Interval  string   `yaml:"interval"`
+  Reassign  string   `yaml:"reassign"`
+  Component string   `yaml:"component"`
+  Targets   []string `yaml:"targets"`
+  Max       int      `yaml:"max"`
+
+  interval time.Duration
+}
+
+func (c *OrphansConfig) enabled() bool {
+  return len(c.Projects) > 0
+}
+
+func loadOrphans(creds *Config) {
+  c := &creds.Orphans
+  c.interval = durationOr(c.Interval, time.Hour)
+  if c.Max <= 0 {
+    c.Max = 1000
+  }
+  for i, project := range c.Projects {
+    c.Projects[i] = strings.ToUpper(project)
+  }
+}
+
+// the issues and why they were orphaned, so they're only sent once
+var orphans = struct {
+  sync.Mutex
+  reported map[string]string
+}{reported: map[string]string{}}
+
+// whether the user can still be assigned issues. a user jira doesn't know
+// any more is as good as deactivated
+func userActive(user, by string, creds *Config) (bool, error) {
+  req, err := newJiraRequest("GET", "/user?"+by+"="+url.QueryEscape(user), nil, creds)
+  if err != nil {
+    return false, err
+  }
+  resp, err := sendJiraRequest(req, creds)
+  if err != nil {
+    return false, fmt.Errorf("Error calling %s: %v", req.URL, err)
+  }
+  defer drainAndClose(resp.Body)
+  if resp.StatusCode == http.StatusNotFound {
+    return false, nil
+  }
+  if resp.StatusCode >= 300 {
+    return false, fmt.Errorf("GET %s returned %s", req.URL, resp.Status)
+  }
+  var u struct {
+    Active bool `json:"active"`
+  }
+  err = json.NewDecoder(resp.Body).Decode(&u)
+  return u.Active, err
+}
+
+// the ids of the project's components
+func projectComponents(project string, creds *Config) (map[string]bool, error) {
+  contents, err := jiraRequest("GET", "/project/"+url.PathEscape(project)+"/components", nil, creds)
+  if err != nil {
+    return nil, err
+  }
+  var list []struct {
+    Id string `json:"id"`
+  }
+  if err := json.Unmarshal(contents, &list); err != nil {
+    return nil, err
+  }
+  ids := map[string]bool{}
+  for _, c := range list {
+    ids[c.Id] = true
+  }
+  return ids, nil
+}
+
+// why the issue is orphaned, empty if it isn't, whether its assignee is
+// deactivated and the components it has that are gone
+func orphanReason(fields map[string]interface{}, active func(string, string) (bool, error),
+  components map[string]bool) (reason string, deactivated bool, missing []string) {
+  reasons := []string{}
+  id, by := fieldString(fields, "assignee.accountId"), "accountId"
+  if len(id) == 0 {
+    id, by = fieldString(fields, "assignee.name"), "username"
+  }
+  if len(id) > 0 {
+    if ok, err := active(id, by); err != nil {
+      logger.Print("Error looking up ", id, ": ", err)
+    } else if !ok {
+      _, name := recordAssignee(fields)
+      deactivated = true
+      reasons = append(reasons, "assigned to "+name+", who is deactivated")
+    }
+  }
+  if components != nil {
+    list, _ := fieldValue(fields, "components").([]interface{})
+    for _, c := range list {
+      c, _ := c.(map[string]interface{})
+      if id := fieldString(c, "id"); len(id) > 0 && !components[id] {
+        missing = append(missing, id)
+        reasons = append(reasons, "in component "+fieldString(c, "name")+", which no longer exists")
+      }
+    }
+  }
+  return strings.Join(reasons, " and "), deactivated, missing
+}
+
+// look for orphaned issues in the projects, sending and fixing the ones
+// that weren't before
+func sweepOrphans(creds *Config) {
+  c := &creds.Orphans
+  users := map[string]bool{} // looked up this sweep
+  active := func(user, by string) (bool, error) {
+    if ok, known := users[user]; known {
+      return ok, nil
+    }
+    ok, err := userActive(user, by, creds)
+    if err == nil {
+      users[user] = ok
+    }
+    return ok, err
+  }
+  seen := map[string]bool{}
+  for _, project := range c.Projects {
+    components, err := projectComponents(project, creds)
+    if err != nil {
+      logger.Print("Error listing the components of ", project, ": ", err)
+      components = nil // only check the assignees
+    }
+    jql := fmt.Sprintf("project = %s AND resolution = EMPTY AND (assignee IS NOT EMPTY OR component IS NOT EMPTY)", project)
+    issues, fields, err := searchIssues(jql, c.Max, creds)
+    if err != nil {
+      logger.Print("Error searching for orphaned issues in ", project, ": ", err)
+      continue
+    }
+    for i, issue := range issues {
+      reason, deactivated, missing := orphanReason(fields[i], active, components)
+      if len(reason) == 0 {
+        continue
+      }
+      seen[issue.Key] = true
+      orphans.Lock()
+      known := orphans.reported[issue.Key] == reason
+      orphans.reported[issue.Key] = reason
+      orphans.Unlock()
+      if !known {
+        orphaned(issue.Key, reason, deactivated, missing, newEvent(eventOrphaned, issue, fields[i]), creds)
+      }
+    }
+  }
+  orphans.Lock()
+  for key := range orphans.reported {
+    if !seen[key] {
+      delete(orphans.reported, key) // adopted since
+    }
+  }
+  orphans.Unlock()
+}
+
+// send the orphaned issue and hand it to the triage queue
+func orphaned(key, reason string, deactivated bool, missing []string, event *Event, creds *Config) {
+  c := &creds.Orphans
+  logger.Print("ORPHANED: ", key, " is ", reason)
+  event.Detail = reason
+  event.Targets = c.Targets
+  if len(event.Targets) == 0 {
+    event.Targets = creds.Operator.Targets
+  }
+  deliver([]*Event{event})
+
+  if len(c.Reassign) > 0 && deactivated {
+    if err := assignIssue(key, c.Reassign, creds); err != nil {
+      logger.Print("Error reassigning ", key, " to ", c.Reassign, ": ", err)
+    } else {
+      logger.Print("Reassigned ", key, " to ", c.Reassign)
+    }
+  }
+  if len(c.Component) > 0 && len(missing) > 0 {
+    update := []map[string]interface{}{}
+    for _, id := range missing {
+      update = append(update, map[string]interface{}{"remove": map[string]string{"id": id}})
+    }
+    update = append(update, map[string]interface{}{"add": map[string]string{"name": c.Component}})
+    body := map[string]interface{}{"update": map[string]interface{}{"components": update}}
+    if _, err := jiraRequest("PUT", "/issue/"+key, body, creds.accountFor("edit")); err != nil {
+      logger.Print("Error moving ", key, " to ", c.Component, ": ", err)
+    }
+  }
+}
+
+func watchOrphans(creds *Config) {
+  for {
+    // a sweep can wait while the instance is near its budget
+    if shards.leading() && !skipForQuota(creds.Url) && !quota.degraded(creds.Url) {
+      sweepOrphans(creds)
+    }
+    time.Sleep(creds.Orphans.interval)
+  }
+}
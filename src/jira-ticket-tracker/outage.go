@@ -0,0 +1,151 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "strings"
+  "sync"
+  "time"
+)
+
+const atlassianStatusUrl = "https://jira-software.status.atlassian.com/api/v2/status.json"
+
+// pauses every poll while jira has a declared outage, configured under
+// `status_page`, instead of logging a failed search for each rule each time
+//
+//   status_page:
+//     atlassian: true        # jira cloud's status page, or
+//     url: https://status.example.com/api/v2/status.json
+//     interval: 1m
+//     indicators: [major, critical]
+//
+// the url is read as a statuspage.io status, where the indicators listed
+// (major and critical by default) are an outage. a url answering with
+// anything else is only in an outage while it answers with a 5xx. the
+// windows stay open meanwhile, so the first poll after it catches up
+type StatusPageConfig struct {
+  Atlassian  bool     `yaml:"atlassian"`
+  Url        string   `yaml:"url"`
+  Interval   string   `yaml:"interval"`
+  Indicators []string `yaml:"indicators"`
+
+  interval time.Duration
+}
+
+func (c *StatusPageConfig) enabled() bool {
+  return c.Atlassian || len(c.Url) > 0
+}
+
+func loadStatusPage(creds *Config) {
+  c := &creds.StatusPage
+  if c.Atlassian && len(c.Url) == 0 {
+    c.Url = atlassianStatusUrl
+  }
+  c.interval = durationOr(c.Interval, time.Minute)
+  if len(c.Indicators) == 0 {
+    c.Indicators = []string{"major", "critical"}
+  }
+}
+
+// the outage being waited out, empty if there's none
+var outage = struct {
+  sync.Mutex
+  declared string
+  since    time.Time
+  skipped  int // polls skipped for outages
+}{}
+
+// whether polls should wait for the outage to end, counting the poll
+// skipped if so
+func skipForOutage() bool {
+  outage.Lock()
+  defer outage.Unlock()
+  if len(outage.declared) == 0 {
+    return false
+  }
+  outage.skipped++
+  return true
+}
+
+func jiraOutage() string {
+  outage.Lock()
+  defer outage.Unlock()
+  return outage.declared
+}
+
+// what the status page says is wrong, empty if jira is up
+func checkStatusPage(c *StatusPageConfig, client *http.Client) (string, error) {
+  resp, err := client.Get(c.Url)
+  if err != nil {
+    return "", err
+  }
+  defer drainAndClose(resp.Body)
+  var status struct {
+    Status struct {
+      Indicator   string `json:"indicator"`
+      Description string `json:"description"`
+    } `json:"status"`
+  }
+  if json.NewDecoder(resp.Body).Decode(&status) != nil || len(status.Status.Indicator) == 0 {
+    if resp.StatusCode >= 500 {
+      return c.Url + " returned " + resp.Status, nil
+    }
+    if resp.StatusCode >= 300 {
+      return "", fmt.Errorf("%s returned %s", c.Url, resp.Status)
+    }
+    return "", nil
+  }
+  if !containsFold(c.Indicators, status.Status.Indicator) {
+    return "", nil
+  }
+  return fmt.Sprintf("%s (%s)", status.Status.Description, status.Status.Indicator), nil
+}
+
+// every replica checks it, since each pauses its own polls and it sends
+// nothing
+func watchStatusPage(creds *Config) {
+  c := &creds.StatusPage
+  client := &http.Client{Timeout: 10 * time.Second}
+  failing := false
+  for {
+    declared, err := checkStatusPage(c, client)
+    switch {
+    case err != nil && !failing:
+      logger.Print("Error checking the status page, polling carries on: ", err)
+      failing = true
+    case err == nil:
+      failing = false
+      setOutage(declared)
+    }
+    time.Sleep(c.interval)
+  }
+}
+
+func setOutage(declared string) {
+  outage.Lock()
+  defer outage.Unlock()
+  switch {
+  case len(declared) > 0 && len(outage.declared) == 0:
+    outage.since = time.Now()
+    logger.Print("JIRA OUTAGE: ", declared, ", polling is paused until it's over")
+  case len(declared) == 0 && len(outage.declared) > 0:
+    logger.Print("JIRA outage over after ", time.Since(outage.since).Round(time.Second), ", polling resumes")
+  }
+  outage.declared = declared
+}
+
+func writeOutageMetrics(out *strings.Builder) {
+  outage.Lock()
+  defer outage.Unlock()
+  down := 0
+  if len(outage.declared) > 0 {
+    down = 1
+  }
+  out.WriteString("# HELP jira_tracker_jira_outage Whether polling is paused for a declared jira outage.\n")
+  out.WriteString("# TYPE jira_tracker_jira_outage gauge\n")
+  fmt.Fprintf(out, "jira_tracker_jira_outage %d\n", down)
+  out.WriteString("# HELP jira_tracker_outage_skipped_polls_total Polls skipped for jira outages.\n")
+  out.WriteString("# TYPE jira_tracker_outage_skipped_polls_total counter\n")
+  fmt.Fprintf(out, "jira_tracker_outage_skipped_polls_total %d\n", outage.skipped)
+}
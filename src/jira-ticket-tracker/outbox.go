@@ -0,0 +1,249 @@
+package main
+
+import (
+  "encoding/json"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+func init() {
+  commands["forward"] = command{"forward [--dir=DIR] [--watch=INTERVAL]", forwardCommand}
+}
+
+// store and forward, for a tracker in a network that reaches jira but not
+// its targets. the events for the outbox's targets are written to its
+// directory instead of sent, encrypted if the state is, and `forward`, run
+// from somewhere that reaches the targets with the same targets and
+// templates configured, sends them on and removes them
+//
+//   outbox:
+//     dir: /mnt/transfer/outbox
+//     targets: [ops-slack, audit]   # every target by default
+type OutboxConfig struct {
+  Dir     string   `yaml:"dir"`
+  Targets []string `yaml:"targets"`
+}
+
+type outboxRecord struct {
+  HistoryRecord
+  Id string `json:"id"`
+}
+
+// a file in the outbox, the events from one delivery to a target
+type outboxBatch struct {
+  Target string         `json:"target"`
+  Events []outboxRecord `json:"events"`
+}
+
+type outboxQueue struct {
+  dir     string
+  targets map[string]bool // nil for every target
+  mu      sync.Mutex
+  seq     int
+}
+
+// the outbox, set up in main. nil when events are sent directly
+var outbox *outboxQueue
+
+func openOutbox(creds *Config) *outboxQueue {
+  if len(creds.Outbox.Dir) == 0 {
+    return nil
+  }
+  if err := os.MkdirAll(creds.Outbox.Dir, 0700); err != nil {
+    logger.Print("Error creating the outbox: ", err)
+    os.Exit(1)
+  }
+  o := &outboxQueue{dir: creds.Outbox.Dir}
+  if len(creds.Outbox.Targets) > 0 {
+    o.targets = map[string]bool{}
+    for _, name := range creds.Outbox.Targets {
+      o.targets[name] = true
+    }
+  }
+  return o
+}
+
+// whether a target's events go into the outbox
+func (o *outboxQueue) holds(target string) bool {
+  return o != nil && (o.targets == nil || o.targets[target])
+}
+
+// write the events for a target as a new file. it's renamed into place
+// once it's complete so a forwarder never reads half of one
+func (o *outboxQueue) Queue(target string, events []*Event) error {
+  batch := outboxBatch{Target: target}
+  for _, event := range events {
+    record, err := historyRecord(event, time.Now())
+    if err != nil {
+      return err
+    }
+    batch.Events = append(batch.Events, outboxRecord{record, event.Id})
+  }
+  contents, err := json.Marshal(batch)
+  if err != nil {
+    return err
+  }
+  o.mu.Lock()
+  o.seq++
+  name := fmt.Sprintf("%020d-%06d.json", time.Now().UnixNano(), o.seq)
+  o.mu.Unlock()
+  path := filepath.Join(o.dir, name)
+  if err := ioutil.WriteFile(path+".tmp", sealFile(contents), 0600); err != nil {
+    return err
+  }
+  return os.Rename(path+".tmp", path)
+}
+
+// the outbox's files, oldest first
+func outboxFiles(dir string) ([]string, error) {
+  paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+  sort.Strings(paths)
+  return paths, err
+}
+
+func readOutboxBatch(path string) (outboxBatch, error) {
+  var batch outboxBatch
+  contents, err := ioutil.ReadFile(path)
+  if err != nil {
+    return batch, err
+  }
+  if contents, err = unsealFile(contents); err != nil {
+    return batch, err
+  }
+  err = json.Unmarshal(contents, &batch)
+  return batch, err
+}
+
+// send a file's events on, through the target's fallbacks, and remove it.
+// what still failed is written back to be tried on the next pass
+func forwardBatch(path string) (sent, failed int, err error) {
+  batch, err := readOutboxBatch(path)
+  if err != nil {
+    return 0, 0, err
+  }
+  s, ok := sinks[batch.Target]
+  if !ok {
+    return 0, 0, fmt.Errorf("unknown target %s", batch.Target)
+  }
+  events := []*Event{}
+  byId := map[string]outboxRecord{}
+  for _, record := range batch.Events {
+    event, err := record.event()
+    if err != nil {
+      logger.Print("Dropping ", record.Key, " from ", filepath.Base(path), ": ", err)
+      continue
+    }
+    event.Id, event.Targets = record.Id, []string{batch.Target}
+    events = append(events, event)
+    byId[record.Id] = record
+  }
+  if len(events) == 0 {
+    return 0, 0, os.Remove(path)
+  }
+
+  left := s.send(events)
+  if len(left) == 0 {
+    return len(events), 0, os.Remove(path)
+  }
+  batch.Events = nil
+  for _, event := range left {
+    batch.Events = append(batch.Events, byId[event.Id])
+  }
+  contents, _ := json.Marshal(batch)
+  if err := ioutil.WriteFile(path+".tmp", sealFile(contents), 0600); err != nil {
+    return len(events) - len(left), len(left), err
+  }
+  return len(events) - len(left), len(left), os.Rename(path+".tmp", path)
+}
+
+// drain the outbox once, returning how many events were sent and how many
+// are still in it
+func forwardOutbox(dir string) (int, int) {
+  paths, err := outboxFiles(dir)
+  if err != nil {
+    logger.Print("Error reading the outbox: ", err)
+    return 0, 0
+  }
+  sent, left := 0, 0
+  for _, path := range paths {
+    s, f, err := forwardBatch(path)
+    if err != nil {
+      logger.Print("Error forwarding ", filepath.Base(path), ": ", err)
+    }
+    sent, left = sent+s, left+f
+  }
+  return sent, left
+}
+
+func forwardCommand(args []string) {
+  flags := flag.NewFlagSet("forward", flag.ExitOnError)
+  dir := flags.String("dir", "", "The outbox to drain, outbox.dir by default")
+  watch := flags.String("watch", "", "Keep draining it this often, e.g. 1m, instead of once")
+  flags.Parse(args)
+  if flags.NArg() > 0 {
+    usageExit(commands["forward"].usage)
+  }
+
+  creds, _ := setup()
+  outbox = nil // this is where the events go out
+  if len(*dir) == 0 {
+    *dir = creds.Outbox.Dir
+  }
+  if len(*dir) == 0 {
+    logger.Print("Which outbox? Give --dir or set outbox.dir")
+    os.Exit(1)
+  }
+  if _, err := os.Stat(*dir); err != nil {
+    logger.Print("Error reading the outbox: ", err)
+    os.Exit(1)
+  }
+  for {
+    sent, left := forwardOutbox(*dir)
+    if sent > 0 || left > 0 {
+      logger.Print("Forwarded ", sent, " events, ", left, " failed and are still in the outbox")
+    }
+    if len(*watch) == 0 {
+      state.Flush()
+      if left > 0 {
+        os.Exit(1)
+      }
+      return
+    }
+    time.Sleep(durationOr(*watch, time.Minute))
+  }
+}
+
+func writeOutboxMetrics(out *strings.Builder) {
+  if outbox == nil {
+    return
+  }
+  paths, err := outboxFiles(outbox.dir)
+  if err != nil {
+    return
+  }
+  count := 0
+  for _, path := range paths {
+    if batch, err := readOutboxBatch(path); err == nil {
+      count += len(batch.Events)
+    }
+  }
+  oldest := 0.0
+  if len(paths) > 0 {
+    nanos, _ := strconv.ParseInt(strings.SplitN(filepath.Base(paths[0]), "-", 2)[0], 10, 64)
+    oldest = time.Since(time.Unix(0, nanos)).Seconds()
+  }
+  out.WriteString("# HELP jira_tracker_outbox_events Events waiting in the outbox to be forwarded.\n")
+  out.WriteString("# TYPE jira_tracker_outbox_events gauge\n")
+  fmt.Fprintf(out, "jira_tracker_outbox_events %d\n", count)
+  out.WriteString("# HELP jira_tracker_outbox_oldest_seconds How long the oldest file in the outbox has waited.\n")
+  out.WriteString("# TYPE jira_tracker_outbox_oldest_seconds gauge\n")
+  fmt.Fprintf(out, "jira_tracker_outbox_oldest_seconds %g\n", oldest)
+}
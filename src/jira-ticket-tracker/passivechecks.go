@@ -0,0 +1,197 @@
+package main
+
+import (
+  "bytes"
+  "encoding/binary"
+  "encoding/json"
+  "fmt"
+  "hash/crc32"
+  "io"
+  "io/ioutil"
+  "time"
+)
+
+// passive check results for legacy monitoring, configured under
+// `passive_checks`. every interval the tracker's status (see pollStatus) is
+// sent to zabbix as a trapper item and/or to nagios through nsca
+//
+//   passive_checks:
+//     interval: 1m
+//     zabbix:
+//       server: zabbix.example.com:10051
+//       host: jira-tracker        # the host as zabbix knows it
+//       key: tracker.status       # the default, the 0/1/2 status code
+//       message_key: tracker.message
+//     nsca:
+//       server: nagios.example.com:5667
+//       host: jira-tracker
+//       service: jira-ticket-tracker
+//       password: ...
+//       encryption: xor           # none (the default) or xor
+type PassiveCheckConfig struct {
+  Interval string       `yaml:"interval"`
+  Zabbix   ZabbixConfig `yaml:"zabbix"`
+  NSCA     NSCAConfig   `yaml:"nsca"`
+}
+
+type ZabbixConfig struct {
+  Server     string `yaml:"server"`
+  Host       string `yaml:"host"`
+  Key        string `yaml:"key"`
+  MessageKey string `yaml:"message_key"`
+}
+
+type NSCAConfig struct {
+  Server     string `yaml:"server"`
+  Host       string `yaml:"host"`
+  Service    string `yaml:"service"`
+  Password   string `yaml:"password"`
+  Encryption string `yaml:"encryption"`
+}
+
+func (c *PassiveCheckConfig) enabled() bool {
+  return len(c.Zabbix.Server) > 0 || len(c.NSCA.Server) > 0
+}
+
+const passiveCheckTimeout = 10 * time.Second
+
+func sendPassiveChecks(creds *Config) {
+  config := creds.PassiveChecks
+  if len(config.Zabbix.Key) == 0 {
+    config.Zabbix.Key = "tracker.status"
+  }
+  if len(config.NSCA.Service) == 0 {
+    config.NSCA.Service = "jira-ticket-tracker"
+  }
+  interval := durationOr(config.Interval, time.Minute)
+  for {
+    code, message := pollStatus()
+    if len(config.Zabbix.Server) > 0 {
+      if err := sendZabbix(config.Zabbix, code, message); err != nil {
+        logger.Print("Error sending the passive check to zabbix: ", err)
+      }
+    }
+    if len(config.NSCA.Server) > 0 {
+      if err := sendNSCA(config.NSCA, code, message); err != nil {
+        logger.Print("Error sending the passive check to nsca: ", err)
+      }
+    }
+    time.Sleep(interval)
+  }
+}
+
+// the zabbix sender protocol: a header, the length and a json request
+func sendZabbix(config ZabbixConfig, code int, message string) error {
+  data := []map[string]string{{"host": config.Host, "key": config.Key, "value": fmt.Sprint(code)}}
+  if len(config.MessageKey) > 0 {
+    data = append(data, map[string]string{"host": config.Host, "key": config.MessageKey, "value": message})
+  }
+  body, err := json.Marshal(map[string]interface{}{"request": "sender data", "data": data})
+  if err != nil {
+    return err
+  }
+
+  conn, err := dialEgress(config.Server, passiveCheckTimeout)
+  if err != nil {
+    return err
+  }
+  defer conn.Close()
+  conn.SetDeadline(time.Now().Add(passiveCheckTimeout))
+
+  var packet bytes.Buffer
+  packet.WriteString("ZBXD\x01")
+  binary.Write(&packet, binary.LittleEndian, uint64(len(body)))
+  packet.Write(body)
+  if _, err := conn.Write(packet.Bytes()); err != nil {
+    return err
+  }
+
+  header := make([]byte, 13)
+  if _, err := io.ReadFull(conn, header); err != nil {
+    return err
+  }
+  reply, err := ioutil.ReadAll(conn)
+  if err != nil {
+    return err
+  }
+  var response struct {
+    Response string `json:"response"`
+    Info     string `json:"info"`
+  }
+  if err := json.Unmarshal(reply, &response); err != nil {
+    return err
+  }
+  if response.Response != "success" {
+    return fmt.Errorf("zabbix replied %s: %s", response.Response, response.Info)
+  }
+  return nil
+}
+
+// nsca's data packet, as laid out by the c struct it's read into, padding
+// included
+const (
+  nscaPacketVersion = 3
+  nscaHostLength    = 64
+  nscaServiceLength = 128
+  nscaOutputLength  = 512
+  nscaPacketLength  = 720
+  nscaIVLength      = 128
+)
+
+func nscaPacket(config NSCAConfig, code int, message string, timestamp uint32) []byte {
+  packet := make([]byte, nscaPacketLength)
+  binary.BigEndian.PutUint16(packet[0:], nscaPacketVersion)
+  binary.BigEndian.PutUint32(packet[8:], timestamp)
+  binary.BigEndian.PutUint16(packet[12:], uint16(code))
+  offset := 14
+  for _, field := range []struct {
+    value  string
+    length int
+  }{{config.Host, nscaHostLength}, {config.Service, nscaServiceLength}, {message, nscaOutputLength}} {
+    value := field.value
+    if len(value) >= field.length {
+      value = value[:field.length-1] // keep the nul terminator
+    }
+    copy(packet[offset:], value)
+    offset += field.length
+  }
+  binary.BigEndian.PutUint32(packet[4:], crc32.ChecksumIEEE(packet))
+  return packet
+}
+
+// send_nsca's protocol: the server sends an iv and a timestamp, the client
+// one packet, encrypted with nothing or xor
+func sendNSCA(config NSCAConfig, code int, message string) error {
+  if config.Encryption != "" && config.Encryption != "none" && config.Encryption != "xor" {
+    return fmt.Errorf("unsupported nsca encryption %s", config.Encryption)
+  }
+  if config.Encryption == "xor" && fipsMode {
+    return fmt.Errorf("nsca's xor encryption isn't allowed in fips mode")
+  }
+  conn, err := dialEgress(config.Server, passiveCheckTimeout)
+  if err != nil {
+    return err
+  }
+  defer conn.Close()
+  conn.SetDeadline(time.Now().Add(passiveCheckTimeout))
+
+  hello := make([]byte, nscaIVLength+4)
+  if _, err := io.ReadFull(conn, hello); err != nil {
+    return err
+  }
+  iv, timestamp := hello[:nscaIVLength], binary.BigEndian.Uint32(hello[nscaIVLength:])
+
+  packet := nscaPacket(config, code, message, timestamp)
+  if config.Encryption == "xor" {
+    for i := range packet {
+      packet[i] ^= iv[i%len(iv)]
+    }
+    if password := config.Password; len(password) > 0 {
+      for i := range packet {
+        packet[i] ^= password[i%len(password)]
+      }
+    }
+  }
+  _, err = conn.Write(packet)
+  return err
+}
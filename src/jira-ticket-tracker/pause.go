@@ -0,0 +1,136 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "os"
+  "sort"
+  "strings"
+  "sync"
+)
+
+func init() {
+  commands["pause"] = command{"pause [RULE]", pauseCommand}
+  commands["resume"] = command{"resume [RULE]", resumeCommand}
+}
+
+// paused rules skip their polls. their search window stays open, so when
+// they're resumed the next poll picks up everything created meanwhile
+var paused = struct {
+  sync.Mutex
+  all   bool
+  rules map[string]bool
+}{rules: map[string]bool{}}
+
+func rulePaused(name string) bool {
+  paused.Lock()
+  defer paused.Unlock()
+  return paused.all || paused.rules[name]
+}
+
+// PauseStatus is what's paused, every rule or the ones listed, and the
+// jira outage polling waits for if there is one
+type PauseStatus struct {
+  All    bool     `json:"all"`
+  Rules  []string `json:"rules"`
+  Outage string   `json:"outage,omitempty"`
+}
+
+func pauseStatus() PauseStatus {
+  paused.Lock()
+  defer paused.Unlock()
+  status := PauseStatus{All: paused.all, Rules: []string{}, Outage: jiraOutage()}
+  for name := range paused.rules {
+    status.Rules = append(status.Rules, name)
+  }
+  sort.Strings(status.Rules)
+  return status
+}
+
+type pauseRequest struct {
+  Rule string `json:"rule"` // every rule if empty
+}
+
+//   GET    /pause               what's paused
+//   POST   /pause {"rule":..}   pause a rule, or every rule without one
+//   DELETE /pause {"rule":..}   resume it, or everything without one
+func handlePause(w http.ResponseWriter, r *http.Request) {
+  if r.Method == "GET" {
+    writeJSON(w, http.StatusOK, pauseStatus())
+    return
+  }
+  if r.Method != "POST" && r.Method != "DELETE" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+  if !operatorAuthorized(r) {
+    writeError(w, http.StatusUnauthorized, "pausing or resuming needs the operator's token")
+    return
+  }
+  var req pauseRequest
+  if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+    writeError(w, http.StatusBadRequest, "invalid json: "+err.Error())
+    return
+  }
+  if len(req.Rule) > 0 && !ruleRegistered(req.Rule) {
+    writeError(w, http.StatusNotFound, "unknown rule "+req.Rule)
+    return
+  }
+  paused.Lock()
+  switch {
+  case r.Method == "POST" && len(req.Rule) == 0:
+    paused.all = true
+  case r.Method == "POST":
+    paused.rules[req.Rule] = true
+  case len(req.Rule) == 0:
+    paused.all, paused.rules = false, map[string]bool{}
+  default:
+    delete(paused.rules, req.Rule)
+  }
+  paused.Unlock()
+  what := req.Rule
+  if len(what) == 0 {
+    what = "every rule"
+  }
+  if r.Method == "POST" {
+    logger.Print("Paused ", what)
+  } else {
+    logger.Print("Resumed ", what)
+  }
+  writeJSON(w, http.StatusOK, pauseStatus())
+}
+
+func pauseCommand(args []string) {
+  setPaused("POST", "pause", args)
+}
+
+func resumeCommand(args []string) {
+  setPaused("DELETE", "resume", args)
+}
+
+func setPaused(method, name string, args []string) {
+  if len(args) > 1 {
+    usageExit(commands[name].usage)
+  }
+  req := pauseRequest{}
+  if len(args) == 1 {
+    req.Rule = args[0]
+  }
+  var status PauseStatus
+  if err := callAPI(method, "/pause", req, &status); err != nil {
+    logger.Print("Error calling ", name, ": ", err)
+    os.Exit(1)
+  }
+  switch {
+  case status.All:
+    fmt.Println("every rule is paused")
+  case len(status.Rules) > 0:
+    fmt.Println("paused:", strings.Join(status.Rules, ", "))
+  default:
+    fmt.Println("nothing is paused")
+  }
+  if len(status.Outage) > 0 {
+    fmt.Println("polling waits for a jira outage:", status.Outage)
+  }
+}
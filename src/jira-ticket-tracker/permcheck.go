@@ -0,0 +1,265 @@
+package main
+
+import (
+  "encoding/json"
+  "flag"
+  "fmt"
+  "net/url"
+  "os"
+  "sort"
+  "strings"
+)
+
+func init() {
+  commands["permissions-check"] = command{"permissions-check [--strict]", permissionsCheckCommand}
+}
+
+// the jira permissions the check looks at. any of them the account has but
+// no enabled feature needs is reported as excess
+var checkedPermissions = []string{
+  "BROWSE_PROJECTS", "CREATE_ISSUES", "ADD_COMMENTS", "TRANSITION_ISSUES", "ASSIGN_ISSUES",
+  "EDIT_ISSUES", "SET_ISSUE_SECURITY", "LINK_ISSUES", "RESOLVE_ISSUES", "CLOSE_ISSUES",
+  "MOVE_ISSUES", "MODIFY_REPORTER", "DELETE_ISSUES", "EDIT_ALL_COMMENTS", "DELETE_ALL_COMMENTS",
+  "ADMINISTER_PROJECTS",
+}
+
+// no feature needs these, they're only checked to be reported
+var globalPermissions = []string{"ADMINISTER", "SYSTEM_ADMIN"}
+
+// project ("" for whatever projects the jql-only rules poll) -> permission
+// -> the features needing it
+type neededPermissions map[string]map[string][]string
+
+func (n neededPermissions) add(project, permission, why string) {
+  project = strings.ToUpper(project)
+  if n[project] == nil {
+    n[project] = map[string][]string{}
+  }
+  if !contains(n[project][permission], why) {
+    n[project][permission] = append(n[project][permission], why)
+  }
+}
+
+// what a target needs to be allowed to do to the issues it's sent
+func targetPermissions(name string, target Target, creds *Config) map[string]string {
+  needs := map[string]string{}
+  switch target.Type {
+  case "jira-comment":
+    needs["ADD_COMMENTS"] = "target " + name
+  case "assign":
+    needs["ASSIGN_ISSUES"] = "target " + name
+  case "form-check":
+    needs["ADD_COMMENTS"] = "target " + name
+    if len(target.Form.Transition) > 0 {
+      needs["TRANSITION_ISSUES"] = "target " + name
+    }
+  case "security-level":
+    needs["EDIT_ISSUES"] = "target " + name
+    needs["SET_ISSUE_SECURITY"] = "target " + name
+  case "slack":
+    if !target.Blocks || len(creds.Slack.SigningSecret) == 0 {
+      break
+    }
+    buttons := target.Buttons
+    if len(buttons) == 0 {
+      buttons = []string{"assign", "transition"}
+    }
+    if contains(buttons, "assign") {
+      needs["ASSIGN_ISSUES"] = "the buttons of " + name
+    }
+    if contains(buttons, "transition") && len(target.Transitions) > 0 {
+      needs["TRANSITION_ISSUES"] = "the buttons of " + name
+    }
+  }
+  return needs
+}
+
+// the permissions each project needs for the features that are configured
+func requiredPermissions(creds *Config, rules []*Rule) neededPermissions {
+  needs := neededPermissions{}
+  polled := map[string]bool{}
+  for _, rule := range rules {
+    polled[strings.ToUpper(rule.Project)] = true
+    needs.add(rule.Project, "BROWSE_PROJECTS", "rule "+rule.Name)
+    targets := allRuleTargets(rule)
+    for _, step := range rule.Chain.Steps {
+      targets = append(targets, step.Target)
+    }
+    for _, name := range targets {
+      for permission, why := range targetPermissions(name, creds.Targets[name], creds) {
+        needs.add(rule.Project, permission, why)
+      }
+    }
+  }
+  everywhere := func(permission, why string) {
+    for project := range polled {
+      needs.add(project, permission, why)
+    }
+  }
+  if creds.Priority.WriteBack {
+    everywhere("EDIT_ISSUES", "priority.write_back")
+  }
+  if len(creds.Security.Level) > 0 {
+    everywhere("EDIT_ISSUES", "security.level")
+    everywhere("SET_ISSUE_SECURITY", "security.level")
+  }
+  if len(creds.NeedsInfo.Status) > 0 {
+    everywhere("ADD_COMMENTS", "needs_info")
+    if creds.NeedsInfo.Action == "close" && len(creds.NeedsInfo.Transition) > 0 {
+      everywhere("TRANSITION_ISSUES", "needs_info")
+    }
+  }
+  if project := creds.Alertmanager.Project; len(project) > 0 {
+    needs.add(project, "BROWSE_PROJECTS", "alertmanager")
+    needs.add(project, "CREATE_ISSUES", "alertmanager")
+    needs.add(project, "ADD_COMMENTS", "alertmanager")
+    if len(creds.Alertmanager.Transition) > 0 {
+      needs.add(project, "TRANSITION_ISSUES", "alertmanager")
+    }
+  }
+  for _, rule := range creds.Intake.Rules {
+    needs.add(rule.Project, "BROWSE_PROJECTS", "intake")
+    needs.add(rule.Project, "CREATE_ISSUES", "intake")
+  }
+  return needs
+}
+
+// which of the permissions the account has, in a project or, with no
+// project, in any
+func myPermissions(project string, permissions []string, creds *Config) (map[string]bool, error) {
+  uri := "/mypermissions?permissions=" + url.QueryEscape(strings.Join(permissions, ","))
+  if len(project) > 0 {
+    uri += "&projectKey=" + url.QueryEscape(project)
+  }
+  contents, err := jiraRequest("GET", uri, nil, creds)
+  if err != nil {
+    return nil, err
+  }
+  var result struct {
+    Permissions map[string]struct {
+      HavePermission bool `json:"havePermission"`
+    } `json:"permissions"`
+  }
+  if err := json.Unmarshal(contents, &result); err != nil {
+    return nil, err
+  }
+  have := map[string]bool{}
+  for key, p := range result.Permissions {
+    have[key] = p.HavePermission
+  }
+  return have, nil
+}
+
+// the write action each permission is used for, see accountFor. the rest
+// are the polling account's
+var permissionActions = map[string]string{
+  "ADD_COMMENTS": "comment", "TRANSITION_ISSUES": "transition", "ASSIGN_ISSUES": "assign",
+  "EDIT_ISSUES": "edit", "SET_ISSUE_SECURITY": "edit", "CREATE_ISSUES": "create",
+}
+
+// the permissions an account needs, for the actions it takes. an account
+// that only writes also has to see the issues it writes to
+func (n neededPermissions) of(account *Config, creds *Config) neededPermissions {
+  needs := neededPermissions{}
+  for project, permissions := range n {
+    for permission, why := range permissions {
+      user := creds
+      if action, ok := permissionActions[permission]; ok {
+        user = creds.accountFor(action)
+      }
+      if user.Login != account.Login {
+        continue
+      }
+      for _, w := range why {
+        needs.add(project, permission, w)
+      }
+      if account.Login != creds.Login {
+        needs.add(project, "BROWSE_PROJECTS", "seeing the issues it changes")
+      }
+    }
+  }
+  return needs
+}
+
+// check each account has the jira permissions the enabled features need
+// it for, and no more. it exits non-zero if any are missing, or with
+// --strict if any are excess
+func permissionsCheckCommand(args []string) {
+  flags := flag.NewFlagSet("permissions-check", flag.ExitOnError)
+  strict := flags.Bool("strict", false, "Also fail when an account has permissions nothing needs")
+  flags.Parse(args)
+  if flags.NArg() > 0 {
+    usageExit(commands["permissions-check"].usage)
+  }
+
+  creds, rules := setup()
+  needs := requiredPermissions(creds, rules)
+  accounts := []*Config{creds}
+  seen := map[string]bool{creds.Login: true}
+  for _, action := range writeActions {
+    if account := creds.accountFor(action); !seen[account.Login] {
+      seen[account.Login] = true
+      accounts = append(accounts, account)
+    }
+  }
+
+  missing, excess := 0, 0
+  for _, account := range accounts {
+    fmt.Println("checking the permissions of", account.Login)
+    m, e := checkPermissions(needs.of(account, creds), account)
+    missing, excess = missing+m, excess+e
+
+    have, err := myPermissions("", globalPermissions, account)
+    if err != nil {
+      logger.Print("Error checking the global permissions of ", account.Login, ": ", err)
+      os.Exit(1)
+    }
+    for _, permission := range globalPermissions {
+      if have[permission] {
+        fmt.Printf("  %-22s EXCESS, a jira administrator\n", permission)
+        excess++
+      }
+    }
+  }
+
+  fmt.Printf("%d missing, %d excess\n", missing, excess)
+  if missing > 0 || (*strict && excess > 0) {
+    os.Exit(1)
+  }
+}
+
+// print each project's needed and excess permissions against what the
+// account has, returning how many are missing and excess
+func checkPermissions(needs neededPermissions, creds *Config) (missing, excess int) {
+  projects := []string{}
+  for project := range needs {
+    projects = append(projects, project)
+  }
+  sort.Strings(projects)
+  for _, project := range projects {
+    have, err := myPermissions(project, checkedPermissions, creds)
+    if err != nil {
+      logger.Print("Error checking the permissions in ", project, ": ", err)
+      os.Exit(1)
+    }
+    if len(project) > 0 {
+      fmt.Println(project + ":")
+    } else {
+      fmt.Println("any project, for the rules without one:")
+    }
+    for _, permission := range checkedPermissions {
+      why, needed := needs[project][permission]
+      switch {
+      case needed && have[permission]:
+        fmt.Printf("  %-22s ok, for %s\n", permission, strings.Join(why, ", "))
+      case needed:
+        fmt.Printf("  %-22s MISSING, needed for %s\n", permission, strings.Join(why, ", "))
+        missing++
+      case have[permission]:
+        fmt.Printf("  %-22s EXCESS, nothing needs it\n", permission)
+        excess++
+      }
+    }
+  }
+  return
+}
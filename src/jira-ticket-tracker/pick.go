@@ -0,0 +1,155 @@
+package main
+
+import (
+  "bufio"
+  "flag"
+  "fmt"
+  "os"
+  "os/exec"
+  "sort"
+  "strconv"
+  "strings"
+)
+
+func init() {
+  commands["pick"] = command{"pick [--jql=JQL] [--limit=N] [--open] [QUERY]", pickCommand}
+}
+
+const (
+  defaultPickJql = "updated >= -14d ORDER BY updated DESC"
+  // how many candidates the built in picker lists at a time
+  pickShown = 20
+)
+
+// how well the query matches the line: its letters have to appear in order,
+// and the closer together they are the better. -1 if they don't all appear
+func fuzzyScore(query, line string) int {
+  query, line = strings.ToLower(query), strings.ToLower(line)
+  score, last, at := 0, -1, 0
+  for _, r := range query {
+    if r == ' ' {
+      continue
+    }
+    i := strings.IndexRune(line[at:], r)
+    if i < 0 {
+      return -1
+    }
+    i += at
+    if last >= 0 {
+      score += i - last - 1
+    }
+    last, at = i, i+len(string(r))
+  }
+  return score
+}
+
+func fuzzyFilter(query string, lines []string) []string {
+  type match struct {
+    line  string
+    score int
+  }
+  matches := []match{}
+  for _, line := range lines {
+    if score := fuzzyScore(query, line); score >= 0 {
+      matches = append(matches, match{line, score})
+    }
+  }
+  sort.SliceStable(matches, func(i, j int) bool { return matches[i].score < matches[j].score })
+  filtered := []string{}
+  for _, m := range matches {
+    filtered = append(filtered, m.line)
+  }
+  return filtered
+}
+
+// pick with fzf, returning false if it isn't installed
+func pickWithFzf(lines []string, query string) (string, bool) {
+  path, err := exec.LookPath("fzf")
+  if err != nil {
+    return "", false
+  }
+  cmd := exec.Command(path, "--no-sort", "--query", query)
+  cmd.Stdin = strings.NewReader(strings.Join(lines, "\n"))
+  cmd.Stderr = os.Stderr
+  out, err := cmd.Output()
+  if err != nil {
+    // esc or ctrl-c
+    return "", true
+  }
+  return strings.TrimSpace(string(out)), true
+}
+
+// a plain prompt for when fzf isn't around: type to narrow the list down,
+// or the number of a line to pick it
+func pickWithPrompt(lines []string, query string) string {
+  input := bufio.NewScanner(os.Stdin)
+  for {
+    candidates := fuzzyFilter(query, lines)
+    if len(candidates) == 1 && len(query) > 0 {
+      return candidates[0]
+    }
+    for i, line := range candidates {
+      if i == pickShown {
+        fmt.Fprintf(os.Stderr, "  ... and %d more\n", len(candidates)-pickShown)
+        break
+      }
+      fmt.Fprintf(os.Stderr, "%3d  %s\n", i+1, line)
+    }
+    if len(candidates) == 0 {
+      fmt.Fprintln(os.Stderr, "  no matches")
+    }
+    fmt.Fprint(os.Stderr, "> ")
+    if !input.Scan() {
+      return ""
+    }
+    text := strings.TrimSpace(input.Text())
+    if n, err := strconv.Atoi(text); err == nil && n >= 1 && n <= len(candidates) && n <= pickShown {
+      return candidates[n-1]
+    }
+    query = text
+  }
+}
+
+// choose one of the recent issues interactively and print its key, or open
+// it in the browser
+func pickCommand(args []string) {
+  flags := flag.NewFlagSet("pick", flag.ExitOnError)
+  jql := flags.String("jql", defaultPickJql, "The issues to pick from")
+  limit := flags.Int("limit", 200, "The most issues to fetch")
+  open := flags.Bool("open", false, "Open the picked issue in the browser instead of printing its key")
+  flags.Parse(args)
+
+  creds, _ := setup()
+  issues, fields, err := searchIssues(configJql(creds, "--jql", *jql), *limit, creds)
+  if err != nil {
+    logger.Print("Error searching: ", err)
+    os.Exit(1)
+  }
+  if len(issues) == 0 {
+    logger.Print("No issues match ", *jql)
+    os.Exit(1)
+  }
+  lines := []string{}
+  for i, issue := range issues {
+    lines = append(lines, fmt.Sprintf("%s  [%s]  %s", issue.Key,
+        fieldString(fields[i], "status.name"), strings.Join(strings.Fields(fieldString(fields[i], "summary")), " ")))
+  }
+
+  query := strings.Join(flags.Args(), " ")
+  picked, ok := pickWithFzf(lines, query)
+  if !ok {
+    picked = pickWithPrompt(lines, query)
+  }
+  if len(picked) == 0 {
+    os.Exit(1)
+  }
+  key := strings.Fields(picked)[0]
+  if *open {
+    if err := openUrl(creds.browseUrl(key)); err != nil {
+      logger.Print("Error opening the browser: ", err)
+      os.Exit(1)
+    }
+    return
+  }
+  fmt.Println(key)
+}
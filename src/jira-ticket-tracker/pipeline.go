@@ -0,0 +1,299 @@
+package main
+
+import (
+  "encoding/json"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "sync"
+  "time"
+)
+
+var recordPipeline = flag.String("record-pipeline", "", "Record what happens to every event in this directory, for `debug`")
+
+func init() {
+  commands["debug"] = command{"debug [--replay] EVENT_ID", debugCommand}
+}
+
+// with --record-pipeline, everything that happens to an event on its way
+// to the targets is written to a file named after its id: the issue as it
+// was, how the rule judged it, and for each target what was dropped, the
+// message, the payload and how sending went. `debug` shows it step by step
+// and with --replay runs it through the current config again, sending
+// nothing. recordings are kept for a week
+type PipelineRecording struct {
+  Id         string                 `json:"id"`
+  Time       time.Time              `json:"time"`
+  Kind       string                 `json:"kind"`
+  Rule       string                 `json:"rule,omitempty"`
+  Key        string                 `json:"key"`
+  Detail     string                 `json:"detail,omitempty"`
+  Fields     map[string]interface{} `json:"fields"`
+  Computed   map[string]string      `json:"computed,omitempty"`
+  Evaluation *EvaluationTrace       `json:"evaluation,omitempty"`
+  Targets    []string               `json:"targets"`
+  Steps      []PipelineStep         `json:"steps"`
+}
+
+type PipelineStep struct {
+  Time    time.Time `json:"time"`
+  Stage   string    `json:"stage"` // security, held, snoozed, invisible, dnd, planned, approval, skipped, sent, failed or fallback
+  Target  string    `json:"target,omitempty"`
+  Detail  string    `json:"detail,omitempty"`
+  Message string    `json:"message,omitempty"`
+  Payload string    `json:"payload,omitempty"`
+}
+
+const pipelineRetention = 7 * 24 * time.Hour
+
+type pipelineRecorder struct {
+  dir string
+
+  mu     sync.Mutex
+  open   map[string]*PipelineRecording // event id -> its recording until delivered
+  pruned time.Time
+}
+
+// nil unless --record-pipeline is given
+var pipeline *pipelineRecorder
+
+func openPipelineRecorder() *pipelineRecorder {
+  if len(*recordPipeline) == 0 {
+    return nil
+  }
+  if err := os.MkdirAll(*recordPipeline, 0700); err != nil {
+    logger.Print("Error creating ", *recordPipeline, ": ", err)
+    os.Exit(1)
+  }
+  logger.Print("Recording every event's way through the pipeline in ", *recordPipeline)
+  return &pipelineRecorder{dir: *recordPipeline, open: map[string]*PipelineRecording{}}
+}
+
+// start recording the events, with the latest evaluation of their issues
+// by their rules
+func (p *pipelineRecorder) begin(events []*Event) {
+  if p == nil {
+    return
+  }
+  p.mu.Lock()
+  defer p.mu.Unlock()
+  for _, event := range events {
+    recording := &PipelineRecording{
+      Id: event.Id, Time: time.Now(), Kind: event.Kind, Rule: event.Rule, Key: event.Issue.Key,
+      Detail: event.Detail, Fields: event.Fields, Computed: event.Computed, Targets: event.Targets,
+    }
+    traces := tracesFor(event.Issue.Key)
+    for i := len(traces) - 1; i >= 0; i-- {
+      if traces[i].Rule == event.Rule {
+        recording.Evaluation = &traces[i]
+        break
+      }
+    }
+    p.open[event.Id] = recording
+  }
+}
+
+func (p *pipelineRecorder) step(event *Event, step PipelineStep) {
+  if p == nil {
+    return
+  }
+  p.mu.Lock()
+  defer p.mu.Unlock()
+  if recording, ok := p.open[event.Id]; ok {
+    step.Time = time.Now()
+    recording.Steps = append(recording.Steps, step)
+  }
+}
+
+// record what a target was sent about an event, or failed to be
+func (p *pipelineRecorder) delivery(s *sink, event, sent *Event, message string, err error) {
+  if p == nil {
+    return
+  }
+  step := PipelineStep{Stage: "sent", Target: s.name, Message: message}
+  if dropped := droppedFields(event, sent); len(dropped) > 0 {
+    step.Detail = "without " + strings.Join(dropped, ", ")
+  }
+  if previewer, ok := s.notifier.(previewer); ok {
+    if payload, err := previewer.preview(sent, message); err == nil {
+      step.Payload = payload
+    }
+  }
+  if err != nil {
+    step.Stage, step.Detail = "failed", strings.TrimSpace(step.Detail+" "+err.Error())
+  }
+  p.step(event, step)
+}
+
+// the parts of an event a target's field policy took out
+func droppedFields(event, sent *Event) []string {
+  dropped := []string{}
+  if event.Issue.Fields != nil && sent.Issue.Fields == nil {
+    dropped = append(dropped, "fields")
+  }
+  if len(event.Computed) > 0 && len(sent.Computed) == 0 {
+    dropped = append(dropped, "computed")
+  }
+  if event.Annotation != nil && sent.Annotation == nil {
+    dropped = append(dropped, "annotation")
+  }
+  if len(event.Correlations) > 0 && len(sent.Correlations) == 0 {
+    dropped = append(dropped, "correlations")
+  }
+  if before, after := event.Issue.Fields, sent.Issue.Fields; before != nil && after != nil {
+    if len(before.Summary) > 0 && len(after.Summary) == 0 {
+      dropped = append(dropped, "summary")
+    }
+    if before.Reporter != nil && after.Reporter == nil {
+      dropped = append(dropped, "reporter")
+    }
+    if before.Assignee != nil && after.Assignee == nil {
+      dropped = append(dropped, "assignee")
+    }
+  }
+  return dropped
+}
+
+// write the events' recordings out, now that they've been delivered
+func (p *pipelineRecorder) finish(events []*Event) {
+  if p == nil {
+    return
+  }
+  p.mu.Lock()
+  defer p.mu.Unlock()
+  for _, event := range events {
+    recording, ok := p.open[event.Id]
+    if !ok {
+      continue
+    }
+    delete(p.open, event.Id)
+    contents, err := json.MarshalIndent(recording, "", "  ")
+    if err == nil {
+      err = ioutil.WriteFile(filepath.Join(p.dir, event.Id+".json"), sealFile(contents), 0600)
+    }
+    if err != nil {
+      logger.Print("Error recording the pipeline of ", event.Id, ": ", err)
+    }
+  }
+  if time.Since(p.pruned) > time.Hour {
+    p.pruned = time.Now()
+    paths, _ := filepath.Glob(filepath.Join(p.dir, "*.json"))
+    for _, path := range paths {
+      if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > pipelineRetention {
+        os.Remove(path)
+      }
+    }
+  }
+}
+
+func readPipelineRecording(dir, id string) (*PipelineRecording, error) {
+  if strings.ContainsAny(id, `/\.`) {
+    return nil, fmt.Errorf("%s isn't an event id", id)
+  }
+  contents, err := ioutil.ReadFile(filepath.Join(dir, id+".json"))
+  if err != nil {
+    return nil, err
+  }
+  if contents, err = unsealFile(contents); err != nil {
+    return nil, err
+  }
+  var recording PipelineRecording
+  err = json.Unmarshal(contents, &recording)
+  return &recording, err
+}
+
+func debugCommand(args []string) {
+  flags := flag.NewFlagSet("debug", flag.ExitOnError)
+  replay := flags.Bool("replay", false, "Also run the event through the current config, sending nothing")
+  flags.Parse(args)
+  if flags.NArg() != 1 {
+    usageExit(commands["debug"].usage)
+  }
+  if len(*recordPipeline) == 0 {
+    logger.Print("Give --record-pipeline with the directory the tracker records in")
+    os.Exit(1)
+  }
+  creds, rules := setup() // the recordings are encrypted if the state is
+  recording, err := readPipelineRecording(*recordPipeline, flags.Arg(0))
+  if err != nil {
+    logger.Print("Error reading the recording: ", err)
+    os.Exit(1)
+  }
+
+  fmt.Printf("%s %s for %s", recording.Time.Format(time.RFC3339), recording.Kind, recording.Key)
+  if len(recording.Rule) > 0 {
+    fmt.Printf(" from rule %s", recording.Rule)
+  }
+  fmt.Println()
+  if len(recording.Detail) > 0 {
+    fmt.Println("detail:", recording.Detail)
+  }
+  if recording.Evaluation != nil {
+    fmt.Println("1. the rule's filters:")
+    fmt.Println(formatConditions(recording.Evaluation.Conditions))
+  }
+  fmt.Println("2. targets:", strings.Join(recording.Targets, ", "))
+  fmt.Println("3. what happened:")
+  for _, step := range recording.Steps {
+    fmt.Printf("  %s %-9s %s %s\n", step.Time.Format("15:04:05.000"), step.Stage, step.Target, step.Detail)
+    if len(step.Message) > 0 {
+      fmt.Println("    message:", strings.Replace(step.Message, "\n", "\n      ", -1))
+    }
+    if len(step.Payload) > 0 {
+      fmt.Println("    payload:", strings.Replace(strings.TrimSpace(step.Payload), "\n", "\n      ", -1))
+    }
+  }
+  if !*replay {
+    return
+  }
+
+  raw, _ := json.Marshal(map[string]interface{}{"key": recording.Key, "fields": recording.Fields})
+  issue, fields, err := parseIssue(raw)
+  if err != nil {
+    logger.Print("Error rebuilding the issue: ", err)
+    os.Exit(1)
+  }
+  fmt.Println("--- replayed with the current config ---")
+  rule := findRule(rules, recording.Rule)
+  event := newEvent(recording.Kind, issue, fields)
+  event.Detail, event.Targets = recording.Detail, recording.Targets
+  if rule != nil {
+    fmt.Println("1. the rule's filters:")
+    fmt.Println(formatConditions(rule.evaluate(issue, fields, creds)))
+    event = ruleEvent(rule, recording.Kind, issue, fields, creds)
+    event.Detail = recording.Detail
+  } else if len(recording.Rule) > 0 {
+    fmt.Println("1. rule", recording.Rule, "isn't configured any more")
+  }
+  if security.matches(event) {
+    event.Targets = security.Targets
+  }
+  fmt.Println("2. targets:", strings.Join(event.Targets, ", "))
+  fmt.Println("3. what would be sent:")
+  for _, name := range event.Targets {
+    s, ok := sinks[name]
+    if !ok {
+      fmt.Printf("  %s: unknown target\n", name)
+      continue
+    }
+    if len(s.visible([]*Event{event})) == 0 {
+      continue
+    }
+    sent := s.target.Fields.redact(event)
+    message := s.message(sent)
+    fmt.Printf("  %s", name)
+    if dropped := droppedFields(event, sent); len(dropped) > 0 {
+      fmt.Print(" without ", strings.Join(dropped, ", "))
+    }
+    fmt.Println()
+    fmt.Println("    message:", strings.Replace(message, "\n", "\n      ", -1))
+    if p, ok := s.notifier.(previewer); ok {
+      if payload, err := p.preview(sent, message); err == nil {
+        fmt.Println("    payload:", strings.Replace(strings.TrimSpace(payload), "\n", "\n      ", -1))
+      }
+    }
+  }
+}
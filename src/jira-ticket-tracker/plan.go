@@ -0,0 +1,346 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "os"
+  "strings"
+  "text/tabwriter"
+  "time"
+)
+
+func init() {
+  commands["plan"] = command{"plan", planCommand}
+  commands["apply"] = command{"apply [ID..]", applyCommand}
+  commands["discard"] = command{"discard ID..|--all", discardCommand}
+}
+
+const eventPlan = "plan"
+
+// a review gate for the targets that change jira, configured under `plan`.
+// a target with `planned: true` only records what it would do, and the
+// changes wait in the state until someone runs `apply`
+//
+//   plan:
+//     window: 1h            # how often the plan is sent to be reviewed
+//     auto_apply: false     # or apply each window's changes once it's over
+//     targets: [ops-leads]  # sent the plan, the operator's by default
+//
+//   targets:
+//     ops-assign:
+//       type: assign
+//       planned: true
+//       assign: {...}
+//
+// an applied change is made against the issue as it is by then, so e.g. an
+// assign target leaves alone an issue someone has taken meanwhile
+type PlanConfig struct {
+  Window    string   `yaml:"window"`
+  AutoApply bool     `yaml:"auto_apply"`
+  Targets   []string `yaml:"targets"`
+
+  window time.Duration
+}
+
+// the target types that change jira, the only ones that can be planned
+var jiraWriteTargets = []string{"jira-comment", "assign", "form-check", "security-level", "watchers", "duplicate-link"}
+
+// PlannedChange is what a planned target would have done for an event
+type PlannedChange struct {
+  Id      string        `json:"id"`
+  Target  string        `json:"target"`
+  Key     string        `json:"key"`
+  Change  string        `json:"change"` // what it does, e.g. "assign OPS-1 to the least loaded of alice, bob"
+  Planned time.Time     `json:"planned"`
+  Event   HistoryRecord `json:"event"` // to rebuild the event from
+}
+
+func loadPlan(creds *Config) {
+  c := &creds.Plan
+  c.window = durationOr(c.Window, time.Hour)
+  for name, target := range creds.Targets {
+    if target.Planned && !contains(jiraWriteTargets, target.Type) {
+      logger.Print("Target ", name, " is planned but doesn't change jira, only ", strings.Join(jiraWriteTargets, ", "), " can be")
+      os.Exit(1)
+    }
+  }
+  if !creds.planned() {
+    return
+  }
+  // these change jira on their own, not through a target, so they can't be
+  // planned and would get around the review
+  writes := []string{}
+  if len(creds.NeedsInfo.Status) > 0 && creds.NeedsInfo.Action == "close" {
+    writes = append(writes, "needs_info's close")
+  }
+  if creds.LabelHygiene.Apply && len(creds.LabelHygiene.Projects) > 0 {
+    writes = append(writes, "label_hygiene's apply")
+  }
+  if creds.Orphans.enabled() && len(creds.Orphans.Reassign) > 0 {
+    writes = append(writes, "orphans' reassign")
+  }
+  if creds.Orphans.enabled() && len(creds.Orphans.Component) > 0 {
+    writes = append(writes, "orphans' component")
+  }
+  if len(writes) > 0 {
+    logger.Print("Targets are planned, but ", strings.Join(writes, ", "), " would change jira without being planned")
+    os.Exit(1)
+  }
+}
+
+// whether any target is planned
+func (c *Config) planned() bool {
+  for _, target := range c.Targets {
+    if target.Planned {
+      return true
+    }
+  }
+  return false
+}
+
+// what a target would do about an event
+func describeChange(target Target, key string) string {
+  switch target.Type {
+  case "jira-comment":
+    return "comment on " + key
+  case "assign":
+    if len(target.Assign.Candidates) > 0 {
+      return "assign " + key + ", if it's unassigned, to the least loaded of " + strings.Join(target.Assign.Candidates, ", ")
+    }
+    return "assign " + key + ", if it's unassigned, from " + target.Assign.Table
+  case "form-check":
+    return "ask the reporter of " + key + " for what's missing"
+  case "security-level":
+    return "set the security level of " + key + " to " + target.Level
+  case "watchers":
+    watchers := append([]string{}, target.Watchers.Users...)
+    if target.Watchers.Leads {
+      watchers = append(watchers, "the leads of its routes")
+    }
+    return "add " + strings.Join(watchers, ", ") + " as watchers of " + key
+  case "duplicate-link":
+    return "link " + key + " to a probable duplicate in " + strings.Join(target.Duplicates.Projects, ", ")
+  }
+  return target.Type + " " + key
+}
+
+// record what the sink would do with the events instead of doing it
+func planChanges(s *sink, events []*Event) {
+  now := time.Now()
+  for _, event := range events {
+    record, err := historyRecord(event, now)
+    if err != nil {
+      logger.Print("Error planning ", s.name, " for ", event.Issue.Key, ": ", err)
+      continue
+    }
+    c := &PlannedChange{
+      Id:      newEventId()[:8],
+      Target:  s.name,
+      Key:     event.Issue.Key,
+      Change:  describeChange(s.target, event.Issue.Key),
+      Planned: now,
+      Event:   record,
+    }
+    state.AddPlannedChange(c)
+    pipeline.step(event, PipelineStep{Stage: "planned", Target: s.name, Detail: c.Change + ", waiting for apply as " + c.Id})
+    logger.Print("PLANNED ", c.Id, ": ", c.Change)
+  }
+}
+
+// make a planned change against the issue as it is now
+func applyChange(c PlannedChange) error {
+  s, ok := sinks[c.Target]
+  if !ok {
+    return fmt.Errorf("target %s is gone", c.Target)
+  }
+  event, err := c.Event.event()
+  if err != nil {
+    return err
+  }
+  if contents := jiraIssue(c.Key, s.creds); contents != nil {
+    if issue, fields, err := parseIssue(contents); err == nil {
+      event.Issue, event.Fields = issue, fields
+    }
+  }
+  if failed := s.attempt([]*Event{event}); len(failed) > 0 {
+    return fmt.Errorf("%s failed, see the log", c.Target)
+  }
+  return nil
+}
+
+// PlanResult is what applying or discarding changes did
+type PlanResult struct {
+  Done   []string          `json:"done"`
+  Failed map[string]string `json:"failed,omitempty"` // change id -> why
+}
+
+// apply the changes with the ids, or every one without any. each is taken
+// out of the plan first, so it isn't applied twice, and the ones that fail
+// go back in
+func applyPlan(ids []string) PlanResult {
+  result := PlanResult{Done: []string{}, Failed: map[string]string{}}
+  for _, pending := range state.PlannedChanges() {
+    if len(ids) > 0 && !contains(ids, pending.Id) {
+      continue
+    }
+    c, ok := state.TakePlannedChange(pending.Id)
+    if !ok {
+      // applied or discarded meanwhile
+      continue
+    }
+    if err := applyChange(c); err != nil {
+      logger.Print("Error applying ", c.Id, " (", c.Change, "): ", err)
+      state.AddPlannedChange(&c)
+      result.Failed[c.Id] = err.Error()
+      continue
+    }
+    logger.Print("Applied ", c.Id, ": ", c.Change)
+    result.Done = append(result.Done, c.Id)
+  }
+  return result
+}
+
+func formatPlan(changes []PlannedChange) string {
+  lines := []string{fmt.Sprintf("%d changes are planned, see them with `plan` and make them with `apply`:", len(changes))}
+  for _, c := range changes {
+    lines = append(lines, c.Id+"  "+c.Change)
+  }
+  return strings.Join(lines, "\n")
+}
+
+// at the end of every window, send the changes planned in it for review,
+// or apply them
+func watchPlan(creds *Config) {
+  c := &creds.Plan
+  // each window starts where the last ended, so a change planned while the
+  // last one was sent isn't missed
+  windowStart := time.Now()
+  for {
+    time.Sleep(time.Until(windowStart.Add(c.window)))
+    windowEnd := time.Now()
+    fresh, ids := []PlannedChange{}, []string{}
+    for _, change := range state.PlannedChanges() {
+      if change.Planned.Before(windowStart) || !change.Planned.Before(windowEnd) {
+        continue
+      }
+      fresh, ids = append(fresh, change), append(ids, change.Id)
+    }
+    windowStart = windowEnd
+    if len(fresh) == 0 {
+      continue
+    }
+    summary := formatPlan(fresh)
+    if c.AutoApply {
+      result := applyPlan(ids)
+      summary = fmt.Sprintf("Applied %d of the %d changes planned in the last %s", len(result.Done), len(fresh), c.window)
+      if len(result.Failed) > 0 {
+        summary += fmt.Sprintf(", %d failed and are still planned", len(result.Failed))
+      }
+    }
+    event := trackerEvent(eventPlan, summary)
+    event.Targets = c.Targets
+    if len(event.Targets) == 0 {
+      event.Targets = creds.Operator.Targets
+    }
+    deliver([]*Event{event})
+  }
+}
+
+type planRequest struct {
+  Ids []string `json:"ids"` // every change if empty
+}
+
+//   GET    /plan                 the planned changes
+//   POST   /plan {"ids":[..]}    apply them, or every one without ids
+//   DELETE /plan {"ids":[..]}    discard them, or every one without ids
+func handlePlan(w http.ResponseWriter, r *http.Request) {
+  if r.Method == "GET" {
+    writeJSON(w, http.StatusOK, state.PlannedChanges())
+    return
+  }
+  if r.Method != "POST" && r.Method != "DELETE" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+  if !operatorAuthorized(r) {
+    writeError(w, http.StatusUnauthorized, "applying or discarding the plan needs the operator's token")
+    return
+  }
+  var req planRequest
+  if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+    writeError(w, http.StatusBadRequest, "invalid json: "+err.Error())
+    return
+  }
+  if r.Method == "POST" {
+    writeJSON(w, http.StatusOK, applyPlan(req.Ids))
+    return
+  }
+  result := PlanResult{Done: []string{}}
+  for _, pending := range state.PlannedChanges() {
+    if len(req.Ids) > 0 && !contains(req.Ids, pending.Id) {
+      continue
+    }
+    if c, ok := state.TakePlannedChange(pending.Id); ok {
+      logger.Print("Discarded ", c.Id, ": ", c.Change)
+      result.Done = append(result.Done, c.Id)
+    }
+  }
+  writeJSON(w, http.StatusOK, result)
+}
+
+func planCommand(args []string) {
+  if len(args) > 0 {
+    usageExit(commands["plan"].usage)
+  }
+  var changes []PlannedChange
+  if err := callAPI("GET", "/plan", nil, &changes); err != nil {
+    logger.Print("Error getting the plan: ", err)
+    os.Exit(1)
+  }
+  if len(changes) == 0 {
+    fmt.Println("nothing is planned")
+    return
+  }
+  w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+  fmt.Fprintln(w, "ID\tPLANNED\tTARGET\tCHANGE")
+  for _, c := range changes {
+    fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.Id, c.Planned.Format("2006-01-02 15:04"), c.Target, c.Change)
+  }
+  w.Flush()
+}
+
+func applyCommand(args []string) {
+  var result PlanResult
+  if err := callAPI("POST", "/plan", planRequest{Ids: args}, &result); err != nil {
+    logger.Print("Error applying the plan: ", err)
+    os.Exit(1)
+  }
+  printPlanResult("applied", result)
+}
+
+func discardCommand(args []string) {
+  ids := args
+  switch {
+  case len(args) == 1 && args[0] == "--all":
+    ids = nil
+  case len(args) == 0 || contains(args, "--all"):
+    usageExit(commands["discard"].usage)
+  }
+  var result PlanResult
+  if err := callAPI("DELETE", "/plan", planRequest{Ids: ids}, &result); err != nil {
+    logger.Print("Error discarding from the plan: ", err)
+    os.Exit(1)
+  }
+  printPlanResult("discarded", result)
+}
+
+func printPlanResult(done string, result PlanResult) {
+  fmt.Printf("%s %d changes\n", done, len(result.Done))
+  for id, reason := range result.Failed {
+    fmt.Printf("%s failed: %s\n", id, reason)
+  }
+  if len(result.Failed) > 0 {
+    os.Exit(1)
+  }
+}
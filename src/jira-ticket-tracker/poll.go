@@ -0,0 +1,97 @@
+package main
+
+import (
+  "net/http"
+  "os"
+  "sync"
+  "time"
+)
+
+// every polling loop registers a trigger so it can be told to poll right
+// away instead of waiting out its interval (SIGUSR1, POST /poll or the
+// poll command)
+var pollTriggers = struct {
+  sync.Mutex
+  chans []chan bool
+}{}
+
+func init() {
+  commands["poll"] = command{"poll", pollCommand}
+}
+
+func newPollTrigger() chan bool {
+  trigger := make(chan bool, 1)
+  pollTriggers.Lock()
+  pollTriggers.chans = append(pollTriggers.chans, trigger)
+  pollTriggers.Unlock()
+  return trigger
+}
+
+// tell every polling loop to poll now. a loop that is already triggered or
+// busy polling just polls once more when it's done
+func triggerPoll() {
+  pollTriggers.Lock()
+  defer pollTriggers.Unlock()
+  for _, trigger := range pollTriggers.chans {
+    select {
+    case trigger <- true:
+    default:
+    }
+  }
+}
+
+func removePollTrigger(trigger chan bool) {
+  pollTriggers.Lock()
+  defer pollTriggers.Unlock()
+  for i, t := range pollTriggers.chans {
+    if t == trigger {
+      pollTriggers.chans = append(pollTriggers.chans[:i], pollTriggers.chans[i+1:]...)
+      return
+    }
+  }
+}
+
+// wait for the interval or until the loop is triggered
+func waitForPoll(trigger chan bool, interval time.Duration) {
+  waitForPollOrStop(trigger, interval, nil)
+}
+
+// the same, returning false if stop was closed first
+func waitForPollOrStop(trigger chan bool, interval time.Duration, stop <-chan struct{}) bool {
+  timer := time.NewTimer(interval)
+  defer timer.Stop()
+  select {
+  case <-timer.C:
+  case <-trigger:
+  case <-stop:
+    return false
+  }
+  return true
+}
+
+func stopped(stop <-chan struct{}) bool {
+  select {
+  case <-stop:
+    return true
+  default:
+    return false
+  }
+}
+
+//   POST /poll  poll every rule and watched issue now
+func handlePoll(w http.ResponseWriter, r *http.Request) {
+  if r.Method != "POST" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+  logger.Print("Polling now, requested through the API")
+  triggerPoll()
+  writeJSON(w, http.StatusAccepted, map[string]string{"status": "polling"})
+}
+
+func pollCommand(args []string) {
+  if err := callAPI("POST", "/poll", nil, nil); err != nil {
+    logger.Print("Error triggering a poll: ", err)
+    os.Exit(1)
+  }
+}
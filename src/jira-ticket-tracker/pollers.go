@@ -0,0 +1,260 @@
+package main
+
+import (
+  "fmt"
+  "launchpad.net/goyaml"
+  "sync"
+  "time"
+)
+
+// the poller running for each rule, so the rules can be swapped while the
+// tracker is running
+var pollers = struct {
+  sync.Mutex
+  creds      *Config
+  events     chan []*Event
+  configured []*Rule // all of them, sharding may leave some to other replicas
+  discovered []*Rule // for the projects discovery found, see discovery.go
+  running    map[string]*poller
+  held       bool // none are started while an upgrade hands over
+}{running: map[string]*poller{}}
+
+type poller struct {
+  rule *Rule
+  stop chan struct{}
+  done chan struct{}
+  once sync.Once
+}
+
+// ask the poller to stop, however many times it's asked
+func (p *poller) halt() {
+  p.once.Do(func() { close(p.stop) })
+}
+
+// wait for the pollers to stop, all of them within the timeout, returning
+// the ones still running after it
+func waitForPollers(stopping map[string]*poller, timeout time.Duration) map[string]*poller {
+  timer := time.NewTimer(timeout)
+  defer timer.Stop()
+  expired := false
+  alive := map[string]*poller{}
+  for name, p := range stopping {
+    if expired {
+      select {
+      case <-p.done:
+      default:
+        alive[name] = p
+      }
+      continue
+    }
+    select {
+    case <-p.done:
+    case <-timer.C:
+      expired = true
+      select {
+      case <-p.done:
+      default:
+        alive[name] = p
+      }
+    }
+  }
+  return alive
+}
+
+// leave a poller that didn't stop in time in place, so no other starts for
+// its rule, and poll the rule again once it has stopped
+func replaceWhenDone(name string, p *poller) {
+  <-p.done
+  pollers.Lock()
+  defer pollers.Unlock()
+  if pollers.running[name] != p {
+    return
+  }
+  logger.Print("The poller of rule ", name, " stopped at last")
+  delete(pollers.running, name)
+  applyOwnedRules()
+}
+
+func startPoller(rule *Rule) *poller {
+  p := &poller{rule: rule, stop: make(chan struct{}), done: make(chan struct{})}
+  logger.Print("Searching for rule ", rule.Name, ": ", rule.query())
+  go func() {
+    defer close(p.done)
+    if !estimateAllowed(rule, pollers.creds) {
+      return
+    }
+    waitForIssues(rule, pollers.creds, pollers.events, p.stop)
+  }()
+  return p
+}
+
+func startPollers(rules []*Rule, creds *Config, c chan []*Event) {
+  pollers.Lock()
+  defer pollers.Unlock()
+  pollers.creds, pollers.events, pollers.configured = creds, c, rules
+  rules = shards.owned(allPollerRules())
+  registerRules(rules)
+  for _, rule := range rules {
+    pollers.running[rule.Name] = startPoller(rule)
+  }
+}
+
+// whether two versions of a rule are configured the same
+func sameRule(a, b *Rule) bool {
+  x, errX := goyaml.Marshal(a)
+  y, errY := goyaml.Marshal(b)
+  return errX == nil && errY == nil && string(x) == string(y)
+}
+
+// poll the new set of rules instead. the pollers of rules that are gone or
+// changed are stopped, and have finished any poll they were in the middle
+// of, before the new ones start, so a rule is never polled twice at once.
+// rules that didn't change keep polling undisturbed
+func applyRules(rules []*Rule) (added, changed, removed int) {
+  pollers.Lock()
+  defer pollers.Unlock()
+  pollers.configured = rules
+  return applyOwnedRules()
+}
+
+// stop every poller and start it again, returning how many there are. they
+// are all asked to stop first and get pollerStopTimeout between them. one
+// that doesn't stop in time is only replaced once it does
+func restartPollers() int {
+  pollers.Lock()
+  defer pollers.Unlock()
+  stopping := map[string]*poller{}
+  for name, p := range pollers.running {
+    p.halt()
+    stopping[name] = p
+  }
+  alive := waitForPollers(stopping, pollerStopTimeout)
+  for name, p := range stopping {
+    if _, ok := alive[name]; ok {
+      logger.Print("The poller of rule ", name, " didn't stop, starting another once it does")
+      go replaceWhenDone(name, p)
+      continue
+    }
+    pollers.running[name] = startPoller(p.rule)
+  }
+  return len(pollers.running)
+}
+
+// stop every poller until resumePollers, for an upgrade, failing if they
+// haven't all stopped within the timeout
+func holdPollers(timeout time.Duration) error {
+  pollers.Lock()
+  defer pollers.Unlock()
+  pollers.held = true
+  stopping := map[string]*poller{}
+  for name, p := range pollers.running {
+    p.halt()
+    stopping[name] = p
+  }
+  alive := waitForPollers(stopping, timeout)
+  for name, p := range stopping {
+    if _, ok := alive[name]; ok {
+      go replaceWhenDone(name, p)
+      continue
+    }
+    delete(pollers.running, name)
+  }
+  if len(alive) > 0 {
+    return fmt.Errorf("%d pollers didn't stop within %s", len(alive), timeout)
+  }
+  return nil
+}
+
+func resumePollers() {
+  pollers.Lock()
+  defer pollers.Unlock()
+  pollers.held = false
+  applyOwnedRules()
+}
+
+// poll this replica's share of the rules again, after the replicas up changed
+func reshardRules() (added, changed, removed int) {
+  pollers.Lock()
+  defer pollers.Unlock()
+  return applyOwnedRules()
+}
+
+// poll these rules for the discovered projects instead
+func applyDiscoveredRules(rules []*Rule) (added, changed, removed int) {
+  pollers.Lock()
+  defer pollers.Unlock()
+  pollers.discovered = rules
+  return applyOwnedRules()
+}
+
+func configuredPollerRules() []*Rule {
+  pollers.Lock()
+  defer pollers.Unlock()
+  return allPollerRules()
+}
+
+// the configured rules and the discovered ones whose names aren't taken,
+// with pollers held
+func allPollerRules() []*Rule {
+  rules := []*Rule{}
+  for _, rule := range pollers.configured {
+    if len(rule.Instance) == 0 { // the webhook brings those
+      rules = append(rules, rule)
+    }
+  }
+  names := map[string]bool{}
+  for _, rule := range rules {
+    names[rule.Name] = true
+  }
+  for _, rule := range pollers.discovered {
+    if !names[rule.Name] {
+      rules = append(rules, rule)
+    }
+  }
+  return rules
+}
+
+func applyOwnedRules() (added, changed, removed int) {
+  if pollers.held {
+    return
+  }
+  rules := shards.owned(allPollerRules())
+  wanted := map[string]*Rule{}
+  for _, rule := range rules {
+    wanted[rule.Name] = rule
+  }
+  replaced := map[string]bool{}
+  for name, p := range pollers.running {
+    select {
+    case <-p.stop:
+      continue // stopping, replaceWhenDone polls the rule again
+    default:
+    }
+    if rule, ok := wanted[name]; ok && sameRule(rule, p.rule) {
+      continue
+    }
+    p.halt()
+    <-p.done
+    delete(pollers.running, name)
+    if _, ok := wanted[name]; ok {
+      replaced[name] = true
+      changed++
+    } else {
+      removed++
+      logger.Print("Stopped polling rule ", name)
+    }
+  }
+
+  for i, rule := range rules {
+    if p, ok := pollers.running[rule.Name]; ok {
+      rules[i] = p.rule // the one being polled
+      continue
+    }
+    if !replaced[rule.Name] {
+      added++
+    }
+    pollers.running[rule.Name] = startPoller(rule)
+  }
+  registerRules(rules)
+  return
+}
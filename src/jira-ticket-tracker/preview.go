@@ -0,0 +1,200 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "flag"
+  "fmt"
+  "os"
+  "sort"
+  "strings"
+)
+
+func init() {
+  commands["preview"] = command{"preview --rule=NAME --issue=KEY [--kind=created] [--target=NAME,..|--all]", previewCommand}
+}
+
+// notifiers that can show what they would send without sending it
+type previewer interface {
+  preview(event *Event, message string) (string, error)
+}
+
+// indent json, leaving anything else as it is
+func indentJSON(contents []byte) string {
+  var out bytes.Buffer
+  if err := json.Indent(&out, contents, "", "  "); err != nil {
+    return string(contents)
+  }
+  return out.String()
+}
+
+func (n *webhookNotifier) preview(event *Event, message string) (string, error) {
+  var out strings.Builder
+  dry := *n
+  dry.dryRun = func(contentType string, headers map[string]string, contents []byte) {
+    fmt.Fprintf(&out, "POST %s\nContent-Type: %s\n", n.url, contentType)
+    names := []string{}
+    for name := range headers {
+      names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+      fmt.Fprintf(&out, "%s: %s\n", name, headers[name])
+    }
+    out.WriteString("\n" + indentJSON(contents) + "\n")
+  }
+  err := dry.Notify(event, message)
+  return out.String(), err
+}
+
+func (n *slackNotifier) preview(event *Event, message string) (string, error) {
+  contents, err := json.Marshal(n.body(event, message))
+  if err != nil {
+    return "", err
+  }
+  to := "POST " + n.url
+  if len(n.token) > 0 {
+    to = "chat.postMessage"
+    if n.thread {
+      to += ", in the thread of the first message about the issue if there is one"
+    }
+  }
+  return to + "\n\n" + indentJSON(contents) + "\n", nil
+}
+
+func (n *splunkNotifier) preview(event *Event, message string) (string, error) {
+  body, err := n.body([]*Event{event}, []string{message})
+  return "POST " + n.url + "\n\n" + indentJSON(bytes.TrimSpace(body)) + "\n", err
+}
+
+func (n *elasticNotifier) preview(event *Event, message string) (string, error) {
+  body, err := n.body([]*Event{event}, []string{message})
+  if err != nil {
+    return "", err
+  }
+  return "POST " + n.url + "\n\n" + body.String(), nil
+}
+
+func (n *clickhouseNotifier) preview(event *Event, message string) (string, error) {
+  row, err := clickhouseEventRow(event, message)
+  return "a row buffered for the next insert\n\n" + indentJSON(row) + "\n", err
+}
+
+func (n *natsNotifier) preview(event *Event, message string) (string, error) {
+  payload := eventPayload(event, message, n.version)
+  if err := checkPayload(payload, n.version); err != nil {
+    return "", err
+  }
+  contents, err := json.Marshal(payload)
+  if err != nil {
+    return "", err
+  }
+  encoding := n.encoding
+  if len(encoding) == 0 {
+    encoding = encodingJSON
+  }
+  return fmt.Sprintf("PUB %s, encoded as %s\n\n%s\n", n.subject, encoding, indentJSON(contents)), nil
+}
+
+func (n *kafkaNotifier) preview(event *Event, message string) (string, error) {
+  payload := eventPayload(event, message, n.version)
+  if err := checkPayload(payload, n.version); err != nil {
+    return "", err
+  }
+  contents, err := json.Marshal(payload)
+  if err != nil {
+    return "", err
+  }
+  encoding := n.encoding
+  if len(encoding) == 0 {
+    encoding = encodingJSON
+  }
+  return fmt.Sprintf("POST %s, keyed %s and encoded as %s\n\n%s\n", n.url, event.Issue.Key, encoding, indentJSON(contents)), nil
+}
+
+func (n *syslogNotifier) preview(event *Event, message string) (string, error) {
+  return n.format(event, message) + "\n", nil
+}
+
+func (n *journaldNotifier) preview(event *Event, message string) (string, error) {
+  var out strings.Builder
+  for _, field := range n.fields(event, message) {
+    out.WriteString(field[0] + "=" + field[1] + "\n")
+  }
+  return out.String(), nil
+}
+
+// fetch a real issue and show what each of a rule's targets would be sent
+// about it, the rendered message and, for the targets that post a payload,
+// the payload. nothing is sent
+func previewCommand(args []string) {
+  flags := flag.NewFlagSet("preview", flag.ExitOnError)
+  ruleName := flags.String("rule", "", "The rule the event is from")
+  key := flags.String("issue", "", "The issue to preview, e.g. OPS-123")
+  kind := flags.String("kind", eventCreated, "The kind of event")
+  only := flags.String("target", "", "Preview these targets instead of the rule's")
+  all := flags.Bool("all", false, "Preview every configured target")
+  flags.Parse(args)
+  if len(*ruleName) == 0 || len(*key) == 0 || flags.NArg() > 0 {
+    usageExit(commands["preview"].usage)
+  }
+
+  creds, rules := setup()
+  rule := findRule(rules, *ruleName)
+  if rule == nil {
+    logger.Print("No rule named ", *ruleName)
+    os.Exit(1)
+  }
+  contents := jiraIssue(*key, creds)
+  if contents == nil {
+    logger.Print("Error fetching ", *key)
+    os.Exit(1)
+  }
+  issue, fields, err := parseIssue(contents)
+  if err != nil {
+    logger.Print("Error parsing ", *key, ": ", err)
+    os.Exit(1)
+  }
+  if !conditionsPassed(rule.evaluate(issue, fields, creds)) {
+    fmt.Println("note:", issue.Key, "doesn't pass the rule's filters, see `why`")
+  }
+
+  event := ruleEvent(rule, *kind, issue, fields, creds)
+  if security.matches(event) {
+    fmt.Println("note: a security issue, only sent to the security targets")
+    event.Targets = security.Targets
+  }
+  targets := event.Targets
+  switch {
+  case *all:
+    targets = []string{}
+    for name := range sinks {
+      targets = append(targets, name)
+    }
+    sort.Strings(targets)
+  case len(*only) > 0:
+    targets = strings.Split(*only, ",")
+  }
+  if len(targets) == 0 {
+    fmt.Println("no targets")
+  }
+
+  for _, name := range targets {
+    s, ok := sinks[name]
+    if !ok {
+      fmt.Printf("--- %s: unknown target ---\n", name)
+      continue
+    }
+    sent := s.target.Fields.redact(event)
+    message := s.message(sent)
+    fmt.Printf("--- %s (%s) ---\n%s\n", name, s.target.Type, message)
+    if p, ok := s.notifier.(previewer); ok {
+      payload, err := p.preview(sent, message)
+      if err != nil {
+        fmt.Println("error building the payload:", err)
+        continue
+      }
+      fmt.Printf("--- %s payload ---\n%s", name, payload)
+    }
+  }
+}
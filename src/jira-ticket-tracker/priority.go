@@ -0,0 +1,91 @@
+package main
+
+import (
+  "github.com/plouc/go-jira-client"
+  "strings"
+)
+
+// priority normalization, configured under `priority`. reporters set
+// priorities inconsistently, so the priority events carry (and routing,
+// templates and computed fields see) is mapped onto the team's own scale:
+// the first matching rule wins, then the reporter's priority through the
+// map, otherwise it's left alone. the reporter's is kept as priority.original
+//
+//   priority:
+//     map: {Highest: P1, High: P2, Medium: P3, Low: P4, Lowest: P4}
+//     rules:
+//       - keyword: [outage, data loss]  # in the summary or description
+//         priority: P1
+//       - label: [cosmetic]
+//         component: [Docs]
+//         priority: P4
+//     write_back: true  # set the corrected priority on new issues in jira
+type PriorityConfig struct {
+  Map       map[string]string `yaml:"map"`
+  Rules     []PriorityRule    `yaml:"rules"`
+  WriteBack bool              `yaml:"write_back"`
+}
+
+// matches like an assignment table entry, on any component, label or keyword
+type PriorityRule struct {
+  Components []string `yaml:"component"`
+  Labels     []string `yaml:"label"`
+  Keywords   []string `yaml:"keyword"`
+  Priority   string   `yaml:"priority"`
+}
+
+var priorities PriorityConfig
+
+func loadPriorities(creds *Config) {
+  priorities = creds.Priority
+}
+
+// the normalized priority for an issue, empty if it shouldn't change
+func (c *PriorityConfig) normalize(issue *gojira.Issue, fields map[string]interface{}) string {
+  for i := range c.Rules {
+    r := &c.Rules[i]
+    entry := assignmentEntry{Components: r.Components, Labels: r.Labels, Keywords: r.Keywords}
+    if entry.matchesIssue(issue, fields) {
+      return r.Priority
+    }
+  }
+  current := fieldString(fields, "priority.name")
+  for from, to := range c.Map {
+    if strings.EqualFold(from, current) {
+      return to
+    }
+  }
+  return ""
+}
+
+// a copy of the fields with the normalized priority, if it differs
+func normalizePriority(issue *gojira.Issue, fields map[string]interface{}) map[string]interface{} {
+  normalized := priorities.normalize(issue, fields)
+  current := fieldString(fields, "priority.name")
+  if len(normalized) == 0 || normalized == current {
+    return fields
+  }
+  copied := map[string]interface{}{}
+  for name, value := range fields {
+    copied[name] = value
+  }
+  copied["priority"] = map[string]interface{}{"name": normalized, "original": current}
+  return copied
+}
+
+// set the normalized priority of a new issue in jira
+func writeBackPriority(event *Event, creds *Config) {
+  original, ok := fieldValue(event.Fields, "priority.original").(string)
+  if !priorities.WriteBack || event.Kind != eventCreated || !ok {
+    return
+  }
+  name := fieldString(event.Fields, "priority.name")
+  body := map[string]interface{}{
+    "fields": map[string]interface{}{"priority": map[string]string{"name": name}},
+  }
+  if _, err := jiraRequest("PUT", "/issue/"+event.Issue.Key, body, creds.accountFor("edit")); err != nil {
+    logger.Print("Error setting the priority of ", event.Issue.Key, ": ", err)
+    return
+  }
+  logger.Print("Changed the priority of ", event.Issue.Key, " from ", original, " to ", name)
+}
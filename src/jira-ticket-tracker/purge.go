@@ -0,0 +1,432 @@
+package main
+
+import (
+  "crypto/rand"
+  "encoding/hex"
+  "encoding/json"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "regexp"
+  "strings"
+)
+
+func init() {
+  commands["purge-user"] = command{"purge-user [--delete] [--dry-run] ACCOUNT", purgeUserCommand}
+}
+
+// erasing a user from the history, for a right to erasure request. every
+// user object that is them, by username, account id, key or email, becomes
+// a pseudonym, and their names are replaced in the text too. with delete,
+// the records mentioning them are dropped instead. the same goes for the
+// outbox, the pipeline recordings and the notes on issues. the full-text
+// index is rebuilt from the history, what's waiting in the state about them
+// is dropped, and the cached responses and warm start snapshot with them
+// in are deleted
+type userPurge struct {
+  ids       map[string]bool // lowercased, what identifies them in user objects
+  names     []string        // what to replace in text, learned from their user objects
+  patterns  []*regexp.Regexp
+  pseudonym string
+  delete    bool
+}
+
+func newUserPurge(account string, delete bool) *userPurge {
+  salt := make([]byte, 16)
+  rand.Read(salt) // a new one each time, so the pseudonym can't be linked back
+  p := &userPurge{ids: map[string]bool{strings.ToLower(account): true}, delete: delete}
+  p.pseudonym = anonymousUser(hex.EncodeToString(salt), account)
+  p.learnName(account)
+  return p
+}
+
+func (p *userPurge) learnName(name string) {
+  if len(name) >= 3 && !containsFold(p.names, name) {
+    p.names = append(p.names, name)
+  }
+}
+
+func (p *userPurge) isUser(v map[string]interface{}) bool {
+  for _, field := range []string{"accountId", "name", "key", "emailAddress"} {
+    if id := fieldString(v, field); len(id) > 0 && p.ids[strings.ToLower(id)] {
+      return len(jiraUserId(v)) > 0 || len(fieldString(v, "emailAddress")) > 0
+    }
+  }
+  return false
+}
+
+// learn the user's other identifiers and names from a record, so e.g.
+// their display name is found in comments too
+func (p *userPurge) learn(value interface{}) {
+  switch v := value.(type) {
+  case map[string]interface{}:
+    if p.isUser(v) {
+      for _, field := range []string{"accountId", "name", "key", "emailAddress", "displayName"} {
+        if id := fieldString(v, field); len(id) > 0 {
+          p.ids[strings.ToLower(id)] = true
+          p.learnName(id)
+        }
+      }
+      return
+    }
+    for _, item := range v {
+      p.learn(item)
+    }
+  case []interface{}:
+    for _, item := range v {
+      p.learn(item)
+    }
+  }
+}
+
+var wordPattern = regexp.MustCompile(`^\w.*\w$`)
+
+// match the names as whole words once they're all learned, so e.g. ann
+// isn't replaced in announcement
+func (p *userPurge) compile() {
+  p.patterns = nil
+  for _, name := range p.names {
+    pattern := regexp.QuoteMeta(name)
+    if wordPattern.MatchString(name) {
+      pattern = `\b` + pattern + `\b`
+    }
+    p.patterns = append(p.patterns, regexp.MustCompile("(?i)"+pattern))
+  }
+}
+
+func (p *userPurge) scrubText(s string) (string, bool) {
+  changed := false
+  for _, pattern := range p.patterns {
+    if pattern.MatchString(s) {
+      s, changed = pattern.ReplaceAllLiteralString(s, p.pseudonym), true
+    }
+  }
+  return s, changed
+}
+
+// the value with the user replaced, and whether they were in it
+func (p *userPurge) scrub(value interface{}) (interface{}, bool) {
+  switch v := value.(type) {
+  case map[string]interface{}:
+    if p.isUser(v) {
+      return map[string]interface{}{"name": p.pseudonym, "accountId": p.pseudonym, "displayName": p.pseudonym, "active": false}, true
+    }
+    changed := false
+    for key, item := range v {
+      scrubbed, ok := p.scrub(item)
+      v[key], changed = scrubbed, changed || ok
+    }
+    return v, changed
+  case []interface{}:
+    changed := false
+    for i, item := range v {
+      scrubbed, ok := p.scrub(item)
+      v[i], changed = scrubbed, changed || ok
+    }
+    return v, changed
+  case string:
+    return p.scrubText(v)
+  }
+  return value, false
+}
+
+// the record without the user, and whether they were in it. nil if it's
+// to be deleted
+func (p *userPurge) record(r HistoryRecord) (*HistoryRecord, bool) {
+  var issue interface{}
+  if err := json.Unmarshal(r.Issue, &issue); err != nil {
+    logger.Print("Skipping the history record of ", r.Key, " from ", r.Time, ": ", err)
+    return nil, false
+  }
+  issue, inIssue := p.scrub(issue)
+  detail, inDetail := p.scrubText(r.Detail)
+  if !inIssue && !inDetail {
+    return nil, false
+  }
+  if p.delete {
+    return nil, true
+  }
+  contents, err := json.Marshal(issue)
+  if err != nil {
+    logger.Print("Error encoding the history record of ", r.Key, " from ", r.Time, ": ", err)
+    return nil, false
+  }
+  r.Issue, r.Detail = contents, detail
+  return &r, true
+}
+
+// whether a record, or the change waiting on it, mentions the user
+func (p *userPurge) mentions(r HistoryRecord, change string) bool {
+  _, inChange := p.scrubText(change)
+  _, inRecord := p.record(r)
+  return inChange || inRecord
+}
+
+// the note without the user, and whether they wrote it or are in it. nil
+// if it's to be deleted
+func (p *userPurge) note(n Note) (*Note, bool) {
+  text, inText := p.scrubText(n.Text)
+  byThem := len(n.Author) > 0 && p.ids[strings.ToLower(n.Author)]
+  if !inText && !byThem {
+    return &n, false
+  }
+  if p.delete {
+    return nil, true
+  }
+  n.Text = text
+  if byThem {
+    n.Author = p.pseudonym
+  }
+  return &n, true
+}
+
+// remove the cached jira responses that mention the user, like their
+// /user lookups, returning how many. they're fetched again when needed
+func (p *userPurge) cacheDir(dir string, dryRun bool) (int, error) {
+  paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+  if err != nil {
+    return 0, err
+  }
+  found := 0
+  for _, path := range paths {
+    contents, err := ioutil.ReadFile(path)
+    if err != nil {
+      return found, err
+    }
+    var cached struct {
+      Contents interface{} `json:"contents"`
+    }
+    if json.Unmarshal(contents, &cached) != nil {
+      continue
+    }
+    if _, ok := p.scrub(cached.Contents); !ok {
+      continue
+    }
+    found++
+    if dryRun {
+      continue
+    }
+    if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+      return found, err
+    }
+  }
+  return found, nil
+}
+
+// pseudonymize or delete the user in a json file of one event, like a
+// pipeline recording, returning whether they were in it
+func (p *userPurge) file(path string, dryRun bool) (bool, error) {
+  contents, err := ioutil.ReadFile(path)
+  if err != nil {
+    return false, err
+  }
+  if contents, err = unsealFile(contents); err != nil {
+    return false, err
+  }
+  var value interface{}
+  if err := json.Unmarshal(contents, &value); err != nil {
+    return false, err
+  }
+  value, found := p.scrub(value)
+  if !found || dryRun {
+    return found, nil
+  }
+  if p.delete {
+    return true, os.Remove(path)
+  }
+  if contents, err = json.Marshal(value); err != nil {
+    return true, err
+  }
+  if err := ioutil.WriteFile(path+".tmp", sealFile(contents), 0600); err != nil {
+    return true, err
+  }
+  return true, os.Rename(path+".tmp", path)
+}
+
+// pseudonymize or delete the user's events in an outbox file, removing it
+// if none are left. returns how many mentioned them
+func (p *userPurge) outboxFile(path string, dryRun bool) (int, error) {
+  batch, err := readOutboxBatch(path)
+  if err != nil {
+    return 0, err
+  }
+  found, kept := 0, []outboxRecord{}
+  for _, record := range batch.Events {
+    purged, ok := p.record(record.HistoryRecord)
+    switch {
+    case !ok:
+      kept = append(kept, record)
+    case purged != nil:
+      found++
+      kept = append(kept, outboxRecord{*purged, record.Id})
+    default:
+      found++
+    }
+  }
+  if found == 0 || dryRun {
+    return found, nil
+  }
+  if len(kept) == 0 {
+    return found, os.Remove(path)
+  }
+  batch.Events = kept
+  contents, err := json.Marshal(batch)
+  if err != nil {
+    return found, err
+  }
+  if err := ioutil.WriteFile(path+".tmp", sealFile(contents), 0600); err != nil {
+    return found, err
+  }
+  return found, os.Rename(path+".tmp", path)
+}
+
+// purge the user from everything besides the history, printing what was
+// done. false if anything failed
+func (p *userPurge) everywhereElse(creds *Config, dryRun bool) bool {
+  ok := true
+  if len(creds.Outbox.Dir) > 0 {
+    paths, err := outboxFiles(creds.Outbox.Dir)
+    if err != nil {
+      logger.Print("Error reading the outbox: ", err)
+      ok = false
+    }
+    found := 0
+    for _, path := range paths {
+      n, err := p.outboxFile(path, dryRun)
+      if err != nil {
+        logger.Print("Error purging ", path, ": ", err)
+        ok = false
+      }
+      found += n
+    }
+    fmt.Printf("%d events in the outbox mention them\n", found)
+  }
+  if len(*recordPipeline) > 0 {
+    paths, err := filepath.Glob(filepath.Join(*recordPipeline, "*.json"))
+    if err != nil {
+      logger.Print("Error reading the pipeline recordings: ", err)
+      ok = false
+    }
+    found := 0
+    for _, path := range paths {
+      mentioned, err := p.file(path, dryRun)
+      if err != nil {
+        logger.Print("Error purging ", path, ": ", err)
+        ok = false
+      }
+      if mentioned {
+        found++
+      }
+    }
+    fmt.Printf("%d pipeline recordings mention them\n", found)
+  } else {
+    fmt.Println("pipeline recordings weren't looked at, give --record-pipeline if the tracker keeps them")
+  }
+  if len(creds.Cache.Dir) > 0 {
+    found, err := p.cacheDir(creds.Cache.Dir, dryRun)
+    if err != nil {
+      logger.Print("Error purging the cache in ", creds.Cache.Dir, ": ", err)
+      ok = false
+    }
+    fmt.Printf("%d cached jira responses mention them\n", found)
+  }
+  if dryRun {
+    return ok
+  }
+  // the snapshot is only caches, with group and role members among them,
+  // so it goes rather than being rewritten
+  if path := creds.WarmStart.Path; len(path) > 0 {
+    if err := os.Remove(path); err == nil {
+      fmt.Println("deleted the warm start snapshot")
+    } else if !os.IsNotExist(err) {
+      logger.Print("Error deleting the warm start snapshot: ", err)
+      ok = false
+    }
+  }
+  forgot := state.ForgetMentions(p.mentions, func(user string) bool { return p.ids[strings.ToLower(user)] })
+  fmt.Printf("dropped %d chain runs, planned changes, approval requests and do not disturbs about them from the state\n", forgot)
+  notes := state.RewriteNotes(p.note)
+  if p.delete {
+    fmt.Printf("deleted %d notes by or about them\n", notes)
+  } else {
+    fmt.Printf("pseudonymized %d notes by or about them\n", notes)
+  }
+  if len(creds.FullText.Path) > 0 {
+    if err := rebuildFullTextIndex(creds.FullText.Path); err != nil {
+      logger.Print("Error rebuilding the full-text index: ", err)
+      ok = false
+    } else {
+      fmt.Println("rebuilt the full-text index from the purged history")
+    }
+  }
+  return ok
+}
+
+// pseudonymize or delete a user's history records in the configured
+// storage. the file history is rewritten in place, so the tracker should be
+// stopped while it runs
+func purgeUserCommand(args []string) {
+  flags := flag.NewFlagSet("purge-user", flag.ExitOnError)
+  deleteRecords := flags.Bool("delete", false, "Delete the records mentioning the user instead of pseudonymizing them")
+  dryRun := flags.Bool("dry-run", false, "Only count the records mentioning the user")
+  flags.Parse(args)
+  if flags.NArg() != 1 {
+    usageExit(commands["purge-user"].usage)
+  }
+  account := flags.Arg(0)
+
+  creds, _ := setup()
+  defer store.Close()
+  p := newUserPurge(account, *deleteRecords)
+  // RewriteHistory changing nothing reads the whole history, which
+  // EachHistory can't do cheaply on every backend
+  _, err := store.RewriteHistory(func(r HistoryRecord) (*HistoryRecord, bool) {
+    var issue interface{}
+    if json.Unmarshal(r.Issue, &issue) == nil {
+      p.learn(issue)
+    }
+    return nil, false
+  })
+  if err == errNoHistory {
+    fmt.Println("no history is kept, there's nothing to purge")
+    return
+  }
+  if err != nil {
+    logger.Print("Error reading the history: ", err)
+    os.Exit(1)
+  }
+  p.compile()
+
+  changed := 0
+  if *dryRun {
+    store.RewriteHistory(func(r HistoryRecord) (*HistoryRecord, bool) {
+      if _, ok := p.record(r); ok {
+        changed++
+      }
+      return nil, false
+    })
+    fmt.Printf("%d history records mention %s\n", changed, account)
+    p.everywhereElse(creds, true)
+    return
+  }
+  if changed, err = store.RewriteHistory(p.record); err != nil {
+    logger.Print("Error purging ", account, " from the history, after ", changed, " records: ", err)
+    os.Exit(1)
+  }
+  if *deleteRecords {
+    fmt.Printf("deleted %d history records mentioning %s\n", changed, account)
+  } else {
+    fmt.Printf("pseudonymized %s in %d history records\n", account, changed)
+  }
+  if !p.everywhereElse(creds, false) {
+    logger.Print("Not every copy of ", account, " could be purged, see above")
+    os.Exit(1)
+  }
+  if archive != nil {
+    // the archive's objects can't be rewritten from here
+    logger.Print("The records already archived to ", creds.History.Archive.Url, " aren't changed, ", account, " isn't purged until they're removed there")
+    os.Exit(1)
+  }
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/url"
+  "strings"
+)
+
+// pushes each message to a phone through a self-hosted or public ntfy topic,
+// or a gotify server, without any commercial push service. the title is the
+// issue and what happened to it, and tapping the push opens the issue
+//
+//   targets:
+//     my-phone:
+//       type: ntfy
+//       url: https://ntfy.sh/alice-jira-8f3k   # the topic
+//       token: tk_...                          # for a protected topic
+//     my-gotify:
+//       type: gotify
+//       url: https://gotify.acme.com
+//       token: AbCdEf...                       # the application's token
+//       priorities: {Blocker: 8}
+//
+// priorities maps an issue's priority to the push's, 1 to 5 for ntfy and 0
+// to 10 for gotify. blockers and criticals are pushed urgently by default,
+// anything else at the service's default
+type pushNotifier struct {
+  service    string // ntfy or gotify
+  url        string
+  token      string
+  priorities map[string]int
+  creds      *Config
+  transport  *targetTransport
+}
+
+var pushPriorities = map[string]map[string]int{
+  "ntfy":   {"Blocker": 5, "Critical": 4, "": 3},
+  "gotify": {"Blocker": 8, "Critical": 6, "": 5},
+}
+
+func newPushNotifier(name string, target Target, creds *Config) (*pushNotifier, error) {
+  u, err := url.Parse(target.Url)
+  if err != nil || len(u.Host) == 0 {
+    return nil, fmt.Errorf("a %s target needs a url", target.Type)
+  }
+  if target.Type == "ntfy" && len(strings.Trim(u.Path, "/")) == 0 {
+    return nil, fmt.Errorf("an ntfy target's url has to include the topic, like https://ntfy.sh/TOPIC")
+  }
+  if target.Type == "gotify" {
+    if len(target.Token) == 0 {
+      return nil, fmt.Errorf("a gotify target needs an application token")
+    }
+    u.Path = strings.TrimSuffix(u.Path, "/") + "/message"
+  }
+  priorities := map[string]int{}
+  for priority, n := range pushPriorities[target.Type] {
+    priorities[priority] = n
+  }
+  min, max := 1, 5
+  if target.Type == "gotify" {
+    min, max = 0, 10
+  }
+  for priority, n := range target.Priorities {
+    if n < min || n > max {
+      return nil, fmt.Errorf("the %s priority for %s has to be between %d and %d", target.Type, priority, min, max)
+    }
+    priorities[priority] = n
+  }
+  return &pushNotifier{
+    service: target.Type, url: u.String(), token: target.Token, priorities: priorities,
+    creds: creds, transport: newTargetTransport(name, target),
+  }, nil
+}
+
+// the push's priority for the issue's
+func (n *pushNotifier) priority(event *Event) int {
+  if p, ok := n.priorities[fieldString(event.Fields, "priority.name")]; ok {
+    return p
+  }
+  return n.priorities[""]
+}
+
+func (n *pushNotifier) Notify(event *Event, message string) error {
+  title := fmt.Sprintf("%s %s", event.Issue.Key, event.Kind)
+  return n.push(title, message, n.creds.browseUrl(event.Issue.Key), n.priority(event))
+}
+
+// one push listing them all, as urgent as the most urgent of them
+func (n *pushNotifier) NotifyBatch(events []*Event, messages []string) error {
+  priority := 0
+  for _, event := range events {
+    if p := n.priority(event); p > priority {
+      priority = p
+    }
+  }
+  title := fmt.Sprintf("%d jira issues", len(events))
+  return n.push(title, "• "+strings.Join(messages, "\n• "), "", priority)
+}
+
+func (n *pushNotifier) push(title, message, click string, priority int) error {
+  client := n.transport.httpClient()
+  if n.service == "gotify" {
+    push := map[string]interface{}{"title": title, "message": message, "priority": priority}
+    if len(click) > 0 {
+      push["extras"] = map[string]interface{}{"client::notification": map[string]interface{}{"click": map[string]string{"url": click}}}
+    }
+    body, _ := json.Marshal(push)
+    return postContents(client, n.url, "application/json", map[string]string{"X-Gotify-Key": n.token}, body)
+  }
+  headers := map[string]string{"Title": title, "Priority": fmt.Sprint(priority), "Tags": "jira"}
+  if len(click) > 0 {
+    headers["Click"] = click
+  }
+  if len(n.token) > 0 {
+    headers["Authorization"] = "Bearer " + n.token
+  }
+  return postContents(client, n.url, "text/plain; charset=utf-8", headers, []byte(message))
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+  "fmt"
+  "net/http"
+  "net/url"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+)
+
+// a budget of calls an hour to each jira instance, configured under
+// `quota`, so the service account slows down before jira throttles it.
+// the calls are always counted, see /metrics
+//
+//   quota:
+//     budget: 3000                          # calls in any hour, per instance
+//     budgets: {other.atlassian.net: 1000}  # by host, instead of budget
+//     degrade_at: 0.8                       # the share of it to degrade at
+//     slowdown: 2                           # how much longer polls wait then
+//
+// an instance past degrade_at of its budget is polled slowdown times less
+// often, and isn't looked up for correlations. polls stop altogether when
+// the whole budget is spent, until the last hour's calls are under it again
+type QuotaConfig struct {
+  Budget    int            `yaml:"budget"`
+  Budgets   map[string]int `yaml:"budgets"`
+  DegradeAt float64        `yaml:"degrade_at"`
+  Slowdown  float64        `yaml:"slowdown"`
+}
+
+var quota = &quotaTracker{instances: map[string]*instanceCalls{}}
+
+type quotaTracker struct {
+  config QuotaConfig
+  creds  *Config
+
+  mu        sync.Mutex
+  instances map[string]*instanceCalls // host -> its calls
+}
+
+// the calls to one instance, by the minute over the last hour
+type instanceCalls struct {
+  minutes   [60]int
+  stamps    [60]int64 // the minute each of minutes counts
+  total     int       // since the tracker started
+  throttled int       // the calls jira answered 429
+  skipped   int       // polls skipped for the budget
+  degraded  bool
+}
+
+func (c *instanceCalls) add(now time.Time) {
+  minute := now.Unix() / 60
+  i := minute % 60
+  if c.stamps[i] != minute {
+    c.stamps[i], c.minutes[i] = minute, 0
+  }
+  c.minutes[i]++
+  c.total++
+}
+
+func (c *instanceCalls) lastHour(now time.Time) int {
+  minute := now.Unix() / 60
+  calls := 0
+  for i, stamp := range c.stamps {
+    if minute-stamp < 60 {
+      calls += c.minutes[i]
+    }
+  }
+  return calls
+}
+
+func loadQuota(creds *Config) {
+  c := creds.Quota
+  if c.DegradeAt <= 0 || c.DegradeAt > 1 {
+    c.DegradeAt = 0.8
+  }
+  if c.Slowdown < 1 {
+    c.Slowdown = 2
+  }
+  quota.mu.Lock()
+  quota.config, quota.creds = c, creds
+  quota.mu.Unlock()
+}
+
+// the host a jira url calls, which is what the budgets are by
+func quotaHost(uri string) string {
+  if u, err := url.Parse(uri); err == nil && len(u.Host) > 0 {
+    return u.Hostname()
+  }
+  return uri
+}
+
+func (q *quotaTracker) budget(host string) int {
+  if budget, ok := q.config.Budgets[host]; ok {
+    return budget
+  }
+  return q.config.Budget
+}
+
+func (q *quotaTracker) calls(host string) *instanceCalls {
+  c, ok := q.instances[host]
+  if !ok {
+    c = &instanceCalls{}
+    q.instances[host] = c
+  }
+  return c
+}
+
+// count a call, and tell the operator when an instance starts or stops
+// being degraded for it
+func (q *quotaTracker) record(host string, status int) {
+  q.mu.Lock()
+  now := time.Now()
+  c := q.calls(host)
+  c.add(now)
+  if status == http.StatusTooManyRequests {
+    c.throttled++
+  }
+  budget := q.budget(host)
+  if budget <= 0 {
+    q.mu.Unlock()
+    return
+  }
+  used := c.lastHour(now)
+  degraded := q.over(host, c, now)
+  changed := degraded != c.degraded
+  c.degraded = degraded
+  creds := q.creds
+  q.mu.Unlock()
+
+  if changed && degraded {
+    // not in the way of the call that crossed it
+    go alertOperator(creds, fmt.Sprintf("%d jira calls to %s in the last hour, %.0f%% of its budget of %d. Polling %g times slower and skipping correlations there",
+      used, host, float64(used)*100/float64(budget), budget, q.config.Slowdown))
+  } else if changed {
+    logger.Print("Jira calls to ", host, " are back under ", q.config.DegradeAt*100, "% of the budget, polling normally")
+  }
+}
+
+// whether the last hour's calls are past degrade_at of the budget
+func (q *quotaTracker) over(host string, c *instanceCalls, now time.Time) bool {
+  budget := q.budget(host)
+  return budget > 0 && float64(c.lastHour(now)) >= q.config.DegradeAt*float64(budget)
+}
+
+// whether calls to the instance at the url should be cut down. the calls
+// age out of the hour without new ones, so it's worked out again each time
+func (q *quotaTracker) degraded(uri string) bool {
+  q.mu.Lock()
+  defer q.mu.Unlock()
+  host := quotaHost(uri)
+  c, ok := q.instances[host]
+  return ok && q.over(host, c, time.Now())
+}
+
+// the time of a poll of the instance, later if it's degraded
+func (q *quotaTracker) stretch(uri string, next time.Time) time.Time {
+  if !q.degraded(uri) {
+    return next
+  }
+  wait := time.Until(next)
+  return next.Add(time.Duration(float64(wait) * (q.config.Slowdown - 1)))
+}
+
+// whether a poll of the instance should be skipped, its budget being
+// spent, counting the poll skipped if so
+func skipForQuota(uri string) bool {
+  q := quota
+  q.mu.Lock()
+  defer q.mu.Unlock()
+  host := quotaHost(uri)
+  budget := q.budget(host)
+  c, ok := q.instances[host]
+  if !ok || budget <= 0 || c.lastHour(time.Now()) < budget {
+    return false
+  }
+  c.skipped++
+  return true
+}
+
+// counts every request on its way to jira
+type quotaTransport struct {
+  next http.RoundTripper
+}
+
+func withQuota(client *http.Client) *http.Client {
+  next := client.Transport
+  if next == nil {
+    next = http.DefaultTransport
+  }
+  return &http.Client{Transport: &quotaTransport{next}, Timeout: client.Timeout}
+}
+
+func (t *quotaTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+  resp, err := t.next.RoundTrip(req)
+  status := 0
+  if resp != nil {
+    status = resp.StatusCode
+  }
+  quota.record(req.URL.Hostname(), status)
+  return resp, err
+}
+
+func writeQuotaMetrics(out *strings.Builder) {
+  q := quota
+  q.mu.Lock()
+  defer q.mu.Unlock()
+  hosts := []string{}
+  for host := range q.instances {
+    hosts = append(hosts, host)
+  }
+  sort.Strings(hosts)
+  now := time.Now()
+  out.WriteString("# HELP jira_tracker_jira_calls_total Calls to each jira instance.\n")
+  out.WriteString("# TYPE jira_tracker_jira_calls_total counter\n")
+  for _, host := range hosts {
+    fmt.Fprintf(out, "jira_tracker_jira_calls_total{instance=%q} %d\n", host, q.instances[host].total)
+  }
+  out.WriteString("# HELP jira_tracker_jira_calls_last_hour Calls to each jira instance in the last hour.\n")
+  out.WriteString("# TYPE jira_tracker_jira_calls_last_hour gauge\n")
+  for _, host := range hosts {
+    fmt.Fprintf(out, "jira_tracker_jira_calls_last_hour{instance=%q} %d\n", host, q.instances[host].lastHour(now))
+  }
+  out.WriteString("# HELP jira_tracker_jira_throttled_total Calls jira answered with 429 Too Many Requests.\n")
+  out.WriteString("# TYPE jira_tracker_jira_throttled_total counter\n")
+  for _, host := range hosts {
+    fmt.Fprintf(out, "jira_tracker_jira_throttled_total{instance=%q} %d\n", host, q.instances[host].throttled)
+  }
+  out.WriteString("# HELP jira_tracker_jira_call_budget The calls an hour each jira instance is budgeted.\n")
+  out.WriteString("# TYPE jira_tracker_jira_call_budget gauge\n")
+  for _, host := range hosts {
+    if budget := q.budget(host); budget > 0 {
+      fmt.Fprintf(out, "jira_tracker_jira_call_budget{instance=%q} %d\n", host, budget)
+    }
+  }
+  out.WriteString("# HELP jira_tracker_quota_degraded Whether an instance is polled less for its budget.\n")
+  out.WriteString("# TYPE jira_tracker_quota_degraded gauge\n")
+  for _, host := range hosts {
+    degraded := 0
+    if q.over(host, q.instances[host], now) {
+      degraded = 1
+    }
+    fmt.Fprintf(out, "jira_tracker_quota_degraded{instance=%q} %d\n", host, degraded)
+  }
+  out.WriteString("# HELP jira_tracker_quota_skipped_polls_total Polls skipped with the budget spent.\n")
+  out.WriteString("# TYPE jira_tracker_quota_skipped_polls_total counter\n")
+  for _, host := range hosts {
+    fmt.Fprintf(out, "jira_tracker_quota_skipped_polls_total{instance=%q} %d\n", host, q.instances[host].skipped)
+  }
+}
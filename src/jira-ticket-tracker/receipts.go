@@ -0,0 +1,178 @@
+package main
+
+import (
+  "crypto/rand"
+  "encoding/hex"
+  "fmt"
+  "net/http"
+  "strings"
+  "time"
+)
+
+const eventUnseen = "notification-unseen"
+
+const (
+  receiptInterval = time.Minute
+  receiptKeep     = 24 * time.Hour // after being seen or escalated
+)
+
+// read receipts for a target's messages, configured per target under
+// `receipt`. a message nobody has seen within the timeout is escalated as a
+// notification-unseen event. slack messages count as seen once someone
+// reacts to them (the target needs a bot token with reactions:read), html
+// emails once their open pixel is loaded from the control API
+//
+//   receipt:
+//     timeout: 15m
+//     escalate: [oncall-pager]
+//     pixel: https://tracker.example.com:8080  # for email, where --listen is reachable
+type ReceiptConfig struct {
+  Timeout  string   `yaml:"timeout"`
+  Escalate []string `yaml:"escalate"`
+  Pixel    string   `yaml:"pixel"`
+}
+
+func (c *ReceiptConfig) enabled() bool {
+  return len(c.Timeout) > 0
+}
+
+// a message sent to a target that we're waiting for someone to see
+type Receipt struct {
+  Id        string        `json:"id"`
+  Target    string        `json:"target"`
+  Key       string        `json:"key"`
+  Kind      string        `json:"kind"`
+  Sent      time.Time     `json:"sent"`
+  Seen      *time.Time    `json:"seen,omitempty"`
+  Escalated bool          `json:"escalated,omitempty"`
+  Slack     *slackMessage `json:"slack,omitempty"`
+}
+
+func newReceipt(target string, event *Event) *Receipt {
+  id := make([]byte, 12)
+  rand.Read(id)
+  return &Receipt{
+    Id:     hex.EncodeToString(id),
+    Target: target,
+    Key:    event.Issue.Key,
+    Kind:   event.Kind,
+    Sent:   time.Now(),
+  }
+}
+
+// a receiptNotifier can send a message in a way that lets us tell when it
+// has been seen, filling in the receipt with whatever it needs for that.
+// confirmsReceipts is false when it's configured in a way that can't
+type receiptNotifier interface {
+  NotifyTracked(event *Event, message string, receipt *Receipt) error
+  confirmsReceipts() bool
+}
+
+// a webhook can't be checked for reactions
+func (n *slackNotifier) confirmsReceipts() bool { return len(n.token) > 0 }
+
+func (n *slackNotifier) NotifyTracked(event *Event, message string, receipt *Receipt) error {
+  posted, err := n.post(event, message)
+  if err == nil {
+    receipt.Slack = &posted
+  }
+  return err
+}
+
+// only html with a pixel can tell it was opened
+func (n *emailNotifier) confirmsReceipts() bool { return n.html && len(n.pixel) > 0 }
+
+func (n *emailNotifier) NotifyTracked(event *Event, message string, receipt *Receipt) error {
+  message += fmt.Sprintf(`<img src="%s/receipts/%s.gif" width="1" height="1" alt="">`,
+    strings.TrimRight(n.pixel, "/"), receipt.Id)
+  return n.Notify(event, message)
+}
+
+// whether the sink's messages are tracked until they're seen
+func (s *sink) tracksReceipts() bool {
+  tracker, ok := s.notifier.(receiptNotifier)
+  return ok && s.target.Receipt.enabled() && tracker.confirmsReceipts()
+}
+
+// check the unseen messages and escalate the ones past their timeout
+func chaseReceipts(c chan []*Event, creds *Config) {
+  for {
+    time.Sleep(receiptInterval)
+    producers.RLock()
+
+    for _, r := range state.AllReceipts() {
+      s, ok := sinks[r.Target]
+      if !ok {
+        state.ForgetReceipt(r.Id)
+        continue
+      }
+      if r.Seen != nil || r.Escalated {
+        if time.Since(r.Sent) > receiptKeep {
+          state.ForgetReceipt(r.Id)
+        }
+        continue
+      }
+
+      if r.Slack != nil {
+        reacted, err := slackReacted(s.target.Token, *r.Slack)
+        if err != nil {
+          logger.Print("Error checking reactions for ", r.Key, " on ", r.Target, ": ", err)
+        } else if reacted {
+          state.MarkReceiptSeen(r.Id)
+          continue
+        }
+      }
+
+      timeout := durationOr(s.target.Receipt.Timeout, 0)
+      if time.Since(r.Sent) < timeout {
+        continue
+      }
+      contents := jiraIssue(r.Key, creds)
+      if contents == nil {
+        continue
+      }
+      issue, fields, err := parseIssue(contents)
+      if err != nil {
+        logger.Print("Error parsing issue ", r.Key, ": ", err)
+        continue
+      }
+      event := newEvent(eventUnseen, issue, fields)
+      event.Detail = fmt.Sprintf("the %s notification on %s wasn't seen within %s", r.Kind, r.Target, s.target.Receipt.Timeout)
+      event.Targets = s.target.Receipt.Escalate
+      state.MarkReceiptEscalated(r.Id)
+      c <- []*Event{event}
+    }
+    producers.RUnlock()
+  }
+}
+
+func receiptsEnabled(creds *Config) bool {
+  for _, target := range creds.Targets {
+    if target.Receipt.enabled() {
+      return true
+    }
+  }
+  return false
+}
+
+// a transparent 1x1 gif
+var pixelGif = []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00\x00\x00\x00\x00\x00\x00!\xf9\x04\x01\x00\x00\x00\x00,\x00\x00\x00\x00\x01\x00\x01\x00\x00\x02\x02D\x01\x00;")
+
+//   GET /receipts             list the messages waiting to be seen
+//   GET /receipts/ID.gif      an email open pixel, marks the message seen
+func handleReceipts(w http.ResponseWriter, r *http.Request) {
+  if r.Method != "GET" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+  id := strings.TrimPrefix(r.URL.Path, "/receipts")
+  id = strings.TrimSuffix(strings.TrimPrefix(id, "/"), ".gif")
+  if len(id) == 0 {
+    writeJSON(w, http.StatusOK, state.AllReceipts())
+    return
+  }
+  state.MarkReceiptSeen(id)
+  w.Header().Set("Content-Type", "image/gif")
+  w.Header().Set("Cache-Control", "no-store")
+  w.Write(pixelGif)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/url"
+  "strings"
+  "sync"
+  "time"
+)
+
+// lists of people (email recipients, auto-assign candidates, first response
+// teams) can name jira groups and project roles as well as users:
+//
+//   to: [alice@example.com, "group:ops-team", "role:Developers", "role:OPS/Administrators"]
+//
+// a role without a project is the issue's project's. they are expanded
+// through the api when used, and the result cached for a while
+const expansionCacheTTL = 10 * time.Minute
+
+var expansionCache = struct {
+  sync.Mutex
+  users map[string]cachedExpansion
+}{users: map[string]cachedExpansion{}}
+
+type cachedExpansion struct {
+  users   []string
+  fetched time.Time
+}
+
+// the users in a project role, including the members of its groups
+func roleMembers(project, role string, creds *Config) ([]string, error) {
+  contents, err := jiraCached("/project/"+url.PathEscape(project)+"/role", creds)
+  if err != nil {
+    return nil, err
+  }
+  var roles map[string]string // name -> url
+  if err := json.Unmarshal(contents, &roles); err != nil {
+    return nil, err
+  }
+  var roleUrl string
+  for name, u := range roles {
+    if strings.EqualFold(name, role) {
+      roleUrl = u
+    }
+  }
+  if len(roleUrl) == 0 {
+    return nil, fmt.Errorf("%s has no role %s", project, role)
+  }
+  // the api gives full urls, we need the part after the base
+  i := strings.Index(roleUrl, "/project/")
+  if i < 0 {
+    return nil, fmt.Errorf("unexpected role url %s", roleUrl)
+  }
+  contents, err = jiraCached(roleUrl[i:], creds)
+  if err != nil {
+    return nil, err
+  }
+  var details struct {
+    Actors []struct {
+      Type string `json:"type"`
+      Name string `json:"name"`
+    } `json:"actors"`
+  }
+  if err := json.Unmarshal(contents, &details); err != nil {
+    return nil, err
+  }
+  users := []string{}
+  for _, actor := range details.Actors {
+    if actor.Type == "atlassian-group-role-actor" {
+      members, err := groupMembers(actor.Name, creds)
+      if err != nil {
+        return nil, err
+      }
+      users = addTargets(users, members)
+    } else {
+      users = addTargets(users, []string{actor.Name})
+    }
+  }
+  return users, nil
+}
+
+// expand one entry of a list into user names, leaving plain users alone
+func expandPrincipal(entry, project string, creds *Config) ([]string, error) {
+  var kind, name string
+  if strings.HasPrefix(entry, "group:") {
+    kind, name = "group", strings.TrimPrefix(entry, "group:")
+  } else if strings.HasPrefix(entry, "role:") {
+    kind, name = "role", strings.TrimPrefix(entry, "role:")
+    if i := strings.Index(name, "/"); i >= 0 {
+      project, name = name[:i], name[i+1:]
+    }
+    if len(project) == 0 {
+      return nil, fmt.Errorf("role %s needs a project", name)
+    }
+  } else {
+    return []string{entry}, nil
+  }
+
+  cacheKey := kind + ":" + project + ":" + name
+  expansionCache.Lock()
+  cached, ok := expansionCache.users[cacheKey]
+  expansionCache.Unlock()
+  if ok && time.Since(cached.fetched) < expansionCacheTTL {
+    return cached.users, nil
+  }
+
+  var users []string
+  var err error
+  if kind == "group" {
+    users, err = groupMembers(name, creds)
+  } else {
+    users, err = roleMembers(project, name, creds)
+  }
+  if err != nil {
+    return nil, err
+  }
+  expansionCache.Lock()
+  expansionCache.users[cacheKey] = cachedExpansion{users, time.Now()}
+  expansionCache.Unlock()
+  return users, nil
+}
+
+// expand every group and role in a list. entries that fail to expand are
+// logged and skipped so one bad group doesn't stop the rest
+func expandPrincipals(list []string, project string, creds *Config) []string {
+  users := []string{}
+  for _, entry := range list {
+    expanded, err := expandPrincipal(entry, project, creds)
+    if err != nil {
+      logger.Print("Error expanding ", entry, ": ", err)
+      continue
+    }
+    users = addTargets(users, expanded)
+  }
+  return users
+}
+
+// the email address of a jira user
+func userEmail(name string, creds *Config) (string, error) {
+  contents, err := jiraCached("/user?username="+url.QueryEscape(name), creds)
+  if err != nil {
+    return "", err
+  }
+  var u struct {
+    EmailAddress string `json:"emailAddress"`
+  }
+  if err := json.Unmarshal(contents, &u); err != nil {
+    return "", err
+  }
+  if len(u.EmailAddress) == 0 {
+    return "", fmt.Errorf("%s has no visible email address", name)
+  }
+  return u.EmailAddress, nil
+}
+
+// an email address to send to and, for the members of groups and roles,
+// the jira user it's for
+type emailRecipient struct {
+  address string
+  user    string
+}
+
+// email recipients with groups and roles expanded to their members' addresses
+func expandRecipients(list []string, project string, creds *Config) []emailRecipient {
+  recipients := []emailRecipient{}
+  for _, entry := range list {
+    if !strings.HasPrefix(entry, "group:") && !strings.HasPrefix(entry, "role:") {
+      recipients = append(recipients, emailRecipient{address: entry})
+      continue
+    }
+    for _, user := range expandPrincipals([]string{entry}, project, creds) {
+      email, err := userEmail(user, creds)
+      if err != nil {
+        logger.Print("Error finding the email of ", user, ": ", err)
+        continue
+      }
+      recipients = append(recipients, emailRecipient{address: email, user: user})
+    }
+  }
+  return recipients
+}
+
+func issueProject(event *Event) string {
+  if event.Issue.Fields != nil && event.Issue.Fields.Project != nil {
+    return event.Issue.Fields.Project.Key
+  }
+  return ""
+}
+
+// a rule's users with its groups and roles expanded. they're expanded again
+// once the cache is stale, so a rule follows the group as its membership
+// changes
+type ruleMembers struct {
+  sync.Mutex
+  creds   *Config
+  users   []string
+  fetched time.Time
+}
+
+// the users the rule follows. if an entry can't be expanded the previous
+// members are kept until the next try
+func (r *Rule) users() []string {
+  m := r.members
+  if m == nil {
+    return r.Users
+  }
+  m.Lock()
+  defer m.Unlock()
+  if len(m.users) > 0 && time.Since(m.fetched) < expansionCacheTTL {
+    return m.users
+  }
+  m.fetched = time.Now()
+  users := []string{}
+  for _, entry := range r.Users {
+    expanded, err := expandPrincipal(entry, r.Project, m.creds)
+    if err != nil {
+      logger.Print("Error expanding ", entry, " for rule ", r.Name, ": ", err)
+      if len(m.users) > 0 {
+        return m.users
+      }
+      continue
+    }
+    users = addTargets(users, expanded)
+  }
+  if len(m.users) > 0 && len(users) != len(m.users) {
+    logger.Print("Rule ", r.Name, " now follows ", len(users), " users, it followed ", len(m.users))
+  }
+  m.users = users
+  return users
+}
+
+// the jql for the rule's users. with none, e.g. an empty group, the rule
+// matches nothing rather than everything
+func (r *Rule) usersJql() string {
+  users := r.users()
+  if len(users) == 0 {
+    return r.Field + " is EMPTY AND " + r.Field + " is not EMPTY"
+  }
+  quoted := []string{}
+  for _, user := range users {
+    quoted = append(quoted, jqlQuote(user))
+  }
+  return r.Field + " in (" + strings.Join(quoted, ", ") + ")"
+}
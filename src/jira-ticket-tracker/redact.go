@@ -0,0 +1,90 @@
+package main
+
+import (
+  "github.com/plouc/go-jira-client"
+)
+
+// a FieldPolicy limits what of an issue a target sees, in its messages and
+// payloads, e.g. a public wallboard channel only getting the key and
+// summary. every target's events go through it before rendering
+//
+//   fields:
+//     only: [summary, status, priority]  # plus the key, project and type
+//     strip: [description]               # or drop just these
+//     anonymize: true                    # and/or hash the people, drop the free text
+//     salt: ...                          # what the hashes are salted with, required
+//     keep: [customfield_10020]          # text custom fields to keep anyway, or computed
+//
+// computed fields, annotations and correlations are names too ("computed",
+// "annotation", "correlations")
+// and are dropped along with everything else not in only. anonymizing is
+// for analytics targets, see anonymize.go
+type FieldPolicy struct {
+  Only      []string `yaml:"only"`
+  Strip     []string `yaml:"strip"`
+  Anonymize bool     `yaml:"anonymize"`
+  Salt      string   `yaml:"salt"`
+  Keep      []string `yaml:"keep"`
+}
+
+func (p *FieldPolicy) allowed(name string) bool {
+  if contains(p.Strip, name) || (p.Anonymize && contains(freeTextFields, name)) {
+    return false
+  }
+  return len(p.Only) == 0 || contains(p.Only, name)
+}
+
+// a copy of the event with only what the policy allows, or the event
+// itself if there is no policy
+func (p *FieldPolicy) redact(event *Event) *Event {
+  if len(p.Only) == 0 && len(p.Strip) == 0 && !p.Anonymize {
+    return event
+  }
+  copied := *event
+
+  fields := map[string]interface{}{}
+  for name, value := range event.Fields {
+    if p.allowed(name) {
+      fields[name] = value
+    }
+  }
+  copied.Fields = fields
+
+  if event.Issue != nil {
+    issue := *event.Issue
+    if event.Issue.Fields != nil {
+      f := gojira.IssueFields{Project: event.Issue.Fields.Project, IssueType: event.Issue.Fields.IssueType}
+      if p.allowed("summary") {
+        f.Summary = event.Issue.Fields.Summary
+      }
+      if p.allowed("description") {
+        f.Description = event.Issue.Fields.Description
+      }
+      if p.allowed("reporter") {
+        f.Reporter = event.Issue.Fields.Reporter
+      }
+      if p.allowed("assignee") {
+        f.Assignee = event.Issue.Fields.Assignee
+      }
+      if p.allowed("created") {
+        f.Created = event.Issue.Fields.Created
+      }
+      issue.Fields = &f
+    }
+    copied.Issue = &issue
+  }
+
+  if !p.allowed("computed") {
+    copied.Computed = nil
+  }
+  if !p.allowed("annotation") {
+    copied.Annotation = nil
+  }
+  if !p.allowed("correlations") {
+    copied.Correlations = nil
+  }
+  if p.Anonymize {
+    p.anonymize(&copied)
+  }
+  return &copied
+}
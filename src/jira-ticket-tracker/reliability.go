@@ -0,0 +1,182 @@
+package main
+
+import (
+  "fmt"
+  "os"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+)
+
+const eventOperatorDigest = "operator-digest"
+
+// the weekly reliability summary sent to the operator targets with
+// `operator: {digest: true}`. each rule's polls count against its error
+// budget, the share of polls allowed to fail, and a rule that matches a
+// burst of issues and then goes quiet is counted as flapping
+//
+//   operator:
+//     targets: [ops-slack]
+//     digest: true
+//     error_budget: 1%   # the default
+//     flapping:
+//       burst: 5         # this many matches
+//       window: 10m      # within this long
+//       silence: 1h      # then none for this long
+type FlapConfig struct {
+  Burst   int    `yaml:"burst"`
+  Window  string `yaml:"window"`
+  Silence string `yaml:"silence"`
+}
+
+// the polls of one rule in a week
+type RuleReliability struct {
+  Polls         int `json:"polls"`
+  Failures      int `json:"failures"`
+  LongestStreak int `json:"longest_streak"` // of failures in a row
+  Matches       int `json:"matches"`
+  Flaps         int `json:"flaps"`
+}
+
+var reliability = &reliabilityTracker{rules: map[string]*flapState{}}
+
+type reliabilityTracker struct {
+  budget  float64
+  burst   int
+  window  time.Duration
+  silence time.Duration
+
+  mu    sync.Mutex
+  rules map[string]*flapState
+}
+
+// the recent matches of a rule, to tell a burst followed by silence
+type flapState struct {
+  matches   []time.Time
+  bursting  bool
+  lastMatch time.Time
+  streak    int
+}
+
+func loadReliability(creds *Config) {
+  config := creds.Operator
+  reliability = &reliabilityTracker{
+    budget:  0.01,
+    burst:   config.Flapping.Burst,
+    window:  durationOr(config.Flapping.Window, 10*time.Minute),
+    silence: durationOr(config.Flapping.Silence, time.Hour),
+    rules:   map[string]*flapState{},
+  }
+  if reliability.burst == 0 {
+    reliability.burst = 5
+  }
+  if len(config.ErrorBudget) > 0 {
+    var percent float64
+    if _, err := fmt.Sscanf(strings.TrimSuffix(config.ErrorBudget, "%"), "%g", &percent); err != nil || percent < 0 || percent > 100 {
+      logger.Print("Invalid error budget ", config.ErrorBudget)
+      os.Exit(1)
+    }
+    reliability.budget = percent / 100
+  }
+}
+
+// count a poll, returning the rule's failures in a row and whether the
+// poll ended a flap
+func (t *reliabilityTracker) record(rule string, matches int, err error) (streak int, flapped bool) {
+  t.mu.Lock()
+  defer t.mu.Unlock()
+
+  f, ok := t.rules[rule]
+  if !ok {
+    f = &flapState{}
+    t.rules[rule] = f
+  }
+  if err != nil {
+    f.streak++
+    return f.streak, false
+  }
+  f.streak = 0
+
+  now := time.Now()
+  if matches > 0 {
+    for i := 0; i < matches; i++ {
+      f.matches = append(f.matches, now)
+    }
+    recent := f.matches[:0]
+    for _, at := range f.matches {
+      if now.Sub(at) <= t.window {
+        recent = append(recent, at)
+      }
+    }
+    f.matches = recent
+    f.lastMatch = now
+    if len(f.matches) >= t.burst {
+      f.bursting = true
+    }
+    return 0, false
+  }
+  if f.bursting && now.Sub(f.lastMatch) >= t.silence {
+    f.bursting = false
+    f.matches = nil
+    return 0, true
+  }
+  return 0, false
+}
+
+func recordReliability(rule string, matches int, err error) {
+  streak, flapped := reliability.record(rule, matches, err)
+  if state != nil {
+    state.RecordReliability(weekOf(time.Now()), rule, matches, err != nil, streak, flapped)
+  }
+}
+
+func formatReliabilityReport(week string, rules map[string]RuleReliability) string {
+  names := []string{}
+  for name := range rules {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  lines := []string{fmt.Sprintf("Rule reliability for %s (error budget %g%% of polls):", week, reliability.budget*100)}
+  for _, name := range names {
+    r := rules[name]
+    failed := 0.0
+    if r.Polls > 0 {
+      failed = float64(r.Failures) / float64(r.Polls)
+    }
+    used := "no budget"
+    if reliability.budget > 0 {
+      used = fmt.Sprintf("%.0f%% of the budget", failed/reliability.budget*100)
+    }
+    status := "ok"
+    if failed > reliability.budget {
+      status = "OVER BUDGET"
+    } else if r.Flaps > 0 {
+      status = "FLAPPING"
+    }
+    lines = append(lines, fmt.Sprintf("%s: %d of %d polls failed (%s, at most %d in a row), %d matches, %d flaps, %s",
+      name, r.Failures, r.Polls, used, r.LongestStreak, r.Matches, r.Flaps, status))
+  }
+  if len(names) == 0 {
+    lines = append(lines, "no polls were recorded")
+  }
+  return strings.Join(lines, "\n")
+}
+
+// send last week's digest to the operator once the week is over
+func sendOperatorDigestWeekly(creds *Config) {
+  for {
+    last := weekOf(time.Now().AddDate(0, 0, -7))
+    if reported := state.DigestReported(); len(reported) == 0 {
+      state.SetDigestReported(last) // nothing was counted for it
+    } else if reported != last {
+      if shards.leading() {
+        event := trackerEvent(eventOperatorDigest, formatReliabilityReport(last, state.ReliabilityWeek(last)))
+        event.Targets = creds.Operator.Targets
+        deliver([]*Event{event})
+      }
+      state.SetDigestReported(last)
+    }
+    time.Sleep(time.Hour)
+  }
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+  "os"
+  "time"
+)
+
+// how often tracked issues are checked for approaching due dates
+const reminderInterval = time.Minute
+
+const (
+  eventReminder = "reminder"
+  overdue       = "overdue"
+)
+
+// reminders about approaching due dates, configured under `reminders`
+//
+//   reminders:
+//     offsets: [3d, 1d, overdue]
+//     targets: [ops-slack]
+//
+// each offset fires once per issue and due date. the targets are the rule's
+// `on: {reminder: [...]}` if it has one, otherwise these, otherwise the
+// rule's own targets, plus anyone subscribed to the issue
+type ReminderConfig struct {
+  Offsets []string `yaml:"offsets"`
+  Targets []string `yaml:"targets"`
+}
+
+type reminderOffset struct {
+  name   string
+  before time.Duration
+}
+
+func parseReminderOffsets(offsets []string) []reminderOffset {
+  parsed := []reminderOffset{}
+  for _, o := range offsets {
+    if o == overdue {
+      // due dates are days, so an issue is overdue once its day is over
+      parsed = append(parsed, reminderOffset{o, -24 * time.Hour})
+      continue
+    }
+    d, err := parseDuration(o)
+    if err != nil {
+      logger.Print("Invalid reminder offset ", o, ": ", err)
+      os.Exit(1)
+    }
+    parsed = append(parsed, reminderOffset{o, d})
+  }
+  return parsed
+}
+
+// the offsets that are due now and haven't been sent for this due date yet.
+// they're wall clock time before the due date, which is a day in the
+// timezone of the issue's calendar if its project has one
+func dueReminders(due time.Time, offsets []reminderOffset, sent []string, now time.Time) []reminderOffset {
+  fire := []reminderOffset{}
+  for _, o := range offsets {
+    if now.Before(due.Add(-o.before)) {
+      continue
+    }
+    if !contains(sent, o.name) {
+      fire = append(fire, o)
+    }
+  }
+  return fire
+}
+
+func remindDueIssues(rules []*Rule, creds *Config, c chan []*Event) {
+  offsets := parseReminderOffsets(creds.Reminders.Offsets)
+  rulesByName := map[string]*Rule{}
+  for _, rule := range rules {
+    rulesByName[rule.Name] = rule
+  }
+
+  for {
+    time.Sleep(reminderInterval)
+    producers.RLock()
+    now := time.Now()
+
+    for key, tracked := range state.TrackedIssues() {
+      if !shards.follows(rulesByName, tracked.Rule) {
+        continue
+      }
+      snapshot := tracked.Snapshot
+      if snapshot == nil || len(snapshot.Due) == 0 || len(snapshot.Resolution) > 0 {
+        continue
+      }
+      location := time.Local
+      if cal := calendarFor(creds, key); cal != nil {
+        location = cal.location
+      }
+      due, err := time.ParseInLocation("2006-01-02", snapshot.Due, location)
+      if err != nil {
+        logger.Print("Error parsing due date ", snapshot.Due, " of ", key, ": ", err)
+        continue
+      }
+      sent := tracked.RemindersSent
+      if tracked.RemindersDue != snapshot.Due {
+        sent = nil // the due date moved, start over
+      }
+
+      fire := dueReminders(due, offsets, sent, now)
+      if len(fire) == 0 {
+        continue
+      }
+      // if several offsets passed at once (e.g. an issue was given a due
+      // date that is already close) only the most urgent is sent
+      urgent := fire[0]
+      for _, o := range fire[1:] {
+        if o.before < urgent.before {
+          urgent = o
+        }
+      }
+
+      event := reminderEvent(key, urgent, creds)
+      if event == nil {
+        continue // try again next time
+      }
+      event.Targets = creds.Reminders.Targets
+      if rule, ok := rulesByName[tracked.Rule]; ok {
+        event.Rule = rule.Name
+        if targets, ok := rule.On[eventReminder]; ok {
+          event.Targets = targets
+        } else if len(event.Targets) == 0 {
+          event.Targets = rule.Targets
+        }
+      }
+      event.Targets = addTargets(event.Targets, state.Subscribers(key))
+      for _, o := range fire {
+        state.MarkReminder(key, snapshot.Due, o.name)
+      }
+      c <- []*Event{event}
+    }
+    producers.RUnlock()
+  }
+}
+
+func reminderEvent(key string, o reminderOffset, creds *Config) *Event {
+  contents := jiraIssue(key, creds)
+  if contents == nil {
+    return nil
+  }
+  issue, fields, err := parseIssue(contents)
+  if err != nil {
+    logger.Print("Error parsing issue ", key, ": ", err)
+    return nil
+  }
+  event := newEvent(eventReminder, issue, fields)
+  if o.name == overdue {
+    event.Detail = "overdue since " + fieldString(fields, "duedate")
+  } else {
+    event.Detail = "due in " + o.name + " on " + fieldString(fields, "duedate")
+  }
+  return event
+}
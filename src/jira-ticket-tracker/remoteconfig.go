@@ -0,0 +1,261 @@
+package main
+
+import (
+  "crypto/ed25519"
+  "encoding/base64"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "launchpad.net/goyaml"
+  "net/http"
+  "net/url"
+  "strings"
+  "sync"
+  "time"
+)
+
+// --config can also be a url, for fleets of trackers managed centrally:
+//
+//   --config=https://config.acme.com/trackers/ops.yaml
+//   --config=consul://consul.acme.com:8500/trackers/ops
+//
+// http servers are polled with the etag of the last version, consul with a
+// blocking query on the key. with --config-public-key the config has to be
+// signed with the matching ed25519 key: over http the base64 signature is
+// in the X-Config-Signature header, in consul it's the value of KEY.sig.
+// when the config changes the new rules are checked, see remoteRules, and
+// swapped in while running, anything else takes a restart
+var (
+  configPublicKey = flag.String("config-public-key", "", "The base64 ed25519 key a remote config must be signed with")
+  configPoll      = flag.Duration("config-poll", time.Minute, "How often to check a remote config for changes")
+)
+
+func remoteConfigUrl(path string) bool {
+  return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "consul://")
+}
+
+var remoteConfig = &remoteConfigWatcher{client: &http.Client{Timeout: 10 * time.Minute}}
+
+type remoteConfigWatcher struct {
+  client *http.Client
+
+  mu       sync.Mutex
+  etag     string // or the consul index
+  contents []byte
+  settings string // the rest of the config when it was loaded, see configWithoutRules
+}
+
+// fetch the config over http, along with its signature if it has one
+func (w *remoteConfigWatcher) get(u string, blocking bool) (contents []byte, signature string, changed bool, err error) {
+  w.mu.Lock()
+  etag := w.etag
+  w.mu.Unlock()
+
+  req, err := http.NewRequest("GET", u, nil)
+  if err != nil {
+    return nil, "", false, err
+  }
+  if len(etag) > 0 && blocking {
+    req.Header.Set("If-None-Match", etag)
+  }
+  resp, err := w.client.Do(req)
+  if err != nil {
+    return nil, "", false, err
+  }
+  defer drainAndClose(resp.Body)
+  if resp.StatusCode == http.StatusNotModified {
+    return nil, "", false, nil
+  }
+  if resp.StatusCode >= 300 {
+    return nil, "", false, fmt.Errorf("GET %s returned %s", u, resp.Status)
+  }
+  if contents, err = ioutil.ReadAll(resp.Body); err != nil {
+    return nil, "", false, err
+  }
+  w.mu.Lock()
+  w.etag = resp.Header.Get("ETag")
+  w.mu.Unlock()
+  return contents, resp.Header.Get("X-Config-Signature"), true, nil
+}
+
+// the same from a consul key
+func (w *remoteConfigWatcher) getConsul(u *url.URL, blocking bool) (contents []byte, signature string, changed bool, err error) {
+  key := strings.TrimPrefix(u.Path, "/")
+  kv := func(key string, query url.Values) ([]byte, string, error) {
+    query.Set("raw", "")
+    req, err := http.NewRequest("GET", "http://"+u.Host+"/v1/kv/"+key+"?"+query.Encode(), nil)
+    if err != nil {
+      return nil, "", err
+    }
+    resp, err := w.client.Do(req)
+    if err != nil {
+      return nil, "", err
+    }
+    defer drainAndClose(resp.Body)
+    if resp.StatusCode >= 300 {
+      return nil, "", fmt.Errorf("consul key %s returned %s", key, resp.Status)
+    }
+    contents, err := ioutil.ReadAll(resp.Body)
+    return contents, resp.Header.Get("X-Consul-Index"), err
+  }
+
+  w.mu.Lock()
+  index := w.etag
+  w.mu.Unlock()
+  query := url.Values{}
+  if len(index) > 0 && blocking {
+    // consul holds the request until the key changes or the wait is up
+    query.Set("index", index)
+    query.Set("wait", "5m")
+  }
+  contents, newIndex, err := kv(key, query)
+  if err != nil {
+    return nil, "", false, err
+  }
+  if newIndex == index && blocking {
+    return nil, "", false, nil
+  }
+  if len(*configPublicKey) > 0 {
+    sig, _, err := kv(key+".sig", url.Values{})
+    if err != nil {
+      return nil, "", false, err
+    }
+    signature = strings.TrimSpace(string(sig))
+  }
+  w.mu.Lock()
+  w.etag = newIndex
+  w.mu.Unlock()
+  return contents, signature, true, nil
+}
+
+func verifyConfig(contents []byte, signature string) error {
+  if len(*configPublicKey) == 0 {
+    return nil
+  }
+  key, err := base64.StdEncoding.DecodeString(*configPublicKey)
+  if err != nil || len(key) != ed25519.PublicKeySize {
+    return fmt.Errorf("invalid --config-public-key")
+  }
+  sig, err := base64.StdEncoding.DecodeString(signature)
+  if err != nil || !ed25519.Verify(ed25519.PublicKey(key), contents, sig) {
+    return fmt.Errorf("the config's signature doesn't match")
+  }
+  return nil
+}
+
+// fetch the config, returning nil if it hasn't changed. with blocking set
+// it waits for a change where the server supports it
+func (w *remoteConfigWatcher) fetch(path string, blocking bool) ([]byte, error) {
+  u, err := url.Parse(path)
+  if err != nil {
+    return nil, err
+  }
+  var contents []byte
+  var signature string
+  var changed bool
+  if u.Scheme == "consul" {
+    contents, signature, changed, err = w.getConsul(u, blocking)
+  } else {
+    contents, signature, changed, err = w.get(path, blocking)
+  }
+  if err != nil || !changed {
+    return nil, err
+  }
+  if err := verifyConfig(contents, signature); err != nil {
+    return nil, err
+  }
+  w.mu.Lock()
+  defer w.mu.Unlock()
+  if string(contents) == string(w.contents) {
+    return nil, nil
+  }
+  w.contents = contents
+  return contents, nil
+}
+
+// the config without its rules, to tell whether anything else changed
+func configWithoutRules(config Config) string {
+  config.Rules, config.RuleTemplates = nil, nil
+  contents, _ := goyaml.Marshal(config)
+  return string(contents)
+}
+
+// the new config's rules, built and checked like the ones the tracker
+// started with. they go with the running targets and settings, since only
+// the rules and their templates are swapped in, and a rule sending to a
+// target the tracker doesn't have refuses the whole config. lint warnings
+// are only logged
+func remoteRules(config *Config, creds *Config) ([]*Rule, error) {
+  running := *creds
+  running.RuleTemplates = config.RuleTemplates
+  rules, err := buildRules(config.Rules, &running)
+  if err != nil {
+    return nil, err
+  }
+  for _, rule := range rules {
+    for _, target := range allRuleTargets(rule) {
+      if _, ok := creds.Targets[target]; !ok {
+        return nil, fmt.Errorf("rule %s sends to unknown target %s", rule.Name, target)
+      }
+    }
+  }
+  for _, warning := range lintRules(rules, creds, nil) {
+    logger.Print("Warning in the new config's rules: ", warning)
+  }
+  return rules, nil
+}
+
+// keep checking the remote config and swap in its rules when they change
+func (w *remoteConfigWatcher) watch(path string, creds *Config) {
+  for {
+    contents, err := w.fetch(path, true)
+    if err != nil {
+      logger.Print("Error fetching the config, keeping the current one: ", err)
+      time.Sleep(*configPoll)
+      continue
+    }
+    if contents == nil {
+      if !strings.HasPrefix(path, "consul://") {
+        time.Sleep(*configPoll)
+      }
+      continue
+    }
+
+    var config Config
+    if err := goyaml.Unmarshal(contents, &config); err != nil {
+      logger.Print("Error parsing the new config, keeping the current one: ", err)
+      continue
+    }
+    if configWithoutRules(config) != w.settings {
+      logger.Print("Settings other than the rules changed in the config, they take effect after a restart")
+    }
+    if gitops.enabled() {
+      continue // the rules come from git
+    }
+    rules, err := remoteRules(&config, creds)
+    if err != nil {
+      logger.Print("Error in the new config's rules, keeping the current ones: ", err)
+      continue
+    }
+    added, changed, removed := applyRules(append(flagRules(creds), rules...))
+    logger.Print(fmt.Sprintf("The config changed: %d rules added, %d changed, %d removed", added, changed, removed))
+  }
+}
+
+// the config the tracker starts with
+func (w *remoteConfigWatcher) load(path string) ([]byte, error) {
+  contents, err := w.fetch(path, false)
+  if err != nil {
+    return nil, err
+  }
+  if contents == nil {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    return w.contents, nil // loaded already
+  }
+  var config Config
+  if err := goyaml.Unmarshal(contents, &config); err == nil {
+    w.settings = configWithoutRules(config)
+  }
+  return contents, nil
+}
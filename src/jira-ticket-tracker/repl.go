@@ -0,0 +1,240 @@
+package main
+
+import (
+  "bufio"
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "io"
+  "os"
+  "sort"
+  "strings"
+  "text/template"
+
+  "github.com/plouc/go-jira-client"
+)
+
+func init() {
+  commands["repl"] = command{"repl", replCommand}
+}
+
+const replHelp = `jql QUERY          search and list the issues, the first 20
+issue KEY          fetch an issue and make it the current one
+fields [PATH]      the current issue's fields, or one like priority.name
+eval RULE          run the current issue through a rule's filters
+expr TEMPLATE      render a template against its fields, like a computed field
+send TARGET [RULE] send the current issue to a target, as the rule's if given
+rules, targets     list them
+help, quit`
+
+// an interactive session with the config loaded, for trying searches,
+// rules and targets out against real issues without restarting
+type repl struct {
+  creds *Config
+  rules []*Rule
+  out   io.Writer
+
+  issue  *gojira.Issue // the current one, nil until `issue`
+  fields map[string]interface{}
+}
+
+// run one line, returning false to stop
+func (r *repl) run(line string) bool {
+  words := strings.Fields(line)
+  if len(words) == 0 {
+    return true
+  }
+  rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), words[0]))
+  var err error
+  switch words[0] {
+  case "quit", "exit":
+    return false
+  case "help", "?":
+    fmt.Fprintln(r.out, replHelp)
+  case "jql", "search":
+    err = r.search(rest)
+  case "issue":
+    err = r.fetch(rest)
+  case "fields":
+    err = r.showFields(rest)
+  case "eval":
+    err = r.eval(rest)
+  case "expr":
+    err = r.expr(rest)
+  case "send":
+    err = r.send(words[1:])
+  case "rules":
+    for _, rule := range r.rules {
+      fmt.Fprintf(r.out, "%s: %s\n", rule.Name, rule.jql())
+    }
+  case "targets":
+    names := []string{}
+    for name, s := range sinks {
+      names = append(names, name+" ("+s.target.Type+")")
+    }
+    sort.Strings(names)
+    fmt.Fprintln(r.out, strings.Join(names, "\n"))
+  default:
+    err = fmt.Errorf("unknown command %s, try help", words[0])
+  }
+  if err != nil {
+    fmt.Fprintln(r.out, "error:", err)
+  }
+  return true
+}
+
+func (r *repl) search(jql string) error {
+  if len(jql) == 0 {
+    return fmt.Errorf("usage: jql QUERY")
+  }
+  issues, fields, err := searchIssues(configJql(r.creds, "the search", jql), 20, r.creds)
+  if err != nil {
+    return err
+  }
+  columns := strings.Split(defaultColumns, ",")
+  rows := []resultRow{}
+  for i, issue := range issues {
+    row := resultRow{}
+    for _, column := range columns {
+      row[column] = columnValue(issue.Key, fields[i], column)
+    }
+    rows = append(rows, row)
+  }
+  return writeRows(rows, columns, "table")
+}
+
+func (r *repl) fetch(key string) error {
+  if len(key) == 0 {
+    return fmt.Errorf("usage: issue KEY")
+  }
+  contents := jiraIssue(strings.ToUpper(key), r.creds)
+  if contents == nil {
+    return fmt.Errorf("fetching %s failed", key)
+  }
+  issue, fields, err := parseIssue(contents)
+  if err != nil {
+    return err
+  }
+  r.issue, r.fields = issue, fields
+  fmt.Fprintf(r.out, "%s: %s\n", issue.Key, issue.Fields.Summary)
+  return nil
+}
+
+func (r *repl) current() error {
+  if r.issue == nil {
+    return fmt.Errorf("no current issue, fetch one with: issue KEY")
+  }
+  return nil
+}
+
+// a field by its path or name, or the set ones with their values cut short
+func (r *repl) showFields(path string) error {
+  if err := r.current(); err != nil {
+    return err
+  }
+  if len(path) > 0 {
+    value := fieldValue(r.fields, path)
+    if value == nil {
+      value = fieldValue(r.fields, customFields.id(path, r.creds))
+    }
+    contents, err := json.MarshalIndent(value, "", "  ")
+    if err != nil {
+      return err
+    }
+    fmt.Fprintln(r.out, string(contents))
+    return nil
+  }
+  fields := withoutNulls(r.fields)
+  names := []string{}
+  for name := range fields {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  for _, name := range names {
+    contents, _ := json.Marshal(fields[name])
+    value := string(contents)
+    if len(value) > 80 {
+      value = value[:77] + "..."
+    }
+    fmt.Fprintf(r.out, "%s: %s\n", name, value)
+  }
+  return nil
+}
+
+func (r *repl) eval(name string) error {
+  if err := r.current(); err != nil {
+    return err
+  }
+  rule := findRule(r.rules, name)
+  if rule == nil {
+    return fmt.Errorf("no rule named %s", name)
+  }
+  results := rule.evaluate(r.issue, r.fields, r.creds)
+  fmt.Fprintln(r.out, matchOutcome(results))
+  fmt.Fprintln(r.out, formatConditions(results))
+  return nil
+}
+
+func (r *repl) expr(text string) error {
+  if err := r.current(); err != nil {
+    return err
+  }
+  if !strings.Contains(text, "{{") {
+    text = "{{" + text + "}}"
+  }
+  t, err := template.New("expr").Funcs(templateFuncs(r.creds)).Parse(text)
+  if err != nil {
+    return err
+  }
+  var out bytes.Buffer
+  if err := t.Execute(&out, withoutNulls(r.fields)); err != nil {
+    return err
+  }
+  fmt.Fprintln(r.out, out.String())
+  return nil
+}
+
+func (r *repl) send(args []string) error {
+  if err := r.current(); err != nil {
+    return err
+  }
+  if len(args) == 0 || len(args) > 2 {
+    return fmt.Errorf("usage: send TARGET [RULE]")
+  }
+  s, ok := sinks[args[0]]
+  if !ok {
+    return fmt.Errorf("unknown target %s", args[0])
+  }
+  event := newEvent(eventCreated, r.issue, r.fields)
+  if len(args) == 2 {
+    rule := findRule(r.rules, args[1])
+    if rule == nil {
+      return fmt.Errorf("no rule named %s", args[1])
+    }
+    event = ruleEvent(rule, eventCreated, r.issue, r.fields, r.creds)
+  }
+  event.Targets = []string{s.name}
+  if failed := s.send([]*Event{event}); len(failed) > 0 {
+    return fmt.Errorf("sending to %s failed, see the log", s.name)
+  }
+  fmt.Fprintln(r.out, "sent to", s.name)
+  return nil
+}
+
+// read commands from stdin until quit or the end of the input
+func replCommand(args []string) {
+  if len(args) > 0 {
+    usageExit(commands["repl"].usage)
+  }
+  creds, rules := setup()
+  r := &repl{creds: creds, rules: rules, out: os.Stdout}
+  fmt.Println("jira-ticket-tracker repl, try help")
+  scanner := bufio.NewScanner(os.Stdin)
+  for {
+    fmt.Print("> ")
+    if !scanner.Scan() || !r.run(scanner.Text()) {
+      fmt.Println()
+      return
+    }
+  }
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+  "bytes"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "sync"
+  texttemplate "text/template"
+  "time"
+)
+
+// the canned responses, loaded at startup in setup
+var responses = &responseLibrary{}
+
+// a library of named canned responses the jira-comment targets post instead
+// of their message, for support leads to curate without touching the
+// config. they're inline under `responses` and/or one file each in
+// responses_dir, named by its path without the extension like templates
+// (responses/billing/refund.md is "billing/refund"). the directory is
+// reread when something in it changes, and a broken edit keeps the
+// previous library
+//
+//   responses_dir: ./responses
+//
+// a rule picks one, with values for its variables. responses are templates
+// seeing the event and the variables as .Vars
+//
+//   rules:
+//     - name: refunds
+//       jql: project = SUPPORT AND labels = refund
+//       targets: [reply]        # a jira-comment target
+//       response:
+//         name: billing/refund
+//         vars: {eta: 5 business days}
+//
+// responses/billing/refund.md:
+//
+//   Hi {{.Fields.reporter.displayName}}, your refund for {{.Issue.Key}}
+//   is on its way and should arrive within {{.Vars.eta}}.
+type responseLibrary struct {
+  mu        sync.RWMutex
+  dir       string
+  inline    map[string]string
+  modTime   time.Time
+  responses *texttemplate.Template
+  funcs     map[string]interface{}
+}
+
+// the canned response a rule's jira-comment targets post
+type RuleResponse struct {
+  Name string            `yaml:"name"`
+  Vars map[string]string `yaml:"vars"`
+}
+
+// what a response is rendered with, the event and the rule's variables
+type responseData struct {
+  *Event
+  Vars map[string]string
+}
+
+func (r *Rule) response() *RuleResponse {
+  if len(r.Response.Name) == 0 {
+    return nil
+  }
+  return &r.Response
+}
+
+func loadResponses(creds *Config, rules []*Rule) *responseLibrary {
+  l := &responseLibrary{dir: creds.ResponsesDir, inline: creds.Responses, funcs: templateFuncs(creds)}
+  if err := l.load(); err != nil {
+    logger.Print("Error loading canned responses: ", err)
+    os.Exit(1)
+  }
+  for _, rule := range rules {
+    if response := rule.response(); response != nil && !l.has(response.Name) {
+      logger.Print("Rule ", rule.Name, " uses canned response ", response.Name, ", which isn't in the library")
+      os.Exit(1)
+    }
+  }
+  if len(l.dir) > 0 {
+    go l.watch()
+  }
+  return l
+}
+
+// the response files and the newest modification time among them and
+// their directories, so deletes are noticed too
+func (l *responseLibrary) files() (paths []string, newest time.Time, err error) {
+  if len(l.dir) == 0 {
+    return nil, newest, nil
+  }
+  err = filepath.Walk(l.dir, func(path string, info os.FileInfo, err error) error {
+    if err != nil {
+      return err
+    }
+    if info.ModTime().After(newest) {
+      newest = info.ModTime()
+    }
+    switch filepath.Ext(path) {
+    case ".md", ".txt", ".tmpl":
+      paths = append(paths, path)
+    }
+    return nil
+  })
+  return paths, newest, err
+}
+
+func (l *responseLibrary) load() error {
+  paths, newest, err := l.files()
+  if err != nil {
+    return err
+  }
+  parsed := texttemplate.New("").Funcs(l.funcs)
+  for name, body := range l.inline {
+    if _, err := parsed.New(name).Parse(body); err != nil {
+      return err
+    }
+  }
+  for _, path := range paths {
+    contents, err := ioutil.ReadFile(path)
+    if err != nil {
+      return err
+    }
+    if _, err := parsed.New(templateName(l.dir, path)).Parse(strings.TrimSpace(string(contents))); err != nil {
+      return err
+    }
+  }
+
+  l.mu.Lock()
+  l.responses, l.modTime = parsed, newest
+  l.mu.Unlock()
+  return nil
+}
+
+func (l *responseLibrary) watch() {
+  for {
+    time.Sleep(time.Duration(waitIntervalSecs * time.Second))
+    _, newest, err := l.files()
+    if err != nil {
+      logger.Print("Error reading canned responses: ", err)
+      continue
+    }
+    l.mu.RLock()
+    changed := newest.After(l.modTime)
+    l.mu.RUnlock()
+    if !changed {
+      continue
+    }
+    if err := l.load(); err != nil {
+      logger.Print("Error reloading canned responses, keeping the old ones: ", err)
+      l.mu.Lock()
+      l.modTime = newest
+      l.mu.Unlock()
+    } else {
+      logger.Print("Reloaded canned responses")
+    }
+  }
+}
+
+func (l *responseLibrary) has(name string) bool {
+  l.mu.RLock()
+  defer l.mu.RUnlock()
+  return l.responses != nil && l.responses.Lookup(name) != nil
+}
+
+// the event's canned response, rendered
+func (l *responseLibrary) Render(event *Event) (string, error) {
+  l.mu.RLock()
+  parsed := l.responses
+  l.mu.RUnlock()
+  name := event.response.Name
+  if parsed == nil || parsed.Lookup(name) == nil {
+    return "", fmt.Errorf("no canned response named %q", name)
+  }
+  var out bytes.Buffer
+  err := parsed.ExecuteTemplate(&out, name, responseData{event, event.response.Vars})
+  return out.String(), err
+}
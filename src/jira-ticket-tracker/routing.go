@@ -0,0 +1,76 @@
+package main
+
+import (
+  "strings"
+)
+
+// a Route is one cell of the routing matrix, configured under `routing`.
+// after a rule matches, every route whose priorities and projects match the
+// issue adds its targets, so e.g. Blockers can page on-call from any rule:
+//
+//   routing:
+//     - priority: [Blocker]
+//       project: [OPS, INFRA]  # empty matches every project
+//       computed: {team: payments}  # optional, see computed.go
+//       targets: [oncall-pager]
+//       lead: alice  # optional, the team's lead, see WatchersConfig
+type Route struct {
+  Priorities []string          `yaml:"priority"`
+  Projects   []string          `yaml:"project"`
+  Computed   map[string]string `yaml:"computed"`
+  Targets    []string          `yaml:"targets"`
+  Lead       string            `yaml:"lead"`
+}
+
+func (r *Route) matches(event *Event) bool {
+  for name, value := range r.Computed {
+    if !strings.EqualFold(event.Computed[name], value) {
+      return false
+    }
+  }
+  return matchesAny(r.Priorities, fieldString(event.Fields, "priority.name")) &&
+    matchesAny(r.Projects, issueProject(event))
+}
+
+// an empty list matches anything
+func matchesAny(values []string, value string) bool {
+  if len(values) == 0 {
+    return true
+  }
+  for _, v := range values {
+    if strings.EqualFold(v, value) {
+      return true
+    }
+  }
+  return false
+}
+
+func contains(values []string, value string) bool {
+  for _, v := range values {
+    if v == value {
+      return true
+    }
+  }
+  return false
+}
+
+func routeTargets(routes []Route, event *Event) []string {
+  targets := []string{}
+  for i := range routes {
+    if routes[i].matches(event) {
+      targets = addTargets(targets, routes[i].Targets)
+    }
+  }
+  return targets
+}
+
+// append the extra targets that aren't already in the list
+func addTargets(targets, extra []string) []string {
+  result := append([]string{}, targets...)
+  for _, t := range extra {
+    if !contains(result, t) {
+      result = append(result, t)
+    }
+  }
+  return result
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+  "fmt"
+  "io/ioutil"
+  "regexp"
+  "sort"
+  "strings"
+)
+
+// what `validate` warns about in the rules: targets that aren't defined,
+// rules that can never send anything and rules that watch the same issues
+// as another, which send twice or to different targets
+type ruleLinter struct {
+  creds    *Config
+  lines    map[string]int // rule -> its line in the config, if it's known
+  warnings []string
+}
+
+// the line each rule starts on in the config, by name. rules from
+// --project and a remote config have none
+func configRuleLines(path string, creds *Config) map[string]int {
+  lines := map[string]int{}
+  if remoteConfigUrl(path) {
+    return lines
+  }
+  contents, err := ioutil.ReadFile(path)
+  if err != nil {
+    return lines
+  }
+  starts := []int{}
+  inRules, indent := false, -1
+  for i, line := range strings.Split(string(contents), "\n") {
+    trimmed := strings.TrimLeft(line, " ")
+    if len(strings.TrimSpace(trimmed)) == 0 || strings.HasPrefix(trimmed, "#") {
+      continue
+    }
+    depth := len(line) - len(trimmed)
+    if depth == 0 {
+      inRules = strings.TrimSpace(line) == "rules:"
+      continue
+    }
+    if !inRules || !strings.HasPrefix(trimmed, "-") {
+      continue
+    }
+    if indent < 0 {
+      indent = depth
+    }
+    if depth == indent {
+      starts = append(starts, i+1)
+    }
+  }
+  for i := range creds.Rules {
+    if i < len(starts) {
+      lines[creds.Rules[i].Name] = starts[i]
+    }
+  }
+  return lines
+}
+
+func (l *ruleLinter) where(rule *Rule) string {
+  if line, ok := l.lines[rule.Name]; ok {
+    return fmt.Sprintf("rule %s (line %d)", rule.Name, line)
+  }
+  return "rule " + rule.Name
+}
+
+func (l *ruleLinter) warn(rule *Rule, format string, args ...interface{}) {
+  l.warnings = append(l.warnings, l.where(rule)+": "+fmt.Sprintf(format, args...))
+}
+
+// the rule's jql with its clauses in order, so rules that only write the
+// same conditions differently compare equal. a jql with an OR is only
+// tidied up, not reordered
+func ruleScope(rule *Rule) string {
+  jql := strings.Join(strings.Fields(strings.ToLower(rule.jql())), " ")
+  if strings.Contains(jql, " or ") {
+    return jql
+  }
+  clauses := []string{}
+  for _, clause := range strings.Split(jql, " and ") {
+    clause = strings.TrimSpace(strings.Trim(clause, "() "))
+    clause = strings.Replace(clause, `"`, "", -1)
+    if len(clause) > 0 && !contains(clauses, clause) {
+      clauses = append(clauses, clause)
+    }
+  }
+  sort.Strings(clauses)
+  if len(rule.Instance) > 0 {
+    clauses = append([]string{"instance = " + rule.Instance}, clauses...)
+  }
+  return strings.Join(clauses, " and ")
+}
+
+var jqlProjectClause = regexp.MustCompile(`(?i)\bproject\s*=\s*"?([A-Za-z0-9_]+)"?`)
+
+// why the rule can never send anything, empty if it can
+func (l *ruleLinter) unreachable(rule *Rule) string {
+  if len(rule.Project) > 0 && len(rule.Jql) > 0 && !strings.Contains(strings.ToLower(rule.Jql), " or ") {
+    for _, m := range jqlProjectClause.FindAllStringSubmatch(rule.Jql, -1) {
+      if !strings.EqualFold(m[1], rule.Project) {
+        return fmt.Sprintf("it's for project %s but its jql only matches %s, so it can never match", rule.Project, m[1])
+      }
+    }
+  }
+  if len(allRuleTargets(rule)) > 0 || len(rule.Chain.Steps) > 0 {
+    return ""
+  }
+  for _, route := range l.creds.Routing {
+    if len(rule.Project) == 0 || matchesAny(route.Projects, rule.Project) {
+      return "" // a route could still send its issues
+    }
+  }
+  return "it has no targets, no chain and no route adds any, so nothing it finds is sent"
+}
+
+// warn about the rules' problems, in the order they're configured
+func lintRules(rules []*Rule, creds *Config, lines map[string]int) []string {
+  l := &ruleLinter{creds: creds, lines: lines}
+  for _, rule := range rules {
+    for _, target := range allRuleTargets(rule) {
+      if _, ok := creds.Targets[target]; !ok {
+        l.warn(rule, "sends to %s, which isn't a target", target)
+      }
+    }
+    for _, step := range rule.Chain.Steps {
+      if _, ok := creds.Targets[step.Target]; !ok {
+        l.warn(rule, "its chain sends to %s, which isn't a target", step.Target)
+      }
+    }
+    if reason := l.unreachable(rule); len(reason) > 0 {
+      l.warn(rule, "%s", reason)
+    }
+  }
+
+  byScope := map[string]*Rule{}
+  for _, rule := range rules {
+    scope := ruleScope(rule)
+    first, ok := byScope[scope]
+    if !ok {
+      byScope[scope] = rule
+      continue
+    }
+    mine, theirs := allRuleTargets(rule), allRuleTargets(first)
+    sort.Strings(mine)
+    sort.Strings(theirs)
+    switch {
+    case strings.Join(mine, ",") != strings.Join(theirs, ","):
+      l.warn(rule, "watches the same issues as %s but sends to [%s] instead of [%s]",
+        l.where(first), strings.Join(mine, ", "), strings.Join(theirs, ", "))
+    case rule.Response.Name != first.Response.Name:
+      l.warn(rule, "watches the same issues as %s but responds with %q instead of %q",
+        l.where(first), rule.Response.Name, first.Response.Name)
+    default:
+      l.warn(rule, "duplicates %s, so its issues are sent twice", l.where(first))
+    }
+  }
+  for i, route := range creds.Routing {
+    for _, target := range route.Targets {
+      if _, ok := creds.Targets[target]; !ok {
+        l.warnings = append(l.warnings, fmt.Sprintf("route %d: sends to %s, which isn't a target", i+1, target))
+      }
+    }
+  }
+  return l.warnings
+}
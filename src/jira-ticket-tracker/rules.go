@@ -0,0 +1,265 @@
+package main
+
+import (
+  "fmt"
+  "os"
+  "strings"
+  "time"
+)
+
+// a Rule is one search the tracker polls, configured under `rules`
+//
+//   rules:
+//     - name: ops-from-jsmith
+//       project: OPS
+//       user: jsmith
+//       field: assignee  # defaults to reporter
+//       users: ["group:payments-team", alice]  # or several, instead of user
+//       jql: type = Bug  # optional, and-ed with the above
+//       targets: [ops-slack]
+//       links: [...]     # optional, see LinkFilter
+//       on:              # optional, different targets per event kind
+//         resolved: [thank-reporter]
+//         reopened: [team-pager]
+//       chain: {...}     # optional, targets one after the other, see RuleChain
+//       interval: 1h     # optional, how often to poll (default 4s)
+//       schedule: "0 2 * * *"  # or poll on a cron schedule instead
+//       max_results: 100 # optional, issues per search page
+//       quiet_hours: 22:00-07:00  # optional, don't poll during these
+//       tests:           # optional, run by `validate`, see RuleTest
+//         - issue: fixtures/OPS-1.json
+//           match: true
+type Rule struct {
+  Name    string              `yaml:"name"`
+  Project string              `yaml:"project"`
+  User    string              `yaml:"user"`
+  Users   []string            `yaml:"users"` // users, groups and roles, see recipients.go
+  Field   string              `yaml:"field"`
+  Jql     string              `yaml:"jql"`
+  Targets []string            `yaml:"targets"`
+  Links   []LinkFilter        `yaml:"links"`
+  On      map[string][]string `yaml:"on"`
+  Chain   RuleChain           `yaml:"chain"`
+  // the canned response its jira-comment targets post, see responseLibrary
+  Response RuleResponse `yaml:"response"`
+  // the jira webhook whose issues it's for instead of polling, see
+  // JiraWebhook
+  Instance string `yaml:"instance"`
+
+  Interval string `yaml:"interval"`
+  Schedule string `yaml:"schedule"`
+
+  MaxResults int    `yaml:"max_results"`
+  QuietHours string `yaml:"quiet_hours"`
+
+  Tests []RuleTest `yaml:"tests"`
+
+  // the rule template to start from and the values for its variables, see
+  // expandRuleTemplate
+  Template string            `yaml:"template"`
+  Vars     map[string]string `yaml:"vars"`
+
+  interval time.Duration
+  cron     *cronSchedule
+  quiet    *quietHours
+  quietIn  *time.Location // the quiet hours' timezone, see quietLocation
+  dir      string // what the tests' fixture paths are relative to, the config's directory by default
+  members  *ruleMembers // users expanded, nil without them
+}
+
+// when the rule should poll next after a poll at t, put off until its
+// quiet hours are over. the issues created meanwhile are in the next
+// poll's window so nothing is missed
+func (r *Rule) nextPoll(t time.Time) time.Time {
+  next := t.Add(r.interval)
+  if r.cron != nil {
+    next = r.cron.next(t)
+  }
+  in := time.Local
+  if r.quietIn != nil {
+    in = r.quietIn
+  }
+  if over := r.quiet.over(next.In(in)); !over.IsZero() {
+    return over
+  }
+  return next
+}
+
+// quiet hours are in the timezone of the calendar of the rule's project, or
+// local time for a rule without one or a calendar without a timezone
+func quietLocation(r *Rule, creds *Config) *time.Location {
+  if len(r.Project) == 0 {
+    return nil
+  }
+  if c := calendarFor(creds, r.Project); c != nil && len(c.Timezone) > 0 {
+    return c.location
+  }
+  return nil
+}
+
+// issues per search page, the rule's own or the config's
+func (r *Rule) pageSize(creds *Config) int {
+  if r.MaxResults > 0 {
+    return r.MaxResults
+  }
+  return creds.pageSize()
+}
+
+// the targets for an event kind, the rule's own targets unless overridden
+func (r *Rule) targetsFor(kind string) []string {
+  if targets, ok := r.On[kind]; ok {
+    return targets
+  }
+  return r.Targets
+}
+
+// the jql searched for the rule, newest issues first
+func (r *Rule) query() string {
+  return r.jql() + " ORDER BY created DESC"
+}
+
+// the rule's conditions as jql, without any ordering
+func (r *Rule) jql() string {
+  clauses := []string{}
+  if len(r.Project) > 0 {
+    clauses = append(clauses, "project = "+jqlQuote(r.Project))
+  }
+  if len(r.User) > 0 {
+    clauses = append(clauses, r.Field+" = "+jqlQuote(r.User))
+  }
+  if len(r.Users) > 0 {
+    clauses = append(clauses, r.usersJql())
+  }
+  if len(r.Jql) > 0 {
+    clauses = append(clauses, "("+r.Jql+")")
+  }
+  return strings.Join(clauses, " AND ")
+}
+
+// a jql string literal. backslashes are escaped too, or a value ending in
+// one would escape the closing quote
+func jqlQuote(value string) string {
+  return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// the rules from the config plus one synthesized from --project/--user
+func configuredRules(creds *Config) []*Rule {
+  rules := flagRules(creds)
+  configured, err := buildRules(creds.Rules, creds)
+  if err != nil {
+    logger.Print(err)
+    os.Exit(1)
+  }
+  return append(rules, configured...)
+}
+
+// the rule synthesized from --project/--user, if they were given
+func flagRules(creds *Config) []*Rule {
+  if len(*project) == 0 {
+    return nil
+  }
+  rule := &Rule{
+    Name:    *project + "-" + *user,
+    Project: *project,
+    User:    *user,
+    Field:   trackingMethod,
+  }
+  creds.Defaults.apply(rule)
+  if err := rule.validate(); err != nil {
+    logger.Print("Invalid rule ", rule.Name, ": ", err)
+    os.Exit(1)
+  }
+  rule.quietIn = quietLocation(rule, creds)
+  return []*Rule{rule}
+}
+
+// fill in, expand and check the rules as configured
+func buildRules(specs []Rule, creds *Config) ([]*Rule, error) {
+  rules := []*Rule{}
+  names := map[string]bool{}
+  for i := range specs {
+    rule := &specs[i]
+    if len(rule.Name) == 0 {
+      rule.Name = fmt.Sprintf("rule-%d", i+1)
+    }
+    if len(rule.Template) > 0 {
+      if err := expandRuleTemplate(rule, creds.RuleTemplates); err != nil {
+        return nil, fmt.Errorf("Invalid rule %s: %v", rule.Name, err)
+      }
+    }
+    creds.Defaults.apply(rule)
+    jql, err := expandJql(rule.Jql, creds.JqlFragments)
+    if err != nil {
+      return nil, fmt.Errorf("Invalid rule %s: %v", rule.Name, err)
+    }
+    rule.Jql = jql
+    if len(rule.Field) == 0 {
+      rule.Field = trackingMethod
+    }
+    if err := rule.validate(); err != nil {
+      return nil, fmt.Errorf("Invalid rule %s: %v", rule.Name, err)
+    }
+    rule.quietIn = quietLocation(rule, creds)
+    if err := rule.Chain.checkTargets(creds); err != nil {
+      return nil, fmt.Errorf("Invalid rule %s: %v", rule.Name, err)
+    }
+    if len(rule.Users) > 0 {
+      rule.members = &ruleMembers{creds: creds}
+    }
+    if len(rule.Instance) > 0 {
+      if err := checkInstanceTargets(rule, creds); err != nil {
+        return nil, fmt.Errorf("Invalid rule %s: %v", rule.Name, err)
+      }
+    }
+    if names[rule.Name] {
+      return nil, fmt.Errorf("Duplicate rule name %s", rule.Name)
+    }
+    names[rule.Name] = true
+    rules = append(rules, rule)
+  }
+  return rules, nil
+}
+
+func (r *Rule) validate() error {
+  if len(r.Project) == 0 && len(r.User) == 0 && len(r.Users) == 0 && len(r.Jql) == 0 && len(r.Instance) == 0 {
+    return fmt.Errorf("a project, user, users, jql or instance is required")
+  }
+  if len(r.Instance) > 0 && len(r.Jql) > 0 {
+    return fmt.Errorf("jql can't be used with instance, webhooks are matched without asking jira")
+  }
+  if len(r.User) > 0 && len(r.Users) > 0 {
+    return fmt.Errorf("only one of user and users can be given")
+  }
+  if r.Field != "reporter" && r.Field != "assignee" {
+    return fmt.Errorf("field must be reporter or assignee, not %q", r.Field)
+  }
+  if len(r.Interval) > 0 && len(r.Schedule) > 0 {
+    return fmt.Errorf("only one of interval and schedule can be given")
+  }
+
+  var err error
+  r.interval = time.Duration(waitIntervalSecs * time.Second)
+  if len(r.Interval) > 0 {
+    if r.interval, err = parseDuration(r.Interval); err != nil {
+      return fmt.Errorf("invalid interval %q: %v", r.Interval, err)
+    }
+    if r.interval < time.Second {
+      return fmt.Errorf("interval %q is too short", r.Interval)
+    }
+  }
+  if len(r.Schedule) > 0 {
+    if r.cron, err = parseCron(r.Schedule); err != nil {
+      return fmt.Errorf("invalid schedule: %v", err)
+    }
+  }
+  if err := r.Chain.validate(); err != nil {
+    return err
+  }
+  if r.MaxResults < 0 {
+    return fmt.Errorf("max_results can't be negative")
+  }
+  if r.quiet, err = parseQuietHours(r.QuietHours); err != nil {
+    return fmt.Errorf("invalid quiet_hours %q: %v", r.QuietHours, err)
+  }
+  return nil
+}
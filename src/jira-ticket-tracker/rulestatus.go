@@ -0,0 +1,179 @@
+package main
+
+import (
+  "fmt"
+  "net/http"
+  "os"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+)
+
+func init() {
+  commands["status"] = command{"status", statusCommand}
+}
+
+// RuleStatus is how a rule's polling has gone since the tracker started
+type RuleStatus struct {
+  Rule        string     `json:"rule"`
+  Healthy     bool       `json:"healthy"`
+  LastPoll    *time.Time `json:"last_poll,omitempty"`
+  LastMatch   *time.Time `json:"last_match,omitempty"`
+  NextPoll    *time.Time `json:"next_poll,omitempty"`
+  ErrorStreak int        `json:"error_streak"` // failed polls in a row
+  LastError   string     `json:"last_error,omitempty"`
+  Paused      bool       `json:"paused,omitempty"`
+
+  rule *Rule
+}
+
+// the status of every rule, for the status command, the passive checks
+// and the heartbeat
+var ruleStatuses = struct {
+  sync.Mutex
+  rules  []string
+  status map[string]*RuleStatus
+}{status: map[string]*RuleStatus{}}
+
+// the rules being polled, again whenever they change. a rule that is still
+// there keeps its status
+func registerRules(rules []*Rule) {
+  ruleStatuses.Lock()
+  defer ruleStatuses.Unlock()
+  names, statuses := []string{}, map[string]*RuleStatus{}
+  for _, rule := range rules {
+    status, ok := ruleStatuses.status[rule.Name]
+    if !ok {
+      status = &RuleStatus{Rule: rule.Name}
+    }
+    status.rule = rule
+    names = append(names, rule.Name)
+    statuses[rule.Name] = status
+  }
+  ruleStatuses.rules, ruleStatuses.status = names, statuses
+}
+
+func recordPoll(rule string, matches int, err error) {
+  ruleStatuses.Lock()
+  if status, ok := ruleStatuses.status[rule]; ok {
+    now := time.Now()
+    status.LastPoll = &now
+    if err != nil {
+      status.ErrorStreak++
+      status.LastError = err.Error()
+    } else {
+      status.ErrorStreak = 0
+      status.LastError = ""
+      if matches > 0 {
+        status.LastMatch = &now
+      }
+    }
+  }
+  ruleStatuses.Unlock()
+  recordReliability(rule, matches, err)
+  heartbeat.beat(err)
+}
+
+func ruleRegistered(rule string) bool {
+  ruleStatuses.Lock()
+  defer ruleStatuses.Unlock()
+  _, ok := ruleStatuses.status[rule]
+  return ok
+}
+
+func setNextPoll(rule string, t time.Time) {
+  ruleStatuses.Lock()
+  defer ruleStatuses.Unlock()
+  if status, ok := ruleStatuses.status[rule]; ok {
+    status.NextPoll = &t
+  }
+}
+
+// whether the rule has missed a poll, must be called with the lock held
+func (s *RuleStatus) late() bool {
+  return s.LastPoll != nil && time.Now().After(s.rule.nextPoll(s.rule.nextPoll(*s.LastPoll)))
+}
+
+// a copy of every rule's status, in the order they're configured
+func allRuleStatuses() []RuleStatus {
+  ruleStatuses.Lock()
+  defer ruleStatuses.Unlock()
+
+  statuses := []RuleStatus{}
+  for _, name := range ruleStatuses.rules {
+    status := *ruleStatuses.status[name]
+    status.Paused = rulePaused(name)
+    status.Healthy = status.ErrorStreak == 0 && (status.Paused || !status.late())
+    statuses = append(statuses, status)
+  }
+  return statuses
+}
+
+const (
+  statusOK       = 0
+  statusWarning  = 1
+  statusCritical = 2
+)
+
+// how polling is going overall, nagios style: critical if a rule's last
+// poll failed, warning if a rule missed a poll, otherwise ok
+func pollStatus() (int, string) {
+  failed, late := []string{}, []string{}
+  statuses := allRuleStatuses()
+  for _, status := range statuses {
+    if status.ErrorStreak > 0 {
+      failed = append(failed, status.Rule+": "+status.LastError)
+    } else if !status.Healthy {
+      late = append(late, status.Rule)
+    }
+  }
+  sort.Strings(failed)
+  sort.Strings(late)
+  if len(failed) > 0 {
+    return statusCritical, "polls failing, " + strings.Join(failed, "; ")
+  }
+  if len(late) > 0 {
+    return statusWarning, "polls late for " + strings.Join(late, ", ")
+  }
+  return statusOK, fmt.Sprintf("polling %d rules", len(statuses))
+}
+
+//   GET /status   every rule's status
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+  if r.Method != "GET" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+  writeJSON(w, http.StatusOK, allRuleStatuses())
+}
+
+func formatStatusTime(t *time.Time) string {
+  if t == nil {
+    return "never"
+  }
+  if t.After(time.Now()) {
+    return "in " + humanize(time.Until(*t))
+  }
+  return humanize(time.Since(*t)) + " ago"
+}
+
+func statusCommand(args []string) {
+  var statuses []RuleStatus
+  if err := callAPI("GET", "/status", nil, &statuses); err != nil {
+    logger.Print("Error fetching the status: ", err)
+    os.Exit(1)
+  }
+  for _, s := range statuses {
+    health := "ok"
+    if s.ErrorStreak > 0 {
+      health = fmt.Sprintf("FAILING x%d: %s", s.ErrorStreak, s.LastError)
+    } else if s.Paused {
+      health = "PAUSED"
+    } else if !s.Healthy {
+      health = "LATE"
+    }
+    fmt.Printf("%s\t%s\tpolled %s\tmatched %s\tnext %s\n", s.Rule, health,
+        formatStatusTime(s.LastPoll), formatStatusTime(s.LastMatch), formatStatusTime(s.NextPoll))
+  }
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+  "bytes"
+  "fmt"
+  "launchpad.net/goyaml"
+  "reflect"
+  "text/template"
+)
+
+// rules shared by many teams, configured under `rule_templates` and written
+// like a rule with {{.var}} wherever the teams differ. a rule with a
+// `template` is that template with its `vars` filled in, and anything the
+// rule sets itself wins over the template
+//
+//   rule_templates:
+//     team-bugs:
+//       project: "{{.project}}"
+//       jql: type = Bug AND component = "{{.component}}"
+//       targets: ["{{.channel}}"]
+//       interval: 1m
+//   rules:
+//     - name: payments-bugs
+//       template: team-bugs
+//       vars: {project: PAY, component: checkout, channel: payments-slack}
+//     - name: search-bugs
+//       template: team-bugs
+//       vars: {project: SRCH, component: index, channel: search-slack}
+//       interval: 5m
+func expandRuleTemplate(rule *Rule, templates map[string]interface{}) error {
+  raw, ok := templates[rule.Template]
+  if !ok {
+    return fmt.Errorf("no rule template named %s", rule.Template)
+  }
+  contents, err := goyaml.Marshal(raw)
+  if err != nil {
+    return err
+  }
+  t, err := template.New(rule.Template).Option("missingkey=error").Parse(string(contents))
+  if err != nil {
+    return fmt.Errorf("rule template %s: %v", rule.Template, err)
+  }
+  var rendered bytes.Buffer
+  if err := t.Execute(&rendered, rule.Vars); err != nil {
+    return fmt.Errorf("rule template %s: %v", rule.Template, err)
+  }
+  var expanded Rule
+  if err := goyaml.Unmarshal(rendered.Bytes(), &expanded); err != nil {
+    return fmt.Errorf("rule template %s: %v", rule.Template, err)
+  }
+
+  // whatever the rule sets overrides the template
+  from, to := reflect.ValueOf(rule).Elem(), reflect.ValueOf(&expanded).Elem()
+  for i := 0; i < from.NumField(); i++ {
+    if from.Type().Field(i).IsExported() && !from.Field(i).IsZero() {
+      to.Field(i).Set(from.Field(i))
+    }
+  }
+  *rule = expanded
+  return nil
+}
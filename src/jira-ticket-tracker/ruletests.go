@@ -0,0 +1,106 @@
+package main
+
+import (
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+)
+
+// an example issue for a rule and whether the rule should match it.
+// targets, if given, must be exactly the targets the event is sent to
+type RuleTest struct {
+  Issue   string   `yaml:"issue"` // relative to the config file, or the rules file for gitops
+  Kind    string   `yaml:"kind"`  // defaults to created
+  Match   bool     `yaml:"match"`
+  Targets []string `yaml:"targets"`
+}
+
+func init() {
+  commands["validate"] = command{"validate", validateCommand}
+}
+
+// run one rule test, returning why it failed or an empty string, and the
+// conditions only jira could check. a test expecting no match that only
+// those could fail is neither passed nor failed, and the same goes for a
+// match it expects
+func (t *RuleTest) run(rule *Rule, creds *Config) (string, []string) {
+  path := t.Issue
+  if !filepath.IsAbs(path) {
+    dir := rule.dir
+    if len(dir) == 0 {
+      dir = filepath.Dir(*config)
+    }
+    path = filepath.Join(dir, path)
+  }
+  contents, err := ioutil.ReadFile(path)
+  if err != nil {
+    return fmt.Sprint("error reading fixture: ", err), nil
+  }
+  issue, fields, err := parseIssue(contents)
+  if err != nil {
+    return fmt.Sprint("error parsing fixture: ", err), nil
+  }
+
+  results := rule.evaluate(issue, fields, creds)
+  matched := conditionsPassed(results)
+  unchecked := []string{}
+  if matched {
+    unchecked = uncheckedConditions(results)
+  }
+  if matched != t.Match && len(unchecked) == 0 {
+    return fmt.Sprintf("expected match %v, got %v\n%s", t.Match, matched, formatConditions(results)), nil
+  }
+  if !t.Match || t.Targets == nil {
+    return "", unchecked
+  }
+
+  kind := t.Kind
+  if len(kind) == 0 {
+    kind = eventCreated
+  }
+  event := ruleEvent(rule, kind, issue, fields, creds)
+  if strings.Join(event.Targets, ",") != strings.Join(t.Targets, ",") {
+    return fmt.Sprintf("expected targets [%s], got [%s]",
+      strings.Join(t.Targets, ", "), strings.Join(event.Targets, ", ")), unchecked
+  }
+  return "", unchecked
+}
+
+// check the config loads, run every rule's tests and lint the templates
+// and rules, see lintTemplates and lintRules. only warnings still pass
+func validateCommand(args []string) {
+  creds, rules := setup()
+  failed, unknown := 0, 0
+  count := 0
+  for _, rule := range rules {
+    for i := range rule.Tests {
+      test := &rule.Tests[i]
+      count++
+      reason, unchecked := test.run(rule, creds)
+      switch {
+      case len(reason) > 0:
+        failed++
+        fmt.Printf("FAIL %s %s: %s\n", rule.Name, test.Issue, reason)
+      case len(unchecked) > 0:
+        unknown++
+        fmt.Printf("?    %s %s: only jira can check %s\n", rule.Name, test.Issue, strings.Join(unchecked, ", "))
+      default:
+        fmt.Printf("ok   %s %s\n", rule.Name, test.Issue)
+      }
+    }
+  }
+  problems := lintTemplates(templates, creds)
+  for _, problem := range problems {
+    fmt.Println("FAIL", problem)
+  }
+  warnings := lintRules(rules, creds, configRuleLines(*config, creds))
+  for _, warning := range warnings {
+    fmt.Println("WARN", warning)
+  }
+  fmt.Printf("%d rules, %d tests, %d failed, %d unknown, %d template problems, %d warnings\n", len(rules), count, failed, unknown, len(problems), len(warnings))
+  if failed > 0 || len(problems) > 0 {
+    os.Exit(1)
+  }
+}
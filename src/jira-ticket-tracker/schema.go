@@ -0,0 +1,337 @@
+package main
+
+import (
+  "encoding/json"
+  "flag"
+  "fmt"
+  "net/http"
+  "reflect"
+  "strings"
+  "sync"
+  "time"
+  "unicode"
+)
+
+// json schemas generated from the go types, so they can't drift from what
+// is actually encoded. named structs go in defs and are referred to by
+// refPrefix+name. tag is the struct tag the names come from, json or yaml
+type schemaBuilder struct {
+  tag       string
+  refPrefix string
+  defs      map[string]interface{}
+}
+
+func newSchemaBuilder(tag, refPrefix string) *schemaBuilder {
+  return &schemaBuilder{tag: tag, refPrefix: refPrefix, defs: map[string]interface{}{}}
+}
+
+var (
+  timeType    = reflect.TypeOf(time.Time{})
+  rawJSONType = reflect.TypeOf(json.RawMessage{})
+)
+
+// the name a named type is defined under, exported
+func schemaName(t reflect.Type) string {
+  name := []rune(t.Name())
+  name[0] = unicode.ToUpper(name[0])
+  return string(name)
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+  switch {
+  case t == timeType:
+    return map[string]interface{}{"type": "string", "format": "date-time"}
+  case t == rawJSONType:
+    return map[string]interface{}{}
+  }
+  switch t.Kind() {
+  case reflect.Ptr:
+    return b.schema(t.Elem())
+  case reflect.Bool:
+    return map[string]interface{}{"type": "boolean"}
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+    reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    return map[string]interface{}{"type": "integer"}
+  case reflect.Float32, reflect.Float64:
+    return map[string]interface{}{"type": "number"}
+  case reflect.String:
+    return map[string]interface{}{"type": "string"}
+  case reflect.Slice, reflect.Array:
+    if t.Elem().Kind() == reflect.Uint8 {
+      return map[string]interface{}{"type": "string", "format": "byte"}
+    }
+    return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+  case reflect.Map:
+    return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+  case reflect.Struct:
+    if len(t.Name()) == 0 {
+      return b.object(t)
+    }
+    name := schemaName(t)
+    if _, ok := b.defs[name]; !ok {
+      b.defs[name] = map[string]interface{}{} // for types that refer to themselves
+      b.defs[name] = b.object(t)
+    }
+    return map[string]interface{}{"$ref": b.refPrefix + name}
+  }
+  return map[string]interface{}{} // interfaces, anything goes
+}
+
+// the schema, allowing null too. openapi 3.0 has its own way of saying so
+func (b *schemaBuilder) nullable(schema map[string]interface{}) map[string]interface{} {
+  if len(schema) == 0 {
+    return schema
+  }
+  if strings.HasPrefix(b.refPrefix, "#/components/") {
+    if _, ref := schema["$ref"]; ref {
+      return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+    }
+    schema["nullable"] = true
+    return schema
+  }
+  return map[string]interface{}{"anyOf": []interface{}{schema, map[string]interface{}{"type": "null"}}}
+}
+
+func (b *schemaBuilder) object(t reflect.Type) map[string]interface{} {
+  properties := map[string]interface{}{}
+  required := []string{}
+  for i := 0; i < t.NumField(); i++ {
+    field := t.Field(i)
+    if len(field.PkgPath) > 0 {
+      continue // unexported
+    }
+    name, options := field.Name, ""
+    if tag, ok := field.Tag.Lookup(b.tag); ok {
+      parts := strings.SplitN(tag, ",", 2)
+      if parts[0] == "-" {
+        continue
+      }
+      if len(parts[0]) > 0 {
+        name = parts[0]
+      }
+      if len(parts) > 1 {
+        options = parts[1]
+      }
+    } else if b.tag == "yaml" {
+      name = strings.ToLower(name) // goyaml's default
+    }
+    property := b.schema(field.Type)
+    if b.tag == "json" && !strings.Contains(options, "omitempty") {
+      required = append(required, name)
+      switch field.Type.Kind() {
+      case reflect.Map, reflect.Slice, reflect.Ptr, reflect.Interface:
+        property = b.nullable(property) // encoded as null when nil
+      }
+    }
+    properties[name] = property
+  }
+  object := map[string]interface{}{"type": "object", "properties": properties}
+  if len(required) > 0 {
+    object["required"] = required
+  }
+  return object
+}
+
+func init() {
+  commands["schema"] = command{"schema [--version=N] config|event", schemaCommand}
+}
+
+const schemaBase = "https://github.com/sk8erwitskil/jira-ticket-tracker/schemas/"
+
+type publishedType struct {
+  title string
+  tag   string
+  value interface{}
+}
+
+// the schemas published at /schemas/vN/NAME.json and by `schema NAME`, by
+// version. a version goes up with changes that could break a consumer,
+// like a field removed or renamed. the events' versions are eventVersion's
+var publishedSchemas = map[string]map[int]publishedType{
+  "config": {1: {"The tracker's config file", "yaml", Config{}}},
+  "event": {
+    1: {"An event as posted to webhook targets", "json", WebhookPayloadV1{}},
+    2: {"An event as posted to webhook targets", "json", WebhookPayload{}},
+  },
+}
+
+// the latest version of a schema
+func latestSchema(name string) int {
+  latest := 0
+  for version := range publishedSchemas[name] {
+    if version > latest {
+      latest = version
+    }
+  }
+  return latest
+}
+
+// a version of a schema, the latest for 0
+func publishedSchema(name string, version int) (map[string]interface{}, bool) {
+  if version == 0 {
+    version = latestSchema(name)
+  }
+  published, ok := publishedSchemas[name][version]
+  if !ok {
+    return nil, false
+  }
+  b := newSchemaBuilder(published.tag, "#/$defs/")
+  schema := b.object(reflect.TypeOf(published.value))
+  schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+  schema["$id"] = fmt.Sprintf("%sv%d/%s.json", schemaBase, version, name)
+  schema["title"] = published.title
+  if len(b.defs) > 0 {
+    schema["$defs"] = b.defs
+  }
+  return schema, true
+}
+
+// check a value against a schema with its $defs, returning what's wrong.
+// only what the generated schemas use is checked
+func validateSchema(schema, defs map[string]interface{}, value interface{}, path string) []string {
+  if ref, ok := schema["$ref"].(string); ok {
+    def, _ := defs[strings.TrimPrefix(ref, "#/$defs/")].(map[string]interface{})
+    return validateSchema(def, defs, value, path)
+  }
+  if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+    for _, option := range anyOf {
+      if len(validateSchema(option.(map[string]interface{}), defs, value, path)) == 0 {
+        return nil
+      }
+    }
+    return []string{path + " matches none of the types it can be"}
+  }
+  kind, _ := schema["type"].(string)
+  problems := []string{}
+  mismatch := func() []string { return []string{fmt.Sprintf("%s should be %s", path, kind)} }
+  switch kind {
+  case "null":
+    if value != nil {
+      return mismatch()
+    }
+  case "string":
+    s, ok := value.(string)
+    if !ok {
+      return mismatch()
+    }
+    if schema["format"] == "date-time" {
+      if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+        return []string{path + " should be a date-time"}
+      }
+    }
+  case "boolean":
+    if _, ok := value.(bool); !ok {
+      return mismatch()
+    }
+  case "integer", "number":
+    n, ok := value.(float64)
+    if !ok || (kind == "integer" && n != float64(int64(n))) {
+      return mismatch()
+    }
+  case "array":
+    items, ok := value.([]interface{})
+    if !ok {
+      return mismatch()
+    }
+    itemSchema, _ := schema["items"].(map[string]interface{})
+    for i, item := range items {
+      problems = append(problems, validateSchema(itemSchema, defs, item, fmt.Sprintf("%s[%d]", path, i))...)
+    }
+  case "object":
+    object, ok := value.(map[string]interface{})
+    if !ok {
+      return mismatch()
+    }
+    required, _ := schema["required"].([]string)
+    for _, name := range required {
+      if _, ok := object[name]; !ok {
+        problems = append(problems, path+"."+name+" is missing")
+      }
+    }
+    properties, _ := schema["properties"].(map[string]interface{})
+    additional, _ := schema["additionalProperties"].(map[string]interface{})
+    for name, v := range object {
+      if property, ok := properties[name].(map[string]interface{}); ok {
+        problems = append(problems, validateSchema(property, defs, v, path+"."+name)...)
+      } else if additional != nil {
+        problems = append(problems, validateSchema(additional, defs, v, path+"."+name)...)
+      }
+    }
+  }
+  return problems
+}
+
+// whether outgoing payloads are checked against their schema, set from
+// `validate_payloads` in setup
+var validatePayloads bool
+
+var eventSchemas = struct {
+  sync.Mutex
+  versions map[int]map[string]interface{}
+}{versions: map[int]map[string]interface{}{}}
+
+// an error if validating payloads and the payload doesn't match its
+// version of the event schema. the delivery then fails, and goes down the
+// fallbacks
+func checkPayload(payload interface{}, version int) error {
+  if !validatePayloads {
+    return nil
+  }
+  eventSchemas.Lock()
+  schema, ok := eventSchemas.versions[version]
+  if !ok {
+    schema, _ = publishedSchema("event", version)
+    eventSchemas.versions[version] = schema
+  }
+  eventSchemas.Unlock()
+  contents, err := json.Marshal(payload)
+  if err != nil {
+    return err
+  }
+  var value interface{}
+  json.Unmarshal(contents, &value)
+  defs, _ := schema["$defs"].(map[string]interface{})
+  if problems := validateSchema(schema, defs, value, "$"); len(problems) > 0 {
+    return fmt.Errorf("the payload doesn't match version %d of the event schema: %s", version, strings.Join(problems, ", "))
+  }
+  return nil
+}
+
+//   GET /schemas/NAME.json      the latest json schema for the config or events
+//   GET /schemas/vN/NAME.json   a version of it
+func handleSchemas(w http.ResponseWriter, r *http.Request) {
+  if r.Method != "GET" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+  name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/schemas/"), ".json")
+  version := 0
+  if parts := strings.SplitN(name, "/", 2); len(parts) == 2 {
+    if _, err := fmt.Sscanf(parts[0], "v%d", &version); err != nil || version == 0 {
+      writeError(w, http.StatusNotFound, "no schema "+name)
+      return
+    }
+    name = parts[1]
+  }
+  schema, ok := publishedSchema(name, version)
+  if !ok {
+    writeError(w, http.StatusNotFound, "no schema "+name)
+    return
+  }
+  writeJSON(w, http.StatusOK, schema)
+}
+
+func schemaCommand(args []string) {
+  flags := flag.NewFlagSet("schema", flag.ExitOnError)
+  version := flags.Int("version", 0, "The version of the schema, the latest by default")
+  flags.Parse(args)
+  if flags.NArg() != 1 {
+    usageExit(commands["schema"].usage)
+  }
+  schema, ok := publishedSchema(flags.Arg(0), *version)
+  if !ok {
+    usageExit(commands["schema"].usage)
+  }
+  contents, _ := json.MarshalIndent(schema, "", "  ")
+  fmt.Println(string(contents))
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+  "encoding/csv"
+  "encoding/json"
+  "flag"
+  "fmt"
+  "github.com/plouc/go-jira-client"
+  "launchpad.net/goyaml"
+  "os"
+  "sort"
+  "strconv"
+  "strings"
+  "text/tabwriter"
+)
+
+func init() {
+  commands["search"] = command{"search [--columns=COL,..] [--sort=[-]COL,..] [--output=table|json|yaml|csv] [--limit=N] JQL", searchCommand}
+  commands["once"] = command{"once [--rule=NAME] [--columns=COL,..] [--sort=[-]COL,..] [--output=table|json|yaml|csv] [--limit=N]", onceCommand}
+}
+
+// the issues matching the jql, up to limit, across as many pages as needed
+func searchIssues(jql string, limit int, creds *Config) ([]*gojira.Issue, []map[string]interface{}, error) {
+  issues, fields := []*gojira.Issue{}, []map[string]interface{}{}
+  for len(issues) < limit {
+    page := creds.pageSize()
+    if limit-len(issues) < page {
+      page = limit - len(issues)
+    }
+    contents := jiraSearch(jql, len(issues), page, creds)
+    if contents == nil {
+      return nil, nil, fmt.Errorf("search failed: %s", jql)
+    }
+    var list rawIssueList
+    if err := json.Unmarshal(contents, &list); err != nil {
+      return nil, nil, err
+    }
+    for _, raw := range list.Issues {
+      issue, f, err := parseIssue(raw)
+      if err != nil {
+        return nil, nil, err
+      }
+      issues, fields = append(issues, issue), append(fields, f)
+    }
+    if len(list.Issues) == 0 || len(issues) >= list.Total {
+      break
+    }
+  }
+  return issues, fields, nil
+}
+
+const defaultColumns = "key,type,priority,status,assignee,summary"
+
+// the short names for the common columns, anything else is a field path
+// like customfield_10010 or reporter.name
+var columnFields = map[string]string{
+  "type":     "issuetype.name",
+  "priority": "priority.name",
+  "status":   "status.name",
+  "assignee": "assignee.displayName",
+  "reporter": "reporter.displayName",
+}
+
+// one row of results, the values of the columns by name
+type resultRow map[string]string
+
+func columnValue(key string, fields map[string]interface{}, column string) string {
+  switch column {
+  case "key":
+    return key
+  case "age":
+    return since(fieldString(fields, "created"))
+  }
+  path := column
+  if f, ok := columnFields[column]; ok {
+    path = f
+  }
+  if names := listNames(fields, path); len(names) > 0 {
+    return strings.Join(names, ",")
+  }
+  return fieldString(fields, path)
+}
+
+// sort the rows by the columns in order, descending for those starting with
+// a -. numbers are compared as numbers
+func sortRows(rows []resultRow, by []string) {
+  sort.SliceStable(rows, func(i, j int) bool {
+    for _, column := range by {
+      descending := strings.HasPrefix(column, "-")
+      column = strings.TrimPrefix(column, "-")
+      a, b := rows[i][column], rows[j][column]
+      if a == b {
+        continue
+      }
+      less := a < b
+      x, errA := strconv.ParseFloat(a, 64)
+      y, errB := strconv.ParseFloat(b, 64)
+      if errA == nil && errB == nil {
+        less = x < y
+      }
+      return less != descending
+    }
+    return false
+  })
+}
+
+func writeRows(rows []resultRow, columns []string, output string) error {
+  switch output {
+  case "json":
+    contents, err := json.MarshalIndent(rows, "", "  ")
+    if err != nil {
+      return err
+    }
+    fmt.Println(string(contents))
+  case "yaml":
+    contents, err := goyaml.Marshal(rows)
+    if err != nil {
+      return err
+    }
+    fmt.Print(string(contents))
+  case "csv":
+    w := csv.NewWriter(os.Stdout)
+    w.Write(columns)
+    for _, row := range rows {
+      record := []string{}
+      for _, column := range columns {
+        record = append(record, row[column])
+      }
+      w.Write(record)
+    }
+    w.Flush()
+    return w.Error()
+  case "table":
+    w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+    fmt.Fprintln(w, strings.ToUpper(strings.Join(columns, "\t")))
+    for _, row := range rows {
+      values := []string{}
+      for _, column := range columns {
+        // a tab or newline in a value would break the alignment
+        values = append(values, truncate(60, strings.Join(strings.Fields(row[column]), " ")))
+      }
+      fmt.Fprintln(w, strings.Join(values, "\t"))
+    }
+    return w.Flush()
+  default:
+    return fmt.Errorf("unknown output %q, expected table, json, yaml or csv", output)
+  }
+  return nil
+}
+
+type resultFlags struct {
+  flags   *flag.FlagSet
+  columns *string
+  sort    *string
+  output  *string
+  limit   *int
+}
+
+func newResultFlags(name, columns string) *resultFlags {
+  flags := flag.NewFlagSet(name, flag.ExitOnError)
+  return &resultFlags{
+    flags:   flags,
+    columns: flags.String("columns", columns, "The columns to show, short names or field paths"),
+    sort:    flags.String("sort", "", "The columns to sort by, with a - for descending"),
+    output:  flags.String("output", "table", "How to print the results: table, json, yaml or csv"),
+    limit:   flags.Int("limit", 50, "The most issues to fetch"),
+  }
+}
+
+func (f *resultFlags) print(rows []resultRow) {
+  columns := strings.Split(*f.columns, ",")
+  if len(*f.sort) > 0 {
+    sortRows(rows, strings.Split(*f.sort, ","))
+  }
+  if err := writeRows(rows, columns, *f.output); err != nil {
+    logger.Print("Error printing the results: ", err)
+    os.Exit(1)
+  }
+}
+
+func (f *resultFlags) row(issue *gojira.Issue, fields map[string]interface{}) resultRow {
+  row := resultRow{}
+  for _, column := range strings.Split(*f.columns, ",") {
+    row[column] = columnValue(issue.Key, fields, column)
+  }
+  return row
+}
+
+// an ad-hoc search, printed rather than notified about
+func searchCommand(args []string) {
+  f := newResultFlags("search", defaultColumns)
+  f.flags.Parse(args)
+  if f.flags.NArg() == 0 {
+    usageExit(commands["search"].usage)
+  }
+
+  creds, _ := setup()
+  issues, fields, err := searchIssues(configJql(creds, "the search", strings.Join(f.flags.Args(), " ")), *f.limit, creds)
+  if err != nil {
+    logger.Print("Error searching: ", err)
+    os.Exit(1)
+  }
+  rows := []resultRow{}
+  for i, issue := range issues {
+    rows = append(rows, f.row(issue, fields[i]))
+  }
+  f.print(rows)
+}
+
+// run each rule's search once and print the issues that pass its filters,
+// without notifying anyone or touching the state
+func onceCommand(args []string) {
+  f := newResultFlags("once", "rule,"+defaultColumns)
+  ruleName := f.flags.String("rule", "", "Only run this rule")
+  f.flags.Parse(args)
+  if f.flags.NArg() > 0 {
+    usageExit(commands["once"].usage)
+  }
+
+  creds, rules := setup()
+  if len(*ruleName) > 0 {
+    rule := findRule(rules, *ruleName)
+    if rule == nil {
+      logger.Print("No rule named ", *ruleName)
+      os.Exit(1)
+    }
+    rules = []*Rule{rule}
+  }
+  rows := []resultRow{}
+  for _, rule := range rules {
+    issues, fields, err := searchIssues(rule.query(), *f.limit, creds)
+    if err != nil {
+      logger.Print("Error searching for rule ", rule.Name, ": ", err)
+      os.Exit(1)
+    }
+    for i, issue := range issues {
+      if !conditionsPassed(rule.evaluate(issue, fields[i], creds)) {
+        continue
+      }
+      row := f.row(issue, fields[i])
+      if _, ok := row["rule"]; ok {
+        row["rule"] = rule.Name
+      }
+      rows = append(rows, row)
+    }
+  }
+  f.print(rows)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+  "fmt"
+  "os"
+  "regexp"
+)
+
+// the security fast path, configured under `security`. events for issues
+// with one of the labels or a CVE id in the summary or description only go
+// to the security targets (e.g. a private channel and the on-call pager),
+// ahead of everything else and never batched. new ones also get the
+// security level set so they stop being visible to everyone
+//
+//   security:
+//     labels: [security]
+//     pattern: 'CVE-\d{4}-\d{4,}'  # the default
+//     targets: [security-channel, security-pager]
+//     level: Security Team         # optional
+type SecurityConfig struct {
+  Labels  []string `yaml:"labels"`
+  Pattern string   `yaml:"pattern"`
+  Targets []string `yaml:"targets"`
+  Level   string   `yaml:"level"`
+
+  pattern *regexp.Regexp
+}
+
+const defaultSecurityPattern = `CVE-\d{4}-\d{4,}`
+
+var security SecurityConfig
+
+func loadSecurity(creds *Config) {
+  security = creds.Security
+  if len(security.Targets) == 0 {
+    return // off
+  }
+  pattern := security.Pattern
+  if len(pattern) == 0 {
+    pattern = defaultSecurityPattern
+  }
+  var err error
+  if security.pattern, err = regexp.Compile(pattern); err != nil {
+    logger.Print("Invalid security pattern: ", err)
+    os.Exit(1)
+  }
+}
+
+func (c *SecurityConfig) matches(event *Event) bool {
+  if c.pattern == nil {
+    return false
+  }
+  for _, label := range listNames(event.Fields, "labels") {
+    if containsFold(c.Labels, label) {
+      return true
+    }
+  }
+  return c.pattern.MatchString(event.Issue.Fields.Summary + "\n" + event.Issue.Fields.Description)
+}
+
+// send security events straight to the security targets, returns the rest
+func deliverSecurity(events []*Event) []*Event {
+  rest := []*Event{}
+  for _, event := range events {
+    if !security.matches(event) {
+      rest = append(rest, event)
+      continue
+    }
+    event.Targets = security.Targets
+    pipeline.step(event, PipelineStep{Stage: "security", Detail: "only sent to the security targets"})
+    for _, name := range event.Targets {
+      if s, ok := sinks[name]; ok {
+        s.send([]*Event{event})
+      } else {
+        logger.Print("Unknown target ", name)
+      }
+    }
+  }
+  return rest
+}
+
+// set the security level on new security issues
+func restrictSecurityIssue(event *Event, creds *Config) {
+  if len(security.Level) == 0 || event.Kind != eventCreated || !security.matches(event) {
+    return
+  }
+  if err := setSecurityLevel(event.Issue.Key, security.Level, creds); err != nil {
+    logger.Print("Error setting the security level of ", event.Issue.Key, ": ", err)
+  }
+}
+
+// set an issue's security level by name, which has to be in the project's
+// security scheme
+func setSecurityLevel(key, level string, creds *Config) error {
+  body := map[string]interface{}{
+    "fields": map[string]interface{}{"security": map[string]string{"name": level}},
+  }
+  if _, err := jiraRequest("PUT", "/issue/"+key, body, creds.accountFor("edit")); err != nil {
+    return err
+  }
+  logger.Print("Set the security level of ", key, " to ", level)
+  return nil
+}
+
+
+// a security-level target sets the level on the issues sent to it, e.g.
+// from a rule matching public projects, optionally only if the summary or
+// description matches a pattern
+//
+//   targets:
+//     hide-secrets:
+//       type: security-level
+//       level: Internal
+//       pattern: '(?i)password|token|secret'
+type securityLevelNotifier struct {
+  level   string
+  pattern *regexp.Regexp
+  creds   *Config
+}
+
+func newSecurityLevelNotifier(target Target, creds *Config) (*securityLevelNotifier, error) {
+  if len(target.Level) == 0 {
+    return nil, fmt.Errorf("a level is required")
+  }
+  n := &securityLevelNotifier{level: target.Level, creds: creds}
+  if len(target.Pattern) > 0 {
+    var err error
+    if n.pattern, err = regexp.Compile(target.Pattern); err != nil {
+      return nil, fmt.Errorf("invalid pattern: %v", err)
+    }
+  }
+  return n, nil
+}
+
+func (n *securityLevelNotifier) Notify(event *Event, message string) error {
+  if fieldString(event.Fields, "security.name") == n.level {
+    return nil // already set
+  }
+  if n.pattern != nil && !n.pattern.MatchString(event.Issue.Fields.Summary+"\n"+event.Issue.Fields.Description) {
+    return nil
+  }
+  return setSecurityLevel(event.Issue.Key, n.level, n.creds)
+}
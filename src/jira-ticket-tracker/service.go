@@ -0,0 +1,13 @@
+package main
+
+import (
+  "flag"
+)
+
+// --service runs the tracker as a windows service, started and stopped by
+// the service manager. a stop hands over like an upgrade does, so the state
+// and the snapshot are saved first, see handOver. elsewhere systemd and
+// launchd run it as it is, and the flag is refused
+//
+//   sc create jira-ticket-tracker start= auto binPath= "C:\tracker\jira-ticket-tracker.exe --service --config=C:\tracker\config.yaml --state=C:\tracker\state.json"
+var asService = flag.Bool("service", false, "Run as a windows service, under the service manager")
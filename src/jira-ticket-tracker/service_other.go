@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+  "os"
+)
+
+func startService() {
+  logger.Print("--service is only for windows, run the tracker under systemd or launchd instead")
+  os.Exit(1)
+}
+
+func serviceRunning(creds *Config) {}
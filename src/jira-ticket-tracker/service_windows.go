@@ -0,0 +1,122 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+  "os"
+  "path/filepath"
+  "runtime"
+  "syscall"
+  "unsafe"
+)
+
+var (
+  procStartServiceCtrlDispatcher = advapi32.NewProc("StartServiceCtrlDispatcherW")
+  procRegisterServiceCtrlHandler = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+  procSetServiceStatus           = advapi32.NewProc("SetServiceStatus")
+)
+
+const (
+  serviceWin32OwnProcess = 0x10
+
+  serviceStopped      = 1
+  serviceStartPending = 2
+  serviceStopPending  = 3
+  serviceRunningState = 4
+
+  serviceAcceptStop     = 1
+  serviceAcceptShutdown = 4
+
+  serviceControlStop        = 1
+  serviceControlInterrogate = 4
+  serviceControlShutdown    = 5
+
+  errorCallNotImplemented = 120
+)
+
+// SERVICE_TABLE_ENTRYW
+type serviceTableEntry struct {
+  name *uint16
+  proc uintptr
+}
+
+// SERVICE_STATUS
+type serviceStatus struct {
+  serviceType             uint32
+  currentState            uint32
+  controlsAccepted        uint32
+  win32ExitCode           uint32
+  serviceSpecificExitCode uint32
+  checkPoint              uint32
+  waitHint                uint32
+}
+
+var service = struct {
+  handle uintptr
+  ready  chan struct{} // closed once the tracker is up
+  creds  *Config
+}{ready: make(chan struct{})}
+
+func setServiceStatus(state, accepted uint32) {
+  status := serviceStatus{serviceType: serviceWin32OwnProcess, currentState: state, controlsAccepted: accepted}
+  procSetServiceStatus.Call(service.handle, uintptr(unsafe.Pointer(&status)))
+}
+
+// connect to the service manager while the tracker starts. it waits in
+// start pending until serviceRunning. its output goes to a log next to the
+// binary, since a service has nowhere else to write it
+func startService() {
+  if exe, err := os.Executable(); err == nil {
+    if f, err := os.OpenFile(filepath.Join(filepath.Dir(exe), "jira-ticket-tracker.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err == nil {
+      logger.SetOutput(f)
+    }
+  }
+  go func() {
+    // the dispatcher keeps the thread it's called on until the service stops
+    runtime.LockOSThread()
+    name, _ := syscall.UTF16PtrFromString("jira-ticket-tracker") // an own process service's name isn't checked
+    table := []serviceTableEntry{{name, syscall.NewCallback(serviceMain)}, {nil, 0}}
+    if ok, _, err := procStartServiceCtrlDispatcher.Call(uintptr(unsafe.Pointer(&table[0]))); ok == 0 {
+      logger.Print("Error connecting to the service manager, --service is for when it starts the tracker: ", err)
+      os.Exit(1)
+    }
+  }()
+}
+
+func serviceMain(argc uint32, argv **uint16) uintptr {
+  handle, _, err := procRegisterServiceCtrlHandler.Call(uintptr(unsafe.Pointer(*argv)), syscall.NewCallback(serviceHandler), 0)
+  if handle == 0 {
+    logger.Print("Error registering with the service manager: ", err)
+    os.Exit(1)
+  }
+  service.handle = handle
+  setServiceStatus(serviceStartPending, 0)
+  <-service.ready
+  setServiceStatus(serviceRunningState, serviceAcceptStop|serviceAcceptShutdown)
+  select {}
+}
+
+func serviceHandler(control, eventType uint32, eventData, context uintptr) uintptr {
+  switch control {
+  case serviceControlStop, serviceControlShutdown:
+    setServiceStatus(serviceStopPending, 0)
+    go func() {
+      logger.Print("Stopping for the service manager")
+      shutdown(service.creds)
+      setServiceStatus(serviceStopped, 0)
+      os.Exit(0)
+    }()
+    return 0
+  case serviceControlInterrogate:
+    return 0
+  }
+  return errorCallNotImplemented
+}
+
+// tell the service manager the tracker is up, and wait to be stopped
+func serviceRunning(creds *Config) {
+  service.creds = creds
+  close(service.ready)
+  select {}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "hash/fnv"
+  "net/http"
+  "os"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+)
+
+var replica = flag.String("replica", "", "This replica's name in sharding.replicas, the hostname by default")
+
+// several replicas with the same config splitting the rules between them,
+// configured under `sharding`. each rule belongs to one replica, picked by
+// hashing its project (or its name, for rules without one) onto a ring of
+// the replicas that are up. replicas check on each other every interval and
+// when one goes away, only its rules move to the others
+//
+//   sharding:
+//     replicas:            # name -> control api, every replica needs --listen
+//       tracker-0: http://tracker-0:8080
+//       tracker-1: http://tracker-1:8080
+//       tracker-2: http://tracker-2:8080
+//     by: project          # or rule
+//     check: 30s
+type ShardingConfig struct {
+  Replicas map[string]string `yaml:"replicas"`
+  By       string            `yaml:"by"`
+  Check    string            `yaml:"check"`
+}
+
+// points per replica on the ring, so the rules spread out evenly
+const shardPoints = 100
+
+var shards = &shardRing{}
+
+type shardRing struct {
+  config ShardingConfig
+  self   string
+  check  time.Duration
+  client *http.Client
+
+  mu     sync.Mutex
+  live   []string
+  points []uint32
+  owners map[uint32]string
+}
+
+func loadSharding(creds *Config) {
+  shards = &shardRing{config: creds.Sharding}
+  if !shards.enabled() {
+    return
+  }
+  shards.self = *replica
+  if len(shards.self) == 0 {
+    shards.self, _ = os.Hostname()
+  }
+  if _, ok := creds.Sharding.Replicas[shards.self]; !ok {
+    logger.Print("This replica, ", shards.self, ", isn't in sharding.replicas, set --replica")
+    os.Exit(1)
+  }
+  if by := creds.Sharding.By; len(by) > 0 && by != "project" && by != "rule" {
+    logger.Print("sharding.by must be project or rule, not ", by)
+    os.Exit(1)
+  }
+  shards.check = durationOr(creds.Sharding.Check, 30*time.Second)
+  shards.client = &http.Client{Timeout: 5 * time.Second}
+  // until the others have been checked on, assume they are all up
+  live := []string{}
+  for name := range creds.Sharding.Replicas {
+    live = append(live, name)
+  }
+  shards.build(live)
+}
+
+func (s *shardRing) enabled() bool {
+  return len(s.config.Replicas) > 0
+}
+
+func shardHash(key string) uint32 {
+  h := fnv.New32a()
+  h.Write([]byte(key))
+  return h.Sum32()
+}
+
+func (s *shardRing) build(live []string) {
+  sort.Strings(live)
+  points, owners := []uint32{}, map[uint32]string{}
+  for _, name := range live {
+    for i := 0; i < shardPoints; i++ {
+      point := shardHash(fmt.Sprintf("%s#%d", name, i))
+      if _, taken := owners[point]; !taken {
+        owners[point] = name
+        points = append(points, point)
+      }
+    }
+  }
+  sort.Slice(points, func(i, j int) bool { return points[i] < points[j] })
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  s.live, s.points, s.owners = live, points, owners
+}
+
+func (s *shardRing) key(rule *Rule) string {
+  if s.config.By != "rule" && len(rule.Project) > 0 {
+    return strings.ToUpper(rule.Project)
+  }
+  return rule.Name
+}
+
+// the replica that polls a rule
+func (s *shardRing) owner(rule *Rule) string {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  if len(s.points) == 0 {
+    return s.self
+  }
+  h := shardHash(s.key(rule))
+  i := sort.Search(len(s.points), func(i int) bool { return s.points[i] >= h })
+  if i == len(s.points) {
+    i = 0
+  }
+  return s.owners[s.points[i]]
+}
+
+// the rules this replica polls, all of them without sharding
+func (s *shardRing) owned(rules []*Rule) []*Rule {
+  if !s.enabled() {
+    return rules
+  }
+  mine := []*Rule{}
+  for _, rule := range rules {
+    if s.owner(rule) == s.self {
+      mine = append(mine, rule)
+    }
+  }
+  return mine
+}
+
+// whether this replica runs the jobs that aren't any rule's, like the
+// reports and sweeps: the first replica that's up, so they move on when it
+// goes away. always without sharding
+func (s *shardRing) leading() bool {
+  if !s.enabled() {
+    return true
+  }
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  return len(s.live) == 0 || s.live[0] == s.self
+}
+
+// whether this replica follows up on an issue the named rule matched, like
+// with reminders: the rule's owner, or the leader for issues no rule did
+func (s *shardRing) follows(rules map[string]*Rule, name string) bool {
+  rule, ok := rules[name]
+  if !ok {
+    return s.leading()
+  }
+  return !s.enabled() || s.owner(rule) == s.self
+}
+
+// a replica that answers at all is up, whatever it answers
+func (s *shardRing) up(name string) bool {
+  if name == s.self {
+    return true
+  }
+  resp, err := s.client.Get(strings.TrimSuffix(s.config.Replicas[name], "/") + "/status")
+  if err != nil {
+    return false
+  }
+  drainAndClose(resp.Body)
+  return true
+}
+
+func (s *shardRing) checkForever() {
+  for {
+    time.Sleep(s.check)
+    live := []string{}
+    for name := range s.config.Replicas {
+      if s.up(name) {
+        live = append(live, name)
+      }
+    }
+    sort.Strings(live)
+    s.mu.Lock()
+    same := strings.Join(live, ",") == strings.Join(s.live, ",")
+    s.mu.Unlock()
+    if same {
+      continue
+    }
+    s.build(live)
+    added, _, removed := reshardRules()
+    logger.Print(fmt.Sprintf("Replicas up now: %s, polling %d more rules and %d fewer", strings.Join(live, ", "), added, removed))
+  }
+}
+
+//   GET /shards   the replicas that are up and which of them polls each rule
+func handleShards(w http.ResponseWriter, r *http.Request) {
+  if r.Method != "GET" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+  if !shards.enabled() {
+    writeError(w, http.StatusNotFound, "the rules aren't sharded")
+    return
+  }
+  owners := map[string]string{}
+  for _, rule := range configuredPollerRules() {
+    owners[rule.Name] = shards.owner(rule)
+  }
+  shards.mu.Lock()
+  live := append([]string{}, shards.live...)
+  shards.mu.Unlock()
+  writeJSON(w, http.StatusOK, map[string]interface{}{"self": shards.self, "live": live, "rules": owners})
+}
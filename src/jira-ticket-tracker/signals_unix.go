@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+  "os"
+  "os/signal"
+  "syscall"
+)
+
+// SIGUSR1 polls everything now, e.g. `kill -USR1 $(pidof jira-ticket-tracker)`
+func handlePollSignal() {
+  signals := make(chan os.Signal, 1)
+  signal.Notify(signals, syscall.SIGUSR1)
+  for range signals {
+    logger.Print("Polling now, got SIGUSR1")
+    triggerPoll()
+  }
+}
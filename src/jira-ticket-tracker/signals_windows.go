@@ -0,0 +1,7 @@
+//go:build windows
+// +build windows
+
+package main
+
+// there is no SIGUSR1 on windows, use POST /poll or the poll command
+func handlePollSignal() {}
@@ -0,0 +1,92 @@
+package main
+
+import (
+  "crypto/hmac"
+  "crypto/sha256"
+  "encoding/hex"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// signing a webhook's payloads with a shared secret. each post has the unix
+// time it was sent in X-Tracker-Timestamp and, in X-Tracker-Signature,
+// sha256= and the hex hmac-sha256 of the timestamp, a dot and the body. a
+// receiver recomputes it to check the post came from the tracker, and
+// rejects old timestamps so a captured post can't be replayed
+//
+//   targets:
+//     audit:
+//       type: webhook
+//       url: https://audit.acme.com/jira-events
+//       signing:
+//         secret_env: AUDIT_WEBHOOK_SECRET   # or secret, secret_file or secret_keyring
+//         header: X-Audit-Signature          # X-Tracker-Signature by default
+type SigningConfig struct {
+  Secret        string `yaml:"secret"`
+  SecretEnv     string `yaml:"secret_env"`
+  SecretFile    string `yaml:"secret_file"`
+  SecretKeyring string `yaml:"secret_keyring"` // SERVICE/ACCOUNT, see keyringSecret
+  Header        string `yaml:"header"`
+}
+
+type webhookSigner struct {
+  secret []byte
+  header string
+}
+
+func (c SigningConfig) secret() (string, error) {
+  switch {
+  case len(c.Secret) > 0:
+    return c.Secret, nil
+  case len(c.SecretEnv) > 0:
+    secret := os.Getenv(c.SecretEnv)
+    if len(secret) == 0 {
+      return "", fmt.Errorf("%s is not set", c.SecretEnv)
+    }
+    return secret, nil
+  case len(c.SecretFile) > 0:
+    contents, err := ioutil.ReadFile(c.SecretFile)
+    return strings.TrimSpace(string(contents)), err
+  case len(c.SecretKeyring) > 0:
+    return keyringSecret(c.SecretKeyring)
+  }
+  return "", nil
+}
+
+// nil when the target isn't signed
+func newWebhookSigner(name string, c SigningConfig) *webhookSigner {
+  secret, err := c.secret()
+  if err != nil {
+    logger.Print("Error getting the signing secret for target ", name, ": ", err)
+    os.Exit(1)
+  }
+  if len(secret) == 0 {
+    if len(c.Header) > 0 {
+      logger.Print("Target ", name, " has a signing header but no secret")
+      os.Exit(1)
+    }
+    return nil
+  }
+  if err := checkFIPSSecret("The signing secret for target "+name, secret); err != nil {
+    logger.Print(err)
+    os.Exit(1)
+  }
+  header := c.Header
+  if len(header) == 0 {
+    header = "X-Tracker-Signature"
+  }
+  return &webhookSigner{secret: []byte(secret), header: header}
+}
+
+func (s *webhookSigner) sign(headers map[string]string, body []byte) {
+  timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+  mac := hmac.New(sha256.New, s.secret)
+  mac.Write([]byte(timestamp + "."))
+  mac.Write(body)
+  headers["X-Tracker-Timestamp"] = timestamp
+  headers[s.header] = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
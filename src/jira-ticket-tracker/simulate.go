@@ -0,0 +1,95 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "sort"
+  "strings"
+)
+
+func init() {
+  commands["simulate"] = command{"simulate --issue=FIXTURE.json --rule=NAME [--kind=created] [--real]", simulateCommand}
+}
+
+func findRule(rules []*Rule, name string) *Rule {
+  for _, r := range rules {
+    if r.Name == name {
+      return r
+    }
+  }
+  return nil
+}
+
+// run a fixture issue (as returned by GET /issue/KEY) through a rule's
+// filters, computed fields, routing and templates. by default nothing is
+// sent and the message for each target is printed instead
+func simulateCommand(args []string) {
+  flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+  fixture := flags.String("issue", "", "A json file with the issue to simulate")
+  ruleName := flags.String("rule", "", "The rule to run it through")
+  kind := flags.String("kind", eventCreated, "The kind of event to simulate")
+  real := flags.Bool("real", false, "Actually send to the targets instead of a dry-run")
+  flags.Parse(args)
+  if len(*fixture) == 0 || len(*ruleName) == 0 {
+    usageExit(commands["simulate"].usage)
+  }
+
+  creds, rules := setup()
+  rule := findRule(rules, *ruleName)
+  if rule == nil {
+    logger.Print("No rule named ", *ruleName)
+    os.Exit(1)
+  }
+
+  contents, err := ioutil.ReadFile(*fixture)
+  if err != nil {
+    logger.Print("Error reading fixture: ", err)
+    os.Exit(1)
+  }
+  issue, fields, err := parseIssue(contents)
+  if err != nil {
+    logger.Print("Error parsing fixture: ", err)
+    os.Exit(1)
+  }
+
+  results := rule.evaluate(issue, fields, creds)
+  fmt.Printf("rule %s against %s: %s\n", rule.Name, issue.Key, matchOutcome(results))
+  fmt.Println(formatConditions(results))
+  if !conditionsPassed(results) {
+    os.Exit(1)
+  }
+  if len(uncheckedConditions(results)) > 0 {
+    fmt.Println("carrying on as if jira's search matches it")
+  }
+
+  event := ruleEvent(rule, *kind, issue, fields, creds)
+  if security.matches(event) {
+    fmt.Println("security issue, only sent to the security targets")
+    event.Targets = security.Targets
+  }
+  if len(event.Computed) > 0 {
+    names := []string{}
+    for name, value := range event.Computed {
+      names = append(names, name+"="+value)
+    }
+    sort.Strings(names)
+    fmt.Println("computed:", strings.Join(names, ", "))
+  }
+  fmt.Println("targets:", strings.Join(event.Targets, ", "))
+
+  if *real {
+    deliver([]*Event{event})
+    fmt.Println("sent")
+    return
+  }
+  for _, name := range event.Targets {
+    s, ok := sinks[name]
+    if !ok {
+      fmt.Printf("--- %s: unknown target ---\n", name)
+      continue
+    }
+    fmt.Printf("--- %s (%s) ---\n%s\n", name, s.target.Type, s.message(event))
+  }
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "net/url"
+  "strings"
+  "time"
+)
+
+const slackAPIUrl = "https://slack.com/api/"
+
+// call a slack web api method with a bot token. body is posted as json, or
+// nil for a GET with the query instead
+func slackAPI(token, method string, query url.Values, body, out interface{}) error {
+  var req *http.Request
+  var err error
+  uri := slackAPIUrl + method
+  if body != nil {
+    contents, err := json.Marshal(body)
+    if err != nil {
+      return err
+    }
+    req, err = http.NewRequest("POST", uri, bytes.NewReader(contents))
+    if err != nil {
+      return err
+    }
+    req.Header.Set("Content-Type", "application/json; charset=utf-8")
+  } else {
+    req, err = http.NewRequest("GET", uri+"?"+query.Encode(), nil)
+    if err != nil {
+      return err
+    }
+  }
+  req.Header.Set("Authorization", "Bearer "+token)
+
+  resp, err := http.DefaultClient.Do(req)
+  if err != nil {
+    return err
+  }
+  defer drainAndClose(resp.Body)
+  if resp.StatusCode >= 300 {
+    return fmt.Errorf("slack %s returned %s", method, resp.Status)
+  }
+
+  var raw json.RawMessage
+  if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+    return err
+  }
+  var status struct {
+    Ok    bool   `json:"ok"`
+    Error string `json:"error"`
+  }
+  if err := json.Unmarshal(raw, &status); err != nil {
+    return err
+  }
+  if !status.Ok {
+    return fmt.Errorf("slack %s failed: %s", method, status.Error)
+  }
+  if out != nil {
+    return json.Unmarshal(raw, out)
+  }
+  return nil
+}
+
+// a message posted through the web api, so it can be found again later
+type slackMessage struct {
+  Channel string `json:"channel"`
+  Ts      string `json:"ts"`
+}
+
+// the first message about an issue, which updates are replies to and
+// which is struck through once the issue is resolved
+type SlackThread struct {
+  Message slackMessage `json:"message"`
+  Text    string       `json:"text,omitempty"`
+  Posted  time.Time    `json:"posted"`
+}
+
+const slackThreadAge = 30 * 24 * time.Hour
+
+// post a chat.postMessage body, which has the channel and text or blocks
+func slackPost(token string, body map[string]interface{}) (slackMessage, error) {
+  var posted slackMessage
+  err := slackAPI(token, "chat.postMessage", nil, body, &posted)
+  return posted, err
+}
+
+// whether anyone has reacted to a message
+func slackReacted(token string, message slackMessage) (bool, error) {
+  var out struct {
+    Message struct {
+      Reactions []struct {
+        Name  string `json:"name"`
+        Count int    `json:"count"`
+      } `json:"reactions"`
+    } `json:"message"`
+  }
+  query := url.Values{"channel": {message.Channel}, "timestamp": {message.Ts}}
+  if err := slackAPI(token, "reactions.get", query, nil, &out); err != nil {
+    return false, err
+  }
+  return len(out.Message.Reactions) > 0, nil
+}
+
+// replace the text of a message, dropping any blocks and their buttons
+func slackUpdate(token string, message slackMessage, text string) error {
+  body := map[string]interface{}{"channel": message.Channel, "ts": message.Ts, "text": text, "blocks": []interface{}{}}
+  return slackAPI(token, "chat.update", nil, body, nil)
+}
+
+// strike through every line of a message, slack's ~ doesn't span lines
+func slackStrike(text string) string {
+  lines := strings.Split(text, "\n")
+  for i, line := range lines {
+    if len(strings.TrimSpace(line)) > 0 {
+      lines[i] = "~" + line + "~"
+    }
+  }
+  return strings.Join(lines, "\n")
+}
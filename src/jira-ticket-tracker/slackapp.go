@@ -0,0 +1,251 @@
+package main
+
+import (
+  "crypto/hmac"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "net/url"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// the slack app behind the buttons on block kit messages, configured under
+// `slack`. its interactivity request url is /slack/actions on the control
+// api. clicks act in jira as the tracker's user, on behalf of the jira user
+// the slack user maps to (from `users`, or by their email address)
+//
+//   slack:
+//     signing_secret: ...   # from the app's basic information
+//     token: xoxb-...       # to look up users' email addresses
+//     users: {U024BE7LH: jsmith}
+//     snooze: 4h            # how long snooze mutes an issue for
+type SlackAppConfig struct {
+  SigningSecret string            `yaml:"signing_secret"`
+  Token         string            `yaml:"token"`
+  Users         map[string]string `yaml:"users"`
+  Snooze        string            `yaml:"snooze"`
+}
+
+const (
+  slackActionAssign     = "jtt-assign"
+  slackActionTransition = "jtt-transition"
+  slackActionSnooze     = "jtt-snooze"
+)
+
+var slackApp = &slackAppHandler{}
+
+type slackAppHandler struct {
+  config SlackAppConfig
+  snooze time.Duration
+  creds  *Config
+}
+
+func loadSlackApp(creds *Config) {
+  slackApp = &slackAppHandler{config: creds.Slack, snooze: durationOr(creds.Slack.Snooze, 4*time.Hour), creds: creds}
+}
+
+func slackText(kind, text string) map[string]interface{} {
+  return map[string]interface{}{"type": kind, "text": text}
+}
+
+func slackButton(label, actionId, value string) map[string]interface{} {
+  return map[string]interface{}{
+    "type": "button", "text": slackText("plain_text", label), "action_id": actionId, "value": value,
+  }
+}
+
+// the block kit form of a message: the text, what happened and the buttons.
+// tracker events have no issue to act on so they get no buttons
+func slackBlocks(event *Event, text string, buttons, transitions []string) []interface{} {
+  blocks := []interface{}{
+    map[string]interface{}{"type": "section", "text": slackText("mrkdwn", text)},
+    map[string]interface{}{"type": "context", "elements": []interface{}{
+      slackText("mrkdwn", fmt.Sprintf("%s · %s", event.Issue.Key, event.Kind)),
+    }},
+  }
+  if event.Issue.Key == trackerKey {
+    return blocks
+  }
+  if len(buttons) == 0 {
+    buttons = []string{"assign", "transition", "snooze"}
+  }
+  key := event.Issue.Key
+  elements := []interface{}{}
+  for _, button := range buttons {
+    switch button {
+    case "assign":
+      elements = append(elements, slackButton("Assign to me", slackActionAssign, key))
+    case "transition":
+      for i, name := range transitions {
+        // action ids have to be unique within the message
+        elements = append(elements, slackButton(name, slackActionTransition+"-"+strconv.Itoa(i), key+"|"+name))
+      }
+    case "snooze":
+      elements = append(elements, slackButton("Snooze", slackActionSnooze, key))
+    }
+  }
+  if len(elements) > 0 {
+    blocks = append(blocks, map[string]interface{}{"type": "actions", "elements": elements})
+  }
+  return blocks
+}
+
+// check the request came from slack, by its signature of the timestamp and
+// body. old timestamps are refused so requests can't be replayed
+func (a *slackAppHandler) verify(r *http.Request, body []byte) bool {
+  if len(a.config.SigningSecret) == 0 {
+    return false
+  }
+  timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+  seconds, err := strconv.ParseInt(timestamp, 10, 64)
+  if err != nil || time.Since(time.Unix(seconds, 0)) > 5*time.Minute {
+    return false
+  }
+  mac := hmac.New(sha256.New, []byte(a.config.SigningSecret))
+  mac.Write([]byte("v0:" + timestamp + ":"))
+  mac.Write(body)
+  expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+  return hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Slack-Signature")))
+}
+
+// the jira user for a slack user, from the config or by email address
+func (a *slackAppHandler) jiraUser(slackUser string) (string, error) {
+  if name, ok := a.config.Users[slackUser]; ok {
+    return name, nil
+  }
+  if len(a.config.Token) == 0 {
+    return "", fmt.Errorf("no jira user is configured for %s", slackUser)
+  }
+  var info struct {
+    User struct {
+      Profile struct {
+        Email string `json:"email"`
+      } `json:"profile"`
+    } `json:"user"`
+  }
+  if err := slackAPI(a.config.Token, "users.info", url.Values{"user": {slackUser}}, nil, &info); err != nil {
+    return "", err
+  }
+  email := info.User.Profile.Email
+  if len(email) == 0 {
+    return "", fmt.Errorf("%s has no email address in slack", slackUser)
+  }
+  contents, err := jiraCached("/user/search?username="+url.QueryEscape(email), a.creds)
+  if err != nil {
+    return "", err
+  }
+  var users []struct {
+    Name string `json:"name"`
+  }
+  if err := json.Unmarshal(contents, &users); err != nil {
+    return "", err
+  }
+  if len(users) != 1 {
+    return "", fmt.Errorf("%d jira users have the email address %s", len(users), email)
+  }
+  return users[0].Name, nil
+}
+
+type slackInteraction struct {
+  Type string `json:"type"`
+  User struct {
+    Id string `json:"id"`
+  } `json:"user"`
+  Actions []struct {
+    ActionId string `json:"action_id"`
+    Value    string `json:"value"`
+  } `json:"actions"`
+  ResponseUrl string `json:"response_url"`
+}
+
+// do what the button says, returning what to tell the user who clicked it
+func (a *slackAppHandler) act(slackUser, actionId, value string) (string, error) {
+  switch {
+  case actionId == slackActionAssign:
+    user, err := a.jiraUser(slackUser)
+    if err != nil {
+      return "", err
+    }
+    if err := assignIssue(value, user, a.creds); err != nil {
+      return "", err
+    }
+    return fmt.Sprintf("Assigned %s to %s", value, user), nil
+  case strings.HasPrefix(actionId, slackActionTransition):
+    parts := strings.SplitN(value, "|", 2)
+    if len(parts) != 2 {
+      return "", fmt.Errorf("invalid transition %q", value)
+    }
+    if err := transitionIssue(parts[0], parts[1], a.creds); err != nil {
+      return "", err
+    }
+    return fmt.Sprintf("Moved %s to %s", parts[0], parts[1]), nil
+  case actionId == slackActionApprove || actionId == slackActionReject:
+    return approvals.decide(a, slackUser, value, actionId == slackActionApprove)
+  case actionId == slackActionSnooze:
+    until := time.Now().Add(a.snooze)
+    snoozeIssue(value, until)
+    return fmt.Sprintf("Snoozed %s until %s", value, until.Format("15:04 Mon")), nil
+  }
+  return "", fmt.Errorf("unknown action %s", actionId)
+}
+
+func (a *slackAppHandler) handle(interaction *slackInteraction) {
+  for _, action := range interaction.Actions {
+    reply, err := a.act(interaction.User.Id, action.ActionId, action.Value)
+    if err != nil {
+      logger.Print("Error handling the slack action ", action.ActionId, " on ", action.Value, ": ", err)
+      reply = "That didn't work: " + err.Error()
+    } else {
+      logger.Print("Slack user ", interaction.User.Id, ": ", reply)
+    }
+    if len(interaction.ResponseUrl) > 0 {
+      response := map[string]interface{}{"response_type": "ephemeral", "replace_original": false, "text": reply}
+      if err := postJSON(interaction.ResponseUrl, response); err != nil {
+        logger.Print("Error replying to the slack action: ", err)
+      }
+    }
+  }
+}
+
+//   POST /slack/actions   slack's interactivity requests, for the buttons
+func handleSlackActions(w http.ResponseWriter, r *http.Request) {
+  if r.Method != "POST" {
+    writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+  body, err := ioutil.ReadAll(r.Body)
+  if err != nil {
+    writeError(w, http.StatusBadRequest, err.Error())
+    return
+  }
+  if !slackApp.verify(r, body) {
+    writeError(w, http.StatusUnauthorized, "invalid signature")
+    return
+  }
+  form, err := url.ParseQuery(string(body))
+  if err != nil {
+    writeError(w, http.StatusBadRequest, err.Error())
+    return
+  }
+  var interaction slackInteraction
+  if err := json.Unmarshal([]byte(form.Get("payload")), &interaction); err != nil {
+    writeError(w, http.StatusBadRequest, "invalid payload: "+err.Error())
+    return
+  }
+  if interaction.Type != "block_actions" {
+    w.WriteHeader(http.StatusOK)
+    return
+  }
+  // slack wants an answer within 3 seconds, jira can take longer
+  go func() {
+    producers.RLock()
+    defer producers.RUnlock()
+    slackApp.handle(&interaction)
+  }()
+  w.WriteHeader(http.StatusOK)
+}
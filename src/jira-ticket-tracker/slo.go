@@ -0,0 +1,162 @@
+package main
+
+import (
+  "fmt"
+  "net/http"
+  "os"
+  "sort"
+  "strings"
+  "time"
+)
+
+const eventSLOReport = "slo-report"
+
+// the delivery latency objective, configured under `slo`. latency is from
+// an issue being created to its notification being delivered, per target.
+// the counts are kept per week in the state file and a report on the week
+// before is sent to `report` every monday
+//
+//   slo:
+//     latency: 60s
+//     objective: 95%
+//     report: [ops-email]
+type SLOConfig struct {
+  Latency   string   `yaml:"latency"`
+  Objective string   `yaml:"objective"`
+  Report    []string `yaml:"report"`
+
+  latency   time.Duration
+  objective float64
+}
+
+// how many weeks of counts are kept
+const sloWeeks = 8
+
+var slo SLOConfig
+
+func loadSLO(creds *Config) {
+  slo = creds.SLO
+  slo.latency = durationOr(slo.Latency, time.Minute)
+  slo.objective = 0.95
+  if len(slo.Objective) > 0 {
+    var percent float64
+    if _, err := fmt.Sscanf(strings.TrimSuffix(slo.Objective, "%"), "%g", &percent); err != nil || percent <= 0 || percent > 100 {
+      logger.Print("Invalid slo objective ", slo.Objective)
+      os.Exit(1)
+    }
+    slo.objective = percent / 100
+  }
+}
+
+// the deliveries to one target in a week
+type DeliveryCounts struct {
+  Delivered int `json:"delivered"`
+  WithinSLO int `json:"within_slo"` // of the created events, which are timed
+  Timed     int `json:"timed"`
+  Failed    int `json:"failed"`
+}
+
+func (d *DeliveryCounts) compliance() float64 {
+  if d.Timed == 0 {
+    return 1
+  }
+  return float64(d.WithinSLO) / float64(d.Timed)
+}
+
+func weekOf(t time.Time) string {
+  year, week := t.ISOWeek()
+  return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// how long after the issue was created an event was delivered, for created
+// events only
+func deliveryLatency(event *Event) (time.Duration, bool) {
+  if event.Kind != eventCreated || event.Issue.Fields == nil {
+    return 0, false
+  }
+  created, err := time.Parse(dateLayout, event.Issue.Fields.Created)
+  if err != nil {
+    return 0, false
+  }
+  return time.Since(created), true
+}
+
+// record a delivery attempt in the metrics and the week's counts
+func recordDelivery(sink string, event *Event, err error) {
+  latency, timed := deliveryLatency(event)
+  deliveryMetrics.record(sink, latency, timed, err)
+  if state != nil {
+    state.RecordDelivery(weekOf(time.Now()), sink, err == nil, timed, latency <= slo.latency)
+  }
+}
+
+func formatSLOReport(week string, counts map[string]DeliveryCounts) string {
+  names := []string{}
+  for name := range counts {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  lines := []string{fmt.Sprintf("Delivery SLO for %s (%.0f%% within %s):", week, slo.objective*100, slo.latency)}
+  for _, name := range names {
+    c := counts[name]
+    status := "met"
+    if c.compliance() < slo.objective {
+      status = "MISSED"
+    }
+    lines = append(lines, fmt.Sprintf("%s: %.1f%% of %d timed, %d delivered, %d failed, %s",
+      name, c.compliance()*100, c.Timed, c.Delivered, c.Failed, status))
+  }
+  if len(names) == 0 {
+    lines = append(lines, "nothing was delivered")
+  }
+  return strings.Join(lines, "\n")
+}
+
+// send last week's report once the week is over
+func reportSLOWeekly() {
+  for {
+    last := weekOf(time.Now().AddDate(0, 0, -7))
+    if reported := state.SLOReported(); len(reported) == 0 {
+      state.SetSLOReported(last) // nothing was counted for it
+    } else if reported != last {
+      if shards.leading() {
+        event := trackerEvent(eventSLOReport, formatSLOReport(last, state.DeliveryWeek(last)))
+        event.Targets = slo.Report
+        deliver([]*Event{event})
+      }
+      state.SetSLOReported(last)
+    }
+    time.Sleep(time.Hour)
+  }
+}
+
+func init() {
+  commands["slo"] = command{"slo [WEEK]", sloCommand}
+}
+
+//   GET /slo[?week=2026-W42]   the delivery counts for a week, this one by default
+func handleSLO(w http.ResponseWriter, r *http.Request) {
+  week := r.URL.Query().Get("week")
+  if len(week) == 0 {
+    week = weekOf(time.Now())
+  }
+  writeJSON(w, http.StatusOK, sloResponse{Week: week, Report: formatSLOReport(week, state.DeliveryWeek(week))})
+}
+
+type sloResponse struct {
+  Week   string `json:"week"`
+  Report string `json:"report"`
+}
+
+func sloCommand(args []string) {
+  path := "/slo"
+  if len(args) > 0 {
+    path += "?week=" + args[0]
+  }
+  var out sloResponse
+  if err := callAPI("GET", path, nil, &out); err != nil {
+    logger.Print("Error fetching the slo report: ", err)
+    os.Exit(1)
+  }
+  fmt.Println(out.Report)
+}
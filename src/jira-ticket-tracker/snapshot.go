@@ -0,0 +1,185 @@
+package main
+
+import (
+  "encoding/json"
+  "io/ioutil"
+  "os"
+  "time"
+)
+
+// the in-memory caches are snapshotted to a file on shutdown and loaded on
+// start, so a restart doesn't mean a burst of metadata calls and cold cache
+// latency. entries keep the time they were fetched, so they still expire
+// as they would have. jira's field and project metadata already survives
+// restarts in `cache.dir`
+//
+//   warm_start:
+//     path: ./tracker-snapshot.json
+//     max_age: 1h   # older snapshots aren't loaded
+type WarmStartConfig struct {
+  Path   string `yaml:"path"`
+  MaxAge string `yaml:"max_age"`
+}
+
+const snapshotVersion = 1
+
+type Snapshot struct {
+  Version     int                           `json:"version"`
+  Saved       time.Time                     `json:"saved"`
+  Conditional map[string]snapshotResponse   `json:"conditional"`  // by uri
+  Links       map[string]snapshotIssue      `json:"links"`        // by key
+  Expansions  map[string]snapshotExpansion  `json:"expansions"`   // groups and roles to users
+  Visibility  map[string]snapshotVisibility `json:"visibility"`
+  TraceOrder  []string                      `json:"trace_order"`
+  Traces      map[string][]EvaluationTrace  `json:"traces"` // the recent evaluations, see `why`
+}
+
+type snapshotResponse struct {
+  Etag         string `json:"etag,omitempty"`
+  LastModified string `json:"last_modified,omitempty"`
+  Contents     []byte `json:"contents"`
+}
+
+type snapshotIssue struct {
+  Fields  map[string]interface{} `json:"fields"`
+  Fetched time.Time              `json:"fetched"`
+}
+
+type snapshotExpansion struct {
+  Users   []string  `json:"users"`
+  Fetched time.Time `json:"fetched"`
+}
+
+type snapshotVisibility struct {
+  Visible bool      `json:"visible"`
+  Checked time.Time `json:"checked"`
+}
+
+func takeSnapshot() *Snapshot {
+  s := &Snapshot{
+    Version: snapshotVersion, Saved: time.Now(),
+    Conditional: map[string]snapshotResponse{}, Links: map[string]snapshotIssue{},
+    Expansions: map[string]snapshotExpansion{}, Visibility: map[string]snapshotVisibility{},
+    Traces: map[string][]EvaluationTrace{},
+  }
+  conditionalCache.Lock()
+  for uri, e := range conditionalCache.entries {
+    s.Conditional[uri] = snapshotResponse{e.etag, e.lastModified, e.contents}
+  }
+  conditionalCache.Unlock()
+  linkCache.Lock()
+  for key, c := range linkCache.issues {
+    s.Links[key] = snapshotIssue{c.fields, c.fetched}
+  }
+  linkCache.Unlock()
+  expansionCache.Lock()
+  for key, c := range expansionCache.users {
+    s.Expansions[key] = snapshotExpansion{c.users, c.fetched}
+  }
+  expansionCache.Unlock()
+  visibilityCache.Lock()
+  for key, c := range visibilityCache.checked {
+    s.Visibility[key] = snapshotVisibility{c.visible, c.checked}
+  }
+  visibilityCache.Unlock()
+  evaluationTraces.Lock()
+  s.TraceOrder = append([]string{}, evaluationTraces.order...)
+  for key, traces := range evaluationTraces.traces {
+    s.Traces[key] = traces
+  }
+  evaluationTraces.Unlock()
+  return s
+}
+
+func (s *Snapshot) restore() {
+  conditionalCache.Lock()
+  for uri, e := range s.Conditional {
+    if len(conditionalCache.entries) >= conditionalCacheSize {
+      break
+    }
+    conditionalCache.entries[uri] = &conditionalEntry{e.Etag, e.LastModified, e.Contents}
+  }
+  conditionalCache.Unlock()
+  linkCache.Lock()
+  for key, c := range s.Links {
+    if time.Since(c.Fetched) < linkCacheTTL {
+      linkCache.issues[key] = cachedIssue{c.Fields, c.Fetched}
+    }
+  }
+  linkCache.Unlock()
+  expansionCache.Lock()
+  for key, c := range s.Expansions {
+    if time.Since(c.Fetched) < expansionCacheTTL {
+      expansionCache.users[key] = cachedExpansion{c.Users, c.Fetched}
+    }
+  }
+  expansionCache.Unlock()
+  visibilityCache.Lock()
+  for key, c := range s.Visibility {
+    if time.Since(c.Checked) < visibilityCacheTTL {
+      visibilityCache.checked[key] = visibilityResult{c.Visible, c.Checked}
+    }
+  }
+  visibilityCache.Unlock()
+  evaluationTraces.Lock()
+  for _, key := range s.TraceOrder {
+    if traces, ok := s.Traces[key]; ok {
+      if _, seen := evaluationTraces.traces[key]; !seen {
+        evaluationTraces.order = append(evaluationTraces.order, key)
+      }
+      evaluationTraces.traces[key] = traces
+    }
+  }
+  if n := len(evaluationTraces.order) - tracedIssues; n > 0 {
+    for _, key := range evaluationTraces.order[:n] {
+      delete(evaluationTraces.traces, key)
+    }
+    evaluationTraces.order = evaluationTraces.order[n:]
+  }
+  evaluationTraces.Unlock()
+}
+
+func saveSnapshot(path string) error {
+  contents, err := json.Marshal(takeSnapshot())
+  if err != nil {
+    return err
+  }
+  tmp := path + ".tmp"
+  if err := ioutil.WriteFile(tmp, sealFile(contents), 0600); err != nil {
+    return err
+  }
+  return os.Rename(tmp, path)
+}
+
+// load the snapshot if there's a recent enough one. it's removed once it's
+// loaded, so a crash later doesn't bring back stale caches
+func loadSnapshot(creds *Config) {
+  c := creds.WarmStart
+  if len(c.Path) == 0 {
+    return
+  }
+  data, err := ioutil.ReadFile(c.Path)
+  if os.IsNotExist(err) {
+    return
+  }
+  defer os.Remove(c.Path)
+  var s Snapshot
+  if err == nil {
+    var contents []byte
+    if contents, err = unsealFile(data); err == nil {
+      err = json.Unmarshal(contents, &s)
+    }
+  }
+  switch {
+  case err != nil:
+    logger.Print("Error reading the snapshot, starting cold: ", err)
+  case s.Version != snapshotVersion:
+    logger.Print("Ignoring the snapshot from another version")
+  case time.Since(s.Saved) > durationOr(c.MaxAge, time.Hour):
+    logger.Print("Ignoring the snapshot from ", s.Saved.Format(time.RFC3339), ", it's too old")
+  default:
+    s.restore()
+    logger.Print("Warm started from the snapshot from ", s.Saved.Format(time.RFC3339), ": ",
+      len(s.Conditional), " responses, ", len(s.Links), " linked issues, ", len(s.Expansions), " expansions")
+  }
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "net/url"
+  "os"
+  "strings"
+  "time"
+)
+
+// indexes each event's payload in splunk through the http event collector.
+// with batch, the events from a poll go in one request. index is optional,
+// the token's default index otherwise, and can name it after the event, see
+// indexName
+//
+//   targets:
+//     splunk:
+//       type: splunk
+//       url: https://splunk.acme.com:8088
+//       token: 11111111-2222-3333-4444-555555555555   # the hec token
+//       index: jira
+//       batch: 10
+type splunkNotifier struct {
+  url      string
+  token    string
+  index    string
+  version  int
+  hostname string
+  client   *targetTransport
+}
+
+type splunkEvent struct {
+  Time       float64     `json:"time"`
+  Host       string      `json:"host,omitempty"`
+  Source     string      `json:"source"`
+  Sourcetype string      `json:"sourcetype"`
+  Index      string      `json:"index,omitempty"`
+  Event      interface{} `json:"event"`
+}
+
+func newSplunkNotifier(name string, target Target) (*splunkNotifier, error) {
+  u, err := url.Parse(target.Url)
+  if err != nil || len(u.Host) == 0 || len(target.Token) == 0 {
+    return nil, fmt.Errorf("a splunk target needs a url and a token")
+  }
+  if len(strings.Trim(u.Path, "/")) == 0 {
+    u.Path = "/services/collector/event"
+  }
+  hostname, _ := os.Hostname()
+  return &splunkNotifier{
+    url: u.String(), token: target.Token, index: target.Index, hostname: hostname,
+    version: targetEventVersion(name, target), client: newTargetTransport(name, target),
+  }, nil
+}
+
+func (n *splunkNotifier) Notify(event *Event, message string) error {
+  return n.NotifyBatch([]*Event{event}, []string{message})
+}
+
+func (n *splunkNotifier) NotifyBatch(events []*Event, messages []string) error {
+  body, err := n.body(events, messages)
+  if err != nil {
+    return err
+  }
+  headers := map[string]string{"Authorization": "Splunk " + n.token}
+  return postContents(n.client.httpClient(), n.url, "application/json", headers, body)
+}
+
+// the collector takes the events one after another in a single body
+func (n *splunkNotifier) body(events []*Event, messages []string) ([]byte, error) {
+  var body bytes.Buffer
+  now := time.Now()
+  for i, event := range events {
+    payload := eventPayload(event, messages[i], n.version)
+    if err := checkPayload(payload, n.version); err != nil {
+      return nil, err
+    }
+    contents, err := json.Marshal(splunkEvent{
+      Time: float64(now.UnixNano()/int64(time.Millisecond)) / 1000, Host: n.hostname,
+      Source: "jira-ticket-tracker", Sourcetype: "_json", Index: indexName(n.index, event, now), Event: payload,
+    })
+    if err != nil {
+      return nil, err
+    }
+    body.Write(contents)
+    body.WriteString("\n")
+  }
+  return body.Bytes(), nil
+}
@@ -0,0 +1,1193 @@
+package main
+
+import (
+  "encoding/json"
+  "io/ioutil"
+  "os"
+  "sort"
+  "sync"
+  "time"
+)
+
+// the tracker's state, loaded at startup in main
+var state *State
+
+// State is everything the tracker needs to remember across restarts. it is
+// kept in a json file which is rewritten on every change, except for the
+// delivery counts, see RecordDelivery
+type State struct {
+  path   string
+  mu     sync.Mutex
+  frozen bool // not saved while another process takes it over, see upgrade.go
+  dirty  bool // changed since it was last saved
+
+  // issue key -> the notifier targets subscribed to it
+  Subscriptions map[string][]string `json:"subscriptions"`
+  // issue key -> what we know about the issues being followed
+  Issues map[string]*TrackedIssue `json:"issues"`
+  // rule name -> the end of the last window searched
+  Checkpoints map[string]time.Time `json:"checkpoints"`
+  // issue key -> the operators' notes and tags, kept until removed
+  Annotations map[string]*Annotation `json:"annotations"`
+  // receipt id -> messages waiting to be seen
+  Receipts map[string]*Receipt `json:"receipts"`
+  // run id -> rules' chains waiting on a delay or retry, see chain.go
+  Chains map[string]*ChainRun `json:"chains,omitempty"`
+  // change id -> a planned target's change waiting to be applied, see plan.go
+  Plan map[string]*PlannedChange `json:"plan,omitempty"`
+  // request id -> a change waiting to be approved in chat, see approvals.go
+  Approvals map[string]*ApprovalRequest `json:"approvals,omitempty"`
+  // week -> target -> deliveries, for the slo report, and the last week
+  // reported on
+  Deliveries map[string]map[string]*DeliveryCounts `json:"deliveries"`
+  SLOReport  string                                `json:"slo_reported,omitempty"`
+  // alertmanager fingerprint -> the open issue for the alert
+  Alerts map[string]string `json:"alerts"`
+  // week -> rule -> polls, for the operator digest, and the last week
+  // reported on
+  Reliability  map[string]map[string]*RuleReliability `json:"reliability"`
+  DigestReport string                                 `json:"digest_reported,omitempty"`
+  // the last week the team report was sent for, see teamreport.go
+  TeamReport string `json:"team_reported,omitempty"`
+  // when the weekly open issue reports were last sent, see mytickets.go
+  MyTicketsReport time.Time `json:"my_tickets_sent"`
+  // issue key -> target -> the first slack message about the issue, for
+  // threading updates under and striking through once resolved
+  Threads map[string]map[string]*SlackThread `json:"threads"`
+  // mobile target -> device id -> the phones registered for its pushes
+  Devices map[string]map[string]*MobileDevice `json:"devices,omitempty"`
+  // user -> their do not disturb, see dnd.go
+  DND map[string]*DNDPreferences `json:"dnd,omitempty"`
+  // issue key -> when notifications about it start again
+  Snoozed map[string]time.Time `json:"snoozed"`
+  // kind -> key -> when it goes off, for the other timers of the storage
+  Timers map[string]map[string]time.Time `json:"timers,omitempty"`
+  // set -> key -> when it's forgotten, for the storage's seen sets
+  Seen map[string]map[string]time.Time `json:"seen,omitempty"`
+  // when quiet polls were last written, see RecordReliability
+  reliabilitySaved time.Time
+}
+
+type TrackedIssue struct {
+  Rule     string         `json:"rule,omitempty"` // the rule that matched it, if any
+  Snapshot *issueSnapshot `json:"snapshot,omitempty"`
+
+  // the reminder offsets already sent and the due date they were sent for
+  RemindersSent []string `json:"reminders_sent,omitempty"`
+  RemindersDue  string   `json:"reminders_due,omitempty"`
+
+  // when the team first responded publicly, and the first response
+  // warnings already sent
+  FirstResponse       string   `json:"first_response,omitempty"`
+  FirstResponseWarned []string `json:"first_response_warned,omitempty"`
+
+  // when the issue was first seen waiting for the reporter, and how far
+  // chasing them has got (warned or expired)
+  NeedsInfoSince string `json:"needs_info_since,omitempty"`
+  NeedsInfoStage string `json:"needs_info_stage,omitempty"`
+
+  // the error tracker's latest on the error behind the issue
+  Errors *ErrorStats `json:"errors,omitempty"`
+}
+
+// how often changes that don't save the state right away are saved
+const stateFlushInterval = 10 * time.Second
+
+func loadState(path string) *State {
+  s := &State{path: path}
+  s.init()
+  go s.flushForever()
+
+  contents, err := ioutil.ReadFile(path)
+  if os.IsNotExist(err) {
+    return s // nothing saved yet
+  } else if err != nil {
+    logger.Print("Error reading state file: ", err)
+    os.Exit(1) // don't risk overwriting state we couldn't read
+  }
+
+  if contents, err = unsealFile(contents); err != nil {
+    logger.Print("Error reading state file: ", err)
+    os.Exit(1)
+  }
+  if err := json.Unmarshal(contents, s); err != nil {
+    logger.Print("Error parsing state file: ", err)
+    os.Exit(1)
+  }
+  s.init()
+  return s
+}
+
+func (s *State) init() {
+  if s.Subscriptions == nil {
+    s.Subscriptions = map[string][]string{}
+  }
+  if s.Issues == nil {
+    s.Issues = map[string]*TrackedIssue{}
+  }
+  if s.Checkpoints == nil {
+    s.Checkpoints = map[string]time.Time{}
+  }
+  if s.Annotations == nil {
+    s.Annotations = map[string]*Annotation{}
+  }
+  if s.Receipts == nil {
+    s.Receipts = map[string]*Receipt{}
+  }
+  if s.Chains == nil {
+    s.Chains = map[string]*ChainRun{}
+  }
+  if s.Plan == nil {
+    s.Plan = map[string]*PlannedChange{}
+  }
+  if s.Approvals == nil {
+    s.Approvals = map[string]*ApprovalRequest{}
+  }
+  if s.Deliveries == nil {
+    s.Deliveries = map[string]map[string]*DeliveryCounts{}
+  }
+  if s.Alerts == nil {
+    s.Alerts = map[string]string{}
+  }
+  if s.Threads == nil {
+    s.Threads = map[string]map[string]*SlackThread{}
+  }
+  if s.Devices == nil {
+    s.Devices = map[string]map[string]*MobileDevice{}
+  }
+  if s.DND == nil {
+    s.DND = map[string]*DNDPreferences{}
+  }
+  if s.Snoozed == nil {
+    s.Snoozed = map[string]time.Time{}
+  }
+  if s.Timers == nil {
+    s.Timers = map[string]map[string]time.Time{}
+  }
+  if s.Seen == nil {
+    s.Seen = map[string]map[string]time.Time{}
+  }
+  if s.Reliability == nil {
+    s.Reliability = map[string]map[string]*RuleReliability{}
+  }
+}
+
+// save must be called with the lock held
+func (s *State) save() {
+  if s.frozen {
+    return
+  }
+  s.dirty = false
+  contents, err := json.MarshalIndent(s, "", "  ")
+  if err != nil {
+    logger.Print("Error encoding state: ", err)
+    return
+  }
+
+  // write to a temp file and rename so a crash can't leave half a file
+  tmp := s.path + ".tmp"
+  if err := ioutil.WriteFile(tmp, sealFile(contents), 0600); err != nil {
+    logger.Print("Error writing state file: ", err)
+    return
+  }
+  if err := os.Rename(tmp, s.path); err != nil {
+    logger.Print("Error writing state file: ", err)
+  }
+}
+
+// save what changed since the last save, if anything did
+func (s *State) Flush() {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  if s.dirty {
+    s.save()
+  }
+}
+
+func (s *State) flushForever() {
+  for range time.Tick(stateFlushInterval) {
+    s.Flush()
+  }
+}
+
+// save the state one last time and stop saving it, so a new process can
+// load it
+func (s *State) Freeze() {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  s.save()
+  s.frozen = true
+}
+
+// save it again, with what changed while it was frozen
+func (s *State) Thaw() {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  s.frozen = false
+  s.save()
+}
+
+// Subscribe returns false if the target was already subscribed to the issue
+func (s *State) Subscribe(key, target string) bool {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  for _, t := range s.Subscriptions[key] {
+    if t == target {
+      return false
+    }
+  }
+  s.Subscriptions[key] = append(s.Subscriptions[key], target)
+  s.save()
+  return true
+}
+
+// Unsubscribe returns false if the target wasn't subscribed to the issue
+func (s *State) Unsubscribe(key, target string) bool {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  targets := s.Subscriptions[key]
+  for i, t := range targets {
+    if t == target {
+      targets = append(targets[:i], targets[i+1:]...)
+      if len(targets) == 0 {
+        delete(s.Subscriptions, key)
+      } else {
+        s.Subscriptions[key] = targets
+      }
+      s.save()
+      return true
+    }
+  }
+  return false
+}
+
+func (s *State) Subscribers(key string) []string {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  return append([]string{}, s.Subscriptions[key]...)
+}
+
+func (s *State) SubscribedKeys() []string {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  keys := make([]string, 0, len(s.Subscriptions))
+  for key := range s.Subscriptions {
+    keys = append(keys, key)
+  }
+  sort.Strings(keys)
+  return keys
+}
+
+func (s *State) AllSubscriptions() map[string][]string {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  subs := map[string][]string{}
+  for key, targets := range s.Subscriptions {
+    subs[key] = append([]string{}, targets...)
+  }
+  return subs
+}
+
+// Track starts following an issue a rule matched
+func (s *State) Track(key, rule string, snapshot issueSnapshot) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  s.Issues[key] = &TrackedIssue{Rule: rule, Snapshot: &snapshot}
+  s.save()
+}
+
+// Untrack stops following an issue on behalf of its rule, the snapshot is
+// kept if the issue is still watched some other way
+func (s *State) Untrack(key string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if tracked, ok := s.Issues[key]; ok && len(tracked.Rule) > 0 {
+    tracked.Rule = ""
+    s.save()
+  }
+}
+
+// the issues rules are following and the rule for each
+func (s *State) TrackedRules() map[string]string {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  rules := map[string]string{}
+  for key, tracked := range s.Issues {
+    if len(tracked.Rule) > 0 {
+      rules[key] = tracked.Rule
+    }
+  }
+  return rules
+}
+
+func (s *State) Snapshot(key string) (issueSnapshot, bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if tracked, ok := s.Issues[key]; ok && tracked.Snapshot != nil {
+    return *tracked.Snapshot, true
+  }
+  return issueSnapshot{}, false
+}
+
+func (s *State) SetSnapshot(key string, snapshot issueSnapshot) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  tracked, ok := s.Issues[key]
+  if !ok {
+    tracked = &TrackedIssue{}
+    s.Issues[key] = tracked
+  } else if tracked.Snapshot != nil && *tracked.Snapshot == snapshot {
+    return // nothing changed, don't rewrite the file
+  }
+  tracked.Snapshot = &snapshot
+  s.save()
+}
+
+// ForgetUnless drops every issue that isn't in keys or followed by a rule
+func (s *State) ForgetUnless(keys map[string]bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  changed := false
+  for key, tracked := range s.Issues {
+    if !keys[key] && len(tracked.Rule) == 0 {
+      delete(s.Issues, key)
+      changed = true
+    }
+  }
+  if changed {
+    s.save()
+  }
+}
+
+// a copy of every followed issue
+func (s *State) TrackedIssues() map[string]TrackedIssue {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  issues := map[string]TrackedIssue{}
+  for key, tracked := range s.Issues {
+    copied := *tracked
+    if tracked.Snapshot != nil {
+      snapshot := *tracked.Snapshot
+      copied.Snapshot = &snapshot
+    }
+    copied.RemindersSent = append([]string{}, tracked.RemindersSent...)
+    copied.FirstResponseWarned = append([]string{}, tracked.FirstResponseWarned...)
+    issues[key] = copied
+  }
+  return issues
+}
+
+// MarkReminder records that a reminder offset fired for an issue's due date
+func (s *State) MarkReminder(key, due, offset string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  tracked, ok := s.Issues[key]
+  if !ok {
+    return
+  }
+  if tracked.RemindersDue != due {
+    tracked.RemindersDue = due
+    tracked.RemindersSent = nil
+  }
+  tracked.RemindersSent = append(tracked.RemindersSent, offset)
+  s.save()
+}
+
+func (s *State) SetFirstResponse(key, at string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if tracked, ok := s.Issues[key]; ok {
+    tracked.FirstResponse = at
+    s.save()
+  }
+}
+
+func (s *State) MarkFirstResponseWarning(key, kind string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if tracked, ok := s.Issues[key]; ok {
+    tracked.FirstResponseWarned = append(tracked.FirstResponseWarned, kind)
+    s.save()
+  }
+}
+
+func (s *State) Checkpoint(rule string) (time.Time, bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  t, ok := s.Checkpoints[rule]
+  return t, ok
+}
+
+func (s *State) SetCheckpoint(rule string, t time.Time) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  s.Checkpoints[rule] = t
+  s.save()
+}
+
+// a copy of an issue's annotation, nil if it has none
+func (s *State) Annotation(key string) *Annotation {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  a, ok := s.Annotations[key]
+  if !ok {
+    return nil
+  }
+  return a.copy()
+}
+
+func (s *State) AllAnnotations() map[string]*Annotation {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  annotations := map[string]*Annotation{}
+  for key, a := range s.Annotations {
+    annotations[key] = a.copy()
+  }
+  return annotations
+}
+
+// Annotate adds a note and/or tags to an issue and returns the result
+func (s *State) Annotate(key string, note *Note, tags []string) *Annotation {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  a, ok := s.Annotations[key]
+  if !ok {
+    a = &Annotation{}
+    s.Annotations[key] = a
+  }
+  if note != nil {
+    a.Notes = append(a.Notes, *note)
+  }
+  for _, tag := range tags {
+    if !contains(a.Tags, tag) {
+      a.Tags = append(a.Tags, tag)
+    }
+  }
+  sort.Strings(a.Tags)
+  s.save()
+  return a.copy()
+}
+
+// RewriteNotes passes every note to fn, which returns the note to keep in
+// its place, or nil to drop it, and whether it changed. it returns how many
+// changed
+func (s *State) RewriteNotes(fn func(note Note) (*Note, bool)) int {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  changed := 0
+  for key, a := range s.Annotations {
+    kept := []Note{}
+    for _, note := range a.Notes {
+      rewritten, ok := fn(note)
+      if ok {
+        changed++
+      }
+      if rewritten != nil {
+        kept = append(kept, *rewritten)
+      }
+    }
+    a.Notes = kept
+    if len(a.Tags) == 0 && len(a.Notes) == 0 {
+      delete(s.Annotations, key)
+    }
+  }
+  if changed > 0 {
+    s.save()
+  }
+  return changed
+}
+
+// Unannotate removes tags from an issue, or everything if no tags are given.
+// it returns false if the issue had no annotation
+func (s *State) Unannotate(key string, tags []string) bool {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  a, ok := s.Annotations[key]
+  if !ok {
+    return false
+  }
+  if len(tags) == 0 {
+    delete(s.Annotations, key)
+  } else {
+    kept := []string{}
+    for _, tag := range a.Tags {
+      if !contains(tags, tag) {
+        kept = append(kept, tag)
+      }
+    }
+    a.Tags = kept
+    if len(a.Tags) == 0 && len(a.Notes) == 0 {
+      delete(s.Annotations, key)
+    }
+  }
+  s.save()
+  return true
+}
+
+// SetNeedsInfo records when an issue started waiting for its reporter, or
+// clears it with an empty since. either way the stage is reset
+func (s *State) SetNeedsInfo(key, since string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if tracked, ok := s.Issues[key]; ok {
+    tracked.NeedsInfoSince = since
+    tracked.NeedsInfoStage = ""
+    s.save()
+  }
+}
+
+func (s *State) SetNeedsInfoStage(key, stage string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if tracked, ok := s.Issues[key]; ok {
+    tracked.NeedsInfoStage = stage
+    s.save()
+  }
+}
+
+func (s *State) AddReceipt(r *Receipt) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  copied := *r
+  s.Receipts[r.Id] = &copied
+  s.save()
+}
+
+// copies of every receipt
+func (s *State) AllReceipts() []Receipt {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  receipts := make([]Receipt, 0, len(s.Receipts))
+  for _, r := range s.Receipts {
+    receipts = append(receipts, *r)
+  }
+  sort.Slice(receipts, func(i, j int) bool { return receipts[i].Sent.Before(receipts[j].Sent) })
+  return receipts
+}
+
+func (s *State) MarkReceiptSeen(id string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if r, ok := s.Receipts[id]; ok && r.Seen == nil {
+    now := time.Now()
+    r.Seen = &now
+    s.save()
+  }
+}
+
+func (s *State) MarkReceiptEscalated(id string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if r, ok := s.Receipts[id]; ok {
+    r.Escalated = true
+    s.save()
+  }
+}
+
+func (s *State) ForgetReceipt(id string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if _, ok := s.Receipts[id]; ok {
+    delete(s.Receipts, id)
+    s.save()
+  }
+}
+
+func (s *State) SetChainRun(r *ChainRun) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  copied := r.copied()
+  s.Chains[r.Id] = &copied
+  s.save()
+}
+
+func (s *State) ChainRun(id string) (ChainRun, bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  r, ok := s.Chains[id]
+  if !ok {
+    return ChainRun{}, false
+  }
+  return r.copied(), true
+}
+
+// copies of the waiting chains, oldest first
+func (s *State) ChainRuns() []ChainRun {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  runs := make([]ChainRun, 0, len(s.Chains))
+  for _, r := range s.Chains {
+    runs = append(runs, r.copied())
+  }
+  sort.Slice(runs, func(i, j int) bool { return runs[i].Started.Before(runs[j].Started) })
+  return runs
+}
+
+func (s *State) ForgetChainRun(id string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if _, ok := s.Chains[id]; ok {
+    delete(s.Chains, id)
+    s.save()
+  }
+}
+
+// drop the chain runs, planned changes and approval requests whose event
+// or change mentions someone, and the do not disturb of the users that are
+// them, returning how many were dropped
+func (s *State) ForgetMentions(mentions func(record HistoryRecord, change string) bool, isUser func(user string) bool) int {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  forgot := 0
+  for id, run := range s.Chains {
+    if mentions(run.Event, "") {
+      delete(s.Chains, id)
+      forgot++
+    }
+  }
+  for id, c := range s.Plan {
+    if mentions(c.Event, c.Change) {
+      delete(s.Plan, id)
+      forgot++
+    }
+  }
+  for id, r := range s.Approvals {
+    if mentions(r.Event, r.Change) {
+      delete(s.Approvals, id)
+      forgot++
+    }
+  }
+  for user := range s.DND {
+    if isUser(user) {
+      delete(s.DND, user)
+      forgot++
+    }
+  }
+  if forgot > 0 {
+    s.save()
+  }
+  return forgot
+}
+
+func (s *State) AddPlannedChange(c *PlannedChange) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  copied := *c
+  s.Plan[c.Id] = &copied
+  s.save()
+}
+
+// copies of the planned changes, oldest first
+func (s *State) PlannedChanges() []PlannedChange {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  changes := make([]PlannedChange, 0, len(s.Plan))
+  for _, c := range s.Plan {
+    changes = append(changes, *c)
+  }
+  sort.Slice(changes, func(i, j int) bool { return changes[i].Planned.Before(changes[j].Planned) })
+  return changes
+}
+
+// TakePlannedChange removes a planned change to apply or discard it, so
+// only one of them gets it
+func (s *State) TakePlannedChange(id string) (PlannedChange, bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  c, ok := s.Plan[id]
+  if !ok {
+    return PlannedChange{}, false
+  }
+  delete(s.Plan, id)
+  s.save()
+  return *c, true
+}
+
+func (s *State) AddApproval(r *ApprovalRequest) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  copied := *r
+  s.Approvals[r.Id] = &copied
+  s.save()
+}
+
+// copies of the approval requests, oldest first
+func (s *State) PendingApprovals() []ApprovalRequest {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  requests := make([]ApprovalRequest, 0, len(s.Approvals))
+  for _, r := range s.Approvals {
+    requests = append(requests, *r)
+  }
+  sort.Slice(requests, func(i, j int) bool { return requests[i].Requested.Before(requests[j].Requested) })
+  return requests
+}
+
+// remove an approval request, returning it if it was still pending, so
+// two clicks can't both act on it
+func (s *State) TakeApproval(id string) (ApprovalRequest, bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  r, ok := s.Approvals[id]
+  if !ok {
+    return ApprovalRequest{}, false
+  }
+  delete(s.Approvals, id)
+  s.save()
+  return *r, true
+}
+
+// RecordDelivery counts a delivery to a target in a week, dropping the
+// counts of weeks too old to report on. it's called for every delivery, so
+// the counts are saved with the next change or within stateFlushInterval
+// rather than rewriting the file each time
+func (s *State) RecordDelivery(week, target string, delivered, timed, withinSLO bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  targets, ok := s.Deliveries[week]
+  if !ok {
+    targets = map[string]*DeliveryCounts{}
+    s.Deliveries[week] = targets
+    weeks := []string{}
+    for w := range s.Deliveries {
+      weeks = append(weeks, w)
+    }
+    sort.Strings(weeks)
+    for len(weeks) > sloWeeks {
+      delete(s.Deliveries, weeks[0])
+      weeks = weeks[1:]
+    }
+  }
+  counts, ok := targets[target]
+  if !ok {
+    counts = &DeliveryCounts{}
+    targets[target] = counts
+  }
+  if !delivered {
+    counts.Failed++
+  } else {
+    counts.Delivered++
+    if timed {
+      counts.Timed++
+      if withinSLO {
+        counts.WithinSLO++
+      }
+    }
+  }
+  s.dirty = true
+}
+
+func (s *State) DeliveryWeek(week string) map[string]DeliveryCounts {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  counts := map[string]DeliveryCounts{}
+  for target, c := range s.Deliveries[week] {
+    counts[target] = *c
+  }
+  return counts
+}
+
+func (s *State) SLOReported() string {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  return s.SLOReport
+}
+
+func (s *State) SetSLOReported(week string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  s.SLOReport = week
+  s.save()
+}
+
+func (s *State) ErrorStats(key string) *ErrorStats {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if tracked, ok := s.Issues[key]; ok && tracked.Errors != nil {
+    stats := *tracked.Errors
+    return &stats
+  }
+  return nil
+}
+
+func (s *State) SetErrorStats(key string, stats *ErrorStats) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  tracked, ok := s.Issues[key]
+  if !ok {
+    tracked = &TrackedIssue{}
+    s.Issues[key] = tracked
+  }
+  copied := *stats
+  tracked.Errors = &copied
+  s.save()
+}
+
+func (s *State) AlertIssue(fingerprint string) string {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  return s.Alerts[fingerprint]
+}
+
+func (s *State) SetAlertIssue(fingerprint, key string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  s.Alerts[fingerprint] = key
+  s.save()
+}
+
+func (s *State) ForgetAlert(fingerprint string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if _, ok := s.Alerts[fingerprint]; ok {
+    delete(s.Alerts, fingerprint)
+    s.save()
+  }
+}
+
+// RecordReliability counts a poll. as rules poll every few seconds, a quiet
+// successful poll only rewrites the file every few minutes
+func (s *State) RecordReliability(week, rule string, matches int, failed bool, streak int, flapped bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  rules, ok := s.Reliability[week]
+  if !ok {
+    rules = map[string]*RuleReliability{}
+    s.Reliability[week] = rules
+    weeks := []string{}
+    for w := range s.Reliability {
+      weeks = append(weeks, w)
+    }
+    sort.Strings(weeks)
+    for len(weeks) > sloWeeks {
+      delete(s.Reliability, weeks[0])
+      weeks = weeks[1:]
+    }
+  }
+  r, ok := rules[rule]
+  if !ok {
+    r = &RuleReliability{}
+    rules[rule] = r
+  }
+  r.Polls++
+  r.Matches += matches
+  if failed {
+    r.Failures++
+    if streak > r.LongestStreak {
+      r.LongestStreak = streak
+    }
+  }
+  if flapped {
+    r.Flaps++
+  }
+  if failed || flapped || matches > 0 || time.Since(s.reliabilitySaved) > 5*time.Minute {
+    s.reliabilitySaved = time.Now()
+    s.save()
+  }
+}
+
+func (s *State) ReliabilityWeek(week string) map[string]RuleReliability {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  rules := map[string]RuleReliability{}
+  for rule, r := range s.Reliability[week] {
+    rules[rule] = *r
+  }
+  return rules
+}
+
+func (s *State) DigestReported() string {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  return s.DigestReport
+}
+
+func (s *State) SetDigestReported(week string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  s.DigestReport = week
+  s.save()
+}
+
+func (s *State) TeamReported() string {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  return s.TeamReport
+}
+
+func (s *State) SetTeamReported(week string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  s.TeamReport = week
+  s.save()
+}
+
+func (s *State) MyTicketsSent() time.Time {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  return s.MyTicketsReport
+}
+
+func (s *State) SetMyTicketsSent(t time.Time) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  s.MyTicketsReport = t
+  s.save()
+}
+
+// a user's do not disturb, nil if they haven't set one
+func (s *State) DNDFor(user string) *DNDPreferences {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  p, ok := s.DND[user]
+  if !ok {
+    return nil
+  }
+  if p.location == nil {
+    if err := p.parse(); err != nil {
+      logger.Print("Ignoring the do not disturb of ", user, ": ", err)
+      return nil
+    }
+  }
+  copied := *p
+  return &copied
+}
+
+func (s *State) SetDND(p *DNDPreferences) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  s.DND[p.User] = p
+  s.save()
+}
+
+// false if the user had none
+func (s *State) ClearDND(user string) bool {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if _, ok := s.DND[user]; !ok {
+    return false
+  }
+  delete(s.DND, user)
+  s.save()
+  return true
+}
+
+// everyone's do not disturb, by user
+func (s *State) AllDND() []DNDPreferences {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  all := []DNDPreferences{}
+  for _, p := range s.DND {
+    all = append(all, *p)
+  }
+  sort.Slice(all, func(i, j int) bool { return all[i].User < all[j].User })
+  return all
+}
+
+// register a device for a target's pushes, replacing it if it's already
+// registered
+func (s *State) RegisterDevice(device *MobileDevice) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if _, ok := s.Devices[device.Target]; !ok {
+    s.Devices[device.Target] = map[string]*MobileDevice{}
+  }
+  s.Devices[device.Target][device.Id] = device
+  s.save()
+}
+
+// false if the device wasn't registered
+func (s *State) UnregisterDevice(target, id string) bool {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if _, ok := s.Devices[target][id]; !ok {
+    return false
+  }
+  delete(s.Devices[target], id)
+  if len(s.Devices[target]) == 0 {
+    delete(s.Devices, target)
+  }
+  s.save()
+  return true
+}
+
+// the devices registered for a target
+func (s *State) TargetDevices(target string) []*MobileDevice {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  devices := []*MobileDevice{}
+  for _, d := range s.Devices[target] {
+    copied := *d
+    devices = append(devices, &copied)
+  }
+  return devices
+}
+
+// every registered device, by target and then id
+func (s *State) AllDevices() []MobileDevice {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  devices := []MobileDevice{}
+  for _, byId := range s.Devices {
+    for _, d := range byId {
+      devices = append(devices, *d)
+    }
+  }
+  sort.Slice(devices, func(i, j int) bool {
+    if devices[i].Target != devices[j].Target {
+      return devices[i].Target < devices[j].Target
+    }
+    return devices[i].Id < devices[j].Id
+  })
+  return devices
+}
+
+// the timers of a kind, snoozes being kept where they always were. must be
+// called with the lock held
+func (s *State) timers(kind string, create bool) map[string]time.Time {
+  if kind == "snooze" {
+    return s.Snoozed
+  }
+  if _, ok := s.Timers[kind]; !ok && create {
+    s.Timers[kind] = map[string]time.Time{}
+  }
+  return s.Timers[kind]
+}
+
+func (s *State) SetTimer(kind, key string, at time.Time) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  s.timers(kind, true)[key] = at
+  s.save()
+}
+
+func (s *State) Timer(kind, key string) (time.Time, bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  at, ok := s.timers(kind, false)[key]
+  return at, ok
+}
+
+func (s *State) DeleteTimer(kind, key string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if timers := s.timers(kind, false); timers != nil {
+    delete(timers, key)
+    if len(timers) == 0 && kind != "snooze" {
+      delete(s.Timers, kind)
+    }
+    s.save()
+  }
+}
+
+func (s *State) MarkSeen(set, key string, ttl time.Duration) bool {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  seen := markSeen(s.Seen, set, key, ttl)
+  if !seen {
+    s.save()
+  }
+  return seen
+}
+
+func (s *State) ForgetSeen(set, key string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if _, ok := s.Seen[set][key]; ok {
+    delete(s.Seen[set], key)
+    s.save()
+  }
+}
+
+func (s *State) SlackThread(key, target string) (SlackThread, bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if thread, ok := s.Threads[key][target]; ok {
+    return *thread, true
+  }
+  return SlackThread{}, false
+}
+
+// SetSlackThread also forgets threads older than slackThreadAge, nobody
+// wants replies to a message from months ago
+func (s *State) SetSlackThread(key, target string, message slackMessage, text string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  for k, targets := range s.Threads {
+    for t, thread := range targets {
+      if time.Since(thread.Posted) > slackThreadAge {
+        delete(targets, t)
+      }
+    }
+    if len(targets) == 0 {
+      delete(s.Threads, k)
+    }
+  }
+  if s.Threads[key] == nil {
+    s.Threads[key] = map[string]*SlackThread{}
+  }
+  s.Threads[key][target] = &SlackThread{Message: message, Text: text, Posted: time.Now()}
+  s.save()
+}
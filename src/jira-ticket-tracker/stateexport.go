@@ -0,0 +1,238 @@
+package main
+
+import (
+  "encoding/json"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "time"
+
+  "launchpad.net/goyaml"
+)
+
+// the version of the export format, bumped on incompatible changes
+const stateExportVersion = 1
+
+// a portable copy of the whole state, e.g. to move the tracker to another
+// host or state backend
+type stateExport struct {
+  Version  int       `json:"version"`
+  Exported time.Time `json:"exported"`
+  State    *State    `json:"state"`
+}
+
+func init() {
+  commands["export-state"] = command{"export-state [--out=PATH]", exportStateCommand}
+  commands["import-state"] = command{"import-state [--replace] PATH", importStateCommand}
+}
+
+// Merge adds another state to this one. subscriptions, tags and notes are
+// combined, checkpoints take the later of the two and anything else only
+// one side knows about is copied. where both know an issue the other's
+// wins, unless keep is set
+func (s *State) Merge(other *State, keep bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  for key, targets := range other.Subscriptions {
+    s.Subscriptions[key] = addTargets(s.Subscriptions[key], targets)
+  }
+  for key, tracked := range other.Issues {
+    if _, ok := s.Issues[key]; !ok || !keep {
+      s.Issues[key] = tracked
+    }
+  }
+  for rule, t := range other.Checkpoints {
+    if t.After(s.Checkpoints[rule]) {
+      s.Checkpoints[rule] = t
+    }
+  }
+  for key, a := range other.Annotations {
+    mine, ok := s.Annotations[key]
+    if !ok {
+      s.Annotations[key] = a
+      continue
+    }
+    mine.Notes = append(mine.Notes, a.Notes...)
+    mine.Tags = addTargets(mine.Tags, a.Tags)
+  }
+  for id, r := range other.Receipts {
+    if _, ok := s.Receipts[id]; !ok {
+      s.Receipts[id] = r
+    }
+  }
+  for id, r := range other.Chains {
+    if _, ok := s.Chains[id]; !ok {
+      s.Chains[id] = r
+    }
+  }
+  for id, c := range other.Plan {
+    if _, ok := s.Plan[id]; !ok {
+      s.Plan[id] = c
+    }
+  }
+  for id, r := range other.Approvals {
+    if _, ok := s.Approvals[id]; !ok {
+      s.Approvals[id] = r
+    }
+  }
+  for week, targets := range other.Deliveries {
+    if _, ok := s.Deliveries[week]; !ok || !keep {
+      s.Deliveries[week] = targets
+    }
+  }
+  if other.SLOReport > s.SLOReport {
+    s.SLOReport = other.SLOReport
+  }
+  for week, rules := range other.Reliability {
+    if _, ok := s.Reliability[week]; !ok || !keep {
+      s.Reliability[week] = rules
+    }
+  }
+  if other.DigestReport > s.DigestReport {
+    s.DigestReport = other.DigestReport
+  }
+  if other.TeamReport > s.TeamReport {
+    s.TeamReport = other.TeamReport
+  }
+  if other.MyTicketsReport.After(s.MyTicketsReport) {
+    s.MyTicketsReport = other.MyTicketsReport
+  }
+  for user, p := range other.DND {
+    if _, ok := s.DND[user]; !ok || !keep {
+      s.DND[user] = p
+    }
+  }
+  for target, devices := range other.Devices {
+    if _, ok := s.Devices[target]; !ok {
+      s.Devices[target] = map[string]*MobileDevice{}
+    }
+    for id, device := range devices {
+      if _, ok := s.Devices[target][id]; !ok || !keep {
+        s.Devices[target][id] = device
+      }
+    }
+  }
+  for key, targets := range other.Threads {
+    if _, ok := s.Threads[key]; !ok || !keep {
+      s.Threads[key] = targets
+    }
+  }
+  for key, until := range other.Snoozed {
+    if until.After(s.Snoozed[key]) {
+      s.Snoozed[key] = until
+    }
+  }
+  for kind, timers := range other.Timers {
+    for key, at := range timers {
+      if at.After(s.timers(kind, true)[key]) {
+        s.timers(kind, true)[key] = at
+      }
+    }
+  }
+  for set, keys := range other.Seen {
+    if _, ok := s.Seen[set]; !ok {
+      s.Seen[set] = map[string]time.Time{}
+    }
+    for key, expires := range keys {
+      if expires.After(s.Seen[set][key]) {
+        s.Seen[set][key] = expires
+      }
+    }
+  }
+  for fingerprint, key := range other.Alerts {
+    if _, ok := s.Alerts[fingerprint]; !ok || !keep {
+      s.Alerts[fingerprint] = key
+    }
+  }
+  s.save()
+}
+
+// only the state file is exported and imported, so with any other storage
+// the checkpoints, seen sets and timers it keeps would be left behind, or
+// imported where nothing reads them. without a config to read the storage
+// is the state file's
+func refuseOtherStorage(command string) {
+  var c struct {
+    Storage StorageConfig `yaml:"storage"`
+  }
+  if remoteConfigUrl(*config) {
+    c.Storage = getCreds(*config).Storage
+  } else if contents, err := ioutil.ReadFile(*config); err == nil {
+    goyaml.Unmarshal(contents, &c)
+  }
+  if len(c.Storage.Type) > 0 && c.Storage.Type != "file" {
+    logger.Print(command, " only moves the state file, but the ", c.Storage.Type,
+      " storage keeps the checkpoints, seen issues and timers. move those with the backend's own tools")
+    os.Exit(1)
+  }
+}
+
+// the state file of a stopped tracker (or a running one's, which is only
+// ever replaced whole) written out with a version. the export isn't
+// encrypted even if the state is
+func exportStateCommand(args []string) {
+  flags := flag.NewFlagSet("export-state", flag.ExitOnError)
+  out := flags.String("out", "", "Where to write the export (default stdout)")
+  flags.Parse(args)
+  refuseOtherStorage("export-state")
+
+  loadStateCipher()
+  s := loadState(*statePath)
+  s.mu.Lock()
+  contents, err := json.MarshalIndent(stateExport{stateExportVersion, time.Now(), s}, "", "  ")
+  s.mu.Unlock()
+  if err != nil {
+    logger.Print("Error encoding state: ", err)
+    os.Exit(1)
+  }
+  contents = append(contents, '\n')
+  if len(*out) == 0 {
+    os.Stdout.Write(contents)
+    return
+  }
+  if err := ioutil.WriteFile(*out, contents, 0600); err != nil {
+    logger.Print("Error writing export: ", err)
+    os.Exit(1)
+  }
+}
+
+// merge an export into the state file, or replace it. the tracker using the
+// state file should be stopped first or it will overwrite the import
+func importStateCommand(args []string) {
+  flags := flag.NewFlagSet("import-state", flag.ExitOnError)
+  replace := flags.Bool("replace", false, "Replace the state instead of merging into it")
+  keep := flags.Bool("keep", false, "When merging, keep the current state of issues both know")
+  flags.Parse(args)
+  if flags.NArg() != 1 {
+    usageExit(commands["import-state"].usage)
+  }
+  refuseOtherStorage("import-state")
+  loadStateCipher()
+
+  contents, err := ioutil.ReadFile(flags.Arg(0))
+  if err != nil {
+    logger.Print("Error reading export: ", err)
+    os.Exit(1)
+  }
+  imported := stateExport{State: &State{}}
+  if err := json.Unmarshal(contents, &imported); err != nil {
+    logger.Print("Error parsing export: ", err)
+    os.Exit(1)
+  }
+  if imported.Version != stateExportVersion {
+    logger.Print(fmt.Sprintf("Unsupported export version %d, expected %d", imported.Version, stateExportVersion))
+    os.Exit(1)
+  }
+  imported.State.init()
+
+  s := &State{path: *statePath}
+  s.init()
+  if !*replace {
+    s = loadState(*statePath)
+  }
+  s.Merge(imported.State, *keep)
+  logger.Print(fmt.Sprintf("Imported %d issues, %d subscriptions and %d checkpoints from %s",
+    len(imported.State.Issues), len(imported.State.Subscriptions), len(imported.State.Checkpoints), flags.Arg(0)))
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+  "errors"
+  "os"
+  "sync"
+  "time"
+)
+
+// Storage is where the tracker keeps the parts of its state that replicas
+// may need to share: the rules' checkpoints, sets of keys already seen, the
+// history of events and timers like snoozes. the rest stays in the state
+// file. configured under `storage`, the state file and history file by
+// default
+//
+//   storage:
+//     type: file      # memory, postgres, dynamodb, or a backend registered in storageBackends
+//
+// a backend has to pass the contract in storage_test.go
+type Storage interface {
+  Checkpoint(rule string) (time.Time, bool, error)
+  SetCheckpoint(rule string, t time.Time) error
+  // add a key to a set, reporting whether it was in it already. keys are
+  // forgotten ttl after they were added
+  MarkSeen(set, key string, ttl time.Duration) (bool, error)
+  // take a key out of a set before its ttl, e.g. when what it was added
+  // for didn't happen after all
+  ForgetSeen(set, key string) error
+  AppendHistory(records []HistoryRecord) error
+  // call fn with the records from since on, oldest first, stopping early if
+  // it returns false
+  EachHistory(since time.Time, fn func(HistoryRecord) bool) error
+  // call fn with every record, replacing the ones it returns true for with
+  // the record it returns, or deleting them if that's nil. returns how many
+  // it replaced or deleted
+  RewriteHistory(fn func(HistoryRecord) (*HistoryRecord, bool)) (int, error)
+  SetTimer(kind, key string, at time.Time) error
+  // when the timer goes off, false if there is none
+  Timer(kind, key string) (time.Time, bool, error)
+  DeleteTimer(kind, key string) error
+  Close() error
+}
+
+type StorageConfig struct {
+  Type     string         `yaml:"type"`
+  Postgres PostgresConfig `yaml:"postgres"`
+  DynamoDB DynamoDBConfig `yaml:"dynamodb"`
+}
+
+// returned by EachHistory when the backend keeps none
+var errNoHistory = errors.New("no history is configured")
+
+// the storage, opened at startup in setup
+var store Storage
+
+// the backends by type, each opened from the config
+var storageBackends = map[string]func(creds *Config) (Storage, error){
+  "file":   func(creds *Config) (Storage, error) { return &fileStorage{}, nil },
+  "memory": func(creds *Config) (Storage, error) { return newMemoryStorage(), nil },
+}
+
+func openStorage(creds *Config) Storage {
+  kind := creds.Storage.Type
+  if len(kind) == 0 {
+    kind = "file"
+  }
+  open, ok := storageBackends[kind]
+  if !ok {
+    logger.Print("Unknown storage type ", kind)
+    os.Exit(1)
+  }
+  s, err := open(creds)
+  if err != nil {
+    logger.Print("Error opening the ", kind, " storage: ", err)
+    os.Exit(1)
+  }
+  return s
+}
+
+func checkpoint(rule string) (time.Time, bool) {
+  t, ok, err := store.Checkpoint(rule)
+  if err != nil {
+    logger.Print("Error reading the checkpoint of rule ", rule, ": ", err)
+  }
+  return t, ok
+}
+
+func setCheckpoint(rule string, t time.Time) {
+  if err := store.SetCheckpoint(rule, t); err != nil {
+    logger.Print("Error saving the checkpoint of rule ", rule, ": ", err)
+  }
+}
+
+// how long the created events already sent are remembered for, so a rule
+// moving between replicas or a retried window doesn't notify twice
+const seenCreatedTTL = 7 * 24 * time.Hour
+
+func recordHistory(events []*Event) {
+  now := time.Now()
+  records := []HistoryRecord{}
+  for _, event := range events {
+    record, err := historyRecord(event, now)
+    if err != nil {
+      logger.Print("Error encoding history for ", event.Issue.Key, ": ", err)
+      continue
+    }
+    records = append(records, record)
+  }
+  if err := store.AppendHistory(records); err != nil {
+    logger.Print("Error writing history: ", err)
+  }
+}
+
+// the state file and the history file, as the tracker has always kept them
+type fileStorage struct{}
+
+func (f *fileStorage) Checkpoint(rule string) (time.Time, bool, error) {
+  t, ok := state.Checkpoint(rule)
+  return t, ok, nil
+}
+
+func (f *fileStorage) SetCheckpoint(rule string, t time.Time) error {
+  state.SetCheckpoint(rule, t)
+  return nil
+}
+
+func (f *fileStorage) MarkSeen(set, key string, ttl time.Duration) (bool, error) {
+  return state.MarkSeen(set, key, ttl), nil
+}
+
+func (f *fileStorage) ForgetSeen(set, key string) error {
+  state.ForgetSeen(set, key)
+  return nil
+}
+
+func (f *fileStorage) AppendHistory(records []HistoryRecord) error {
+  if history == nil {
+    return nil
+  }
+  return history.Append(records)
+}
+
+func (f *fileStorage) EachHistory(since time.Time, fn func(HistoryRecord) bool) error {
+  if history == nil {
+    return errNoHistory
+  }
+  return history.Each(func(r HistoryRecord) bool {
+    return r.Time.Before(since) || fn(r)
+  })
+}
+
+func (f *fileStorage) RewriteHistory(fn func(HistoryRecord) (*HistoryRecord, bool)) (int, error) {
+  if history == nil {
+    return 0, errNoHistory
+  }
+  return history.Rewrite(fn)
+}
+
+func (f *fileStorage) SetTimer(kind, key string, at time.Time) error {
+  state.SetTimer(kind, key, at)
+  return nil
+}
+
+func (f *fileStorage) Timer(kind, key string) (time.Time, bool, error) {
+  at, ok := state.Timer(kind, key)
+  return at, ok, nil
+}
+
+func (f *fileStorage) DeleteTimer(kind, key string) error {
+  state.DeleteTimer(kind, key)
+  return nil
+}
+
+func (f *fileStorage) Close() error { return nil }
+
+func snoozeIssue(key string, until time.Time) {
+  if err := store.SetTimer("snooze", key, until); err != nil {
+    logger.Print("Error snoozing ", key, ": ", err)
+  }
+}
+
+// whether notifications about the issue are snoozed, forgetting snoozes
+// that have run out
+func issueSnoozed(key string) bool {
+  until, ok, err := store.Timer("snooze", key)
+  if err != nil {
+    logger.Print("Error checking whether ", key, " is snoozed: ", err)
+    return false
+  }
+  if !ok {
+    return false
+  }
+  if time.Now().Before(until) {
+    return true
+  }
+  store.DeleteTimer("snooze", key)
+  return false
+}
+
+// memoryStorage is the reference backend, lost on restart
+type memoryStorage struct {
+  mu          sync.Mutex
+  checkpoints map[string]time.Time
+  seen        map[string]map[string]time.Time // set -> key -> when it's forgotten
+  history     []HistoryRecord
+  timers      map[string]map[string]time.Time
+}
+
+func newMemoryStorage() *memoryStorage {
+  return &memoryStorage{
+    checkpoints: map[string]time.Time{},
+    seen:        map[string]map[string]time.Time{},
+    timers:      map[string]map[string]time.Time{},
+  }
+}
+
+func (m *memoryStorage) Checkpoint(rule string) (time.Time, bool, error) {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+  t, ok := m.checkpoints[rule]
+  return t, ok, nil
+}
+
+func (m *memoryStorage) SetCheckpoint(rule string, t time.Time) error {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+  m.checkpoints[rule] = t
+  return nil
+}
+
+func (m *memoryStorage) MarkSeen(set, key string, ttl time.Duration) (bool, error) {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+  return markSeen(m.seen, set, key, ttl), nil
+}
+
+func (m *memoryStorage) ForgetSeen(set, key string) error {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+  delete(m.seen[set], key)
+  return nil
+}
+
+// add the key to the set, dropping the set's expired keys on the way
+func markSeen(sets map[string]map[string]time.Time, set, key string, ttl time.Duration) bool {
+  now := time.Now()
+  keys, ok := sets[set]
+  if !ok {
+    keys = map[string]time.Time{}
+    sets[set] = keys
+  }
+  for k, expires := range keys {
+    if !now.Before(expires) {
+      delete(keys, k)
+    }
+  }
+  _, seen := keys[key]
+  if !seen {
+    keys[key] = now.Add(ttl)
+  }
+  return seen
+}
+
+func (m *memoryStorage) AppendHistory(records []HistoryRecord) error {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+  m.history = append(m.history, records...)
+  return nil
+}
+
+func (m *memoryStorage) EachHistory(since time.Time, fn func(HistoryRecord) bool) error {
+  m.mu.Lock()
+  records := append([]HistoryRecord{}, m.history...)
+  m.mu.Unlock()
+  for _, r := range records {
+    if !r.Time.Before(since) && !fn(r) {
+      break
+    }
+  }
+  return nil
+}
+
+func (m *memoryStorage) RewriteHistory(fn func(HistoryRecord) (*HistoryRecord, bool)) (int, error) {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+  kept, changed := []HistoryRecord{}, 0
+  for _, r := range m.history {
+    replaced, ok := fn(r)
+    switch {
+    case !ok:
+      kept = append(kept, r)
+    case replaced != nil:
+      kept = append(kept, *replaced)
+      changed++
+    default:
+      changed++
+    }
+  }
+  m.history = kept
+  return changed, nil
+}
+
+func (m *memoryStorage) SetTimer(kind, key string, at time.Time) error {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+  if _, ok := m.timers[kind]; !ok {
+    m.timers[kind] = map[string]time.Time{}
+  }
+  m.timers[kind][key] = at
+  return nil
+}
+
+func (m *memoryStorage) Timer(kind, key string) (time.Time, bool, error) {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+  at, ok := m.timers[kind][key]
+  return at, ok, nil
+}
+
+func (m *memoryStorage) DeleteTimer(kind, key string) error {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+  delete(m.timers[kind], key)
+  return nil
+}
+
+func (m *memoryStorage) Close() error { return nil }
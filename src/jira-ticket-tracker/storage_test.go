@@ -0,0 +1,199 @@
+package main
+
+import (
+  "crypto/rand"
+  "encoding/hex"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+  "time"
+)
+
+// the contract every storage backend has to satisfy. memory and file are
+// always checked, postgres and dynamodb when there is one to check against:
+//
+//   JTT_TEST_POSTGRES_URL=postgres://localhost/tracker_test?sslmode=disable
+//   JTT_TEST_DYNAMODB_ENDPOINT=http://localhost:8000   # e.g. dynamodb local
+//
+// the checks work on keys no one else uses, with seen keys that expire
+// within seconds, but the checkpoint and one of the history records they
+// write stay behind
+func TestStorageContract(t *testing.T) {
+  t.Run("memory", func(t *testing.T) {
+    storageContract(t, newMemoryStorage())
+  })
+  t.Run("file", func(t *testing.T) {
+    dir := t.TempDir()
+    state = loadState(filepath.Join(dir, "state.json"))
+    history = &historyFile{path: filepath.Join(dir, "history.jsonl")}
+    defer func() { history = nil }()
+    storageContract(t, &fileStorage{})
+  })
+  t.Run("postgres", func(t *testing.T) {
+    url := os.Getenv("JTT_TEST_POSTGRES_URL")
+    if len(url) == 0 {
+      t.Skip("JTT_TEST_POSTGRES_URL isn't set")
+    }
+    creds := &Config{}
+    creds.Storage.Postgres.Url = url
+    s, err := openPostgresStorage(creds)
+    if err != nil {
+      t.Fatal(err)
+    }
+    defer s.Close()
+    storageContract(t, s)
+  })
+  t.Run("dynamodb", func(t *testing.T) {
+    endpoint := os.Getenv("JTT_TEST_DYNAMODB_ENDPOINT")
+    if len(endpoint) == 0 {
+      t.Skip("JTT_TEST_DYNAMODB_ENDPOINT isn't set")
+    }
+    creds := &Config{}
+    creds.Storage.DynamoDB = DynamoDBConfig{Table: "jtt-storage-test", Region: "us-east-1", Create: true, Endpoint: endpoint}
+    s, err := openDynamoDBStorage(creds)
+    if err != nil {
+      t.Fatal(err)
+    }
+    defer s.Close()
+    storageContract(t, s)
+  })
+}
+
+func storageContract(t *testing.T, s Storage) {
+  nonce := make([]byte, 6)
+  rand.Read(nonce)
+  prefix := "storage-test-" + hex.EncodeToString(nonce)
+  // backends may store times at a coarser resolution
+  now := time.Now().Truncate(time.Millisecond)
+  same := func(a, b time.Time) bool { return a.Sub(b) < time.Millisecond && b.Sub(a) < time.Millisecond }
+
+  t.Run("checkpoints", func(t *testing.T) {
+    if _, ok, err := s.Checkpoint(prefix); err != nil || ok {
+      t.Errorf("a checkpoint never set was found (err %v)", err)
+    }
+    for _, at := range []time.Time{now.Add(-time.Hour), now} {
+      if err := s.SetCheckpoint(prefix, at); err != nil {
+        t.Errorf("setting a checkpoint: %v", err)
+      }
+      if got, ok, err := s.Checkpoint(prefix); err != nil || !ok || !same(got, at) {
+        t.Errorf("a checkpoint set to %s came back as %s (found %v, err %v)", at, got, ok, err)
+      }
+    }
+  })
+
+  t.Run("seen", func(t *testing.T) {
+    set := prefix + "-set"
+    if seen, err := s.MarkSeen(set, "a", 5*time.Second); err != nil || seen {
+      t.Errorf("a new key was already seen (err %v)", err)
+    }
+    if seen, err := s.MarkSeen(set, "a", 5*time.Second); err != nil || !seen {
+      t.Errorf("a key just added wasn't seen (err %v)", err)
+    }
+    if seen, err := s.MarkSeen(set+"-other", "a", 5*time.Second); err != nil || seen {
+      t.Errorf("a key was seen in a set it was never added to (err %v)", err)
+    }
+    if err := s.ForgetSeen(set, "a"); err != nil {
+      t.Errorf("forgetting a key: %v", err)
+    }
+    if seen, err := s.MarkSeen(set, "a", 5*time.Second); err != nil || seen {
+      t.Errorf("a forgotten key was still seen (err %v)", err)
+    }
+    if err := s.ForgetSeen(set, "never-added"); err != nil {
+      t.Errorf("forgetting a key never added: %v", err)
+    }
+    if _, err := s.MarkSeen(set, "short", time.Second); err != nil {
+      t.Errorf("adding a key: %v", err)
+    }
+    time.Sleep(1100 * time.Millisecond)
+    if seen, err := s.MarkSeen(set, "short", time.Second); err != nil || seen {
+      t.Errorf("a key was still seen after its ttl (err %v)", err)
+    }
+  })
+
+  t.Run("history", func(t *testing.T) {
+    records := []HistoryRecord{
+      {Time: now, Kind: "storage-test", Key: prefix + "-1", Targets: []string{}, Issue: []byte(`{"key":"` + prefix + `-1","fields":{}}`)},
+      {Time: now.Add(time.Millisecond), Kind: "storage-test", Key: prefix + "-2", Targets: []string{"t"}, Issue: []byte(`{"key":"` + prefix + `-2","fields":{}}`)},
+    }
+    if err := s.AppendHistory(records); err != nil {
+      t.Fatalf("appending history: %v", err)
+    }
+    found := []string{}
+    err := s.EachHistory(now.Add(-time.Second), func(r HistoryRecord) bool {
+      if strings.HasPrefix(r.Key, prefix) {
+        found = append(found, r.Key)
+      }
+      return true
+    })
+    if err == errNoHistory {
+      t.Skip("the backend keeps no history")
+    }
+    if err != nil || strings.Join(found, ",") != prefix+"-1,"+prefix+"-2" {
+      t.Errorf("the history appended came back as %v (err %v)", found, err)
+    }
+    stopped := 0
+    s.EachHistory(now.Add(-time.Second), func(r HistoryRecord) bool {
+      stopped++
+      return false
+    })
+    if stopped != 1 {
+      t.Errorf("the history went on after fn returned false")
+    }
+    later := 0
+    s.EachHistory(now.Add(time.Hour), func(r HistoryRecord) bool {
+      if strings.HasPrefix(r.Key, prefix) {
+        later++
+      }
+      return true
+    })
+    if later != 0 {
+      t.Errorf("the history had records from before since")
+    }
+    rewritten, err := s.RewriteHistory(func(r HistoryRecord) (*HistoryRecord, bool) {
+      switch r.Key {
+      case prefix + "-1":
+        r.Detail = "rewritten"
+        return &r, true
+      case prefix + "-2":
+        return nil, true
+      }
+      return nil, false
+    })
+    if err != nil || rewritten != 2 {
+      t.Errorf("rewriting two records reported %d (err %v)", rewritten, err)
+    }
+    details := []string{}
+    s.EachHistory(now.Add(-time.Second), func(r HistoryRecord) bool {
+      if strings.HasPrefix(r.Key, prefix) {
+        details = append(details, r.Key+"="+r.Detail)
+      }
+      return true
+    })
+    if strings.Join(details, ",") != prefix+"-1=rewritten" {
+      t.Errorf("the history rewritten came back as %v", details)
+    }
+  })
+
+  t.Run("timers", func(t *testing.T) {
+    if _, ok, err := s.Timer(prefix, "a"); err != nil || ok {
+      t.Errorf("a timer never set was found (err %v)", err)
+    }
+    at := now.Add(time.Hour)
+    if err := s.SetTimer(prefix, "a", at); err != nil {
+      t.Errorf("setting a timer: %v", err)
+    }
+    if got, ok, err := s.Timer(prefix, "a"); err != nil || !ok || !same(got, at) {
+      t.Errorf("a timer set for %s came back as %s (found %v, err %v)", at, got, ok, err)
+    }
+    if _, ok, _ := s.Timer(prefix+"-other", "a"); ok {
+      t.Errorf("a timer was found under another kind")
+    }
+    if err := s.DeleteTimer(prefix, "a"); err != nil {
+      t.Errorf("deleting a timer: %v", err)
+    }
+    if _, ok, err := s.Timer(prefix, "a"); err != nil || ok {
+      t.Errorf("a deleted timer was found (err %v)", err)
+    }
+  })
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/url"
+)
+
+// streamSearch runs a search and hands each issue to fn as it is decoded,
+// so a page of hundreds of issues never has to be held in memory at once.
+// it returns the total number of matches and how many were on this page
+func streamSearch(jql string, startAt, maxResults int, creds *Config, fn func(issue []byte)) (total, count int, err error) {
+  uri := fmt.Sprintf("/search?jql=%s&startAt=%d&maxResults=%d", url.QueryEscape(jql), startAt, maxResults)
+  resp, err := jiraDo("GET", uri, nil, creds)
+  if err != nil {
+    return 0, 0, err
+  }
+  // the decoder stops at the closing brace, drain the rest so the
+  // connection can be reused
+  defer drainAndClose(resp.Body)
+
+  dec := json.NewDecoder(resp.Body)
+  if err := expectDelim(dec, '{'); err != nil {
+    return 0, 0, err
+  }
+  for dec.More() {
+    token, err := dec.Token()
+    if err != nil {
+      return total, count, err
+    }
+    switch token {
+    case "total":
+      if err := dec.Decode(&total); err != nil {
+        return total, count, err
+      }
+    case "issues":
+      if err := expectDelim(dec, '['); err != nil {
+        return total, count, err
+      }
+      for dec.More() {
+        var issue json.RawMessage
+        if err := dec.Decode(&issue); err != nil {
+          return total, count, err
+        }
+        fn(issue)
+        count++
+      }
+      if err := expectDelim(dec, ']'); err != nil {
+        return total, count, err
+      }
+    default:
+      // skip anything else (expand, startAt, names...) without keeping it
+      var skip json.RawMessage
+      if err := dec.Decode(&skip); err != nil {
+        return total, count, err
+      }
+    }
+  }
+  return total, count, nil
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+  token, err := dec.Token()
+  if err != nil {
+    return err
+  }
+  if token != delim {
+    return fmt.Errorf("Error parsing json: expected %v, got %v", delim, token)
+  }
+  return nil
+}
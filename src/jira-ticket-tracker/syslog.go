@@ -0,0 +1,214 @@
+package main
+
+import (
+  "context"
+  "crypto/tls"
+  "fmt"
+  "net"
+  "net/url"
+  "os"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+)
+
+// sends each event to a syslog server as an rfc 5424 message, with the
+// issue in structured data so a siem can index it without parsing the text.
+// udp sends a datagram per message, tcp and tls frame them by their length
+// as rfc 6587 and 5425 do
+//
+//   targets:
+//     siem:
+//       type: syslog
+//       url: tls://siem.acme.com:6514   # or udp://..:514, tcp://..:601
+//       facility: local3                # local0 by default
+//       severity: {Blocker: crit, Critical: err}   # by priority, notice by default
+type syslogNotifier struct {
+  scheme   string
+  addr     string
+  host     string
+  facility int
+  severity map[string]int
+  hostname string
+
+  transport *targetTransport
+  mu        sync.Mutex
+  conn      net.Conn
+}
+
+var syslogFacilities = map[string]int{
+  "kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+  "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+  "local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var syslogSeverities = map[string]int{
+  "emerg": 0, "alert": 1, "crit": 2, "err": 3, "warning": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+// our private enterprise number isn't registered, so the sd-id uses the
+// one set aside for examples
+const syslogSDID = "jira@32473"
+
+// a target's priority to severity map, by number
+func parseSeverities(name string, severities map[string]string) map[string]int {
+  parsed := map[string]int{}
+  for priority, severity := range severities {
+    n, ok := syslogSeverities[severity]
+    if !ok {
+      logger.Print("Unknown severity ", severity, " for target ", name, ", it can be emerg, alert, crit, err, warning, notice, info or debug")
+      os.Exit(1)
+    }
+    parsed[priority] = n
+  }
+  return parsed
+}
+
+// the event's severity by its priority, notice if it isn't mapped
+func eventSeverity(event *Event, severities map[string]int) int {
+  if n, ok := severities[fieldString(event.Fields, "priority.name")]; ok {
+    return n
+  }
+  return syslogSeverities["notice"]
+}
+
+func newSyslogNotifier(name string, target Target) (*syslogNotifier, error) {
+  u, err := url.Parse(target.Url)
+  if err != nil || len(u.Host) == 0 {
+    return nil, fmt.Errorf("a syslog target needs a url like udp://host:514")
+  }
+  ports := map[string]string{"udp": "514", "tcp": "601", "tls": "6514"}
+  port, ok := ports[u.Scheme]
+  if !ok {
+    return nil, fmt.Errorf("unknown syslog scheme %s, it can be udp, tcp or tls", u.Scheme)
+  }
+  addr := u.Host
+  if len(u.Port()) == 0 {
+    addr = net.JoinHostPort(u.Hostname(), port)
+  }
+  facility := syslogFacilities["local0"]
+  if len(target.Facility) > 0 {
+    if facility, ok = syslogFacilities[target.Facility]; !ok {
+      return nil, fmt.Errorf("unknown facility %s", target.Facility)
+    }
+  }
+  hostname, _ := os.Hostname()
+  if len(hostname) == 0 {
+    hostname = "-"
+  }
+  return &syslogNotifier{
+    scheme: u.Scheme, addr: addr, host: u.Hostname(), facility: facility,
+    severity: parseSeverities(name, target.Severity), hostname: hostname,
+    transport: newTargetTransport(name, target),
+  }, nil
+}
+
+// escape a structured data param value
+func sdEscape(s string) string {
+  return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(s)
+}
+
+// the event's fields as one sd-element. param names are at most 32
+// printable characters without =, space, ] or "
+func syslogStructuredData(event *Event) string {
+  params := [][2]string{{"key", event.Issue.Key}, {"kind", event.Kind}}
+  if len(event.Rule) > 0 {
+    params = append(params, [2]string{"rule", event.Rule})
+  }
+  if event.Issue.Fields != nil {
+    params = append(params, [2]string{"summary", event.Issue.Fields.Summary})
+  }
+  if priority := fieldString(event.Fields, "priority.name"); len(priority) > 0 {
+    params = append(params, [2]string{"priority", priority})
+  }
+  if len(event.Detail) > 0 {
+    params = append(params, [2]string{"detail", event.Detail})
+  }
+  names := []string{}
+  for name := range event.Computed {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  for _, name := range names {
+    param := strings.Map(func(r rune) rune {
+      if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+        return '_'
+      }
+      return r
+    }, name)
+    if len(param) > 32 {
+      param = param[:32]
+    }
+    params = append(params, [2]string{param, event.Computed[name]})
+  }
+  var sd strings.Builder
+  sd.WriteString("[" + syslogSDID)
+  for _, param := range params {
+    fmt.Fprintf(&sd, ` %s="%s"`, param[0], sdEscape(param[1]))
+  }
+  sd.WriteString("]")
+  return sd.String()
+}
+
+func (n *syslogNotifier) format(event *Event, message string) string {
+  priority := n.facility*8 + eventSeverity(event, n.severity)
+  msgid := event.Kind
+  if len(msgid) == 0 {
+    msgid = "-"
+  }
+  // the message is utf-8, which the bom says
+  return fmt.Sprintf("<%d>1 %s %s jira-ticket-tracker %d %s %s \ufeff%s",
+    priority, time.Now().UTC().Format("2006-01-02T15:04:05.000000Z"), n.hostname, os.Getpid(), msgid,
+    syslogStructuredData(event), message)
+}
+
+func (n *syslogNotifier) Notify(event *Event, message string) error {
+  line := n.format(event, message)
+  n.mu.Lock()
+  defer n.mu.Unlock()
+  if n.conn == nil {
+    if err := n.connect(); err != nil {
+      return err
+    }
+  }
+  n.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+  var err error
+  if n.scheme == "udp" {
+    _, err = n.conn.Write([]byte(line))
+  } else {
+    _, err = fmt.Fprintf(n.conn, "%d %s", len(line), line)
+  }
+  if err != nil {
+    n.conn.Close()
+    n.conn = nil
+  }
+  return err
+}
+
+func (n *syslogNotifier) connect() error {
+  if n.scheme == "udp" {
+    conn, err := egressDialContext(&net.Dialer{Timeout: 10 * time.Second})(context.Background(), "udp", n.addr)
+    if err != nil {
+      return err
+    }
+    n.conn = conn
+    return nil
+  }
+  conn, err := n.transport.dial(n.addr)
+  if err != nil {
+    return err
+  }
+  if n.scheme == "tls" {
+    tlsConn := tls.Client(conn, n.transport.tlsConfig(n.host))
+    tlsConn.SetDeadline(time.Now().Add(10 * time.Second))
+    if err := tlsConn.Handshake(); err != nil {
+      conn.Close()
+      return err
+    }
+    tlsConn.SetDeadline(time.Time{})
+    conn = tlsConn
+  }
+  n.conn = conn
+  return nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "os"
+  "sort"
+  "strings"
+  "time"
+)
+
+const eventTeamReport = "team-report"
+
+func init() {
+  commands["response-times"] = command{"response-times [--from=TIME] [--to=TIME] [--rule=NAME,..]", responseTimesCommand}
+}
+
+// the weekly team report, sent to the targets every monday on the week
+// before. it's worked out from the history, so that has to be kept
+//
+//   team_report:
+//     targets: [ops-email]
+//     rules: [ops-queue]   # the rules whose issues count, all by default
+//     jql: project = OPS   # optional, for the time spent in each status
+//
+// for each assignee it has the median time from an issue being routed to
+// them, created or reassigned to them, to their first action on it: a
+// transition, a resolution or their own comment. with jql, it also breaks
+// down how long the matching issues resolved that week spent in each
+// status, see cycleTimes
+type TeamReportConfig struct {
+  Targets []string `yaml:"targets"`
+  Rules   []string `yaml:"rules"`
+  Jql     string   `yaml:"jql"`
+}
+
+func (c *TeamReportConfig) enabled() bool {
+  return len(c.Targets) > 0
+}
+
+func loadTeamReport(creds *Config) {
+  creds.TeamReport.Jql = configJql(creds, "team_report.jql", creds.TeamReport.Jql)
+}
+
+// how quickly one assignee first acted on the issues routed to them
+type assigneeResponse struct {
+  Name    string
+  Times   []time.Duration // of the issues acted on
+  Waiting int             // routed and not acted on yet
+}
+
+// the middle of the times, which mustn't be empty
+func medianDuration(times []time.Duration) time.Duration {
+  times = append([]time.Duration{}, times...)
+  sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+  if len(times)%2 == 1 {
+    return times[len(times)/2]
+  }
+  return (times[len(times)/2-1] + times[len(times)/2]) / 2
+}
+
+// who the issue is assigned to, as an id and a name to show, empty if
+// it's unassigned
+func recordAssignee(fields map[string]interface{}) (string, string) {
+  id := fieldString(fields, "assignee.accountId")
+  if len(id) == 0 {
+    id = fieldString(fields, "assignee.name")
+  }
+  name := fieldString(fields, "assignee.displayName")
+  if len(name) == 0 {
+    name = id
+  }
+  return id, name
+}
+
+// whether the event is the assignee acting on the issue. comments only
+// count when the latest is theirs, where the history has who wrote it
+func assigneeActed(kind string, fields map[string]interface{}, assignee string) bool {
+  switch kind {
+  case eventTransitioned, eventResolved:
+    return true
+  case eventCommented:
+    comments, _ := fieldValue(fields, "comment.comments").([]interface{})
+    if len(comments) == 0 {
+      return true
+    }
+    latest, _ := comments[len(comments)-1].(map[string]interface{})
+    for _, id := range []string{"accountId", "name"} {
+      if author := fieldString(latest, "author."+id); len(author) > 0 {
+        return author == assignee
+      }
+    }
+    return true
+  }
+  return false
+}
+
+// the response times of the issues routed between from and to. the
+// history is read on to now, so actions after to still count
+func responseTimes(from, to time.Time, rules []string) (map[string]*assigneeResponse, error) {
+  type routed struct {
+    assignee string
+    at       time.Time
+    acted    bool
+  }
+  issues := map[string]*routed{} // key -> who has it now
+  assignees := map[string]*assigneeResponse{}
+  err := store.EachHistory(from, func(r HistoryRecord) bool {
+    if r.Key == trackerKey || (len(rules) > 0 && !contains(rules, r.Rule)) {
+      return true
+    }
+    _, fields, err := parseIssue(r.Issue)
+    if err != nil {
+      return true
+    }
+    id, name := recordAssignee(fields)
+    current, known := issues[r.Key]
+    if !known || current.assignee != id {
+      if known && !current.acted {
+        assignees[current.assignee].Waiting-- // passed on without acting
+      }
+      // reassigned, or the first we know of it. unless it was just
+      // created, it could have been assigned long before
+      if len(id) > 0 && r.Time.Before(to) && (known || r.Kind == eventCreated) {
+        if _, ok := assignees[id]; !ok {
+          assignees[id] = &assigneeResponse{Name: name}
+        }
+        assignees[id].Waiting++
+        issues[r.Key] = &routed{assignee: id, at: r.Time}
+      } else {
+        issues[r.Key] = &routed{assignee: id, acted: true}
+      }
+      return true
+    }
+    if !current.acted && len(id) > 0 && assigneeActed(r.Kind, fields, id) {
+      current.acted = true
+      assignees[id].Waiting--
+      assignees[id].Times = append(assignees[id].Times, r.Time.Sub(current.at))
+    }
+    return true
+  })
+  return assignees, err
+}
+
+func formatResponseTimes(title string, assignees map[string]*assigneeResponse) string {
+  ids := []string{}
+  for id := range assignees {
+    ids = append(ids, id)
+  }
+  sort.Slice(ids, func(i, j int) bool { return assignees[ids[i]].Name < assignees[ids[j]].Name })
+  lines := []string{title}
+  for _, id := range ids {
+    a := assignees[id]
+    line := a.Name + ": "
+    if len(a.Times) > 0 {
+      line += fmt.Sprintf("median %s to first action over %d issues", humanize(medianDuration(a.Times)), len(a.Times))
+    } else {
+      line += "no issues acted on"
+    }
+    if a.Waiting > 0 {
+      line += fmt.Sprintf(", %d still waiting", a.Waiting)
+    }
+    lines = append(lines, line)
+  }
+  if len(ids) == 0 {
+    lines = append(lines, "no issues were routed to anyone")
+  }
+  return strings.Join(lines, "\n")
+}
+
+// midnight on the monday of t's week
+func weekStart(t time.Time) time.Time {
+  midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+  return midnight.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+}
+
+// last week's report
+func teamReport(creds *Config) (string, error) {
+  to := weekStart(time.Now())
+  from := to.AddDate(0, 0, -7)
+  assignees, err := responseTimes(from, to, creds.TeamReport.Rules)
+  if err != nil {
+    return "", err
+  }
+  report := formatResponseTimes("Response times for "+weekOf(from)+", from routing to the assignee's first action:", assignees)
+  if len(creds.TeamReport.Jql) == 0 {
+    return report, nil
+  }
+  statuses, issues, err := cycleTimes(creds.TeamReport.Jql, from, to, 200, creds)
+  if err != nil {
+    return "", err
+  }
+  return report + "\n\n" + formatCycleTimes("Time in each status of the issues resolved in "+weekOf(from)+":", statuses, issues), nil
+}
+
+// send last week's report once the week is over
+func sendTeamReportWeekly(creds *Config) {
+  for {
+    last := weekOf(time.Now().AddDate(0, 0, -7))
+    if reported := state.TeamReported(); len(reported) == 0 {
+      state.SetTeamReported(last) // the history may not cover it
+    } else if reported != last && !shards.leading() {
+      state.SetTeamReported(last) // the leader sends it
+    } else if reported != last {
+      report, err := teamReport(creds)
+      if err != nil {
+        logger.Print("Error reading the history for the team report: ", err)
+      } else {
+        event := trackerEvent(eventTeamReport, report)
+        event.Targets = creds.TeamReport.Targets
+        deliver([]*Event{event})
+        state.SetTeamReported(last)
+      }
+    }
+    time.Sleep(time.Hour)
+  }
+}
+
+// print the response times of a period, last week by default
+func responseTimesCommand(args []string) {
+  flags := flag.NewFlagSet("response-times", flag.ExitOnError)
+  fromFlag := flags.String("from", "", "Issues routed from this time (default the start of last week)")
+  toFlag := flags.String("to", "", "Until this time (default the start of this week, or now with --from)")
+  ruleNames := flags.String("rule", "", "Only these rules' issues (default team_report.rules)")
+  flags.Parse(args)
+
+  to := weekStart(time.Now())
+  from := to.AddDate(0, 0, -7)
+  var err error
+  if len(*fromFlag) > 0 {
+    if from, err = parseReplayTime(*fromFlag); err != nil {
+      logger.Print("Invalid --from: ", err)
+      os.Exit(1)
+    }
+    to = time.Now()
+  }
+  if len(*toFlag) > 0 {
+    if to, err = parseReplayTime(*toFlag); err != nil {
+      logger.Print("Invalid --to: ", err)
+      os.Exit(1)
+    }
+  }
+
+  creds, _ := setup()
+  rules := creds.TeamReport.Rules
+  if len(*ruleNames) > 0 {
+    rules = splitTags(*ruleNames)
+  }
+  assignees, err := responseTimes(from, to, rules)
+  if err != nil {
+    logger.Print("Error reading history: ", err)
+    os.Exit(1)
+  }
+  fmt.Println(formatResponseTimes(fmt.Sprintf("Response times from %s to %s:", from.Format(time.RFC3339), to.Format(time.RFC3339)), assignees))
+}
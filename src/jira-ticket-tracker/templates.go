@@ -0,0 +1,258 @@
+package main
+
+import (
+  "bytes"
+  "fmt"
+  htmltemplate "html/template"
+  "io/ioutil"
+  "launchpad.net/goyaml"
+  "os"
+  "path/filepath"
+  "strings"
+  "sync"
+  texttemplate "text/template"
+  "time"
+)
+
+// the named message templates, loaded at startup in main
+var templates = &templateSet{}
+
+// a templateSet holds the inline templates from the config plus every file
+// under the templates directory. a file is named by its path relative to the
+// directory without the extension, so templates/partials/footer.html is
+// "partials/footer" and can be included with {{template "partials/footer" .}}.
+// .html files are parsed as html/template so they are escaped correctly for
+// email, everything else (.tmpl, .txt) as text/template.
+//
+// a target with a locale gets the template's translation if there is one,
+// e.g. created.ja.tmpl ("created.ja") for `locale: ja` or `ja-JP`, and
+// templates can look up messages in the catalogs under locales_dir, one
+// file of message id -> text per locale (locales/ja.yaml), with
+// {{t .Locale "issue-resolved"}}
+type templateSet struct {
+  mu       sync.RWMutex
+  dir      string
+  inline   map[string]string
+  modTime  time.Time
+  text     *texttemplate.Template
+  html     *htmltemplate.Template
+  funcs    map[string]interface{}
+  locales  string
+  catalogs map[string]map[string]string // locale -> message id -> text
+}
+
+func loadTemplates(creds *Config) *templateSet {
+  t := &templateSet{dir: creds.TemplatesDir, inline: creds.Templates, locales: creds.LocalesDir}
+  t.funcs = templateFuncs(creds)
+  t.funcs["t"] = t.translate
+  if err := t.load(); err != nil {
+    logger.Print("Error loading templates: ", err)
+    os.Exit(1) // don't start sending half rendered messages
+  }
+  if len(t.dir) > 0 || len(t.locales) > 0 {
+    go t.watch()
+  }
+  return t
+}
+
+func templateName(dir, path string) string {
+  rel, err := filepath.Rel(dir, path)
+  if err != nil {
+    rel = path
+  }
+  return filepath.ToSlash(strings.TrimSuffix(rel, filepath.Ext(rel)))
+}
+
+// the template files in the directory and the newest modification time
+// among them, so we can tell when something needs reloading
+func (t *templateSet) files() (paths []string, newest time.Time, err error) {
+  if len(t.locales) > 0 {
+    catalogs, _ := filepath.Glob(filepath.Join(t.locales, "*.yaml"))
+    for _, path := range append(catalogs, t.locales) {
+      if info, err := os.Stat(path); err == nil && info.ModTime().After(newest) {
+        newest = info.ModTime() // so editing a catalog reloads too
+      }
+    }
+  }
+  if len(t.dir) == 0 {
+    return nil, newest, nil
+  }
+  err = filepath.Walk(t.dir, func(path string, info os.FileInfo, err error) error {
+    if err != nil {
+      return err
+    }
+    if info.ModTime().After(newest) {
+      newest = info.ModTime() // directories too, so deletes are noticed
+    }
+    switch filepath.Ext(path) {
+    case ".html", ".tmpl", ".txt":
+      paths = append(paths, path)
+    }
+    return nil
+  })
+  return paths, newest, err
+}
+
+// parse everything into fresh sets and only swap them in if it all parsed
+func (t *templateSet) load() error {
+  paths, newest, err := t.files()
+  if err != nil {
+    return err
+  }
+
+  text := texttemplate.New("").Funcs(t.funcs)
+  html := htmltemplate.New("").Funcs(t.funcs)
+  for name, body := range t.inline {
+    if _, err := text.New(name).Parse(body); err != nil {
+      return err
+    }
+  }
+  for _, path := range paths {
+    contents, err := ioutil.ReadFile(path)
+    if err != nil {
+      return err
+    }
+    name := templateName(t.dir, path)
+    if filepath.Ext(path) == ".html" {
+      _, err = html.New(name).Parse(string(contents))
+    } else {
+      _, err = text.New(name).Parse(string(contents))
+    }
+    if err != nil {
+      return err
+    }
+  }
+
+  catalogs, err := t.loadCatalogs()
+  if err != nil {
+    return err
+  }
+
+  t.mu.Lock()
+  t.text, t.html, t.modTime, t.catalogs = text, html, newest, catalogs
+  t.mu.Unlock()
+  return nil
+}
+
+// the message catalogs, one yaml file per locale named after it
+func (t *templateSet) loadCatalogs() (map[string]map[string]string, error) {
+  catalogs := map[string]map[string]string{}
+  if len(t.locales) == 0 {
+    return catalogs, nil
+  }
+  paths, err := filepath.Glob(filepath.Join(t.locales, "*.yaml"))
+  if err != nil {
+    return nil, err
+  }
+  for _, path := range paths {
+    contents, err := ioutil.ReadFile(path)
+    if err != nil {
+      return nil, err
+    }
+    messages := map[string]string{}
+    if err := goyaml.Unmarshal(contents, &messages); err != nil {
+      return nil, fmt.Errorf("%s: %v", path, err)
+    }
+    locale := strings.TrimSuffix(filepath.Base(path), ".yaml")
+    catalogs[strings.ToLower(locale)] = messages
+  }
+  return catalogs, nil
+}
+
+// the locales to try for a locale, most specific first: ja-JP then ja
+func localeChain(locale string) []string {
+  locale = strings.ToLower(strings.Replace(locale, "_", "-", -1))
+  if len(locale) == 0 {
+    return nil
+  }
+  chain := []string{locale}
+  if i := strings.Index(locale, "-"); i > 0 {
+    chain = append(chain, locale[:i])
+  }
+  return chain
+}
+
+// the message in the locale's catalog, formatted with any args, or the id
+// itself if it isn't translated
+func (t *templateSet) translate(locale, id string, args ...interface{}) string {
+  t.mu.RLock()
+  catalogs := t.catalogs
+  t.mu.RUnlock()
+
+  message := id
+  for _, l := range localeChain(locale) {
+    if m, ok := catalogs[l][id]; ok {
+      message = m
+      break
+    }
+  }
+  if len(args) > 0 {
+    return fmt.Sprintf(message, args...)
+  }
+  return message
+}
+
+// the name of the template to render for a locale, its translation if one
+// was loaded
+func (t *templateSet) Localized(name, locale string) string {
+  t.mu.RLock()
+  defer t.mu.RUnlock()
+  for _, l := range localeChain(locale) {
+    localized := name + "." + l
+    if (t.html != nil && t.html.Lookup(localized) != nil) || (t.text != nil && t.text.Lookup(localized) != nil) {
+      return localized
+    }
+  }
+  return name
+}
+
+// reload the directory whenever something in it changes. a broken edit
+// keeps the previous templates in place
+func (t *templateSet) watch() {
+  for {
+    time.Sleep(time.Duration(waitIntervalSecs * time.Second))
+    _, newest, err := t.files()
+    if err != nil {
+      logger.Print("Error reading templates: ", err)
+      continue
+    }
+    t.mu.RLock()
+    changed := newest.After(t.modTime)
+    t.mu.RUnlock()
+    if !changed {
+      continue
+    }
+    if err := t.load(); err != nil {
+      logger.Print("Error reloading templates, keeping the old ones: ", err)
+      t.mu.Lock()
+      t.modTime = newest // don't retry until the next edit
+      t.mu.Unlock()
+    } else {
+      logger.Print("Reloaded templates")
+    }
+  }
+}
+
+// IsHTML is whether the named template is an html one, e.g. for emails
+func (t *templateSet) IsHTML(name string) bool {
+  t.mu.RLock()
+  defer t.mu.RUnlock()
+  return len(name) > 0 && t.html != nil && t.html.Lookup(name) != nil
+}
+
+func (t *templateSet) Render(name string, data interface{}) (string, error) {
+  t.mu.RLock()
+  text, html := t.text, t.html
+  t.mu.RUnlock()
+
+  var out bytes.Buffer
+  if html != nil && html.Lookup(name) != nil {
+    err := html.ExecuteTemplate(&out, name, data)
+    return out.String(), err
+  }
+  if text != nil && text.Lookup(name) != nil {
+    err := text.ExecuteTemplate(&out, name, data)
+    return out.String(), err
+  }
+  return "", fmt.Errorf("no template named %q", name)
+}
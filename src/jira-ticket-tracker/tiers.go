@@ -0,0 +1,145 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "launchpad.net/goyaml"
+  "net/http"
+  "net/url"
+  "os"
+  "strings"
+  "sync"
+  "time"
+)
+
+// customer tiers for service desk projects, configured under
+// `customer_tiers`. the tier of the first of the issue's organizations
+// found in the file (or, failing that, the crm api) is attached to events
+// as the computed field "tier", so routes and templates can use it:
+//
+//   customer_tiers:
+//     field: Organizations   # the default, jsm's organizations field
+//     file: ./tiers.yaml     # organization name -> tier
+//     api: https://crm.example.com/api/orgs/{org}  # returns {"tier": ".."}
+//     default: standard
+type TierConfig struct {
+  Field   string `yaml:"field"`
+  File    string `yaml:"file"`
+  Api     string `yaml:"api"`
+  Default string `yaml:"default"`
+}
+
+const tierCacheTTL = time.Hour
+
+var customerTiers = &tierLookup{}
+
+type tierLookup struct {
+  mu     sync.Mutex
+  config TierConfig
+  creds  *Config
+  file   map[string]string
+  cached map[string]cachedTier
+}
+
+type cachedTier struct {
+  tier    string
+  fetched time.Time
+}
+
+func loadCustomerTiers(creds *Config) {
+  config := creds.CustomerTiers
+  customerTiers = &tierLookup{config: config, creds: creds, cached: map[string]cachedTier{}}
+  if len(config.Field) == 0 {
+    customerTiers.config.Field = "Organizations"
+  }
+  if len(config.File) == 0 {
+    return
+  }
+  contents, err := ioutil.ReadFile(config.File)
+  if err != nil {
+    logger.Print("Error reading customer tiers: ", err)
+    os.Exit(1)
+  }
+  file := map[string]string{}
+  if err := goyaml.Unmarshal(contents, &file); err != nil {
+    logger.Print("Error parsing customer tiers: ", err)
+    os.Exit(1)
+  }
+  customerTiers.file = map[string]string{}
+  for org, tier := range file {
+    customerTiers.file[strings.ToLower(org)] = tier
+  }
+}
+
+func (t *tierLookup) enabled() bool {
+  return len(t.config.File) > 0 || len(t.config.Api) > 0
+}
+
+// the tier of an issue's organization, or the default
+func (t *tierLookup) tier(fields map[string]interface{}) string {
+  if !t.enabled() {
+    return ""
+  }
+  id := customFields.id(t.config.Field, t.creds)
+  for _, org := range listNames(fields, id) {
+    if tier, ok := t.file[strings.ToLower(org)]; ok {
+      return tier
+    }
+    if tier := t.fromApi(org); len(tier) > 0 {
+      return tier
+    }
+  }
+  return t.config.Default
+}
+
+func (t *tierLookup) fromApi(org string) string {
+  if len(t.config.Api) == 0 {
+    return ""
+  }
+  t.mu.Lock()
+  cached, ok := t.cached[org]
+  t.mu.Unlock()
+  if ok && time.Since(cached.fetched) < tierCacheTTL {
+    return cached.tier
+  }
+  // the api's calls are counted like jira's, and can have a budget too.
+  // near it a tier out of date will do
+  uri := strings.Replace(t.config.Api, "{org}", url.PathEscape(org), -1)
+  if skipForQuota(uri) || (ok && quota.degraded(uri)) {
+    return cached.tier
+  }
+
+  tier, err := fetchTier(uri)
+  if err != nil {
+    logger.Print("Error looking up the tier of ", org, ": ", err)
+    return "" // don't cache failures
+  }
+  t.mu.Lock()
+  t.cached[org] = cachedTier{tier, time.Now()}
+  t.mu.Unlock()
+  return tier
+}
+
+// counted by the quota, see quota.go. events wait on the lookup, so it
+// gives up on a slow api
+var tierClient = withQuota(&http.Client{Timeout: 10 * time.Second})
+
+func fetchTier(uri string) (string, error) {
+  resp, err := tierClient.Get(uri)
+  if err != nil {
+    return "", err
+  }
+  defer drainAndClose(resp.Body)
+  if resp.StatusCode == http.StatusNotFound {
+    return "", nil
+  }
+  if resp.StatusCode >= 300 {
+    return "", fmt.Errorf("%s returned %s", uri, resp.Status)
+  }
+  var body struct {
+    Tier string `json:"tier"`
+  }
+  err = json.NewDecoder(resp.Body).Decode(&body)
+  return body.Tier, err
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+  "bytes"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "net/url"
+  "strings"
+  "time"
+)
+
+// how much of each body --trace-http logs
+const traceBodyLength = 512
+
+// logs a line for every jira call with --trace-http, to debug e.g. searches
+// that mysteriously come back empty. credentials are never logged, they're
+// in the authorization header
+type traceTransport struct {
+  next http.RoundTripper
+}
+
+func withTrace(client *http.Client) *http.Client {
+  logger.Print("Tracing every jira request")
+  next := client.Transport
+  if next == nil {
+    next = http.DefaultTransport
+  }
+  return &http.Client{Transport: &traceTransport{next}, Timeout: client.Timeout}
+}
+
+// a body for the log, on one line and cut short
+func traceBody(body []byte) string {
+  text := strings.Join(strings.Fields(string(body)), " ")
+  return truncate(traceBodyLength, text)
+}
+
+// the url without any user info, with the query unescaped so jql is legible
+func traceURL(u *url.URL) string {
+  sanitized := *u
+  sanitized.User = nil
+  if query, err := url.QueryUnescape(sanitized.RawQuery); err == nil {
+    sanitized.RawQuery = ""
+    if len(query) > 0 {
+      return sanitized.String() + "?" + query
+    }
+  }
+  return sanitized.String()
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+  request := ""
+  if req.Body != nil {
+    body, err := ioutil.ReadAll(req.Body)
+    req.Body.Close()
+    if err != nil {
+      return nil, err
+    }
+    req.Body = ioutil.NopCloser(bytes.NewReader(body))
+    request = " " + traceBody(body)
+  }
+
+  start := time.Now()
+  resp, err := t.next.RoundTrip(req)
+  latency := time.Since(start).Truncate(time.Millisecond)
+  line := fmt.Sprintf("HTTP %s %s%s", req.Method, traceURL(req.URL), request)
+  if err != nil {
+    logger.Print(line, " -> error after ", latency, ": ", err)
+    return resp, err
+  }
+
+  body, err := ioutil.ReadAll(resp.Body)
+  resp.Body.Close()
+  resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+  if err != nil {
+    logger.Print(line, " -> ", resp.Status, " in ", latency, ", error reading the body: ", err)
+    return resp, nil // let the caller see the short body
+  }
+  logger.Print(line, " -> ", resp.Status, " in ", latency, ": ", traceBody(body))
+  return resp, nil
+}
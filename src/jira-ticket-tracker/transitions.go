@@ -0,0 +1,82 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "strings"
+)
+
+// move an issue through the workflow transition with the given name, or
+// the one leading to a status of that name
+func transitionIssue(key, name string, creds *Config) error {
+  creds = creds.accountFor("transition") // the transitions offered depend on who asks
+  uri := "/issue/" + key + "/transitions"
+  contents, err := jiraRequest("GET", uri, nil, creds)
+  if err != nil {
+    return err
+  }
+  var available struct {
+    Transitions []struct {
+      Id   string `json:"id"`
+      Name string `json:"name"`
+      To   struct {
+        Name string `json:"name"`
+      } `json:"to"`
+    } `json:"transitions"`
+  }
+  if err := json.Unmarshal(contents, &available); err != nil {
+    return err
+  }
+
+  names := []string{}
+  for _, t := range available.Transitions {
+    if strings.EqualFold(t.Name, name) || strings.EqualFold(t.To.Name, name) {
+      body := map[string]interface{}{"transition": map[string]string{"id": t.Id}}
+      _, err := jiraRequest("POST", uri, body, creds)
+      return err
+    }
+    names = append(names, t.Name)
+  }
+  return fmt.Errorf("%s has no transition %q, only %s", key, name, strings.Join(names, ", "))
+}
+
+func assignIssue(key, user string, creds *Config) error {
+  _, err := jiraRequest("PUT", "/issue/"+key+"/assignee", map[string]string{"name": user}, creds.accountFor("assign"))
+  return err
+}
+
+// create an issue from its fields, returning the new key
+func createIssue(fields map[string]interface{}, creds *Config) (string, error) {
+  contents, err := jiraRequest("POST", "/issue", map[string]interface{}{"fields": fields}, creds.accountFor("create"))
+  if err != nil {
+    return "", err
+  }
+  var created struct {
+    Key string `json:"key"`
+  }
+  if err := json.Unmarshal(contents, &created); err != nil {
+    return "", err
+  }
+  return created.Key, nil
+}
+
+// subscribe the targets to an issue we just created and tell them about it
+func followNewIssue(key string, targets []string, detail string, creds *Config, c chan []*Event) {
+  for _, target := range targets {
+    state.Subscribe(key, target)
+  }
+  contents := jiraIssue(key, creds)
+  if contents == nil {
+    return
+  }
+  issue, fields, err := parseIssue(contents)
+  if err != nil {
+    logger.Print("Error parsing ", key, ": ", err)
+    return
+  }
+  state.SetSnapshot(key, snapshotOf(fields))
+  event := newEvent(eventCreated, issue, fields)
+  event.Detail = detail
+  event.Targets = targets
+  c <- []*Event{event}
+}
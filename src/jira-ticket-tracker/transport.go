@@ -0,0 +1,184 @@
+package main
+
+import (
+  "bufio"
+  "crypto/tls"
+  "crypto/x509"
+  "encoding/base64"
+  "fmt"
+  "io/ioutil"
+  "net"
+  "net/http"
+  "net/url"
+  "os"
+  "time"
+)
+
+// the ca, client certificate and proxy a target connects with, instead of
+// the system's cas and the environment's proxy. for webhooks, sms, email,
+// nats and kafka. slack targets always go through the environment's proxy
+//
+//   targets:
+//     audit:
+//       type: webhook
+//       url: https://audit.internal/jira-events
+//       tls:
+//         ca: /etc/tracker/internal-ca.pem   # trusted instead of the system's
+//         cert: /etc/tracker/client.pem      # for mtls
+//         key: /etc/tracker/client-key.pem
+//         server_name: audit.internal        # optional, to verify against
+//       proxy: http://egress.internal:3128   # or none, the environment's by default
+type TLSConfig struct {
+  CA         string `yaml:"ca"`
+  Cert       string `yaml:"cert"`
+  Key        string `yaml:"key"`
+  ServerName string `yaml:"server_name"`
+}
+
+// how a target connects. nil is the defaults
+type targetTransport struct {
+  tls    *tls.Config // nil for the system's cas
+  proxy  *url.URL    // nil for the environment's, or none with direct
+  direct bool
+  client *http.Client
+}
+
+// nil when the target has neither tls nor a proxy configured
+func newTargetTransport(name string, target Target) *targetTransport {
+  if target.TLS == (TLSConfig{}) && len(target.Proxy) == 0 {
+    return nil
+  }
+  t := &targetTransport{}
+  if target.TLS != (TLSConfig{}) {
+    config, err := loadTLSConfig(target.TLS)
+    if err != nil {
+      logger.Print("Invalid tls for target ", name, ": ", err)
+      os.Exit(1)
+    }
+    t.tls = config
+  }
+  switch target.Proxy {
+  case "":
+  case "none":
+    t.direct = true
+  default:
+    proxy, err := url.Parse(target.Proxy)
+    if err != nil || len(proxy.Host) == 0 {
+      logger.Print("Invalid proxy ", target.Proxy, " for target ", name)
+      os.Exit(1)
+    }
+    t.proxy = proxy
+  }
+  transport := http.DefaultTransport.(*http.Transport).Clone()
+  if t.tls != nil {
+    transport.TLSClientConfig = t.tls
+  }
+  switch {
+  case t.direct:
+    transport.Proxy = nil
+  case t.proxy != nil:
+    transport.Proxy = egressProxy(http.ProxyURL(t.proxy))
+  }
+  t.client = &http.Client{Transport: transport, Timeout: 60 * time.Second}
+  return t
+}
+
+func loadTLSConfig(c TLSConfig) (*tls.Config, error) {
+  config := &tls.Config{ServerName: c.ServerName}
+  if len(c.CA) > 0 {
+    contents, err := ioutil.ReadFile(c.CA)
+    if err != nil {
+      return nil, err
+    }
+    config.RootCAs = x509.NewCertPool()
+    if !config.RootCAs.AppendCertsFromPEM(contents) {
+      return nil, fmt.Errorf("no certificates in %s", c.CA)
+    }
+  }
+  if len(c.Cert) > 0 || len(c.Key) > 0 {
+    cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+    if err != nil {
+      return nil, err
+    }
+    config.Certificates = []tls.Certificate{cert}
+  }
+  return fipsTLS(config), nil
+}
+
+// the client to make the target's http calls with
+func (t *targetTransport) httpClient() *http.Client {
+  if t == nil {
+    return http.DefaultClient
+  }
+  return t.client
+}
+
+// the tls config for a connection to host, verified against serverName
+// unless the target has its own
+func (t *targetTransport) tlsConfig(serverName string) *tls.Config {
+  config := fipsTLS(&tls.Config{})
+  if t != nil && t.tls != nil {
+    config = t.tls.Clone()
+  }
+  if len(config.ServerName) == 0 {
+    config.ServerName = serverName
+  }
+  return config
+}
+
+// a tcp connection to addr for the targets that aren't http, through the
+// proxy with CONNECT if the target has one. the environment's proxy is only
+// for http
+func (t *targetTransport) dial(addr string) (net.Conn, error) {
+  if t == nil || t.proxy == nil {
+    return dialEgress(addr, 10*time.Second)
+  }
+  if t.proxy.Scheme != "http" {
+    return nil, fmt.Errorf("only http proxies can tunnel to %s", addr)
+  }
+  if host, _, err := net.SplitHostPort(addr); err != nil || !egress.allowsDestination(host) {
+    return nil, egressDenied(addr)
+  }
+  proxyAddr := t.proxy.Host
+  if len(t.proxy.Port()) == 0 {
+    proxyAddr += ":80"
+  }
+  conn, err := dialEgress(proxyAddr, 10*time.Second)
+  if err != nil {
+    return nil, err
+  }
+  conn.SetDeadline(time.Now().Add(10 * time.Second))
+  connect := "CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+  if t.proxy.User != nil {
+    password, _ := t.proxy.User.Password()
+    credentials := base64.StdEncoding.EncodeToString([]byte(t.proxy.User.Username() + ":" + password))
+    connect += "Proxy-Authorization: Basic " + credentials + "\r\n"
+  }
+  if _, err := conn.Write([]byte(connect + "\r\n")); err != nil {
+    conn.Close()
+    return nil, err
+  }
+  reader := bufio.NewReader(conn)
+  resp, err := http.ReadResponse(reader, nil)
+  if err != nil {
+    conn.Close()
+    return nil, err
+  }
+  resp.Body.Close()
+  if resp.StatusCode != http.StatusOK {
+    conn.Close()
+    return nil, fmt.Errorf("the proxy returned %s tunnelling to %s", resp.Status, addr)
+  }
+  conn.SetDeadline(time.Time{})
+  // servers like smtp's greet first, so the greeting may be buffered already
+  return &bufferedConn{conn, reader}, nil
+}
+
+type bufferedConn struct {
+  net.Conn
+  reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+  return c.reader.Read(b)
+}
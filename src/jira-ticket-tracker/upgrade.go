@@ -0,0 +1,113 @@
+package main
+
+import (
+  "context"
+  "fmt"
+  "net"
+  "os"
+  "os/signal"
+  "sync"
+  "syscall"
+  "time"
+)
+
+// held for writing by an upgrade while it hands over, so no polled events
+// are delivered by the old process once the new one may have the state
+var deliveries sync.RWMutex
+
+// held for reading by the producers besides the pollers, like the watchers,
+// reminders and chains, for each round of their work, and for writing by an
+// upgrade, so it waits for the rounds under way and no new ones start
+var producers sync.RWMutex
+
+// how long requests to the control API get to finish before an upgrade
+const apiShutdownTimeout = 30 * time.Second
+
+// how long the pollers and the other producers each get to stop for one
+const handOverTimeout = time.Minute
+
+// lock l, or give up after the timeout. a lock that comes too late is let
+// go as soon as it does
+func lockWithin(l sync.Locker, timeout time.Duration) bool {
+  locked := make(chan struct{})
+  go func() {
+    l.Lock()
+    close(locked)
+  }()
+  select {
+  case <-locked:
+    return true
+  case <-time.After(timeout):
+    go func() {
+      <-locked
+      l.Unlock()
+    }()
+    return false
+  }
+}
+
+// stop everything that polls, produces, delivers or serves, and save the
+// state and the snapshot for the new process to load. the producers finish
+// what they were sending and the consumer what it got, so nothing is left
+// in the channel. what's returned carries on as before, if the new process
+// doesn't take over
+func handOver(creds *Config) (func(socket *os.File), error) {
+  if err := holdPollers(handOverTimeout); err != nil {
+    resumePollers()
+    return nil, err
+  }
+  if !lockWithin(&producers, handOverTimeout) {
+    resumePollers()
+    return nil, fmt.Errorf("the watchers, reminders and chains didn't stop within %s", handOverTimeout)
+  }
+  // requests to the control API may still send events, so this comes
+  // before the deliveries stop
+  if apiServer != nil {
+    ctx, cancel := context.WithTimeout(context.Background(), apiShutdownTimeout)
+    if err := apiServer.Shutdown(ctx); err != nil {
+      apiServer.Close() // e.g. /events streams, they reconnect to the new one
+    }
+    cancel()
+  }
+  deliveries.Lock()
+  // only now that nothing makes changes is the state saved for the last time
+  state.Freeze()
+  if len(creds.WarmStart.Path) > 0 {
+    if err := saveSnapshot(creds.WarmStart.Path); err != nil {
+      logger.Print("Error saving the snapshot: ", err)
+    }
+  }
+
+  return func(socket *os.File) {
+    state.Thaw()
+    if socket != nil {
+      listener, err := net.FileListener(socket)
+      if err != nil {
+        logger.Print("Error serving the control API again: ", err)
+      } else {
+        go serveAPI(listener)
+      }
+    }
+    deliveries.Unlock()
+    producers.Unlock()
+    resumePollers()
+  }, nil
+}
+
+// stop the way an upgrade hands over, with no process to take over, so the
+// events under way are finished and the state and snapshot are saved
+func shutdown(creds *Config) {
+  if _, err := handOver(creds); err != nil {
+    logger.Print("Error stopping cleanly: ", err)
+  }
+}
+
+// shut down on SIGTERM or an interrupt, then exit
+func handleShutdownSignal(creds *Config) {
+  signals := make(chan os.Signal, 1)
+  signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+  sig := <-signals
+  logger.Print("Shutting down, got ", sig)
+  shutdown(creds)
+  os.Exit(0)
+}
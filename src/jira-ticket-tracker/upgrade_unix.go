@@ -0,0 +1,141 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+  "bufio"
+  "fmt"
+  "net"
+  "os"
+  "os/exec"
+  "os/signal"
+  "strconv"
+  "strings"
+  "syscall"
+  "time"
+)
+
+// SIGUSR2 upgrades the tracker in place, e.g. after replacing the binary,
+// `kill -USR2 $(pidof jira-ticket-tracker)`. the old process stops polling,
+// finishes what it's delivering, stops serving and saves its state, then
+// starts the binary again with the same arguments and hands it the control
+// API's socket. connections meanwhile wait in the socket's backlog instead
+// of being refused. once the new process is up it says so and the old one
+// exits. if it doesn't within upgradeTimeout, the old one carries on
+const (
+  readyFdEnv    = "JIRA_TRACKER_READY_FD"
+  listenerFdEnv = "JIRA_TRACKER_LISTENER_FD"
+)
+
+const upgradeTimeout = 2 * time.Minute
+
+func handleUpgradeSignal(listener net.Listener, creds *Config) {
+  signals := make(chan os.Signal, 1)
+  signal.Notify(signals, syscall.SIGUSR2)
+  for range signals {
+    logger.Print("Upgrading, got SIGUSR2")
+    if err := upgrade(listener, creds); err != nil {
+      logger.Print("Error upgrading, carrying on as before: ", err)
+    }
+  }
+}
+
+func upgrade(listener net.Listener, creds *Config) error {
+  executable, err := os.Executable()
+  if err != nil {
+    return err
+  }
+  var socket *os.File
+  if l, ok := listener.(*net.TCPListener); ok {
+    // a copy, the socket stays open when the server closes its own
+    if socket, err = l.File(); err != nil {
+      return err
+    }
+    defer socket.Close()
+  }
+  ready, readyWriter, err := os.Pipe()
+  if err != nil {
+    return err
+  }
+  defer ready.Close()
+
+  resume, err := handOver(creds)
+  if err != nil {
+    readyWriter.Close()
+    return err
+  }
+  env := []string{}
+  for _, v := range os.Environ() {
+    if !strings.HasPrefix(v, readyFdEnv+"=") && !strings.HasPrefix(v, listenerFdEnv+"=") {
+      env = append(env, v)
+    }
+  }
+  cmd := exec.Command(executable, os.Args[1:]...)
+  cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+  cmd.ExtraFiles = []*os.File{readyWriter} // fd 3
+  cmd.Env = append(env, readyFdEnv+"=3")
+  if socket != nil {
+    cmd.ExtraFiles = append(cmd.ExtraFiles, socket) // fd 4
+    cmd.Env = append(cmd.Env, listenerFdEnv+"=4")
+  }
+  err = cmd.Start()
+  readyWriter.Close()
+  if err != nil {
+    resume(socket)
+    return err
+  }
+
+  // a line once it's up, or the pipe closing if it exits before that
+  answer := make(chan bool, 1)
+  go func() {
+    line, _ := bufio.NewReader(ready).ReadString('\n')
+    answer <- strings.TrimSpace(line) == "ready"
+  }()
+  select {
+  case ok := <-answer:
+    if ok {
+      logger.Print("Process ", cmd.Process.Pid, " took over, exiting")
+      os.Exit(0)
+    }
+    err = fmt.Errorf("the new process exited before it took over")
+  case <-time.After(upgradeTimeout):
+    cmd.Process.Kill()
+    err = fmt.Errorf("the new process didn't take over within %s", upgradeTimeout)
+  }
+  go cmd.Wait()
+  resume(socket)
+  return err
+}
+
+// the control API's socket from the process this one is upgrading, nil if
+// it isn't
+func inheritedListener() net.Listener {
+  fd, err := strconv.Atoi(os.Getenv(listenerFdEnv))
+  if err != nil {
+    return nil
+  }
+  os.Unsetenv(listenerFdEnv)
+  file := os.NewFile(uintptr(fd), "listener")
+  defer file.Close()
+  listener, err := net.FileListener(file)
+  if err != nil {
+    logger.Print("Error taking over the control API's socket: ", err)
+    return nil
+  }
+  return listener
+}
+
+// tell the process being upgraded that this one is up, so it exits
+func tookOver() {
+  fd, err := strconv.Atoi(os.Getenv(readyFdEnv))
+  if err != nil {
+    return
+  }
+  os.Unsetenv(readyFdEnv)
+  file := os.NewFile(uintptr(fd), "ready")
+  defer file.Close()
+  if _, err := file.Write([]byte("ready\n")); err != nil {
+    logger.Print("Error telling the old process this one took over: ", err)
+  }
+}
@@ -0,0 +1,17 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+  "net"
+)
+
+// there is no SIGUSR2 on windows, so no upgrading in place either
+func handleUpgradeSignal(listener net.Listener, creds *Config) {}
+
+func inheritedListener() net.Listener {
+  return nil
+}
+
+func tookOver() {}
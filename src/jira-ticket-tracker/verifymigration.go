@@ -0,0 +1,167 @@
+package main
+
+import (
+  "encoding/json"
+  "flag"
+  "fmt"
+  "net/url"
+  "os"
+  "strings"
+)
+
+func init() {
+  commands["verify-migration"] = command{"verify-migration --jql=JQL [--to=INSTANCE] [--map=OLD:NEW,..] [--field=NAME] [--limit=10000]", verifyMigrationCommand}
+}
+
+// the target's counterpart of a source issue, by the key with its project
+// mapped, or by a field on the target holding the source key
+type counterpartFinder struct {
+  instance *correlatedInstance
+  projects map[string]string // source project -> target project
+  field    string            // the target's field with the source key, if given
+  fieldId  string
+}
+
+// what's looked for on the target, for the report
+func (f *counterpartFinder) expected(key string) string {
+  if len(f.field) > 0 {
+    return fmt.Sprintf("%s = %s", f.field, key)
+  }
+  i := strings.LastIndex(key, "-")
+  if project, ok := f.projects[key[:i]]; ok {
+    return project + key[i:]
+  }
+  return key
+}
+
+// whether the target has the source issue, and an error when that couldn't
+// be told
+func (f *counterpartFinder) exists(key string) (bool, error) {
+  if len(f.field) == 0 {
+    _, err := f.instance.lookup(f.expected(key))
+    if err != nil && strings.Contains(err.Error(), "returned 404") {
+      return false, nil
+    }
+    return err == nil, err
+  }
+  jql := fmt.Sprintf("%s ~ %s", jqlQuote(f.field), jqlQuote(key))
+  uri := fmt.Sprintf("/search?jql=%s&maxResults=50&fields=%s", url.QueryEscape(jql), url.QueryEscape(f.fieldId))
+  contents, err := jiraRequest("GET", uri, nil, f.instance.creds)
+  if err != nil {
+    return false, err
+  }
+  var result struct {
+    Issues []struct {
+      Fields map[string]interface{} `json:"fields"`
+    } `json:"issues"`
+  }
+  if err := json.Unmarshal(contents, &result); err != nil {
+    return false, err
+  }
+  // the search is for text, so an exact value is looked for in what it found
+  for _, issue := range result.Issues {
+    if strings.EqualFold(strings.TrimSpace(fieldString(issue.Fields, f.fieldId)), key) {
+      return true, nil
+    }
+  }
+  return false, nil
+}
+
+// the id of a field on the instance by its name, or the name if it's an id
+func (i *correlatedInstance) fieldId(name string) (string, error) {
+  contents, err := jiraRequest("GET", "/field", nil, i.creds)
+  if err != nil {
+    return "", err
+  }
+  var fields []struct {
+    Id   string `json:"id"`
+    Name string `json:"name"`
+  }
+  if err := json.Unmarshal(contents, &fields); err != nil {
+    return "", err
+  }
+  for _, field := range fields {
+    if field.Id == name || strings.EqualFold(field.Name, name) {
+      return field.Id, nil
+    }
+  }
+  return "", fmt.Errorf("%s has no field %s", i.Name, name)
+}
+
+func parseProjectMap(s string) (map[string]string, error) {
+  projects := map[string]string{}
+  for _, pair := range strings.Split(s, ",") {
+    if len(strings.TrimSpace(pair)) == 0 {
+      continue
+    }
+    parts := strings.SplitN(pair, ":", 2)
+    if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+      return nil, fmt.Errorf("%q isn't OLD:NEW", pair)
+    }
+    projects[strings.ToUpper(strings.TrimSpace(parts[0]))] = strings.ToUpper(strings.TrimSpace(parts[1]))
+  }
+  return projects, nil
+}
+
+func verifyMigrationCommand(args []string) {
+  flags := flag.NewFlagSet("verify-migration", flag.ExitOnError)
+  jql := flags.String("jql", "", "The issues on this instance that should have been migrated")
+  to := flags.String("to", "", "The correlation instance they were migrated to, if there's more than one")
+  mapping := flags.String("map", "", "Projects whose key changed, e.g. OPS:OPSC,PAY:PAYC")
+  field := flags.String("field", "", "A field on the target holding the source key, instead of matching keys")
+  limit := flags.Int("limit", 10000, "The most issues to check")
+  flags.Parse(args)
+  if len(*jql) == 0 || flags.NArg() > 0 || (len(*mapping) > 0 && len(*field) > 0) {
+    usageExit(commands["verify-migration"].usage)
+  }
+
+  creds, _ := setup()
+  if correlations == nil {
+    logger.Print("Configure the instance issues were migrated to under correlation.instances")
+    os.Exit(1)
+  }
+  finder := &counterpartFinder{field: *field}
+  for _, i := range correlations.instances {
+    if i.Name == *to || (len(*to) == 0 && len(correlations.instances) == 1) {
+      finder.instance = i
+    }
+  }
+  if finder.instance == nil {
+    logger.Print("Which instance? Give --to with one of correlation.instances")
+    os.Exit(1)
+  }
+  projects, err := parseProjectMap(*mapping)
+  if err != nil {
+    logger.Print("Invalid --map: ", err)
+    os.Exit(1)
+  }
+  finder.projects = projects
+  if len(*field) > 0 {
+    if finder.fieldId, err = finder.instance.fieldId(*field); err != nil {
+      logger.Print("Error finding --field: ", err)
+      os.Exit(1)
+    }
+  }
+
+  issues, _, err := searchIssues(configJql(creds, "--jql", *jql), *limit, creds)
+  if err != nil {
+    logger.Print("Error searching: ", err)
+    os.Exit(1)
+  }
+  missing, unknown := 0, 0
+  for _, issue := range issues {
+    found, err := finder.exists(issue.Key)
+    switch {
+    case err != nil:
+      unknown++
+      fmt.Printf("UNKNOWN %s: %s (%v)\n", issue.Key, finder.expected(issue.Key), err)
+    case !found:
+      missing++
+      fmt.Printf("MISSING %s: %s not on %s\n", issue.Key, finder.expected(issue.Key), finder.instance.Name)
+    }
+  }
+  fmt.Printf("%d issues checked, %d missing on %s, %d couldn't be checked\n", len(issues), missing, finder.instance.Name, unknown)
+  if missing > 0 || unknown > 0 {
+    os.Exit(1)
+  }
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/url"
+  "sync"
+  "time"
+)
+
+// a Visibility check keeps restricted issues out of broad channels: before
+// a target gets an event, jira is asked whether the team behind it can see
+// the issue, and if not the target is skipped
+//
+//   visible_to:
+//     group: ops-team    # every active member must see it
+//     # user: ops-bot    # or one account standing in for the team
+type Visibility struct {
+  Group string `yaml:"group"`
+  User  string `yaml:"user"`
+}
+
+const (
+  visibilityCacheTTL = 5 * time.Minute
+  groupMembersPage   = 50
+)
+
+var visibilityCache = struct {
+  sync.Mutex
+  checked map[string]visibilityResult
+}{checked: map[string]visibilityResult{}}
+
+type visibilityResult struct {
+  visible bool
+  checked time.Time
+}
+
+func (v *Visibility) enabled() bool {
+  return len(v.Group) > 0 || len(v.User) > 0
+}
+
+// the active members of a jira group, every page of them, through the
+// metadata cache. a page that can't be read fails the whole group, since
+// the members missing from it might not see the issue
+func groupMembers(group string, creds *Config) ([]string, error) {
+  members := []string{}
+  for start := 0; ; start += groupMembersPage {
+    uri := "/group/member?groupname=" + url.QueryEscape(group) + fmt.Sprintf("&startAt=%d&maxResults=%d", start, groupMembersPage)
+    contents, err := jiraCached(uri, creds)
+    if err != nil {
+      return nil, err
+    }
+    var page struct {
+      IsLast bool `json:"isLast"`
+      Values []struct {
+        Name   string `json:"name"`
+        Active bool   `json:"active"`
+      } `json:"values"`
+    }
+    if err := json.Unmarshal(contents, &page); err != nil {
+      return nil, err
+    }
+    for _, m := range page.Values {
+      if m.Active {
+        members = append(members, m.Name)
+      }
+    }
+    if page.IsLast || len(page.Values) == 0 {
+      return members, nil
+    }
+  }
+}
+
+// whether a user can browse an issue
+func canView(key, user string, creds *Config) (bool, error) {
+  uri := "/user/viewissue/search?issueKey=" + url.QueryEscape(key) + "&username=" + url.QueryEscape(user)
+  contents, err := jiraRequest("GET", uri, nil, creds)
+  if err != nil {
+    return false, err
+  }
+  var users []struct {
+    Name string `json:"name"`
+  }
+  if err := json.Unmarshal(contents, &users); err != nil {
+    return false, err
+  }
+  for _, u := range users {
+    if u.Name == user {
+      return true, nil
+    }
+  }
+  return false, nil
+}
+
+// whether the team can see the issue. errors count as not visible, it's
+// better to miss a notification than to leak a restricted issue
+func (v *Visibility) allows(key string, creds *Config) bool {
+  principal := "user:" + v.User
+  if len(v.Group) > 0 {
+    principal = "group:" + v.Group
+  }
+  cacheKey := key + " " + principal
+  visibilityCache.Lock()
+  cached, ok := visibilityCache.checked[cacheKey]
+  visibilityCache.Unlock()
+  if ok && time.Since(cached.checked) < visibilityCacheTTL {
+    return cached.visible
+  }
+
+  visible, err := v.check(key, creds)
+  if err != nil {
+    logger.Print("Error checking whether ", principal, " can see ", key, ": ", err)
+    return false
+  }
+  visibilityCache.Lock()
+  visibilityCache.checked[cacheKey] = visibilityResult{visible, time.Now()}
+  visibilityCache.Unlock()
+  return visible
+}
+
+func (v *Visibility) check(key string, creds *Config) (bool, error) {
+  users := []string{v.User}
+  if len(v.Group) > 0 {
+    var err error
+    if users, err = groupMembers(v.Group, creds); err != nil {
+      return false, err
+    }
+    if len(users) == 0 {
+      return false, fmt.Errorf("group %s has no active members", v.Group)
+    }
+  }
+  for _, user := range users {
+    ok, err := canView(key, user, creds)
+    if err != nil || !ok {
+      return false, err
+    }
+  }
+  return true, nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+  "net/http"
+  "sort"
+  "sync"
+  "time"
+)
+
+// a fullscreen page for the team tv, served at /wallboard on the control
+// api and configured under `wallboard`. it cycles through the panels, each
+// either a list of issues or issue counts grouped by a field
+//
+//   wallboard:
+//     title: Ops
+//     rotate: 15s
+//     panels:
+//       - title: Newest tickets
+//         jql: project = OPS ORDER BY created DESC
+//         limit: 10
+//       - title: SLA at risk
+//         jql: project = OPS AND "Time to resolution" < remaining("2h")
+//       - title: Unassigned per team
+//         jql: project = OPS AND assignee is EMPTY AND resolution is EMPTY
+//         count_by: components   # or e.g. assignee.displayName
+type WallboardConfig struct {
+  Title  string           `yaml:"title"`
+  Rotate string           `yaml:"rotate"`
+  Panels []WallboardPanel `yaml:"panels"`
+}
+
+type WallboardPanel struct {
+  Title   string `yaml:"title" json:"title"`
+  Jql     string `yaml:"jql" json:"-"`
+  Limit   int    `yaml:"limit" json:"-"`    // issues listed, 10 by default
+  CountBy string `yaml:"count_by" json:"-"` // a field path, to count instead of list
+}
+
+// what a panel shows, refreshed at most every wallboardCacheTTL
+type wallboardData struct {
+  Title  string           `json:"title"`
+  Issues []wallboardIssue `json:"issues,omitempty"`
+  Counts []wallboardCount `json:"counts,omitempty"`
+  Error  string           `json:"error,omitempty"`
+}
+
+type wallboardIssue struct {
+  Key      string `json:"key"`
+  Summary  string `json:"summary"`
+  Priority string `json:"priority"`
+  Emoji    string `json:"emoji"`
+  Status   string `json:"status"`
+  Assignee string `json:"assignee"`
+  Age      string `json:"age"`
+}
+
+type wallboardCount struct {
+  Name  string `json:"name"`
+  Count int    `json:"count"`
+}
+
+const (
+  wallboardCacheTTL = 30 * time.Second
+  // how many issues a count panel looks at
+  wallboardMaxCounted = 1000
+)
+
+var wallboard = &wallboardServer{}
+
+type wallboardServer struct {
+  config WallboardConfig
+  rotate time.Duration
+  creds  *Config
+
+  mu      sync.Mutex
+  fetched time.Time
+  panels  []wallboardData
+}
+
+func loadWallboard(creds *Config) {
+  wallboard = &wallboardServer{config: creds.Wallboard, rotate: durationOr(creds.Wallboard.Rotate, 15*time.Second), creds: creds}
+}
+
+func (w *wallboardServer) panel(p WallboardPanel) wallboardData {
+  data := wallboardData{Title: p.Title}
+  limit := p.Limit
+  if limit == 0 {
+    limit = 10
+  }
+  if len(p.CountBy) > 0 {
+    limit = wallboardMaxCounted
+  }
+  issues, fields, err := searchIssues(p.Jql, limit, w.creds)
+  if err != nil {
+    data.Error = err.Error()
+    return data
+  }
+
+  if len(p.CountBy) == 0 {
+    for i, issue := range issues {
+      priority := fieldString(fields[i], "priority.name")
+      data.Issues = append(data.Issues, wallboardIssue{
+        Key:      issue.Key,
+        Summary:  fieldString(fields[i], "summary"),
+        Priority: priority,
+        Emoji:    priorityEmoji(priority),
+        Status:   fieldString(fields[i], "status.name"),
+        Assignee: fieldString(fields[i], "assignee.displayName"),
+        Age:      since(fieldString(fields[i], "created")),
+      })
+    }
+    return data
+  }
+
+  counts := map[string]int{}
+  for _, f := range fields {
+    names := listNames(f, p.CountBy)
+    if len(names) == 0 {
+      if name := fieldString(f, p.CountBy); len(name) > 0 {
+        names = []string{name}
+      } else {
+        names = []string{"none"}
+      }
+    }
+    for _, name := range names {
+      counts[name]++
+    }
+  }
+  for name, count := range counts {
+    data.Counts = append(data.Counts, wallboardCount{name, count})
+  }
+  sort.Slice(data.Counts, func(i, j int) bool {
+    if data.Counts[i].Count != data.Counts[j].Count {
+      return data.Counts[i].Count > data.Counts[j].Count
+    }
+    return data.Counts[i].Name < data.Counts[j].Name
+  })
+  return data
+}
+
+// every panel, from the cache if it's fresh so a room full of tvs doesn't
+// hammer jira
+func (w *wallboardServer) data() []wallboardData {
+  w.mu.Lock()
+  defer w.mu.Unlock()
+  if w.panels != nil && time.Since(w.fetched) < wallboardCacheTTL {
+    return w.panels
+  }
+  panels := []wallboardData{}
+  for _, p := range w.config.Panels {
+    panels = append(panels, w.panel(p))
+  }
+  w.panels, w.fetched = panels, time.Now()
+  return panels
+}
+
+//   GET /wallboard        the page
+//   GET /wallboard/data   what the panels show, as json
+func handleWallboard(rw http.ResponseWriter, r *http.Request) {
+  if r.Method != "GET" {
+    writeError(rw, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+  if len(wallboard.config.Panels) == 0 {
+    writeError(rw, http.StatusNotFound, "no wallboard panels are configured")
+    return
+  }
+  if r.URL.Path == "/wallboard/data" {
+    writeJSON(rw, http.StatusOK, map[string]interface{}{
+      "title":  wallboard.config.Title,
+      "rotate": wallboard.rotate.Seconds(),
+      "panels": wallboard.data(),
+    })
+    return
+  }
+  rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+  rw.Write([]byte(wallboardPage))
+}
+
+const wallboardPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Wallboard</title>
+<style>
+  body { margin: 0; background: #111; color: #eee; font: 2.2vw sans-serif; overflow: hidden; }
+  header { display: flex; justify-content: space-between; padding: 1vw 2vw; background: #222; }
+  h1 { margin: 0; font-size: 3vw; }
+  table { width: 96vw; margin: 1vw 2vw; border-collapse: collapse; }
+  td { padding: 0.6vw 0.8vw; border-bottom: 1px solid #333; white-space: nowrap; overflow: hidden; text-overflow: ellipsis; max-width: 50vw; }
+  .key { font-weight: bold; color: #8cf; }
+  .count { font-size: 3vw; font-weight: bold; text-align: right; width: 10vw; }
+  .error { color: #f66; padding: 2vw; }
+  #dots span { opacity: 0.3; } #dots span.on { opacity: 1; }
+</style>
+</head>
+<body>
+<header><h1 id="title"></h1><div id="dots"></div></header>
+<div id="panel"></div>
+<script>
+var data = null, current = 0;
+function esc(s) {
+  var d = document.createElement("div");
+  d.textContent = s == null ? "" : String(s);
+  return d.innerHTML;
+}
+function show() {
+  if (!data || data.panels.length == 0) return;
+  current = current % data.panels.length;
+  var p = data.panels[current], html = "";
+  document.getElementById("title").textContent = (data.title ? data.title + ": " : "") + p.title;
+  var dots = "";
+  for (var i = 0; i < data.panels.length; i++) dots += '<span class="' + (i == current ? "on" : "") + '">●</span> ';
+  document.getElementById("dots").innerHTML = dots;
+  if (p.error) {
+    html = '<div class="error">' + esc(p.error) + "</div>";
+  } else if (p.counts) {
+    html = "<table>" + p.counts.map(function(c) {
+      return '<tr><td>' + esc(c.name) + '</td><td class="count">' + c.count + "</td></tr>";
+    }).join("") + "</table>";
+  } else {
+    html = "<table>" + (p.issues || []).map(function(i) {
+      return '<tr><td>' + esc(i.emoji) + '</td><td class="key">' + esc(i.key) + "</td><td>" + esc(i.summary) +
+        "</td><td>" + esc(i.status) + "</td><td>" + esc(i.assignee) + "</td><td>" + esc(i.age) + "</td></tr>";
+    }).join("") + "</table>";
+  }
+  document.getElementById("panel").innerHTML = html;
+}
+function refresh() {
+  fetch("/wallboard/data").then(function(r) { return r.json(); }).then(function(d) { data = d; show(); });
+}
+refresh();
+setInterval(refresh, 30000);
+setTimeout(function tick() {
+  current++;
+  show();
+  setTimeout(tick, (data ? data.rotate : 15) * 1000);
+}, 15000);
+</script>
+</body>
+</html>
+`
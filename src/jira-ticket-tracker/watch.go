@@ -0,0 +1,195 @@
+package main
+
+import (
+  "encoding/json"
+  "flag"
+  "fmt"
+  "os"
+  "sort"
+  "strings"
+  "time"
+)
+
+func init() {
+  commands["watch"] = command{"watch [--interval=10s] [--color=auto|always|never] KEY", watchCommand}
+}
+
+// fields that change without anything happening to the issue, or that are
+// shown another way
+var watchIgnoredFields = map[string]bool{
+  "updated": true, "lastViewed": true, "comment": true, "worklog": true,
+  "watches": true, "votes": true, "aggregateprogress": true, "progress": true,
+  "aggregatetimespent": true, "aggregatetimeestimate": true, "timetracking": true,
+}
+
+const (
+  ansiReset = "\033[0m"
+  ansiBold  = "\033[1m"
+  ansiRed   = "\033[31m"
+  ansiGreen = "\033[32m"
+  ansiCyan  = "\033[36m"
+)
+
+type watchPrinter struct {
+  color bool
+}
+
+func (p *watchPrinter) paint(code, s string) string {
+  if !p.color {
+    return s
+  }
+  return code + s + ansiReset
+}
+
+// the readable form of a field's value, the name of objects like statuses
+// and users and the names in lists
+func watchValue(value interface{}) string {
+  switch v := value.(type) {
+  case nil:
+    return ""
+  case string:
+    return v
+  case map[string]interface{}:
+    for _, name := range []string{"displayName", "name", "value", "key"} {
+      if s, ok := v[name].(string); ok {
+        return s
+      }
+    }
+  case []interface{}:
+    names := []string{}
+    for _, item := range v {
+      names = append(names, watchValue(item))
+    }
+    return strings.Join(names, ", ")
+  case float64, bool:
+    return fmt.Sprint(v)
+  }
+  contents, _ := json.Marshal(value)
+  return string(contents)
+}
+
+func watchValues(fields map[string]interface{}) map[string]string {
+  values := map[string]string{}
+  for name, value := range fields {
+    if !watchIgnoredFields[name] {
+      values[name] = watchValue(value)
+    }
+  }
+  return values
+}
+
+func watchComments(fields map[string]interface{}) []jiraComment {
+  var page struct {
+    Comments []jiraComment `json:"comments"`
+  }
+  if raw, err := json.Marshal(fields["comment"]); err == nil {
+    json.Unmarshal(raw, &page)
+  }
+  return page.Comments
+}
+
+// print the fields that changed between two fetches, and the new comments
+func (p *watchPrinter) diff(key string, before, after map[string]interface{}, seen map[string]bool) {
+  old, now := watchValues(before), watchValues(after)
+  names := []string{}
+  for name := range now {
+    if old[name] != now[name] {
+      names = append(names, name)
+    }
+  }
+  for name := range old {
+    if _, ok := now[name]; !ok && len(old[name]) > 0 {
+      names = append(names, name)
+    }
+  }
+  sort.Strings(names)
+
+  comments := []jiraComment{}
+  for _, c := range watchComments(after) {
+    if !seen[c.Id] {
+      seen[c.Id] = true
+      comments = append(comments, c)
+    }
+  }
+  if len(names) == 0 && len(comments) == 0 {
+    return
+  }
+
+  fmt.Printf("%s %s\n", p.paint(ansiBold, key), p.paint(ansiCyan, time.Now().Format("15:04:05")))
+  for _, name := range names {
+    fmt.Printf("  %s\n", p.paint(ansiBold, name))
+    if len(old[name]) > 0 {
+      fmt.Printf("    %s\n", p.paint(ansiRed, "- "+truncate(200, old[name])))
+    }
+    if len(now[name]) > 0 {
+      fmt.Printf("    %s\n", p.paint(ansiGreen, "+ "+truncate(200, now[name])))
+    }
+  }
+  for _, c := range comments {
+    fmt.Printf("  %s\n", p.paint(ansiBold, "comment by "+c.Author.Name))
+    for _, line := range strings.Split(strings.TrimSpace(c.Body), "\n") {
+      fmt.Printf("    %s\n", p.paint(ansiGreen, line))
+    }
+  }
+}
+
+// whether stdout is a terminal rather than a file or pipe
+func stdoutIsTerminal() bool {
+  info, err := os.Stdout.Stat()
+  return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// poll one issue and print what changes on it as it happens
+func watchCommand(args []string) {
+  flags := flag.NewFlagSet("watch", flag.ExitOnError)
+  interval := flags.Duration("interval", 10*time.Second, "How often to check the issue")
+  color := flags.String("color", "auto", "Color the changes: auto, always or never")
+  flags.Parse(args)
+  if flags.NArg() != 1 {
+    usageExit(commands["watch"].usage)
+  }
+  key := strings.ToUpper(flags.Arg(0))
+
+  p := &watchPrinter{}
+  switch *color {
+  case "auto":
+    p.color = stdoutIsTerminal() && len(os.Getenv("NO_COLOR")) == 0
+  case "always":
+    p.color = true
+  case "never":
+  default:
+    usageExit(commands["watch"].usage)
+  }
+
+  creds, _ := setup()
+  contents := jiraIssue(key, creds)
+  if contents == nil {
+    logger.Print("Error fetching ", key)
+    os.Exit(1)
+  }
+  issue, fields, err := parseIssue(contents)
+  if err != nil {
+    logger.Print("Error parsing ", key, ": ", err)
+    os.Exit(1)
+  }
+  seen := map[string]bool{}
+  for _, c := range watchComments(fields) {
+    seen[c.Id] = true
+  }
+  fmt.Printf("%s %s [%s], watching for changes\n", p.paint(ansiBold, key), issue.Fields.Summary, fieldString(fields, "status.name"))
+
+  for {
+    time.Sleep(*interval)
+    contents, changed := jiraIssueIfChanged("watch", key, creds)
+    if contents == nil || !changed {
+      continue
+    }
+    _, after, err := parseIssue(contents)
+    if err != nil {
+      logger.Print("Error parsing ", key, ": ", err)
+      continue
+    }
+    p.diff(key, fields, after, seen)
+    fields = after
+  }
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+  "bufio"
+  "bytes"
+  "fmt"
+  "os"
+  "path/filepath"
+  "runtime"
+  "runtime/pprof"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+// the tracker watching itself for leaks, configured under `watchdog`. every
+// interval the goroutines are counted and the heap is measured after a gc.
+// a leak is either going over a limit, or growing at every one of the last
+// `growth` checks. the operator is alerted with the stacks most goroutines
+// are in, and a goroutine dump and heap profile are written to `dump`
+//
+//   watchdog:
+//     interval: 1m
+//     goroutines: 2000      # at most
+//     heap_mb: 512          # in use, at most
+//     growth: 10            # checks in a row, 0 to not look at growth
+//     dump: ./diagnostics   # optional
+//     restart_pollers: true # stop and start the poll loop on a leak
+//
+// the pollers carry on from their checkpoints after a restart. a poller
+// that is stuck, often the leak itself, is left behind and stops the next
+// time it looks. alerts and restarts are at most once an hour
+type WatchdogConfig struct {
+  Interval       string `yaml:"interval"`
+  Goroutines     int    `yaml:"goroutines"`
+  HeapMB         int    `yaml:"heap_mb"`
+  Growth         int    `yaml:"growth"`
+  Dump           string `yaml:"dump"`
+  RestartPollers bool   `yaml:"restart_pollers"`
+}
+
+const watchdogCooldown = time.Hour
+
+// how long a poller gets to stop when they're restarted
+const pollerStopTimeout = time.Minute
+
+type runtimeSample struct {
+  goroutines int
+  heap       uint64
+}
+
+var watchdog = struct {
+  sync.Mutex
+  samples []runtimeSample // the latest last
+  acted   time.Time
+}{}
+
+func (c *WatchdogConfig) enabled() bool {
+  return c.Goroutines > 0 || c.HeapMB > 0 || c.Growth > 0
+}
+
+func watchForLeaks(creds *Config) {
+  config := creds.Watchdog
+  interval := durationOr(config.Interval, time.Minute)
+  for {
+    time.Sleep(interval)
+    runtime.GC()
+    var m runtime.MemStats
+    runtime.ReadMemStats(&m)
+    sample := runtimeSample{runtime.NumGoroutine(), m.HeapInuse}
+
+    watchdog.Lock()
+    watchdog.samples = append(watchdog.samples, sample)
+    if len(watchdog.samples) > config.Growth+1 {
+      watchdog.samples = watchdog.samples[len(watchdog.samples)-config.Growth-1:]
+    }
+    reason := leakReason(config, watchdog.samples)
+    act := len(reason) > 0 && time.Since(watchdog.acted) > watchdogCooldown
+    if act {
+      watchdog.acted = time.Now()
+      watchdog.samples = nil // so growth is looked for again from here
+    }
+    watchdog.Unlock()
+    if act {
+      handleLeak(creds, reason, sample)
+    }
+  }
+}
+
+// why the samples look like a leak, empty if they don't
+func leakReason(config WatchdogConfig, samples []runtimeSample) string {
+  latest := samples[len(samples)-1]
+  if config.Goroutines > 0 && latest.goroutines > config.Goroutines {
+    return fmt.Sprintf("%d goroutines, the limit is %d", latest.goroutines, config.Goroutines)
+  }
+  if config.HeapMB > 0 && latest.heap > uint64(config.HeapMB)<<20 {
+    return fmt.Sprintf("%d MB of heap in use, the limit is %d MB", latest.heap>>20, config.HeapMB)
+  }
+  if config.Growth <= 0 || len(samples) <= config.Growth {
+    return ""
+  }
+  goroutines, heap := true, true
+  for i := 1; i < len(samples); i++ {
+    goroutines = goroutines && samples[i].goroutines > samples[i-1].goroutines
+    heap = heap && samples[i].heap > samples[i-1].heap
+  }
+  first := samples[0]
+  switch {
+  case goroutines:
+    return fmt.Sprintf("goroutines grew at each of the last %d checks, from %d to %d", config.Growth, first.goroutines, latest.goroutines)
+  case heap:
+    return fmt.Sprintf("the heap grew at each of the last %d checks, from %d MB to %d MB", config.Growth, first.heap>>20, latest.heap>>20)
+  }
+  return ""
+}
+
+func handleLeak(creds *Config, reason string, sample runtimeSample) {
+  message := "Possible leak: " + reason
+  if stacks := topGoroutineStacks(3); len(stacks) > 0 {
+    message += ". Most goroutines are in " + strings.Join(stacks, "; ")
+  }
+  if len(creds.Watchdog.Dump) > 0 {
+    if dir, err := writeDiagnostics(creds.Watchdog.Dump); err != nil {
+      logger.Print("Error writing the leak diagnostics: ", err)
+    } else {
+      message += ". Diagnostics are in " + dir
+    }
+  }
+  alertOperator(creds, message)
+  if creds.Watchdog.RestartPollers {
+    restarted := restartPollers()
+    logger.Print(fmt.Sprintf("Restarted %d pollers after the leak, %d goroutines before", restarted, sample.goroutines))
+  }
+}
+
+// the functions the most goroutines are in with how many, from the
+// goroutine profile, skipping the runtime's own frames
+func topGoroutineStacks(n int) []string {
+  var dump bytes.Buffer
+  if err := pprof.Lookup("goroutine").WriteTo(&dump, 1); err != nil {
+    return nil
+  }
+  type stack struct {
+    count int
+    where string
+  }
+  stacks := []stack{}
+  var current *stack
+  scanner := bufio.NewScanner(&dump)
+  for scanner.Scan() {
+    line := scanner.Text()
+    if fields := strings.Fields(line); len(fields) > 1 && fields[1] == "@" {
+      count, _ := strconv.Atoi(fields[0])
+      stacks = append(stacks, stack{count: count})
+      current = &stacks[len(stacks)-1]
+      continue
+    }
+    // #	0x4a2b3c	main.waitForIssues+0x1c	/src/jira-ticket-tracker.go:412
+    fields := strings.Fields(line)
+    if current == nil || len(current.where) > 0 || len(fields) < 3 || fields[0] != "#" {
+      continue
+    }
+    function := fields[2]
+    if i := strings.LastIndex(function, "+"); i > 0 {
+      function = function[:i]
+    }
+    if !strings.HasPrefix(function, "runtime.") && !strings.HasPrefix(function, "sync.") {
+      current.where = function
+    }
+  }
+  sort.SliceStable(stacks, func(i, j int) bool { return stacks[i].count > stacks[j].count })
+  top := []string{}
+  for _, s := range stacks {
+    if len(top) == n {
+      break
+    }
+    if len(s.where) > 0 {
+      top = append(top, fmt.Sprintf("%s (%d)", s.where, s.count))
+    }
+  }
+  return top
+}
+
+// a goroutine dump and a heap profile in a new directory under dir
+func writeDiagnostics(dir string) (string, error) {
+  dir = filepath.Join(dir, "leak-"+time.Now().Format("20060102-150405"))
+  if err := os.MkdirAll(dir, 0700); err != nil {
+    return "", err
+  }
+  goroutines, err := os.Create(filepath.Join(dir, "goroutines.txt"))
+  if err != nil {
+    return "", err
+  }
+  defer goroutines.Close()
+  if err := pprof.Lookup("goroutine").WriteTo(goroutines, 2); err != nil {
+    return "", err
+  }
+  heap, err := os.Create(filepath.Join(dir, "heap.pprof"))
+  if err != nil {
+    return "", err
+  }
+  defer heap.Close()
+  return dir, pprof.WriteHeapProfile(heap)
+}
+
+func writeRuntimeMetrics(out *strings.Builder) {
+  var m runtime.MemStats
+  runtime.ReadMemStats(&m)
+  out.WriteString("# HELP jira_tracker_goroutines Goroutines running now.\n")
+  out.WriteString("# TYPE jira_tracker_goroutines gauge\n")
+  fmt.Fprintf(out, "jira_tracker_goroutines %d\n", runtime.NumGoroutine())
+  out.WriteString("# HELP jira_tracker_heap_inuse_bytes Heap in use now.\n")
+  out.WriteString("# TYPE jira_tracker_heap_inuse_bytes gauge\n")
+  fmt.Fprintf(out, "jira_tracker_heap_inuse_bytes %d\n", m.HeapInuse)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "strings"
+)
+
+// a watchers target adds people as watchers of its issues, so they get
+// jira's own notifications about them too
+//
+//   targets:
+//     ops-watchers:
+//       type: watchers
+//       watchers:
+//         users: [alice, "group:ops-leads"]  # see recipients.go
+//         leads: true  # and the lead of each route the issue matches
+type WatchersConfig struct {
+  Users []string `yaml:"users"`
+  Leads bool     `yaml:"leads"`
+}
+
+type watchersNotifier struct {
+  name   string
+  config WatchersConfig
+  creds  *Config
+}
+
+func newWatchersNotifier(name string, config WatchersConfig, creds *Config) (*watchersNotifier, error) {
+  if len(config.Users) == 0 && !config.Leads {
+    return nil, fmt.Errorf("watchers needs users or leads")
+  }
+  return &watchersNotifier{name: name, config: config, creds: creds}, nil
+}
+
+// who should watch the event's issue
+func (n *watchersNotifier) watchers(event *Event) []string {
+  users := expandPrincipals(n.config.Users, issueProject(event), n.creds)
+  if n.config.Leads {
+    for i := range n.creds.Routing {
+      route := &n.creds.Routing[i]
+      if len(route.Lead) > 0 && route.matches(event) {
+        users = addTargets(users, []string{route.Lead})
+      }
+    }
+  }
+  return users
+}
+
+// the names and account ids of the issue's watchers
+func issueWatchers(key string, creds *Config) (map[string]bool, error) {
+  contents, err := jiraRequest("GET", "/issue/"+key+"/watchers", nil, creds)
+  if err != nil {
+    return nil, err
+  }
+  var list struct {
+    Watchers []struct {
+      Name      string `json:"name"`
+      AccountId string `json:"accountId"`
+    } `json:"watchers"`
+  }
+  if err := json.Unmarshal(contents, &list); err != nil {
+    return nil, err
+  }
+  watching := map[string]bool{}
+  for _, w := range list.Watchers {
+    watching[w.Name], watching[w.AccountId] = true, true
+  }
+  return watching, nil
+}
+
+func (n *watchersNotifier) Notify(event *Event, message string) error {
+  key := event.Issue.Key
+  watching, err := issueWatchers(key, n.creds)
+  if err != nil {
+    return fmt.Errorf("can't list the watchers of %s: %v", key, err)
+  }
+  failed := []string{}
+  for _, user := range n.watchers(event) {
+    if watching[user] {
+      continue
+    }
+    // the body is the username, or the account id on jira cloud
+    if _, err := jiraRequest("POST", "/issue/"+key+"/watchers", user, n.creds.accountFor("edit")); err != nil {
+      failed = append(failed, user+": "+err.Error())
+      continue
+    }
+    logger.Print(n.name, ": ", user, " is watching ", key)
+  }
+  if len(failed) > 0 {
+    return fmt.Errorf("can't add watchers to %s: %s", key, strings.Join(failed, ", "))
+  }
+  return nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+  "bufio"
+  "encoding/json"
+  "fmt"
+  "net/url"
+  "os"
+  "sort"
+  "strings"
+  "time"
+)
+
+// how long a resolved issue matched by a rule keeps being followed in case
+// it is reopened
+const reopenWindow = 30 * 24 * time.Hour
+
+// how many watched keys go in one search, to keep the jql in a url
+const watchBatch = 100
+
+// what we remember about a watched issue between polls
+type issueSnapshot struct {
+  Status     string `json:"status"`
+  Updated    string `json:"updated"`
+  Comments   int    `json:"comments"`
+  Resolution string `json:"resolution,omitempty"`
+  Resolved   string `json:"resolved,omitempty"` // the resolutiondate
+  Due        string `json:"due,omitempty"`      // the duedate, e.g. 2006-01-02
+  Created    string `json:"created,omitempty"`
+}
+
+func snapshotOf(fields map[string]interface{}) issueSnapshot {
+  return issueSnapshot{
+    Status:     fieldString(fields, "status.name"),
+    Updated:    fieldString(fields, "updated"),
+    Comments:   int(fieldNumber(fields, "comment.total")),
+    Resolution: fieldString(fields, "resolution.name"),
+    Resolved:   fieldString(fields, "resolutiondate"),
+    Due:        fieldString(fields, "duedate"),
+    Created:    fieldString(fields, "created"),
+  }
+}
+
+// compare two snapshots of the same issue and return the event kind and
+// detail to emit, or an empty kind if nothing changed
+func snapshotChange(before, after issueSnapshot) (kind, detail string) {
+  switch {
+  case len(before.Resolution) == 0 && len(after.Resolution) > 0:
+    return eventResolved, after.Resolution
+  case len(before.Resolution) > 0 && len(after.Resolution) == 0:
+    return eventReopened, before.Resolution + " -> " + after.Status
+  case before.Status != after.Status:
+    return eventTransitioned, before.Status + " -> " + after.Status
+  case after.Comments > before.Comments:
+    return eventCommented, ""
+  case before.Updated != after.Updated:
+    return eventUpdated, ""
+  }
+  return "", ""
+}
+
+// the time from creation to resolution, zero if either can't be parsed
+func timeToResolution(fields map[string]interface{}) time.Duration {
+  created, err := time.Parse(dateLayout, fieldString(fields, "created"))
+  if err != nil {
+    return 0
+  }
+  resolved, err := time.Parse(dateLayout, fieldString(fields, "resolutiondate"))
+  if err != nil {
+    return 0
+  }
+  return resolved.Sub(created)
+}
+
+// read the issue keys from a watch-list file, one per line. blank lines and
+// lines starting with # are ignored
+func readWatchlist(path string) ([]string, error) {
+  file, err := os.Open(path)
+  if err != nil {
+    return nil, err
+  }
+  defer file.Close()
+
+  keys := []string{}
+  scanner := bufio.NewScanner(file)
+  for scanner.Scan() {
+    line := strings.TrimSpace(scanner.Text())
+    if len(line) == 0 || strings.HasPrefix(line, "#") {
+      continue
+    }
+    keys = append(keys, strings.ToUpper(line))
+  }
+  return keys, scanner.Err()
+}
+
+// follow the issues on the watch-list, the subscribed issues and the issues
+// rules have matched, emitting an event whenever one of them changes
+func watchIssues(path string, rules []*Rule, creds *Config, c chan []*Event) {
+  var modTime time.Time
+  keys := []string{}
+  rulesByName := map[string]*Rule{}
+  for _, rule := range rules {
+    rulesByName[rule.Name] = rule
+  }
+
+  trigger := newPollTrigger()
+  var lastPoll time.Time
+  for {
+    // polled less often near the instance's budget, like the rules
+    next := quota.stretch(creds.Url, time.Now().Add(waitIntervalSecs*time.Second))
+    waitForPoll(trigger, time.Until(next))
+    if skipForQuota(creds.Url) {
+      continue
+    }
+    producers.RLock()
+    started := time.Now()
+
+    // reload the watch-list whenever the file changes
+    if len(path) > 0 {
+      info, err := os.Stat(path)
+      if err != nil {
+        logger.Print("Error reading watch-list: ", err)
+      } else if !info.ModTime().Equal(modTime) {
+        newKeys, err := readWatchlist(path)
+        if err != nil {
+          logger.Print("Error reading watch-list: ", err)
+        } else {
+          modTime = info.ModTime()
+          keys = newKeys
+          logger.Print("Loaded ", len(keys), " issues from ", path)
+        }
+      }
+    }
+
+    // with sharding the leader watches the list and the subscriptions, and
+    // each rule's owner the issues it matched
+    tracked := state.TrackedRules()
+    watched := map[string]bool{}
+    if shards.leading() {
+      for _, key := range keys {
+        watched[key] = true
+      }
+      for _, key := range state.SubscribedKeys() {
+        watched[key] = true
+      }
+    }
+    for key, rule := range tracked {
+      if shards.follows(rulesByName, rule) {
+        watched[key] = true
+      }
+    }
+
+    sorted := []string{}
+    for key := range watched {
+      sorted = append(sorted, key)
+    }
+    sort.Strings(sorted)
+
+    events, ok := pollWatchedIssues(sorted, lastPoll, creds)
+    if ok {
+      lastPoll = started // or the next poll looks back to this one's start too
+    }
+    for _, event := range events {
+      key := event.Issue.Key
+      targets := state.Subscribers(key)
+      if rule, ok := rulesByName[tracked[key]]; ok {
+        event.Rule = rule.Name
+        event.chain = rule.chain()
+        targets = addTargets(rule.targetsFor(event.Kind), targets)
+      }
+      event.Targets = targets
+      event.leaveTargetsToChain()
+      c <- []*Event{event}
+    }
+
+    // forget issues that were taken off the list
+    state.ForgetUnless(watched)
+    producers.RUnlock()
+  }
+}
+
+// search for the watched issues updated since the last poll, a batch of
+// keys at a time, and compare each to what we saw last time. issues never
+// seen before are fetched whatever their update, so they can be recorded.
+// ok is false if a search failed
+func pollWatchedIssues(keys []string, since time.Time, creds *Config) (events []*Event, ok bool) {
+  fresh, seen := []string{}, []string{}
+  for _, key := range keys {
+    before, known := state.Snapshot(key)
+    if !known {
+      fresh = append(fresh, key)
+      continue
+    }
+    if resolved, err := time.Parse(dateLayout, before.Resolved); err == nil && time.Since(resolved) > reopenWindow {
+      state.Untrack(key)
+    }
+    seen = append(seen, key)
+  }
+  // jql dates are in the jira user's timezone, so relative minutes are used
+  // and a minute of overlap is left, the snapshots telling what's new
+  updated := ""
+  if !since.IsZero() {
+    updated = fmt.Sprintf(" AND updated >= -%dm", int(time.Since(since).Minutes())+1)
+  }
+  events, ok = []*Event{}, true
+  groups := []struct {
+    keys   []string
+    filter string
+  }{{fresh, ""}, {seen, updated}}
+  for _, group := range groups {
+    batch, filter := group.keys, group.filter
+    for start := 0; start < len(batch); start += watchBatch {
+      end := start + watchBatch
+      if end > len(batch) {
+        end = len(batch)
+      }
+      quoted := []string{}
+      for _, key := range batch[start:end] {
+        quoted = append(quoted, jqlQuote(key))
+      }
+      issues, err := searchWatched("key in ("+strings.Join(quoted, ", ")+")"+filter, creds)
+      if err != nil {
+        logger.Print("Error polling watched issues: ", err)
+        ok = false
+        continue
+      }
+      for _, contents := range issues {
+        if event := watchedChange(contents); event != nil {
+          events = append(events, event)
+        }
+      }
+    }
+  }
+  return events, ok
+}
+
+// every page of a search of watched issues, with all their fields for the
+// snapshots. keys that don't exist or aren't visible are only warned about
+// by jira, so they don't fail the rest of the batch
+func searchWatched(jql string, creds *Config) ([]json.RawMessage, error) {
+  issues := []json.RawMessage{}
+  for {
+    uri := fmt.Sprintf("/search?jql=%s&startAt=%d&maxResults=%d&fields=*all&validateQuery=warn",
+      url.QueryEscape(jql), len(issues), creds.pageSize())
+    contents, err := jiraRequest("GET", uri, nil, creds)
+    if err != nil {
+      return nil, err
+    }
+    var list rawIssueList
+    if err := json.Unmarshal(contents, &list); err != nil {
+      return nil, err
+    }
+    issues = append(issues, list.Issues...)
+    if len(list.Issues) == 0 || len(issues) >= list.Total {
+      return issues, nil
+    }
+  }
+}
+
+// compare a watched issue to what we saw last time. the first time an issue
+// is seen it is only recorded so adding a key doesn't fire
+func watchedChange(contents []byte) *Event {
+  issue, fields, err := parseIssue(contents)
+  if err != nil {
+    logger.Print("Error parsing a watched issue: ", err)
+    return nil
+  }
+  key := issue.Key
+
+  after := snapshotOf(fields)
+  before, seen := state.Snapshot(key)
+  state.SetSnapshot(key, after)
+  if resolved, err := time.Parse(dateLayout, after.Resolved); err == nil && time.Since(resolved) > reopenWindow {
+    state.Untrack(key) // resolved long enough ago to stop following it
+  }
+  if !seen {
+    return nil
+  }
+
+  kind, detail := snapshotChange(before, after)
+  if len(kind) == 0 {
+    return nil
+  }
+  event := newEvent(kind, issue, fields)
+  event.Detail = detail
+  if kind == eventResolved {
+    event.TimeToResolution = timeToResolution(fields)
+  }
+  return event
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+  "fmt"
+  "os"
+  "strings"
+  "sync"
+  "time"
+)
+
+const (
+  eventWatermark        = "watermark"
+  eventWatermarkCleared = "watermark-cleared"
+)
+
+// alerts on how many issues a search matches rather than on the issues
+// themselves, configured under `watermarks`. the count is checked every
+// interval and the alert fires once it has been over (or under) the mark
+// for the whole of `for`, then again when it's back
+//
+//   watermarks:
+//     - name: blockers
+//       jql: project = PROJ AND priority = Blocker AND resolution = Unresolved
+//       above: 25
+//     - name: unassigned-queue
+//       jql: project = OPS AND assignee IS EMPTY AND resolution = Unresolved
+//       above: 10
+//       for: 30m
+//       interval: 5m      # 1m by default
+//       targets: [ops-pager] # the operator's by default
+type Watermark struct {
+  Name     string   `yaml:"name"`
+  Jql      string   `yaml:"jql"`
+  Above    int      `yaml:"above"` // fires with more issues than this
+  Below    int      `yaml:"below"` // or with fewer
+  For      string   `yaml:"for"`
+  Interval string   `yaml:"interval"`
+  Targets  []string `yaml:"targets"`
+
+  sustain  time.Duration
+  interval time.Duration
+}
+
+// how a watermark stands, since the tracker started
+type watermarkStatus struct {
+  count    int
+  breached time.Time // when the count went past the mark, zero if it isn't
+  firing   bool
+}
+
+var watermarks = struct {
+  sync.Mutex
+  list   []*Watermark
+  status map[string]*watermarkStatus
+}{status: map[string]*watermarkStatus{}}
+
+func loadWatermarks(creds *Config) {
+  seen := map[string]bool{}
+  for i := range creds.Watermarks {
+    w := &creds.Watermarks[i]
+    switch {
+    case len(w.Name) == 0 || len(w.Jql) == 0:
+      logger.Print("Every watermark needs a name and jql")
+      os.Exit(1)
+    case seen[w.Name]:
+      logger.Print("There is more than one watermark named ", w.Name)
+      os.Exit(1)
+    case w.Above <= 0 && w.Below <= 0:
+      logger.Print("Watermark ", w.Name, " needs above or below")
+      os.Exit(1)
+    }
+    seen[w.Name] = true
+    w.sustain = durationOr(w.For, 0)
+    w.interval = durationOr(w.Interval, time.Minute)
+    watermarks.list = append(watermarks.list, w)
+    watermarks.status[w.Name] = &watermarkStatus{}
+  }
+}
+
+func (w *Watermark) breachedBy(count int) bool {
+  return (w.Above > 0 && count > w.Above) || (w.Below > 0 && count < w.Below)
+}
+
+func (w *Watermark) mark() string {
+  if w.Above > 0 && w.Below > 0 {
+    return fmt.Sprintf("between %d and %d", w.Below, w.Above)
+  }
+  if w.Above > 0 {
+    return fmt.Sprintf("at most %d", w.Above)
+  }
+  return fmt.Sprintf("at least %d", w.Below)
+}
+
+func (w *Watermark) alert(kind, summary string, creds *Config) {
+  logger.Print("WATERMARK: ", summary)
+  event := trackerEvent(kind, summary)
+  event.Detail = w.Jql
+  event.Targets = w.Targets
+  if len(event.Targets) == 0 {
+    event.Targets = creds.Operator.Targets
+  }
+  deliver([]*Event{event})
+}
+
+// count once, alerting if the watermark started or stopped firing
+func (w *Watermark) check(creds *Config) {
+  count, err := countIssues(w.Jql, creds)
+  if err != nil {
+    logger.Print("Error counting the issues for watermark ", w.Name, ": ", err)
+    return // the last count stands
+  }
+  now := time.Now()
+  watermarks.Lock()
+  status := watermarks.status[w.Name]
+  status.count = count
+  fire, clear := false, false
+  switch {
+  case !w.breachedBy(count):
+    clear = status.firing
+    status.breached, status.firing = time.Time{}, false
+  case status.breached.IsZero():
+    status.breached = now
+    fallthrough
+  default:
+    if !status.firing && now.Sub(status.breached) >= w.sustain {
+      status.firing, fire = true, true
+    }
+  }
+  since := status.breached
+  watermarks.Unlock()
+
+  if fire {
+    summary := fmt.Sprintf("%s: %d issues, should be %s", w.Name, count, w.mark())
+    if w.sustain > 0 {
+      summary += fmt.Sprintf(", since %s", since.Format("15:04"))
+    }
+    w.alert(eventWatermark, summary, creds)
+  }
+  if clear {
+    w.alert(eventWatermarkCleared, fmt.Sprintf("%s: back to %d issues", w.Name, count), creds)
+  }
+}
+
+func watchWatermarks(creds *Config) {
+  for _, w := range watermarks.list {
+    go func(w *Watermark) {
+      for {
+        if shards.leading() {
+          w.check(creds)
+        }
+        time.Sleep(w.interval)
+      }
+    }(w)
+  }
+}
+
+func writeWatermarkMetrics(out *strings.Builder) {
+  if len(watermarks.list) == 0 {
+    return
+  }
+  watermarks.Lock()
+  defer watermarks.Unlock()
+  out.WriteString("# HELP jira_tracker_watermark_issues The issues a watermark's search matched when last counted.\n")
+  out.WriteString("# TYPE jira_tracker_watermark_issues gauge\n")
+  for _, w := range watermarks.list {
+    fmt.Fprintf(out, "jira_tracker_watermark_issues{watermark=%q} %d\n", w.Name, watermarks.status[w.Name].count)
+  }
+  out.WriteString("# HELP jira_tracker_watermark_firing Whether a watermark's alert is firing.\n")
+  out.WriteString("# TYPE jira_tracker_watermark_firing gauge\n")
+  for _, w := range watermarks.list {
+    firing := 0
+    if watermarks.status[w.Name].firing {
+      firing = 1
+    }
+    fmt.Fprintf(out, "jira_tracker_watermark_firing{watermark=%q} %d\n", w.Name, firing)
+  }
+}
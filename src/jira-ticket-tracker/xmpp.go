@@ -0,0 +1,248 @@
+package main
+
+import (
+  "bytes"
+  "crypto/tls"
+  "encoding/base64"
+  "encoding/xml"
+  "fmt"
+  "net"
+  "strings"
+  "time"
+)
+
+// posts each message to an xmpp multi-user chat room. it connects with
+// starttls, logs in with sasl plain, joins the room and posts, once per send
+// like email does
+//
+//   targets:
+//     ops-xmpp:
+//       type: xmpp
+//       xmpp:
+//         jid: tracker@chat.acme.com
+//         password: ...
+//         server: chat.acme.com:5222  # the jid's domain by default
+//         room: ops@conference.chat.acme.com
+//         nick: tracker               # the jid's user by default
+//
+// the password is only ever sent over tls, so a server that doesn't offer
+// starttls is refused
+type XMPPConfig struct {
+  JID      string `yaml:"jid"`
+  Password string `yaml:"password"`
+  Server   string `yaml:"server"`
+  Room     string `yaml:"room"`
+  Nick     string `yaml:"nick"`
+}
+
+const xmppTimeout = 30 * time.Second
+
+type xmppNotifier struct {
+  config    XMPPConfig
+  transport *targetTransport
+}
+
+// an element from the stream, with what's inside it left as xml
+type xmppElement struct {
+  XMLName xml.Name
+  Attrs   []xml.Attr `xml:",any,attr"`
+  Inner   []byte     `xml:",innerxml"`
+}
+
+func (e *xmppElement) attr(name string) string {
+  for _, a := range e.Attrs {
+    if a.Name.Local == name {
+      return a.Value
+    }
+  }
+  return ""
+}
+
+func newXMPPNotifier(name string, target Target) (*xmppNotifier, error) {
+  config := target.XMPP
+  at := strings.Index(config.JID, "@")
+  if at <= 0 || len(config.Password) == 0 || len(config.Room) == 0 {
+    return nil, fmt.Errorf("an xmpp target needs a jid like user@domain, a password and a room")
+  }
+  if len(config.Server) == 0 {
+    config.Server = config.JID[at+1:] + ":5222"
+  }
+  if len(config.Nick) == 0 {
+    config.Nick = config.JID[:at]
+  }
+  return &xmppNotifier{config: config, transport: newTargetTransport(name, target)}, nil
+}
+
+func (n *xmppNotifier) Notify(event *Event, message string) error {
+  return n.send(message)
+}
+
+func (n *xmppNotifier) NotifyBatch(events []*Event, messages []string) error {
+  return n.send(fmt.Sprintf("%d issues:\n• %s", len(events), strings.Join(messages, "\n• ")))
+}
+
+// an xmpp session, for one send
+type xmppSession struct {
+  conn    net.Conn
+  decoder *xml.Decoder
+  domain  string
+}
+
+func (s *xmppSession) write(format string, args ...interface{}) error {
+  _, err := fmt.Fprintf(s.conn, format, args...)
+  return err
+}
+
+// open a new stream, as is done again after tls and after logging in
+func (s *xmppSession) open() error {
+  s.decoder = xml.NewDecoder(s.conn)
+  return s.write(`<?xml version='1.0'?><stream:stream to='%s' xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>`, xmlEscape(s.domain))
+}
+
+// the next element from the server, skipping the stream's own opening tag
+func (s *xmppSession) next() (*xmppElement, error) {
+  for {
+    token, err := s.decoder.Token()
+    if err != nil {
+      return nil, err
+    }
+    start, ok := token.(xml.StartElement)
+    if !ok || start.Name.Local == "stream" {
+      continue
+    }
+    e := &xmppElement{}
+    if err := s.decoder.DecodeElement(e, &start); err != nil {
+      return nil, err
+    }
+    if e.XMLName.Local == "error" {
+      return nil, fmt.Errorf("xmpp stream error: %s", innerText(e.Inner))
+    }
+    return e, nil
+  }
+}
+
+// the next element, which has to be the one named
+func (s *xmppSession) expect(name string) (*xmppElement, error) {
+  e, err := s.next()
+  if err != nil {
+    return nil, err
+  }
+  if e.XMLName.Local != name {
+    return nil, fmt.Errorf("expected <%s> from the xmpp server, got <%s>: %s", name, e.XMLName.Local, innerText(e.Inner))
+  }
+  return e, nil
+}
+
+func (n *xmppNotifier) send(message string) error {
+  config := n.config
+  conn, err := n.transport.dial(config.Server)
+  if err != nil {
+    return err
+  }
+  defer conn.Close()
+  conn.SetDeadline(time.Now().Add(xmppTimeout))
+  at := strings.Index(config.JID, "@")
+  s := &xmppSession{conn: conn, domain: config.JID[at+1:]}
+
+  if err := s.open(); err != nil {
+    return err
+  }
+  features, err := s.expect("features")
+  if err != nil {
+    return err
+  }
+  if !bytes.Contains(features.Inner, []byte("<starttls")) {
+    return fmt.Errorf("%s doesn't offer starttls, not sending the password in the clear", config.Server)
+  }
+  if err := s.write(`<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>`); err != nil {
+    return err
+  }
+  if _, err := s.expect("proceed"); err != nil {
+    return err
+  }
+  host := strings.Split(config.Server, ":")[0]
+  tlsConn := tls.Client(conn, n.transport.tlsConfig(host))
+  if err := tlsConn.Handshake(); err != nil {
+    return err
+  }
+  s.conn = tlsConn
+  if err := s.open(); err != nil {
+    return err
+  }
+  if features, err = s.expect("features"); err != nil {
+    return err
+  }
+  if !bytes.Contains(features.Inner, []byte(">PLAIN<")) {
+    return fmt.Errorf("%s doesn't offer plain authentication", config.Server)
+  }
+  credentials := base64.StdEncoding.EncodeToString([]byte("\x00" + config.JID[:at] + "\x00" + config.Password))
+  if err := s.write(`<auth xmlns='urn:ietf:params:xml:ns:xmpp-sasl' mechanism='PLAIN'>%s</auth>`, credentials); err != nil {
+    return err
+  }
+  if _, err := s.expect("success"); err != nil {
+    return fmt.Errorf("logging in to xmpp: %v", err)
+  }
+
+  if err := s.open(); err != nil {
+    return err
+  }
+  if _, err := s.expect("features"); err != nil {
+    return err
+  }
+  if err := s.write(`<iq type='set' id='bind'><bind xmlns='urn:ietf:params:xml:ns:xmpp-bind'><resource>jira-ticket-tracker</resource></bind></iq>`); err != nil {
+    return err
+  }
+  if iq, err := s.expect("iq"); err != nil || iq.attr("type") != "result" {
+    return fmt.Errorf("binding an xmpp resource failed: %v", err)
+  }
+
+  // joining, without the room's history, and waiting to see ourselves in it
+  occupant := config.Room + "/" + config.Nick
+  if err := s.write(`<presence to='%s'><x xmlns='http://jabber.org/protocol/muc'><history maxstanzas='0'/></x></presence>`, xmlEscape(occupant)); err != nil {
+    return err
+  }
+  for {
+    e, err := s.next()
+    if err != nil {
+      return err
+    }
+    if e.XMLName.Local != "presence" || !strings.EqualFold(e.attr("from"), occupant) {
+      continue
+    }
+    if e.attr("type") == "error" {
+      return fmt.Errorf("joining %s failed: %s", config.Room, innerText(e.Inner))
+    }
+    break
+  }
+  if err := s.write(`<message to='%s' type='groupchat'><body>%s</body></message>`, xmlEscape(config.Room), xmlEscape(message)); err != nil {
+    return err
+  }
+  return s.write(`<presence to='%s' type='unavailable'/></stream:stream>`, xmlEscape(occupant))
+}
+
+func xmlEscape(s string) string {
+  var out bytes.Buffer
+  xml.EscapeText(&out, []byte(s))
+  return out.String()
+}
+
+// the text in some xml, for error messages
+func innerText(inner []byte) string {
+  decoder := xml.NewDecoder(bytes.NewReader(inner))
+  text := []string{}
+  for {
+    token, err := decoder.Token()
+    if err != nil {
+      break
+    }
+    switch t := token.(type) {
+    case xml.CharData:
+      if s := strings.TrimSpace(string(t)); len(s) > 0 {
+        text = append(text, s)
+      }
+    case xml.StartElement:
+      text = append(text, t.Name.Local)
+    }
+  }
+  return strings.Join(text, " ")
+}